@@ -0,0 +1,152 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/token"
+)
+
+// DiscriminatorAttr returns an attribute describing the discriminator
+// for the disjunction v, for use by tools that consume CUE syntax,
+// such as the encoding/openapi and encoding/gocode exporters.
+//
+// When the root of the decision tree switches on the value of
+// a field, the attribute holds its path, for example
+// @discriminator(kind). When it switches on the kind of a field,
+// the attribute also has a "kind" argument, for example
+// @discriminator(meta.type,kind). Likewise, a switch on the
+// numeric range of a field has a "range" argument, for example
+// @discriminator(size,range), and a switch on the patterns that
+// a string field matches has a "regexp" argument, for example
+// @discriminator(id,regexp), and a switch on the length of a list
+// has a "len" argument, for example @discriminator(items,len).
+// The path "." refers to v itself.
+//
+// It reports false if the discriminator is not perfect or
+// the tree does not start by switching on a field.
+func DiscriminatorAttr(v cue.Value, opts ...Option) (*ast.Attribute, bool) {
+	arms := Disjunctions(v)
+	if len(arms) < 2 {
+		return nil, false
+	}
+	tree, _, perfect := Discriminate(arms, opts...)
+	if !perfect {
+		return nil, false
+	}
+	for {
+		n, ok := tree.(*OptionalNode)
+		if !ok {
+			break
+		}
+		tree = n.Present
+	}
+	switch n := tree.(type) {
+	case *ValueSwitchNode:
+		return &ast.Attribute{
+			Text: fmt.Sprintf("@discriminator(%s)", n.Path),
+		}, true
+	case *KindSwitchNode:
+		return &ast.Attribute{
+			Text: fmt.Sprintf("@discriminator(%s,kind)", n.Path),
+		}, true
+	case *RangeSwitchNode:
+		return &ast.Attribute{
+			Text: fmt.Sprintf("@discriminator(%s,range)", n.Path),
+		}, true
+	case *RegexSwitchNode:
+		return &ast.Attribute{
+			Text: fmt.Sprintf("@discriminator(%s,regexp)", n.Path),
+		}, true
+	case *LenSwitchNode:
+		return &ast.Attribute{
+			Text: fmt.Sprintf("@discriminator(%s,len)", n.Path),
+		}, true
+	}
+	return nil, false
+}
+
+// AnnotateDiscriminators adds a @discriminator attribute, as returned by
+// [DiscriminatorAttr], to each field in n whose value in v is a
+// disjunction with a perfect discriminator. The syntax n should have
+// been obtained by calling v.Syntax, so that exporters working from n
+// see the attributes. Fields that already have a @discriminator
+// attribute are left alone.
+//
+// Fields inside nested struct literals are annotated too.
+// Values of optional fields are analyzed with [Optional](true).
+func AnnotateDiscriminators(n ast.Node, v cue.Value, opts ...Option) {
+	switch n := n.(type) {
+	case *ast.File:
+		annotateDecls(n.Decls, v, opts)
+	case *ast.StructLit:
+		annotateDecls(n.Elts, v, opts)
+	}
+}
+
+func annotateDecls(decls []ast.Decl, v cue.Value, opts []Option) {
+	for _, decl := range decls {
+		f, ok := decl.(*ast.Field)
+		if !ok {
+			continue
+		}
+		name, ok := astLabelName(f.Label)
+		if !ok {
+			continue
+		}
+		fv := lookupField(v, name)
+		if !fv.Exists() {
+			continue
+		}
+		if !hasAttr(f, "discriminator") {
+			fopts := opts
+			if f.Constraint == token.OPTION {
+				fopts = append(opts[:len(opts):len(opts)], Optional(true))
+			}
+			if attr, ok := DiscriminatorAttr(fv, fopts...); ok {
+				f.Attrs = append(f.Attrs, attr)
+			}
+		}
+		AnnotateDiscriminators(f.Value, fv, opts...)
+	}
+}
+
+// astLabelName returns the selector name, as returned by selectorName,
+// for the given label. It reports false if the label is not a
+// regular, definition or hidden label.
+func astLabelName(l ast.Label) (string, bool) {
+	name, isIdent, err := ast.LabelName(l)
+	if err != nil {
+		return "", false
+	}
+	if isIdent && (strings.HasPrefix(name, "#") || strings.HasPrefix(name, "_")) {
+		return name, true
+	}
+	return selectorName(cue.Str(name)), true
+}
+
+// lookupField is like lookupSelector except that
+// it also finds optional fields.
+func lookupField(v cue.Value, name string) cue.Value {
+	iter, err := v.Fields(cue.All())
+	if err != nil {
+		return cue.Value{}
+	}
+	for iter.Next() {
+		if selectorName(iter.Selector()) == name {
+			return iter.Value()
+		}
+	}
+	return cue.Value{}
+}
+
+func hasAttr(f *ast.Field, key string) bool {
+	for _, a := range f.Attrs {
+		if k, _ := a.Split(); k == key {
+			return true
+		}
+	}
+	return false
+}
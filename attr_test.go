@@ -0,0 +1,57 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/format"
+	"github.com/go-quicktest/qt"
+)
+
+func TestAnnotateDiscriminators(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`
+#A: {kind!: "a", x!: int} | {kind!: "b", y!: string}
+b?: {kind!: "a"} | {kind!: "b"}
+"c.d": {
+	e: int | string
+}
+f: {a?: int} | {b?: int}
+g: {kind!: "a"} | {kind!: "b"} @discriminator(other)
+`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	n := v.Syntax(cue.Definitions(true), cue.Optional(true), cue.Attributes(true), cue.Raw())
+	AnnotateDiscriminators(n, v)
+	data, err := format.Node(n)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), strings.TrimPrefix(`
+{
+	#A: {
+		kind!: "a"
+		x!:    int
+	} | {
+		kind!: "b"
+		y!:    string
+	} @discriminator(kind)
+	b?: {
+		kind!: "a"
+	} | {
+		kind!: "b"
+	} @discriminator(kind)
+	"c.d": {
+		e: int | string @discriminator(.,kind)
+	}
+	f: {
+		a?: int
+	} | {
+		b?: int
+	}
+	g: {
+		kind!: "a"
+	} | {
+		kind!: "b"
+	} @discriminator(other)
+}`, "\n")))
+}
@@ -0,0 +1,223 @@
+package cuediscrim
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"sync"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/format"
+)
+
+// Cache holds analysis results on disk, keyed by a hash of the
+// content of the arms analyzed and the options that affect the
+// analysis, so that repeated runs over unchanged schemas, as in
+// CI, don't need to analyze them again.
+//
+// A Cache is safe for concurrent use, including by several
+// processes sharing the same directory.
+type Cache struct {
+	dir string
+}
+
+// NewCache returns a cache that keeps its entries in the given
+// directory, which is created if needed when the first entry
+// is written.
+func NewCache(dir string) *Cache {
+	return &Cache{
+		dir: dir,
+	}
+}
+
+// Analyze is like [Analyze] except that it returns the result from
+// the cache when there is one, and stores the result in the cache
+// otherwise. The Log field of a cached result is empty, so when
+// [LogTo] or [CaptureLog] is specified, the analysis is always done
+// and only its result is stored.
+//
+// The cache is only an optimization, so errors reading or
+// writing it are ignored and the analysis is done as usual.
+//
+// Note that the key includes the version of this module, or its
+// VCS revision in a development build, but not uncommitted changes
+// to it, so the cache should be cleared when working on the
+// module itself.
+func (c *Cache) Analyze(arms []cue.Value, opts ...Option) *Result {
+	var o options
+	for _, f := range opts {
+		f(&o)
+	}
+	key, err := cacheKey(arms, o)
+	if err != nil {
+		return Analyze(arms, opts...)
+	}
+	if o.logger == nil && o.captureLog <= 0 {
+		if r, err := c.get(key); err == nil {
+			r.Arms = arms
+			r.Warnings = resultWarnings(r.Tree, arms, o)
+			return r
+		}
+	}
+	r := Analyze(arms, opts...)
+	c.put(key, r) // Ignore error.
+	return r
+}
+
+// cacheEntry holds the JSON form of a cached [Result].
+type cacheEntry struct {
+	Tree    Tree    `json:"tree"`
+	Groups  [][]int `json:"groups,omitempty"`
+	Perfect bool    `json:"perfect"`
+}
+
+func (c *Cache) get(key string) (*Result, error) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, err
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	if e.Tree.DecisionNode == nil {
+		return nil, fmt.Errorf("cache entry has no tree")
+	}
+	groups := make([]IntSet, len(e.Groups))
+	for i, g := range e.Groups {
+		groups[i] = mapSetOf(slices.Values(g))
+	}
+	return &Result{
+		Tree:    e.Tree.DecisionNode,
+		Groups:  groups,
+		Perfect: e.Perfect,
+	}, nil
+}
+
+func (c *Cache) put(key string, r *Result) error {
+	e := cacheEntry{
+		Tree:    Tree{r.Tree},
+		Perfect: r.Perfect,
+	}
+	for _, g := range r.Groups {
+		e.Groups = append(e.Groups, sortedInts(g))
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0o777); err != nil {
+		return err
+	}
+	// Write to a temporary file first so that concurrent
+	// readers never see a partially written entry.
+	f, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// cacheKey returns the key for the analysis of arms with the given
+// options. It covers the content of the arms, including any
+// definitions they refer to, whether each is deprecated and
+// which of its structs are closed.
+func cacheKey(arms []cue.Value, o options) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "cuediscrim %s\ntree %d\n", moduleVersion(), TreeVersion)
+	fmt.Fprintf(h, "options %v %v %v %d %q %d %v %d %v %v %d %v %d %q %q %v %d %v\n",
+		o.mergeCompatible,
+		o.ignoreDeprecated,
+		o.optional,
+		o.tieBreak,
+		o.preferFields,
+		o.eval,
+		o.preserveGroups,
+		o.maxValueBranches,
+		o.chooseEnums,
+		o.enumStrategy,
+		o.enumThreshold,
+		o.closedWorld,
+		o.maxDepth,
+		o.includePaths,
+		o.excludePaths,
+		o.armWeights,
+		o.topArms,
+		o.optionalFields,
+	)
+	for _, arm := range arms {
+		n := arm.Syntax(
+			cue.Definitions(true),
+			cue.Hidden(true),
+			cue.Optional(true),
+			cue.Attributes(true),
+			cue.InlineImports(true),
+		)
+		data, err := format.Node(n)
+		if err != nil {
+			return "", fmt.Errorf("cannot format arm: %v", err)
+		}
+		fmt.Fprintf(h, "arm %d %v\n%s\n", len(data), IsDeprecated(arm), data)
+		// The syntax doesn't show which structs are
+		// closed by a call to close, so add them too.
+		closed := func(path string, v cue.Value) {
+			if v.IncompleteKind()&cue.StructKind != 0 && !v.Allows(cue.AnyString) {
+				fmt.Fprintf(h, "closed %s\n", path)
+			}
+		}
+		closed(".", arm)
+		for path, vals := range allFields([]cue.Value{arm}, intSetN(1), requiredLabel|optionalLabel|regularLabel) {
+			closed(path, vals[0])
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// moduleVersion returns the version of this module in the
+// running binary, with the VCS revision when it's the main module
+// so that development builds don't share entries across commits.
+var moduleVersion = sync.OnceValue(func() string {
+	const modulePath = "github.com/rogpeppe/cuediscrim"
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if info.Main.Path == modulePath {
+		v := info.Main.Version
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" || s.Key == "vcs.modified" {
+				v += " " + s.Value
+			}
+		}
+		return v
+	}
+	for _, m := range info.Deps {
+		if m.Path != modulePath {
+			continue
+		}
+		if m.Replace != nil {
+			return m.Replace.Path + "@" + m.Replace.Version
+		}
+		return m.Version
+	}
+	return ""
+})
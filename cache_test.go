@@ -0,0 +1,70 @@
+package cuediscrim
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestCache(t *testing.T) {
+	ctx := cuecontext.New()
+	dir := t.TempDir()
+	c := NewCache(dir)
+	entries := func() []string {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		return files
+	}
+	for _, test := range buildDecisionTreeTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := ctx.CompileString(test.cue)
+			arms := Disjunctions(v)
+			want := Analyze(arms)
+			r := c.Analyze(arms)
+			qt.Check(t, qt.Equals(NodeString(r.Tree), NodeString(want.Tree)))
+			n := len(entries())
+
+			// The second analysis comes from the cache.
+			r = c.Analyze(arms)
+			qt.Check(t, qt.Equals(NodeString(r.Tree), NodeString(want.Tree)))
+			qt.Check(t, qt.Equals(r.Perfect, want.Perfect))
+			qt.Check(t, qt.DeepEquals(r.Warnings, want.Warnings))
+			qt.Check(t, qt.HasLen(r.Arms, len(arms)))
+			qt.Check(t, qt.HasLen(entries(), n))
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	ctx := cuecontext.New()
+	dir := t.TempDir()
+	c := NewCache(dir)
+	arms := Disjunctions(ctx.CompileString(`{a!: "x"} | {a!: "y"}`))
+	c.Analyze(arms)
+	c.Analyze(arms)
+	c.Analyze(arms, MaxValueBranches(1))
+	// The content of the arms forms part of the key,
+	// even when it comes from a reference.
+	c.Analyze(Disjunctions(ctx.CompileString(`{a!: "x"} | {a!: "z"}`)))
+	c.Analyze(Disjunctions(ctx.CompileString(`#X: "x", {a!: #X} | {a!: "y"}`)))
+	c.Analyze(Disjunctions(ctx.CompileString(`#X: "z", {a!: #X} | {a!: "y"}`)))
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.HasLen(files, 5))
+}
+
+func TestCacheInvalidEntry(t *testing.T) {
+	ctx := cuecontext.New()
+	dir := t.TempDir()
+	c := NewCache(dir)
+	arms := Disjunctions(ctx.CompileString(`{a!: "x"} | {a!: "y"}`))
+	want := NodeString(c.Analyze(arms).Tree)
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.HasLen(files, 1))
+	err = os.WriteFile(files[0], []byte("{"), 0o666)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(NodeString(c.Analyze(arms).Tree), want))
+}
@@ -0,0 +1,113 @@
+package cuediscrim
+
+import (
+	"cuelang.org/go/cue"
+)
+
+// CheckOption configures the behavior of [CheckValue].
+type CheckOption func(*checkOptions)
+
+type checkOptions struct {
+	allowIncomplete bool
+	injectDefaults  bool
+
+	// leaves, if not nil, collects the leaves reached
+	// by the check, in the order they are reached.
+	leaves *[]*LeafNode
+}
+
+// AllowIncomplete causes non-concrete values in the data being
+// checked (for example a field whose value is still an unresolved
+// expression or a type such as string) to be treated as unknown
+// rather than as errors. A switch on such a value chooses the union of
+// all the branches that could be reached by some concrete value
+// for it, so the result holds every arm that the data might
+// select once it is complete.
+func AllowIncomplete(enable bool) CheckOption {
+	return func(opts *checkOptions) {
+		opts.allowIncomplete = enable
+	}
+}
+
+// InjectDefaults causes a value that lacks a field switched on by a
+// [ValueSwitchNode] to be treated as if it held the value recorded in
+// the node's Implied field, which is taken from the default
+// for the field in the arms (for example kind: *"v1" | string).
+// This matches the behavior of producers that omit fields holding their
+// default value. The result of such a switch is never definite
+// (see [CheckDetail]).
+func InjectDefaults(enable bool) CheckOption {
+	return func(opts *checkOptions) {
+		opts.injectDefaults = enable
+	}
+}
+
+// CheckValue is like n.Check(v) but allows the
+// checking behavior to be configured.
+func CheckValue(n DecisionNode, v cue.Value, opts ...CheckOption) IntSet {
+	var copts checkOptions
+	for _, f := range opts {
+		f(&copts)
+	}
+	s, _ := n.check(v, copts)
+	return s
+}
+
+// CheckResult holds the result of [CheckDetail]. Every arm
+// that the tree can choose (see [DecisionNode.Possible]) is in exactly
+// one of its sets.
+type CheckResult struct {
+	// Selected holds the arm that the value has been proven
+	// to match by positive tests on its contents, such as a
+	// switch on a tag field. It holds at most one arm.
+	Selected IntSet
+
+	// Possible holds the arms that the value might match
+	// but which could not be proven. This happens when
+	// several arms are indistinguishable, when
+	// [AllowIncomplete] is in effect and the value is
+	// not yet concrete, or when arms were chosen by
+	// elimination: a [FieldAbsenceNode] can rule arms out
+	// but, because extra fields are usually allowed, cannot
+	// prove that the remaining arm matches.
+	Possible IntSet
+
+	// Excluded holds the arms that the value cannot match.
+	Excluded IntSet
+}
+
+// CheckDetail is like [CheckValue] but distinguishes arms that
+// have been proven to match from those that are merely possible.
+func CheckDetail(n DecisionNode, v cue.Value, opts ...CheckOption) CheckResult {
+	var copts checkOptions
+	for _, f := range opts {
+		f(&copts)
+	}
+	s, definite := n.check(v, copts)
+	r := CheckResult{
+		Selected: wordSet(0),
+		Possible: s,
+		Excluded: without(n.Possible(), s),
+	}
+	if definite && s.Len() == 1 {
+		r.Selected, r.Possible = s, wordSet(0)
+	}
+	return r
+}
+
+// isUnknown reports whether f should be treated as
+// having an unknown value.
+func (opts checkOptions) isUnknown(f cue.Value) bool {
+	return opts.allowIncomplete && f.Exists() && !f.IsConcrete()
+}
+
+// unknownKind returns the kinds that the unknown
+// value f might take on.
+func unknownKind(f cue.Value) cue.Kind {
+	if k := f.IncompleteKind(); k != cue.BottomKind {
+		return k
+	}
+	// An incomplete expression such as a + 1 where a isn't
+	// yet known has bottom kind, but could become anything.
+	return cue.TopKind
+}
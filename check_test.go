@@ -0,0 +1,194 @@
+package cuediscrim
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var checkIncompleteTests = []struct {
+	testName string
+	cue      string
+	data     []dataTest
+}{{
+	testName: "ValueSwitch",
+	cue: `
+{type!: "a", x!: int} |
+{type!: "b"} |
+{type!: "c"}
+`,
+	data: []dataTest{{
+		name: "concrete",
+		cue:  `{type: "a"}`,
+		want: setOf(0),
+	}, {
+		name: "disjunction",
+		cue:  `{type: "a" | "b"}`,
+		want: setOf(0, 1),
+	}, {
+		name: "type",
+		cue:  `{type: string}`,
+		want: setOf(0, 1, 2),
+	}, {
+		name: "wrongType",
+		cue:  `{type: int}`,
+		want: setOf(),
+	}, {
+		name: "expression",
+		cue: `{
+			type: t + "x"
+			t: string
+		}`,
+		want: setOf(0, 1, 2),
+	}},
+}, {
+	testName: "KindSwitch",
+	cue:      `string | int | {a!: int}`,
+	data: []dataTest{{
+		name: "concrete",
+		cue:  `"foo"`,
+		want: setOf(0),
+	}, {
+		name: "number",
+		cue:  `>5`,
+		want: setOf(1),
+	}, {
+		name: "disjunction",
+		cue:  `int | string`,
+		want: setOf(0, 1),
+	}, {
+		name: "top",
+		cue:  `_`,
+		want: setOf(0, 1, 2),
+	}},
+}}
+
+func TestCheckValueAllowIncomplete(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range checkIncompleteTests {
+		t.Run(test.testName, func(t *testing.T) {
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			tree, _, _ := Discriminate(Disjunctions(val))
+			for _, dtest := range test.data {
+				t.Run(dtest.name, func(t *testing.T) {
+					data := ctx.CompileString(dtest.cue)
+					got := CheckValue(tree, data, AllowIncomplete(true))
+					qt.Assert(t, deepEquals(ref(got), ref(dtest.want)))
+				})
+			}
+		})
+	}
+}
+
+func TestCheckValueIncompleteWithoutOption(t *testing.T) {
+	ctx := cuecontext.New()
+	tree, _, _ := Discriminate(Disjunctions(ctx.CompileString(`{type!: "a"} | {type!: "b"}`)))
+	got := CheckValue(tree, ctx.CompileString(`{type: string}`))
+	qt.Assert(t, qt.Equals(got.Len(), 0))
+}
+
+var checkDetailTests = []struct {
+	testName string
+	cue      string
+	data     string
+	want     CheckResult
+}{{
+	testName: "TagSwitch",
+	cue:      `{type!: "a"} | {type!: "b"} | {type!: "c"}`,
+	data:     `{type: "b"}`,
+	want: CheckResult{
+		Selected: setOf(1),
+		Possible: setOf(),
+		Excluded: setOf(0, 2),
+	},
+}, {
+	testName: "NoMatch",
+	cue:      `{type!: "a"} | {type!: "b"}`,
+	data:     `{type: "x"}`,
+	want: CheckResult{
+		Selected: setOf(),
+		Possible: setOf(),
+		Excluded: setOf(0, 1),
+	},
+}, {
+	testName: "Elimination",
+	cue:      `{a!: int} | {b!: string} | {c!: bool}`,
+	data:     `{a: 1}`,
+	want: CheckResult{
+		Selected: setOf(),
+		Possible: setOf(0),
+		Excluded: setOf(1, 2),
+	},
+}, {
+	testName: "Indistinguishable",
+	cue:      `{b!: "x", a?: int} | {b!: "x", c?: int}`,
+	data:     `{b: "x"}`,
+	want: CheckResult{
+		Selected: setOf(),
+		Possible: setOf(0, 1),
+		Excluded: setOf(),
+	},
+}, {
+	testName: "DefaultBranch",
+	cue:      `int | "foo"`,
+	data:     `1`,
+	want: CheckResult{
+		Selected: setOf(0),
+		Possible: setOf(),
+		Excluded: setOf(1),
+	},
+}}
+
+func TestCheckDetail(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range checkDetailTests {
+		t.Run(test.testName, func(t *testing.T) {
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			tree, _, _ := Discriminate(Disjunctions(val))
+			t.Logf("tree: %s", NodeString(tree))
+			got := CheckDetail(tree, ctx.CompileString(test.data))
+			qt.Assert(t, deepEquals(got, test.want))
+		})
+	}
+}
+
+func TestCheckInjectDefaults(t *testing.T) {
+	ctx := cuecontext.New()
+	schema := ctx.CompileString(`
+{kind!: *"v1" | string, a!: int} |
+{kind!: "v2", b!: int}
+`)
+	qt.Assert(t, qt.IsNil(schema.Err()))
+	tree, _, isPerfect := Discriminate(Disjunctions(schema), Evaluate(EvalDefaults))
+	qt.Assert(t, qt.IsTrue(isPerfect))
+	qt.Assert(t, qt.Equals(NodeString(tree), `
+switch kind (absent = "v1") {
+case "v1":
+	choose({0})
+case "v2":
+	choose({1})
+default:
+	error
+}
+`[1:]))
+
+	legacy := ctx.CompileString(`{a: 1}`)
+	qt.Assert(t, qt.IsNil(legacy.Err()))
+	qt.Check(t, deepEquals(ref(CheckValue(tree, legacy)), ref[IntSet](setOf())))
+	qt.Check(t, deepEquals(ref(CheckValue(tree, legacy, InjectDefaults(true))), ref[IntSet](setOf(0))))
+
+	// The result is inferred rather than proven.
+	r := CheckDetail(tree, legacy, InjectDefaults(true))
+	qt.Check(t, deepEquals(r, CheckResult{
+		Selected: setOf(),
+		Possible: setOf(0),
+		Excluded: setOf(1),
+	}))
+
+	// A value with the field is unaffected.
+	v2 := ctx.CompileString(`{kind: "v2", b: 1}`)
+	qt.Check(t, deepEquals(ref(CheckValue(tree, v2, InjectDefaults(true))), ref[IntSet](setOf(1))))
+}
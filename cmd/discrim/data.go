@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/encoding/json"
+	"cuelang.org/go/encoding/yaml"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// loadData reads the data file with the given name,
+// which may be JSON, YAML or CUE depending on its extension.
+func loadData(ctx *cue.Context, filename string) (cue.Value, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return cue.Value{}, err
+	}
+	var v cue.Value
+	switch filepath.Ext(filename) {
+	case ".json":
+		expr, err := json.Extract(filename, data)
+		if err != nil {
+			return cue.Value{}, err
+		}
+		v = ctx.BuildExpr(expr)
+	case ".yaml", ".yml":
+		f, err := yaml.Extract(filename, data)
+		if err != nil {
+			return cue.Value{}, err
+		}
+		v = ctx.BuildFile(f)
+	case ".cue":
+		v = ctx.CompileBytes(data, cue.Filename(filename))
+	default:
+		return cue.Value{}, fmt.Errorf("%s: unknown data file type", filename)
+	}
+	if err := v.Err(); err != nil {
+		return cue.Value{}, err
+	}
+	return v, nil
+}
+
+// loadDocuments reads all the documents in the data file with
+// the given name. YAML files may hold several documents separated
+// by "---" lines, and JSON files may hold a stream of JSON values.
+// A CUE file always holds a single document.
+func loadDocuments(ctx *cue.Context, filename string) ([]cue.Value, error) {
+	var docs []cue.Value
+	switch filepath.Ext(filename) {
+	case ".json", ".jsonl", ".ndjson":
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		docs, err = cuediscrim.JSONDocuments(ctx, filename, data)
+		if err != nil {
+			return nil, err
+		}
+	case ".yaml", ".yml":
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		docs, err = cuediscrim.YAMLDocuments(ctx, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+	default:
+		v, err := loadData(ctx, filename)
+		if err != nil {
+			return nil, err
+		}
+		docs = []cue.Value{v}
+	}
+	return docs, nil
+}
+
+// loadDataGlob reads all the data files matching the given glob pattern.
+func loadDataGlob(ctx *cue.Context, pattern string) ([]string, []cue.Value, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("no files match %q", pattern)
+	}
+	vs := make([]cue.Value, len(files))
+	for i, f := range files {
+		vs[i], err = loadData(ctx, f)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return files, vs, nil
+}
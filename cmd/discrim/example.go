@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/load"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// runExample implements the example subcommand, which prints
+// a minimal example document for one arm of a disjunction.
+func runExample(args []string) int {
+	fs := flag.NewFlagSet("example", flag.ExitOnError)
+	path := fs.String("p", "", "`path` of the disjunction within the package, as used by cue eval -e (required)")
+	armSpec := fs.String("arm", "", "the arm to print an example for: its index, its name with or without the leading #, or the value of its discriminator field (required)")
+	asJSON := fs.Bool("json", false, "print the example as JSON rather than CUE")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: discrim example [package] -p path -arm arm\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+The example subcommand prints a minimal document that is an instance of
+the chosen arm of the disjunction at the given path, and that the
+decision tree for the disjunction classifies as that arm. It holds the
+required and regular fields of the arm, using defaults where there are
+any, so it can be used as a starting point for documentation.
+
+For example, given
+
+	#Config: backend: #S3 | #GCS
+	#S3: {type!: "s3", bucket!: string}
+	#GCS: {type!: "gcs", project!: string}
+
+either of these prints an example for #S3:
+
+	discrim example -p '#Config.backend' -arm s3
+	discrim example -p '#Config.backend' -arm S3
+`)
+		os.Exit(2)
+	}
+	// Allow the package to come before the flags, as
+	// in "discrim example ./pkg -p x -arm y".
+	fs.Parse(args)
+	var pkgArgs []string
+	for fs.NArg() > 0 {
+		pkgArgs = append(pkgArgs, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+	if *path == "" || *armSpec == "" || len(pkgArgs) > 1 {
+		fs.Usage()
+	}
+	p := cue.ParsePath(*path)
+	if err := p.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid path: %v\n", err)
+		return 1
+	}
+	ctx := cuecontext.New()
+	insts := load.Instances(pkgArgs, nil)
+	pkg := ctx.BuildInstance(insts[0])
+	if err := pkg.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot build instance: %v\n", err)
+		return 1
+	}
+	v := pkg.LookupPath(p)
+	if err := v.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot find %s: %v\n", *path, err)
+		return 1
+	}
+	arms := cuediscrim.Disjunctions(v)
+	if len(arms) < 2 {
+		fmt.Fprintf(os.Stderr, "%s is not a disjunction\n", *path)
+		return 1
+	}
+	names := cuediscrim.ArmNames(v)
+	tree, _, _ := cuediscrim.Discriminate(arms)
+	arm, err := selectArm(*armSpec, len(arms), names, tree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	x, err := cuediscrim.ArmExample(tree, arms, arm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(x, "", "\t")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot encode example: %v\n", err)
+			return 1
+		}
+		fmt.Printf("%s\n", data)
+		return 0
+	}
+	data, err := format.Node(x.Syntax(cue.Final(), cue.Concrete(true)), format.Simplify())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot format example: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%s\n", data)
+	return 0
+}
+
+// selectArm returns the index of the arm chosen by spec, which holds
+// an arm index, an arm name, with or without its leading #, or a value
+// of the field at the root of tree that selects a single arm.
+func selectArm(spec string, n int, names []string, tree cuediscrim.DecisionNode) (int, error) {
+	if i, err := strconv.Atoi(spec); err == nil {
+		if i < 0 || i >= n {
+			return 0, fmt.Errorf("arm %d out of range; there are %d arms", i, n)
+		}
+		return i, nil
+	}
+	for i, name := range names {
+		if name != "" && (name == spec || strings.EqualFold(strings.TrimPrefix(name, "#"), strings.TrimPrefix(spec, "#"))) {
+			return i, nil
+		}
+	}
+	if o, ok := tree.(*cuediscrim.OptionalNode); ok {
+		tree = o.Present
+	}
+	if vs, ok := tree.(*cuediscrim.ValueSwitchNode); ok {
+		for val, branch := range vs.Branches {
+			if val.String() != spec && val.String() != strconv.Quote(spec) {
+				continue
+			}
+			if arms := branch.Possible(); arms.Len() == 1 {
+				for arm := range arms.Values() {
+					return arm, nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("no arm matches %q", spec)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"cuelang.org/go/cue"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// fillsFlag implements flag.Value for the -fill flag.
+// It maps from path to the CUE expression to fill in there.
+type fillsFlag map[string]string
+
+func (f fillsFlag) String() string {
+	var parts []string
+	for _, path := range slices.Sorted(maps.Keys(f)) {
+		parts = append(parts, path+"="+f[path])
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f fillsFlag) Set(s string) error {
+	path, expr, ok := strings.Cut(s, "=")
+	if !ok || path == "" {
+		return fmt.Errorf("fill %q is not of the form path=expr", s)
+	}
+	f[path] = expr
+	return nil
+}
+
+// fill returns v with all the fill-ins applied.
+func (f fillsFlag) fill(ctx *cue.Context, v cue.Value) (cue.Value, error) {
+	if len(f) == 0 {
+		return v, nil
+	}
+	fills := make(map[string]any)
+	for path, expr := range f {
+		x := ctx.CompileString(expr, cue.Filename("fill "+path))
+		if err := x.Err(); err != nil {
+			return cue.Value{}, fmt.Errorf("invalid fill value for %s: %v", path, err)
+		}
+		fills[path] = x
+	}
+	return cuediscrim.Fill(v, fills)
+}
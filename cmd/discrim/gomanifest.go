@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+
+	"cuelang.org/go/cue"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// loadGoTypes reads the file named by -go-types, which maps the name
+// of each union to an object that maps its arms to Go types.
+func loadGoTypes(ctx *cue.Context, filename string) (map[string]map[string]string, error) {
+	v, err := loadData(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	var types map[string]map[string]string
+	if err := v.Decode(&types); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return types, nil
+}
+
+// walkGoTypes returns the unions defined by the definitions
+// within v that have Go types, for -go-types.
+func walkGoTypes(v cue.Value, types map[string]map[string]string) []cuediscrim.GoTypeUnion {
+	if (v.IncompleteKind() & cue.StructKind) == 0 {
+		return nil
+	}
+	iter, err := v.Fields(cue.Definitions(true))
+	if err != nil {
+		return nil
+	}
+	var unions []cuediscrim.GoTypeUnion
+	for iter.Next() {
+		v := iter.Value()
+		if iter.Selector().IsDefinition() {
+			name := v.Path().String()
+			if arms := cuediscrim.Disjunctions(v); len(arms) > 1 && types[name] != nil {
+				unions = append(unions, goTypeUnion(name, v, arms, types[name]))
+			}
+		}
+		unions = append(unions, walkGoTypes(v, types)...)
+	}
+	return unions
+}
+
+// goTypeUnion returns the union with the given name for
+// the disjunction v with the given arms and Go types.
+func goTypeUnion(name string, v cue.Value, arms []cue.Value, types map[string]string) cuediscrim.GoTypeUnion {
+	return cuediscrim.GoTypeUnion{
+		Name:   name,
+		Result: result(v, discriminate(arms, cuediscrim.ArmNames(v), false)),
+		Types:  types,
+	}
+}
+
+// printGoTypeManifest prints the Go type manifest for the given
+// unions as JSON, or as CUE with -cue. Any unions in types that
+// are not found are reported, and discrim exits with a non-zero
+// status if the manifest is not valid.
+func printGoTypeManifest(unions []cuediscrim.GoTypeUnion, types map[string]map[string]string) {
+	found := make(map[string]bool)
+	for _, u := range unions {
+		found[u.Name] = true
+	}
+	ok := true
+	for _, name := range slices.Sorted(maps.Keys(types)) {
+		if !found[name] {
+			fmt.Fprintf(os.Stderr, "%s: no such disjunction\n", name)
+			ok = false
+		}
+	}
+	m, err := cuediscrim.NewGoTypeManifest(unions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+	var data []byte
+	if *flagCUE {
+		data, err = m.CUE()
+	} else {
+		data, err = json.MarshalIndent(m, "", "\t")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(data)
+}
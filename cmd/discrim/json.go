@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"cuelang.org/go/cue"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// jsonReport holds the output for a single disjunction with -json.
+type jsonReport struct {
+	*cuediscrim.Report
+
+	// EncodedTree holds the decision tree in the form
+	// produced by [cuediscrim.EncodeTree].
+	EncodedTree cuediscrim.Tree `json:"encodedTree"`
+}
+
+// newReport returns the report on the result r for the disjunction v,
+// found at the given path in the package with the given import path.
+func newReport(r *cuediscrim.Result, importPath, path string, v cue.Value) *cuediscrim.Report {
+	rep := r.Report(path)
+	rep.ImportPath = importPath
+	if pos := v.Pos(); pos.IsValid() {
+		rep.Pos = pos.String()
+	}
+	return rep
+}
+
+// jsonReference holds the output with -json for a reference from
+// another package to a disjunction that has already been reported.
+type jsonReference struct {
+	// ImportPath and Path identify the disjunction,
+	// as in its report.
+	ImportPath string `json:"importPath,omitempty"`
+	Path       string `json:"path"`
+
+	// Importer holds the location of the reference,
+	// as in [cuediscrim.Report.Importers].
+	Importer string `json:"importer"`
+}
+
+// printJSONReference writes a reference from imp to the
+// disjunction of f to out as a single line of JSON.
+func printJSONReference(out io.Writer, f *finding, imp string) {
+	data, err := json.Marshal(jsonReference{
+		ImportPath: f.instPath,
+		Path:       f.path(),
+		Importer:   imp,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(out, "%s\n", data)
+}
+
+// printJSON writes the report rep on the result r
+// to out as a single line of JSON.
+func printJSON(out io.Writer, rep *cuediscrim.Report, r *cuediscrim.Result) {
+	data, err := json.Marshal(jsonReport{
+		Report:      rep,
+		EncodedTree: cuediscrim.Tree{DecisionNode: r.Tree},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(out, "%s\n", data)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+// TestJSONReferences checks that, with -json, a disjunction imported
+// from another package is reported with the import path of that
+// package, and that later references to it are printed as JSON.
+func TestJSONReferences(t *testing.T) {
+	insts := loadTestPackages(t, 2)
+	oldJSON := *flagJSON
+	*flagJSON = true
+	defer func() {
+		*flagJSON = oldJSON
+	}()
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	qt.Assert(t, qt.IsNil(err))
+	oldStdout := os.Stdout
+	os.Stdout = out
+	defer func() {
+		os.Stdout = oldStdout
+	}()
+
+	w := &walker{}
+	for p := range packages(cuecontext.New(), instanceSources(insts), true) {
+		qt.Assert(t, qt.IsNil(p.err))
+		w.instPath = p.path
+		w.walk(p.pkg)
+	}
+	os.Stdout = oldStdout
+	data, err := os.ReadFile(out.Name())
+	qt.Assert(t, qt.IsNil(err))
+
+	type line struct {
+		ImportPath string   `json:"importPath"`
+		Path       string   `json:"path"`
+		Importers  []string `json:"importers"`
+		Importer   string   `json:"importer"`
+	}
+	var got []line
+	for _, l := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		var x line
+		qt.Assert(t, qt.IsNil(json.Unmarshal([]byte(l), &x)), qt.Commentf("%s", l))
+		for i, imp := range x.Importers {
+			x.Importers[i] = filepath.Base(imp)
+		}
+		if x.Importer != "" {
+			x.Importer = filepath.Base(x.Importer)
+		}
+		got = append(got, x)
+	}
+	qt.Assert(t, qt.DeepEquals(got, []line{{
+		ImportPath: "example.com/m/shared",
+		Path:       "#U",
+		Importers:  []string{"p0.cue:6:1: x"},
+	}, {
+		ImportPath: "example.com/m/p0@v0",
+		Path:       "y",
+	}, {
+		ImportPath: "example.com/m/shared",
+		Path:       "#U",
+		Importer:   "p1.cue:6:1: x",
+	}, {
+		ImportPath: "example.com/m/p1@v0",
+		Path:       "y",
+	}}))
+}
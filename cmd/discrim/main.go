@@ -1,16 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"slices"
+	"strings"
 
 	"cuelang.org/go/cue"
 	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/build"
 	"cuelang.org/go/cue/cuecontext"
-	"cuelang.org/go/cue/format"
 	"cuelang.org/go/cue/load"
 	"cuelang.org/go/cue/parser"
 
@@ -20,16 +24,96 @@ import (
 var (
 	flagAll                   = flag.Bool("a", false, "show information on all disjuncts, not just imperfect ones")
 	flagVerbose               = flag.Bool("v", false, "print more info")
+	flagLogLevel              = flag.Int("log-level", 1, "with -v, how much debug information to print about the analysis, from 1 (chosen discriminators only) to 3 (everything)")
 	flagExpr                  = flag.String("e", "", "expression to print info on")
 	flagContinue              = flag.Bool("continue-on-error", false, "continue on error")
 	flagMergeCompatible       = flag.Bool("m", false, "merge compatible data types if a perfect discriminator cannot be found")
 	flagMergeCompatibleAlways = flag.Bool("M", false, "merge compatible types even when the discriminator is perfect")
 	flagTypes                 = flag.Bool("t", false, "when types have been merged, show the merged result")
+	flagStats                 = flag.Bool("stats", false, "print statistics about the size of each decision tree")
+	flagProof                 = flag.Bool("proof", false, "for perfect decision trees, explain how each pair of arms is told apart")
+	flagExamples              = flag.String("examples", "", "with -e, infer discriminators from the example data files matching this glob pattern")
+	flagFormat                = flag.String("format", "text", "output format for decision trees; see below for available formats")
+	flagCUE                   = flag.Bool("cue", false, "print the results as CUE conforming to the cuediscrim #Report schema")
+	flagJSON                  = flag.Bool("json", false, "print the result for each disjunction as a single line of JSON holding its #Report and its encoded decision tree")
+	flagIgnoreDeprecated      = flag.Bool("ignore-deprecated", false, "ignore arms marked with @deprecated when deciding whether a discriminator is perfect")
+	flagDedup                 = flag.Bool("dedup", true, "report disjunctions defined in imported packages once, listing the places that refer to them")
+	flagFills                 = make(fillsFlag)
+	flagEval                  = flag.String("eval", "none", "how to evaluate arms before analysis: none, simplify or defaults")
+	flagMaxOutput             = flag.Int("max-output", 0, "with -format text, truncate each decision tree after this many bytes (0 means no limit)")
+	flagSelfContained         = flag.Bool("selfcontained", false, "analyze each package as a self-contained value with its imports inlined, as produced by cue def --inline-imports")
+	flagMaxValueBranches      = flag.Int("max-value-branches", 0, "do not switch on more than this many values of a field at once (0 means no limit)")
+	flagMaxDepth              = flag.Int("max-depth", 0, "do not consider fields more than this many selectors deep as discriminators (0 means no limit)")
+	flagOptimize              = flag.Bool("optimize", false, "reshape each decision tree to make fewer lookups, for example by testing the kind of a value before its value")
+	flagOptionalFields        = flag.Bool("optional-fields", false, "consider fields that are optional in some arms as discriminators as soon as no required field will do, testing for their presence first")
+	flagTopArms               = flag.String("top-arms", "analyze", "how to treat arms that accept any value or any struct, such as _ or {...}: analyze, catch-all, error or exclude")
+	flagClosed                = flag.Bool("closed", false, "assume that values have no fields other than those declared by their arm, so that arms can be told apart by the fields they require")
+	flagRewrite               = flag.Bool("rewrite", false, "print each imperfect disjunction rewritten in CUE as if comprehensions that switch on its discriminator fields")
+	flagAbsPaths              = flag.Bool("abs-paths", false, "with -format text, print the paths in decision trees as full CUE paths, as used by the cue command")
+	flagOpenAPI               = flag.Bool("openapi", false, "print an OpenAPI discriminator object for each definition that is a disjunction told apart by a single string field, instead of the usual output")
+	flagSort                  = flag.Bool("sort", false, "print the disjunctions found sorted by source position once all packages have been analyzed, rather than as they are found")
+	flagCacheDir              = flag.String("cache-dir", "", "keep the results of analysis in this `directory` and reuse them when the disjunctions and flags are unchanged")
+	flagRequireDiscriminator  = flag.String("require-discriminator", "", "check that every disjunction in the packages (or the -e expression) is told apart perfectly by the field at this `path` alone, reporting those that are not, instead of the usual output")
+	flagMarkdown              = flag.Bool("markdown", false, "print Markdown documentation of the discriminator of each definition that is a disjunction, instead of the usual output")
+	flagGoTypes               = flag.String("go-types", "", "print a manifest mapping the arms of each disjunction named in this JSON, YAML or CUE `file` to Go types, checked against its decision tree, as JSON (or CUE with -cue), instead of the usual output")
+	flagFailImperfect         = flag.Bool("fail-imperfect", false, "exit with status 3 if any imperfect disjunction is found")
+	flagOutDir                = flag.String("o", "", "write the report on each disjunction to a file of its own in this `directory` instead of printing it")
+	flagRegistry              = flag.Bool("registry", false, "print a registry of the tag values of the unions defined in the packages, with any collisions between them, as JSON (or CUE with -cue), instead of the usual output")
+	flagJobs                  = flag.Int("j", 1, "analyze up to this many packages at once")
+	flagAuditOpenAPI          = flag.Bool("audit-openapi", false, "audit the discriminators of the unions in the OpenAPI 3 documents given as arguments, in JSON or YAML, instead of the usual output")
+	flagJSONSchema            = flag.Bool("jsonschema", false, "read the arguments as JSON Schema files, in JSON or YAML, rather than CUE packages")
 )
 
+// exprName holds the -e expression, or the lone expression
+// read from standard input, as described for [loneExpr].
+var exprName string
+
+// evalMode holds the mode selected by the -eval flag.
+var evalMode cuediscrim.Concreteness
+
+// topArmPolicy holds the policy selected by the -top-arms flag.
+var topArmPolicy cuediscrim.TopArmPolicy
+
+// flagPaths holds the paths selected by the -p flag.
+var flagPaths pathsFlag
+
+// flagMatch holds the regular expression given by the -match flag.
+var flagMatch regexpFlag
+
+// flagScope holds the kinds of field selected by the -scope flag.
+var flagScope = allScopes
+
+// flagIncludePaths and flagExcludePaths hold the patterns
+// given by the -include-path and -exclude-path flags.
+var flagIncludePaths, flagExcludePaths patternsFlag
+
+func init() {
+	flag.Var(&flagPaths, "p", "only report on the disjunction at this `path`, as printed by discrim or used by cue eval -e, even if it is perfect; may be repeated")
+	flag.Var(&flagMatch, "match", "only analyze the disjunctions whose paths, as printed by discrim, match this `regexp`")
+	flag.Var(&flagScope, "scope", "analyze the disjunctions in these comma-separated kinds of field: regular, definitions, hidden or all")
+	flag.Var(&flagIncludePaths, "include-path", "only use fields matched by this `pattern`, such as spec.*, as discriminators; may be repeated")
+	flag.Var(&flagExcludePaths, "exclude-path", "do not use fields matched by this `pattern`, such as metadata.name, as discriminators; may be repeated")
+	flag.Var(flagFills, "fill", "fill in the value at a path before analysis, as `path=expr` (for example -fill '#F.in=\"x\"'); may be repeated")
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "vet" {
+		os.Exit(runVet(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "example" {
+		os.Exit(runExample(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "tool" {
+		os.Exit(runTool(os.Args[2:]))
+	}
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "usage: discrim [package...]\n")
+		fmt.Fprintf(os.Stderr, "       discrim [flags] - < file.cue\n")
+		fmt.Fprintf(os.Stderr, "       discrim -jsonschema [flags] file...\n")
+		fmt.Fprintf(os.Stderr, "       discrim -audit-openapi [flags] file...\n")
+		fmt.Fprintf(os.Stderr, "       discrim vet -e expr package data-file...\n")
+		fmt.Fprintf(os.Stderr, "       discrim example [package] -p path -arm arm\n")
+		fmt.Fprintf(os.Stderr, "       discrim tool < request.json\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, `
 By default, discrim searches for and prints information on discriminators
@@ -38,11 +122,239 @@ that are not "perfect" in the named packages.
 If an expression is provided with -e, the discriminator for just that
 expression will be printed, evaluated in the context of the specified
 package specified.
-`)
+
+A package argument of - reads a CUE file from standard input, as
+for the cue command, so that discrim can be used in pipelines and
+editor integrations without writing files. When standard input holds
+just an expression, such as {a!: int} | {b!: int}, along with any
+imports it needs, it's treated as if it had been given with -e, and
+is named by its CUE source in reports and in the -go-types file.
+
+With -jsonschema, the arguments are JSON Schema files rather than
+CUE packages, read as YAML if their names end in .yaml or .yml and
+as JSON otherwise. Each is converted to CUE as cue import would
+convert it: the schemas in $defs become definitions, so that the
+schema at #/$defs/shape is analyzed at the path #shape, and each
+oneOf or anyOf is analyzed as a disjunction. A oneOf or anyOf at the
+root of a schema is reported at the path ".". With -e, the expression
+is evaluated in the context of the single schema given.
+
+With -audit-openapi, the arguments are OpenAPI 3 documents, read
+as for -jsonschema, and each component schema that is a union,
+such as one defined by oneOf or anyOf, is checked to declare a
+discriminator object whose property tells its arms apart perfectly
+and whose mapping agrees with the decision tree. For each union
+that has no such discriminator, the problems are printed along with
+the discriminator object that discrim would suggest instead, or the
+decision tree when no single property tells the arms apart. With -a,
+the unions whose discriminators are usable are listed too, and with
+-json, each audit is printed as a line of JSON. discrim exits with
+a non-zero status if any union has no usable discriminator.
+
+With -examples, the example data files are classified using
+the decision tree for the -e expression, and each field in the
+examples is reported on with respect to how well it discriminates
+between the arms in practice.
+
+Paths are printed in the same syntax as the cue command uses,
+so a path printed by discrim can be passed to cue eval -e, and
+a path accepted by cue eval -e can be passed to -p to report
+on just that disjunction. With -abs-paths, the paths in
+decision trees are printed in full too.
+
+With -match, only the disjunctions whose paths match the given
+regular expression are analyzed, which helps to focus on one part of
+a large package. The expression is not anchored, so -match '^#Spec\.'
+selects the disjunctions within #Spec and -match 'Spec' selects any
+path that contains Spec.
+
+With -fill, values can be supplied for the inputs of parameterized
+definitions so that unions that depend on them can be analyzed.
+For example, -fill '#F.in="x"' fills in the in field of #F.
+
+With -max-value-branches, no decision tree switches on more
+values of a field than the given limit, for code generation targets
+that cannot handle large enumerations. Arms that can then only be
+told apart by value are reported as imperfect.
+
+With -include-path and -exclude-path, the fields that decision
+trees may use are limited to those matched by any of the -include-path
+patterns, if given, and not matched by any of the -exclude-path
+patterns. A pattern is a path as printed in decision trees, in which
+each field name may hold wildcards as understood by path.Match and
+[*] matches any list element, and it also matches the fields within
+the ones it names. For example, -include-path 'spec.*' allows only
+the fields within spec.
+
+With -max-depth, fields nested more deeply than the given number
+of selectors are not considered, which keeps the analysis of deeply
+nested schemas such as Kubernetes custom resources quick. Arms that
+can only be told apart by deeper fields are reported as imperfect.
+
+With -optional-fields, a field that is optional in some arms, such
+as kind in {kind?: "a"} | {kind?: "b"}, may be chosen as soon as no
+required field tells the arms apart. The decision tree tests whether
+the field is present first and falls back to a decision made
+without it otherwise, which is usually imperfect.
+
+With -top-arms, arms that accept any value, such as _, or any
+struct, such as {...}, are treated specially rather than analyzed
+like other arms, which they would otherwise be chosen along with.
+With catch-all, such arms are chosen wherever the decision tree
+for the other arms would choose nothing, as a default. With error,
+they are left out of the tree and reported, and the disjunction
+is counted as imperfect. With exclude, they are left out of the
+tree with a warning.
+
+With -scope, only the disjunctions in the given kinds of field
+are analyzed: regular fields, definitions and the fields within
+them, or hidden fields and the fields within them. By default,
+all of them are.
+
+With -selfcontained, each package is analyzed as exported by
+cue def --inline-imports, so the results do not depend on how
+imports are resolved. Source positions are not available in
+that case.
+
+With -openapi, an OpenAPI 3 discriminator object is printed for
+each definition in the packages (or for the -e expression) that
+is a disjunction of named struct arms told apart by the value of
+a single string field, mapping each value to the schema that the
+cue command's OpenAPI encoder produces for the arm it selects.
+With -v, the reason that other definitions have no discriminator
+is printed too.
+
+With -registry, a registry of tag values is printed for all the
+definitions in the packages (or for the -e expression) that are
+disjunctions: for each field that their decision trees switch on
+by value, it lists the values and the definition that each selects
+in each union. A value used by several unions that don't all select
+the same definition with it is listed as a collision. The registry
+is printed as JSON conforming to the #TagRegistry schema, or as CUE
+with -cue. With -v, the collisions are also described on standard
+error.
+
+With -markdown, Markdown documentation is printed for each
+definition in the packages (or for the -e expression) that is
+a disjunction, ready to include in API documentation. It names
+the fields that tell the arms apart and has a table that maps
+their values to each arm, with its doc comment and a link to its
+source, followed by an example value for each arm.
+
+With -go-types, the given file maps the name of each disjunction
+(or the -e expression) to an object that maps its arms, by name or
+by a tag value that selects them, to Go types qualified by import
+path, such as example.com/shapes.Circle. A manifest of the mapping
+is printed as JSON conforming to the #GoTypeManifest schema, or as
+CUE with -cue, for code generators that wire up decoders for the
+disjunctions. The mapping is checked against the decision trees:
+if a disjunction is not told apart perfectly, or an arm has no
+type, or a name is unknown, the problems are reported instead and
+discrim exits with a non-zero status.
+
+With -require-discriminator, every disjunction in the packages
+(or the -e expression) is checked to be told apart perfectly by the
+value of the given field alone, as for organizations that standardize
+on a single tag field. Each disjunction that is not is printed along
+with the fields that its decision tree uses instead, and discrim
+exits with a non-zero status if there are any.
+
+With -rewrite, each imperfect disjunction is also printed rewritten
+as CUE that uses if comprehensions to switch on the fields that
+tell its arms apart, where possible, for use in place of the
+original disjunction.
+
+Each disjunction is printed as soon as it has been analyzed, so
+that long runs give early feedback. When -dedup is in effect, a
+disjunction that is referred to again later is printed once, with
+a line for each later reference. With -sort, nothing is printed
+until all the packages have been analyzed, and the disjunctions
+are then printed in order of source position, with the references
+to each listed alongside it.
+
+With -json, a JSON object is printed on a line of its own for each
+disjunction that would otherwise be printed as text. It holds the
+fields of the #Report schema, including the import path of the
+package and the source positions of the disjunction and its arms,
+along with the decision tree in the serialized form described by
+the cuediscrim #Tree schema, as encodedTree. When -dedup is in
+effect, a later reference to a disjunction that has already been
+printed is printed as an object holding the importPath and path of
+the disjunction and the location of the reference as importer.
+
+With -o, the report on each disjunction is written to a file of its
+own in the given directory instead of being printed, which makes the
+results for large packages easier to navigate. The file for the
+disjunction at #A.b in the package example.com/foo is named
+example.com/foo/#A.b.txt within the directory, or has the extension
+.json with -json or .cue with -cue, in which case it holds a single
+report. Characters in paths that are not safe in file names are
+escaped as %%XX. Nothing is printed for each disjunction, but
+-fail-imperfect still applies.
+
+With -fail-imperfect, discrim exits with status 3 if any of the
+disjunctions that it reports on is imperfect, so that it can be used
+to check the quality of schemas in scripts. Errors and the failures
+of other checks such as -require-discriminator are reported with
+status 1.
+
+When several packages are given, up to -j of them are analyzed at
+once, each in a CUE context of its own. They are still built one at
+a time, because packages that import the same package share it. The
+results are still printed in the order the packages are given, and a
+definition imported by several of them is still reported once with
+-dedup, although it is analyzed once for each. By default, with -j 1,
+the packages are analyzed one at a time.
+
+With -cache-dir, the result of analyzing each disjunction is kept
+in the given directory, keyed by the content of the disjunction and
+the flags that affect analysis, so that later runs over unchanged
+packages can reuse it. The cache is not consulted with -v, which
+needs the analysis log.
+
+The -format flag selects the output format for each decision tree.
+Available formats: %s
+
+The hash format prints a fingerprint of each decision tree that
+changes only when the decisions it makes change, so build systems
+can compare it against a stored value to decide cheaply whether
+code generated from the tree needs to be regenerated.
+`, strings.Join(cuediscrim.Exporters(), ", "))
 		os.Exit(2)
 	}
 	flag.Parse()
+	exporter, ok := cuediscrim.LookupExporter(*flagFormat)
+	if !ok {
+		log.Fatalf("unknown format %q; available formats: %s", *flagFormat, strings.Join(cuediscrim.Exporters(), ", "))
+	}
+	if err := parseModes(); err != nil {
+		log.Fatal(err)
+	}
+	if *flagJSON && *flagCUE {
+		log.Fatalf("-json and -cue are mutually exclusive")
+	}
+	if *flagOutDir != "" && *flagExpr != "" {
+		log.Fatalf("-o cannot be used with -e")
+	}
+	if flagMatch.re != nil && *flagExpr != "" {
+		log.Fatalf("-match cannot be used with -e")
+	}
+	var err error
+	if *flagAuditOpenAPI {
+		if flag.NArg() == 0 {
+			log.Fatalf("-audit-openapi requires at least one file")
+		}
+		os.Exit(auditOpenAPI(flag.Args(), exporter))
+	}
 	ctx := cuecontext.New()
+	var goTypes map[string]map[string]string
+	if *flagGoTypes != "" {
+		var err error
+		goTypes, err = loadGoTypes(ctx, *flagGoTypes)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	var expr ast.Expr
 	if *flagExpr != "" {
@@ -51,79 +363,460 @@ package specified.
 		if err != nil {
 			log.Fatalf("cannot parse expression: %v", err)
 		}
+		exprName = *flagExpr
 	}
 
-	insts := load.Instances(flag.Args(), nil)
-	if len(insts) != 1 && expr != nil {
+	var cfg *load.Config
+	stdinExpr := false
+	if slices.Contains(flag.Args(), "-") {
+		// Read standard input here rather than leaving it
+		// to load so that a lone expression can be recognized.
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("cannot read standard input: %v", err)
+		}
+		cfg = &load.Config{Stdin: bytes.NewReader(data)}
+		if expr == nil && len(flag.Args()) == 1 {
+			exprName, stdinExpr = loneExpr(data)
+		}
+	}
+	var insts []*build.Instance
+	var srcs []source
+	if *flagJSONSchema {
+		if flag.NArg() == 0 {
+			log.Fatalf("-jsonschema requires at least one file")
+		}
+		srcs = jsonSchemaSources(flag.Args())
+	} else {
+		insts = load.Instances(flag.Args(), cfg)
+		srcs = instanceSources(insts)
+	}
+	if len(srcs) != 1 && expr != nil {
 		log.Fatalf("-e requires exactly one package to be specifed")
 	}
-	if expr != nil {
-		scope := ctx.BuildInstance(insts[0]) // Ignore error.
-		var logTo io.Writer
-		if *flagVerbose {
-			logTo = os.Stdout
+	if expr != nil || stdinExpr {
+		var scope cue.Value
+		if *flagJSONSchema {
+			scope, err = srcs[0].build(ctx)
+		} else {
+			scope, err = preparePackage(ctx, ctx.BuildInstance(insts[0])) // Ignore build error.
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		// The value of a lone expression read from
+		// standard input is that of the package itself.
+		v := scope
+		if expr != nil {
+			v = ctx.BuildExpr(expr, cue.Scope(scope), cue.InferBuiltins(true))
 		}
-		v := ctx.BuildExpr(expr, cue.Scope(scope), cue.InferBuiltins(true))
 		if err := v.Err(); err != nil {
 			log.Fatalf("cannot build expression: %v", err)
 		}
 		arms := cuediscrim.Disjunctions(v)
+		if *flagOpenAPI {
+			printOpenAPI(v, arms)
+			return
+		}
+		if *flagMarkdown {
+			printMarkdown(v, arms)
+			return
+		}
+		if *flagGoTypes != "" {
+			var unions []cuediscrim.GoTypeUnion
+			if types := goTypes[exprName]; types != nil {
+				unions = append(unions, goTypeUnion(exprName, v, arms, types))
+			}
+			printGoTypeManifest(unions, goTypes)
+			return
+		}
+		if *flagRegistry {
+			printRegistry([]cuediscrim.GoTagUnion{registryUnion(exprName, v, arms)})
+			return
+		}
+		if *flagRequireDiscriminator != "" {
+			if !checkRequire(v, arms) {
+				os.Exit(1)
+			}
+			return
+		}
+		if *flagCUE || *flagJSON {
+			r := result(v, discriminate(arms, cuediscrim.ArmNames(v), false))
+			rep := newReport(r, srcs[0].path, exprName, v)
+			if *flagJSON {
+				printJSON(os.Stdout, rep, r)
+			} else {
+				printCUE(rep)
+			}
+			exitIfImperfect(!r.Perfect)
+			return
+		}
 		if *flagVerbose {
-			printArms(arms)
+			printArms(os.Stdout, arms)
+		}
+		r := result(v, discriminate(arms, cuediscrim.ArmNames(v), false))
+		printRemoved(os.Stdout, r.Removed)
+		if *flagVerbose {
+			fmt.Print(r.Log)
 		}
-		d, groups, isPerfect := discriminate(arms, logTo)
 		if *flagTypes || *flagVerbose {
-			printMergedTypes(arms, groups)
+			printMergedTypes(os.Stdout, r)
 		}
-		if !isPerfect {
+		if !r.Perfect {
 			fmt.Printf("discriminator is imperfect\n")
 		}
-		fmt.Print(cuediscrim.NodeString(d))
+		if *flagStats {
+			printStats(os.Stdout, r.Tree)
+		}
+		printWarnings(os.Stdout, r.Warnings)
+		if *flagProof && r.Perfect {
+			printProof(os.Stdout, r.Tree)
+		}
+		// The expression is often a path such as #Def,
+		// in which case it can be used for -abs-paths.
+		export(os.Stdout, exporter, r, cue.ParsePath(exprName))
+		printRewrite(os.Stdout, r)
+		if *flagExamples != "" {
+			_, examples, err := loadDataGlob(ctx, *flagExamples)
+			if err != nil {
+				log.Fatal(err)
+			}
+			printInference(r, examples)
+		}
+		exitIfImperfect(!r.Perfect)
 		return
 	}
-	for _, inst := range insts {
-		pkg := ctx.BuildInstance(inst)
-		if err := pkg.Err(); err != nil {
-			fmt.Fprintf(os.Stderr, "cannot build instance: %v\n", err)
+	w := &walker{
+		exporter: exporter,
+	}
+	var unions []cuediscrim.GoTagUnion
+	var goTypeUnions []cuediscrim.GoTypeUnion
+	violations := 0
+	walk := !*flagOpenAPI && !*flagMarkdown && *flagGoTypes == "" && !*flagRegistry && *flagRequireDiscriminator == ""
+	for p := range packages(ctx, srcs, walk) {
+		if p.err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", p.err)
 			if !*flagContinue {
 				os.Exit(1)
 			}
 			continue
 		}
-		new(walker).walkFields(pkg)
+		pkg := p.pkg
+		if *flagOpenAPI {
+			walkOpenAPI(pkg)
+			continue
+		}
+		if *flagMarkdown {
+			walkMarkdown(pkg)
+			continue
+		}
+		if *flagGoTypes != "" {
+			goTypeUnions = append(goTypeUnions, walkGoTypes(pkg, goTypes)...)
+			continue
+		}
+		if *flagRegistry {
+			unions = append(unions, registryUnions(pkg)...)
+			continue
+		}
+		if *flagRequireDiscriminator != "" {
+			violations += walkRequire(pkg)
+			continue
+		}
+		w.instPath = p.path
+		if !p.walked {
+			w.walk(pkg)
+			continue
+		}
+		for _, a := range p.found {
+			w.add(a.key, a.def, a.defInst, a.f)
+		}
+	}
+	if *flagRegistry {
+		printRegistry(unions)
+		return
+	}
+	if *flagGoTypes != "" {
+		printGoTypeManifest(goTypeUnions, goTypes)
+		return
+	}
+	if violations > 0 {
+		os.Exit(1)
+	}
+	w.flush()
+	if *flagCUE && *flagOutDir == "" {
+		data, err := cuediscrim.ReportsCUE(w.reports)
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(data)
+	}
+	exitIfImperfect(w.imperfect > 0)
+}
+
+// parseModes sets evalMode and topArmPolicy
+// from the -eval and -top-arms flags.
+func parseModes() error {
+	switch *flagEval {
+	case "none":
+		evalMode = cuediscrim.EvalNone
+	case "simplify":
+		evalMode = cuediscrim.EvalSimplify
+	case "defaults":
+		evalMode = cuediscrim.EvalDefaults
+	default:
+		return fmt.Errorf("unknown -eval mode %q; want none, simplify or defaults", *flagEval)
+	}
+	policy, err := cuediscrim.ParseTopArmPolicy(*flagTopArms)
+	if err != nil {
+		return fmt.Errorf("unknown -top-arms policy %q; want analyze, catch-all, error or exclude", *flagTopArms)
+	}
+	topArmPolicy = policy
+	return nil
+}
+
+// exitImperfect holds the exit status used with -fail-imperfect,
+// distinct from the status of 1 used for errors and for
+// the other checks.
+const exitImperfect = 3
+
+// exitIfImperfect exits with status exitImperfect if -fail-imperfect
+// is specified and imperfect disjunctions were found.
+func exitIfImperfect(found bool) {
+	if *flagFailImperfect && found {
+		os.Exit(exitImperfect)
+	}
+}
+
+// export writes the result r for the disjunction
+// at path p to out using e.
+func export(out io.Writer, e cuediscrim.Exporter, r *cuediscrim.Result, p cue.Path) {
+	if se, ok := e.(cuediscrim.StreamExporter); ok {
+		opts := []cuediscrim.WriteOption{
+			cuediscrim.MaxOutput(*flagMaxOutput),
+		}
+		if *flagAbsPaths && p.Err() == nil {
+			opts = append(opts, cuediscrim.PathPrinter(treePathPrinter(p)))
+		}
+		if err := se.ExportTo(out, r, opts...); err != nil {
+			log.Fatalf("cannot export: %v", err)
+		}
+		return
+	}
+	data, err := e.Export(r)
+	if err != nil {
+		log.Fatalf("cannot export: %v", err)
 	}
+	out.Write(data)
 }
 
-func discriminate(arms []cue.Value, verboseWriter io.Writer) (cuediscrim.DecisionNode, []cuediscrim.IntSet, bool) {
+// printRewrite writes r rewritten by [cuediscrim.RewriteCUE] to out
+// if the -rewrite flag is specified and r is imperfect.
+func printRewrite(out io.Writer, r *cuediscrim.Result) {
+	if !*flagRewrite || r.Perfect {
+		return
+	}
+	data, err := cuediscrim.RewriteCUE(r)
+	if err != nil {
+		log.Fatalf("cannot rewrite: %v", err)
+	}
+	fmt.Fprintf(out, "rewrite:\n%s", data)
+}
+
+// result returns the result r of discriminating the arms of v,
+// which may have been found through a reference to another value,
+// with the names and removed arms of v itself.
+func result(v cue.Value, r *cuediscrim.Result) *cuediscrim.Result {
+	r1 := *r
+	r1.Names = cuediscrim.ArmNames(v)
+	r1.Removed = cuediscrim.RemovedArms(v)
+	return &r1
+}
+
+func printCUE(r *cuediscrim.Report) {
+	data, err := r.CUE()
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(data)
+}
+
+// maxLog holds the maximum size of the debug log kept
+// for each disjunction with -v.
+const maxLog = 1 << 20
+
+// discriminate analyzes the arms once according to the flags.
+// With -optimize, the tree in the result is optimized and its
+// warnings are those of the optimized tree. With -v, the debug log
+// is kept in the result so that it can be printed along with the
+// rest of the output. The log shows arms by the given names, as
+// returned by [cuediscrim.ArmNames].
+func discriminate(arms []cue.Value, names []string, optional bool) *cuediscrim.Result {
 	merge := *flagMergeCompatibleAlways
 
-	n, groups, isPerfect := cuediscrim.Discriminate(arms, cuediscrim.LogTo(verboseWriter), cuediscrim.MergeCompatible(merge))
-	if isPerfect || !*flagMergeCompatible {
-		return n, groups, isPerfect
+	opts := append(analysisOptions(),
+		cuediscrim.Optional(optional),
+		cuediscrim.LogArmNames(names),
+	)
+	analyze := cuediscrim.Analyze
+	if *flagCacheDir != "" {
+		analyze = cuediscrim.NewCache(*flagCacheDir).Analyze
+	}
+	r := analyze(arms, append(opts, cuediscrim.MergeCompatible(merge))...)
+	if !r.Perfect && *flagMergeCompatible {
+		log := r.Log
+		r = analyze(arms, append(opts, cuediscrim.MergeCompatible(true))...)
+		r.Log = log + r.Log
+	}
+	if *flagOptimize {
+		tree := cuediscrim.Optimize(r.Tree)
+		// Keep the warnings about the arms rather than
+		// the tree, such as those for -top-arms.
+		treeWarnings := cuediscrim.Warnings(r.Tree)
+		warnings := cuediscrim.Warnings(tree)
+		for _, w := range r.Warnings {
+			if !slices.Contains(treeWarnings, w) {
+				warnings = append(warnings, w)
+			}
+		}
+		r.Tree, r.Warnings = tree, warnings
+	}
+	return r
+}
+
+// analysisOptions returns the options for analysis
+// specified by the flags, other than those for merging.
+func analysisOptions() []cuediscrim.Option {
+	opts := []cuediscrim.Option{
+		cuediscrim.LogLevel(*flagLogLevel),
+		cuediscrim.IgnoreDeprecated(*flagIgnoreDeprecated),
+		cuediscrim.Evaluate(evalMode),
+		cuediscrim.MaxValueBranches(*flagMaxValueBranches),
+		cuediscrim.MaxDepth(*flagMaxDepth),
+		cuediscrim.IncludePaths(flagIncludePaths...),
+		cuediscrim.ExcludePaths(flagExcludePaths...),
+		cuediscrim.ClosedWorld(*flagClosed),
+		cuediscrim.TopArms(topArmPolicy),
+		cuediscrim.OptionalFields(*flagOptionalFields),
 	}
-	return cuediscrim.Discriminate(arms, cuediscrim.LogTo(verboseWriter), cuediscrim.MergeCompatible(true))
+	if *flagVerbose {
+		opts = append(opts, cuediscrim.CaptureLog(maxLog))
+	}
+	return opts
 }
 
-func printMergedTypes(arms []cue.Value, groups []cuediscrim.IntSet) {
-	for _, g := range groups {
+func printMergedTypes(out io.Writer, r *cuediscrim.Result) {
+	// MergedGroups returns the groups with more
+	// than one member in the same order as r.Groups.
+	merged := r.MergedGroups()
+	for _, g := range r.Groups {
 		if g.Len() < 2 {
 			continue
 		}
-		var vs []cue.Value
-		for i := range g.Values() {
-			vs = append(vs, arms[i])
+		mg := merged[0]
+		merged = merged[1:]
+		fmt.Fprintf(out, "merged %s into %s\n", cuediscrim.ArmSetString(g, r.Names), mg.Type)
+		if mg.Constraint != "" {
+			fmt.Fprintf(out, "merged %s constraint %s\n", cuediscrim.ArmSetString(g, r.Names), mg.Constraint)
 		}
-		expr := cuediscrim.DataTypeForValues(vs)
-		data, err := format.Node(expr)
-		if err != nil {
-			panic(err)
+	}
+}
+
+// printInference prints the discriminators inferred from data,
+// labeling each example with the arm that it validates against.
+func printInference(r *cuediscrim.Result, data []cue.Value) {
+	examples := cuediscrim.LabelExamples(r.Arms, data)
+	for _, ev := range cuediscrim.InferDiscriminators(r.Tree, examples) {
+		switch {
+		case ev.Discriminates && !ev.InSchema:
+			fmt.Printf("%s: discriminates in practice but not used by schema\n", ev.Path)
+		case ev.Discriminates:
+			fmt.Printf("%s: discriminates\n", ev.Path)
+		case ev.InSchema:
+			fmt.Printf("%s: used by schema but does not discriminate in practice\n", ev.Path)
 		}
-		fmt.Printf("merged %s into %s\n", cuediscrim.SetString(g), data)
 	}
 }
 
+func printStats(out io.Writer, n cuediscrim.DecisionNode) {
+	st := cuediscrim.Stats(n)
+	fmt.Fprintf(out, "nodes %d; depth %d; value switches %d; max value branches %d; expected lookups %.2f\n", st.Nodes, st.Depth, st.ValueSwitches, st.MaxValueBranches, st.ExpectedLookups)
+}
+
 type walker struct {
-	printed bool
+	exporter cuediscrim.Exporter
+	printed  bool
+	reports  []*cuediscrim.Report
+
+	// imperfect holds the number of imperfect
+	// disjunctions that have been reported.
+	imperfect int
+
+	// instPath holds the import path of the instance being walked.
+	instPath string
+	// memo is shared across all the instances walked.
+	memo memo
+
+	// deferred holds whether the disjunctions found are kept
+	// in found rather than added, because the instance is being
+	// walked in parallel with others (see [packages]).
+	deferred bool
+	found    []found
+
+	// findings holds the findings that have not yet been
+	// reported, when -sort or -cue is specified.
+	findings []*finding
+
+	// collect holds whether the findings are kept in findings
+	// for the caller rather than reported, as for the tool
+	// subcommand.
+	collect bool
+
+	// When -dedup is enabled, byKey holds all
+	// the findings indexed by memo key.
+	byKey map[string]*finding
+}
+
+// finding holds a disjunction to be reported on.
+type finding struct {
+	// instPath holds the import path of the
+	// instance that v was found in.
+	instPath string
+	v        cue.Value
+	arms     []cue.Value
+	optional bool
+
+	// result holds the result of discriminating arms,
+	// which may be shared with other findings
+	// that refer to the same value.
+	result *cuediscrim.Result
+
+	// importers holds the places in other packages
+	// that refer to v.
+	importers []string
+
+	// reported holds whether the finding has been printed.
+	reported bool
+}
+
+// path returns the path of the disjunction, which is "." for
+// the root of a package, as in decision trees.
+func (f *finding) path() string {
+	if p := f.v.Path().String(); p != "" {
+		return p
+	}
+	return "."
+}
+
+// walk walks the package v for disjunctions. With -jsonschema,
+// the root schema of v is analyzed too, because a oneOf or anyOf
+// is commonly found there.
+func (w *walker) walk(v cue.Value) {
+	if *flagJSONSchema && len(flagPaths) == 0 && flagMatch.match(v.Path()) {
+		if arms := cuediscrim.Disjunctions(v); len(arms) > 1 {
+			w.analyze(v, arms, false, false)
+		}
+	}
+	w.walkFields(v)
 }
 
 func (w *walker) walkFields(v cue.Value) {
@@ -136,35 +829,218 @@ func (w *walker) walkFields(v cue.Value) {
 	}
 	for iter.Next() {
 		v := iter.Value()
+		if !flagScope.descend(v.Path()) {
+			continue
+		}
+		if !flagScope.analyze(v.Path()) || !flagMatch.match(v.Path()) {
+			w.walkFields(v)
+			continue
+		}
 		if arms := cuediscrim.Disjunctions(v); len(arms) > 1 {
-			n, groups, isPerfect := discriminate(arms, nil)
-			if *flagAll || !isPerfect {
-				if w.printed {
-					fmt.Printf("\n")
-				}
-				w.printed = true
-				fmt.Printf("%v: %v\n", v.Pos(), v.Path())
-				if *flagVerbose {
-					printArms(arms)
-					// Run again so that we get the debug info.
-					// TODO avoid duplicating the work when *flagAll is specified
-					// so we know we're printing debug info in advance.
-					n, groups, _ = discriminate(arms, os.Stdout)
-				}
-				if *flagTypes || *flagVerbose {
-					printMergedTypes(arms, groups)
-				}
-				fmt.Print(cuediscrim.NodeString(n))
+			selected := len(flagPaths) > 0 && flagPaths.match(v.Path())
+			if len(flagPaths) > 0 && !selected {
+				w.walkFields(v)
+				continue
 			}
-
+			w.analyze(v, arms, iter.FieldType()&cue.OptionalConstraint != 0, selected)
 		}
 		w.walkFields(v)
 	}
 }
 
-func printArms(arms []cue.Value) {
+// analyze analyzes the disjunction v with the given arms,
+// adding a finding for it if it is to be reported.
+func (w *walker) analyze(v cue.Value, arms []cue.Value, optional, selected bool) {
+	key, def, defInst := memoKey(w.instPath, v)
+	if optional {
+		key += "?"
+	}
+	a := w.memo.discriminate(key, arms, cuediscrim.ArmNames(v), optional)
+	if !*flagAll && !selected && a.Perfect {
+		return
+	}
+	if defInst == "" {
+		// Only references to other packages are de-duplicated.
+		key = instanceKey(w.instPath) + ":" + v.Path().String()
+	}
+	f := &finding{
+		instPath: w.instPath,
+		v:        v,
+		arms:     arms,
+		optional: optional,
+		result:   a,
+	}
+	if w.deferred {
+		w.found = append(w.found, found{key, def, defInst, f})
+	} else {
+		w.add(key, def, defInst, f)
+	}
+}
+
+// add adds a finding with the given key. If defInst is not empty,
+// the finding's value is a reference to def in the package with that
+// import path.
+func (w *walker) add(key string, def cue.Value, defInst string, f *finding) {
+	if !*flagDedup {
+		w.emit(f)
+		return
+	}
+	if f0 := w.byKey[key]; f0 != nil {
+		switch {
+		case defInst == "":
+		case f0.reported && *flagJSON:
+			printJSONReference(os.Stdout, f0, importer(f.v))
+		case f0.reported:
+			// It's too late to list the importer with
+			// the finding, so print it on its own.
+			fmt.Printf("\n%s: refers to %v, reported above\n", importer(f.v), f0.v.Path())
+		default:
+			f0.importers = append(f0.importers, importer(f.v))
+		}
+		return
+	}
+	if defInst != "" {
+		f.importers = []string{importer(f.v)}
+		f.v = def
+		f.instPath = defInst
+	}
+	if w.byKey == nil {
+		w.byKey = make(map[string]*finding)
+	}
+	w.byKey[key] = f
+	w.emit(f)
+}
+
+// emit reports f immediately, or keeps it to be reported by flush
+// when -sort is specified, the output is a single CUE document or
+// the reports are written to files with -o, so that each file
+// lists all the references to its disjunction.
+func (w *walker) emit(f *finding) {
+	if *flagSort || *flagCUE || *flagOutDir != "" || w.collect {
+		w.findings = append(w.findings, f)
+		return
+	}
+	w.report(f)
+}
+
+// flush reports all the findings kept by emit,
+// sorting them first if -sort is specified.
+func (w *walker) flush() {
+	if *flagSort {
+		slices.SortStableFunc(w.findings, compareFindings)
+	}
+	for _, f := range w.findings {
+		w.report(f)
+	}
+	w.findings = nil
+}
+
+// compareFindings orders findings by the source
+// position of their values, then by path.
+func compareFindings(f1, f2 *finding) int {
+	p1, p2 := f1.v.Pos(), f2.v.Pos()
+	return cmp.Or(
+		cmp.Compare(p1.Filename(), p2.Filename()),
+		cmp.Compare(p1.Line(), p2.Line()),
+		cmp.Compare(p1.Column(), p2.Column()),
+		cmp.Compare(f1.v.Path().String(), f2.v.Path().String()),
+	)
+}
+
+func (w *walker) report(f *finding) {
+	f.reported = true
+	r := result(f.v, f.result)
+	if !r.Perfect {
+		w.imperfect++
+	}
+	if *flagOutDir != "" {
+		w.writeFile(f, r)
+		return
+	}
+	if *flagCUE || *flagJSON {
+		rep := f.report(r)
+		if *flagJSON {
+			printJSON(os.Stdout, rep, r)
+		} else {
+			w.reports = append(w.reports, rep)
+		}
+		return
+	}
+	if w.printed {
+		fmt.Printf("\n")
+	}
+	w.printed = true
+	w.printText(os.Stdout, f, r)
+}
+
+// report returns the report on f, with the result r,
+// as printed by -cue and -json.
+func (f *finding) report(r *cuediscrim.Result) *cuediscrim.Report {
+	rep := newReport(r, f.instPath, f.path(), f.v)
+	rep.Importers = f.importers
+	return rep
+}
+
+// printText writes the report on f, with the result r, to out
+// in the usual text form.
+func (w *walker) printText(out io.Writer, f *finding, r *cuediscrim.Result) {
+	fmt.Fprintf(out, "%v: %v\n", f.v.Pos(), f.path())
+	if len(f.importers) > 0 {
+		fmt.Fprintf(out, "imported by:\n")
+		for _, imp := range f.importers {
+			fmt.Fprintf(out, "\t%s\n", imp)
+		}
+	}
+	printRemoved(out, r.Removed)
+	if *flagVerbose {
+		printArms(out, r.Arms)
+		fmt.Fprint(out, r.Log)
+	}
+	if *flagTypes || *flagVerbose {
+		printMergedTypes(out, r)
+	}
+	if *flagStats {
+		printStats(out, r.Tree)
+	}
+	printWarnings(out, r.Warnings)
+	if *flagProof && r.Perfect {
+		printProof(out, r.Tree)
+	}
+	export(out, w.exporter, r, f.v.Path())
+	printRewrite(out, r)
+}
+
+func importer(v cue.Value) string {
+	return fmt.Sprintf("%v: %v", v.Pos(), v.Path())
+}
+
+func printArms(out io.Writer, arms []cue.Value) {
 	for i, arm := range arms {
-		fmt.Printf("%d: %v: %v\n", i, arm.Pos(), arm)
+		fmt.Fprintf(out, "%d: %v: %v\n", i, arm.Pos(), arm)
+	}
+}
+
+func printRemoved(out io.Writer, removed []cuediscrim.RemovedArm) {
+	for _, r := range removed {
+		fmt.Fprintf(out, "removed arm at %v: %s\n", r.Arm.Pos(), r.Reason)
+	}
+}
+
+func printWarnings(out io.Writer, warnings []cuediscrim.Warning) {
+	for _, w := range warnings {
+		fmt.Fprintf(out, "warning: %v\n", w)
+	}
+}
+
+func printProof(out io.Writer, n cuediscrim.DecisionNode) {
+	p := cuediscrim.Prove(n)
+	for _, s := range p.Separations {
+		fmt.Fprintf(out, "proof: %v\n", s)
+	}
+	// Arms merged with -m are considered perfect
+	// but are not separated.
+	for _, pair := range p.Unseparated {
+		fmt.Fprintf(out, "proof: arms %d and %d: not separated\n", pair[0], pair[1])
 	}
 }
 
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/token"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// walkMarkdown prints Markdown documentation for each
+// definition within v that is a disjunction.
+func walkMarkdown(v cue.Value) {
+	if (v.IncompleteKind() & cue.StructKind) == 0 {
+		return
+	}
+	iter, err := v.Fields(cue.Definitions(true))
+	if err != nil {
+		return
+	}
+	for iter.Next() {
+		v := iter.Value()
+		if iter.Selector().IsDefinition() && flagMatch.match(v.Path()) {
+			if arms := cuediscrim.Disjunctions(v); len(arms) > 1 {
+				printMarkdown(v, arms)
+			}
+		}
+		walkMarkdown(v)
+	}
+}
+
+// printMarkdown prints Markdown documentation for the
+// disjunction v with the given arms.
+func printMarkdown(v cue.Value, arms []cue.Value) {
+	r := result(v, discriminate(arms, cuediscrim.ArmNames(v), false))
+	opts := []cuediscrim.MarkdownOption{
+		cuediscrim.MarkdownLinks(relativeLink),
+	}
+	if exprName != "" {
+		opts = append(opts, cuediscrim.MarkdownTitle(fmt.Sprintf("`%s`", exprName)))
+	}
+	data, err := cuediscrim.GenerateMarkdown(v, r, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(append(data, '\n'))
+}
+
+// relativeLink returns a link to the source at pos
+// relative to the current directory where possible.
+func relativeLink(pos token.Pos) string {
+	if !pos.IsValid() || pos.Filename() == "" {
+		return ""
+	}
+	file := pos.Filename()
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, file); err == nil && filepath.IsLocal(rel) {
+			file = filepath.ToSlash(rel)
+		}
+	}
+	return fmt.Sprintf("%s#L%d", file, pos.Line())
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"cmp"
+
+	"cuelang.org/go/cue"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// memo caches analyses for the duration of a single run so that
+// a definition imported by several packages is only analyzed once,
+// unless the packages are analyzed in parallel, in which case
+// each has a memo of its own.
+// The flags that affect analysis are fixed for the whole run
+// so they don't need to form part of the key.
+type memo struct {
+	entries map[string]*cuediscrim.Result
+}
+
+// discriminate is like the top level discriminate function except
+// that it consults the cache first. The key should be obtained
+// by calling memoKey on the value the arms were taken from,
+// and must distinguish optional from non-optional values.
+func (m *memo) discriminate(key string, arms []cue.Value, names []string, optional bool) *cuediscrim.Result {
+	if a := m.entries[key]; a != nil {
+		return a
+	}
+	a := discriminate(arms, names, optional)
+	if m.entries == nil {
+		m.entries = make(map[string]*cuediscrim.Result)
+	}
+	m.entries[key] = a
+	return a
+}
+
+// memoKey returns the cache key for v, which was found in the instance
+// with the given import path. When v is a plain reference to another
+// value (for example dep.#U), the key is that of the referenced value,
+// so all references to the same definition share an entry.
+//
+// It also returns the value that the key refers to and, when that
+// value lives in a different instance, the import path of that
+// instance.
+func memoKey(instPath string, v cue.Value) (key string, def cue.Value, defInst string) {
+	if root, p := v.ReferencePath(); root.Exists() && len(p.Selectors()) > 0 {
+		if inst := root.BuildInstance(); inst != nil {
+			// A value built from syntax rather than from a loaded
+			// instance, such as a converted JSON Schema, has no
+			// import path of its own.
+			defPath := cmp.Or(inst.ImportPath, instPath)
+			if instanceKey(defPath) == instanceKey(instPath) {
+				defPath = ""
+			}
+			return instanceKey(cmp.Or(defPath, instPath)) + ":" + p.String(), root.LookupPath(p), defPath
+		}
+	}
+	return instanceKey(instPath) + ":" + v.Path().String(), v, ""
+}
+
+// instanceKey returns the import path without any major version suffix,
+// so that "example.com/foo@v0" and "example.com/foo" are treated the same.
+func instanceKey(importPath string) string {
+	for i := len(importPath) - 1; i >= 0 && importPath[i] != '/'; i-- {
+		if importPath[i] == '@' {
+			return importPath[:i]
+		}
+	}
+	return importPath
+}
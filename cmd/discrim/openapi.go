@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// walkOpenAPI prints the OpenAPI discriminator object
+// for each definition within v that has one.
+func walkOpenAPI(v cue.Value) {
+	if (v.IncompleteKind() & cue.StructKind) == 0 {
+		return
+	}
+	iter, err := v.Fields(cue.Definitions(true))
+	if err != nil {
+		return
+	}
+	for iter.Next() {
+		v := iter.Value()
+		if iter.Selector().IsDefinition() && flagMatch.match(v.Path()) {
+			if arms := cuediscrim.Disjunctions(v); len(arms) > 1 {
+				printOpenAPI(v, arms)
+			}
+		}
+		walkOpenAPI(v)
+	}
+}
+
+// printOpenAPI prints the OpenAPI discriminator object for the
+// disjunction v with the given arms. With -v, it also explains
+// why there is none.
+func printOpenAPI(v cue.Value, arms []cue.Value) {
+	r := result(v, discriminate(arms, cuediscrim.ArmNames(v), false))
+	d, err := cuediscrim.FindOpenAPIDiscriminator(r.Tree, r.Names)
+	if err != nil {
+		if *flagVerbose {
+			fmt.Printf("%v: %v: no discriminator: %v\n", v.Pos(), v.Path(), err)
+		}
+		return
+	}
+	data, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%v: %v\n", v.Pos(), v.Path())
+	os.Stdout.Write(append(data, '\n'))
+}
+
+// auditOpenAPI prints the audit of the unions in each of the
+// OpenAPI documents in files, for -audit-openapi, printing the trees
+// with e where no discriminator can be suggested. It returns the exit
+// status, which is non-zero if any union has no usable discriminator.
+func auditOpenAPI(files []string, e cuediscrim.Exporter) int {
+	status := 0
+	printed := false
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			status = 1
+			continue
+		}
+		opts := append(analysisOptions(), cuediscrim.MergeCompatible(*flagMergeCompatibleAlways))
+		audits, err := cuediscrim.AuditOpenAPI(cuecontext.New(), file, data, opts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			status = 1
+			continue
+		}
+		for _, a := range audits {
+			if !a.Usable() {
+				status = 1
+			} else if !*flagAll {
+				continue
+			}
+			if *flagJSON {
+				data, err := json.Marshal(struct {
+					File string `json:"file"`
+					*cuediscrim.OpenAPIAudit
+				}{file, &a})
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("%s\n", data)
+				continue
+			}
+			if printed {
+				fmt.Printf("\n")
+			}
+			printed = true
+			printAudit(file, &a, e)
+		}
+	}
+	return status
+}
+
+// printAudit prints the audit a of a union in the
+// OpenAPI document in file as text.
+func printAudit(file string, a *cuediscrim.OpenAPIAudit, e cuediscrim.Exporter) {
+	ref := "#/components/schemas/" + a.Schema
+	if a.Usable() {
+		fmt.Printf("%s: %s: discriminator %s is usable\n", file, ref, a.Declared.PropertyName)
+		return
+	}
+	for _, p := range a.Problems {
+		fmt.Printf("%s: %s: %s\n", file, ref, p)
+	}
+	if *flagVerbose {
+		fmt.Print(a.Result.Log)
+	}
+	if a.Inferred == nil {
+		fmt.Printf("no discriminator can be suggested: %s\n", a.NotInferred)
+		export(os.Stdout, e, a.Result, cue.Path{})
+		return
+	}
+	data, err := json.MarshalIndent(a.Inferred, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("suggested discriminator:\n%s\n", data)
+}
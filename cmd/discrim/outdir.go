@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// writeFile writes the report on f, with the result r, to a file
+// of its own in the -o directory, as named by outputFile.
+func (w *walker) writeFile(f *finding, r *cuediscrim.Result) {
+	var buf bytes.Buffer
+	ext := ".txt"
+	switch {
+	case *flagJSON:
+		ext = ".json"
+		printJSON(&buf, f.report(r), r)
+	case *flagCUE:
+		ext = ".cue"
+		data, err := f.report(r).CUE()
+		if err != nil {
+			log.Fatal(err)
+		}
+		buf.Write(data)
+	default:
+		w.printText(&buf, f, r)
+	}
+	name := outputFile(*flagOutDir, f.instPath, f.path(), ext)
+	if err := os.MkdirAll(filepath.Dir(name), 0o777); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0o666); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// outputFile returns the name of the file in dir that holds the
+// report on the disjunction at the given path in the package with the
+// given import path. Each element of the import path is a directory,
+// and the file is named after the path with the given extension.
+func outputFile(dir, importPath, path, ext string) string {
+	elems := []string{dir}
+	for _, elem := range strings.Split(importPath, "/") {
+		if elem != "" {
+			elems = append(elems, fileNameEscape(elem))
+		}
+	}
+	return filepath.Join(append(elems, fileNameEscape(path)+ext)...)
+}
+
+// fileNameEscape returns s with the characters that are not safe in
+// file names on common file systems escaped as %XX. A name that would
+// otherwise refer to the current or parent directory is escaped too.
+func fileNameEscape(s string) string {
+	if s == "." || s == ".." {
+		return strings.ReplaceAll(s, ".", "%2E")
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("#._-@()[]+=,'", c) >= 0:
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"iter"
+	"os"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"cuelang.org/go/cue/cuecontext"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// source holds a package to be analyzed.
+type source struct {
+	// path holds the import path of the package, or the
+	// name of the JSON Schema file with -jsonschema.
+	path string
+
+	// build builds the package in ctx.
+	build func(ctx *cue.Context) (cue.Value, error)
+}
+
+// instanceSources returns a source for each of the given instances.
+func instanceSources(insts []*build.Instance) []source {
+	srcs := make([]source, len(insts))
+	for i, inst := range insts {
+		srcs[i] = source{
+			path: inst.ImportPath,
+			build: func(ctx *cue.Context) (cue.Value, error) {
+				return buildPackage(ctx, inst)
+			},
+		}
+	}
+	return srcs
+}
+
+// jsonSchemaSources returns a source for each of
+// the given JSON Schema files, for -jsonschema.
+func jsonSchemaSources(files []string) []source {
+	srcs := make([]source, len(files))
+	for i, file := range files {
+		srcs[i] = source{
+			path: file,
+			build: func(ctx *cue.Context) (cue.Value, error) {
+				return buildJSONSchema(ctx, file)
+			},
+		}
+	}
+	return srcs
+}
+
+// pkgResult holds a package built from a source
+// and, when it was analyzed in parallel with other packages,
+// the disjunctions found in it.
+type pkgResult struct {
+	path string
+	pkg  cue.Value
+	err  error
+
+	// walked holds whether the package has been walked already,
+	// in which case found holds what was found, in order.
+	walked bool
+	found  []found
+}
+
+// found holds the arguments of a call to [walker.add]
+// that has been put off until the findings of the packages
+// analyzed earlier have been added.
+type found struct {
+	key     string
+	def     cue.Value
+	defInst string
+	f       *finding
+}
+
+// packages returns the result of building each of the sources
+// in turn. When there are several sources and -j allows, the usual
+// analysis is done in parallel as each one is built when walk is
+// true, each package in a context of its own because a context can't
+// be used concurrently. The packages are still built one at a time,
+// because packages that import the same package share its
+// instance, which building modifies. The results are produced in the
+// order of the sources, so that the output doesn't depend on which
+// packages happen to be analyzed first.
+func packages(ctx *cue.Context, srcs []source, walk bool) iter.Seq[*pkgResult] {
+	if *flagJobs <= 1 || len(srcs) < 2 {
+		return func(yield func(*pkgResult) bool) {
+			for _, src := range srcs {
+				pkg, err := src.build(ctx)
+				if !yield(&pkgResult{path: src.path, pkg: pkg, err: err}) {
+					return
+				}
+			}
+		}
+	}
+	results := make([]chan *pkgResult, len(srcs))
+	for i := range results {
+		results[i] = make(chan *pkgResult, 1)
+	}
+	go func() {
+		sem := make(chan struct{}, *flagJobs)
+		for i, src := range srcs {
+			sem <- struct{}{}
+			r := buildSource(src)
+			go func() {
+				defer func() {
+					<-sem
+				}()
+				if walk {
+					analyzePackage(r)
+				}
+				results[i] <- r
+			}()
+		}
+	}()
+	return func(yield func(*pkgResult) bool) {
+		for _, c := range results {
+			if !yield(<-c) {
+				return
+			}
+		}
+	}
+}
+
+// buildSource builds src in a new context.
+func buildSource(src source) *pkgResult {
+	pkg, err := src.build(cuecontext.New())
+	return &pkgResult{
+		path: src.path,
+		pkg:  pkg,
+		err:  err,
+	}
+}
+
+// analyzePackage walks the package in r for disjunctions without
+// reporting them. Each package has a memo of its own, so a definition
+// imported by several packages is analyzed once for each of them.
+func analyzePackage(r *pkgResult) {
+	if r.err != nil {
+		return
+	}
+	w := &walker{
+		instPath: r.path,
+		deferred: true,
+	}
+	w.walk(r.pkg)
+	r.walked, r.found = true, w.found
+}
+
+// buildPackage builds inst, filling it in as specified by -fill and
+// making it self-contained with -selfcontained.
+func buildPackage(ctx *cue.Context, inst *build.Instance) (cue.Value, error) {
+	pkg := ctx.BuildInstance(inst)
+	if err := pkg.Err(); err != nil {
+		return cue.Value{}, fmt.Errorf("cannot build instance: %v", err)
+	}
+	return preparePackage(ctx, pkg)
+}
+
+// buildJSONSchema converts the JSON Schema in the named file to CUE
+// and prepares it as buildPackage does.
+func buildJSONSchema(ctx *cue.Context, file string) (cue.Value, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return cue.Value{}, err
+	}
+	pkg, err := cuediscrim.ImportJSONSchema(ctx, file, data)
+	if err != nil {
+		return cue.Value{}, fmt.Errorf("%s: %v", file, err)
+	}
+	return preparePackage(ctx, pkg)
+}
+
+// preparePackage fills in pkg as specified by -fill and
+// makes it self-contained with -selfcontained.
+func preparePackage(ctx *cue.Context, pkg cue.Value) (cue.Value, error) {
+	pkg, err := flagFills.fill(ctx, pkg)
+	if err == nil && *flagSelfContained {
+		pkg, err = cuediscrim.SelfContained(pkg)
+	}
+	return pkg, err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cuelang.org/go/cue/build"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/load"
+	"github.com/go-quicktest/qt"
+)
+
+// TestPackagesParallel checks that packages that import the same
+// package can be built and analyzed in parallel. Run it with -race.
+func TestPackagesParallel(t *testing.T) {
+	insts := loadTestPackages(t, 8)
+	oldJobs := *flagJobs
+	*flagJobs = 4
+	defer func() {
+		*flagJobs = oldJobs
+	}()
+	var paths []string
+	for p := range packages(cuecontext.New(), instanceSources(insts), true) {
+		qt.Assert(t, qt.IsNil(p.err))
+		qt.Check(t, qt.IsTrue(p.walked))
+		qt.Check(t, qt.Not(qt.HasLen(p.found, 0)))
+		paths = append(paths, p.path)
+	}
+	qt.Assert(t, qt.DeepEquals(paths, []string{
+		"example.com/m/p0@v0",
+		"example.com/m/p1@v0",
+		"example.com/m/p2@v0",
+		"example.com/m/p3@v0",
+		"example.com/m/p4@v0",
+		"example.com/m/p5@v0",
+		"example.com/m/p6@v0",
+		"example.com/m/p7@v0",
+	}))
+}
+
+// loadTestPackages writes a module holding n packages, p0, p1 and so
+// on, each of which refers to the imperfect disjunction shared.#U
+// from its field x and has an imperfect disjunction of its own in
+// its field y, and returns the instances for the packages.
+func loadTestPackages(t *testing.T, n int) []*build.Instance {
+	dir := t.TempDir()
+	files := map[string]string{
+		"cue.mod/module.cue": `
+module: "example.com/m@v0"
+language: version: "v0.12.0"
+`,
+		"shared/shared.cue": `
+package shared
+
+#U: {kind!: string} | {kind!: "b", b?: string}
+`,
+	}
+	var pkgs []string
+	for i := range n {
+		name := fmt.Sprintf("p%d", i)
+		pkgs = append(pkgs, "./"+name)
+		files[name+"/"+name+".cue"] = fmt.Sprintf(`
+package %s
+
+import "example.com/m/shared"
+
+x: shared.#U
+y: {n!: %d} | {n!: int, c?: string}
+`, name, i)
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		qt.Assert(t, qt.IsNil(os.MkdirAll(filepath.Dir(path), 0o777)))
+		qt.Assert(t, qt.IsNil(os.WriteFile(path, []byte(data), 0o666)))
+	}
+	insts := load.Instances(pkgs, &load.Config{Dir: dir})
+	for _, inst := range insts {
+		qt.Assert(t, qt.IsNil(inst.Err))
+	}
+	return insts
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"cuelang.org/go/cue"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// pathsFlag implements flag.Value for the -p flag.
+// It holds paths in the syntax used by the cue command.
+type pathsFlag []cue.Path
+
+func (f *pathsFlag) String() string {
+	var parts []string
+	for _, p := range *f {
+		parts = append(parts, p.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f *pathsFlag) Set(s string) error {
+	p := cue.ParsePath(s)
+	if err := p.Err(); err != nil {
+		return err
+	}
+	*f = append(*f, p)
+	return nil
+}
+
+// match reports whether p is one of the paths in f.
+func (f pathsFlag) match(p cue.Path) bool {
+	s := canonicalPath(p)
+	for _, p1 := range f {
+		if canonicalPath(p1) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalPath returns p in cue.Path syntax without
+// any optional or required markers, so that a path
+// such as a.b? matches a.b.
+func canonicalPath(p cue.Path) string {
+	sels := p.Selectors()
+	for i, sel := range sels {
+		if sel.ConstraintType() != 0 && sel.LabelType() == cue.StringLabel {
+			sels[i] = cue.Str(sel.Unquoted())
+		}
+	}
+	return cue.MakePath(sels...).String()
+}
+
+// treePathPrinter returns a function that prints the paths
+// in a decision tree for the disjunction at path p as absolute
+// paths in cue.Path syntax. Paths that cannot be
+// represented are printed unchanged.
+func treePathPrinter(p cue.Path) func(string) string {
+	return func(path string) string {
+		tp, err := cuediscrim.CUEPath(path)
+		if err != nil {
+			return path
+		}
+		sels := append(p.Selectors(), tp.Selectors()...)
+		return cue.MakePath(sels...).String()
+	}
+}
+
+// patternsFlag implements flag.Value for the -include-path
+// and -exclude-path flags. It holds the patterns in the
+// syntax used by [cuediscrim.IncludePaths].
+type patternsFlag []string
+
+func (f *patternsFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *patternsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// regexpFlag implements flag.Value for the -match flag.
+type regexpFlag struct {
+	re *regexp.Regexp
+}
+
+func (f *regexpFlag) String() string {
+	if f.re == nil {
+		return ""
+	}
+	return f.re.String()
+}
+
+func (f *regexpFlag) Set(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	f.re = re
+	return nil
+}
+
+// match reports whether p, as printed by discrim, matches the
+// regular expression in f. It always holds if f is unset.
+func (f *regexpFlag) match(p cue.Path) bool {
+	return f.re == nil || f.re.MatchString(canonicalPath(p))
+}
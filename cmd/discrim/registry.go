@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"cuelang.org/go/cue"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// registryUnions returns the unions defined by
+// the definitions within v, for -registry.
+func registryUnions(v cue.Value) []cuediscrim.GoTagUnion {
+	if (v.IncompleteKind() & cue.StructKind) == 0 {
+		return nil
+	}
+	iter, err := v.Fields(cue.Definitions(true))
+	if err != nil {
+		return nil
+	}
+	var unions []cuediscrim.GoTagUnion
+	for iter.Next() {
+		v := iter.Value()
+		if iter.Selector().IsDefinition() && flagMatch.match(v.Path()) {
+			if arms := cuediscrim.Disjunctions(v); len(arms) > 1 {
+				unions = append(unions, registryUnion(v.Path().String(), v, arms))
+			}
+		}
+		unions = append(unions, registryUnions(v)...)
+	}
+	return unions
+}
+
+// registryUnion returns the union with the given
+// name for the disjunction v with the given arms.
+func registryUnion(name string, v cue.Value, arms []cue.Value) cuediscrim.GoTagUnion {
+	return cuediscrim.GoTagUnion{
+		Name:  name,
+		Tree:  discriminate(arms, cuediscrim.ArmNames(v), false).Tree,
+		Names: cuediscrim.ArmNames(v),
+	}
+}
+
+// printRegistry prints the tag registry for the given unions as
+// JSON, or as CUE with -cue. With -v, the collisions are also
+// described on standard error.
+func printRegistry(unions []cuediscrim.GoTagUnion) {
+	r := cuediscrim.NewTagRegistry(unions)
+	if *flagVerbose {
+		for _, c := range r.Collisions {
+			fmt.Fprintf(os.Stderr, "collision: %v\n", c)
+		}
+	}
+	var data []byte
+	var err error
+	if *flagCUE {
+		data, err = r.CUE()
+	} else {
+		data, err = json.MarshalIndent(r, "", "\t")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(data)
+}
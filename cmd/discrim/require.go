@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"cuelang.org/go/cue"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// walkRequire checks every disjunction within v against
+// -require-discriminator, printing each violation.
+// It returns the number of violations.
+func walkRequire(v cue.Value) int {
+	if (v.IncompleteKind() & cue.StructKind) == 0 {
+		return 0
+	}
+	iter, err := v.Fields(cue.All())
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for iter.Next() {
+		v := iter.Value()
+		if !flagScope.descend(v.Path()) {
+			continue
+		}
+		if arms := cuediscrim.Disjunctions(v); len(arms) > 1 && flagScope.analyze(v.Path()) && flagMatch.match(v.Path()) {
+			if !checkRequire(v, arms) {
+				n++
+			}
+		}
+		n += walkRequire(v)
+	}
+	return n
+}
+
+// checkRequire checks the disjunction v with the given arms against
+// -require-discriminator, printing the violation if there is one.
+// It reports whether v conforms.
+func checkRequire(v cue.Value, arms []cue.Value) bool {
+	r := result(v, discriminate(arms, cuediscrim.ArmNames(v), false))
+	err := cuediscrim.RequireDiscriminator(r, *flagRequireDiscriminator)
+	if err != nil {
+		fmt.Printf("%v: %v: %v\n", v.Pos(), v.Path(), err)
+		return false
+	}
+	return true
+}
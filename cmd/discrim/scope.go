@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"cuelang.org/go/cue"
+)
+
+// scopeFlag implements flag.Value for the -scope flag.
+// It holds the kinds of field whose disjunctions are analyzed.
+type scopeFlag struct {
+	regular     bool
+	definitions bool
+	hidden      bool
+}
+
+// allScopes holds the value of the -scope flag by default.
+var allScopes = scopeFlag{
+	regular:     true,
+	definitions: true,
+	hidden:      true,
+}
+
+func (f *scopeFlag) String() string {
+	var parts []string
+	if f.regular {
+		parts = append(parts, "regular")
+	}
+	if f.definitions {
+		parts = append(parts, "definitions")
+	}
+	if f.hidden {
+		parts = append(parts, "hidden")
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *scopeFlag) Set(s string) error {
+	*f = scopeFlag{}
+	for _, part := range strings.Split(s, ",") {
+		switch part {
+		case "regular":
+			f.regular = true
+		case "definitions":
+			f.definitions = true
+		case "hidden":
+			f.hidden = true
+		case "all":
+			*f = allScopes
+		default:
+			return fmt.Errorf("unknown scope %q; want regular, definitions, hidden or all", part)
+		}
+	}
+	return nil
+}
+
+// scopeOf returns the kind of field at path p: hidden if any of its
+// selectors is hidden, definitions if any is a definition, and
+// regular otherwise.
+func scopeOf(p cue.Path) scopeFlag {
+	var s scopeFlag
+	for _, sel := range p.Selectors() {
+		switch sel.LabelType() {
+		case cue.HiddenLabel, cue.HiddenDefinitionLabel:
+			return scopeFlag{hidden: true}
+		case cue.DefinitionLabel:
+			s.definitions = true
+		}
+	}
+	if !s.definitions {
+		s.regular = true
+	}
+	return s
+}
+
+// analyze reports whether the disjunction at path p is in scope.
+func (f *scopeFlag) analyze(p cue.Path) bool {
+	s := scopeOf(p)
+	return s.regular && f.regular || s.definitions && f.definitions || s.hidden && f.hidden
+}
+
+// descend reports whether any field within the field at path p
+// might be in scope. Fields within a definition are in the
+// definitions scope unless they are hidden, and fields within
+// a hidden field are all hidden.
+func (f *scopeFlag) descend(p cue.Path) bool {
+	s := scopeOf(p)
+	switch {
+	case s.hidden:
+		return f.hidden
+	case s.definitions:
+		return f.definitions || f.hidden
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/parser"
+)
+
+// loneExpr reports whether the CUE in data, as read from standard
+// input, consists of a single expression such as {a!: int} | {b!: int}
+// along with any imports it needs, and if so returns the expression
+// formatted as CUE, for use in place of an -e expression.
+func loneExpr(data []byte) (string, bool) {
+	f, err := parser.ParseFile("-", data)
+	if err != nil {
+		// Leave the error to be reported by load.
+		return "", false
+	}
+	var expr ast.Expr
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.ImportDecl, *ast.CommentGroup, *ast.Attribute:
+		case *ast.EmbedDecl:
+			if expr != nil {
+				return "", false
+			}
+			expr = decl.Expr
+		default:
+			// A package clause or a field makes
+			// it a file like any other.
+			return "", false
+		}
+	}
+	if expr == nil {
+		return "", false
+	}
+	src, err := format.Node(expr)
+	if err != nil {
+		return "", false
+	}
+	return string(src), true
+}
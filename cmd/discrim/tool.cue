@@ -0,0 +1,49 @@
+package discrim
+
+// #ToolRequest describes the request read by discrim tool
+// from its standard input.
+#ToolRequest: {
+	// dir holds the directory that packages are
+	// resolved in, by default the current directory.
+	dir?: string
+
+	// packages holds the packages to analyze, as they would
+	// be given to discrim, by default the package in dir.
+	packages?: [...string]
+
+	// source holds CUE to analyze instead of packages.
+	source?: string
+
+	// expr holds an expression for a single disjunction to
+	// analyze, as given to discrim -e, evaluated in the context
+	// of the single package or the source.
+	expr?: string
+
+	// all reports whether to report on perfect
+	// disjunctions too, as for discrim -a.
+	all?: bool
+
+	// flags holds discrim flags that affect analysis, by name
+	// without the leading hyphen, such as {m: true, "max-depth": 3}.
+	// A list gives a flag that may be repeated, such as
+	// "exclude-path", once for each of its elements.
+	flags?: [string]: bool | number | string | [...string]
+}
+
+// #ToolResponse describes the response written by discrim tool
+// to its standard output.
+#ToolResponse: {
+	// reports holds a report on each disjunction, as printed by
+	// discrim -json: the fields of the cuediscrim #Report schema,
+	// along with the decision tree in the form described by the
+	// cuediscrim #Tree schema, as encodedTree.
+	reports!: [...{...}]
+
+	// imperfect holds the number of reports on
+	// disjunctions that are imperfect.
+	imperfect!: int & >=0
+
+	// errors holds a message for each failure, such
+	// as a package that can't be built.
+	errors?: [...string]
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/load"
+	"cuelang.org/go/cue/parser"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// toolSchema holds the CUE schema for the requests and
+// responses of the tool subcommand.
+//
+//go:embed tool.cue
+var toolSchema string
+
+// toolRequest holds a request read by the tool subcommand,
+// as described by #ToolRequest in tool.cue.
+type toolRequest struct {
+	Dir      string         `json:"dir,omitempty"`
+	Packages []string       `json:"packages,omitempty"`
+	Source   string         `json:"source,omitempty"`
+	Expr     string         `json:"expr,omitempty"`
+	All      bool           `json:"all,omitempty"`
+	Flags    map[string]any `json:"flags,omitempty"`
+}
+
+// toolResponse holds the response written by the tool subcommand,
+// as described by #ToolResponse in tool.cue.
+type toolResponse struct {
+	Reports   []jsonReport `json:"reports"`
+	Imperfect int          `json:"imperfect"`
+	Errors    []string     `json:"errors,omitempty"`
+}
+
+// toolFlags holds the names of the flags that a request may set.
+// Flags that affect only the form of the output are left out,
+// because the response always has the same form.
+var toolFlags = []string{
+	"M",
+	"cache-dir",
+	"closed",
+	"dedup",
+	"eval",
+	"exclude-path",
+	"fill",
+	"ignore-deprecated",
+	"include-path",
+	"m",
+	"match",
+	"max-depth",
+	"max-value-branches",
+	"optimize",
+	"optional-fields",
+	"p",
+	"scope",
+	"selfcontained",
+	"top-arms",
+}
+
+// runTool implements the tool subcommand, which reads a request
+// as JSON from standard input and writes the response as JSON to
+// standard output, for cue cmd scripts and other tools.
+func runTool(args []string) int {
+	fs := flag.NewFlagSet("tool", flag.ExitOnError)
+	schema := fs.Bool("schema", false, "print the CUE schema for requests and responses rather than reading a request")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: discrim tool < request.json\n")
+		fmt.Fprintf(os.Stderr, "       discrim tool -schema\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+The tool subcommand reads a request as JSON from standard input,
+analyzes the disjunctions that it asks for and writes a response as
+JSON to standard output, so that scripts such as those run by
+cue cmd with tool/exec can check their schemas and use the results
+without parsing the usual text. The request and the response are
+described by #ToolRequest and #ToolResponse in the schema printed
+by -schema. For example, the request
+
+	{"packages": ["./schemas"], "flags": {"m": true}}
+
+reports on the imperfect disjunctions in the package ./schemas,
+merging compatible arms. In a _tool.cue file, that might be
+
+	command: discrim: {
+		run: exec.Run & {
+			cmd:    ["discrim", "tool"]
+			stdin:  json.Marshal({packages: ["./schemas"], flags: m: true})
+			stdout: string
+		}
+		response: json.Unmarshal(run.stdout)
+		print: cli.Print & {
+			text: "\(response.imperfect) imperfect disjunctions"
+		}
+	}
+
+The exit status is zero whenever a response is written, even when
+some packages can't be analyzed, so that scripts can inspect it;
+failures are described by its errors field.
+`)
+		os.Exit(2)
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+	}
+	if *schema {
+		fmt.Print(toolSchema)
+		return 0
+	}
+	data, err := json.Marshal(tool(os.Stdin))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot marshal response: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%s\n", data)
+	return 0
+}
+
+// tool returns the response to the request read from r.
+func tool(r io.Reader) *toolResponse {
+	resp := &toolResponse{
+		Reports: []jsonReport{},
+	}
+	fail := func(err error) *toolResponse {
+		resp.Errors = append(resp.Errors, err.Error())
+		return resp
+	}
+	var req toolRequest
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		return fail(fmt.Errorf("cannot decode request: %v", err))
+	}
+	if err := req.setFlags(); err != nil {
+		return fail(err)
+	}
+	ctx := cuecontext.New()
+	var srcs []source
+	switch {
+	case req.Source != "":
+		if len(req.Packages) > 0 {
+			return fail(fmt.Errorf("request has both source and packages"))
+		}
+		srcs = []source{{
+			path: "source",
+			build: func(ctx *cue.Context) (cue.Value, error) {
+				v := ctx.CompileString(req.Source, cue.Filename("source.cue"))
+				if err := v.Err(); err != nil {
+					return cue.Value{}, fmt.Errorf("cannot build source: %v", err)
+				}
+				return preparePackage(ctx, v)
+			},
+		}}
+	default:
+		srcs = instanceSources(load.Instances(req.Packages, &load.Config{Dir: req.Dir}))
+	}
+	if req.Expr != "" {
+		if len(srcs) != 1 {
+			return fail(fmt.Errorf("expr requires exactly one package"))
+		}
+		rep, err := toolExpr(ctx, srcs[0], req.Expr)
+		if err != nil {
+			return fail(err)
+		}
+		resp.add(rep)
+		return resp
+	}
+	w := &walker{
+		collect: true,
+	}
+	for p := range packages(ctx, srcs, true) {
+		if p.err != nil {
+			resp.Errors = append(resp.Errors, p.err.Error())
+			continue
+		}
+		w.instPath = p.path
+		if !p.walked {
+			w.walk(p.pkg)
+			continue
+		}
+		for _, a := range p.found {
+			w.add(a.key, a.def, a.defInst, a.f)
+		}
+	}
+	for _, f := range w.findings {
+		r := result(f.v, f.result)
+		resp.add(jsonReport{
+			Report:      f.report(r),
+			EncodedTree: cuediscrim.Tree{DecisionNode: r.Tree},
+		})
+	}
+	return resp
+}
+
+// toolExpr returns the report on the disjunction
+// given by expr in the package built from src.
+func toolExpr(ctx *cue.Context, src source, expr string) (jsonReport, error) {
+	x, err := parser.ParseExpr("expression", expr)
+	if err != nil {
+		return jsonReport{}, fmt.Errorf("cannot parse expression: %v", err)
+	}
+	scope, err := src.build(ctx)
+	if err != nil {
+		return jsonReport{}, err
+	}
+	v := ctx.BuildExpr(x, cue.Scope(scope), cue.InferBuiltins(true))
+	if err := v.Err(); err != nil {
+		return jsonReport{}, fmt.Errorf("cannot build expression: %v", err)
+	}
+	arms := cuediscrim.Disjunctions(v)
+	r := result(v, discriminate(arms, cuediscrim.ArmNames(v), false))
+	return jsonReport{
+		Report:      newReport(r, src.path, expr, v),
+		EncodedTree: cuediscrim.Tree{DecisionNode: r.Tree},
+	}, nil
+}
+
+// add adds rep to the response.
+func (resp *toolResponse) add(rep jsonReport) {
+	resp.Reports = append(resp.Reports, rep)
+	if !rep.Perfect {
+		resp.Imperfect++
+	}
+}
+
+// setFlags sets the flags given by the request.
+func (req *toolRequest) setFlags() error {
+	*flagAll = req.All
+	for name, value := range req.Flags {
+		if !slices.Contains(toolFlags, name) {
+			return fmt.Errorf("flag %q cannot be set by a request", name)
+		}
+		values, ok := value.([]any)
+		if !ok {
+			values = []any{value}
+		}
+		for _, v := range values {
+			s := fmt.Sprint(v)
+			if n, ok := v.(json.Number); ok {
+				// Use the number as written, so that large
+				// integers are not printed in exponent form.
+				s = n.String()
+			}
+			if err := flag.Set(name, s); err != nil {
+				return fmt.Errorf("invalid value %v for flag %q: %v", v, name, err)
+			}
+		}
+	}
+	return parseModes()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+// TestToolLargeIntFlag checks that an integer flag value in a
+// request is passed to the flag as written, not in exponent form.
+func TestToolLargeIntFlag(t *testing.T) {
+	oldMaxDepth := *flagMaxDepth
+	oldAll := *flagAll
+	defer func() {
+		*flagMaxDepth = oldMaxDepth
+		*flagAll = oldAll
+	}()
+	resp := tool(strings.NewReader(`{
+	"source": "x: {a!: 1} | {a!: 2}",
+	"flags": {"max-depth": 1000000}
+}`))
+	qt.Assert(t, qt.HasLen(resp.Errors, 0))
+	qt.Assert(t, qt.Equals(*flagMaxDepth, 1000000))
+}
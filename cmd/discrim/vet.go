@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/load"
+	"cuelang.org/go/cue/parser"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// runVet implements the vet subcommand, which classifies
+// data files using the decision tree for a disjunction and then
+// validates each file against the arm it has been classified as.
+func runVet(args []string) int {
+	fs := flag.NewFlagSet("vet", flag.ExitOnError)
+	expr := fs.String("e", "", "expression for the disjunction to validate against (required)")
+	injectDefaults := fs.Bool("inject-defaults", false, "classify data lacking a tag field as if it held the tag's default value")
+	summary := fs.Bool("summary", false, "print the number of documents classified as each arm")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: discrim vet -e expr package data-file...\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+The vet subcommand uses the decision tree for the disjunction in the
+given expression to choose an arm for each data file, and then
+validates the data against that arm only. It reports both
+classification failures and validation errors.
+
+Data files may be JSON, YAML or CUE. A YAML file holding several
+documents separated by "---" lines, or a JSON file holding a stream
+of values, is treated as a sequence of documents, each of which is
+checked separately.
+`)
+		os.Exit(2)
+	}
+	fs.Parse(args)
+	if *expr == "" || fs.NArg() < 2 {
+		fs.Usage()
+	}
+	x, err := parser.ParseExpr("expression", *expr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot parse expression: %v\n", err)
+		return 1
+	}
+	ctx := cuecontext.New()
+	insts := load.Instances(fs.Args()[:1], nil)
+	scope := ctx.BuildInstance(insts[0])
+	if err := scope.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot build instance: %v\n", err)
+		return 1
+	}
+	v := ctx.BuildExpr(x, cue.Scope(scope), cue.InferBuiltins(true))
+	if err := v.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot build expression: %v\n", err)
+		return 1
+	}
+	arms := cuediscrim.Disjunctions(v)
+	tree, _, _ := cuediscrim.Discriminate(arms)
+
+	var names []string
+	var docs []cue.Value
+	exitCode := 0
+	for _, filename := range fs.Args()[1:] {
+		fileDocs, err := loadDocuments(ctx, filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exitCode = 1
+			continue
+		}
+		for i, doc := range fileDocs {
+			name := filename
+			if len(fileDocs) > 1 {
+				name = fmt.Sprintf("%s:doc %d", filename, i)
+			}
+			names = append(names, name)
+			docs = append(docs, doc)
+		}
+	}
+	c := cuediscrim.ClassifyDocuments(tree, docs, cuediscrim.InjectDefaults(*injectDefaults))
+	for i, r := range c.Documents {
+		if !vetData(names[i], docs[i], arms, r.Arms()) {
+			exitCode = 1
+		}
+	}
+	if *summary {
+		printDocumentStats(c.Stats)
+	}
+	return exitCode
+}
+
+// printDocumentStats prints the number of documents
+// classified as each arm.
+func printDocumentStats(st cuediscrim.DocumentStats) {
+	fmt.Printf("%d documents:", st.Documents)
+	for _, arm := range slices.Sorted(maps.Keys(st.ByArm)) {
+		fmt.Printf(" arm %d: %d;", arm, st.ByArm[arm])
+	}
+	fmt.Printf(" ambiguous: %d; unmatched: %d\n", st.Ambiguous, st.Unmatched)
+}
+
+// vetData validates a single data value against the arms
+// chosen for it, reporting whether it was valid.
+func vetData(filename string, data cue.Value, arms []cue.Value, chosen cuediscrim.IntSet) bool {
+	switch chosen.Len() {
+	case 0:
+		fmt.Printf("%s: no arm matches\n", filename)
+		return false
+	case 1:
+	default:
+		fmt.Printf("%s: cannot choose between arms %s; validating against each\n", filename, cuediscrim.SetString(chosen))
+	}
+	var valid []int
+	errs := make(map[int]error)
+	for _, i := range slices.Sorted(chosen.Values()) {
+		if err := arms[i].Unify(data).Validate(cue.Concrete(true)); err != nil {
+			errs[i] = err
+			continue
+		}
+		valid = append(valid, i)
+	}
+	if len(valid) > 0 {
+		for _, i := range valid {
+			fmt.Printf("%s: ok (arm %d%s)\n", filename, i, armPos(arms[i]))
+		}
+		return true
+	}
+	for _, i := range slices.Sorted(chosen.Values()) {
+		fmt.Printf("%s: invalid for arm %d%s:\n", filename, i, armPos(arms[i]))
+		errors.Print(os.Stdout, errs[i], nil)
+	}
+	return false
+}
+
+func armPos(arm cue.Value) string {
+	if pos := arm.Pos(); pos.IsValid() {
+		return " at " + pos.String()
+	}
+	return ""
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/token"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/load"
+	"cuelang.org/go/cue/parser"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+var (
+	flagExpr            = flag.String("e", "", "expression for the disjunction to generate code for (required unless -tags is specified)")
+	flagLang            = flag.String("lang", "go", "language of the generated code: go, ts (TypeScript) or proto (a suggested protobuf oneof)")
+	flagPackage         = flag.String("pkg", "discrim", "with -lang go, package name of the generated code")
+	flagFunc            = flag.String("func", "", "name of the generated function (default Discriminate for Go, discriminate for TypeScript)")
+	flagMessage         = flag.String("message", "", "with -lang proto, name of the message holding the oneof (default derived from -e)")
+	flagTypesFrom       = flag.String("types-from", "", "with -lang ts, import the types named by the type guards from this module")
+	flagOutput          = flag.String("o", "", "write the generated code to this file rather than standard output")
+	flagTags            = flag.Bool("tags", false, "generate Go types and constants for the tag values of the disjunctions rather than a function")
+	flagMergeCompatible = flag.Bool("m", false, "merge compatible data types if a perfect discriminator cannot be found")
+	flagEnum            = flag.String("enum", "auto", "with -lang go or ts, how to find the case for a value in a switch on its value: auto, switch, map or binary-search")
+	flagEnumThreshold   = flag.Int("enum-threshold", cuediscrim.DefaultEnumThreshold, "with -enum auto, the number of cases above which a switch on a value becomes a map lookup")
+	flagRouter          = flag.String("router", "", "generate an HTTP router for the endpoints described in this JSON `file` rather than a function")
+	flagChi             = flag.Bool("chi", false, "with -router, register the handlers with a chi.Router rather than a net/http.ServeMux")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: discrimgen -e expr [flags] [package]\n")
+		fmt.Fprintf(os.Stderr, "       discrimgen -tags [-e expr] [flags] [package]\n")
+		fmt.Fprintf(os.Stderr, "       discrimgen -router file [flags] [package]\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+discrimgen generates a function that returns the indexes of the
+arms of the disjunction selected by -e that a JSON value is
+classified as, using the same decision tree as the discrim command.
+The expression is evaluated in the context of the named package.
+
+With -lang go, when any arm is a reference to a definition, a
+table holding the name of each arm is generated too. The generated
+code uses only the Go standard library.
+
+With -lang ts, a TypeScript type guard is generated for each arm
+that is a reference to a definition, named after the definition,
+so an arm #Foo has the guard isFoo(x: unknown): x is Foo.
+
+With -lang proto, a protobuf message is suggested holding a oneof
+with a field for each arm, named after the value of the field that
+tells the arms apart where possible, for help in migrating the
+disjunction to protobuf.
+
+With -tags, Go code is generated declaring a type for each field
+that a disjunction is told apart by the value of, with a constant
+for each of its values, so that code need not use string literals
+for them. This is done for every definition in the package that is
+a disjunction, or just for the -e expression if specified.
+
+With -router, Go code is generated for an HTTP routing layer for
+the endpoints described in the given JSON file, which holds a list
+of objects such as
+
+	{
+		"name": "CreateShape",
+		"method": "POST",
+		"path": "/shapes",
+		"union": "#Shape",
+		"types": {"#Circle": "example.com/shapes.Circle", "square": "example.com/shapes.Square"}
+	}
+
+where union is an expression for the disjunction that the request
+body is an instance of and types maps its arms, by name or by a tag
+value that selects them, to Go types, as for discrim -go-types. The
+handler for each endpoint decides which arm the body is an instance
+of, decodes it into the Go type of that arm and passes it to the
+handler function for that type. RegisterRoutes registers the handlers
+with a net/http.ServeMux, or with a chi.Router with -chi.
+`)
+		os.Exit(2)
+	}
+	flag.Parse()
+	if (*flagExpr == "" && !*flagTags && *flagRouter == "") || flag.NArg() > 1 {
+		flag.Usage()
+	}
+	log.SetFlags(0)
+	log.SetPrefix("discrimgen: ")
+	enumStrategy, err := cuediscrim.ParseEnumStrategy(*flagEnum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := cuecontext.New()
+	insts := load.Instances(flag.Args(), nil)
+	scope := ctx.BuildInstance(insts[0])
+	if err := scope.Err(); err != nil {
+		log.Fatalf("cannot build instance: %v", err)
+	}
+	if *flagRouter != "" {
+		output(generateRouter(ctx, scope))
+		return
+	}
+	if *flagTags && *flagExpr == "" {
+		output(generateTags(definitionUnions(scope)))
+		return
+	}
+	expr, err := parser.ParseExpr("expression", *flagExpr)
+	if err != nil {
+		log.Fatalf("cannot parse expression: %v", err)
+	}
+	v := ctx.BuildExpr(expr, cue.Scope(scope), cue.InferBuiltins(true))
+	if err := v.Err(); err != nil {
+		log.Fatalf("cannot build expression: %v", err)
+	}
+	r := cuediscrim.DiscriminateValue(v,
+		cuediscrim.MergeCompatible(*flagMergeCompatible),
+		cuediscrim.Enums(enumStrategy, *flagEnumThreshold),
+	)
+	if !r.Perfect {
+		log.Printf("warning: discriminator for %s is not perfect", *flagExpr)
+	}
+	if *flagTags {
+		output(generateTags([]cuediscrim.GoTagUnion{{
+			Name:  *flagExpr,
+			Tree:  r.Tree,
+			Names: r.Names,
+		}}))
+		return
+	}
+	var src []byte
+	switch *flagLang {
+	case "go":
+		src, err = generateGo(r)
+	case "ts":
+		src, err = generateTypeScript(r)
+	case "proto":
+		src, err = generateProto(r)
+	default:
+		log.Fatalf("unknown language %q; want go, ts or proto", *flagLang)
+	}
+	output(src, err)
+}
+
+// output writes the generated code src to the place
+// specified by the -o flag, or fails if err is non-nil.
+func output(src []byte, err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *flagOutput == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*flagOutput, src, 0o666); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func generateGo(r *cuediscrim.Result) ([]byte, error) {
+	opts := []cuediscrim.GoOption{
+		cuediscrim.GoPackage(*flagPackage),
+		cuediscrim.GoGeneratedBy("discrimgen"),
+	}
+	if *flagFunc != "" {
+		opts = append(opts, cuediscrim.GoFunc(*flagFunc))
+	}
+	if slices.ContainsFunc(r.Names, func(name string) bool { return name != "" }) {
+		opts = append(opts, cuediscrim.GoArmNames(r.Names))
+	}
+	return cuediscrim.GenerateGo(r.Tree, opts...)
+}
+
+func generateTypeScript(r *cuediscrim.Result) ([]byte, error) {
+	opts := []cuediscrim.TSOption{
+		cuediscrim.TSGeneratedBy("discrimgen"),
+	}
+	if *flagFunc != "" {
+		opts = append(opts, cuediscrim.TSFunc(*flagFunc))
+	}
+	if *flagTypesFrom != "" {
+		opts = append(opts, cuediscrim.TSTypesFrom(*flagTypesFrom))
+	}
+	return cuediscrim.GenerateTypeScript(r.Tree, r.Names, opts...)
+}
+
+func generateProto(r *cuediscrim.Result) ([]byte, error) {
+	message := *flagMessage
+	if message == "" {
+		// Use the final element of the expression
+		// when it's a path such as #Shape.
+		if sels := cue.ParsePath(*flagExpr).Selectors(); len(sels) > 0 {
+			message = strings.TrimPrefix(sels[len(sels)-1].String(), "#")
+		}
+	}
+	if !token.IsIdentifier(message) || strings.HasPrefix(message, "_") {
+		return nil, fmt.Errorf("cannot derive message name from %q; use -message", *flagExpr)
+	}
+	return cuediscrim.SuggestProtoOneof(r, message).Proto(), nil
+}
+
+func generateTags(unions []cuediscrim.GoTagUnion) ([]byte, error) {
+	return cuediscrim.GenerateGoTags(unions,
+		cuediscrim.GoPackage(*flagPackage),
+		cuediscrim.GoGeneratedBy("discrimgen"),
+	)
+}
+
+// definitionUnions returns a union for each definition
+// within v that is a disjunction.
+func definitionUnions(v cue.Value) []cuediscrim.GoTagUnion {
+	var unions []cuediscrim.GoTagUnion
+	iter, err := v.Fields(cue.Definitions(true))
+	if err != nil {
+		return nil
+	}
+	for iter.Next() {
+		v := iter.Value()
+		if iter.Selector().IsDefinition() && len(cuediscrim.Disjunctions(v)) > 1 {
+			r := cuediscrim.DiscriminateValue(v, cuediscrim.MergeCompatible(*flagMergeCompatible))
+			unions = append(unions, cuediscrim.GoTagUnion{
+				Name:  v.Path().String(),
+				Tree:  r.Tree,
+				Names: r.Names,
+			})
+		}
+		if v.IncompleteKind()&cue.StructKind != 0 {
+			unions = append(unions, definitionUnions(v)...)
+		}
+	}
+	return unions
+}
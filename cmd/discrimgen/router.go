@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/parser"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// route holds an endpoint as described in the -router file.
+type route struct {
+	Name   string            `json:"name"`
+	Method string            `json:"method"`
+	Path   string            `json:"path"`
+	Union  string            `json:"union"`
+	Types  map[string]string `json:"types"`
+}
+
+// generateRouter generates an HTTP router for the endpoints described
+// in the -router file, evaluating the union of each in scope.
+func generateRouter(ctx *cue.Context, scope cue.Value) ([]byte, error) {
+	data, err := os.ReadFile(*flagRouter)
+	if err != nil {
+		return nil, err
+	}
+	var routes []route
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("%s: %v", *flagRouter, err)
+	}
+	goRoutes := make([]cuediscrim.GoRoute, len(routes))
+	for i, rt := range routes {
+		expr, err := parser.ParseExpr("union", rt.Union)
+		if err != nil {
+			return nil, fmt.Errorf("%s: cannot parse union: %v", rt.Name, err)
+		}
+		v := ctx.BuildExpr(expr, cue.Scope(scope), cue.InferBuiltins(true))
+		if err := v.Err(); err != nil {
+			return nil, fmt.Errorf("%s: cannot build union: %v", rt.Name, err)
+		}
+		goRoutes[i] = cuediscrim.GoRoute{
+			Name:   rt.Name,
+			Method: rt.Method,
+			Path:   rt.Path,
+			Union: cuediscrim.GoTypeUnion{
+				Name:   rt.Union,
+				Result: cuediscrim.DiscriminateValue(v, cuediscrim.MergeCompatible(*flagMergeCompatible)),
+				Types:  rt.Types,
+			},
+		}
+	}
+	return cuediscrim.GenerateGoRouter(goRoutes,
+		cuediscrim.GoPackage(*flagPackage),
+		cuediscrim.GoGeneratedBy("discrimgen"),
+		cuediscrim.GoChiRouter(*flagChi),
+	)
+}
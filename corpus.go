@@ -0,0 +1,149 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"cuelang.org/go/cue"
+)
+
+// CorpusReport holds the result of [CheckCorpus].
+type CorpusReport struct {
+	// Documents holds the number of documents checked.
+	Documents int
+
+	// Mismatches holds the documents for which the decision tree
+	// does not choose exactly the arms that they validate against,
+	// in the order they were found.
+	Mismatches []CorpusMismatch
+}
+
+// CorpusMismatch describes a document for which the decision tree
+// disagrees with validation.
+type CorpusMismatch struct {
+	// File holds the name of the file holding the document,
+	// relative to the corpus directory.
+	File string
+
+	// Index holds the index of the document within the file,
+	// which is always zero unless the file holds a stream of
+	// documents.
+	Index int
+
+	// Chosen holds the arms chosen by the decision tree.
+	Chosen IntSet
+
+	// Valid holds the arms that the document validates against.
+	Valid IntSet
+}
+
+func (m CorpusMismatch) String() string {
+	return fmt.Sprintf("%s#%d: tree chooses %v but document validates against %v", m.File, m.Index, SetString(m.Chosen), SetString(m.Valid))
+}
+
+// CheckCorpus checks the decision tree for the disjunction schema,
+// analyzed with the given options, against a corpus of example
+// documents: the files in the directory dir and its subdirectories
+// with the extension .json, .jsonl, .ndjson, .yaml, .yml or .cue.
+// JSON and YAML files may hold streams of documents, as read by
+// [JSONDocuments] and [YAMLDocuments]. Other files are ignored.
+//
+// For each document, the arms chosen by the tree are compared with
+// the arms that the document validates against, as cue vet would
+// check them, and any difference is reported as a mismatch. This
+// includes documents that validate against no arm but that the tree
+// chooses an arm for, so a corpus is expected to hold valid
+// documents only. Run regularly, as from a test (see the
+// cuediscrimtest package), it catches changes to the schema or to
+// the analysis that make the tree disagree with CUE itself.
+//
+// An error is returned only if the corpus cannot be read.
+func CheckCorpus(schema cue.Value, dir string, opts ...Option) (*CorpusReport, error) {
+	if err := schema.Err(); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	r := DiscriminateValue(schema, opts...)
+	ctx := schema.Context()
+	report := &CorpusReport{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		docs, err := corpusDocuments(ctx, path)
+		if err != nil || docs == nil {
+			return err
+		}
+		file, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		file = filepath.ToSlash(file)
+		for i, doc := range docs {
+			report.Documents++
+			chosen := r.Tree.Check(doc)
+			valid := validArms(r.Arms, doc)
+			if !mapSetOf(chosen.Values()).Equal(valid) {
+				report.Mismatches = append(report.Mismatches, CorpusMismatch{
+					File:   file,
+					Index:  i,
+					Chosen: chosen,
+					Valid:  valid,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// corpusDocuments returns the documents in the corpus file with
+// the given name, or nil if it isn't a kind of file that a corpus
+// holds.
+func corpusDocuments(ctx *cue.Context, filename string) ([]cue.Value, error) {
+	ext := filepath.Ext(filename)
+	switch ext {
+	case ".json", ".jsonl", ".ndjson", ".yaml", ".yml", ".cue":
+	default:
+		return nil, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var docs []cue.Value
+	switch ext {
+	case ".yaml", ".yml":
+		docs, err = YAMLDocuments(ctx, data)
+	case ".cue":
+		v := ctx.CompileBytes(data, cue.Filename(filename))
+		docs, err = []cue.Value{v}, v.Err()
+	default:
+		docs, err = JSONDocuments(ctx, filename, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if docs == nil {
+		docs = []cue.Value{}
+	}
+	return docs, nil
+}
+
+// validArms returns the arms that doc validates against.
+func validArms(arms []cue.Value, doc cue.Value) mapSet[int] {
+	valid := make(mapSet[int])
+	for i, arm := range arms {
+		if arm.Unify(doc).Validate(cue.Concrete(true)) == nil {
+			valid[i] = true
+		}
+	}
+	return valid
+}
@@ -0,0 +1,68 @@
+package cuediscrim
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestCheckCorpus(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"circle.json": `{"type": "circle", "r": 1}`,
+		"stream.jsonl": `{"type": "square", "side": 2}
+{"type": "circle", "r": 1, "side": 2}
+`,
+		"sub/shapes.yaml": `type: square
+side: 3
+---
+type: circle
+`,
+		"sub/square.cue": `type: "square", side: 4`,
+		"README.md":      `not a document`,
+	})
+	v := cuecontext.New().CompileString(`
+#Circle: {type!: "circle", r!: number}
+#Square: {type!: "square", side!: number}
+#Shape: #Circle | #Square
+`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	r, err := CheckCorpus(v.LookupPath(cue.ParsePath("#Shape")), dir)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(r.Documents, 6))
+	var mismatches []string
+	for _, m := range r.Mismatches {
+		mismatches = append(mismatches, m.String())
+	}
+	// The definitions are closed, so the circle with a side is
+	// not valid, and the circle without a radius isn't either,
+	// but the tree switches on the type alone.
+	qt.Check(t, qt.DeepEquals(mismatches, []string{
+		"stream.jsonl#1: tree chooses {0} but document validates against {}",
+		"sub/shapes.yaml#1: tree chooses {0} but document validates against {}",
+	}))
+}
+
+func TestCheckCorpusError(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"bad.json": `{"type": `,
+	})
+	v := cuecontext.New().CompileString(`{a!: int} | {b!: int}`)
+	_, err := CheckCorpus(v, dir)
+	qt.Assert(t, qt.ErrorMatches(err, `.*bad.json: .*`))
+}
+
+// writeFiles writes the given files, keyed by slash-separated
+// name, within dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		qt.Assert(t, qt.IsNil(os.MkdirAll(filepath.Dir(path), 0o777)))
+		qt.Assert(t, qt.IsNil(os.WriteFile(path, []byte(data), 0o666)))
+	}
+}
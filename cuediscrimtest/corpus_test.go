@@ -0,0 +1,24 @@
+package cuediscrimtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestAssertCorpus(t *testing.T) {
+	AssertCorpus(t, cuecontext.New().CompileString(schema), filepath.Join("testdata", "corpus"))
+}
+
+func TestAssertCorpusMismatch(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "x.json"), []byte(`{"kind": "a"}`), 0o666)
+	qt.Assert(t, qt.IsNil(err))
+	msg := failure(func(t testing.TB) {
+		AssertCorpus(t, cuecontext.New().CompileString(schema), dir)
+	})
+	qt.Assert(t, qt.Equals(msg, "decision tree disagrees with validation for 1 of 1 documents in "+dir+":\nx.json#0: tree chooses {0} but document validates against {}\n"))
+}
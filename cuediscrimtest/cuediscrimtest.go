@@ -0,0 +1,95 @@
+// Package cuediscrimtest provides helpers for checking
+// discrimination properties of CUE schemas from Go tests.
+//
+// Each helper takes the disjunction to be analyzed as a CUE
+// value; its arms are obtained with [cuediscrim.Disjunctions].
+package cuediscrimtest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// AssertPerfect fails the test if the discriminator for schema
+// is not perfect.
+func AssertPerfect(t testing.TB, schema cue.Value, opts ...cuediscrim.Option) {
+	t.Helper()
+	r := analyze(t, schema, opts)
+	if !r.Perfect {
+		t.Fatalf("discriminator is not perfect:\n%s", cuediscrim.NodeString(r.Tree))
+	}
+}
+
+// AssertDiscriminator fails the test if the root of the
+// decision tree for schema does not switch on the field
+// at the given path (for example "kind" or "meta.type").
+// Use "." for the value itself.
+func AssertDiscriminator(t testing.TB, schema cue.Value, path string, opts ...cuediscrim.Option) {
+	t.Helper()
+	r := analyze(t, schema, opts)
+	got, ok := rootPath(r.Tree)
+	if !ok {
+		t.Fatalf("decision tree does not switch on a field; want %q:\n%s", path, cuediscrim.NodeString(r.Tree))
+	}
+	if got != path {
+		t.Fatalf("decision tree switches on %q; want %q:\n%s", got, path, cuediscrim.NodeString(r.Tree))
+	}
+}
+
+// AssertClassifies fails the test if the decision tree for schema
+// does not choose exactly the arm with index wantArm for data.
+func AssertClassifies(t testing.TB, schema, data cue.Value, wantArm int, opts ...cuediscrim.Option) {
+	t.Helper()
+	r := analyze(t, schema, opts)
+	got := r.Tree.Check(data)
+	if got.Len() != 1 || !got.Has(wantArm) {
+		t.Fatalf("data classified as %s; want {%d}:\n%s", cuediscrim.SetString(got), wantArm, cuediscrim.NodeString(r.Tree))
+	}
+}
+
+// AssertCorpus fails the test if the decision tree for schema
+// disagrees with validation for any of the example documents in
+// the directory dir, as reported by [cuediscrim.CheckCorpus]:
+// the tree must choose exactly the arms that each document
+// validates against.
+func AssertCorpus(t testing.TB, schema cue.Value, dir string, opts ...cuediscrim.Option) {
+	t.Helper()
+	r, err := cuediscrim.CheckCorpus(schema, dir, opts...)
+	if err != nil {
+		t.Fatalf("cannot check corpus: %v", err)
+	}
+	if len(r.Mismatches) == 0 {
+		return
+	}
+	var buf strings.Builder
+	for _, m := range r.Mismatches {
+		fmt.Fprintf(&buf, "%v\n", m)
+	}
+	t.Fatalf("decision tree disagrees with validation for %d of %d documents in %s:\n%s", len(r.Mismatches), r.Documents, dir, buf.String())
+}
+
+func analyze(t testing.TB, schema cue.Value, opts []cuediscrim.Option) *cuediscrim.Result {
+	t.Helper()
+	if err := schema.Err(); err != nil {
+		t.Fatalf("invalid schema: %v", err)
+	}
+	return cuediscrim.Analyze(cuediscrim.Disjunctions(schema), opts...)
+}
+
+// rootPath returns the path switched on by the root of the tree.
+func rootPath(n cuediscrim.DecisionNode) (string, bool) {
+	switch n := n.(type) {
+	case *cuediscrim.OptionalNode:
+		return rootPath(n.Present)
+	case *cuediscrim.KindSwitchNode:
+		return n.Path, true
+	case *cuediscrim.ValueSwitchNode:
+		return n.Path, true
+	}
+	return "", false
+}
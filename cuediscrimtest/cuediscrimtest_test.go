@@ -0,0 +1,97 @@
+package cuediscrimtest
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+const schema = `
+{kind!: "a", x!: int} |
+{kind!: "b", y!: string}
+`
+
+const imperfectSchema = `{a!: int} | {b!: string}`
+
+func TestAssertions(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(schema)
+	AssertPerfect(t, v)
+	AssertDiscriminator(t, v, "kind")
+	AssertClassifies(t, v, ctx.CompileString(`{kind: "b", y: "foo"}`), 1)
+}
+
+var failureTests = []struct {
+	testName string
+	f        func(t testing.TB)
+	want     string
+}{{
+	testName: "NotPerfect",
+	f: func(t testing.TB) {
+		AssertPerfect(t, cuecontext.New().CompileString(imperfectSchema))
+	},
+	want: "discriminator is not perfect:\nallOf {\n\tnotPresent(a) -> {1}\n\tnotPresent(b) -> {0}\n}\n",
+}, {
+	testName: "WrongDiscriminator",
+	f: func(t testing.TB) {
+		AssertDiscriminator(t, cuecontext.New().CompileString(schema), "x")
+	},
+	want: "decision tree switches on \"kind\"; want \"x\":\nswitch kind {\ncase \"a\":\n\tchoose({0})\ncase \"b\":\n\tchoose({1})\ndefault:\n\terror\n}\n",
+}, {
+	testName: "NoDiscriminator",
+	f: func(t testing.TB) {
+		AssertDiscriminator(t, cuecontext.New().CompileString(imperfectSchema), "a")
+	},
+	want: "decision tree does not switch on a field; want \"a\":\nallOf {\n\tnotPresent(a) -> {1}\n\tnotPresent(b) -> {0}\n}\n",
+}, {
+	testName: "WrongArm",
+	f: func(t testing.TB) {
+		ctx := cuecontext.New()
+		AssertClassifies(t, ctx.CompileString(schema), ctx.CompileString(`{kind: "a"}`), 1)
+	},
+	want: "data classified as {0}; want {1}:\nswitch kind {\ncase \"a\":\n\tchoose({0})\ncase \"b\":\n\tchoose({1})\ndefault:\n\terror\n}\n",
+}, {
+	testName: "InvalidSchema",
+	f: func(t testing.TB) {
+		AssertPerfect(t, cuecontext.New().CompileString(`1 & 2`))
+	},
+	want: "invalid schema: conflicting values 2 and 1",
+}}
+
+func TestAssertionFailures(t *testing.T) {
+	for _, test := range failureTests {
+		t.Run(test.testName, func(t *testing.T) {
+			qt.Assert(t, qt.Equals(failure(test.f), test.want))
+		})
+	}
+}
+
+// failure calls f and returns the message passed to Fatalf,
+// or the empty string if it did not fail.
+func failure(f func(t testing.TB)) (msg string) {
+	rt := &recorder{}
+	done := make(chan struct{})
+	// Run in a separate goroutine so that Fatalf
+	// can exit with runtime.Goexit as testing.T does.
+	go func() {
+		defer close(done)
+		f(rt)
+	}()
+	<-done
+	return rt.msg
+}
+
+type recorder struct {
+	testing.TB
+	msg string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Fatalf(f string, a ...any) {
+	r.msg = fmt.Sprintf(f, a...)
+	runtime.Goexit()
+}
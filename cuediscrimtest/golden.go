@@ -0,0 +1,102 @@
+package cuediscrimtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// updateEnv holds the name of the environment variable that
+// causes [AssertGolden] to write golden files.
+const updateEnv = "CUEDISCRIMTEST_UPDATE"
+
+// updateGolden reports whether golden files should be written
+// rather than checked: when the environment variable named by
+// updateEnv holds a true value such as 1, or when the test binary
+// defines a boolean -update flag, following the common convention
+// for golden files, and it is set. This package doesn't define the
+// flag itself, so that it doesn't clash with one defined by a test.
+func updateGolden() bool {
+	if ok, _ := strconv.ParseBool(os.Getenv(updateEnv)); ok {
+		return true
+	}
+	f := flag.Lookup("update")
+	if f == nil {
+		return false
+	}
+	g, ok := f.Value.(flag.Getter)
+	if !ok {
+		return false
+	}
+	update, _ := g.Get().(bool)
+	return update
+}
+
+// AssertGolden fails the test if the serialized decision tree for
+// schema (see [GoldenTree]) does not match the contents of the
+// golden file with the given name. When the CUEDISCRIMTEST_UPDATE
+// environment variable is set to 1, or the test binary defines an
+// -update flag and it is specified, the file is written instead,
+// creating any parent directories as needed.
+func AssertGolden(t testing.TB, schema cue.Value, filename string, opts ...cuediscrim.Option) {
+	t.Helper()
+	r := analyze(t, schema, opts)
+	got, err := GoldenTree(r.Tree)
+	if err != nil {
+		t.Fatalf("cannot encode tree: %v", err)
+	}
+	if updateGolden() {
+		if err := os.MkdirAll(filepath.Dir(filename), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, got, 0o666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("golden file %s does not exist; run with %s=1 to create it", filename, updateEnv)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("decision tree does not match %s (-want +got):\n%s\nrun with %s=1 to update the golden file",
+			filename, cmp.Diff(lines(want), lines(got)), updateEnv)
+	}
+}
+
+// GoldenTree returns the form of the given tree as stored by
+// [AssertGolden]: the encoding produced by [cuediscrim.EncodeTree],
+// indented with tabs and with a trailing newline, so that changes
+// show up clearly in line-based diffs. The result is deterministic
+// for a given tree.
+func GoldenTree(n cuediscrim.DecisionNode) ([]byte, error) {
+	data, err := cuediscrim.EncodeTree(n)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "\t"); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func lines(data []byte) []string {
+	return strings.SplitAfter(string(data), "\n")
+}
@@ -0,0 +1,71 @@
+package cuediscrimtest
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+
+	"github.com/rogpeppe/cuediscrim"
+)
+
+// update is found by AssertGolden, so that "go test -update"
+// rewrites the golden files of this package.
+var update = flag.Bool("update", false, "update golden files")
+
+func TestAssertGolden(t *testing.T) {
+	AssertGolden(t, cuecontext.New().CompileString(schema), filepath.Join("testdata", "schema.golden"))
+}
+
+func TestGoldenTreeRoundTrip(t *testing.T) {
+	tree, _, _ := cuediscrim.Discriminate(cuediscrim.Disjunctions(cuecontext.New().CompileString(schema)))
+	data, err := GoldenTree(tree)
+	qt.Assert(t, qt.IsNil(err))
+	tree1, err := cuediscrim.DecodeTree(data)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(cuediscrim.NodeString(tree1), cuediscrim.NodeString(tree)))
+}
+
+func TestAssertGoldenMismatch(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "x.golden")
+	err := os.WriteFile(filename, []byte("{}\n"), 0o666)
+	qt.Assert(t, qt.IsNil(err))
+	msg := failure(func(t testing.TB) {
+		AssertGolden(t, cuecontext.New().CompileString(schema), filename)
+	})
+	qt.Assert(t, qt.StringContains(msg, "decision tree does not match "+filename))
+	qt.Assert(t, qt.StringContains(msg, "run with CUEDISCRIMTEST_UPDATE=1"))
+}
+
+func TestAssertGoldenMissing(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "missing.golden")
+	msg := failure(func(t testing.TB) {
+		AssertGolden(t, cuecontext.New().CompileString(schema), filename)
+	})
+	qt.Assert(t, qt.Equals(msg, "golden file "+filename+" does not exist; run with CUEDISCRIMTEST_UPDATE=1 to create it"))
+}
+
+func TestAssertGoldenUpdate(t *testing.T) {
+	t.Setenv(updateEnv, "1")
+	filename := filepath.Join(t.TempDir(), "sub", "x.golden")
+	AssertGolden(t, cuecontext.New().CompileString(schema), filename)
+	data, err := os.ReadFile(filename)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.IsTrue(strings.HasPrefix(string(data), "{\n\t\"version\": ")))
+}
+
+func TestAssertGoldenUpdateFlag(t *testing.T) {
+	old := *update
+	*update = true
+	defer func() {
+		*update = old
+	}()
+	filename := filepath.Join(t.TempDir(), "x.golden")
+	AssertGolden(t, cuecontext.New().CompileString(schema), filename)
+	_, err := os.Stat(filename)
+	qt.Assert(t, qt.IsNil(err))
+}
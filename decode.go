@@ -0,0 +1,195 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"slices"
+
+	"cuelang.org/go/cue"
+)
+
+// Decoder decides which arm of a disjunction a value is an
+// instance of, using a decision tree for the arms and, optionally,
+// validating the value against the arm. It is safe to use
+// concurrently.
+type Decoder struct {
+	arms  []cue.Value
+	tree  DecisionNode
+	opts  decodeOptions
+	copts checkOptions
+}
+
+// DecodeOption configures a [Decoder].
+type DecodeOption func(*decodeOptions)
+
+type decodeOptions struct {
+	validate bool
+	names    []string
+	check    []CheckOption
+}
+
+// DecodeValidate causes [Decoder.Decode] to unify the value with the
+// arm chosen by the tree and to fail unless the result is a concrete
+// instance of the arm. When the tree chooses several arms, they are
+// tried in the order given by [LeafNode.Ordered], and the first that
+// the value is an instance of is chosen.
+func DecodeValidate(enable bool) DecodeOption {
+	return func(opts *decodeOptions) {
+		opts.validate = enable
+	}
+}
+
+// DecodeArmNames specifies a name for each arm, as returned by
+// [ArmNames], to be used in the errors returned by a [Decoder].
+func DecodeArmNames(names []string) DecodeOption {
+	return func(opts *decodeOptions) {
+		opts.names = names
+	}
+}
+
+// DecodeCheckOptions specifies options for checking values
+// against the tree, such as [InjectDefaults].
+func DecodeCheckOptions(opts ...CheckOption) DecodeOption {
+	return func(dopts *decodeOptions) {
+		dopts.check = opts
+	}
+}
+
+// NewDecoder returns a decoder that decides between the given
+// arms using tree, which should have been made for them, as
+// by [Discriminate] (see also [Verify]).
+func NewDecoder(arms []cue.Value, tree DecisionNode, opts ...DecodeOption) *Decoder {
+	d := &Decoder{
+		arms: arms,
+		tree: tree,
+	}
+	for _, f := range opts {
+		f(&d.opts)
+	}
+	for _, f := range d.opts.check {
+		f(&d.copts)
+	}
+	return d
+}
+
+// Decoder returns a decoder for the arms of r, using the
+// names of the arms in its errors where known.
+func (r *Result) Decoder(opts ...DecodeOption) *Decoder {
+	opts = append([]DecodeOption{DecodeArmNames(r.Names)}, opts...)
+	return NewDecoder(r.Arms, r.Tree, opts...)
+}
+
+// Decode returns the index of the arm that v is an instance of.
+// Without [DecodeValidate], the arm is the one chosen by the tree,
+// which must choose exactly one.
+//
+// The error, if any, is a [*DecodeError].
+func (d *Decoder) Decode(v cue.Value) (int, error) {
+	arm, _, err := d.DecodeValue(v)
+	return arm, err
+}
+
+// DecodeValue is like [Decoder.Decode] but also returns v unified
+// with the chosen arm, which holds any defaults of the arm. Without
+// [DecodeValidate], the result is not checked for errors.
+func (d *Decoder) DecodeValue(v cue.Value) (int, cue.Value, error) {
+	order := d.check(v)
+	if len(order) == 0 || (!d.opts.validate && len(order) > 1) {
+		return -1, cue.Value{}, d.error(order, nil)
+	}
+	if !d.opts.validate {
+		u, err := d.unify(order[0], v)
+		if err != nil {
+			return -1, cue.Value{}, d.error(order, err)
+		}
+		return order[0], u, nil
+	}
+	var firstErr error
+	for _, arm := range order {
+		u, err := d.unify(arm, v)
+		if err == nil {
+			err = u.Validate(cue.Concrete(true))
+		}
+		if err == nil {
+			return arm, u, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return -1, cue.Value{}, d.error(order, firstErr)
+}
+
+// check returns the arms that the tree chooses for v in the order
+// that they should be tried: the order given by [LeafNode.Ordered]
+// for the leaves that v reaches.
+func (d *Decoder) check(v cue.Value) []int {
+	var leaves []*LeafNode
+	copts := d.copts
+	copts.leaves = &leaves
+	s, _ := d.tree.check(v, copts)
+	var order []int
+	for _, l := range leaves {
+		for _, arm := range l.Ordered() {
+			if s.Has(arm) && !slices.Contains(order, arm) {
+				order = append(order, arm)
+			}
+		}
+	}
+	// Any arms chosen other than by a leaf, as by
+	// a FieldAbsenceNode, come last.
+	for _, arm := range sortedInts(s) {
+		if !slices.Contains(order, arm) {
+			order = append(order, arm)
+		}
+	}
+	return order
+}
+
+// unify returns v unified with the given arm, or v itself
+// and an error if there is no such arm.
+func (d *Decoder) unify(arm int, v cue.Value) (cue.Value, error) {
+	if arm < 0 || arm >= len(d.arms) {
+		return v, fmt.Errorf("arm %d does not exist", arm)
+	}
+	return d.arms[arm].Unify(v), nil
+}
+
+func (d *Decoder) error(arms []int, err error) *DecodeError {
+	return &DecodeError{
+		Arms:  arms,
+		Err:   err,
+		names: d.opts.names,
+	}
+}
+
+// DecodeError describes a value that a [Decoder]
+// could not decode.
+type DecodeError struct {
+	// Arms holds the arms that the tree chose for the value, in
+	// the order that they were tried. It is empty when the tree
+	// rejected the value.
+	Arms []int
+
+	// Err holds the error from validating the value against the
+	// first of Arms, or from finding that arm, or nil if the value
+	// was not validated and the arm was found.
+	Err error
+
+	names []string
+}
+
+func (e *DecodeError) Error() string {
+	switch {
+	case len(e.Arms) == 0:
+		return "value is not an instance of any arm"
+	case e.Err != nil && len(e.Arms) == 1:
+		return fmt.Sprintf("value is not an instance of %s: %v", armName(e.names, e.Arms[0]), e.Err)
+	case e.Err != nil:
+		return fmt.Sprintf("value is not an instance of any of arms %s: %v", armListString(e.Arms, e.names), e.Err)
+	}
+	return fmt.Sprintf("value might be an instance of any of arms %s", armListString(e.Arms, e.names))
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,150 @@
+package cuediscrim
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var decodeTests = []struct {
+	testName  string
+	cue       string
+	validate  bool
+	data      string
+	want      int
+	wantErr   string
+	wantValue string
+}{{
+	testName:  "Tag",
+	cue:       `#A | #B`,
+	data:      `{type: "b", s: "x"}`,
+	want:      1,
+	wantValue: `{type: "b", s: "x"}`,
+}, {
+	testName:  "Defaults",
+	cue:       `#A | #B`,
+	data:      `{type: "a"}`,
+	want:      0,
+	wantValue: `{type: "a", n: 1}`,
+}, {
+	testName: "NoArm",
+	cue:      `#A | #B`,
+	data:     `{type: "c"}`,
+	wantErr:  `value is not an instance of any arm`,
+}, {
+	testName:  "NotValidated",
+	cue:       `#A | #B`,
+	data:      `{type: "a", n: "x"}`,
+	want:      0,
+	wantValue: `_|_`,
+}, {
+	testName: "Validated",
+	cue:      `#A | #B`,
+	validate: true,
+	data:     `{type: "a", n: "x"}`,
+	wantErr:  `value is not an instance of #A: n: .*`,
+}, {
+	testName: "Ambiguous",
+	cue:      `{a!: int} | {a!: int, b?: string}`,
+	data:     `{a: 1, b: "x"}`,
+	wantErr:  `value might be an instance of any of arms \[1, 0\]`,
+}, {
+	testName:  "AmbiguousValidatedSpecific",
+	cue:       `{a!: int} | {a!: int, b?: string}`,
+	validate:  true,
+	data:      `{a: 1, b: "x"}`,
+	want:      1,
+	wantValue: `{a: 1, b: "x"}`,
+}, {
+	testName:  "AmbiguousValidatedGeneral",
+	cue:       `{a!: int} | {a!: int, b?: string}`,
+	validate:  true,
+	data:      `{a: 1, b: 2}`,
+	want:      0,
+	wantValue: `{a: 1, b: 2}`,
+}, {
+	testName: "AmbiguousValidatedNone",
+	cue:      `{a!: int} | {a!: int, b?: string}`,
+	validate: true,
+	data:     `{a: "x"}`,
+	wantErr:  `value is not an instance of any of arms \[1, 0\]: .*`,
+}}
+
+func TestDecoder(t *testing.T) {
+	for _, test := range decodeTests {
+		t.Run(test.testName, func(t *testing.T) {
+			ctx := cuecontext.New()
+			scope := ctx.CompileString(`
+#A: {type!: "a", n: *1 | int}
+#B: {type!: "b", s?: string}
+`)
+			qt.Assert(t, qt.IsNil(scope.Err()))
+			v := ctx.CompileString(test.cue, cue.Scope(scope))
+			qt.Assert(t, qt.IsNil(v.Err()))
+			r := DiscriminateValue(v)
+			d := r.Decoder(DecodeValidate(test.validate))
+
+			data := ctx.CompileString(test.data)
+			qt.Assert(t, qt.IsNil(data.Err()))
+			arm, u, err := d.DecodeValue(data)
+			if test.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, test.wantErr))
+				qt.Check(t, qt.ErrorAs(err, new(*DecodeError)))
+				qt.Check(t, qt.Equals(arm, -1))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Check(t, qt.Equals(arm, test.want))
+			if test.wantValue == "_|_" {
+				qt.Check(t, qt.IsNotNil(u.Validate(cue.Concrete(true))))
+				return
+			}
+			qt.Check(t, qt.IsNil(u.Validate(cue.Concrete(true))))
+			want := ctx.CompileString(test.wantValue)
+			qt.Check(t, qt.IsNil(u.Subsume(want, cue.Final())))
+			qt.Check(t, qt.IsNil(want.Subsume(u, cue.Final())))
+
+			arm, err = d.Decode(data)
+			qt.Check(t, qt.IsNil(err))
+			qt.Check(t, qt.Equals(arm, test.want))
+		})
+	}
+}
+
+func TestDecoderUnknownArm(t *testing.T) {
+	v := cuecontext.New().CompileString(`{type!: "a"} | {type!: "b"}`)
+	arms := Disjunctions(v)
+	tree, _, _ := Discriminate(arms)
+	d := NewDecoder(arms[:1], tree, DecodeValidate(true))
+	_, err := d.Decode(v.Context().CompileString(`{type: "b"}`))
+	qt.Check(t, qt.ErrorMatches(err, `value is not an instance of arm 1: arm 1 does not exist`))
+
+	// Without validation, the missing arm is still an error.
+	d = NewDecoder(arms[:1], tree)
+	arm, _, err := d.DecodeValue(v.Context().CompileString(`{type: "b"}`))
+	qt.Check(t, qt.ErrorMatches(err, `value is not an instance of arm 1: arm 1 does not exist`))
+	qt.Check(t, qt.ErrorAs(err, new(*DecodeError)))
+	qt.Check(t, qt.Equals(arm, -1))
+}
+
+func TestDecoderPrecedence(t *testing.T) {
+	// The stored precedence is honored even though it puts
+	// the more general arm first.
+	ctx := cuecontext.New()
+	arms := Disjunctions(ctx.CompileString(`{a!: int} | {a!: int, b?: string}`))
+	tree := &LeafNode{
+		Arms:       setOf(0, 1),
+		Precedence: []int{0, 1},
+	}
+	d := NewDecoder(arms, tree, DecodeValidate(true))
+	arm, err := d.Decode(ctx.CompileString(`{a: 1, b: "x"}`))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(arm, 0))
+
+	tree.Precedence = []int{1, 0}
+	arm, err = d.Decode(ctx.CompileString(`{a: 1, b: "x"}`))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(arm, 1))
+}
@@ -0,0 +1,36 @@
+package cuediscrim
+
+import (
+	"cuelang.org/go/cue"
+)
+
+// IsDeprecated reports whether the arm v is marked as deprecated.
+// An arm is deprecated when it holds a @deprecated attribute,
+// either as a declaration attribute inside a struct:
+//
+//	#Old: {
+//		@deprecated(use #New instead)
+//		kind!: "old"
+//	}
+//
+// or as a field attribute when v is itself a field value.
+func IsDeprecated(v cue.Value) bool {
+	for _, attr := range v.Attributes(cue.ValueAttr) {
+		if attr.Name() == "deprecated" {
+			return true
+		}
+	}
+	return false
+}
+
+// DeprecatedArms returns the set of indexes of the arms
+// that are deprecated according to [IsDeprecated].
+func DeprecatedArms(arms []cue.Value) IntSet {
+	s := make(mapSet[int])
+	for i, arm := range arms {
+		if IsDeprecated(arm) {
+			s[i] = true
+		}
+	}
+	return s
+}
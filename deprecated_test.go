@@ -0,0 +1,44 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestDeprecated(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`
+#A: {
+	type!: "a"
+	x!: int
+}
+#OldA: {
+	@deprecated(use #A)
+	type!: "a"
+	x!: >0
+}
+#B: {
+	type!: "b"
+}
+x: #A | #OldA | #B
+y: #A | #OldA
+`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	arms := Disjunctions(v.LookupPath(cue.ParsePath("x")))
+	qt.Assert(t, qt.HasLen(arms, 3))
+	qt.Assert(t, deepEquals(ref(DeprecatedArms(arms)), ref[IntSet](setOf(1))))
+
+	arms = Disjunctions(v.LookupPath(cue.ParsePath("y")))
+	tree, _, isPerfect := Discriminate(arms)
+	qt.Check(t, qt.IsFalse(isPerfect))
+	qt.Check(t, qt.Equals(NodeString(tree), strings.TrimPrefix(`
+choose({0, 1}) deprecated({1})
+`, "\n")))
+
+	_, _, isPerfect = Discriminate(arms, IgnoreDeprecated(true))
+	qt.Check(t, qt.IsTrue(isPerfect))
+}
@@ -1,20 +1,45 @@
 package cuediscrim
 
 import (
+	"cmp"
 	"io"
 	"iter"
 	"maps"
+	"slices"
+	"strings"
 
 	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/token"
 )
 
 type options struct {
-	logger          *indentWriter
-	mergeCompatible bool
+	logger           *indentWriter
+	logLevel         int
+	captureLog       int
+	mergeCompatible  bool
+	ignoreDeprecated bool
+	optional         bool
+	tieBreak         TieBreakPolicy
+	preferFields     []string
+	eval             Concreteness
+	preserveGroups   bool
+	maxValueBranches int
+	enumStrategy     EnumStrategy
+	enumThreshold    int
+	chooseEnums      bool
+	closedWorld      bool
+	maxDepth         int
+	includePaths     []string
+	excludePaths     []string
+	armNames         []string
+	armWeights       map[int]float64
+	topArms          TopArmPolicy
+	optionalFields   bool
 }
 
 // LogTo causes debug information to be written to w.
-// If w is nil, no logging will be done.
+// If w is nil, no logging will be done. The amount of
+// information is determined by [LogLevel].
 func LogTo(w io.Writer) Option {
 	if w == nil {
 		return func(opts *options) {
@@ -28,12 +53,215 @@ func LogTo(w io.Writer) Option {
 	}
 }
 
+// LogLevel sets how much debug information is written when
+// [LogTo] is specified:
+//
+//   - level 1 (the default) logs the discriminators that are chosen;
+//   - level 2 also logs the candidates that were considered and rejected;
+//   - level 3 also logs the value set of each arm at each candidate path.
+//
+// Levels above 3 are treated as 3.
+func LogLevel(level int) Option {
+	return func(opts *options) {
+		opts.logLevel = level
+	}
+}
+
+// fieldLimits returns the limits on the fields
+// that may be used as discriminators.
+func (o *options) fieldLimits() fieldLimits {
+	return fieldLimits{
+		maxDepth: o.maxDepth,
+		include:  o.includePaths,
+		exclude:  o.excludePaths,
+	}
+}
+
+// logf logs a message if the log level is at least level.
+func (o *options) logf(level int, f string, a ...any) {
+	if o.logger != nil && level <= max(o.logLevel, 1) {
+		o.logger.Printf(f, a...)
+	}
+}
+
+// LogArmNames causes the debug log written by [LogTo] to show
+// arms by their names, where known, rather than by their indexes.
+// The names are indexed by arm, as returned by [ArmNames]; see
+// [ArmSetString]. [DiscriminateValue] provides the names itself.
+func LogArmNames(names []string) Option {
+	return func(opts *options) {
+		opts.armNames = names
+	}
+}
+
 func MergeCompatible(enable bool) Option {
 	return func(opts *options) {
 		opts.mergeCompatible = enable
 	}
 }
 
+// IgnoreDeprecated causes arms marked as deprecated (see [IsDeprecated])
+// to be disregarded when deciding whether a discriminator is perfect:
+// a leaf that chooses several arms is still considered perfect if
+// no more than one of them is not deprecated.
+func IgnoreDeprecated(enable bool) Option {
+	return func(opts *options) {
+		opts.ignoreDeprecated = enable
+	}
+}
+
+// Optional specifies that the disjunction is the value
+// of an optional field, so the value being checked
+// may be absent. The resulting tree has an [OptionalNode]
+// at its root that handles absence before discriminating
+// between the arms.
+func Optional(enable bool) Option {
+	return func(opts *options) {
+		opts.optional = enable
+	}
+}
+
+// TieBreakPolicy determines which field is chosen to
+// switch on when several would discriminate equally well.
+type TieBreakPolicy int
+
+const (
+	// TieBreakShallowest chooses the field with the fewest
+	// path elements. This is the default.
+	TieBreakShallowest TieBreakPolicy = iota
+
+	// TieBreakLexical chooses the field whose path
+	// sorts first lexically.
+	TieBreakLexical
+
+	// TieBreakDeclaration chooses the field that is
+	// declared first in the source.
+	TieBreakDeclaration
+)
+
+// TieBreak sets the policy used to choose between fields
+// that would discriminate equally well. Whatever the policy,
+// the result is deterministic for a given set of arms.
+func TieBreak(p TieBreakPolicy) Option {
+	return func(opts *options) {
+		opts.tieBreak = p
+	}
+}
+
+// PreferFields causes the fields with the given paths to be
+// chosen in preference to others when several fields would
+// discriminate equally well. Fields earlier in the list are
+// preferred to later ones. Ties between other fields are
+// broken according to the [TieBreak] policy.
+func PreferFields(paths ...string) Option {
+	return func(opts *options) {
+		opts.preferFields = paths
+	}
+}
+
+// MaxValueBranches limits the number of branches in any
+// [ValueSwitchNode] or [RegexSwitchNode] to n, for the benefit of code generation targets
+// that cannot handle large enumerations. When switching on the
+// values of a field would need more branches than that, the field is
+// considered only by kind, and other fields are tried instead. If
+// nothing else discriminates between the arms, the tree switches on
+// the kind of the value alone where that helps, and the discriminator
+// is reported as imperfect. A limit of zero or less means no limit.
+func MaxValueBranches(n int) Option {
+	return func(opts *options) {
+		opts.maxValueBranches = n
+	}
+}
+
+// Enums sets the Enum field of each [ValueSwitchNode] in the tree to
+// the strategy chosen by s for the number of branches, as returned by
+// [EnumStrategy.Choose] with the given threshold, so that code
+// generated from a switch on a large enumeration of strings, for
+// example, looks the value up rather than testing each case in turn.
+// Without this option, the field is left as [EnumAuto] and the code
+// generators choose with [DefaultEnumThreshold].
+func Enums(s EnumStrategy, threshold int) Option {
+	return func(opts *options) {
+		opts.enumStrategy = s
+		opts.enumThreshold = threshold
+		opts.chooseEnums = true
+	}
+}
+
+// ClosedWorld causes the arms to be treated as closed structs, so
+// that a value has no fields other than those declared by the arm it
+// is an instance of. This allows a field required by some arms and
+// not declared by the others to select between them by its presence
+// (see [FieldPresenceNode]), so that {a!: int} | {b!: string}, for
+// example, can be discriminated perfectly. Fields allowed by a
+// pattern constraint such as [string]: int are taken to be declared.
+//
+// Arms that are actually closed, such as definitions and the
+// results of calls to close, are always treated that way,
+// with or without this option.
+func ClosedWorld(enable bool) Option {
+	return func(opts *options) {
+		opts.closedWorld = enable
+	}
+}
+
+// OptionalFields causes fields that are optional in some or all of
+// the arms, such as kind in {kind?: "a"} | {kind?: "b"}, to be
+// considered as discriminators as soon as no required field can
+// be used, rather than only as a last resort. The field is tested
+// for presence first (see [FieldPresenceNode]): a value with the
+// field is discriminated by its value, and a value without it falls
+// back to a decision between the arms that don't require it, made
+// without the field. Unless that fallback can tell them apart, the
+// discriminator is imperfect, because a value without the field
+// could be an instance of any of them.
+func OptionalFields(enable bool) Option {
+	return func(opts *options) {
+		opts.optionalFields = enable
+	}
+}
+
+// MaxDepth limits the fields considered as discriminators to those
+// at most n selectors deep, counting each list index as a selector,
+// so that analysis of deeply nested schemas such as Kubernetes
+// custom resources finishes quickly. When the arms can only be told
+// apart by deeper fields, the tree is the best that can be made
+// without them and the discriminator is reported as imperfect.
+// A limit of zero or less means no limit.
+func MaxDepth(n int) Option {
+	return func(opts *options) {
+		opts.maxDepth = n
+	}
+}
+
+// IncludePaths limits the fields used as discriminators to those
+// matched by at least one of the given patterns, for example so
+// that code generated from the tree only looks at fields that are
+// available when values are classified. The kind and value of the
+// whole value can always be used.
+//
+// A pattern is a path as shown in decision trees, such as
+// spec.kind or items[0].type, and matches the field at that path
+// and all the fields within it. Each field name in the pattern may
+// hold wildcards as understood by [path.Match], so that spec.*
+// matches the fields within spec but not spec itself, and the index
+// [*] matches any list element. A malformed pattern matches nothing.
+func IncludePaths(patterns ...string) Option {
+	return func(opts *options) {
+		opts.includePaths = patterns
+	}
+}
+
+// ExcludePaths prevents the fields matched by any of the given
+// patterns, as described for [IncludePaths], from being used as
+// discriminators, for example to forbid the use of metadata.name.
+// It applies to the fields allowed by IncludePaths, if any.
+func ExcludePaths(patterns ...string) Option {
+	return func(opts *options) {
+		opts.excludePaths = patterns
+	}
+}
+
 type Option func(*options)
 
 // Discriminate returns a decision tree that can be used
@@ -49,12 +277,38 @@ type Option func(*options)
 // If [MergeCompatible] is specified, it also returns a slice
 // of distinct sets of arms that have been merged.
 func Discriminate(arms []cue.Value, optArgs ...Option) (DecisionNode, []IntSet, bool) {
+	return discriminate(arms, nil, optArgs)
+}
+
+// DiscriminateSeq is like [Discriminate] except that it takes
+// its arms from an iterator, such as that returned by [DisjunctionSeq].
+// The discrimination index for the top level values is built
+// as the arms are produced. Note that the arms themselves
+// are still retained because the analysis needs random access
+// to them.
+func DiscriminateSeq(arms iter.Seq[cue.Value], optArgs ...Option) (DecisionNode, []IntSet, bool) {
+	var armSlice []cue.Value
+	var rootSets []valueSet
+	for arm := range arms {
+		armSlice = append(armSlice, arm)
+		rootSets = append(rootSets, valueSetForValue(arm))
+	}
+	return discriminate(armSlice, rootSets, optArgs)
+}
+
+func discriminate(arms []cue.Value, rootSets []valueSet, optArgs []Option) (DecisionNode, []IntSet, bool) {
 	var opts options
 	for _, f := range optArgs {
 		f(&opts)
 	}
 	var groups []IntSet
 	origArms := arms
+	deprecated := DeprecatedArms(arms)
+	if opts.eval != EvalNone {
+		arms = evaluateArms(arms, opts.eval)
+		// The precomputed sets are for the unevaluated arms.
+		rootSets = nil
+	}
 	var rev func(int) IntSet
 	if opts.mergeCompatible {
 		var newArms []cue.Value
@@ -62,58 +316,122 @@ func Discriminate(arms []cue.Value, optArgs ...Option) (DecisionNode, []IntSet,
 		if len(newArms) != len(arms) {
 			// Some items have been merged. It's useful to know
 			// that for debugging purposes.
-			opts.logger.Printf("merge groups: {")
+			opts.logf(1, "merge groups: {")
 			opts.logger.Indent()
 			for i := range newArms {
-				opts.logger.Printf("%v", SetString(rev(i)))
+				opts.logf(1, "%v", SetString(rev(i)))
 			}
 			opts.logger.Unindent()
-			opts.logger.Printf("}")
+			opts.logf(1, "}")
 		} else {
-			opts.logger.Printf("no merging")
+			opts.logf(1, "no merging")
 		}
 		groups = make([]IntSet, len(newArms))
 		for i := range groups {
 			groups[i] = rev(i)
 		}
+		if len(newArms) != len(arms) {
+			// The precomputed sets no longer correspond to the arms.
+			rootSets = nil
+		}
 		arms = newArms
 	}
+	top := topArms(arms, opts)
+	if len(top) > 0 {
+		opts.logf(1, "top arms %v (%v)", top, opts.topArms)
+	}
 	var n DecisionNode
 	if len(arms) <= 64 {
 		d := &discriminator[wordSet]{
-			options: opts,
-			sets:    wordSetAPI{},
-			rev:     rev,
+			options:    opts,
+			sets:       wordSetAPI{},
+			rev:        rev,
+			deprecated: deprecated,
 		}
-		n = d.discriminate(arms, wordSetN(len(arms)))
+		d.seedValueSets(rootValues, rootSets)
+		selected := wordSetN(len(arms))
+		for _, i := range top {
+			d.sets.delete(&selected, i)
+		}
+		n = d.discriminate(arms, selected)
 	} else {
 		d := &discriminator[mapSet[int]]{
-			options: opts,
-			sets:    mapSetAPI[int]{},
-			rev:     rev,
+			options:    opts,
+			sets:       mapSetAPI[int]{},
+			rev:        rev,
+			deprecated: deprecated,
+		}
+		d.seedValueSets(rootValues, rootSets)
+		selected := intSetN(len(arms))
+		for _, i := range top {
+			d.sets.delete(&selected, i)
 		}
-		n = d.discriminate(arms, intSetN(len(arms)))
+		n = d.discriminate(arms, selected)
 	}
 
-	return n, groups, isPerfect(n, opts.mergeCompatible, origArms)
+	n = guardShortLists(n, origArms, deprecated, nil)
+	n = applyTopArms(n, arms, top, rev, opts)
+	orderBySpecificity(n, origArms)
+	if opts.armWeights != nil {
+		orderByWeight(n, opts.armWeights)
+	}
+	if opts.optional {
+		n = &OptionalNode{
+			Present: n,
+		}
+	}
+	perfect := isPerfect(n, opts, origArms)
+	if opts.topArms == TopArmsError && len(top) > 0 {
+		perfect = false
+	}
+	return n, groups, perfect
 }
 
 type discriminator[Set any] struct {
 	sets setAPI[Set, int]
 	rev  func(int) IntSet
+	// valueSets caches the value set for each arm by the
+	// values that it was computed from. The value at a given path
+	// within a given arm never changes, so once computed, an entry
+	// remains valid.
+	valueSets map[valueSetKey][]*valueSet
+
+	// probes caches the result of [discriminator.probe] by arm.
+	probes map[int]cue.Value
+
+	// deprecated holds the set of deprecated arms,
+	// in terms of the original arm indexes.
+	deprecated IntSet
+
+	// absent holds the paths of the fields that values are
+	// known not to have, in the fallback of a switch made by
+	// [discriminator.optionalFieldDiscriminator].
+	absent map[string]bool
 	options
 }
 
+// allFields is like the allFields function but respects
+// the [MaxDepth], [IncludePaths] and [ExcludePaths] options.
+func (d *discriminator[Set]) allFields(arms []cue.Value, selected Set, labelTypes labelType) iter.Seq2[string, []cue.Value] {
+	return allFieldsWithin(arms, d.sets.asSet(selected), labelTypes, d.fieldLimits())
+}
+
 func (d *discriminator[Set]) discriminate(arms []cue.Value, selected Set) (_n DecisionNode) {
-	d.logger.Printf("discriminate %v {", d.setString(selected))
+	if d.sets.len(selected) <= 1 {
+		// Nothing to disambiguate.
+		return d.newLeaf(selected)
+	}
+	d.logf(1, "discriminate %v {", d.setString(selected))
 	d.logger.Indent()
 	defer func() {
-		d.logger.Printf("} -> %T", _n)
+		d.logf(1, "} -> %T", _n)
 	}()
 	defer d.logger.Unindent()
-	if d.sets.len(selected) <= 1 {
-		// Nothing to disambiguate.
-		return d.newLeaf(selected)
+	if n := d.disjointKindSwitch(arms, selected); n != nil {
+		// Fast path: all the arms have different kinds,
+		// so there's no need for any further analysis.
+		d.logf(1, "all kinds are disjoint")
+		return n
 	}
 	// First try to discriminate based on the top level value only.
 	// We're happy just to make some progress, so we'll consider
@@ -129,30 +447,39 @@ func (d *discriminator[Set]) discriminate(arms []cue.Value, selected Set) (_n De
 	if d.sets.len(needDiscrim) == 0 {
 		needDiscrim = selected
 	}
-	byValue, byKind, full := d.discriminators(arms, selected, needDiscrim)
+	byValue, byKind, full := d.discriminators(rootValues, arms, selected, needDiscrim)
 	if full {
-		return d.buildDecisionFromDescriminators(".", arms, selected, byValue, byKind)
+		d.logf(1, "chose .")
+		return d.buildDecisionFromDescriminators(".", arms, selected, byValue, byKind, nil, nil)
+	}
+	if byRange := d.rangeDiscrim(arms, selected, needDiscrim, byValue, byKind); byRange != nil {
+		d.logf(1, "chose range of .")
+		return d.buildDecisionFromDescriminators(".", arms, selected, byValue, byKind, byRange, nil)
+	}
+	if byPattern := d.patternDiscrim(arms, selected, needDiscrim, byValue, byKind); byPattern != nil {
+		d.logf(1, "chose pattern of .")
+		return d.buildDecisionFromDescriminators(".", arms, selected, byValue, byKind, nil, byPattern)
 	}
 	// First try to find a single discriminator that can be used to do all discrimination.
-	for path, values := range allFields(arms, d.sets.asSet(selected), requiredLabel) {
-		d.logger.Printf("----- PATH %s", path)
-		byValue, byKind, full := d.discriminators(values, selected, selected)
-		if full {
-			d.logger.Printf("fully discriminated")
-		}
-		d.logger.Printf("values:")
-		for v, group := range byValue {
-			d.logger.Printf("	%v: %v", v, d.setString(group))
-		}
-		d.logger.Printf("kinds:")
-		for k, group := range byKind {
-			d.logger.Printf("	%v: %v", k, d.setString(group))
-		}
-		if full {
-			return d.buildDecisionFromDescriminators(path, values, selected, byValue, byKind)
+	if n := d.fieldDiscriminator(arms, selected, requiredLabel); n != nil {
+		return n
+	}
+	// A field that some arms require and the others can't have
+	// selects the former.
+	if n := d.presenceDiscriminator(arms, selected); n != nil {
+		return n
+	}
+	if d.optionalFields {
+		if n := d.optionalFieldDiscriminator(arms, selected); n != nil {
+			return n
 		}
 	}
-	d.logger.Printf("no pure discriminator found; trying existence checks; selected %s", d.setString(selected))
+	// Lists with the same elements might still differ in how
+	// many of them they allow.
+	if n := d.lengthDiscriminator(arms, selected); n != nil {
+		return n
+	}
+	d.logf(2, "no pure discriminator found; trying existence checks; selected %s", d.setString(selected))
 
 	// We haven't found any pure single discriminator.
 	// Now try to narrow things down by checking for field absence.
@@ -163,14 +490,14 @@ func (d *discriminator[Set]) discriminate(arms []cue.Value, selected Set) (_n De
 	// one arm at a time.
 	possible := selected
 	branches := make(map[string]IntSet)
-	for path, values := range allFields(arms, d.sets.asSet(selected), requiredLabel) {
+	for path, values := range d.allFields(arms, selected, requiredLabel) {
 		group := d.existenceDiscriminator(values, selected)
-		d.logger.Printf("----- PATH %s %s; possible %s", path, d.setString(group), d.setString(possible))
+		d.logf(2, "----- PATH %s %s; possible %s", path, d.setString(group), d.setString(possible))
 
 		if d.sets.len(group) != d.sets.len(selected)-1 {
 			continue
 		}
-		d.logger.Printf("it's possible!")
+		d.logf(2, "it's possible!")
 		// we're deselecting exactly one member, but
 		// we want to be sure that we're removing something new.
 		removed := false
@@ -181,7 +508,7 @@ func (d *discriminator[Set]) discriminate(arms []cue.Value, selected Set) (_n De
 			}
 		}
 		if !removed {
-			d.logger.Printf("nothing removed")
+			d.logf(2, "nothing removed")
 			continue
 		}
 		possible = d.sets.intersect(possible, group)
@@ -191,16 +518,301 @@ func (d *discriminator[Set]) discriminate(arms []cue.Value, selected Set) (_n De
 		}
 	}
 	if d.sets.len(possible) > 0 {
+		if n := d.tupleDiscriminator(arms, selected); n != nil {
+			return n
+		}
+		// As a last resort, try optional fields too, as for
+		// OptionalFields. A value that lacks the field falls back
+		// to the arms that allow it to be absent.
+		if !d.optionalFields {
+			if n := d.optionalFieldDiscriminator(arms, selected); n != nil {
+				return n
+			}
+		}
+		if n := d.sharedValueFallback(arms, selected); n != nil {
+			return n
+		}
+		if n := d.kindFallback(arms, selected); n != nil {
+			d.logf(1, "falling back to kind switch")
+			return n
+		}
+		if n := d.declaredPresenceDiscriminator(arms, selected); n != nil {
+			return n
+		}
 		// We haven't been able to form a discriminator.
 		// TODO better than this.
+		d.logf(1, "no discriminator found")
 		return d.newLeaf(selected)
 	}
-	return &FieldAbsenceNode{
-		Branches: branches,
+	d.logf(1, "chose absence of %s", strings.Join(slices.Sorted(maps.Keys(branches)), ", "))
+	return newFieldAbsenceNode(branches)
+}
+
+// sharedValueFallback returns a switch on a field whose value is
+// one of a set of constants in some selected arms, but where some
+// of the constants are allowed by more than one arm, as with
+// {type!: "a" | "legacy"} | {type!: "b" | "legacy"}. Each constant
+// chooses all the arms that allow it, so a shared constant leads to
+// a leaf with several arms rather than to any one of them (see
+// [Warnings]).
+//
+// The other arms may allow any value of some kind at the path, as
+// with {type!: "a"} | {type!: string}. They are chosen along with
+// the arms of every constant of that kind, and the default of the
+// switch chooses them by the kind of the value. It returns nil if
+// there is no such field.
+func (d *discriminator[Set]) sharedValueFallback(arms []cue.Value, selected Set) DecisionNode {
+	for path, values := range d.allFields(arms, selected, requiredLabel) {
+		byValue, byKind, _ := d.discriminators(valueSetKey{path, requiredLabel}, values, selected, selected)
+		if len(byValue) == 0 {
+			// None of the values at the path is a constant.
+			continue
+		}
+		if !slices.ContainsFunc(slices.Collect(iterConcat(maps.Values(byValue), maps.Values(byKind))), func(group Set) bool {
+			return !d.sets.equal(group, selected)
+		}) {
+			// This would make no progress.
+			continue
+		}
+		var dflt DecisionNode = ErrorNode{}
+		if len(byKind) > 0 {
+			d.logf(1, "chose %s with shared values and open kinds %v", path, slices.Sorted(maps.Keys(byKind)))
+			k := &KindSwitchNode{
+				Path:     path,
+				Branches: make(map[cue.Kind]DecisionNode, len(byKind)),
+			}
+			for kind, group := range byKind {
+				k.Branches[kind] = d.newLeaf(group)
+			}
+			dflt = k
+		} else {
+			d.logf(1, "chose %s with shared values", path)
+		}
+		n := &ValueSwitchNode{
+			Path:     path,
+			Branches: make(map[Atom]DecisionNode, len(byValue)),
+			Default:  dflt,
+		}
+		if d.chooseEnums {
+			n.Enum = d.enumStrategy.Choose(len(byValue), d.enumThreshold)
+		}
+		for val, group := range byValue {
+			n.Branches[val] = d.newLeaf(group)
+		}
+		if a := d.impliedValue(arms, selected); mapHasKey(n.Branches, a) {
+			n.Implied = a
+		}
+		return n
+	}
+	return nil
+}
+
+// kindFallback returns a switch on the kind of the value that
+// narrows down the selected arms when [MaxValueBranches] has
+// prevented a value switch, or nil if there is no such switch.
+func (d *discriminator[Set]) kindFallback(arms []cue.Value, selected Set) DecisionNode {
+	if d.maxValueBranches <= 0 {
+		return nil
 	}
+	vsets := make([]valueSet, len(arms))
+	for i := range d.sets.values(selected) {
+		vsets[i] = d.valueSet(rootValues, arms, i)
+	}
+	byKind := d.kindDiscrim(vsets, selected, valueSet.kinds)
+	if len(byKind) < 2 {
+		return nil
+	}
+	for _, group := range byKind {
+		if d.sets.equal(group, selected) {
+			// This would make no progress.
+			return nil
+		}
+	}
+	return d.buildDecisionFromDescriminators(".", arms, selected, nil, byKind, nil, nil)
+}
+
+// candidate holds a field that fully discriminates
+// between a set of arms.
+type candidate[Set any] struct {
+	path      string
+	values    []cue.Value
+	byValue   map[Atom]Set
+	byKind    map[cue.Kind]Set
+	byRange   []rangeGroup[Set]
+	byPattern []patternGroup[Set]
 }
 
-func (d *discriminator[Set]) buildDecisionFromDescriminators(path string, values []cue.Value, selected Set, byValue map[Atom]Set, byKind map[cue.Kind]Set) DecisionNode {
+// fieldDiscriminator returns a node that discriminates between
+// the selected arms by switching on a single field with one of the
+// given label types, or nil if there is no such field. When there are
+// several such fields, the choice is made according to the TieBreak and
+// PreferFields options.
+func (d *discriminator[Set]) fieldDiscriminator(arms []cue.Value, selected Set, labels labelType) DecisionNode {
+	// With the default options, the first candidate found is the one
+	// we want because allFields produces the shallowest fields first.
+	firstWins := d.tieBreak == TieBreakShallowest && len(d.preferFields) == 0
+	var candidates []candidate[Set]
+	for path, values := range d.allFields(arms, selected, labels) {
+		if d.absent[path] {
+			continue
+		}
+		d.logf(2, "----- PATH %s", path)
+		byValue, byKind, full := d.discriminators(valueSetKey{path, labels}, values, selected, selected)
+		var byRange []rangeGroup[Set]
+		var byPattern []patternGroup[Set]
+		if !full {
+			byRange = d.rangeDiscrim(values, selected, selected, byValue, byKind)
+			full = byRange != nil
+		}
+		if !full {
+			byPattern = d.patternDiscrim(values, selected, selected, byValue, byKind)
+			full = byPattern != nil
+		}
+		if full {
+			d.logf(2, "fully discriminated")
+		}
+		d.logf(3, "values:")
+		for _, v := range slices.SortedFunc(maps.Keys(byValue), Atom.compare) {
+			d.logf(3, "	%v: %v", v, d.setString(byValue[v]))
+		}
+		d.logf(3, "kinds:")
+		for _, k := range slices.Sorted(maps.Keys(byKind)) {
+			d.logf(3, "	%v: %v", k, d.setString(byKind[k]))
+		}
+		if byRange != nil {
+			d.logf(3, "ranges:")
+			for _, g := range byRange {
+				d.logf(3, "	%v: %v", g.interval, d.setString(g.arms))
+			}
+		}
+		if byPattern != nil {
+			d.logf(3, "patterns:")
+			for _, g := range byPattern {
+				d.logf(3, "	=~%q: %v", g.pattern, d.setString(g.arms))
+			}
+		}
+		if !full {
+			continue
+		}
+		if firstWins {
+			d.logf(1, "chose %s", path)
+			return d.buildDecisionFromDescriminators(path, values, selected, byValue, byKind, byRange, byPattern)
+		}
+		candidates = append(candidates, candidate[Set]{
+			path:      path,
+			values:    values,
+			byValue:   byValue,
+			byKind:    byKind,
+			byRange:   byRange,
+			byPattern: byPattern,
+		})
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	// Note: the sort is stable so that candidates that compare
+	// equal remain in the order that allFields produced them.
+	slices.SortStableFunc(candidates, func(c0, c1 candidate[Set]) int {
+		return d.compareCandidatePaths(c0.path, c0.values, c1.path, c1.values)
+	})
+	c := candidates[0]
+	d.logf(1, "chose %s from %d candidates", c.path, len(candidates))
+	return d.buildDecisionFromDescriminators(c.path, c.values, selected, c.byValue, c.byKind, c.byRange, c.byPattern)
+}
+
+func (d *discriminator[Set]) compareCandidatePaths(path0 string, values0 []cue.Value, path1 string, values1 []cue.Value) int {
+	if c := cmp.Compare(d.preferIndex(path0), d.preferIndex(path1)); c != 0 {
+		return c
+	}
+	switch d.tieBreak {
+	case TieBreakLexical:
+		return strings.Compare(path0, path1)
+	case TieBreakDeclaration:
+		return comparePos(firstPos(values0), firstPos(values1))
+	}
+	return cmp.Compare(pathDepth(path0), pathDepth(path1))
+}
+
+// preferIndex returns the index of path in the PreferFields
+// option, or len(d.preferFields) if it's not there.
+func (d *discriminator[Set]) preferIndex(path string) int {
+	if i := slices.Index(d.preferFields, path); i >= 0 {
+		return i
+	}
+	return len(d.preferFields)
+}
+
+// firstPos returns the position of the first value that has one.
+func firstPos(values []cue.Value) token.Pos {
+	for _, v := range values {
+		if pos := v.Pos(); pos.IsValid() {
+			return pos
+		}
+	}
+	return token.NoPos
+}
+
+// comparePos compares positions by file name and then offset.
+// Invalid positions sort last.
+func comparePos(p0, p1 token.Pos) int {
+	switch {
+	case p0.IsValid() && !p1.IsValid():
+		return -1
+	case !p0.IsValid() && p1.IsValid():
+		return 1
+	case !p0.IsValid() && !p1.IsValid():
+		return 0
+	}
+	if c := strings.Compare(p0.Filename(), p1.Filename()); c != 0 {
+		return c
+	}
+	return cmp.Compare(p0.Offset(), p1.Offset())
+}
+
+// disjointKindSwitch returns a kind switch on the top level value
+// if the kinds of all the selected arms are pairwise disjoint,
+// or nil if not.
+func (d *discriminator[Set]) disjointKindSwitch(arms []cue.Value, selected Set) DecisionNode {
+	var all cue.Kind
+	byKind := make(map[cue.Kind]int)
+	for i := range d.sets.values(selected) {
+		k := arms[i].IncompleteKind()
+		if k&cue.NumberKind != 0 && atomForValue(arms[i]).isValid() {
+			// A number such as 1 might be written as 1.0.
+			k |= cue.NumberKind
+		}
+		if k == 0 || (k&all) != 0 {
+			return nil
+		}
+		all |= k
+		for _, k1 := range allKinds {
+			if (k & k1) != 0 {
+				byKind[k1] = i
+			}
+		}
+	}
+	n := &KindSwitchNode{
+		Path:     ".",
+		Branches: make(map[cue.Kind]DecisionNode, len(byKind)),
+	}
+	for k, i := range byKind {
+		n.Branches[k] = d.newLeaf(d.sets.of(i))
+	}
+	return n
+}
+
+// buildDecisionFromDescriminators returns a node that switches on
+// the value at path using the given discriminators, as returned by
+// discriminators, rangeDiscrim and patternDiscrim. When byRange is
+// non-nil, it replaces the parts of byValue and byKind that concern
+// numbers; likewise byPattern replaces the parts that concern strings.
+func (d *discriminator[Set]) buildDecisionFromDescriminators(path string, values []cue.Value, selected Set, byValue map[Atom]Set, byKind map[cue.Kind]Set, byRange []rangeGroup[Set], byPattern []patternGroup[Set]) DecisionNode {
+	if byRange != nil {
+		byValue, byKind = withoutNumbers(byValue, byKind)
+	}
+	if byPattern != nil {
+		byValue, byKind = withoutStrings(byValue, byKind)
+	}
 	var kindSwitch DecisionNode
 	if len(byKind) == 0 {
 		kindSwitch = ErrorNode{}
@@ -210,8 +822,9 @@ func (d *discriminator[Set]) buildDecisionFromDescriminators(path string, values
 			Path:     path,
 			Branches: make(map[cue.Kind]DecisionNode, len(byKind)),
 		}
-		for k, group := range byKind {
-			d.logger.Printf("kind %v: %v", k, d.setString(group))
+		for _, k := range slices.Sorted(maps.Keys(byKind)) {
+			group := byKind[k]
+			d.logf(2, "kind %v: %v", k, d.setString(group))
 			var branch DecisionNode
 			switch {
 			case k == cue.StructKind && d.sets.len(group) > 1:
@@ -228,6 +841,46 @@ func (d *discriminator[Set]) buildDecisionFromDescriminators(path string, values
 		}
 		kindSwitch = n
 	}
+	if byRange != nil {
+		rangeSwitch := &RangeSwitchNode{
+			Path:    path,
+			Default: kindSwitch,
+		}
+		for _, g := range byRange {
+			d.logf(2, "range %v: %v", g.interval, d.setString(g.arms))
+			var branch DecisionNode
+			if d.sets.equal(g.arms, selected) {
+				branch = d.newLeaf(selected)
+			} else {
+				branch = d.discriminate(values, g.arms)
+			}
+			rangeSwitch.Branches = append(rangeSwitch.Branches, RangeBranch{
+				Interval: g.interval,
+				Node:     branch,
+			})
+		}
+		kindSwitch = rangeSwitch
+	}
+	if byPattern != nil {
+		regexSwitch := &RegexSwitchNode{
+			Path:    path,
+			Default: kindSwitch,
+		}
+		for _, g := range byPattern {
+			d.logf(2, "pattern %q: %v", g.pattern, d.setString(g.arms))
+			var branch DecisionNode
+			if d.sets.equal(g.arms, selected) {
+				branch = d.newLeaf(selected)
+			} else {
+				branch = d.discriminate(values, g.arms)
+			}
+			regexSwitch.Branches = append(regexSwitch.Branches, RegexBranch{
+				Pattern: g.pattern,
+				Node:    branch,
+			})
+		}
+		kindSwitch = regexSwitch
+	}
 	if len(byValue) == 0 {
 		return kindSwitch
 	}
@@ -236,21 +889,47 @@ func (d *discriminator[Set]) buildDecisionFromDescriminators(path string, values
 		Branches: make(map[Atom]DecisionNode, len(byValue)),
 		Default:  kindSwitch,
 	}
-	for val, group := range byValue {
+	if d.chooseEnums {
+		valSwitch.Enum = d.enumStrategy.Choose(len(byValue), d.enumThreshold)
+	}
+	for _, val := range slices.SortedFunc(maps.Keys(byValue), Atom.compare) {
+		group := byValue[val]
 		var branch DecisionNode
 		if d.sets.equal(group, selected) {
 			// We've got nothing more to base a decision on,
 			// so terminate.
 			branch = d.newLeaf(selected)
 		} else {
-			d.logger.Printf("valSwitch %v", val)
+			d.logf(2, "valSwitch %v", val)
 			branch = d.discriminate(values, group)
 		}
 		valSwitch.Branches[val] = branch
 	}
+	if a := d.impliedValue(values, selected); mapHasKey(valSwitch.Branches, a) {
+		valSwitch.Implied = a
+	}
 	return valSwitch
 }
 
+// impliedValue returns the default value shared by all the
+// selected values that have a default, or the zero Atom if there
+// is no such value or the defaults differ.
+func (d *discriminator[Set]) impliedValue(values []cue.Value, selected Set) Atom {
+	var implied Atom
+	for i := range d.sets.values(selected) {
+		dv, ok := values[i].Default()
+		if !ok {
+			continue
+		}
+		a := atomForValue(dv)
+		if !a.isValid() || (implied.isValid() && a != implied) {
+			return Atom{}
+		}
+		implied = a
+	}
+	return implied
+}
+
 // discriminators returns the possible discriminators between the selected elements
 // of the given arm values. The first returned value discriminates based on exact
 // value; the second discriminates based on kind.
@@ -259,11 +938,13 @@ func (d *discriminator[Set]) buildDecisionFromDescriminators(path string, values
 // value discriminator map.
 //
 // It also reports whether the returned discrimators will fully discriminate
-// the elements of needDiscrim
-func (d *discriminator[Set]) discriminators(arms0 []cue.Value, selected, needDiscrim Set) (map[Atom]Set, map[cue.Kind]Set, bool) {
+// the elements of needDiscrim. The key identifies the values
+// for caching their value sets.
+func (d *discriminator[Set]) discriminators(key valueSetKey, arms0 []cue.Value, selected, needDiscrim Set) (map[Atom]Set, map[cue.Kind]Set, bool) {
+	path := key.path
 	arms := make([]valueSet, len(arms0))
 	for i := range d.sets.values(selected) {
-		arms[i] = valueSetForValue(arms0[i])
+		arms[i] = d.valueSet(key, arms0, i)
 	}
 	byKind := d.kindDiscrim(arms, selected, valueSet.kinds)
 	full := d.fullyDiscriminated(maps.Values(byKind), needDiscrim)
@@ -271,6 +952,10 @@ func (d *discriminator[Set]) discriminators(arms0 []cue.Value, selected, needDis
 		return nil, byKind, full
 	}
 	byValue := d.valueDiscrim(arms, selected)
+	if d.maxValueBranches > 0 && len(byValue) > d.maxValueBranches {
+		d.logf(2, "value switch on %s would need %d branches; limit is %d", path, len(byValue), d.maxValueBranches)
+		return nil, byKind, false
+	}
 	byKind = d.kindDiscrim(arms, selected, func(v valueSet) cue.Kind {
 		return v.types
 	})
@@ -278,11 +963,233 @@ func (d *discriminator[Set]) discriminators(arms0 []cue.Value, selected, needDis
 		delete(byValue, Atom{"null"})
 	}
 	if mapHasKey(byValue, Atom{"true"}) && mapHasKey(byValue, Atom{"false"}) {
-		delete(byKind, cue.BoolKind)
+		// Every bool has its own case, so the default
+		// can't be reached by a bool.
+		if group, ok := byKind[cue.BoolKind]; ok {
+			d.logf(2, "default of switch on %s cannot be a bool for %s: true and false are both cases", path, d.setString(group))
+			delete(byKind, cue.BoolKind)
+		}
 	}
 	return byValue, byKind, d.fullyDiscriminated(iterConcat(maps.Values(byValue), maps.Values(byKind)), needDiscrim)
 }
 
+// rangeGroup holds the arms that allow the numbers in an interval.
+type rangeGroup[Set any] struct {
+	interval Interval
+	arms     Set
+}
+
+// rangeDiscrim returns a discriminator that tells apart the numbers
+// allowed by the selected arms by their bounds, for use when
+// byValue and byKind, as returned by discriminators, fail to
+// discriminate the elements of needDiscrim. It returns nil if
+// the numeric intervals together with the non-numeric parts
+// of byValue and byKind don't fully discriminate them either.
+func (d *discriminator[Set]) rangeDiscrim(values []cue.Value, selected, needDiscrim Set, byValue map[Atom]Set, byKind map[cue.Kind]Set) []rangeGroup[Set] {
+	if !mapHasKey(byKind, cue.IntKind) && !mapHasKey(byKind, cue.FloatKind) {
+		return nil
+	}
+	ivs := make([][]Interval, len(values))
+	for i := range d.sets.values(selected) {
+		v := values[i]
+		if d.eval == EvalDefaults {
+			if dv, ok := v.Default(); ok {
+				v = dv
+			}
+		}
+		ivs[i] = numberIntervals(v)
+	}
+	intervals, sets := numberRanges(ivs)
+	if len(intervals) == 0 {
+		return nil
+	}
+	groups := make([]rangeGroup[Set], len(intervals))
+	for i, iv := range intervals {
+		groups[i] = rangeGroup[Set]{
+			interval: iv,
+			arms:     d.sets.make(),
+		}
+		for _, arm := range sets[i] {
+			d.sets.add(&groups[i].arms, arm)
+		}
+	}
+	byValue, byKind = withoutNumbers(byValue, byKind)
+	all := iterConcat(maps.Values(byValue), maps.Values(byKind), func(yield func(Set) bool) {
+		for _, g := range groups {
+			if !yield(g.arms) {
+				return
+			}
+		}
+	})
+	if !d.fullyDiscriminated(all, needDiscrim) {
+		return nil
+	}
+	return groups
+}
+
+// withoutNumbers returns copies of byValue and byKind
+// without any entries for numbers.
+func withoutNumbers[Set any](byValue map[Atom]Set, byKind map[cue.Kind]Set) (map[Atom]Set, map[cue.Kind]Set) {
+	byValue = maps.Clone(byValue)
+	maps.DeleteFunc(byValue, func(a Atom, _ Set) bool {
+		return a.kind()&cue.NumberKind != 0
+	})
+	byKind = maps.Clone(byKind)
+	delete(byKind, cue.IntKind)
+	delete(byKind, cue.FloatKind)
+	return byValue, byKind
+}
+
+// patternGroup holds the arms that might allow
+// a string that is matched by a pattern.
+type patternGroup[Set any] struct {
+	pattern string
+	arms    Set
+}
+
+// patternDiscrim is like rangeDiscrim except that it tells apart the
+// strings allowed by the selected arms by the regular expressions
+// they must match. The groups are in the order that their patterns
+// should be tested: as a string matched by several patterns is
+// matched by the first of them, each group holds the arms of all
+// the patterns that might also match the string.
+func (d *discriminator[Set]) patternDiscrim(values []cue.Value, selected, needDiscrim Set, byValue map[Atom]Set, byKind map[cue.Kind]Set) []patternGroup[Set] {
+	if !mapHasKey(byKind, cue.StringKind) {
+		return nil
+	}
+	byPattern := make(map[string][]int)
+	// Concrete strings are better told apart by a value
+	// switch, so only use patterns when some arm has one.
+	hasPattern := false
+	for i := range d.sets.values(selected) {
+		v := values[i]
+		if d.eval == EvalDefaults {
+			if dv, ok := v.Default(); ok {
+				v = dv
+			}
+		}
+		patterns := stringPatterns(v)
+		if v.Kind() != cue.StringKind && len(patterns) > 0 && !slices.Equal(patterns, []string{anyString}) {
+			hasPattern = true
+		}
+		for _, p := range patterns {
+			if arms := byPattern[p]; len(arms) == 0 || arms[len(arms)-1] != i {
+				byPattern[p] = append(arms, i)
+			}
+		}
+	}
+	if !hasPattern {
+		return nil
+	}
+	if d.maxValueBranches > 0 && len(byPattern) > d.maxValueBranches {
+		d.logf(2, "pattern switch would need %d branches; limit is %d", len(byPattern), d.maxValueBranches)
+		return nil
+	}
+	// Test the patterns with the longest prefixes first so that
+	// the general patterns act as a fallback for the specific ones.
+	prefixes := make(map[string]string, len(byPattern))
+	for p := range byPattern {
+		prefixes[p], _ = anchoredPrefix(p)
+	}
+	patterns := slices.SortedFunc(maps.Keys(byPattern), func(p0, p1 string) int {
+		return cmp.Or(
+			cmp.Compare(len(prefixes[p1]), len(prefixes[p0])),
+			cmp.Compare(p0, p1),
+		)
+	})
+	groups := make([]patternGroup[Set], len(patterns))
+	for i, p := range patterns {
+		groups[i] = patternGroup[Set]{
+			pattern: p,
+			arms:    d.sets.make(),
+		}
+		for _, p1 := range patterns {
+			if !patternsOverlap(p, p1) {
+				continue
+			}
+			for _, arm := range byPattern[p1] {
+				d.sets.add(&groups[i].arms, arm)
+			}
+		}
+	}
+	byValue, byKind = withoutStrings(byValue, byKind)
+	all := iterConcat(maps.Values(byValue), maps.Values(byKind), func(yield func(Set) bool) {
+		for _, g := range groups {
+			if !yield(g.arms) {
+				return
+			}
+		}
+	})
+	if !d.fullyDiscriminated(all, needDiscrim) {
+		return nil
+	}
+	return groups
+}
+
+// withoutStrings returns copies of byValue and byKind
+// without any entries for strings.
+func withoutStrings[Set any](byValue map[Atom]Set, byKind map[cue.Kind]Set) (map[Atom]Set, map[cue.Kind]Set) {
+	byValue = maps.Clone(byValue)
+	maps.DeleteFunc(byValue, func(a Atom, _ Set) bool {
+		return a.kind() == cue.StringKind
+	})
+	byKind = maps.Clone(byKind)
+	delete(byKind, cue.StringKind)
+	return byValue, byKind
+}
+
+// valueSetKey identifies the values of the arms at a path, as
+// produced by allFields for the given label types, so that their
+// value sets can be cached. The values at the root are the arms
+// themselves, whatever the label types, so they have the key
+// rootValues.
+type valueSetKey struct {
+	path   string
+	labels labelType
+}
+
+var rootValues = valueSetKey{path: "."}
+
+// valueSet returns the value set for the i'th member of arms,
+// which holds the values identified by key.
+func (d *discriminator[Set]) valueSet(key valueSetKey, arms []cue.Value, i int) valueSet {
+	sets := d.valueSets[key]
+	if sets == nil {
+		if d.valueSets == nil {
+			d.valueSets = make(map[valueSetKey][]*valueSet)
+		}
+		sets = make([]*valueSet, len(arms))
+		d.valueSets[key] = sets
+	}
+	if sets[i] == nil {
+		v := arms[i]
+		if d.eval == EvalDefaults {
+			if dv, ok := v.Default(); ok {
+				v = dv
+			}
+		}
+		s := valueSetForValue(v)
+		d.logf(3, "value set of arm %d at %s: %v", i, key.path, s)
+		sets[i] = &s
+	}
+	return *sets[i]
+}
+
+// seedValueSets primes the value set cache for the given values.
+func (d *discriminator[Set]) seedValueSets(key valueSetKey, vsets []valueSet) {
+	if vsets == nil {
+		return
+	}
+	sets := make([]*valueSet, len(vsets))
+	for i := range vsets {
+		sets[i] = &vsets[i]
+	}
+	if d.valueSets == nil {
+		d.valueSets = make(map[valueSetKey][]*valueSet)
+	}
+	d.valueSets[key] = sets
+}
+
 // existenceDiscriminator returns the subset of selected that checking for non-existence
 // will select.
 func (d *discriminator[Set]) existenceDiscriminator(arms []cue.Value, selected Set) Set {
@@ -389,13 +1296,19 @@ func (d *discriminator[Set]) fullyDiscriminated(it iter.Seq[Set], selected Set)
 }
 
 func (d *discriminator[Set]) setString(s Set) string {
-	return SetString(d.asExternalSet(s))
+	return ArmSetString(d.asExternalSet(s), d.armNames)
 }
 
 func (d *discriminator[Set]) newLeaf(s Set) DecisionNode {
-	return &LeafNode{
+	leaf := &LeafNode{
 		Arms: d.asExternalSet(s),
 	}
+	if d.deprecated.Len() > 0 {
+		if dep := intersect(leaf.Arms, d.deprecated); dep.Len() > 0 {
+			leaf.Deprecated = dep
+		}
+	}
+	return leaf
 }
 
 func (d *discriminator[Set]) asExternalSet(s Set) IntSet {
@@ -1,11 +1,13 @@
 package cuediscrim
 
 import (
+	"fmt"
 	"os"
 	"slices"
 	"strings"
 	"testing"
 
+	"cuelang.org/go/cue"
 	"cuelang.org/go/cue/cuecontext"
 	"github.com/go-quicktest/qt"
 )
@@ -47,6 +49,39 @@ case string:
 		cue:  `true`,
 		want: setOf(),
 	}},
+}, {
+	testName: "DisjointKinds",
+	cue:      `1 | "foo" | {a!: int} | [...int] | null`,
+	want: `
+switch kind(.) {
+case null:
+	choose({4})
+case int:
+	choose({0})
+case float:
+	choose({0})
+case string:
+	choose({1})
+case list:
+	choose({3})
+case struct:
+	choose({2})
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "int",
+		cue:  "2",
+		want: setOf(0),
+	}, {
+		name: "struct",
+		cue:  `{a: 1}`,
+		want: setOf(2),
+	}, {
+		name: "bool",
+		cue:  `true`,
+		want: setOf(),
+	}},
 }, {
 	testName: "SimpleValues",
 	cue:      `"foo" | "bar" | true`,
@@ -192,6 +227,368 @@ default:
 		cue:  `{type: "other"}`,
 		want: setOf(),
 	}},
+}, {
+	testName: "QuotedLabel",
+	cue: `
+{
+	"a.b"!: "foo"
+} | {
+	"a.b"!: "bar"
+}`,
+	want: `
+switch "a.b" {
+case "bar":
+	choose({1})
+case "foo":
+	choose({0})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "withFoo",
+		cue:  `{"a.b": "foo"}`,
+		want: setOf(0),
+	}, {
+		name: "withNested",
+		cue:  `{a: b: "foo"}`,
+		want: setOf(),
+	}},
+}, {
+	testName: "DefinitionLabel",
+	cue: `
+{
+	x!: #kind!: "foo"
+} | {
+	x!: #kind!: "bar"
+}`,
+	want: `
+switch x.#kind {
+case "bar":
+	choose({1})
+case "foo":
+	choose({0})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "definition",
+		cue:  `{x: #kind: "bar"}`,
+		want: setOf(1),
+	}, {
+		name: "regular",
+		cue:  `{x: kind: "foo"}`,
+		want: setOf(),
+	}},
+}, {
+	testName: "HiddenLabel",
+	cue: `
+{
+	_kind!: "foo"
+} | {
+	_kind!: "bar"
+}`,
+	want: `
+switch _kind {
+case "bar":
+	choose({1})
+case "foo":
+	choose({0})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "hidden",
+		cue:  `{_kind: "foo"}`,
+		want: setOf(0),
+	}, {
+		name: "regular",
+		cue:  `{kind: "foo"}`,
+		want: setOf(),
+	}},
+}, {
+	testName: "FoldedValues",
+	cue: `
+{
+	kind!: "a" | "b" | "c"
+	x!: int
+} | {
+	kind!: "d" | "e"
+	x!: string
+} | {
+	kind!: "f"
+	x!: bool
+}`,
+	want: `
+switch kind {
+case in {"a", "b", "c"}:
+	choose({0})
+case in {"d", "e"}:
+	choose({1})
+case "f":
+	choose({2})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+}, {
+	testName: "ListOfTaggedStructs",
+	cue: `
+#EventA: {kind!: "a", x!: int}
+#EventB: {kind!: "b", y!: string}
+[...#EventA] | [...#EventB]
+`,
+	want: `
+switch len(.) {
+case 0:
+	choose({0, 1})
+default:
+	switch [0].kind {
+	case "a":
+		choose({0})
+	case "b":
+		choose({1})
+	default:
+		error
+	}
+}
+`,
+	data: []dataTest{{
+		name: "a",
+		cue:  `[{kind: "a", x: 1}, {kind: "a", x: 2}]`,
+		want: setOf(0),
+	}, {
+		name: "b",
+		cue:  `[{kind: "b", y: "x"}]`,
+		want: setOf(1),
+	}, {
+		name: "empty",
+		cue:  `[]`,
+		want: setOf(0, 1),
+	}},
+}, {
+	testName: "NestedListOfTaggedStructs",
+	cue: `
+{batch!: [...{kind!: "a", x!: int}]} |
+{batch!: [...{kind!: "b", y!: string}]}
+`,
+	want: `
+switch len(batch) {
+case 0:
+	choose({0, 1})
+default:
+	switch batch[0].kind {
+	case "a":
+		choose({0})
+	case "b":
+		choose({1})
+	default:
+		error
+	}
+}
+`,
+	data: []dataTest{{
+		name: "b",
+		cue:  `{batch: [{kind: "b", y: "x"}]}`,
+		want: setOf(1),
+	}},
+}, {
+	testName: "ListWithFixedPrefix",
+	cue: `
+{items!: [{kind!: "a", x!: int}, ...]} |
+{items!: [{kind!: "b", y!: string}, ...]}
+`,
+	want: `
+switch items[0].kind {
+case "a":
+	choose({0})
+case "b":
+	choose({1})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "a",
+		cue:  `{items: [{kind: "a", x: 1}, true]}`,
+		want: setOf(0),
+	}, {
+		name: "b",
+		cue:  `{items: [{kind: "b", y: "x"}]}`,
+		want: setOf(1),
+	}},
+}, {
+	testName: "TupleElement",
+	cue: `
+[int, "a"] | [int, "b"] | [...bool]
+`,
+	want: `
+switch len(.) {
+case <=1:
+	choose({2})
+default:
+	switch [1] {
+	case "a":
+		choose({0})
+	case "b":
+		choose({1})
+	default:
+		switch kind([1]) {
+		case bool:
+			choose({2})
+		}
+	}
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "a",
+		cue:  `[1, "a"]`,
+		want: setOf(0),
+	}, {
+		name: "short",
+		cue:  `[true]`,
+		want: setOf(2),
+	}, {
+		name: "bools",
+		cue:  `[true, false]`,
+		want: setOf(2),
+	}},
+}, {
+	testName: "OptionalTag",
+	cue:      `{kind?: "a", x?: int} | {kind?: "b", y?: string} | {kind?: int}`,
+	want: `
+firstOf {
+	present(kind) ->
+		switch kind {
+		case "a":
+			choose({0})
+		case "b":
+			choose({1})
+		default:
+			switch kind(kind) {
+			case int:
+				choose({2})
+			}
+		}
+	default ->
+		choose({0, 1, 2})
+}
+`,
+	data: []dataTest{{
+		name: "b",
+		cue:  `{kind: "b"}`,
+		want: setOf(1),
+	}, {
+		name: "absent",
+		cue:  `{x: 1}`,
+		want: setOf(0, 1, 2),
+	}, {
+		name: "empty",
+		cue:  `{}`,
+		want: setOf(0, 1, 2),
+	}},
+}, {
+	testName: "MixedOptionalTag",
+	cue:      `{kind!: "a", x?: int} | {kind?: "b", y?: string}`,
+	want: `
+firstOf {
+	present(kind) ->
+		switch kind {
+		case "a":
+			choose({0})
+		case "b":
+			choose({1})
+		default:
+			error
+		}
+	default ->
+		choose({1})
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "a",
+		cue:  `{kind: "a"}`,
+		want: setOf(0),
+	}, {
+		name: "empty",
+		cue:  `{}`,
+		want: setOf(1),
+	}},
+}, {
+	testName: "OptionalOnlyTag",
+	cue:      `{kind?: "a"} | {kind?: "b"}`,
+	want: `
+firstOf {
+	present(kind) ->
+		switch kind {
+		case "a":
+			choose({0})
+		case "b":
+			choose({1})
+		default:
+			error
+		}
+	default ->
+		choose({0, 1})
+}
+`,
+	data: []dataTest{{
+		name: "empty",
+		cue:  `{}`,
+		want: setOf(0, 1),
+	}},
+}, {
+	testName: "DefaultTag",
+	cue:      `{kind!: *"a" | "b", x!: int} | {kind!: "z"}`,
+	want: `
+switch kind (absent = "a") {
+case in {"a", "b"}:
+	choose({0})
+case "z":
+	choose({1})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+}, {
+	testName: "EmbeddedScalarTag",
+	cue: `
+{
+	tag!: {"a" | "b", #doc: "first"}
+	x?: int
+} | {
+	tag!: {"c", extra?: int}
+}`,
+	want: `
+switch tag {
+case in {"a", "b"}:
+	choose({0})
+case "c":
+	choose({1})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "b",
+		cue:  `{tag: "b"}`,
+		want: setOf(0),
+	}, {
+		name: "c",
+		cue:  `{tag: "c"}`,
+		want: setOf(1),
+	}},
 }, {
 	testName: "StructsWithSeveralPotentialDiscriminators",
 	cue: `
@@ -259,23 +656,12 @@ case struct:
 }
 `,
 	want: `
-choose({0, 1, 2})
-`,
-	wantPerfect: false,
-}, {
-	testName: "MatchN",
-	cue:      `matchN(1, [true, false, matchN(1, ["foo", "bar" | "baz"])])`,
-	want: `
-switch . {
-case "bar":
-	choose({3})
-case "baz":
-	choose({4})
-case "foo":
+switch (a, b) {
+case ("bar", true):
 	choose({2})
-case false:
+case ("foo", false):
 	choose({1})
-case true:
+case ("foo", true):
 	choose({0})
 default:
 	error
@@ -283,104 +669,1764 @@ default:
 `,
 	wantPerfect: true,
 }, {
-	testName: "MultipleDisjointStructs",
+	testName: "TupleOfThree",
 	cue: `
-{a!: int} | {b!: string} | {c!: bool}
+{a!: 1, b!: 1, c!: 1} |
+{a!: 1, b!: 1, c!: 2} |
+{a!: 1, b!: 2, c!: 1} |
+{a!: 2, b!: 1, c!: 1}
 `,
 	want: `
-allOf {
-	notPresent(a) -> {1, 2}
-	notPresent(b) -> {0, 2}
-	notPresent(c) -> {0, 1}
+switch (a, b, c) {
+case (1, 1, 1):
+	choose({0})
+case (1, 1, 2):
+	choose({1})
+case (1, 2, 1):
+	choose({2})
+case (2, 1, 1):
+	choose({3})
+default:
+	error
 }
 `,
-	wantPerfect: false,
-	data: []dataTest{{
-		name: "hasA",
-		cue:  `{a: 5}`,
+	wantPerfect: true,
+}, {
+	testName: "TupleWithDisjunctions",
+	cue:      `{a!: "x" | "y", b!: 1} | {a!: "x", b!: 2} | {a!: "z" | "x", b!: 3 | 4} | {a!: "y", b!: 2 | 3}`,
+	want: `
+switch (a, b) {
+case ("x", 1):
+	choose({0})
+case ("x", 2):
+	choose({1})
+case ("x", 3):
+	choose({2})
+case ("x", 4):
+	choose({2})
+case ("y", 1):
+	choose({0})
+case ("y", 2):
+	choose({3})
+case ("y", 3):
+	choose({3})
+case ("z", 3):
+	choose({2})
+case ("z", 4):
+	choose({2})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+}, {
+	testName: "SharedValues",
+	cue:      `{type!: "a" | "legacy"} | {type!: "b" | "legacy"} | {type!: "c"}`,
+	want: `
+switch type {
+case "a":
+	choose({0})
+case "b":
+	choose({1})
+case "c":
+	choose({2})
+case "legacy":
+	choose({0, 1})
+default:
+	error
+}
+`,
+	data: []dataTest{{
+		name: "a",
+		cue:  `{type: "a"}`,
+		want: setOf(0),
+	}, {
+		name: "legacy",
+		cue:  `{type: "legacy"}`,
+		want: setOf(0, 1),
+	}, {
+		name: "other",
+		cue:  `{type: "d"}`,
+		want: setOf(),
+	}},
+}, {
+	testName: "MatchN",
+	cue:      `matchN(1, [true, false, matchN(1, ["foo", "bar" | "baz"])])`,
+	want: `
+switch . {
+case "bar":
+	choose({3})
+case "baz":
+	choose({4})
+case "foo":
+	choose({2})
+case false:
+	choose({1})
+case true:
+	choose({0})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+}, {
+	testName: "MultipleDisjointStructs",
+	cue: `
+{a!: int} | {b!: string} | {c!: bool}
+`,
+	want: `
+allOf {
+	notPresent(a) -> {1, 2}
+	notPresent(b) -> {0, 2}
+	notPresent(c) -> {0, 1}
+}
+`,
+	wantPerfect: false,
+	data: []dataTest{{
+		name: "hasA",
+		cue:  `{a: 5}`,
+		want: setOf(0),
+	}, {
+		name: "hasB",
+		cue:  `{b: "ff"}`,
+		want: setOf(1),
+	}, {
+		name: "hasC",
+		cue:  `{b: "ff"}`,
+		want: setOf(1),
+	}, {
+		name: "hasAB",
+		cue:  `{a: 1, b: "x"}`,
+		want: setOf(0, 1),
+	}, {
+		name: "hasAll",
+		cue:  `{a: 1, b: "x", c: true}`,
+		want: setOf(0, 1, 2),
+	}, {
+		name: "hasDifferentType",
+		cue:  `{a: true}`,
+		want: setOf(0),
+	}},
+}, {
+	testName: "ForbiddenFields",
+	cue: `
+{a!: int, b?: _|_} | {b!: string, a?: _|_}
+`,
+	want: `
+firstOf {
+	present(a) ->
+		choose({0})
+	present(b) ->
+		choose({1})
+	default ->
+		error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "hasA",
+		cue:  `{a: 5}`,
+		want: setOf(0),
+	}, {
+		name: "hasB",
+		cue:  `{b: "ff"}`,
+		want: setOf(1),
+	}, {
+		name: "hasNeither",
+		cue:  `{c: true}`,
+		want: setOf(),
+	}},
+}, {
+	testName: "ExclusiveMatchN",
+	cue: `
+a?: int
+b?: string
+c?: bool
+matchN(1, [{a!: _}, {b!: _}, {c!: _}])
+`,
+	want: `
+firstOf {
+	present(a) ->
+		choose({0})
+	present(b) ->
+		choose({1})
+	present(c) ->
+		choose({2})
+	default ->
+		error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "hasC",
+		cue:  `{c: true}`,
+		want: setOf(2),
+	}},
+}, {
+	testName: "GuardedFields",
+	cue: `
+a?: int
+b?: string
+c?: bool
+if a != _|_ {b?: _|_, c?: _|_}
+if b != _|_ {c?: _|_}
+`,
+	want: `
+firstOf {
+	present(a) ->
+		choose({0})
+	present(b) ->
+		choose({1})
+	present(c) ->
+		choose({2})
+	default ->
+		choose({3})
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "hasB",
+		cue:  `{b: "x"}`,
+		want: setOf(1),
+	}, {
+		name: "empty",
+		cue:  `{}`,
+		want: setOf(3),
+	}},
+}, {
+	testName: "NumericRanges",
+	cue:      `>5 | <=0 | >=1 & <=4`,
+	want: `
+switch range(.) {
+case <=0:
+	choose({1})
+case >=1 & <=4:
+	choose({2})
+case >5:
+	choose({0})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "above",
+		cue:  `6`,
+		want: setOf(0),
+	}, {
+		name: "zero",
+		cue:  `0`,
+		want: setOf(1),
+	}, {
+		name: "negative",
+		cue:  `-7.5`,
+		want: setOf(1),
+	}, {
+		name: "within",
+		cue:  `2.5`,
+		want: setOf(2),
+	}, {
+		name: "gap",
+		cue:  `5`,
+		want: setOf(),
+	}, {
+		name: "string",
+		cue:  `"5"`,
+		want: setOf(),
+	}},
+}, {
+	testName: "NumericRangesAndKinds",
+	cue:      `int & <0 | int & >=0 & <10 | 10 | string`,
+	want: `
+switch range(.) {
+case <0:
+	choose({0})
+case >=0 & <10:
+	choose({1})
+case 10:
+	choose({2})
+default:
+	switch kind(.) {
+	case string:
+		choose({3})
+	}
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "negative",
+		cue:  `-1`,
+		want: setOf(0),
+	}, {
+		name: "ten",
+		cue:  `10`,
+		want: setOf(2),
+	}, {
+		name: "above",
+		cue:  `11`,
+		want: setOf(),
+	}, {
+		name: "string",
+		cue:  `"x"`,
+		want: setOf(3),
+	}},
+}, {
+	testName: "NumericRangeField",
+	cue:      `{size!: >100, big!: true} | {size!: <=100, small!: true} | {size!: "unknown"}`,
+	want: `
+switch size {
+case "unknown":
+	choose({2})
+default:
+	switch range(size) {
+	case <=100:
+		choose({1})
+	case >100:
+		choose({0})
+	default:
+		error
+	}
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "big",
+		cue:  `{size: 1000, big: true}`,
+		want: setOf(0),
+	}, {
+		name: "small",
+		cue:  `{size: 100, small: true}`,
+		want: setOf(1),
+	}, {
+		name: "unknown",
+		cue:  `{size: "unknown"}`,
+		want: setOf(2),
+	}, {
+		name: "otherString",
+		cue:  `{size: "other"}`,
+		want: setOf(),
+	}},
+}, {
+	testName: "OverlappingRanges",
+	cue:      `>0 | >10`,
+	want: `
+choose({0, 1}) precedence([1, 0])
+`,
+	wantPerfect: false,
+}, {
+	testName: "ListLengths",
+	cue:      `[int] | [int, int] | [int, int, int, ...int] | string`,
+	want: `
+switch len(.) {
+case 1:
+	choose({0})
+case 2:
+	choose({1})
+case >=3:
+	choose({2})
+default:
+	switch kind(.) {
+	case string:
+		choose({3})
+	}
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "one",
+		cue:  `[1]`,
+		want: setOf(0),
+	}, {
+		name: "two",
+		cue:  `[1, 2]`,
+		want: setOf(1),
+	}, {
+		name: "many",
+		cue:  `[1, 2, 3, 4]`,
+		want: setOf(2),
+	}, {
+		name: "empty",
+		cue:  `[]`,
+		want: setOf(),
+	}, {
+		name: "string",
+		cue:  `"x"`,
+		want: setOf(3),
+	}},
+}, {
+	testName: "OverlappingListLengths",
+	cue:      `[int] | [int, ...int]`,
+	want: `
+switch len(.) {
+case 1:
+	choose({0, 1})
+case >=2:
+	choose({1})
+default:
+	error
+}
+`,
+	wantPerfect: false,
+	data: []dataTest{{
+		name: "one",
+		cue:  `[1]`,
+		want: setOf(0, 1),
+	}, {
+		name: "two",
+		cue:  `[1, 2]`,
+		want: setOf(1),
+	}},
+}, {
+	testName: "ListItemsField",
+	cue: `
+import "list"
+
+{a!: list.MaxItems(2)} | {a!: list.MinItems(3)}
+`,
+	want: `
+switch len(a) {
+case <=2:
+	choose({0})
+case >=3:
+	choose({1})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "empty",
+		cue:  `{a: []}`,
+		want: setOf(0),
+	}, {
+		name: "short",
+		cue:  `{a: [1, 2]}`,
+		want: setOf(0),
+	}, {
+		name: "long",
+		cue:  `{a: [1, 2, 3]}`,
+		want: setOf(1),
+	}, {
+		name: "notList",
+		cue:  `{a: 1}`,
+		want: setOf(),
+	}},
+}, {
+	testName: "ListItemsWithElements",
+	cue: `
+import "list"
+
+list.MaxItems(2) & [...int] | list.MinItems(3) & [...int]
+`,
+	want: `
+switch len(.) {
+case <=2:
+	choose({0})
+case >=3:
+	choose({1})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "short",
+		cue:  `[1]`,
+		want: setOf(0),
+	}, {
+		name: "long",
+		cue:  `[1, 2, 3, 4]`,
+		want: setOf(1),
+	}},
+}, {
+	testName: "StringPatterns",
+	cue:      `=~"^a" | =~"^b" | int`,
+	want: `
+switch regexp(.) {
+case =~"^a":
+	choose({0})
+case =~"^b":
+	choose({1})
+default:
+	switch kind(.) {
+	case int:
+		choose({2})
+	}
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "a",
+		cue:  `"apple"`,
+		want: setOf(0),
+	}, {
+		name: "b",
+		cue:  `"banana"`,
+		want: setOf(1),
+	}, {
+		name: "noMatch",
+		cue:  `"cherry"`,
+		want: setOf(),
+	}, {
+		name: "int",
+		cue:  `3`,
+		want: setOf(2),
+	}},
+}, {
+	testName: "StringPatternField",
+	cue:      `{id!: =~"^usr_", name!: string} | {id!: =~"^org_", members!: [...string]} | {id!: "root"}`,
+	want: `
+switch regexp(id) {
+case =~"^org_":
+	choose({1})
+case =~"^root$":
+	choose({2})
+case =~"^usr_":
+	choose({0})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "user",
+		cue:  `{id: "usr_123", name: "x"}`,
+		want: setOf(0),
+	}, {
+		name: "org",
+		cue:  `{id: "org_1", members: []}`,
+		want: setOf(1),
+	}, {
+		name: "root",
+		cue:  `{id: "root"}`,
+		want: setOf(2),
+	}},
+}, {
+	testName: "StringPatternAlternatives",
+	cue:      `{k!: =~"^a" | =~"^c", x!: int} | {k!: =~"^b", y!: int}`,
+	want: `
+switch regexp(k) {
+case =~"^a":
+	choose({0})
+case =~"^b":
+	choose({1})
+case =~"^c":
+	choose({0})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "a",
+		cue:  `{k: "a1", x: 1}`,
+		want: setOf(0),
+	}, {
+		name: "b",
+		cue:  `{k: "b1", y: 1}`,
+		want: setOf(1),
+	}, {
+		name: "c",
+		cue:  `{k: "c1", x: 1}`,
+		want: setOf(0),
+	}},
+}, {
+	testName: "UnanchoredPatterns",
+	cue:      `=~"a" | =~"b"`,
+	want: `
+choose({0, 1})
+`,
+	wantPerfect: false,
+}, {
+	testName: "ExoticLabels",
+	cue:      `{"ü\u0007"!: {"a.b"!: int}} | {"ü\u0007"!: {"a.b"!: string}}`,
+	want: `
+switch kind("ü\a"."a.b") {
+case int:
+	choose({0})
+case string:
+	choose({1})
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "int",
+		cue:  `{"ü\u0007": {"a.b": 1}}`,
+		want: setOf(0),
+	}, {
+		name: "string",
+		cue:  `{"ü\u0007": {"a.b": "x"}}`,
+		want: setOf(1),
+	}, {
+		name: "notDotted",
+		cue:  `{"ü\u0007": {a: {b: 1}}}`,
+		want: setOf(),
+	}},
+}, {
+	testName: "OpenTag",
+	cue:      `{type!: "a", x!: int} | {type!: "b", y!: int} | {type!: string}`,
+	want: `
+switch type {
+case "a":
+	choose({0, 2})
+case "b":
+	choose({1, 2})
+default:
+	switch kind(type) {
+	case string:
+		choose({2})
+	}
+}
+`,
+	wantPerfect: false,
+	data: []dataTest{{
+		name: "a",
+		cue:  `{type: "a", x: 1}`,
+		want: setOf(0, 2),
+	}, {
+		name: "other",
+		cue:  `{type: "c"}`,
+		want: setOf(2),
+	}, {
+		name: "notString",
+		cue:  `{type: 1}`,
+		want: setOf(),
+	}},
+}, {
+	testName: "ClosedArms",
+	cue:      `close({a!: int}) | close({b!: string})`,
+	want: `
+firstOf {
+	present(a) ->
+		choose({0})
+	present(b) ->
+		choose({1})
+	default ->
+		error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "a",
+		cue:  `{a: 1}`,
+		want: setOf(0),
+	}, {
+		name: "b",
+		cue:  `{b: "x"}`,
+		want: setOf(1),
+	}},
+}, {
+	testName: "ClosedAndOpenArms",
+	cue:      `close({a!: int}) | {b!: string}`,
+	want: `
+firstOf {
+	present(b) ->
+		choose({1})
+	default ->
+		choose({0})
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "a",
+		cue:  `{a: 1}`,
+		want: setOf(0),
+	}, {
+		name: "both",
+		cue:  `{a: 1, b: "x"}`,
+		want: setOf(1),
+	}},
+}, {
+	testName: "ClosedNestedArms",
+	cue:      `{x!: close({a!: int})} | {x!: close({b!: int})}`,
+	want: `
+firstOf {
+	present(x.a) ->
+		choose({0})
+	present(x.b) ->
+		choose({1})
+	default ->
+		error
+}
+`,
+	wantPerfect: true,
+}, {
+	testName: "ClosedArmsWithPattern",
+	cue:      `close({a!: int, [=~"^x"]: int}) | close({xb!: string})`,
+	want: `
+firstOf {
+	present(a) ->
+		choose({0})
+	default ->
+		choose({1})
+}
+`,
+	wantPerfect: true,
+}, {
+	testName: "EmbeddedOptionalFieldGroups",
+	cue: `
+#A: {a?: int, a2?: int}
+#B: {b?: string}
+#D: {
+	common!: string
+	{#A} | {#B}
+}
+#D
+`,
+	want: `
+firstOf {
+	present(a) ->
+		choose({0})
+	present(a2) ->
+		choose({0})
+	present(b) ->
+		choose({1})
+	default ->
+		choose({0, 1})
+}
+`,
+	wantPerfect: false,
+	data: []dataTest{{
+		name: "a2",
+		cue:  `{common: "x", a2: 1}`,
+		want: setOf(0),
+	}, {
+		name: "b",
+		cue:  `{common: "x", b: "y"}`,
+		want: setOf(1),
+	}, {
+		name: "none",
+		cue:  `{common: "x"}`,
+		want: setOf(0, 1),
+	}},
+}}
+
+func TestBuildDecisionTree(t *testing.T) {
+	var opts []Option
+	if testing.Verbose() {
+		opts = append(opts, LogTo(os.Stderr), LogLevel(3))
+	}
+	for _, test := range buildDecisionTreeTests {
+		t.Run(test.testName, func(t *testing.T) {
+			ctx := cuecontext.New()
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+
+			arms := Disjunctions(val)
+			t.Logf("arms: %v", arms)
+			tree, _, isPerfect := Discriminate(arms, opts...)
+			qt.Assert(t, qt.Equals(NodeString(tree), strings.TrimPrefix(test.want, "\n")))
+			qt.Check(t, qt.Equals(isPerfect, test.wantPerfect))
+
+			for _, dtest := range test.data {
+				t.Run(dtest.name, func(t *testing.T) {
+					data := ctx.CompileString(dtest.cue)
+					got := tree.Check(data)
+					qt.Assert(t, deepEquals(ref(got), ref(dtest.want)))
+				})
+			}
+		})
+	}
+}
+
+func ref[T any](x T) *T {
+	return &x
+}
+
+func TestIndentWriter(t *testing.T) {
+	var buf strings.Builder
+	w := &indentWriter{
+		w: &buf,
+	}
+	w.Printf("hello {")
+	w.Indent()
+	w.Printf("foo\nbar {")
+	w.Indent()
+	w.Write([]byte("some\ntext\nwritten"))
+	w.Write([]byte(" directly\n"))
+	w.Unindent()
+	w.Printf("}")
+	w.Unindent()
+	w.Printf("} something")
+	qt.Assert(t, qt.Equals(buf.String(), `
+hello {
+	foo
+	bar {
+		some
+		text
+		written directly
+	}
+} something
+`[1:]))
+}
+
+func setOf(xs ...int) mapSet[int] {
+	return mapSetOf(slices.Values(xs))
+}
+
+func TestDiscriminateSeq(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range buildDecisionTreeTests {
+		t.Run(test.testName, func(t *testing.T) {
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+
+			tree, _, isPerfect := DiscriminateSeq(DisjunctionSeq(val))
+			qt.Assert(t, qt.Equals(NodeString(tree), strings.TrimPrefix(test.want, "\n")))
+			qt.Check(t, qt.Equals(isPerfect, test.wantPerfect))
+		})
+	}
+}
+
+func TestFieldAbsenceNodeNestedPaths(t *testing.T) {
+	ctx := cuecontext.New()
+	n := &FieldAbsenceNode{
+		Branches: map[string]IntSet{
+			"a":   setOf(1, 2),
+			"b.c": setOf(0, 2),
+			"d":   setOf(0, 1),
+		},
+	}
+	for _, test := range []struct {
+		cue  string
+		want IntSet
+	}{
+		{`{a: 1, b: c: 1}`, setOf(0, 1)},
+		{`{a: 1, b: {}}`, setOf(0)},
+		{`{b: c: 1, d: 1}`, setOf(1, 2)},
+		{`{a: 1, b: c: 1, d: 1}`, setOf(0, 1, 2)},
+		{`{}`, setOf()},
+	} {
+		got := n.Check(ctx.CompileString(test.cue))
+		qt.Check(t, deepEquals(ref(mapSetOf(got.Values())), ref(mapSetOf(test.want.Values()))), qt.Commentf("%s", test.cue))
+	}
+}
+
+func TestDiscriminateOptional(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`
+#A: {type!: "a", n!: int}
+#B: {type!: "b", s!: string}
+x: {payload?: #A | #B}
+`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	iter, err := v.LookupPath(cue.ParsePath("x")).Fields(cue.Optional(true))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.IsTrue(iter.Next()))
+	qt.Assert(t, qt.Equals(iter.FieldType()&cue.OptionalConstraint, cue.OptionalConstraint))
+
+	r := DiscriminateValue(iter.Value(), Optional(true))
+	qt.Assert(t, qt.Equals(NodeString(r.Tree), `
+if present(.) {
+	switch type {
+	case "a":
+		choose({0})
+	case "b":
+		choose({1})
+	default:
+		error
+	}
+} else {
+	absent
+}
+`[1:]))
+	qt.Check(t, qt.IsTrue(r.Perfect))
+	qt.Check(t, qt.Equals(r.Tree.Check(cue.Value{}).Len(), 0))
+	qt.Check(t, deepEquals(ref(r.Tree.Check(ctx.CompileString(`{type: "b", s: "x"}`))), ref[IntSet](setOf(1))))
+
+	data, err := EncodeTree(r.Tree)
+	qt.Assert(t, qt.IsNil(err))
+	tree, err := DecodeTree(data)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(NodeString(tree), NodeString(r.Tree)))
+}
+
+var tieBreakTests = []struct {
+	testName string
+	opts     []Option
+	want     string
+}{{
+	testName: "Default",
+	want:     "zkind",
+}, {
+	testName: "Lexical",
+	opts:     []Option{TieBreak(TieBreakLexical)},
+	want:     "akind",
+}, {
+	testName: "Declaration",
+	opts:     []Option{TieBreak(TieBreakDeclaration)},
+	want:     "meta.kind",
+}, {
+	testName: "PreferFields",
+	opts:     []Option{PreferFields("nope", "akind")},
+	want:     "akind",
+}, {
+	testName: "PreferNested",
+	opts:     []Option{TieBreak(TieBreakLexical), PreferFields("meta.kind")},
+	want:     "meta.kind",
+}}
+
+func TestTieBreak(t *testing.T) {
+	ctx := cuecontext.New()
+	val := ctx.CompileString(`
+{
+	meta!: kind!: "a"
+	zkind!: "a"
+	akind!: "a"
+} | {
+	meta!: kind!: "b"
+	zkind!: "b"
+	akind!: "b"
+}`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	for _, test := range tieBreakTests {
+		t.Run(test.testName, func(t *testing.T) {
+			tree, _, isPerfect := Discriminate(Disjunctions(val), test.opts...)
+			qt.Assert(t, qt.IsTrue(isPerfect))
+			qt.Assert(t, qt.Equals(tree.(*ValueSwitchNode).Path, test.want))
+		})
+	}
+}
+
+func TestDisjunctionsDistributesConjuncts(t *testing.T) {
+	ctx := cuecontext.New()
+	// Note: the evaluator reports an error for this value
+	// because {common!: string} does not itself match
+	// any of the arms, so we don't check val.Err.
+	val := ctx.CompileString(`
+matchN(1, [{
+	type!: "a"
+	x!: int
+}, {
+	type!: "b"
+}, {
+	type!: "c"
+	common!: int
+}]) & {
+	common!: string
+}`)
+	arms := Disjunctions(val)
+	qt.Assert(t, qt.HasLen(arms, 2))
+	for _, arm := range arms {
+		qt.Check(t, qt.IsTrue(arm.LookupPath(cue.MakePath(cue.Str("common").Required())).Exists()))
+	}
+	tree, _, isPerfect := Discriminate(arms)
+	qt.Assert(t, qt.Equals(NodeString(tree), `
+switch type {
+case "a":
+	choose({0})
+case "b":
+	choose({1})
+default:
+	error
+}
+`[1:]))
+	qt.Assert(t, qt.IsTrue(isPerfect))
+}
+
+func TestWriteNodeMaxOutput(t *testing.T) {
+	ctx := cuecontext.New()
+	val := ctx.CompileString(`{type!: "a"} | {type!: "b"} | {type!: "c"}`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	tree, _, _ := Discriminate(Disjunctions(val))
+
+	var buf strings.Builder
+	err := WriteNode(&buf, tree)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(buf.String(), NodeString(tree)))
+
+	buf.Reset()
+	err = WriteNode(&buf, tree, MaxOutput(40))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(buf.String(), `
+switch type {
+case "a":
+	choose({0})
+...
+`[1:]))
+}
+
+func TestWriteNodePathPrinter(t *testing.T) {
+	ctx := cuecontext.New()
+	val := ctx.CompileString(`
+#R: {spec!: {type!: "a" | "b"}} | {spec!: {type!: "c"}} | string
+`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	r := val.LookupPath(cue.ParsePath("#R"))
+	tree, _, _ := Discriminate(Disjunctions(r))
+
+	var buf strings.Builder
+	err := WriteNode(&buf, tree, PathPrinter(func(path string) string {
+		p, err := CUEPath(path)
+		qt.Assert(t, qt.IsNil(err))
+		return cue.MakePath(append(r.Path().Selectors(), p.Selectors()...)...).String()
+	}))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(buf.String(), `
+switch kind(#R) {
+case string:
+	choose({2})
+case struct:
+	switch #R.spec.type {
+	case in {"a", "b"}:
+		choose({0})
+	case "c":
+		choose({1})
+	default:
+		error
+	}
+}
+`[1:]))
+}
+
+func TestEvaluateDefaults(t *testing.T) {
+	ctx := cuecontext.New()
+	val := ctx.CompileString(`
+{_t: *"a" | "b", kind!: _t, x?: int} |
+{_t: *"b" | "c", kind!: _t, y?: string}
+`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	arms := Disjunctions(val)
+
+	tree, _, isPerfect := Discriminate(arms, Evaluate(EvalSimplify))
+	qt.Check(t, qt.IsFalse(isPerfect))
+	qt.Check(t, qt.Equals(NodeString(tree), `
+switch kind {
+case "a":
+	choose({0})
+case "b":
+	choose({0, 1})
+case "c":
+	choose({1})
+default:
+	error
+}
+`[1:]))
+
+	tree, _, isPerfect = Discriminate(arms, Evaluate(EvalDefaults))
+	qt.Check(t, qt.IsTrue(isPerfect))
+	qt.Check(t, qt.Equals(NodeString(tree), `
+switch kind {
+case "a":
+	choose({0})
+case "b":
+	choose({1})
+default:
+	error
+}
+`[1:]))
+}
+
+func TestWarnings(t *testing.T) {
+	ctx := cuecontext.New()
+	val := ctx.CompileString(`
+{batch!: [...{kind!: "a", x!: int}], kind!: "x"} |
+{batch!: [...{kind!: "b", y!: string}], kind!: "x"}
+`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	r := Analyze(Disjunctions(val))
+	qt.Assert(t, qt.IsFalse(r.Perfect))
+	qt.Assert(t, qt.DeepEquals(r.Warnings, []Warning{{
+		Path:    "batch",
+		Message: "length 0 is allowed by arms {0, 1}, so they are not discriminated",
+	}}))
+
+	// An empty list is an instance of neither arm,
+	// so there's nothing to warn about.
+	val = ctx.CompileString(`
+{batch!: [{kind!: "a", x!: int}, ...]} |
+{batch!: [{kind!: "b", y!: string}, ...]}
+`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	r = Analyze(Disjunctions(val))
+	qt.Assert(t, qt.IsTrue(r.Perfect))
+	qt.Assert(t, qt.HasLen(r.Warnings, 0))
+
+	val = ctx.CompileString(`{kind?: "a", x?: int} | {kind?: "b", y?: string}`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	r = Analyze(Disjunctions(val))
+	qt.Assert(t, qt.IsFalse(r.Perfect))
+	qt.Assert(t, qt.DeepEquals(r.Warnings, []Warning{{
+		Path:    "kind",
+		Message: "discriminating field is optional, so a value without it is not discriminated; consider making it required",
+	}}))
+
+	val = ctx.CompileString(`{kind!: "a" | "old" | "legacy"} | {kind!: "b" | "old" | "legacy"} | {kind!: "c" | "legacy"}`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	r = Analyze(Disjunctions(val))
+	qt.Assert(t, qt.IsFalse(r.Perfect))
+	qt.Assert(t, qt.DeepEquals(r.Warnings, []Warning{{
+		Path:    "kind",
+		Message: `value "legacy" is allowed by arms {0, 1, 2}, so they are not discriminated`,
+	}, {
+		Path:    "kind",
+		Message: `value "old" is allowed by arms {0, 1}, so they are not discriminated`,
+	}}))
+
+	val = ctx.CompileString(`{kind!: "a"} | {kind!: "b"} | {kind!: string}`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	r = Analyze(Disjunctions(val))
+	qt.Assert(t, qt.IsFalse(r.Perfect))
+	qt.Assert(t, qt.DeepEquals(r.Warnings, []Warning{{
+		Path:    "kind",
+		Message: `value "a" is allowed by arms {0, 2}, so they are not discriminated`,
+	}, {
+		Path:    "kind",
+		Message: `value "b" is allowed by arms {1, 2}, so they are not discriminated`,
+	}, {
+		Path:    "kind",
+		Message: `tag accepts arbitrary strings in arms {2}, so its values do not tell those arms apart from the others`,
+	}}))
+
+	// An arm that allows any int is told apart from
+	// the arm of a string constant.
+	val = ctx.CompileString(`{kind!: "a"} | {kind!: int}`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	r = Analyze(Disjunctions(val))
+	qt.Assert(t, qt.IsTrue(r.Perfect))
+	qt.Assert(t, qt.HasLen(r.Warnings, 0))
+
+	// Lengths allowed by several arms are reported.
+	val = ctx.CompileString(`{a!: [int]} | {a!: [int, ...int]} | {a!: [int, int, int, ...int]}`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	r = Analyze(Disjunctions(val))
+	qt.Assert(t, qt.IsFalse(r.Perfect))
+	qt.Assert(t, qt.DeepEquals(r.Warnings, []Warning{{
+		Path:    "a",
+		Message: `length 1 is allowed by arms {0, 1}, so they are not discriminated`,
+	}, {
+		Path:    "a",
+		Message: `lengths >=3 are allowed by arms {1, 2}, so they are not discriminated`,
+	}}))
+
+	val = ctx.CompileString(`{kind!: "a"} | {kind!: "b"}`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	qt.Assert(t, qt.HasLen(Analyze(Disjunctions(val)).Warnings, 0))
+}
+
+func TestExhaustiveDefault(t *testing.T) {
+	// Every bool has a case, so the default is an error, and
+	// the log says why.
+	val := cuecontext.New().CompileString(`{a!: bool} | {a!: true} | {a!: false}`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	var buf strings.Builder
+	tree, _, _ := Discriminate(Disjunctions(val), LogTo(&buf), LogLevel(2))
+	qt.Check(t, qt.Equals(NodeString(tree), `
+switch a {
+case false:
+	choose({0, 2}) precedence([2, 0])
+case true:
+	choose({0, 1}) precedence([1, 0])
+default:
+	error
+}
+`[1:]))
+	qt.Check(t, qt.StringContains(buf.String(), "default of switch on a cannot be a bool for {0}: true and false are both cases"))
+}
+
+var maxValueBranchesTests = []struct {
+	testName    string
+	cue         string
+	max         int
+	want        string
+	wantPerfect bool
+}{{
+	testName: "WithinLimit",
+	cue:      `{t!: "a"} | {t!: "b"}`,
+	max:      2,
+	want: `
+switch t {
+case "a":
+	choose({0})
+case "b":
+	choose({1})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+}, {
+	testName: "OtherField",
+	cue:      `{t!: "a", k!: int} | {t!: "b", k!: string} | {t!: "c", k!: bool}`,
+	max:      2,
+	want: `
+switch kind(k) {
+case bool:
+	choose({2})
+case int:
+	choose({0})
+case string:
+	choose({1})
+}
+`,
+	wantPerfect: true,
+}, {
+	testName: "KindFallback",
+	cue:      `"a" | "b" | "c" | int`,
+	max:      2,
+	want: `
+switch kind(.) {
+case int:
+	choose({3})
+case string:
+	choose({0, 1, 2})
+}
+`,
+}}
+
+func TestMaxValueBranches(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range maxValueBranchesTests {
+		t.Run(test.testName, func(t *testing.T) {
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			tree, _, isPerfect := Discriminate(Disjunctions(val), MaxValueBranches(test.max))
+			qt.Check(t, qt.Equals(NodeString(tree), strings.TrimPrefix(test.want, "\n")))
+			qt.Check(t, qt.Equals(isPerfect, test.wantPerfect))
+		})
+	}
+}
+
+var maxDepthTests = []struct {
+	testName    string
+	cue         string
+	max         int
+	want        string
+	wantPerfect bool
+}{{
+	testName: "WithinLimit",
+	cue:      `{spec!: {kind!: "a"}} | {spec!: {kind!: "b"}}`,
+	max:      2,
+	want: `
+switch spec.kind {
+case "a":
+	choose({0})
+case "b":
+	choose({1})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+}, {
+	testName: "TooDeep",
+	cue:      `{spec!: {kind!: "a"}} | {spec!: {kind!: "b"}}`,
+	max:      1,
+	want: `
+choose({0, 1})
+`,
+}, {
+	testName: "ShallowerUsed",
+	cue:      `{t!: "a", spec!: {kind!: "x"}} | {t!: "b", spec!: {kind!: "x"}} | {t!: "b", spec!: {kind!: "y"}}`,
+	max:      1,
+	want: `
+switch t {
+case "a":
+	choose({0})
+case "b":
+	choose({1, 2})
+default:
+	error
+}
+`,
+}, {
+	testName: "ListIndex",
+	cue:      `{items!: [{kind!: "a"}]} | {items!: [{kind!: "b"}]}`,
+	max:      2,
+	want: `
+choose({0, 1})
+`,
+}}
+
+func TestMaxDepth(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range maxDepthTests {
+		t.Run(test.testName, func(t *testing.T) {
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			tree, _, isPerfect := Discriminate(Disjunctions(val), MaxDepth(test.max))
+			qt.Check(t, qt.Equals(NodeString(tree), strings.TrimPrefix(test.want, "\n")))
+			qt.Check(t, qt.Equals(isPerfect, test.wantPerfect))
+		})
+	}
+}
+
+var pathPatternsTests = []struct {
+	testName    string
+	cue         string
+	include     []string
+	exclude     []string
+	want        string
+	wantPerfect bool
+}{{
+	testName: "Exclude",
+	cue:      `{metadata!: {name!: "a"}, spec!: {kind!: int}} | {metadata!: {name!: "b"}, spec!: {kind!: string}}`,
+	exclude:  []string{"metadata.name"},
+	want: `
+switch kind(spec.kind) {
+case int:
+	choose({0})
+case string:
+	choose({1})
+}
+`,
+	wantPerfect: true,
+}, {
+	testName: "Include",
+	cue:      `{type!: "a", spec!: {kind!: int}} | {type!: "b", spec!: {kind!: string}}`,
+	include:  []string{"spec.*"},
+	want: `
+switch kind(spec.kind) {
+case int:
+	choose({0})
+case string:
+	choose({1})
+}
+`,
+	wantPerfect: true,
+}, {
+	testName: "IncludeAndExclude",
+	cue:      `{spec!: {a!: "x", b!: 1}} | {spec!: {a!: "y", b!: 2}}`,
+	include:  []string{"spec.*"},
+	exclude:  []string{"spec.a"},
+	want: `
+switch spec.b {
+case 1:
+	choose({0})
+case 2:
+	choose({1})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+}, {
+	testName: "NothingAllowed",
+	cue:      `{type!: "a"} | {type!: "b"} | int`,
+	exclude:  []string{"*"},
+	want: `
+switch kind(.) {
+case int:
+	choose({2})
+case struct:
+	choose({0, 1})
+}
+`,
+}}
+
+func TestPathPatterns(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range pathPatternsTests {
+		t.Run(test.testName, func(t *testing.T) {
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			tree, _, isPerfect := Discriminate(Disjunctions(val), IncludePaths(test.include...), ExcludePaths(test.exclude...))
+			qt.Check(t, qt.Equals(NodeString(tree), strings.TrimPrefix(test.want, "\n")))
+			qt.Check(t, qt.Equals(isPerfect, test.wantPerfect))
+		})
+	}
+}
+
+var closedWorldTests = []struct {
+	testName    string
+	cue         string
+	want        string
+	wantPerfect bool
+	data        []dataTest
+}{{
+	testName: "DistinctFields",
+	cue:      `{a!: int} | {b!: string}`,
+	want: `
+firstOf {
+	present(a) ->
+		choose({0})
+	present(b) ->
+		choose({1})
+	default ->
+		error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "a",
+		cue:  `{a: 1}`,
 		want: setOf(0),
 	}, {
-		name: "hasB",
-		cue:  `{b: "ff"}`,
-		want: setOf(1),
-	}, {
-		name: "hasC",
-		cue:  `{b: "ff"}`,
+		name: "b",
+		cue:  `{b: "x"}`,
 		want: setOf(1),
 	}, {
-		name: "hasAB",
-		cue:  `{a: 1, b: "x"}`,
-		want: setOf(0, 1),
+		name: "neither",
+		cue:  `{c: 1}`,
+		want: setOf(),
+	}},
+}, {
+	testName: "DeclaredElsewhere",
+	cue:      `{a!: int, b?: int} | {b!: string}`,
+	want: `
+firstOf {
+	present(a) ->
+		choose({0})
+	default ->
+		choose({1})
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "both",
+		cue:  `{a: 1, b: 2}`,
+		want: setOf(0),
 	}, {
-		name: "hasAll",
-		cue:  `{a: 1, b: "x", c: true}`,
-		want: setOf(0, 1, 2),
+		name: "b",
+		cue:  `{b: "x"}`,
+		want: setOf(1),
+	}},
+}, {
+	testName: "SharedField",
+	cue:      `{a!: int, x!: 1} | {a!: int, x!: 2} | {b!: string}`,
+	want: `
+firstOf {
+	present(a) ->
+		switch x {
+		case 1:
+			choose({0})
+		case 2:
+			choose({1})
+		default:
+			error
+		}
+	present(b) ->
+		choose({2})
+	default ->
+		error
+}
+`,
+	wantPerfect: true,
+	data: []dataTest{{
+		name: "x2",
+		cue:  `{a: 1, x: 2}`,
+		want: setOf(1),
 	}, {
-		name: "hasDifferentType",
-		cue:  `{a: true}`,
-		want: setOf(0),
+		name: "b",
+		cue:  `{b: "x"}`,
+		want: setOf(2),
 	}},
+}, {
+	testName: "Nested",
+	cue:      `{x!: {a!: int}} | {x!: {b!: int}}`,
+	want: `
+firstOf {
+	present(x.a) ->
+		choose({0})
+	present(x.b) ->
+		choose({1})
+	default ->
+		error
+}
+`,
+	wantPerfect: true,
+}, {
+	testName: "PatternConstraint",
+	cue:      `{a!: int} | {[string]: string}`,
+	want: `
+choose({0, 1})
+`,
 }}
 
-func TestBuildDecisionTree(t *testing.T) {
-	var opts []Option
-	if testing.Verbose() {
-		opts = append(opts, LogTo(os.Stderr))
-	}
-	for _, test := range buildDecisionTreeTests {
+func TestClosedWorld(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range closedWorldTests {
 		t.Run(test.testName, func(t *testing.T) {
-			ctx := cuecontext.New()
 			val := ctx.CompileString(test.cue)
 			qt.Assert(t, qt.IsNil(val.Err()))
-
-			arms := Disjunctions(val)
-			t.Logf("arms: %v", arms)
-			tree, _, isPerfect := Discriminate(arms, opts...)
+			tree, _, isPerfect := Discriminate(Disjunctions(val), ClosedWorld(true))
 			qt.Assert(t, qt.Equals(NodeString(tree), strings.TrimPrefix(test.want, "\n")))
 			qt.Check(t, qt.Equals(isPerfect, test.wantPerfect))
 
+			data, err := EncodeTree(tree)
+			qt.Assert(t, qt.IsNil(err))
+			tree1, err := DecodeTree(data)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Check(t, qt.Equals(NodeString(tree1), NodeString(tree)))
+
+			p := NewPolicy(tree)
+			_, err = p.CUE()
+			qt.Assert(t, qt.IsNil(err))
 			for _, dtest := range test.data {
-				t.Run(dtest.name, func(t *testing.T) {
-					data := ctx.CompileString(dtest.cue)
-					got := tree.Check(data)
-					qt.Assert(t, deepEquals(ref(got), ref(dtest.want)))
-				})
+				data := ctx.CompileString(dtest.cue)
+				qt.Check(t, deepEquals(ref(tree.Check(data)), ref(dtest.want)), qt.Commentf("data %s", dtest.name))
+				qt.Check(t, deepEquals(ref(mapSetOf(p.Check(data).Values())), ref(mapSetOf(dtest.want.Values()))), qt.Commentf("policy data %s", dtest.name))
 			}
 		})
 	}
 }
 
-func ref[T any](x T) *T {
-	return &x
+func TestClosedWorldDisabled(t *testing.T) {
+	val := cuecontext.New().CompileString(`{a!: int} | {b!: string}`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	tree, _, isPerfect := Discriminate(Disjunctions(val))
+	qt.Check(t, qt.IsFalse(isPerfect))
+	_, ok := tree.(*FieldAbsenceNode)
+	qt.Check(t, qt.IsTrue(ok))
 }
 
-func TestIndentWriter(t *testing.T) {
-	var buf strings.Builder
-	w := &indentWriter{
-		w: &buf,
+var optionalFieldsTests = []struct {
+	testName    string
+	cue         string
+	want        string
+	wantPerfect bool
+	data        []dataTest
+}{{
+	testName: "OptionalEverywhere",
+	cue:      `{kind?: "a"} | {kind?: "b"}`,
+	want: `
+firstOf {
+	present(kind) ->
+		switch kind {
+		case "a":
+			choose({0})
+		case "b":
+			choose({1})
+		default:
+			error
+		}
+	default ->
+		choose({0, 1})
+}
+`,
+	data: []dataTest{{
+		name: "b",
+		cue:  `{kind: "b"}`,
+		want: setOf(1),
+	}, {
+		name: "absent",
+		cue:  `{}`,
+		want: setOf(0, 1),
+	}},
+}, {
+	testName: "FallbackOnRequired",
+	cue:      `{kind?: "a", x!: int} | {kind?: "b", y!: int} | {kind?: "c", z!: int}`,
+	want: `
+firstOf {
+	present(kind) ->
+		switch kind {
+		case "a":
+			choose({0})
+		case "b":
+			choose({1})
+		case "c":
+			choose({2})
+		default:
+			error
+		}
+	default ->
+		allOf {
+			notPresent(x) -> {1, 2}
+			notPresent(y) -> {0, 2}
+			notPresent(z) -> {0, 1}
+		}
+}
+`,
+	data: []dataTest{{
+		name: "absent",
+		cue:  `{y: 1}`,
+		want: setOf(1),
+	}},
+}, {
+	testName: "RequiredInSome",
+	cue:      `{kind!: "a"} | {kind?: "b"} | {kind?: "c"}`,
+	want: `
+firstOf {
+	present(kind) ->
+		switch kind {
+		case "a":
+			choose({0})
+		case "b":
+			choose({1})
+		case "c":
+			choose({2})
+		default:
+			error
+		}
+	default ->
+		choose({1, 2})
+}
+`,
+}, {
+	testName: "RequiredPreferred",
+	cue:      `{kind?: "a", type!: "x"} | {kind?: "b", type!: "y"}`,
+	want: `
+switch type {
+case "x":
+	choose({0})
+case "y":
+	choose({1})
+default:
+	error
+}
+`,
+	wantPerfect: true,
+}}
+
+func TestOptionalFields(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range optionalFieldsTests {
+		t.Run(test.testName, func(t *testing.T) {
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			tree, _, isPerfect := Discriminate(Disjunctions(val), OptionalFields(true))
+			qt.Assert(t, qt.Equals(NodeString(tree), strings.TrimPrefix(test.want, "\n")))
+			qt.Check(t, qt.Equals(isPerfect, test.wantPerfect))
+			for _, dtest := range test.data {
+				data := ctx.CompileString(dtest.cue)
+				qt.Check(t, qt.DeepEquals(sortedInts(tree.Check(data)), sortedInts(dtest.want)), qt.Commentf("data %s", dtest.name))
+			}
+		})
 	}
-	w.Printf("hello {")
-	w.Indent()
-	w.Printf("foo\nbar {")
-	w.Indent()
-	w.Write([]byte("some\ntext\nwritten"))
-	w.Write([]byte(" directly\n"))
-	w.Unindent()
-	w.Printf("}")
-	w.Unindent()
-	w.Printf("} something")
-	qt.Assert(t, qt.Equals(buf.String(), `
-hello {
-	foo
-	bar {
-		some
-		text
-		written directly
+}
+
+var enumsTests = []struct {
+	testName string
+	arms     int
+	opts     []Option
+	want     EnumStrategy
+}{{
+	testName: "Unset",
+	arms:     DefaultEnumThreshold + 1,
+	want:     EnumAuto,
+}, {
+	testName: "AutoSmall",
+	arms:     DefaultEnumThreshold,
+	opts:     []Option{Enums(EnumAuto, 0)},
+	want:     EnumSwitch,
+}, {
+	testName: "AutoLarge",
+	arms:     DefaultEnumThreshold + 1,
+	opts:     []Option{Enums(EnumAuto, 0)},
+	want:     EnumMap,
+}, {
+	testName: "AutoLowThreshold",
+	arms:     3,
+	opts:     []Option{Enums(EnumAuto, 2)},
+	want:     EnumMap,
+}, {
+	testName: "BinarySearch",
+	arms:     3,
+	opts:     []Option{Enums(EnumBinarySearch, 0)},
+	want:     EnumBinarySearch,
+}}
+
+func TestEnums(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range enumsTests {
+		t.Run(test.testName, func(t *testing.T) {
+			var arms []cue.Value
+			for i := range test.arms {
+				arms = append(arms, ctx.CompileString(fmt.Sprintf(`{kind!: "k%d"}`, i)))
+			}
+			tree, _, isPerfect := Discriminate(arms, test.opts...)
+			qt.Assert(t, qt.IsTrue(isPerfect))
+			n, ok := tree.(*ValueSwitchNode)
+			qt.Assert(t, qt.IsTrue(ok))
+			qt.Check(t, qt.Equals(n.Enum, test.want))
+
+			// The strategy survives encoding.
+			data, err := EncodeTree(tree)
+			qt.Assert(t, qt.IsNil(err))
+			tree1, err := DecodeTree(data)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Check(t, qt.Equals(tree1.(*ValueSwitchNode).Enum, test.want))
+		})
 	}
-} something
-`[1:]))
 }
 
-func setOf(xs ...int) mapSet[int] {
-	return mapSetOf(slices.Values(xs))
+var logLevelTests = []struct {
+	level int
+	want  string
+}{{
+	level: 0,
+	want: `
+discriminate {0, 1, 2} {
+	chose .
+	discriminate {0, 1} {
+		chose type
+	} -> *cuediscrim.ValueSwitchNode
+} -> *cuediscrim.KindSwitchNode
+`,
+}, {
+	level: 2,
+	want: `
+discriminate {0, 1, 2} {
+	chose .
+	kind list: {2}
+	kind struct: {0, 1}
+	discriminate {0, 1} {
+		----- PATH type
+		fully discriminated
+		chose type
+		valSwitch "a"
+		valSwitch "b"
+	} -> *cuediscrim.ValueSwitchNode
+} -> *cuediscrim.KindSwitchNode
+`,
+}, {
+	level: 3,
+	want: `
+discriminate {0, 1, 2} {
+	value set of arm 0 at .: (struct)
+	value set of arm 1 at .: (struct)
+	value set of arm 2 at .: (list)
+	chose .
+	kind list: {2}
+	kind struct: {0, 1}
+	discriminate {0, 1} {
+		----- PATH type
+		value set of arm 0 at type: ("a")
+		value set of arm 1 at type: ("b")
+		fully discriminated
+		values:
+			"a": {0}
+			"b": {1}
+		kinds:
+		chose type
+		valSwitch "a"
+		valSwitch "b"
+	} -> *cuediscrim.ValueSwitchNode
+} -> *cuediscrim.KindSwitchNode
+`,
+}}
+
+func TestLogLevel(t *testing.T) {
+	val := cuecontext.New().CompileString(`{type!: "a", x!: int} | {type!: "b"} | [...string]`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	for _, test := range logLevelTests {
+		var buf strings.Builder
+		Discriminate(Disjunctions(val), LogTo(&buf), LogLevel(test.level))
+		qt.Check(t, qt.Equals(buf.String(), strings.TrimPrefix(test.want, "\n")), qt.Commentf("level %d", test.level))
+	}
+}
+
+func TestCaptureLog(t *testing.T) {
+	val := cuecontext.New().CompileString(`{type!: "a", x!: int} | {type!: "b"} | [...string]`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+
+	r := DiscriminateValue(val, CaptureLog(1000))
+	qt.Check(t, qt.Equals(r.Log, strings.TrimPrefix(logLevelTests[0].want, "\n")))
+
+	// Only the most recent lines are kept.
+	r = Analyze(Disjunctions(val), CaptureLog(80))
+	qt.Check(t, qt.Equals(r.Log, `...
+		chose type
+	} -> *cuediscrim.ValueSwitchNode
+} -> *cuediscrim.KindSwitchNode
+`))
+
+	// The log is still written to the LogTo writer.
+	var buf strings.Builder
+	r = Analyze(Disjunctions(val), CaptureLog(1000), LogTo(&buf), LogLevel(2))
+	qt.Check(t, qt.Equals(r.Log, buf.String()))
+	qt.Check(t, qt.StringContains(r.Log, "----- PATH type"))
+
+	r = Analyze(Disjunctions(val))
+	qt.Check(t, qt.Equals(r.Log, ""))
 }
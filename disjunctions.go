@@ -2,6 +2,7 @@ package cuediscrim
 
 import (
 	"fmt"
+	"iter"
 
 	"cuelang.org/go/cue"
 )
@@ -9,18 +10,70 @@ import (
 // Disjunctions splits v into its component disjunctions,
 // including disjunctions in subexpressions.
 // Any matchN operator with an argument of 1 also counts as a disjunction.
+// When each arm of such a matchN does nothing but require a single
+// field, as in matchN(1, [{a!: _}, {b!: _}]), each returned arm
+// also forbids the fields required by the others, because no more
+// than one of them can be present. A struct that makes some of its
+// optional fields mutually exclusive, as with
+//
+//	if a != _|_ { b?: _|_ }
+//
+// also counts as a disjunction, with an arm for each of those fields
+// and one for when none of them is present.
 func Disjunctions(v cue.Value) []cue.Value {
 	return appendDisjunctions(nil, v)
 }
 
+// DisjunctionSeq is like [Disjunctions] but returns an iterator
+// that expands the disjunctions lazily rather than
+// materializing them all up front.
+func DisjunctionSeq(v cue.Value) iter.Seq[cue.Value] {
+	return func(yield func(cue.Value) bool) {
+		yieldDisjunctions(v, yield)
+	}
+}
+
 func appendDisjunctions(dst []cue.Value, v cue.Value) []cue.Value {
+	yieldDisjunctions(v, func(v cue.Value) bool {
+		dst = append(dst, v)
+		return true
+	})
+	return dst
+}
+
+// yieldDisjunctions calls yield for each disjunction in v.
+// It reports whether the iteration should continue.
+func yieldDisjunctions(v cue.Value, yield func(cue.Value) bool) bool {
+	return walkDisjunctions(v, yield, nil)
+}
+
+// walkDisjunctions is like yieldDisjunctions except that, if group
+// is non-nil, it is called with enter=true before the arms of a matchN
+// call are produced and with enter=false afterwards, so that
+// the caller can see how the arms are grouped.
+func walkDisjunctions(v cue.Value, yield func(cue.Value) bool, group func(v cue.Value, enter bool)) bool {
 	op, args := v.Eval().Expr()
+	if op != cue.OrOp {
+		if arms, ok := conjunctionArms(v); ok {
+			for _, arm := range arms {
+				if !yield(arm) {
+					return false
+				}
+			}
+			return true
+		}
+		if arms, ok := collapsedArms(v); ok {
+			op, args = cue.OrOp, arms
+		}
+	}
 	switch op {
 	case cue.OrOp:
 		for _, v := range args {
-			dst = appendDisjunctions(dst, v)
+			if !walkDisjunctions(v, yield, group) {
+				return false
+			}
 		}
-		return dst
+		return true
 	case cue.CallOp:
 		if fmt.Sprint(args[0]) != "matchN" {
 			break
@@ -38,10 +91,115 @@ func appendDisjunctions(dst []cue.Value, v cue.Value) []cue.Value {
 		if err != nil {
 			break
 		}
+		if group != nil {
+			group(v, true)
+			defer group(v, false)
+		}
+		var elems []cue.Value
 		for iter.Next() {
-			dst = appendDisjunctions(dst, iter.Value())
+			elems = append(elems, iter.Value())
+		}
+		if n == 1 {
+			if arms, ok := exclusiveArms(elems); ok {
+				for _, arm := range arms {
+					if !yield(arm) {
+						return false
+					}
+				}
+				return true
+			}
+		}
+		for _, elem := range elems {
+			if !walkDisjunctions(elem, yield, group) {
+				return false
+			}
+		}
+		return true
+	}
+	if arms, ok := guardedArms(v); ok {
+		for _, arm := range arms {
+			if !yield(arm) {
+				return false
+			}
+		}
+		return true
+	}
+	return yield(v)
+}
+
+// collapsedArms handles a disjunction that evaluation presents as
+// a single value even though its arms differ, as happens with lists
+// constrained by validators such as
+// list.MaxItems(2) & [...int] | list.MinItems(3) & [...int].
+// It returns the arms as written, but only when none of them is an
+// instance of another, so that a disjunction whose arms really are
+// duplicates, such as {a!: int} | {a!: int}, is still treated as
+// a single value. It reports false if v isn't such a disjunction.
+func collapsedArms(v cue.Value) ([]cue.Value, bool) {
+	op, args := v.Expr()
+	if op != cue.OrOp || len(args) < 2 {
+		return nil, false
+	}
+	for i, arg := range args {
+		if arg.Validate() != nil {
+			// Evaluation left the arm out because it is
+			// an error, rather than merely incomplete.
+			return nil, false
+		}
+		for j, arg1 := range args {
+			if i != j && arg.Subsume(arg1, cue.Schema()) == nil {
+				return nil, false
+			}
+		}
+	}
+	return args, true
+}
+
+// conjunctionArms handles a conjunction such as
+// matchN(1, [A, B]) & {common!: string}, which the evaluator
+// does not turn into a disjunction itself. It distributes
+// the other conjuncts over the arms of the first conjunct
+// that is a disjunction, returning the resulting arms.
+// Arms that conflict with the other conjuncts are omitted.
+// It reports false if v isn't such a conjunction.
+func conjunctionArms(v cue.Value) ([]cue.Value, bool) {
+	if op, _ := v.Expr(); op != cue.AndOp {
+		return nil, false
+	}
+	conjuncts := appendConjuncts(nil, v)
+	for i, c := range conjuncts {
+		arms := Disjunctions(c)
+		if len(arms) < 2 {
+			continue
+		}
+		var result []cue.Value
+		for _, arm := range arms {
+			for j, c1 := range conjuncts {
+				if j != i {
+					arm = arm.Unify(c1)
+				}
+			}
+			if arm.Err() == nil {
+				result = append(result, arm)
+			}
+		}
+		if len(result) == 0 {
+			return nil, false
 		}
-		return dst
+		return result, true
+	}
+	return nil, false
+}
+
+// appendConjuncts appends all the conjuncts of v to dst,
+// flattening nested conjunctions.
+func appendConjuncts(dst []cue.Value, v cue.Value) []cue.Value {
+	op, args := v.Expr()
+	if op != cue.AndOp {
+		return append(dst, v)
+	}
+	for _, arg := range args {
+		dst = appendConjuncts(dst, arg)
 	}
-	return append(dst, v)
+	return dst
 }
@@ -0,0 +1,812 @@
+package cuediscrim
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	cuejson "cuelang.org/go/encoding/json"
+)
+
+// TreeVersion holds the current version of the serialized tree format
+// produced by [EncodeTree]. [DecodeTree] accepts this version
+// and any earlier version.
+//
+// Version history:
+//
+//	1: initial version.
+//	2: value switch branches are held in an ordered list
+//	   rather than an object keyed by value.
+//	3: adds the optional, group, rangeSwitch, regexSwitch,
+//	   tupleSwitch, lenSwitch and fieldPresence node types, and
+//	   the implied, enum and order fields of value switches and
+//	   the precedence field of leaves.
+const TreeVersion = 3
+
+// TreeSchema holds the CUE schema for the serialized tree format.
+// The #Tree definition describes the top level value.
+//
+//go:embed tree.cue
+var TreeSchema string
+
+// TreeJSONSchema holds a JSON Schema equivalent to [TreeSchema].
+//
+//go:embed tree.schema.json
+var TreeJSONSchema string
+
+// EncodeTree returns the JSON encoding of the given decision tree.
+// The result conforms to the #Tree definition in [TreeSchema]
+// and can be decoded with [DecodeTree].
+func EncodeTree(n DecisionNode) ([]byte, error) {
+	root, err := encodeNode(n)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Version int `json:"version"`
+		Root    any `json:"root"`
+	}{TreeVersion, root})
+}
+
+// TreeHash returns a fingerprint of the encoded form of the given
+// decision tree: the SHA-256 hash of its encoding as produced by
+// [EncodeTree], in hex, prefixed by "sha256:". The encoding doesn't
+// depend on map ordering, so equal trees have the same hash, which
+// can be used to tell cheaply whether a tree has changed, for
+// example to decide whether code generated from it needs to be
+// regenerated.
+//
+// Trees that make the same decisions but are structured
+// differently, for example by testing fields in a different order
+// or by ordering branches differently as set by [ArmWeights], have
+// different hashes.
+//
+// The hash changes when [TreeVersion] does.
+func TreeHash(n DecisionNode) (string, error) {
+	data, err := EncodeTree(n)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// Tree wraps a decision tree so that it can be marshaled
+// and unmarshaled as part of a larger JSON document.
+// Its JSON form is that produced by [EncodeTree].
+type Tree struct {
+	DecisionNode
+}
+
+// MarshalJSON implements [json.Marshaler] by calling [EncodeTree].
+func (t Tree) MarshalJSON() ([]byte, error) {
+	return EncodeTree(t.DecisionNode)
+}
+
+// UnmarshalJSON implements [json.Unmarshaler] by calling [DecodeTree].
+func (t *Tree) UnmarshalJSON(data []byte) error {
+	n, err := DecodeTree(data)
+	if err != nil {
+		return err
+	}
+	t.DecisionNode = n
+	return nil
+}
+
+type encodedLeaf struct {
+	Type       string `json:"type"`
+	Arms       []int  `json:"arms"`
+	Deprecated []int  `json:"deprecated,omitempty"`
+	Precedence []int  `json:"precedence,omitempty"`
+}
+
+type encodedKindSwitch struct {
+	Type     string         `json:"type"`
+	Path     string         `json:"path"`
+	Optional bool           `json:"optional,omitempty"`
+	Order    []string       `json:"order,omitempty"`
+	Branches map[string]any `json:"branches"`
+}
+
+type encodedValueSwitch struct {
+	Type     string             `json:"type"`
+	Path     string             `json:"path"`
+	Optional bool               `json:"optional,omitempty"`
+	Implied  string             `json:"implied,omitempty"`
+	Enum     string             `json:"enum,omitempty"`
+	Branches []encodedValueCase `json:"branches"`
+	Default  any                `json:"default,omitempty"`
+}
+
+type encodedValueCase struct {
+	Value string `json:"value"`
+	Node  any    `json:"node"`
+}
+
+type encodedRangeSwitch struct {
+	Type     string             `json:"type"`
+	Path     string             `json:"path"`
+	Optional bool               `json:"optional,omitempty"`
+	Branches []encodedRangeCase `json:"branches"`
+	Default  any                `json:"default,omitempty"`
+}
+
+type encodedRangeCase struct {
+	Range string `json:"range"`
+	Node  any    `json:"node"`
+}
+
+type encodedRegexSwitch struct {
+	Type     string             `json:"type"`
+	Path     string             `json:"path"`
+	Optional bool               `json:"optional,omitempty"`
+	Branches []encodedRegexCase `json:"branches"`
+	Default  any                `json:"default,omitempty"`
+}
+
+type encodedRegexCase struct {
+	Pattern string `json:"pattern"`
+	Node    any    `json:"node"`
+}
+
+type encodedFieldPresence struct {
+	Type     string                `json:"type"`
+	Branches []encodedPresenceCase `json:"branches"`
+	Default  any                   `json:"default,omitempty"`
+}
+
+type encodedPresenceCase struct {
+	Path string `json:"path"`
+	Node any    `json:"node"`
+}
+
+type encodedTupleSwitch struct {
+	Type     string             `json:"type"`
+	Paths    []string           `json:"paths"`
+	Branches []encodedTupleCase `json:"branches"`
+}
+
+type encodedTupleCase struct {
+	Values []string `json:"values"`
+	Node   any      `json:"node"`
+}
+
+type encodedFieldAbsence struct {
+	Type     string           `json:"type"`
+	Branches map[string][]int `json:"branches"`
+}
+
+type encodedOptional struct {
+	Type    string `json:"type"`
+	Present any    `json:"present"`
+}
+
+type encodedGroup struct {
+	Type   string `json:"type"`
+	Name   string `json:"name,omitempty"`
+	Select any    `json:"select"`
+}
+
+type encodedError struct {
+	Type string `json:"type"`
+}
+
+func encodeNode(n DecisionNode) (any, error) {
+	switch n := n.(type) {
+	case *LeafNode:
+		e := &encodedLeaf{
+			Type: "leaf",
+			Arms: sortedInts(n.Arms),
+		}
+		if n.Deprecated != nil && n.Deprecated.Len() > 0 {
+			e.Deprecated = sortedInts(n.Deprecated)
+		}
+		e.Precedence = n.Precedence
+		return e, nil
+	case *KindSwitchNode:
+		e := &encodedKindSwitch{
+			Type:     "kindSwitch",
+			Path:     n.Path,
+			Optional: n.Optional,
+			Branches: make(map[string]any),
+		}
+		for _, k := range n.Order {
+			if mapHasKey(n.Branches, k) {
+				e.Order = append(e.Order, k.String())
+			}
+		}
+		for k, sub := range n.Branches {
+			esub, err := encodeNode(sub)
+			if err != nil {
+				return nil, err
+			}
+			e.Branches[k.String()] = esub
+		}
+		return e, nil
+	case *ValueSwitchNode:
+		e := &encodedValueSwitch{
+			Type:     "valueSwitch",
+			Path:     n.Path,
+			Optional: n.Optional,
+			Implied:  n.Implied.String(),
+			Branches: make([]encodedValueCase, 0, len(n.Branches)),
+		}
+		if n.Enum != EnumAuto {
+			e.Enum = n.Enum.String()
+		}
+		for _, val := range ordered(n.Branches, n.Order, Atom.compare) {
+			esub, err := encodeNode(n.Branches[val])
+			if err != nil {
+				return nil, err
+			}
+			e.Branches = append(e.Branches, encodedValueCase{
+				Value: val.String(),
+				Node:  esub,
+			})
+		}
+		if n.Default != nil {
+			edefault, err := encodeNode(n.Default)
+			if err != nil {
+				return nil, err
+			}
+			e.Default = edefault
+		}
+		return e, nil
+	case *RangeSwitchNode:
+		e := &encodedRangeSwitch{
+			Type:     "rangeSwitch",
+			Path:     n.Path,
+			Optional: n.Optional,
+			Branches: make([]encodedRangeCase, 0, len(n.Branches)),
+		}
+		for _, b := range n.Branches {
+			esub, err := encodeNode(b.Node)
+			if err != nil {
+				return nil, err
+			}
+			e.Branches = append(e.Branches, encodedRangeCase{
+				Range: b.Interval.String(),
+				Node:  esub,
+			})
+		}
+		if n.Default != nil {
+			edefault, err := encodeNode(n.Default)
+			if err != nil {
+				return nil, err
+			}
+			e.Default = edefault
+		}
+		return e, nil
+	case *LenSwitchNode:
+		e := &encodedRangeSwitch{
+			Type:     "lenSwitch",
+			Path:     n.Path,
+			Branches: make([]encodedRangeCase, 0, len(n.Branches)),
+		}
+		for _, b := range n.Branches {
+			esub, err := encodeNode(b.Node)
+			if err != nil {
+				return nil, err
+			}
+			e.Branches = append(e.Branches, encodedRangeCase{
+				Range: b.Interval.String(),
+				Node:  esub,
+			})
+		}
+		if n.Default != nil {
+			edefault, err := encodeNode(n.Default)
+			if err != nil {
+				return nil, err
+			}
+			e.Default = edefault
+		}
+		return e, nil
+	case *RegexSwitchNode:
+		e := &encodedRegexSwitch{
+			Type:     "regexSwitch",
+			Path:     n.Path,
+			Optional: n.Optional,
+			Branches: make([]encodedRegexCase, 0, len(n.Branches)),
+		}
+		for _, b := range n.Branches {
+			esub, err := encodeNode(b.Node)
+			if err != nil {
+				return nil, err
+			}
+			e.Branches = append(e.Branches, encodedRegexCase{
+				Pattern: b.Pattern,
+				Node:    esub,
+			})
+		}
+		if n.Default != nil {
+			edefault, err := encodeNode(n.Default)
+			if err != nil {
+				return nil, err
+			}
+			e.Default = edefault
+		}
+		return e, nil
+	case *FieldPresenceNode:
+		e := &encodedFieldPresence{
+			Type:     "fieldPresence",
+			Branches: make([]encodedPresenceCase, 0, len(n.Branches)),
+		}
+		for _, b := range n.Branches {
+			esub, err := encodeNode(b.Node)
+			if err != nil {
+				return nil, err
+			}
+			e.Branches = append(e.Branches, encodedPresenceCase{
+				Path: b.Path,
+				Node: esub,
+			})
+		}
+		if n.Default != nil {
+			edefault, err := encodeNode(n.Default)
+			if err != nil {
+				return nil, err
+			}
+			e.Default = edefault
+		}
+		return e, nil
+	case *TupleSwitchNode:
+		e := &encodedTupleSwitch{
+			Type:     "tupleSwitch",
+			Paths:    n.Paths,
+			Branches: make([]encodedTupleCase, 0, len(n.Branches)),
+		}
+		for _, b := range n.Branches {
+			esub, err := encodeNode(b.Node)
+			if err != nil {
+				return nil, err
+			}
+			values := make([]string, len(b.Values))
+			for i, a := range b.Values {
+				values[i] = a.String()
+			}
+			e.Branches = append(e.Branches, encodedTupleCase{
+				Values: values,
+				Node:   esub,
+			})
+		}
+		return e, nil
+	case *FieldAbsenceNode:
+		e := &encodedFieldAbsence{
+			Type:     "fieldAbsence",
+			Branches: make(map[string][]int),
+		}
+		for path, group := range n.Branches {
+			e.Branches[path] = sortedInts(group)
+		}
+		return e, nil
+	case *OptionalNode:
+		epresent, err := encodeNode(n.Present)
+		if err != nil {
+			return nil, err
+		}
+		return &encodedOptional{
+			Type:    "optional",
+			Present: epresent,
+		}, nil
+	case *GroupNode:
+		eselect, err := encodeNode(n.Select)
+		if err != nil {
+			return nil, err
+		}
+		return &encodedGroup{
+			Type:   "group",
+			Name:   n.Name,
+			Select: eselect,
+		}, nil
+	case ErrorNode, *ErrorNode:
+		return &encodedError{
+			Type: "error",
+		}, nil
+	}
+	return nil, fmt.Errorf("cannot encode node of type %T", n)
+}
+
+// DecodeTree decodes a tree in the format produced by [EncodeTree].
+// Trees encoded with earlier versions of the format are
+// upgraded to the current version, and the result is validated
+// against [TreeSchema] before decoding.
+func DecodeTree(data []byte) (DecisionNode, error) {
+	data, err := migrateTree(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTree(data); err != nil {
+		return nil, err
+	}
+	var t struct {
+		Version int          `json:"version"`
+		Root    *decodedNode `json:"root"`
+	}
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return t.Root.node()
+}
+
+func validateTree(data []byte) error {
+	expr, err := cuejson.Extract("tree.json", data)
+	if err != nil {
+		return fmt.Errorf("invalid tree: %v", err)
+	}
+	ctx := cuecontext.New()
+	schema := ctx.CompileString(TreeSchema, cue.Filename("tree.cue"))
+	if err := schema.Err(); err != nil {
+		return fmt.Errorf("invalid tree schema: %v", err)
+	}
+	v := schema.LookupPath(cue.MakePath(cue.Def("Tree"))).Unify(ctx.BuildExpr(expr))
+	if err := v.Validate(cue.Concrete(true)); err != nil {
+		return fmt.Errorf("invalid tree: %v", err)
+	}
+	return nil
+}
+
+// decodedNode holds the union of all the encoded node types.
+type decodedNode struct {
+	Type       string          `json:"type"`
+	Path       string          `json:"path"`
+	Paths      []string        `json:"paths"`
+	Optional   bool            `json:"optional"`
+	Implied    string          `json:"implied"`
+	Enum       string          `json:"enum"`
+	Order      []string        `json:"order"`
+	Arms       []int           `json:"arms"`
+	Deprecated []int           `json:"deprecated"`
+	Precedence []int           `json:"precedence"`
+	Branches   json.RawMessage `json:"branches"`
+	Default    *decodedNode    `json:"default"`
+	Present    *decodedNode    `json:"present"`
+	Name       string          `json:"name"`
+	Select     *decodedNode    `json:"select"`
+}
+
+func (e *decodedNode) node() (DecisionNode, error) {
+	switch e.Type {
+	case "leaf":
+		n := &LeafNode{
+			Arms: mapSetOf(slices.Values(e.Arms)),
+		}
+		if n.Arms == nil {
+			n.Arms = mapSet[int]{}
+		}
+		if len(e.Deprecated) > 0 {
+			n.Deprecated = mapSetOf(slices.Values(e.Deprecated))
+		}
+		if len(e.Precedence) > 0 {
+			n.Precedence = e.Precedence
+		}
+		return n, nil
+	case "kindSwitch":
+		var branches map[string]*decodedNode
+		if err := json.Unmarshal(e.Branches, &branches); err != nil {
+			return nil, err
+		}
+		n := &KindSwitchNode{
+			Path:     e.Path,
+			Branches: make(map[cue.Kind]DecisionNode),
+			Optional: e.Optional,
+		}
+		for name, esub := range branches {
+			k, ok := kindForName(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown kind %q", name)
+			}
+			sub, err := esub.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Branches[k] = sub
+		}
+		for _, name := range e.Order {
+			k, ok := kindForName(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown kind %q", name)
+			}
+			n.Order = append(n.Order, k)
+		}
+		return n, nil
+	case "valueSwitch":
+		var branches []struct {
+			Value string       `json:"value"`
+			Node  *decodedNode `json:"node"`
+		}
+		if err := json.Unmarshal(e.Branches, &branches); err != nil {
+			return nil, err
+		}
+		n := &ValueSwitchNode{
+			Path:     e.Path,
+			Branches: make(map[Atom]DecisionNode),
+			Optional: e.Optional,
+		}
+		if e.Enum != "" {
+			s, err := ParseEnumStrategy(e.Enum)
+			if err != nil {
+				return nil, err
+			}
+			n.Enum = s
+		}
+		if e.Implied != "" {
+			a, err := parseAtom(e.Implied)
+			if err != nil {
+				return nil, err
+			}
+			n.Implied = a
+		}
+		for _, c := range branches {
+			a, err := parseAtom(c.Value)
+			if err != nil {
+				return nil, err
+			}
+			sub, err := c.Node.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Branches[a] = sub
+			n.Order = append(n.Order, a)
+		}
+		if slices.IsSortedFunc(n.Order, Atom.compare) {
+			// The branches are in their usual order.
+			n.Order = nil
+		}
+		if e.Default != nil {
+			sub, err := e.Default.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Default = sub
+		}
+		return n, nil
+	case "rangeSwitch":
+		var branches []struct {
+			Range string       `json:"range"`
+			Node  *decodedNode `json:"node"`
+		}
+		if err := json.Unmarshal(e.Branches, &branches); err != nil {
+			return nil, err
+		}
+		n := &RangeSwitchNode{
+			Path:     e.Path,
+			Optional: e.Optional,
+		}
+		for _, c := range branches {
+			iv, err := parseInterval(c.Range)
+			if err != nil {
+				return nil, err
+			}
+			sub, err := c.Node.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Branches = append(n.Branches, RangeBranch{
+				Interval: iv,
+				Node:     sub,
+			})
+		}
+		if e.Default != nil {
+			sub, err := e.Default.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Default = sub
+		}
+		return n, nil
+	case "lenSwitch":
+		var branches []struct {
+			Range string       `json:"range"`
+			Node  *decodedNode `json:"node"`
+		}
+		if err := json.Unmarshal(e.Branches, &branches); err != nil {
+			return nil, err
+		}
+		n := &LenSwitchNode{
+			Path: e.Path,
+		}
+		for _, c := range branches {
+			iv, err := parseInterval(c.Range)
+			if err != nil {
+				return nil, err
+			}
+			sub, err := c.Node.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Branches = append(n.Branches, RangeBranch{
+				Interval: iv,
+				Node:     sub,
+			})
+		}
+		if e.Default != nil {
+			sub, err := e.Default.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Default = sub
+		}
+		return n, nil
+	case "regexSwitch":
+		var branches []struct {
+			Pattern string       `json:"pattern"`
+			Node    *decodedNode `json:"node"`
+		}
+		if err := json.Unmarshal(e.Branches, &branches); err != nil {
+			return nil, err
+		}
+		n := &RegexSwitchNode{
+			Path:     e.Path,
+			Optional: e.Optional,
+		}
+		for _, c := range branches {
+			if _, err := regexp.Compile(c.Pattern); err != nil {
+				return nil, err
+			}
+			sub, err := c.Node.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Branches = append(n.Branches, RegexBranch{
+				Pattern: c.Pattern,
+				Node:    sub,
+			})
+		}
+		if e.Default != nil {
+			sub, err := e.Default.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Default = sub
+		}
+		return n, nil
+	case "fieldPresence":
+		var branches []struct {
+			Path string       `json:"path"`
+			Node *decodedNode `json:"node"`
+		}
+		if err := json.Unmarshal(e.Branches, &branches); err != nil {
+			return nil, err
+		}
+		n := &FieldPresenceNode{}
+		for _, c := range branches {
+			sub, err := c.Node.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Branches = append(n.Branches, PresenceBranch{
+				Path: c.Path,
+				Node: sub,
+			})
+		}
+		if e.Default != nil {
+			sub, err := e.Default.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Default = sub
+		}
+		return n, nil
+	case "tupleSwitch":
+		var branches []struct {
+			Values []string     `json:"values"`
+			Node   *decodedNode `json:"node"`
+		}
+		if err := json.Unmarshal(e.Branches, &branches); err != nil {
+			return nil, err
+		}
+		n := &TupleSwitchNode{
+			Paths: e.Paths,
+		}
+		for _, c := range branches {
+			if len(c.Values) != len(n.Paths) {
+				return nil, fmt.Errorf("tuple switch has %d values in a case but %d paths", len(c.Values), len(n.Paths))
+			}
+			values := make([]Atom, len(c.Values))
+			for i, s := range c.Values {
+				a, err := parseAtom(s)
+				if err != nil {
+					return nil, err
+				}
+				values[i] = a
+			}
+			sub, err := c.Node.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Branches = append(n.Branches, TupleBranch{
+				Values: values,
+				Node:   sub,
+			})
+		}
+		return n, nil
+	case "fieldAbsence":
+		var branches map[string][]int
+		if err := json.Unmarshal(e.Branches, &branches); err != nil {
+			return nil, err
+		}
+		sets := make(map[string]IntSet)
+		for path, arms := range branches {
+			s := mapSetOf(slices.Values(arms))
+			if s == nil {
+				s = mapSet[int]{}
+			}
+			sets[path] = s
+		}
+		return newFieldAbsenceNode(sets), nil
+	case "optional":
+		present, err := e.Present.node()
+		if err != nil {
+			return nil, err
+		}
+		return &OptionalNode{
+			Present: present,
+		}, nil
+	case "group":
+		sel, err := e.Select.node()
+		if err != nil {
+			return nil, err
+		}
+		return &GroupNode{
+			Name:   e.Name,
+			Select: sel,
+		}, nil
+	case "error":
+		return ErrorNode{}, nil
+	}
+	return nil, fmt.Errorf("unknown node type %q", e.Type)
+}
+
+func kindForName(name string) (cue.Kind, bool) {
+	for _, k := range allKinds {
+		if k.String() == name {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+// parseAtom parses the CUE representation of an atom
+// as produced by [Atom.String].
+func parseAtom(s string) (Atom, error) {
+	v := cuecontext.New().CompileString(s)
+	if err := v.Err(); err != nil {
+		return Atom{}, fmt.Errorf("invalid value %q: %v", s, err)
+	}
+	a := atomForValue(v)
+	if !a.isValid() {
+		return Atom{}, fmt.Errorf("value %q is not a concrete atom", s)
+	}
+	return a, nil
+}
+
+// parseInterval parses the CUE representation of an interval
+// as produced by [Interval.String].
+func parseInterval(s string) (Interval, error) {
+	v := cuecontext.New().CompileString(s)
+	if err := v.Err(); err != nil {
+		return Interval{}, fmt.Errorf("invalid range %q: %v", s, err)
+	}
+	ivs := numberIntervals(v)
+	if len(ivs) != 1 {
+		return Interval{}, fmt.Errorf("range %q is not a single interval", s)
+	}
+	return ivs[0], nil
+}
+
+func sortedInts(s IntSet) []int {
+	if s == nil {
+		return []int{}
+	}
+	xs := slices.Sorted(s.Values())
+	if xs == nil {
+		xs = []int{}
+	}
+	return xs
+}
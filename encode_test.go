@@ -0,0 +1,247 @@
+package cuediscrim
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestEncodeDecodeTree(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range buildDecisionTreeTests {
+		t.Run(test.testName, func(t *testing.T) {
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			tree, _, _ := Discriminate(Disjunctions(val))
+
+			data, err := EncodeTree(tree)
+			qt.Assert(t, qt.IsNil(err))
+			t.Logf("encoded: %s", data)
+			tree1, err := DecodeTree(data)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.Equals(NodeString(tree1), strings.TrimPrefix(test.want, "\n")))
+
+			for _, dtest := range test.data {
+				data := ctx.CompileString(dtest.cue)
+				qt.Check(t, deepEquals(ref(mapSetOf(tree1.Check(data).Values())), ref(mapSetOf(dtest.want.Values()))), qt.Commentf("data %s", dtest.name))
+			}
+		})
+	}
+}
+
+var decodeTreeErrorTests = []struct {
+	testName  string
+	data      string
+	wantError string
+}{{
+	testName:  "FutureVersion",
+	data:      `{"version": 1000, "root": {"type": "error"}}`,
+	wantError: `unsupported tree version 1000 \(latest known version is 3\)`,
+}, {
+	testName:  "UnknownNodeType",
+	data:      `{"version": 1, "root": {"type": "other"}}`,
+	wantError: `invalid tree: (.|\n)*`,
+}, {
+	testName:  "MissingArms",
+	data:      `{"version": 1, "root": {"type": "leaf"}}`,
+	wantError: `invalid tree: (.|\n)*`,
+}, {
+	testName:  "BadKind",
+	data:      `{"version": 1, "root": {"type": "kindSwitch", "path": ".", "branches": {"foo": {"type": "error"}}}}`,
+	wantError: `invalid tree: (.|\n)*`,
+}, {
+	testName:  "BadValue",
+	data:      `{"version": 2, "root": {"type": "valueSwitch", "path": ".", "branches": [{"value": "int", "node": {"type": "error"}}]}}`,
+	wantError: `value "int" is not a concrete atom`,
+}, {
+	testName:  "NoVersion",
+	data:      `{"root": {"type": "error"}}`,
+	wantError: `invalid tree: missing or invalid version`,
+}}
+
+func TestDecodeTreeError(t *testing.T) {
+	for _, test := range decodeTreeErrorTests {
+		t.Run(test.testName, func(t *testing.T) {
+			_, err := DecodeTree([]byte(test.data))
+			qt.Assert(t, qt.ErrorMatches(err, test.wantError))
+		})
+	}
+}
+
+func TestTreeJSONSchemaIsValidJSON(t *testing.T) {
+	var x any
+	qt.Assert(t, qt.IsNil(json.Unmarshal([]byte(TreeJSONSchema), &x)))
+	qt.Assert(t, qt.IsNil(cuecontext.New().CompileString(TreeSchema).Err()))
+}
+
+func TestDecodeTreeV1(t *testing.T) {
+	tree, err := DecodeTree([]byte(`{
+	"version": 1,
+	"root": {
+		"type": "valueSwitch",
+		"path": "type",
+		"branches": {
+			"\"foo\"": {"type": "leaf", "arms": [0]},
+			"\"bar\"": {
+				"type": "valueSwitch",
+				"path": "x",
+				"branches": {
+					"true": {"type": "leaf", "arms": [1]},
+					"false": {"type": "leaf", "arms": [2]}
+				},
+				"default": {"type": "error"}
+			}
+		},
+		"default": {"type": "error"}
+	}
+}`))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(NodeString(tree), `
+switch type {
+case "bar":
+	switch x {
+	case false:
+		choose({2})
+	case true:
+		choose({1})
+	default:
+		error
+	}
+case "foo":
+	choose({0})
+default:
+	error
+}
+`[1:]))
+}
+
+func TestDecodeTreeV2(t *testing.T) {
+	data := []byte(`{
+	"version": 2,
+	"root": {
+		"type": "kindSwitch",
+		"path": ".",
+		"branches": {
+			"string": {"type": "leaf", "arms": [0]},
+			"struct": {
+				"type": "valueSwitch",
+				"path": "type",
+				"branches": [
+					{"value": "\"a\"", "node": {"type": "leaf", "arms": [1]}},
+					{"value": "\"b\"", "node": {"type": "leaf", "arms": [2]}}
+				],
+				"default": {"type": "error"}
+			}
+		}
+	}
+}`)
+	tree, err := DecodeTree(data)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(NodeString(tree), `
+switch kind(.) {
+case string:
+	choose({0})
+case struct:
+	switch type {
+	case "a":
+		choose({1})
+	case "b":
+		choose({2})
+	default:
+		error
+	}
+}
+`[1:]))
+	data, err = EncodeTree(tree)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.StringContains(string(data), `"version":3`))
+}
+
+func TestTreeJSON(t *testing.T) {
+	// The tree covers every node type and
+	// atoms of all kinds.
+	tree := &OptionalNode{
+		Present: &ValueSwitchNode{
+			Path:    "a.b",
+			Implied: Atom{`"x\ty"`},
+			Branches: map[Atom]DecisionNode{
+				{`"x\ty"`}: &LeafNode{Arms: setOf(0), Deprecated: setOf(0)},
+				{`1`}:      &LeafNode{Arms: setOf(1)},
+				{`1.0`}:    &LeafNode{Arms: setOf(2)},
+				{`'\x00'`}: &LeafNode{Arms: setOf(3)},
+				{`null`}:   &LeafNode{Arms: setOf(4)},
+				{`true`}: &GroupNode{
+					Name: "#G",
+					Select: &FieldAbsenceNode{
+						Branches: map[string]IntSet{
+							"c": setOf(5),
+							"d": setOf(6),
+						},
+					},
+				},
+			},
+			Default: &KindSwitchNode{
+				Path:     "a.b",
+				Optional: true,
+				Branches: map[cue.Kind]DecisionNode{
+					cue.StructKind: &LeafNode{Arms: setOf(7, 8)},
+					cue.ListKind:   ErrorNode{},
+				},
+			},
+		},
+	}
+	type doc struct {
+		Name string `json:"name"`
+		Tree Tree   `json:"tree"`
+	}
+	data, err := json.Marshal(doc{"x", Tree{tree}})
+	qt.Assert(t, qt.IsNil(err))
+	var d doc
+	qt.Assert(t, qt.IsNil(json.Unmarshal(data, &d)))
+	qt.Check(t, qt.Equals(d.Name, "x"))
+	qt.Check(t, qt.Equals(NodeString(d.Tree.DecisionNode), NodeString(tree)))
+
+	err = json.Unmarshal([]byte(`{"tree": {"version": 2, "root": {"type": "other"}}}`), &d)
+	qt.Check(t, qt.ErrorMatches(err, `invalid tree: (.|\n)*`))
+}
+
+func TestTreeHash(t *testing.T) {
+	ctx := cuecontext.New()
+	hashes := make(map[string]string)
+	for _, test := range buildDecisionTreeTests {
+		t.Run(test.testName, func(t *testing.T) {
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			tree, _, _ := Discriminate(Disjunctions(val))
+			h, err := TreeHash(tree)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Check(t, qt.StringContains(h, "sha256:"))
+
+			// The hash is the same for a tree that has been
+			// rebuilt, and so has different map ordering, and
+			// for the tree as decoded from its encoding.
+			tree1, _, _ := Discriminate(Disjunctions(val))
+			h1, err := TreeHash(tree1)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Check(t, qt.Equals(h1, h))
+			data, err := EncodeTree(tree)
+			qt.Assert(t, qt.IsNil(err))
+			tree2, err := DecodeTree(data)
+			qt.Assert(t, qt.IsNil(err))
+			h2, err := TreeHash(tree2)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Check(t, qt.Equals(h2, h))
+
+			// Trees that differ have different hashes.
+			want := NodeString(tree)
+			if other, ok := hashes[h]; ok {
+				qt.Check(t, qt.Equals(other, want))
+			}
+			hashes[h] = want
+		})
+	}
+}
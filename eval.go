@@ -0,0 +1,52 @@
+package cuediscrim
+
+import (
+	"cuelang.org/go/cue"
+)
+
+// Concreteness determines how arms are evaluated
+// before analysis. See [Evaluate].
+type Concreteness int
+
+const (
+	// EvalNone analyzes the arms as they are. This is the default.
+	EvalNone Concreteness = iota
+
+	// EvalSimplify evaluates each arm before analysis
+	// so that references and computed fields are resolved
+	// as far as possible.
+	EvalSimplify
+
+	// EvalDefaults is like EvalSimplify except that
+	// fields that have a default value, such as
+	// kind!: *"a" | "b", are treated as if they
+	// held only that default.
+	// Note that the resulting tree will not recognize data
+	// that uses a non-default value for such a field.
+	EvalDefaults
+)
+
+// Evaluate specifies how arms are evaluated before analysis.
+// Fields whose values are computed, for example
+// kind!: strings.ToLower(_name), can only be used in
+// a value switch when they evaluate to a constant.
+func Evaluate(c Concreteness) Option {
+	return func(opts *options) {
+		opts.eval = c
+	}
+}
+
+// evaluateArms returns the arms evaluated according to c.
+// Defaults are resolved when the value sets are computed
+// rather than here, because filling in a field of an arm
+// does not preserve the constraints on its other fields.
+func evaluateArms(arms []cue.Value, c Concreteness) []cue.Value {
+	if c == EvalNone {
+		return arms
+	}
+	evaluated := make([]cue.Value, len(arms))
+	for i, arm := range arms {
+		evaluated[i] = arm.Eval()
+	}
+	return evaluated
+}
@@ -0,0 +1,303 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/cue/token"
+)
+
+// maxExampleDepth bounds the nesting of the values produced by
+// [GenerateExample], so that recursive definitions terminate.
+const maxExampleDepth = 32
+
+// GenerateExample returns a minimal concrete instance of v, suitable
+// for use as an example document. It holds the required and regular
+// fields of v but no optional fields, the default of any value that
+// has one, empty lists where possible, and otherwise the simplest
+// value allowed by the value's kind, bounds and patterns. It returns
+// an error if it cannot find such a value, as when v is constrained
+// by validators that it doesn't understand.
+func GenerateExample(v cue.Value) (cue.Value, error) {
+	g := &exampleGen{}
+	return g.generate(v)
+}
+
+// ArmExample is like [GenerateExample] except that it generates an
+// instance of arms[arm] that tree, the decision tree for arms,
+// classifies as that arm. If the minimal instance isn't classified
+// as the arm, as when the tree switches on an optional field, the
+// optional fields that the tree uses are included too. It returns an
+// error if the tree still doesn't choose the arm.
+func ArmExample(tree DecisionNode, arms []cue.Value, arm int) (cue.Value, error) {
+	if arm < 0 || arm >= len(arms) {
+		return cue.Value{}, fmt.Errorf("arm %d out of range", arm)
+	}
+	g := &exampleGen{}
+	x, err := g.generate(arms[arm])
+	if err != nil {
+		return cue.Value{}, err
+	}
+	if tree.Check(x).Has(arm) {
+		return x, nil
+	}
+	g.include = TreePaths(tree)
+	x, err = g.generate(arms[arm])
+	if err != nil {
+		return cue.Value{}, err
+	}
+	if chosen := tree.Check(x); !chosen.Has(arm) {
+		return cue.Value{}, fmt.Errorf("example for arm %d is classified as %s", arm, SetString(chosen))
+	}
+	return x, nil
+}
+
+type exampleGen struct {
+	// include holds the paths of optional fields to include,
+	// in the form used by decision trees.
+	include Set[string]
+
+	// failed holds the path of the first value
+	// for which no instance could be found.
+	failed string
+}
+
+func (g *exampleGen) generate(v cue.Value) (cue.Value, error) {
+	g.failed = ""
+	x, ok := g.expr(v, ".", 0)
+	if !ok {
+		return cue.Value{}, fmt.Errorf("cannot generate an example: no valid value found for %s", g.failed)
+	}
+	return v.Context().BuildExpr(x), nil
+}
+
+// expr returns the syntax for a minimal instance of v, which is
+// found at the given path, and reports whether there is one.
+func (g *exampleGen) expr(v cue.Value, path string, depth int) (_ ast.Expr, ok bool) {
+	defer func() {
+		if !ok && g.failed == "" {
+			g.failed = path
+		}
+	}()
+	if depth > maxExampleDepth {
+		return nil, false
+	}
+	if dv, ok := v.Default(); ok {
+		if x, ok := g.expr(dv, path, depth+1); ok && g.valid(v, x) {
+			return x, true
+		}
+	}
+	if a := atomForValue(v); a.isValid() {
+		x, err := parser.ParseExpr("example", a.String())
+		return x, err == nil
+	}
+	if op, args := v.Expr(); op == cue.OrOp {
+		for _, arg := range args {
+			if x, ok := g.expr(arg, path, depth+1); ok && g.valid(v, x) {
+				return x, true
+			}
+		}
+		return nil, false
+	}
+	kind := v.IncompleteKind()
+	for _, k := range []cue.Kind{
+		cue.StructKind,
+		cue.StringKind,
+		cue.IntKind,
+		cue.FloatKind,
+		cue.BoolKind,
+		cue.NullKind,
+		cue.ListKind,
+		cue.BytesKind,
+	} {
+		if kind&k == 0 {
+			continue
+		}
+		for _, x := range g.candidates(v, k, path, depth) {
+			if g.valid(v, x) {
+				return x, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// candidates returns possible instances of v with the given kind,
+// simplest first.
+func (g *exampleGen) candidates(v cue.Value, k cue.Kind, path string, depth int) []ast.Expr {
+	switch k {
+	case cue.StructKind:
+		if x, ok := g.structExpr(v, path, depth); ok {
+			return []ast.Expr{x}
+		}
+	case cue.ListKind:
+		return g.listExprs(v, path, depth)
+	case cue.StringKind:
+		var xs []ast.Expr
+		for _, p := range stringPatterns(v) {
+			if prefix, ok := anchoredPrefix(p); ok && prefix != "" {
+				xs = append(xs,
+					ast.NewString(prefix),
+					ast.NewString(prefix+"0"),
+					ast.NewString(prefix+"a"),
+				)
+			}
+		}
+		return append(xs, ast.NewString(""), ast.NewString("a"))
+	case cue.IntKind, cue.FloatKind:
+		var xs []ast.Expr
+		for _, iv := range numberIntervals(v) {
+			s, ok := exampleNumber(iv, k == cue.IntKind)
+			if !ok {
+				continue
+			}
+			if k == cue.FloatKind && !strings.ContainsAny(s, ".eE") {
+				s += ".0"
+			}
+			if x, err := parser.ParseExpr("example", s); err == nil {
+				xs = append(xs, x)
+			}
+		}
+		return xs
+	case cue.BoolKind:
+		return []ast.Expr{ast.NewBool(false), ast.NewBool(true)}
+	case cue.NullKind:
+		return []ast.Expr{ast.NewNull()}
+	case cue.BytesKind:
+		return []ast.Expr{ast.NewLit(token.STRING, "''")}
+	}
+	return nil
+}
+
+// structExpr returns a struct holding an instance of each
+// field of v that is required, regular, or optional and
+// in g.include.
+func (g *exampleGen) structExpr(v cue.Value, path string, depth int) (ast.Expr, bool) {
+	iter, err := v.Fields(cue.Optional(true))
+	if err != nil {
+		return nil, false
+	}
+	s := &ast.StructLit{}
+	for iter.Next() {
+		fpath := pathConcat(path, selectorName(iter.Selector()))
+		if iter.FieldType()&cue.OptionalConstraint != 0 && !g.includes(fpath) {
+			continue
+		}
+		x, ok := g.expr(iter.Value(), fpath, depth+1)
+		if !ok {
+			return nil, false
+		}
+		s.Elts = append(s.Elts, &ast.Field{
+			Label: ast.NewString(iter.Selector().Unquoted()),
+			Value: x,
+		})
+	}
+	return s, true
+}
+
+// listExprs returns candidate instances of the list v: one
+// holding only the elements that v requires, and one with an
+// extra element when v allows more.
+func (g *exampleGen) listExprs(v cue.Value, path string, depth int) []ast.Expr {
+	l := &ast.ListLit{}
+	iter, err := v.List()
+	if err != nil {
+		return nil
+	}
+	for i := 0; iter.Next(); i++ {
+		x, ok := g.expr(iter.Value(), pathConcat(path, fmt.Sprintf("[%d]", i)), depth+1)
+		if !ok {
+			return nil
+		}
+		l.Elts = append(l.Elts, x)
+	}
+	if len(l.Elts) > 0 {
+		return []ast.Expr{l}
+	}
+	elem := v.LookupPath(cue.MakePath(cue.AnyIndex))
+	if !elem.Exists() {
+		return []ast.Expr{l}
+	}
+	x, ok := g.expr(elem, pathConcat(path, firstElem), depth+1)
+	if !ok {
+		return []ast.Expr{l}
+	}
+	one := &ast.ListLit{
+		Elts: []ast.Expr{x},
+	}
+	if g.includes(pathConcat(path, firstElem)) {
+		// The tree looks at the first element, so
+		// prefer a list that has one.
+		return []ast.Expr{one, l}
+	}
+	return []ast.Expr{l, one}
+}
+
+// includes reports whether the value at path,
+// or anything within it, is in g.include.
+func (g *exampleGen) includes(path string) bool {
+	if g.include == nil {
+		return false
+	}
+	for p := range g.include.Values() {
+		if p == path || strings.HasPrefix(p, path+".") || strings.HasPrefix(p, path+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// valid reports whether x, which is concrete, is an instance of v.
+func (g *exampleGen) valid(v cue.Value, x ast.Expr) bool {
+	xv := v.Context().BuildExpr(x)
+	// Note: we don't use cue.Concrete because it reports
+	// optional fields of arms split from a disjunction as
+	// incomplete. The generated structs hold all the fields
+	// that must be present, so it isn't needed.
+	return xv.Err() == nil && v.Unify(xv).Validate() == nil
+}
+
+// exampleNumber returns the simplest number in iv, in CUE syntax,
+// preferring zero and then the bounds themselves. If isInt is true,
+// the number must be an integer.
+func exampleNumber(iv Interval, isInt bool) (string, bool) {
+	one := big.NewRat(1, 1)
+	var xs []*big.Rat
+	xs = append(xs, new(big.Rat))
+	lo, hi := iv.Min.rat(), iv.Max.rat()
+	if lo != nil {
+		xs = append(xs, lo, new(big.Rat).Add(lo, one), ceilRat(lo))
+	}
+	if hi != nil {
+		xs = append(xs, hi, new(big.Rat).Sub(hi, one), floorRat(hi))
+	}
+	if lo != nil && hi != nil {
+		mid := new(big.Rat).Add(lo, hi)
+		xs = append(xs, mid.Quo(mid, big.NewRat(2, 1)))
+	}
+	for _, x := range xs {
+		if iv.Contains(x) && (!isInt || x.IsInt()) {
+			return formatBound(x.RatString()), true
+		}
+	}
+	return "", false
+}
+
+// floorRat returns the largest integer not greater than x.
+func floorRat(x *big.Rat) *big.Rat {
+	q := new(big.Int).Div(x.Num(), x.Denom())
+	return new(big.Rat).SetInt(q)
+}
+
+// ceilRat returns the smallest integer not less than x.
+func ceilRat(x *big.Rat) *big.Rat {
+	f := floorRat(x)
+	if f.Cmp(x) == 0 {
+		return f
+	}
+	return f.Add(f, big.NewRat(1, 1))
+}
@@ -0,0 +1,95 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/format"
+	"github.com/go-quicktest/qt"
+)
+
+var armExampleTests = []struct {
+	testName string
+	cue      string
+	arm      int
+	want     string
+	wantErr  string
+}{{
+	testName: "RequiredAndDefaults",
+	cue: `
+#S3: {type!: "s3", bucket!: =~"^s3://", region: *"us-east-1" | string, retries?: int}
+#GCS: {type!: "gcs", project!: string & !=""}
+x: #S3 | #GCS
+`,
+	arm: 0,
+	want: `
+{
+	type:   "s3"
+	bucket: "s3://"
+	region: "us-east-1"
+}`,
+}, {
+	testName: "Bounds",
+	cue: `
+x: {kind!: "a", size!: int & >10 & <=20, ratio!: float & >0 & <1, neg!: < -3} | {kind!: "b"}
+`,
+	arm: 0,
+	want: `
+{
+	kind:  "a"
+	size:  11
+	ratio: 0.5
+	neg:   -4
+}`,
+}, {
+	testName: "Lists",
+	cue: `
+x: {items!: [...int], pair!: [string, bool]} | string
+`,
+	arm: 0,
+	want: `
+{
+	items: []
+	pair: ["", false]
+}`,
+}, {
+	testName: "OptionalDiscriminator",
+	cue: `
+x: {type?: "a", a?: int} | {type!: "b"}
+`,
+	arm:  0,
+	want: `{}`,
+}, {
+	testName: "Unsatisfiable",
+	cue: `
+import "strings"
+
+x: {name!: strings.MinRunes(3) & strings.MaxRunes(2)} | int
+`,
+	arm:     0,
+	wantErr: `cannot generate an example: no valid value found for name`,
+}}
+
+func TestArmExample(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range armExampleTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			arms := Disjunctions(v.LookupPath(cue.ParsePath("x")))
+			tree, _, _ := Discriminate(arms)
+			x, err := ArmExample(tree, arms, test.arm)
+			if test.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, test.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			data, err := format.Node(x.Syntax(), format.Simplify())
+			qt.Assert(t, qt.IsNil(err))
+			qt.Check(t, qt.Equals(string(data), strings.TrimPrefix(test.want, "\n")))
+			qt.Check(t, qt.IsTrue(tree.Check(x).Has(test.arm)))
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package cuediscrim
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/token"
+)
+
+// maxGuardDepth bounds the number of references followed
+// by [guardedArms] to find the struct literals of a value.
+const maxGuardDepth = 8
+
+// mayHaveField reports whether a value of arms[i] might have a field
+// at path. It doesn't if the arm forbids the field (see
+// [forbidsField]), if the arm is closed and doesn't allow it (see
+// [closedForbidsField]) or, with the [ClosedWorld] option, if the
+// arm doesn't declare it.
+func (d *discriminator[Set]) mayHaveField(arms []cue.Value, i int, path string) bool {
+	if forbidsField(arms[i], path) || forbidsField(d.probe(arms, i), path) {
+		return false
+	}
+	if closedForbidsField(arms[i], path) {
+		return false
+	}
+	return !d.closedWorld || allowsField(arms[i], path)
+}
+
+// probe returns arms[i] unified with an example of it (see
+// [GenerateExample]), so that the constraints of the arm that depend
+// on the values of its fields, such as
+//
+//	if a != _|_ { b?: _|_ }
+//
+// take effect. It returns arms[i] itself if there is no example.
+func (d *discriminator[Set]) probe(arms []cue.Value, i int) cue.Value {
+	if v, ok := d.probes[i]; ok {
+		return v
+	}
+	if d.probes == nil {
+		d.probes = make(map[int]cue.Value)
+	}
+	v := arms[i]
+	if ex, err := GenerateExample(v); err == nil {
+		if u := v.Unify(ex); u.Err() == nil {
+			v = u
+		}
+	}
+	d.probes[i] = v
+	return v
+}
+
+// forbidsField reports whether v declares the field at path, or one
+// of the structs above it, with a value that is an error, as with
+// b?: _|_, so that no value of v can have the field.
+func forbidsField(v cue.Value, path string) bool {
+	for _, name := range splitPath(path) {
+		f, ok := field(v, name)
+		if !ok {
+			return false
+		}
+		if f.Err() != nil {
+			return true
+		}
+		v = f
+	}
+	return false
+}
+
+// closedForbidsField reports whether some struct on the way to the
+// field at path in v is closed, as a definition or a call to close
+// is, and doesn't allow the next field on the path, so that no value
+// of v can have the field. Unlike [allowsField], it takes only the
+// closedness of v itself into account.
+func closedForbidsField(v cue.Value, path string) bool {
+	for _, name := range splitPath(path) {
+		if isIndex(name) || strings.HasPrefix(name, "#") || strings.HasPrefix(name, "_") {
+			// Only regular fields of structs can be
+			// closed out.
+			return false
+		}
+		if v.IncompleteKind()&cue.StructKind == 0 {
+			return false
+		}
+		sels := cue.ParsePath(name).Selectors()
+		if len(sels) != 1 {
+			return false
+		}
+		if !v.Allows(sels[0]) {
+			return true
+		}
+		f, ok := field(v, name)
+		if !ok {
+			return false
+		}
+		v = f
+	}
+	return false
+}
+
+// exclusiveArms returns the arms of a call to matchN(1, arms) in
+// which each arm does nothing but require a single field, as in
+//
+//	matchN(1, [{a!: _}, {b!: _}])
+//
+// Exactly one of the fields may be present, so each of the returned
+// arms forbids the fields required by the others. It reports false
+// if the arms are not of that form.
+func exclusiveArms(arms []cue.Value) ([]cue.Value, bool) {
+	names := make([]string, len(arms))
+	for i, arm := range arms {
+		n := 0
+		for lab, f := range structFields(arm, requiredLabel|optionalLabel|regularLabel) {
+			if lab.labelType != requiredLabel || f.IncompleteKind() != cue.TopKind {
+				return nil, false
+			}
+			names[i] = lab.name
+			n++
+		}
+		if n != 1 {
+			return nil, false
+		}
+	}
+	result := make([]cue.Value, len(arms))
+	for i, arm := range arms {
+		result[i] = presenceArm(arm, "", withoutName(names, names[i]))
+	}
+	return result, true
+}
+
+// guardedArms splits a struct that makes some of its optional fields
+// mutually exclusive with comprehensions such as
+//
+//	if a != _|_ { b?: _|_ }
+//
+// into one arm for each of those fields, which requires the field and
+// forbids the others, followed by an arm that forbids them all. Such
+// a struct is a common way of writing a union in CUE that predates
+// required fields. It reports false if v is not such a struct, or if
+// not every pair of the fields is exclusive.
+//
+// The comprehensions are found in the source of v, so a value
+// computed from other values, such as an arm of a disjunction,
+// is not split.
+func guardedArms(v cue.Value) ([]cue.Value, bool) {
+	if v.IncompleteKind() != cue.StructKind {
+		return nil, false
+	}
+	excludes := make(map[[2]string]bool)
+	for _, s := range structLits(v.Source(), maxGuardDepth) {
+		for _, elt := range s.Elts {
+			guard, excluded, ok := exclusionGuard(elt)
+			if !ok {
+				continue
+			}
+			for _, name := range excluded {
+				excludes[[2]string{guard, name}] = true
+				excludes[[2]string{name, guard}] = true
+			}
+		}
+	}
+	if len(excludes) == 0 {
+		return nil, false
+	}
+	var names []string
+	for lab := range structFields(v, requiredLabel|optionalLabel|regularLabel) {
+		for pair := range excludes {
+			if pair[0] == lab.name {
+				if lab.labelType != optionalLabel {
+					return nil, false
+				}
+				names = append(names, lab.name)
+				break
+			}
+		}
+	}
+	if len(names) < 2 {
+		return nil, false
+	}
+	for i, name := range names {
+		for _, name1 := range names[i+1:] {
+			if !excludes[[2]string{name, name1}] {
+				return nil, false
+			}
+		}
+	}
+	var arms []cue.Value
+	for _, name := range names {
+		arms = append(arms, presenceArm(v, name, withoutName(names, name)))
+	}
+	return append(arms, presenceArm(v, "", names)), true
+}
+
+// exclusionGuard reports whether elt is a comprehension of the form
+//
+//	if a != _|_ { b?: _|_, c?: _|_ }
+//
+// returning the selector name of the guarding field and those of the
+// fields it excludes.
+func exclusionGuard(elt ast.Decl) (guard string, excluded []string, ok bool) {
+	c, ok := elt.(*ast.Comprehension)
+	if !ok || len(c.Clauses) != 1 {
+		return "", nil, false
+	}
+	clause, ok := c.Clauses[0].(*ast.IfClause)
+	if !ok {
+		return "", nil, false
+	}
+	cond, ok := clause.Condition.(*ast.BinaryExpr)
+	if !ok || cond.Op != token.NEQ {
+		return "", nil, false
+	}
+	x, y := cond.X, cond.Y
+	if _, ok := x.(*ast.BottomLit); ok {
+		x, y = y, x
+	}
+	ident, ok := x.(*ast.Ident)
+	if _, isBottom := y.(*ast.BottomLit); !ok || !isBottom {
+		return "", nil, false
+	}
+	body, ok := c.Value.(*ast.StructLit)
+	if !ok {
+		return "", nil, false
+	}
+	for _, elt := range body.Elts {
+		f, ok := elt.(*ast.Field)
+		if !ok {
+			return "", nil, false
+		}
+		if _, ok := f.Value.(*ast.BottomLit); !ok {
+			return "", nil, false
+		}
+		name, _, err := ast.LabelName(f.Label)
+		if err != nil {
+			return "", nil, false
+		}
+		excluded = append(excluded, cue.Str(name).String())
+	}
+	return cue.Str(ident.Name).String(), excluded, len(excluded) > 0
+}
+
+// structLits returns the struct literals that make up the value
+// of the source node n, following references to other fields
+// and conjunctions up to the given depth.
+func structLits(n ast.Node, depth int) []*ast.StructLit {
+	if depth <= 0 {
+		return nil
+	}
+	switch n := n.(type) {
+	case *ast.File:
+		return []*ast.StructLit{{Elts: n.Decls}}
+	case *ast.Field:
+		return structLits(n.Value, depth)
+	case *ast.StructLit:
+		return []*ast.StructLit{n}
+	case *ast.ParenExpr:
+		return structLits(n.X, depth)
+	case *ast.BinaryExpr:
+		if n.Op == token.AND {
+			return append(structLits(n.X, depth), structLits(n.Y, depth)...)
+		}
+	case *ast.Ident:
+		if n.Node != nil {
+			return structLits(n.Node, depth-1)
+		}
+	}
+	return nil
+}
+
+// presenceArm returns v with the field with the given selector name
+// made required, unless it is empty, and the named absent fields
+// forbidden.
+func presenceArm(v cue.Value, present string, absent []string) cue.Value {
+	var decls []any
+	if present != "" {
+		decls = append(decls, &ast.Field{
+			Label:      selectorLabel(present),
+			Constraint: token.NOT,
+			Value:      ast.NewIdent("_"),
+		})
+	}
+	for _, name := range absent {
+		decls = append(decls, &ast.Field{
+			Label:      selectorLabel(name),
+			Constraint: token.OPTION,
+			Value:      &ast.BottomLit{},
+		})
+	}
+	return v.Unify(v.Context().BuildExpr(ast.NewStruct(decls...)))
+}
+
+// selectorLabel returns a label for the field with
+// the given selector name.
+func selectorLabel(name string) ast.Label {
+	if s, err := strconv.Unquote(name); err == nil {
+		return ast.NewString(s)
+	}
+	return ast.NewIdent(name)
+}
+
+// withoutName returns names without name.
+func withoutName(names []string, name string) []string {
+	return slices.DeleteFunc(slices.Clone(names), func(n string) bool {
+		return n == name
+	})
+}
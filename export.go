@@ -0,0 +1,192 @@
+package cuediscrim
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Exporter is implemented by types that can convert the result
+// of an analysis to some output format.
+type Exporter interface {
+	// Name returns the name of the output format,
+	// for example "json".
+	Name() string
+
+	// Export returns the given result formatted
+	// in the exporter's output format.
+	Export(r *Result) ([]byte, error)
+}
+
+// StreamExporter is optionally implemented by an [Exporter] that
+// can write its output directly, so that large results don't need
+// to be held in memory. Tools such as cmd/discrim check for it
+// with a type assertion.
+type StreamExporter interface {
+	Exporter
+
+	// ExportTo writes the given result to w in the exporter's
+	// output format. Options that don't apply to the format
+	// are ignored.
+	ExportTo(w io.Writer, r *Result, opts ...WriteOption) error
+}
+
+var exporters struct {
+	mu sync.Mutex
+	m  map[string]Exporter
+}
+
+// RegisterExporter makes an exporter available by name
+// so that tools such as cmd/discrim can find it with [LookupExporter].
+// Typically it is called from an init function in the package
+// that implements the exporter.
+//
+// It panics if an exporter with the same name is already registered.
+func RegisterExporter(e Exporter) {
+	exporters.mu.Lock()
+	defer exporters.mu.Unlock()
+	name := e.Name()
+	if _, ok := exporters.m[name]; ok {
+		panic(fmt.Errorf("exporter %q registered twice", name))
+	}
+	if exporters.m == nil {
+		exporters.m = make(map[string]Exporter)
+	}
+	exporters.m[name] = e
+}
+
+// LookupExporter returns the exporter registered with the given name.
+func LookupExporter(name string) (Exporter, bool) {
+	exporters.mu.Lock()
+	defer exporters.mu.Unlock()
+	e, ok := exporters.m[name]
+	return e, ok
+}
+
+// Exporters returns the names of all the registered exporters
+// in sorted order.
+func Exporters() []string {
+	exporters.mu.Lock()
+	defer exporters.mu.Unlock()
+	return slices.Sorted(maps.Keys(exporters.m))
+}
+
+func init() {
+	RegisterExporter(textExporter{})
+	RegisterExporter(treeJSONExporter{})
+	RegisterExporter(reportCUEExporter{})
+	RegisterExporter(policyExporter{})
+	RegisterExporter(mermaidExporter{})
+	RegisterExporter(jsonSchemaExporter{})
+	RegisterExporter(hashExporter{})
+}
+
+// textExporter exports the tree as formatted by [NodeString].
+type textExporter struct{}
+
+func (textExporter) Name() string {
+	return "text"
+}
+
+func (e textExporter) Export(r *Result) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := e.ExportTo(&buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Any groups merged by [MergeCompatible] are named in the tree
+// and listed after it; see [GroupNames] and [WriteMergedGroups].
+// Arms are shown by name where known; see [WriteArmNames].
+// The given options are applied after those.
+func (textExporter) ExportTo(w io.Writer, r *Result, opts ...WriteOption) error {
+	groups := r.MergedGroups()
+	opts = append([]WriteOption{GroupNames(groups), WriteArmNames(r.Names)}, opts...)
+	if err := WriteNode(w, r.Tree, opts...); err != nil {
+		return err
+	}
+	return WriteMergedGroups(w, groups, r.Names)
+}
+
+// treeJSONExporter exports the tree as encoded by [EncodeTree].
+type treeJSONExporter struct{}
+
+func (treeJSONExporter) Name() string {
+	return "json"
+}
+
+func (treeJSONExporter) Export(r *Result) ([]byte, error) {
+	data, err := EncodeTree(r.Tree)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// hashExporter exports the fingerprint of
+// the tree as produced by [TreeHash].
+type hashExporter struct{}
+
+func (hashExporter) Name() string {
+	return "hash"
+}
+
+func (hashExporter) Export(r *Result) ([]byte, error) {
+	h, err := TreeHash(r.Tree)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(h + "\n"), nil
+}
+
+// reportCUEExporter exports a report as produced by [Report.CUE].
+type reportCUEExporter struct{}
+
+func (reportCUEExporter) Name() string {
+	return "cue"
+}
+
+func (reportCUEExporter) Export(r *Result) ([]byte, error) {
+	return r.Report("").CUE()
+}
+
+// policyExporter exports the tree as a policy
+// as produced by [Policy.CUE].
+type policyExporter struct{}
+
+func (policyExporter) Name() string {
+	return "policy"
+}
+
+func (policyExporter) Export(r *Result) ([]byte, error) {
+	return NewPolicy(r.Tree).CUE()
+}
+
+// mermaidExporter exports the tree as a flowchart
+// as produced by [NodeMermaid].
+type mermaidExporter struct{}
+
+func (mermaidExporter) Name() string {
+	return "mermaid"
+}
+
+func (mermaidExporter) Export(r *Result) ([]byte, error) {
+	return []byte(NodeMermaid(r.Tree)), nil
+}
+
+// jsonSchemaExporter exports the tree as a JSON Schema
+// as produced by [ExportJSONSchema].
+type jsonSchemaExporter struct{}
+
+func (jsonSchemaExporter) Name() string {
+	return "jsonschema"
+}
+
+// The arm schemas are named after the arms where their names are known.
+func (jsonSchemaExporter) Export(r *Result) ([]byte, error) {
+	return exportJSONSchema(r.Tree, r.Arms, r.Names)
+}
@@ -0,0 +1,72 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+type testExporter struct{}
+
+func (testExporter) Name() string {
+	return "test-exporter"
+}
+
+func (testExporter) Export(r *Result) ([]byte, error) {
+	return []byte("test output"), nil
+}
+
+func TestRegisterExporter(t *testing.T) {
+	RegisterExporter(testExporter{})
+	defer func() {
+		exporters.mu.Lock()
+		defer exporters.mu.Unlock()
+		delete(exporters.m, "test-exporter")
+	}()
+	qt.Check(t, qt.PanicMatches(func() {
+		RegisterExporter(testExporter{})
+	}, `exporter "test-exporter" registered twice`))
+	qt.Check(t, qt.DeepEquals(Exporters(), []string{"cue", "hash", "json", "jsonschema", "mermaid", "policy", "test-exporter", "text"}))
+
+	e, ok := LookupExporter("test-exporter")
+	qt.Assert(t, qt.IsTrue(ok))
+	data, err := e.Export(&Result{})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(string(data), "test output"))
+
+	_, ok = LookupExporter("other")
+	qt.Check(t, qt.IsFalse(ok))
+}
+
+func TestBuiltinExporters(t *testing.T) {
+	v := cuecontext.New().CompileString(`int | string`)
+	r := Analyze(Disjunctions(v))
+	for _, name := range []string{"text", "json", "cue", "policy", "mermaid", "jsonschema", "hash"} {
+		e, ok := LookupExporter(name)
+		qt.Assert(t, qt.IsTrue(ok))
+		data, err := e.Export(r)
+		qt.Assert(t, qt.IsNil(err))
+		qt.Check(t, qt.Not(qt.HasLen(data, 0)))
+	}
+}
+
+func TestStreamExporter(t *testing.T) {
+	v := cuecontext.New().CompileString(`{a!: int} | {b!: int}`)
+	r := Analyze(Disjunctions(v))
+	e, ok := LookupExporter("text")
+	qt.Assert(t, qt.IsTrue(ok))
+	se, ok := e.(StreamExporter)
+	qt.Assert(t, qt.IsTrue(ok))
+	data, err := e.Export(r)
+	qt.Assert(t, qt.IsNil(err))
+	var buf strings.Builder
+	qt.Assert(t, qt.IsNil(se.ExportTo(&buf, r)))
+	qt.Check(t, qt.Equals(buf.String(), string(data)))
+
+	e, ok = LookupExporter("json")
+	qt.Assert(t, qt.IsTrue(ok))
+	_, ok = e.(StreamExporter)
+	qt.Check(t, qt.IsFalse(ok))
+}
@@ -1,7 +1,12 @@
 package cuediscrim
 
 import (
+	"fmt"
 	"iter"
+	pathpkg "path"
+	"slices"
+	"strconv"
+	"strings"
 
 	"cuelang.org/go/cue"
 )
@@ -11,8 +16,74 @@ import (
 // than structs.
 // This includes the root values, which are also "required" at the root path.
 // It only includes string labels that have any bits set in labelTypes.
+// When labelTypes includes requiredLabel, the elements of lists are
+// included too, with selector names such as "[0]": each element in
+// the fixed prefix of a list, or the first element of a list
+// with only an element type, as in [...#Event].
 func allFields(values []cue.Value, selected Set[int], labelTypes labelType) iter.Seq2[string, []cue.Value] {
-	return func(yield func(string, []cue.Value) bool) {
+	return allFieldsWithin(values, selected, labelTypes, fieldLimits{})
+}
+
+// fieldLimits holds limits on the fields produced by
+// allFieldsWithin, as set by [MaxDepth], [IncludePaths]
+// and [ExcludePaths]. The zero value imposes no limits.
+type fieldLimits struct {
+	// maxDepth holds the maximum number of selectors
+	// in a path, or zero for no limit.
+	maxDepth int
+
+	// include and exclude hold the patterns
+	// for [IncludePaths] and [ExcludePaths].
+	include []string
+	exclude []string
+}
+
+// allows reports whether the field at path may be produced.
+func (l fieldLimits) allows(path string) bool {
+	if len(l.include) > 0 && !slices.ContainsFunc(l.include, func(pattern string) bool {
+		return matchPathPattern(pattern, path)
+	}) {
+		return false
+	}
+	return !slices.ContainsFunc(l.exclude, func(pattern string) bool {
+		return matchPathPattern(pattern, path)
+	})
+}
+
+// matchPathPattern reports whether pattern, as described for
+// [IncludePaths], matches path or any path that it is within.
+func matchPathPattern(pattern, path string) bool {
+	pats, names := splitPath(pattern), splitPath(path)
+	if len(pats) == 0 || len(pats) > len(names) {
+		return false
+	}
+	for i, pat := range pats {
+		name := names[i]
+		switch {
+		case isIndex(pat):
+			if pat != "[*]" && pat != name || !isIndex(name) {
+				return false
+			}
+		case isIndex(name):
+			return false
+		default:
+			if ok, _ := pathpkg.Match(pat, name); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// allFieldsWithin is like allFields but only produces
+// the fields allowed by limits. Fields that are not allowed
+// are still looked inside, so that an allowed field within
+// them can be produced.
+func allFieldsWithin(values []cue.Value, selected Set[int], labelTypes labelType, limits fieldLimits) iter.Seq2[string, []cue.Value] {
+	return func(yield0 func(string, []cue.Value) bool) {
+		yield := func(path string, values []cue.Value) bool {
+			return !limits.allows(path) || yield0(path, values)
+		}
 		var q queue[pathValues]
 		q.push(pathValues{
 			path: ".",
@@ -25,15 +96,26 @@ func allFields(values []cue.Value, selected Set[int], labelTypes labelType) iter
 			if !ok {
 				return
 			}
+			if limits.maxDepth > 0 && pathDepth(x.path) >= limits.maxDepth {
+				// The fields of x are too deep.
+				continue
+			}
 			var ordered [][]cue.Value
 			var orderedNames []string
 			byName := make(map[string]int)
+			nelems := 0
+			if labelTypes&requiredLabel != 0 {
+				for i, v := range x.values {
+					if selected.Has(i) {
+						nelems = max(nelems, listLen(v))
+					}
+				}
+			}
 			for i, v := range x.values {
 				if !selected.Has(i) {
 					continue
 				}
-				for label, v := range structFields(v, labelTypes) {
-					name := label.name
+				add := func(name string, v cue.Value) {
 					var entry []cue.Value
 					if i, ok := byName[name]; ok {
 						entry = ordered[i]
@@ -45,6 +127,21 @@ func allFields(values []cue.Value, selected Set[int], labelTypes labelType) iter
 					}
 					entry[i] = v
 				}
+				for label, v := range structFields(v, labelTypes) {
+					add(label.name, v)
+				}
+				// Treat the elements of a list as if they were
+				// required so that lists of tagged structs and
+				// tuples can be discriminated. Note that this is
+				// not true of an empty list: see [guardShortLists].
+				// When another arm has more elements, a list
+				// with an element type might have them too,
+				// so the element type is used for those.
+				for j := range nelems {
+					if elem := listElement(v, j); elem.Exists() {
+						add(fmt.Sprintf("[%d]", j), elem)
+					}
+				}
 			}
 
 			// First produce any field that has a non-struct value.
@@ -53,9 +150,13 @@ func allFields(values []cue.Value, selected Set[int], labelTypes labelType) iter
 				name, values := orderedNames[oi], ordered[oi]
 				for _, v := range values {
 					if v.Exists() && v.IncompleteKind() != cue.StructKind {
-						if !yield(pathConcat(x.path, name), values) {
+						path := pathConcat(x.path, name)
+						if !yield(path, values) {
 							return
 						}
+						if hasList(values) {
+							q.push(pathValues{path, values})
+						}
 						ordered[oi] = nil
 						continue outer
 					}
@@ -78,13 +179,152 @@ func allFields(values []cue.Value, selected Set[int], labelTypes labelType) iter
 	}
 }
 
+// selectorName returns the name of sel as it appears in a path,
+// without any optional or required marker. String labels are
+// quoted when they are not valid identifiers, so a path
+// holding a label such as "a.b" can still be split correctly.
+func selectorName(sel cue.Selector) string {
+	name := sel.String()
+	if sel.ConstraintType() != 0 {
+		// Remove the trailing ? or !.
+		name = name[:len(name)-1]
+	}
+	return name
+}
+
+// splitPath splits a path as produced by allFields into its
+// component selector names. The root path "." has no components.
+// A list index, as in "items[0].kind", is a component in its own right.
+func splitPath(path string) []string {
+	if path == "." || path == "" {
+		return nil
+	}
+	var parts []string
+	start := 0
+	inQuote := false
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '\\' && inQuote:
+			i++
+		case c == '"':
+			inQuote = !inQuote
+		case c == '.' && !inQuote:
+			if i > start {
+				parts = append(parts, path[start:i])
+			}
+			start = i + 1
+		case c == '[' && !inQuote:
+			if i > start {
+				parts = append(parts, path[start:i])
+			}
+			start = i
+		}
+	}
+	return append(parts, path[start:])
+}
+
+// CUEPath returns the path in a decision tree, as found in
+// [KindSwitchNode.Path] for example, as a [cue.Path] relative to the
+// disjunction. The root path "." yields the empty path. Its String
+// method produces the same syntax as the cue command, so it can be
+// used to combine tree paths with the path of the disjunction
+// itself.
+//
+// Hidden fields cannot be represented without knowing the package
+// they belong to, so CUEPath returns an error for paths
+// that refer to them.
+func CUEPath(path string) (cue.Path, error) {
+	var sels []cue.Selector
+	for _, name := range splitPath(path) {
+		if strings.HasPrefix(name, "_") {
+			return cue.Path{}, fmt.Errorf("cannot make path for hidden field %s", name)
+		}
+		if isIndex(name) {
+			i, err := strconv.Atoi(strings.Trim(name, "[]"))
+			if err != nil {
+				return cue.Path{}, fmt.Errorf("invalid index in path %q", path)
+			}
+			sels = append(sels, cue.Index(i))
+			continue
+		}
+		p := cue.ParsePath(name)
+		if err := p.Err(); err != nil {
+			return cue.Path{}, fmt.Errorf("invalid path %q: %v", path, err)
+		}
+		sels = append(sels, p.Selectors()...)
+	}
+	return cue.MakePath(sels...), nil
+}
+
+// pathDepth returns the number of selectors in path.
+func pathDepth(path string) int {
+	return len(splitPath(path))
+}
+
 func pathConcat(p1, p2 string) string {
 	if p1 == "" || p1 == "." {
 		return p2
 	}
+	if isIndex(p2) {
+		return p1 + p2
+	}
 	return p1 + "." + p2
 }
 
+// firstElem holds the selector name used for
+// the first element of a list.
+const firstElem = "[0]"
+
+// isIndex reports whether the selector name
+// refers to a list element.
+func isIndex(name string) bool {
+	return strings.HasPrefix(name, "[")
+}
+
+// hasList reports whether any of the values is a list.
+func hasList(values []cue.Value) bool {
+	for _, v := range values {
+		if v.Exists() && v.IncompleteKind() == cue.ListKind {
+			return true
+		}
+	}
+	return false
+}
+
+// listLen returns the number of elements of v to consider when
+// discriminating: the length of its fixed prefix, or one if it
+// has only an element type, as in [...#Event]. It returns zero
+// if v is not a list.
+func listLen(v cue.Value) int {
+	if v.IncompleteKind() != cue.ListKind {
+		return 0
+	}
+	n := 0
+	if iter, err := v.List(); err == nil {
+		for iter.Next() {
+			n++
+		}
+	}
+	if n == 0 && v.LookupPath(cue.MakePath(cue.AnyIndex)).Exists() {
+		return 1
+	}
+	return n
+}
+
+// listElement returns the value of element i of v, which
+// must be a list, or the zero value if there is none.
+// Beyond the fixed prefix of a list with an element type,
+// such as [...#Event], the element type is used.
+func listElement(v cue.Value, i int) cue.Value {
+	if v.IncompleteKind() != cue.ListKind {
+		return cue.Value{}
+	}
+	if elem := v.LookupPath(cue.MakePath(cue.Index(i))); elem.Exists() {
+		return elem
+	}
+	return v.LookupPath(cue.MakePath(cue.AnyIndex))
+}
+
 type pathValues struct {
 	path   string
 	values []cue.Value
@@ -97,14 +337,14 @@ func structFields(v cue.Value, labelTypes labelType) iter.Seq2[label, cue.Value]
 		if !v.Exists() {
 			return
 		}
-		iter, err := v.Fields(cue.Optional(true))
+		iter, err := v.Fields(cue.Optional(true), cue.Definitions(true), cue.Hidden(true))
 		if err != nil {
 			return
 		}
 		for iter.Next() {
 			if labelTypes.match(iter.FieldType()) {
 				lab := label{
-					name:      iter.Selector().Unquoted(),
+					name:      selectorName(iter.Selector()),
 					labelType: labelTypeForSelectorType(iter.FieldType()),
 				}
 				if !yield(lab, iter.Value()) {
@@ -133,7 +373,7 @@ func (t labelType) match(selt cue.SelectorType) bool {
 }
 
 func labelTypeForSelectorType(selt cue.SelectorType) labelType {
-	if (selt & cue.StringLabel) == 0 {
+	if (selt & (cue.StringLabel | cue.DefinitionLabel | cue.HiddenLabel | cue.HiddenDefinitionLabel)) == 0 {
 		return 0
 	}
 	switch selt & (cue.OptionalConstraint | cue.RequiredConstraint) {
@@ -118,6 +118,18 @@ discrim: [{
 }]
 discrim.kind: ["foo"]
 `,
+}, {
+	testName:   "ListElements",
+	labelTypes: requiredLabel,
+	cue: `
+{items!: [int, "a"]} |
+{items!: [...string]}
+`,
+	want: `
+items: [[int, "a"], [...string]]
+items[0]: [int, string]
+items[1]: ["a", string]
+`,
 }}
 
 func TestAllFields(t *testing.T) {
@@ -156,3 +168,73 @@ func disjunctionArms(v cue.Value) []cue.Value {
 	}
 	return args
 }
+
+func TestSplitPath(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		want []string
+	}{
+		{".", nil},
+		{"a", []string{"a"}},
+		{"a.b.c", []string{"a", "b", "c"}},
+		{`"a.b".c`, []string{`"a.b"`, "c"}},
+		{`a."b\".c"`, []string{"a", `"b\".c"`}},
+		{"#a._b.c", []string{"#a", "_b", "c"}},
+		{"[0].kind", []string{"[0]", "kind"}},
+		{"a[0][0].b", []string{"a", "[0]", "[0]", "b"}},
+		{`"a[0]".b`, []string{`"a[0]"`, "b"}},
+	} {
+		qt.Check(t, qt.DeepEquals(splitPath(test.path), test.want), qt.Commentf("%s", test.path))
+	}
+}
+
+func TestMatchPathPattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"a", "a", true},
+		{"a", "a.b", true},
+		{"a", "b", false},
+		{"a.b", "a", false},
+		{"spec.*", "spec", false},
+		{"spec.*", "spec.kind", true},
+		{"spec.*", "spec.x.y", true},
+		{"*.name", "metadata.name", true},
+		{"*.name", "metadata.namespace", false},
+		{"items[*].kind", "items[3].kind", true},
+		{"items[0]", "items[1].kind", false},
+		{"items[0]", "items[0].kind", true},
+		{"items.*", "items[0]", false},
+		{`"a.b"`, `"a.b".c`, true},
+		{"a", `"a.b"`, false},
+		{"[", "a", false},
+		{".", "a", false},
+	} {
+		qt.Check(t, qt.Equals(matchPathPattern(test.pattern, test.path), test.want), qt.Commentf("%s %s", test.pattern, test.path))
+	}
+}
+
+func TestCUEPath(t *testing.T) {
+	for _, test := range []struct {
+		path    string
+		want    string
+		wantErr string
+	}{
+		{path: ".", want: ""},
+		{path: "a.b", want: "a.b"},
+		{path: `"a.b".c`, want: `"a.b".c`},
+		{path: "#A.kind", want: "#A.kind"},
+		{path: "items[0].kind", want: "items[0].kind"},
+		{path: "a._b", wantErr: "cannot make path for hidden field _b"},
+	} {
+		p, err := CUEPath(test.path)
+		if test.wantErr != "" {
+			qt.Check(t, qt.ErrorMatches(err, test.wantErr), qt.Commentf("%s", test.path))
+			continue
+		}
+		qt.Assert(t, qt.IsNil(err))
+		qt.Check(t, qt.Equals(p.String(), test.want), qt.Commentf("%s", test.path))
+	}
+}
@@ -0,0 +1,42 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"cuelang.org/go/cue"
+)
+
+// Fill returns v with each value in fills unified at the
+// corresponding path, which is in the syntax accepted by
+// [cue.ParsePath] (for example "#F.in").
+//
+// This makes it possible to analyze unions inside parameterized
+// definitions such as
+//
+//	#F: {
+//		in:  string
+//		out: {t!: in, a!: int} | {t!: "z", b!: string}
+//	}
+//
+// which can only be discriminated once the inputs they
+// depend on are known. Representative inputs can be
+// filled in before calling [Disjunctions] on the result.
+//
+// It returns an error if a path is invalid or if a value
+// conflicts with v.
+func Fill(v cue.Value, fills map[string]any) (cue.Value, error) {
+	for _, path := range slices.Sorted(maps.Keys(fills)) {
+		p := cue.ParsePath(path)
+		if err := p.Err(); err != nil {
+			return cue.Value{}, fmt.Errorf("invalid fill path %q: %v", path, err)
+		}
+		v = v.FillPath(p, fills[path])
+		if err := v.Err(); err != nil {
+			// The error already mentions the path.
+			return cue.Value{}, err
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,51 @@
+package cuediscrim
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestFill(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`
+#F: {
+	in: string
+	out: {t!: in, a!: int, ...} | {t!: "z", b!: string, ...}
+}
+`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	out := cue.ParsePath("#F.out")
+
+	_, _, perfect := Discriminate(Disjunctions(v.LookupPath(out)))
+	qt.Assert(t, qt.IsFalse(perfect))
+
+	v1, err := Fill(v, map[string]any{
+		"#F.in": "x",
+	})
+	qt.Assert(t, qt.IsNil(err))
+	tree, _, perfect := Discriminate(Disjunctions(v1.LookupPath(out)))
+	qt.Assert(t, qt.IsTrue(perfect))
+	qt.Assert(t, qt.Equals(NodeString(tree), `
+switch t {
+case "x":
+	choose({0})
+case "z":
+	choose({1})
+default:
+	error
+}
+`[1:]))
+
+	_, err = Fill(v, map[string]any{
+		"#F.in": 1,
+	})
+	qt.Assert(t, qt.ErrorMatches(err, `#F.in: conflicting values string and 1 .*`))
+
+	_, err = Fill(v, map[string]any{
+		"#F.": 1,
+	})
+	qt.Assert(t, qt.ErrorMatches(err, `invalid fill path "#F.": .*`))
+}
@@ -0,0 +1,712 @@
+package cuediscrim
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"go/format"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+)
+
+// GoOption represents an option to [GenerateGo].
+type GoOption func(*goOptions)
+
+type goOptions struct {
+	pkg      string
+	funcName string
+	names    []string
+	command  string
+	chi      bool
+}
+
+// GoPackage sets the name of the package of the generated code.
+// The default is "discrim".
+func GoPackage(name string) GoOption {
+	return func(o *goOptions) {
+		o.pkg = name
+	}
+}
+
+// GoFunc sets the name of the generated function.
+// The default is "Discriminate".
+func GoFunc(name string) GoOption {
+	return func(o *goOptions) {
+		o.funcName = name
+	}
+}
+
+// GoArmNames causes the generated code to include a table holding
+// the name of each arm, such as that returned by [ArmNames], so that
+// callers can map the arm indexes returned by the function to names.
+func GoArmNames(names []string) GoOption {
+	return func(o *goOptions) {
+		o.names = names
+	}
+}
+
+// GoGeneratedBy sets the name of the command mentioned in the
+// "Code generated" comment at the start of the generated code.
+// The default is "cuediscrim".
+func GoGeneratedBy(command string) GoOption {
+	return func(o *goOptions) {
+		o.command = command
+	}
+}
+
+// GenerateGo returns Go source code for a function that classifies a
+// value in the same way as n.Check, but without any need for CUE at
+// run time. The function (named Discriminate by default; see [GoFunc])
+// takes a value as decoded by encoding/json and returns the indexes
+// of the arms chosen for it. Another function with a JSON suffix
+// takes the JSON-encoded value instead. When a leaf of n holds several
+// arms, they are returned in the order given by [LeafNode.Ordered],
+// so a caller that must pick one can take the first.
+//
+// The generated code has a switch statement for each [KindSwitchNode],
+// [ValueSwitchNode], [RangeSwitchNode], [LenSwitchNode] and
+// [RegexSwitchNode] in n. The
+// regular expressions are compiled once, when the package is initialized.
+// A ValueSwitchNode with many branches, or with its Enum field set
+// to [EnumMap] or [EnumBinarySearch], finds the case for a value by
+// looking it up in a map or a sorted table instead; see [Enums].
+// As JSON has no distinct integer type,
+// a number is considered to be an int if it has no fraction or
+// exponent when decoded as a [json.Number], or if it is integral
+// when decoded as a float64. The value passed to the function is
+// always present, so an [OptionalNode] at the root of n has no effect.
+// Definitions and hidden fields are always absent, as JSON cannot
+// hold them.
+func GenerateGo(n DecisionNode, opts ...GoOption) ([]byte, error) {
+	o := goOptions{
+		pkg:      "discrim",
+		funcName: "Discriminate",
+		command:  "cuediscrim",
+	}
+	for _, f := range opts {
+		f(&o)
+	}
+	if !isGoIdent(o.pkg) || !isGoIdent(o.funcName) {
+		return nil, fmt.Errorf("invalid Go identifier in package %q or function %q", o.pkg, o.funcName)
+	}
+	decls, regexps := goDiscriminatorDecls(n, o)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by %s. DO NOT EDIT.\n\n", o.command)
+	fmt.Fprintf(&buf, "package %s\n\n", o.pkg)
+	fmt.Fprintf(&buf, "import (\n\t\"bytes\"\n\t\"encoding/json\"\n\t\"math\"\n")
+	if regexps {
+		fmt.Fprintf(&buf, "\t\"regexp\"\n")
+	}
+	fmt.Fprintf(&buf, "\t\"strconv\"\n\t\"strings\"\n)\n\n")
+	buf.Write(decls)
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("cannot format generated code: %v", err)
+	}
+	return src, nil
+}
+
+// goDiscriminatorDecls returns the declarations of the function
+// generated by [GenerateGo] for n, named as specified by o, and
+// of its tables and helpers. It also reports whether they use the
+// regexp package; they always use bytes, encoding/json, math,
+// strconv and strings.
+func goDiscriminatorDecls(n DecisionNode, o goOptions) ([]byte, bool) {
+	g := &goGenerator{
+		helper: lowerFirst(o.funcName),
+	}
+	// Generate the function body first so that we
+	// know which regular expressions it needs.
+	g.node(n)
+	body := bytes.Clone(g.buf.Bytes())
+	g.buf.Reset()
+	if o.names != nil {
+		g.printf("// %sArmNames holds the name of each arm, indexed by\n", o.funcName)
+		g.printf("// the values returned by %s.\n", o.funcName)
+		g.printf("var %sArmNames = []string{\n", o.funcName)
+		for _, name := range o.names {
+			g.printf("%s,\n", strconv.Quote(name))
+		}
+		g.printf("}\n\n")
+	}
+	if len(g.regexps) > 0 {
+		g.printf("// %sRegexps holds the regular expressions\n", g.helper)
+		g.printf("// used by %s.\n", o.funcName)
+		g.printf("var %sRegexps = []*regexp.Regexp{\n", g.helper)
+		for _, p := range g.regexps {
+			g.printf("regexp.MustCompile(%s),\n", strconv.Quote(p))
+		}
+		g.printf("}\n\n")
+	}
+	searches := false
+	for i, table := range g.tables {
+		g.printf("// %sTable%d maps the values %s to\n", g.helper, i, tablePathDesc(table.path))
+		g.printf("// the cases of a switch in %s.\n", o.funcName)
+		if table.strategy == EnumMap {
+			g.printf("var %sTable%d = map[string]int{\n", g.helper, i)
+			for _, e := range table.entries {
+				g.printf("%s: %d,\n", strconv.Quote(e.key), e.index)
+			}
+		} else {
+			// The entries are sorted by key for the
+			// binary search, which compares bytes.
+			searches = true
+			g.printf("var %sTable%d = []%sEntry{\n", g.helper, i, g.helper)
+			for _, e := range table.sorted(strings.Compare) {
+				g.printf("{%s, %d},\n", strconv.Quote(e.key), e.index)
+			}
+		}
+		g.printf("}\n\n")
+	}
+	g.printf("// %s returns the indexes of the arms that v is classified as,\n", o.funcName)
+	g.printf("// where v is as decoded by encoding/json, preferably using\n")
+	g.printf("// json.Decoder.UseNumber so that integers and floats can be\n")
+	g.printf("// told apart. It returns nil if v matches no arm.\n")
+	g.printf("func %s(v any) []int {\n", o.funcName)
+	g.buf.Write(body)
+	g.printf("}\n\n")
+	g.printf(goHelpers, o.funcName, g.helper)
+	if searches {
+		g.printf(goSearchHelpers, g.helper)
+	}
+	return g.buf.Bytes(), len(g.regexps) > 0
+}
+
+type goGenerator struct {
+	buf    bytes.Buffer
+	helper string
+
+	// regexps holds the patterns used by the
+	// generated code, indexed by their position
+	// in the generated table.
+	regexps []string
+
+	// tables holds the lookup tables for value switches
+	// rendered with [EnumMap] or [EnumBinarySearch].
+	tables []valueTable
+
+	// keys is used to compare the branches of value switches.
+	keys nodeKeys
+}
+
+// valueTable holds a table that maps the key of each
+// value in a [ValueSwitchNode] to the index of its case.
+// Case indexes start at 1, so that a missing key
+// maps to the default case.
+type valueTable struct {
+	path     string
+	strategy EnumStrategy
+	entries  []valueTableEntry
+}
+
+// sorted returns the entries of t sorted by key
+// according to cmp.
+func (t valueTable) sorted(cmp func(string, string) int) []valueTableEntry {
+	return slices.SortedFunc(slices.Values(t.entries), func(e0, e1 valueTableEntry) int {
+		return cmp(e0.key, e1.key)
+	})
+}
+
+type valueTableEntry struct {
+	key   string
+	index int
+}
+
+// valueTableCases returns the cases of n, each holding the
+// keys that select it as returned by atomKey, with the
+// node for the case. Values that no key can match, and
+// keys that appear in an earlier case, are omitted,
+// as are cases with no keys at all. The nodes are compared
+// using nkeys, as for [ValueSwitchNode.foldBranches].
+func valueTableCases(n *ValueSwitchNode, atomKey func(Atom) (string, bool), nkeys *nodeKeys) (keys [][]string, nodes []DecisionNode) {
+	seen := make(map[string]bool)
+	for _, group := range n.foldBranches(nkeys) {
+		var groupKeys []string
+		for _, a := range group.values {
+			key, ok := atomKey(a)
+			if !ok || seen[key] {
+				continue
+			}
+			seen[key] = true
+			groupKeys = append(groupKeys, key)
+		}
+		if len(groupKeys) == 0 {
+			// No JSON value can hold any of the values.
+			continue
+		}
+		keys = append(keys, groupKeys)
+		nodes = append(nodes, group.node)
+	}
+	return keys, nodes
+}
+
+// tupleKeys returns the keys for the values of a [TupleBranch]
+// as returned by atomKey, reporting false if no value
+// can match one of them.
+func tupleKeys(values []Atom, atomKey func(Atom) (string, bool)) ([]string, bool) {
+	keys := make([]string, len(values))
+	for i, a := range values {
+		key, ok := atomKey(a)
+		if !ok {
+			return nil, false
+		}
+		keys[i] = key
+	}
+	return keys, true
+}
+
+// newValueTable returns a table for a switch on path
+// with the cases holding the given keys.
+func newValueTable(path string, strategy EnumStrategy, keys [][]string) valueTable {
+	t := valueTable{
+		path:     path,
+		strategy: strategy,
+	}
+	for i, group := range keys {
+		for _, key := range group {
+			t.entries = append(t.entries, valueTableEntry{key, i + 1})
+		}
+	}
+	return t
+}
+
+// tablePathDesc describes the value at path
+// in the comment for a table.
+func tablePathDesc(path string) string {
+	if path == "." {
+		return "of the value"
+	}
+	return "at " + path
+}
+
+// table returns the name of a new table holding
+// the given case keys for a switch on path.
+func (g *goGenerator) table(path string, strategy EnumStrategy, keys [][]string) string {
+	g.tables = append(g.tables, newValueTable(path, strategy, keys))
+	return fmt.Sprintf("%sTable%d", g.helper, len(g.tables)-1)
+}
+
+// regexp returns an expression for the compiled form of pattern.
+func (g *goGenerator) regexp(pattern string) string {
+	i := slices.Index(g.regexps, pattern)
+	if i < 0 {
+		i = len(g.regexps)
+		g.regexps = append(g.regexps, pattern)
+	}
+	return fmt.Sprintf("%sRegexps[%d]", g.helper, i)
+}
+
+func (g *goGenerator) printf(f string, a ...any) {
+	fmt.Fprintf(&g.buf, f, a...)
+}
+
+// node writes the statements that return the arms chosen by n.
+func (g *goGenerator) node(n DecisionNode) {
+	switch n := n.(type) {
+	case *LeafNode:
+		g.printf("return %s\n", goInts(n.Ordered()))
+	case *KindSwitchNode:
+		g.printf("switch %sKind(%sLookup(v%s)) {\n", g.helper, g.helper, goPathArgs(n.Path))
+		for _, k := range ordered(n.Branches, n.Order, cmp.Compare[cue.Kind]) {
+			g.printf("case %q:\n", k.String())
+			g.node(n.Branches[k])
+		}
+		g.printf("}\nreturn nil\n")
+	case *ValueSwitchNode:
+		keys, nodes := valueTableCases(n, goAtomKey, &g.keys)
+		value := fmt.Sprintf("%sValue(%sLookup(v%s))", g.helper, g.helper, goPathArgs(n.Path))
+		switch s := n.Enum.Choose(len(n.Branches), 0); s {
+		case EnumMap, EnumBinarySearch:
+			table := g.table(n.Path, s, keys)
+			if s == EnumMap {
+				g.printf("switch %s[%s] {\n", table, value)
+			} else {
+				g.printf("switch %sSearch(%s, %s) {\n", g.helper, table, value)
+			}
+			for i, node := range nodes {
+				g.printf("case %d:\n", i+1)
+				g.node(node)
+			}
+		default:
+			g.printf("switch %s {\n", value)
+			for i, node := range nodes {
+				quoted := make([]string, len(keys[i]))
+				for j, key := range keys[i] {
+					quoted[j] = strconv.Quote(key)
+				}
+				g.printf("case %s:\n", strings.Join(quoted, ", "))
+				g.node(node)
+			}
+		}
+		g.printf("default:\n")
+		if n.Default != nil {
+			g.node(n.Default)
+		} else {
+			g.printf("return nil\n")
+		}
+		g.printf("}\n")
+	case *RangeSwitchNode:
+		g.printf("if x, ok := %sNumber(%sLookup(v%s)); ok {\n", g.helper, g.helper, goPathArgs(n.Path))
+		g.printf("switch {\n")
+		for _, b := range n.Branches {
+			g.printf("case %s:\n", intervalCond(b.Interval, "x"))
+			g.node(b.Node)
+		}
+		g.printf("}\n}\n")
+		if n.Default != nil {
+			g.node(n.Default)
+		} else {
+			g.printf("return nil\n")
+		}
+	case *LenSwitchNode:
+		g.printf("if n, ok := %sLen(%sLookup(v%s)); ok {\n", g.helper, g.helper, goPathArgs(n.Path))
+		g.printf("switch {\n")
+		for _, b := range n.Branches {
+			g.printf("case %s:\n", intervalCond(b.Interval, "n"))
+			g.node(b.Node)
+		}
+		g.printf("}\n}\n")
+		if n.Default != nil {
+			g.node(n.Default)
+		} else {
+			g.printf("return nil\n")
+		}
+	case *RegexSwitchNode:
+		g.printf("if s, ok := %sString(%sLookup(v%s)); ok {\n", g.helper, g.helper, goPathArgs(n.Path))
+		g.printf("switch {\n")
+		for _, b := range n.Branches {
+			g.printf("case %s.MatchString(s):\n", g.regexp(b.Pattern))
+			g.node(b.Node)
+		}
+		g.printf("}\n}\n")
+		if n.Default != nil {
+			g.node(n.Default)
+		} else {
+			g.printf("return nil\n")
+		}
+	case *FieldPresenceNode:
+		for _, b := range n.Branches {
+			g.printf("if _, ok := %sLookup(v%s); ok {\n", g.helper, goPathArgs(b.Path))
+			g.node(b.Node)
+			g.printf("}\n")
+		}
+		if n.Default != nil {
+			g.node(n.Default)
+		} else {
+			g.printf("return nil\n")
+		}
+	case *TupleSwitchNode:
+		values := make([]string, len(n.Paths))
+		for i, path := range n.Paths {
+			values[i] = fmt.Sprintf("%sValue(%sLookup(v%s))", g.helper, g.helper, goPathArgs(path))
+		}
+		g.printf("switch [%d]string{%s} {\n", len(n.Paths), strings.Join(values, ", "))
+		for _, b := range n.Branches {
+			keys, ok := tupleKeys(b.Values, goAtomKey)
+			if !ok {
+				continue
+			}
+			for i, key := range keys {
+				keys[i] = strconv.Quote(key)
+			}
+			g.printf("case [%d]string{%s}:\n", len(keys), strings.Join(keys, ", "))
+			g.node(b.Node)
+		}
+		g.printf("}\nreturn nil\n")
+	case *FieldAbsenceNode:
+		g.printf("var arms []int\nfound := false\n")
+		for _, path := range slices.Sorted(maps.Keys(n.Branches)) {
+			g.printf("if _, ok := %sLookup(v%s); !ok {\n", g.helper, goPathArgs(path))
+			g.printf("arms = %sIntersect(arms, found, %s)\n", g.helper, goInts(sortedInts(n.Branches[path])))
+			g.printf("found = true\n}\n")
+		}
+		g.printf("if !found {\nreturn %s\n}\nreturn arms\n", goInts(sortedInts(n.Possible())))
+	case *OptionalNode:
+		g.node(n.Present)
+	case *GroupNode:
+		if n.Name != "" {
+			g.printf("// group %s\n", n.Name)
+		}
+		g.node(n.Select)
+	default:
+		g.printf("return nil\n")
+	}
+}
+
+// goPathArgs returns the path as extra arguments to the lookup helper.
+// Field names are unquoted and quoted again as Go string literals,
+// so names that aren't identifiers, such as "a.b", are looked up as
+// a single field.
+func goPathArgs(path string) string {
+	var buf strings.Builder
+	for _, sel := range policyPath(path) {
+		if sel.IsIndex {
+			fmt.Fprintf(&buf, ", %d", sel.Index)
+		} else {
+			fmt.Fprintf(&buf, ", %s", strconv.Quote(sel.Field))
+		}
+	}
+	return buf.String()
+}
+
+// intervalCond returns a condition, in Go or TypeScript
+// syntax, that holds when the number x is in iv.
+func intervalCond(iv Interval, x string) string {
+	var conds []string
+	if iv.Min.Value != "" {
+		if iv.Min.Inclusive {
+			conds = append(conds, x+" >= "+iv.Min.Value)
+		} else {
+			conds = append(conds, x+" > "+iv.Min.Value)
+		}
+	}
+	if iv.Max.Value != "" {
+		if iv.Max.Inclusive {
+			conds = append(conds, x+" <= "+iv.Max.Value)
+		} else {
+			conds = append(conds, x+" < "+iv.Max.Value)
+		}
+	}
+	if len(conds) == 0 {
+		return "true"
+	}
+	return strings.Join(conds, " && ")
+}
+
+func goInts(xs []int) string {
+	if len(xs) == 0 {
+		return "nil"
+	}
+	parts := make([]string, len(xs))
+	for i, x := range xs {
+		parts[i] = strconv.Itoa(x)
+	}
+	return "[]int{" + strings.Join(parts, ", ") + "}"
+}
+
+// goAtomKey returns the key that the value helper in the generated
+// code produces for a JSON value equal to a. It reports false
+// if no JSON value can equal a.
+func goAtomKey(a Atom) (string, bool) {
+	v := cuecontext.New().CompileString(a.String())
+	switch v.Kind() {
+	case cue.NullKind:
+		return "null", true
+	case cue.BoolKind:
+		b, _ := v.Bool()
+		return "bool:" + strconv.FormatBool(b), true
+	case cue.StringKind:
+		s, _ := v.String()
+		return "string:" + s, true
+	case cue.IntKind:
+		f, _ := v.Float64()
+		return "int:" + strconv.FormatFloat(f, 'f', -1, 64), true
+	case cue.FloatKind:
+		f, _ := v.Float64()
+		return "float:" + strconv.FormatFloat(f, 'g', -1, 64), true
+	}
+	return "", false
+}
+
+func isGoIdent(s string) bool {
+	for i, r := range s {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func lowerFirst(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[size:]
+}
+
+// goHelpers holds the helper functions in the generated code.
+// It is formatted with the function name and the prefix
+// for helper names.
+const goHelpers = `// %[1]sJSON is like %[1]s except that it decodes
+// the value from data, which holds JSON.
+func %[1]sJSON(data []byte) ([]int, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return %[1]s(v), nil
+}
+
+// %[2]sLookup returns the value at the given path in v, where each
+// element of the path is a field name or a list index, and reports
+// whether it exists.
+func %[2]sLookup(v any, path ...any) (any, bool) {
+	for _, p := range path {
+		switch p := p.(type) {
+		case string:
+			m, ok := v.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[p]; !ok {
+				return nil, false
+			}
+		case int:
+			l, ok := v.([]any)
+			if !ok || p >= len(l) {
+				return nil, false
+			}
+			v = l[p]
+		}
+	}
+	return v, true
+}
+
+// %[2]sKind returns the CUE kind of v, or the empty
+// string if it does not exist.
+func %[2]sKind(v any, exists bool) string {
+	if !exists {
+		return ""
+	}
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "struct"
+	case json.Number:
+		if strings.ContainsAny(string(v), ".eE") {
+			return "float"
+		}
+		return "int"
+	case float64:
+		if v == math.Trunc(v) {
+			return "int"
+		}
+		return "float"
+	}
+	return ""
+}
+
+// %[2]sValue returns a key identifying the value of v when it is a
+// constant, or the empty string otherwise.
+func %[2]sValue(v any, exists bool) string {
+	switch k := %[2]sKind(v, exists); k {
+	case "null":
+		return "null"
+	case "bool":
+		return "bool:" + strconv.FormatBool(v.(bool))
+	case "string":
+		return "string:" + v.(string)
+	case "int", "float":
+		var f float64
+		switch v := v.(type) {
+		case json.Number:
+			f, _ = v.Float64()
+		case float64:
+			f = v
+		}
+		if k == "int" {
+			return "int:" + strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return "float:" + strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return ""
+}
+
+// %[2]sNumber returns the value of v if it is a number,
+// and reports whether it is.
+func %[2]sNumber(v any, exists bool) (float64, bool) {
+	if !exists {
+		return 0, false
+	}
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// %[2]sLen returns the length of v if it is a list,
+// and reports whether it is.
+func %[2]sLen(v any, exists bool) (int, bool) {
+	if !exists {
+		return 0, false
+	}
+	l, ok := v.([]any)
+	return len(l), ok
+}
+
+// %[2]sString returns the value of v if it is a string,
+// and reports whether it is.
+func %[2]sString(v any, exists bool) (string, bool) {
+	if !exists {
+		return "", false
+	}
+	s, ok := v.(string)
+	return s, ok
+}
+
+// %[2]sIntersect returns the arms in both arms and group,
+// or group if found is false.
+func %[2]sIntersect(arms []int, found bool, group []int) []int {
+	if !found {
+		return group
+	}
+	var result []int
+	for _, arm := range arms {
+		for _, g := range group {
+			if arm == g {
+				result = append(result, arm)
+				break
+			}
+		}
+	}
+	return result
+}
+`
+
+// goSearchHelpers holds the helpers in the generated code for
+// value switches rendered with [EnumBinarySearch]. It is
+// formatted with the prefix for helper names.
+const goSearchHelpers = `
+// %[1]sEntry holds the case for a value key
+// in a table sorted by key.
+type %[1]sEntry struct {
+	key   string
+	index int
+}
+
+// %[1]sSearch returns the case for key in table, which
+// is sorted by key, or zero if there is none.
+func %[1]sSearch(table []%[1]sEntry, key string) int {
+	i, j := 0, len(table)
+	for i < j {
+		h := int(uint(i+j) >> 1)
+		if table[h].key < key {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
+	if i < len(table) && table[i].key == key {
+		return table[i].index
+	}
+	return 0
+}
+`
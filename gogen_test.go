@@ -0,0 +1,225 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestGenerateGoMatchesTree(t *testing.T) {
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not available")
+	}
+	if testing.Short() {
+		t.Skip("skipping build of generated code in short mode")
+	}
+	ctx := cuecontext.New()
+	dir := t.TempDir()
+	var main, want strings.Builder
+	main.WriteString("package main\n\nimport \"fmt\"\n\nfunc main() {\n")
+	for i, test := range buildDecisionTreeTests {
+		val := ctx.CompileString(test.cue)
+		qt.Assert(t, qt.IsNil(val.Err()))
+		// Generate code from the tree with every value
+		// switch looked up in a table too.
+		for _, enums := range []struct {
+			suffix string
+			opts   []Option
+		}{
+			{"", nil},
+			{"Map", []Option{Enums(EnumMap, 0)}},
+			{"Search", []Option{Enums(EnumBinarySearch, 0)}},
+		} {
+			funcName := fmt.Sprintf("Test%d%s", i, enums.suffix)
+			tree, _, _ := Discriminate(Disjunctions(val), enums.opts...)
+			src, err := GenerateGo(tree, GoPackage("main"), GoFunc(funcName))
+			qt.Assert(t, qt.IsNil(err), qt.Commentf("test %s", test.testName))
+			err = os.WriteFile(filepath.Join(dir, strings.ToLower(funcName)+".go"), src, 0o666)
+			qt.Assert(t, qt.IsNil(err))
+			for _, dtest := range test.data {
+				data, err := ctx.CompileString(dtest.cue).MarshalJSON()
+				if err != nil {
+					// Incomplete values have no JSON encoding.
+					continue
+				}
+				name := funcName + "/" + test.testName + "/" + dtest.name
+				fmt.Fprintf(&main, "\tfmt.Println(%q, must(%sJSON([]byte(%s))))\n", name, funcName, strconv.Quote(string(data)))
+				// Definitions and hidden fields are lost in the
+				// JSON encoding, so compare with the tree's result
+				// for the value that the generated code sees.
+				got := tree.Check(ctx.CompileBytes(data))
+				fmt.Fprintln(&want, name, slices.Sorted(got.Values()))
+			}
+		}
+	}
+	main.WriteString("}\n\nfunc must(arms []int, err error) []int {\n\tif err != nil {\n\t\tpanic(err)\n\t}\n\treturn arms\n}\n")
+	err = os.WriteFile(filepath.Join(dir, "main.go"), []byte(main.String()), 0o666)
+	qt.Assert(t, qt.IsNil(err))
+	err = os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.test/gen\n\ngo 1.22\n"), 0o666)
+	qt.Assert(t, qt.IsNil(err))
+
+	cmd := exec.Command(goCmd, "run", ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	qt.Assert(t, qt.IsNil(err), qt.Commentf("output: %s", out))
+	qt.Check(t, qt.Equals(string(out), want.String()))
+}
+
+func TestGenerateGo(t *testing.T) {
+	v := cuecontext.New().CompileString(`{type!: "a", x!: int} | {type!: "b"} | [...string]`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v))
+	src, err := GenerateGo(tree, GoPackage("shapes"), GoFunc("Classify"), GoArmNames([]string{"A", "B", "List"}))
+	qt.Assert(t, qt.IsNil(err))
+	s := string(src)
+	qt.Check(t, qt.StringContains(s, "// Code generated by cuediscrim. DO NOT EDIT.\n\npackage shapes\n"))
+	qt.Check(t, qt.StringContains(s, `
+var ClassifyArmNames = []string{
+	"A",
+	"B",
+	"List",
+}
+`))
+	qt.Check(t, qt.StringContains(s, `
+func Classify(v any) []int {
+	switch classifyKind(classifyLookup(v)) {
+	case "list":
+		return []int{2}
+	case "struct":
+		switch classifyValue(classifyLookup(v, "type")) {
+		case "string:a":
+			return []int{0}
+		case "string:b":
+			return []int{1}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+`))
+	qt.Check(t, qt.StringContains(s, "func ClassifyJSON(data []byte) ([]int, error) {"))
+}
+
+func TestGenerateGoMap(t *testing.T) {
+	v := cuecontext.New().CompileString(`{type!: "a" | "b", x!: int} | {type!: "c"} | [...string]`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v), Enums(EnumAuto, 2))
+	src, err := GenerateGo(tree, GoFunc("Classify"))
+	qt.Assert(t, qt.IsNil(err))
+	s := string(src)
+	qt.Check(t, qt.StringContains(s, `
+// classifyTable0 maps the values at type to
+// the cases of a switch in Classify.
+var classifyTable0 = map[string]int{
+	"string:a": 1,
+	"string:b": 1,
+	"string:c": 2,
+}
+`))
+	qt.Check(t, qt.StringContains(s, `
+	case "struct":
+		switch classifyTable0[classifyValue(classifyLookup(v, "type"))] {
+		case 1:
+			return []int{0}
+		case 2:
+			return []int{1}
+		default:
+			return nil
+		}
+`))
+}
+
+func TestGenerateGoBinarySearch(t *testing.T) {
+	v := cuecontext.New().CompileString(`{type!: "c" | "a", x!: int} | {type!: "b"}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v), Enums(EnumBinarySearch, 0))
+	src, err := GenerateGo(tree, GoFunc("Classify"))
+	qt.Assert(t, qt.IsNil(err))
+	s := string(src)
+	qt.Check(t, qt.StringContains(s, `
+var classifyTable0 = []classifyEntry{
+	{"string:a", 1},
+	{"string:b", 2},
+	{"string:c", 1},
+}
+`))
+	qt.Check(t, qt.StringContains(s, `
+	switch classifySearch(classifyTable0, classifyValue(classifyLookup(v, "type"))) {
+	case 1:
+		return []int{0}
+	case 2:
+		return []int{1}
+	default:
+		return nil
+	}
+`))
+	qt.Check(t, qt.StringContains(s, "func classifySearch(table []classifyEntry, key string) int {"))
+}
+
+func TestGenerateGoFieldPresence(t *testing.T) {
+	v := cuecontext.New().CompileString(`{a!: int} | {b!: string} | {c?: int}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v), ClosedWorld(true))
+	src, err := GenerateGo(tree, GoFunc("Classify"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.StringContains(string(src), `
+func Classify(v any) []int {
+	if _, ok := classifyLookup(v, "a"); ok {
+		return []int{0}
+	}
+	if _, ok := classifyLookup(v, "b"); ok {
+		return []int{1}
+	}
+	return []int{2}
+}
+`))
+}
+
+func TestGenerateGoPrecedence(t *testing.T) {
+	v := cuecontext.New().CompileString(`>0 | >10`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v))
+	src, err := GenerateGo(tree, GoFunc("Classify"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.StringContains(string(src), `
+func Classify(v any) []int {
+	return []int{1, 0}
+}
+`))
+}
+
+func TestGenerateGoTupleSwitch(t *testing.T) {
+	v := cuecontext.New().CompileString(`{a!: "foo", b!: true} | {a!: "foo", b!: false} | {a!: "bar", b!: true}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v))
+	src, err := GenerateGo(tree, GoFunc("Classify"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.StringContains(string(src), `
+func Classify(v any) []int {
+	switch [2]string{classifyValue(classifyLookup(v, "a")), classifyValue(classifyLookup(v, "b"))} {
+	case [2]string{"string:bar", "bool:true"}:
+		return []int{2}
+	case [2]string{"string:foo", "bool:false"}:
+		return []int{1}
+	case [2]string{"string:foo", "bool:true"}:
+		return []int{0}
+	}
+	return nil
+}
+`))
+}
+
+func TestGenerateGoInvalidName(t *testing.T) {
+	_, err := GenerateGo(&LeafNode{Arms: setOf(0)}, GoFunc("not-an-ident"))
+	qt.Check(t, qt.ErrorMatches(err, `invalid Go identifier in package "discrim" or function "not-an-ident"`))
+}
@@ -0,0 +1,46 @@
+package cuediscrim
+
+// #GoTypeManifest maps the arms of a set of unions to Go types,
+// as produced by GoTypeManifest.CUE.
+#GoTypeManifest: {
+	// unions holds an entry for each union.
+	unions!: [...#Union]
+}
+
+#Union: {
+	// union holds the name of the union.
+	union!: string
+
+	// arms holds an entry for each arm that has a Go type.
+	arms!: [...#Arm]
+}
+
+#Arm: {
+	// arm holds the index of the arm in the union.
+	arm!: int & >=0
+
+	// name holds the name of the arm, if it has one.
+	name?: string
+
+	// tags holds the values of fields that select the arm alone.
+	tags?: [...{
+		// path holds the path of the field within the union.
+		path!: string
+
+		// value holds the CUE representation of the value
+		// (for example "\"circle\"" or "1").
+		value!: string
+	}]
+
+	// type holds the Go type of the arm.
+	type!: #GoType
+}
+
+#GoType: {
+	// importPath holds the import path of the package that
+	// declares the type. It is absent for predeclared types.
+	importPath?: string
+
+	// name holds the name of the type within its package.
+	name!: string
+}
@@ -0,0 +1,246 @@
+package cuediscrim
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/literal"
+)
+
+// GoTypeManifestSchema holds the CUE source of the schema for Go type
+// manifests, which defines #GoTypeManifest. The result of
+// [GoTypeManifest.CUE] always conforms to #GoTypeManifest.
+//
+//go:embed gomanifest.cue
+var GoTypeManifestSchema string
+
+// GoTypeUnion describes a union whose arms are mapped
+// to Go types by [NewGoTypeManifest].
+type GoTypeUnion struct {
+	// Name holds the CUE path of the union, such as #Shape.
+	Name string
+
+	// Result holds the analysis of the union.
+	Result *Result
+
+	// Types maps arms of the union to the Go types that they
+	// decode to. Each key is either the name of an arm, as
+	// returned by [ArmNames], or a value of a field that the
+	// decision tree switches on that selects a single arm,
+	// such as "circle" or, equivalently, "\"circle\"". Each
+	// value is a Go type name qualified by its import path, such
+	// as example.com/shapes.Circle, or a predeclared type name
+	// such as string.
+	Types map[string]string
+}
+
+// GoTypeManifest maps the arms of a set of unions to Go types. Its
+// JSON form is intended to be consumed by code generators that wire
+// up decoders for the unions, so that the mapping is checked against
+// the schema rather than maintained by hand.
+type GoTypeManifest struct {
+	// Unions holds an entry for each union, in the order
+	// they were given to [NewGoTypeManifest].
+	Unions []GoTypeManifestUnion `json:"unions"`
+}
+
+// GoTypeManifestUnion holds the Go types of the arms of a union.
+type GoTypeManifestUnion struct {
+	// Union holds the name of the union.
+	Union string `json:"union"`
+
+	// Arms holds an entry for each arm, in order.
+	Arms []GoTypeManifestArm `json:"arms"`
+}
+
+// GoTypeManifestArm holds the Go type of an arm of a union.
+type GoTypeManifestArm struct {
+	// Arm holds the index of the arm in the union.
+	Arm int `json:"arm"`
+
+	// Name holds the name of the arm, if it has one.
+	Name string `json:"name,omitempty"`
+
+	// Tags holds the values of fields that select the arm alone.
+	Tags []GoTypeManifestTag `json:"tags,omitempty"`
+
+	// Type holds the Go type of the arm.
+	Type GoTypeRef `json:"type"`
+}
+
+// GoTypeManifestTag holds a value of a field that selects an arm.
+type GoTypeManifestTag struct {
+	// Path holds the path of the field within the union.
+	Path string `json:"path"`
+
+	// Value holds the CUE representation of the value.
+	Value string `json:"value"`
+}
+
+// GoTypeRef refers to a Go type.
+type GoTypeRef struct {
+	// ImportPath holds the import path of the package that
+	// declares the type. It is empty for predeclared types.
+	ImportPath string `json:"importPath,omitempty"`
+
+	// Name holds the name of the type within its package.
+	Name string `json:"name"`
+}
+
+func (t GoTypeRef) String() string {
+	if t.ImportPath == "" {
+		return t.Name
+	}
+	return t.ImportPath + "." + t.Name
+}
+
+// ParseGoTypeRef parses a Go type name qualified by its import
+// path, such as example.com/shapes.Circle, or a predeclared type
+// name such as string.
+func ParseGoTypeRef(s string) (GoTypeRef, error) {
+	dir, elem := "", s
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		dir, elem = s[:i+1], s[i+1:]
+	}
+	var t GoTypeRef
+	if pkg, name, ok := strings.Cut(elem, "."); ok {
+		t = GoTypeRef{
+			ImportPath: dir + pkg,
+			Name:       name,
+		}
+	} else if dir == "" {
+		t.Name = elem
+	}
+	if !isGoIdent(t.Name) || (t.ImportPath == "" && dir != "") {
+		return GoTypeRef{}, fmt.Errorf("invalid Go type %q", s)
+	}
+	return t, nil
+}
+
+// NewGoTypeManifest returns the manifest mapping the arms of each of
+// the given unions to Go types. It checks the mapping against the
+// decision tree for each union: each union must be told apart
+// perfectly, each key must name an arm, no arm may be given two
+// different types, and every arm that the tree can choose must have
+// a type. If any of those checks fail, it returns an error
+// describing all the failures.
+func NewGoTypeManifest(unions []GoTypeUnion) (*GoTypeManifest, error) {
+	m := &GoTypeManifest{
+		Unions: []GoTypeManifestUnion{},
+	}
+	var errs []error
+	for _, u := range unions {
+		mu, uerrs := goTypeManifestUnion(u)
+		m.Unions = append(m.Unions, mu)
+		for _, err := range uerrs {
+			errs = append(errs, fmt.Errorf("%s: %v", u.Name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// goTypeManifestUnion returns the manifest entry for u
+// along with any problems found with its types.
+func goTypeManifestUnion(u GoTypeUnion) (GoTypeManifestUnion, []error) {
+	r := u.Result
+	mu := GoTypeManifestUnion{
+		Union: u.Name,
+		Arms:  []GoTypeManifestArm{},
+	}
+	var errs []error
+	if !r.Perfect {
+		errs = append(errs, fmt.Errorf("arms are not all told apart, so a decoder cannot choose a single type"))
+	}
+
+	// Find the tag values that select each arm alone, which
+	// can be used as keys as well as the arm names.
+	tags := make(map[int][]GoTypeManifestTag)
+	keys := make(map[string]int)
+	for _, f := range goTagValues(r.Tree) {
+		for _, a := range f.values {
+			arms := f.arms[a]
+			if arms == nil || arms.Len() != 1 {
+				continue
+			}
+			arm := sortedInts(arms)[0]
+			tags[arm] = append(tags[arm], GoTypeManifestTag{
+				Path:  f.path,
+				Value: a.String(),
+			})
+			keys[a.String()] = arm
+			if a.kind() == cue.StringKind {
+				if s, err := literal.Unquote(a.String()); err == nil {
+					keys[s] = arm
+				}
+			}
+		}
+	}
+	for arm, name := range r.Names {
+		if name != "" {
+			keys[name] = arm
+		}
+	}
+
+	types := make(map[int]GoTypeRef)
+	for _, key := range slices.Sorted(maps.Keys(u.Types)) {
+		t, err := ParseGoTypeRef(u.Types[key])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", key, err))
+			continue
+		}
+		arm, ok := keys[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s does not name an arm or select a single arm", key))
+			continue
+		}
+		if t0, ok := types[arm]; ok && t0 != t {
+			errs = append(errs, fmt.Errorf("%s is given both type %v and type %v", armName(r.Names, arm), t0, t))
+			continue
+		}
+		types[arm] = t
+	}
+	possible := r.Tree.Possible()
+	for arm := range r.Arms {
+		t, ok := types[arm]
+		if !ok {
+			if possible.Has(arm) {
+				errs = append(errs, fmt.Errorf("%s has no Go type", armName(r.Names, arm)))
+			}
+			continue
+		}
+		ma := GoTypeManifestArm{
+			Arm:  arm,
+			Tags: tags[arm],
+			Type: t,
+		}
+		if arm < len(r.Names) {
+			ma.Name = r.Names[arm]
+		}
+		mu.Arms = append(mu.Arms, ma)
+	}
+	return mu, errs
+}
+
+// armName returns a description of the given arm
+// using its name if it has one.
+func armName(names []string, arm int) string {
+	if arm < len(names) && names[arm] != "" {
+		return names[arm]
+	}
+	return fmt.Sprintf("arm %d", arm)
+}
+
+// CUE returns the manifest formatted as a CUE data file. The result is
+// validated against the #GoTypeManifest definition in
+// [GoTypeManifestSchema].
+func (m *GoTypeManifest) CUE() ([]byte, error) {
+	return encodeCUE(m, GoTypeManifestSchema, "gomanifest", "GoTypeManifest")
+}
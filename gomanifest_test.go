@@ -0,0 +1,148 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var parseGoTypeRefTests = []struct {
+	s       string
+	want    GoTypeRef
+	wantErr string
+}{{
+	s:    "example.com/shapes.Circle",
+	want: GoTypeRef{ImportPath: "example.com/shapes", Name: "Circle"},
+}, {
+	s:    "example.com/v1.2/shapes.Circle",
+	want: GoTypeRef{ImportPath: "example.com/v1.2/shapes", Name: "Circle"},
+}, {
+	s:    "time.Time",
+	want: GoTypeRef{ImportPath: "time", Name: "Time"},
+}, {
+	s:    "string",
+	want: GoTypeRef{Name: "string"},
+}, {
+	s:       "example.com/shapes",
+	wantErr: `invalid Go type "example.com/shapes"`,
+}, {
+	s:       "shapes.Circle.Radius",
+	wantErr: `invalid Go type "shapes.Circle.Radius"`,
+}, {
+	s:       "",
+	wantErr: `invalid Go type ""`,
+}}
+
+func TestParseGoTypeRef(t *testing.T) {
+	for _, test := range parseGoTypeRefTests {
+		t.Run(test.s, func(t *testing.T) {
+			got, err := ParseGoTypeRef(test.s)
+			if test.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, test.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Check(t, qt.Equals(got, test.want))
+			qt.Check(t, qt.Equals(got.String(), test.s))
+		})
+	}
+}
+
+func TestNewGoTypeManifest(t *testing.T) {
+	v := cuecontext.New().CompileString(`
+#Circle: {type!: "circle", r!: number}
+#Square: {type!: "square", side!: number}
+#Shape: #Circle | #Square | {type!: "blob"}
+`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	m, err := NewGoTypeManifest([]GoTypeUnion{{
+		Name:   "#Shape",
+		Result: DiscriminateValue(v.LookupPath(cue.ParsePath("#Shape"))),
+		Types: map[string]string{
+			"#Circle":  "example.com/shapes.Circle",
+			`"square"`: "example.com/shapes.Square",
+			"blob":     "example.com/blob.Blob",
+		},
+	}})
+	qt.Assert(t, qt.IsNil(err))
+	data, err := m.CUE()
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(string(data), strings.TrimPrefix(`
+unions: [{
+	union: "#Shape"
+	arms: [{
+		arm:  0
+		name: "#Circle"
+		tags: [{
+			path:  "type"
+			value: "\"circle\""
+		}]
+		type: {
+			importPath: "example.com/shapes"
+			name:       "Circle"
+		}
+	}, {
+		arm:  1
+		name: "#Square"
+		tags: [{
+			path:  "type"
+			value: "\"square\""
+		}]
+		type: {
+			importPath: "example.com/shapes"
+			name:       "Square"
+		}
+	}, {
+		arm: 2
+		tags: [{
+			path:  "type"
+			value: "\"blob\""
+		}]
+		type: {
+			importPath: "example.com/blob"
+			name:       "Blob"
+		}
+	}]
+}]
+`, "\n")))
+}
+
+func TestNewGoTypeManifestErrors(t *testing.T) {
+	v := cuecontext.New().CompileString(`
+#Circle: {type!: "circle", r!: number}
+#Square: {type!: "square", side!: number}
+#Shape: #Circle | #Square | {type!: "blob"}
+#Loose: {a!: int} | {a!: int, b?: string}
+`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	_, err := NewGoTypeManifest([]GoTypeUnion{{
+		Name:   "#Shape",
+		Result: DiscriminateValue(v.LookupPath(cue.ParsePath("#Shape"))),
+		Types: map[string]string{
+			"#Circle":   "example.com/shapes.Circle",
+			"circle":    "example.com/shapes.Round",
+			"#Triangle": "example.com/shapes.Triangle",
+			"blob":      "example.com/blob",
+		},
+	}, {
+		Name:   "#Loose",
+		Result: DiscriminateValue(v.LookupPath(cue.ParsePath("#Loose"))),
+		Types: map[string]string{
+			"arm 0": "int",
+		},
+	}})
+	qt.Assert(t, qt.ErrorMatches(err, strings.Join([]string{
+		`#Shape: #Triangle does not name an arm or select a single arm`,
+		`#Shape: blob: invalid Go type "example.com/blob"`,
+		`#Shape: #Circle is given both type example.com/shapes.Circle and type example.com/shapes.Round`,
+		`#Shape: #Square has no Go type`,
+		`#Shape: arm 2 has no Go type`,
+		`#Loose: arms are not all told apart, so a decoder cannot choose a single type`,
+		`#Loose: arm 0 does not name an arm or select a single arm`,
+		`#Loose: arm 0 has no Go type`,
+		`#Loose: arm 1 has no Go type`,
+	}, "\n")))
+}
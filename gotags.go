@@ -0,0 +1,255 @@
+package cuediscrim
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+)
+
+// GoTagUnion describes a union whose tag values
+// are declared by [GenerateGoTags].
+type GoTagUnion struct {
+	// Name holds the CUE path of the union, such as #Shape.
+	// The names of its declarations start with an identifier
+	// derived from the final element of the path.
+	Name string
+
+	// Tree holds the decision tree for the union.
+	Tree DecisionNode
+
+	// Names holds the name of each arm of the union, as returned
+	// by [ArmNames], or nil if they are not known. They are used
+	// to document the constants for values that select a single arm.
+	Names []string
+}
+
+// GenerateGoTags returns Go source code declaring the tag values of
+// each of the given unions, so that code referring to them need not
+// use literals that can drift from the schema. For each field that
+// the decision tree for a union switches on by value, it declares a
+// type named after the union and the field, and a constant for each
+// value, as in:
+//
+//	// ShapeType holds a value of the type field of #Shape.
+//	type ShapeType string
+//
+//	const (
+//		// ShapeTypeCircle selects #Circle.
+//		ShapeTypeCircle ShapeType = "circle"
+//		ShapeTypeSquare ShapeType = "square"
+//	)
+//
+// The type is string when all the values are strings, and int when
+// all the values are integers; other fields are omitted. Names that
+// would otherwise clash are made unique with a numeric suffix.
+//
+// Of the options, only [GoPackage] and [GoGeneratedBy] have any effect.
+func GenerateGoTags(unions []GoTagUnion, opts ...GoOption) ([]byte, error) {
+	o := goOptions{
+		pkg:     "discrim",
+		command: "cuediscrim",
+	}
+	for _, f := range opts {
+		f(&o)
+	}
+	if !isGoIdent(o.pkg) {
+		return nil, fmt.Errorf("invalid Go identifier in package %q", o.pkg)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by %s. DO NOT EDIT.\n\n", o.command)
+	fmt.Fprintf(&buf, "package %s\n", o.pkg)
+	used := make(map[string]bool)
+	unique := func(name string) string {
+		name1 := name
+		for i := 2; used[name1]; i++ {
+			name1 = fmt.Sprintf("%s%d", name, i)
+		}
+		used[name1] = true
+		return name1
+	}
+	ctx := cuecontext.New()
+	for _, u := range unions {
+		prefix := identForPath(u.Name)
+		if prefix == "" {
+			return nil, fmt.Errorf("cannot derive Go identifier from union name %q", u.Name)
+		}
+		for _, f := range goTagValues(u.Tree) {
+			goType, lits := goTagLiterals(ctx, f.values)
+			if goType == "" {
+				continue
+			}
+			typeName := unique(prefix + goPathIdent(f.path))
+			fmt.Fprintf(&buf, "\n// %s holds a value of the %s field of %s.\n", typeName, f.path, u.Name)
+			fmt.Fprintf(&buf, "type %s %s\n\n", typeName, goType)
+			fmt.Fprintf(&buf, "const (\n")
+			for i, a := range f.values {
+				s, err := strconv.Unquote(lits[i])
+				if err != nil {
+					// An integer.
+					s = lits[i]
+				}
+				name := camelName(s)
+				if name == "" {
+					name = fmt.Sprintf("Value%d", i)
+				}
+				name = unique(typeName + name)
+				if arms := f.arms[a]; arms != nil && arms.Len() == 1 {
+					if arm := sortedInts(arms)[0]; arm < len(u.Names) && u.Names[arm] != "" {
+						fmt.Fprintf(&buf, "// %s selects %s.\n", name, u.Names[arm])
+					}
+				}
+				fmt.Fprintf(&buf, "%s %s = %s\n", name, typeName, lits[i])
+			}
+			fmt.Fprintf(&buf, ")\n")
+		}
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("cannot format generated code: %v", err)
+	}
+	return src, nil
+}
+
+// goTagValueSet holds the values that a decision
+// tree switches on for the field at path.
+type goTagValueSet struct {
+	path   string
+	values []Atom
+	// arms holds the arms chosen by the leaf that a value leads
+	// to directly, if any.
+	arms map[Atom]IntSet
+}
+
+// goTagValues returns the fields that n switches on by value,
+// in path order.
+func goTagValues(n DecisionNode) []goTagValueSet {
+	fields := make(map[string]*goTagValueSet)
+	var keys nodeKeys
+	var walk func(n DecisionNode)
+	walk = func(n DecisionNode) {
+		switch n := n.(type) {
+		case *KindSwitchNode:
+			for _, k := range slices.Sorted(maps.Keys(n.Branches)) {
+				walk(n.Branches[k])
+			}
+		case *ValueSwitchNode:
+			f := fields[n.Path]
+			if f == nil {
+				f = &goTagValueSet{
+					path: n.Path,
+					arms: make(map[Atom]IntSet),
+				}
+				fields[n.Path] = f
+			}
+			for _, g := range n.foldBranches(&keys) {
+				for _, a := range g.values {
+					if _, ok := f.arms[a]; ok {
+						continue
+					}
+					f.values = append(f.values, a)
+					f.arms[a] = nil
+					if leaf, ok := g.node.(*LeafNode); ok {
+						f.arms[a] = leaf.Arms
+					}
+				}
+				walk(g.node)
+			}
+			if n.Default != nil {
+				walk(n.Default)
+			}
+		case *RangeSwitchNode:
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+			if n.Default != nil {
+				walk(n.Default)
+			}
+		case *LenSwitchNode:
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+			if n.Default != nil {
+				walk(n.Default)
+			}
+		case *RegexSwitchNode:
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+			if n.Default != nil {
+				walk(n.Default)
+			}
+		case *FieldPresenceNode:
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+			if n.Default != nil {
+				walk(n.Default)
+			}
+		case *TupleSwitchNode:
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+		case *OptionalNode:
+			walk(n.Present)
+		case *GroupNode:
+			walk(n.Select)
+		}
+	}
+	walk(n)
+	var result []goTagValueSet
+	for _, path := range slices.Sorted(maps.Keys(fields)) {
+		f := fields[path]
+		slices.SortFunc(f.values, Atom.compare)
+		result = append(result, *f)
+	}
+	return result
+}
+
+// goTagLiterals returns the Go type for the given values and a Go
+// literal for each of them, or the empty string if they are
+// not all strings or all integers.
+func goTagLiterals(ctx *cue.Context, values []Atom) (string, []string) {
+	var goType string
+	lits := make([]string, len(values))
+	for i, a := range values {
+		v := ctx.CompileString(a.String())
+		var t string
+		switch v.Kind() {
+		case cue.StringKind:
+			s, _ := v.String()
+			t, lits[i] = "string", strconv.Quote(s)
+		case cue.IntKind:
+			n, err := v.Int64()
+			if err != nil {
+				return "", nil
+			}
+			t, lits[i] = "int", strconv.FormatInt(n, 10)
+		default:
+			return "", nil
+		}
+		if goType != "" && t != goType {
+			return "", nil
+		}
+		goType = t
+	}
+	return goType, lits
+}
+
+// goPathIdent returns an identifier made from
+// the field names in the given path.
+func goPathIdent(path string) string {
+	var buf strings.Builder
+	for _, name := range splitPath(path) {
+		if !isIndex(name) {
+			buf.WriteString(camelName(labelName(name)))
+		}
+	}
+	return buf.String()
+}
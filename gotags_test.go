@@ -0,0 +1,65 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestGenerateGoTags(t *testing.T) {
+	v := cuecontext.New().CompileString(`
+#Circle: {type!: "circle", r!: number}
+#Square: {type!: "square", side!: number}
+#Shape: #Circle | #Square | {type!: "other-shape" | ""}
+#Versioned: {"meta.data"!: {version!: 1}} | {"meta.data"!: {version!: 2}}
+#Flag: {on!: true} | {on!: false}
+`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	var unions []GoTagUnion
+	for _, name := range []string{"#Shape", "#Versioned", "#Flag"} {
+		r := DiscriminateValue(v.LookupPath(cue.ParsePath(name)))
+		unions = append(unions, GoTagUnion{
+			Name:  name,
+			Tree:  r.Tree,
+			Names: r.Names,
+		})
+	}
+	src, err := GenerateGoTags(unions, GoPackage("shapes"), GoGeneratedBy("test"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(string(src), strings.TrimPrefix(`
+// Code generated by test. DO NOT EDIT.
+
+package shapes
+
+// ShapeType holds a value of the type field of #Shape.
+type ShapeType string
+
+const (
+	ShapeTypeValue0 ShapeType = ""
+	// ShapeTypeCircle selects #Circle.
+	ShapeTypeCircle     ShapeType = "circle"
+	ShapeTypeOtherShape ShapeType = "other-shape"
+	// ShapeTypeSquare selects #Square.
+	ShapeTypeSquare ShapeType = "square"
+)
+
+// VersionedMetaDataVersion holds a value of the "meta.data".version field of #Versioned.
+type VersionedMetaDataVersion int
+
+const (
+	VersionedMetaDataVersion1 VersionedMetaDataVersion = 1
+	VersionedMetaDataVersion2 VersionedMetaDataVersion = 2
+)
+`, "\n")))
+}
+
+func TestGenerateGoTagsInvalidName(t *testing.T) {
+	_, err := GenerateGoTags([]GoTagUnion{{
+		Name: "a | b",
+		Tree: &LeafNode{},
+	}})
+	qt.Check(t, qt.ErrorMatches(err, `cannot derive Go identifier from union name "a \| b"`))
+}
@@ -0,0 +1,307 @@
+package cuediscrim
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/token"
+)
+
+// GoAnalysis holds the result of [AnalyzeGoVariants].
+type GoAnalysis struct {
+	// Result holds the analysis of the CUE representation
+	// of the variants, with one arm for each variant in order.
+	*Result
+
+	// TagField holds the name of the top level field that the Go
+	// JSON encoding of the variants uses to tell them apart: a field
+	// present in the encoding of every variant with a different
+	// string value for each, which is the same for every value of
+	// the variant's type. It is empty if there is no such field.
+	TagField string
+
+	// TagValues holds the value of TagField for each variant.
+	TagValues []string
+
+	// Agrees reports whether the decision tree for the CUE
+	// representation switches on TagField first, so that CUE
+	// and Go tell the variants apart in the same way.
+	Agrees bool
+}
+
+// AnalyzeGoVariants analyzes the Go sum type formed by the given
+// variants, typically the implementations of an interface, as if
+// their JSON encodings were the arms of a CUE disjunction. Each
+// element of variants holds an example value of a variant, such as
+// its zero value.
+//
+// The CUE representation of a variant follows the rules of
+// encoding/json: fields are required unless marked omitempty,
+// and fields marked "-" are omitted. The fields of a variant with
+// a custom MarshalJSON method are taken from the encoding of its
+// example with [json.Marshal], as its type does not describe them;
+// a field that holds the same string, number or bool in the encoding
+// of the zero value of the type is taken to be constant.
+//
+// The tag field is found by encoding each example and the zero value
+// of its type, so a tag added by a custom MarshalJSON method is found,
+// but a tag that is merely filled in by the example is not. The
+// representation is made without reference to the tag field, so that
+// Agrees reports whether CUE tells the variants apart by it too.
+func AnalyzeGoVariants(ctx *cue.Context, variants []any, opts ...Option) (*GoAnalysis, error) {
+	encoded := make([]map[string]any, len(variants))
+	zeros := make([]map[string]any, len(variants))
+	for i, x := range variants {
+		var err error
+		if encoded[i], err = encodeObject(x); err != nil {
+			return nil, err
+		}
+		if zeros[i], err = encodeObject(zeroOf(x)); err != nil {
+			return nil, err
+		}
+	}
+	a := &GoAnalysis{}
+	a.TagField, a.TagValues = goTagField(encoded, zeros)
+	arms := make([]cue.Value, len(variants))
+	names := make([]string, len(variants))
+	for i, x := range variants {
+		t := reflect.TypeOf(x)
+		var expr ast.Expr
+		if t.Implements(marshalerType) {
+			expr = exprForJSON(encoded[i], zeros[i])
+		} else {
+			// The example itself is not nil, so
+			// its encoding can't be null.
+			for t.Kind() == reflect.Pointer {
+				t = t.Elem()
+			}
+			expr = exprForGoType(t, make(map[reflect.Type]bool))
+		}
+		// Format the syntax so that identifiers such
+		// as int are resolved when it is compiled.
+		src, err := format.Node(expr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot format CUE for variant %T: %v", x, err)
+		}
+		arms[i] = ctx.CompileBytes(src)
+		if err := arms[i].Err(); err != nil {
+			return nil, fmt.Errorf("cannot make CUE for variant %T: %v", x, err)
+		}
+		names[i] = goTypeName(t)
+	}
+	a.Result = Analyze(arms, opts...)
+	a.Names = names
+	if a.TagField != "" {
+		tree := a.Tree
+		if n, ok := tree.(*OptionalNode); ok {
+			tree = n.Present
+		}
+		n, ok := tree.(*ValueSwitchNode)
+		a.Agrees = ok && n.Path == selectorName(cue.Str(a.TagField))
+	}
+	return a, nil
+}
+
+var marshalerType = reflect.TypeFor[json.Marshaler]()
+
+// encodeObject returns the JSON encoding of x as an object,
+// or nil if x doesn't encode as an object.
+func encodeObject(x any) (map[string]any, error) {
+	data, err := json.Marshal(x)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode variant %T: %v", x, err)
+	}
+	var obj map[string]any
+	json.Unmarshal(data, &obj)
+	return obj, nil
+}
+
+// zeroOf returns the zero value of the type of x or, when x is
+// a pointer, a pointer to the zero value of the type it points to.
+func zeroOf(x any) any {
+	t := reflect.TypeOf(x)
+	if t.Kind() == reflect.Pointer {
+		return reflect.New(t.Elem()).Interface()
+	}
+	return reflect.Zero(t).Interface()
+}
+
+// goTagField returns the name of the first field, in sorted order,
+// that holds a different string in each of the encoded values, the
+// same string as in the corresponding encoded zero value, and those
+// strings.
+func goTagField(encoded, zeros []map[string]any) (string, []string) {
+	if len(encoded) == 0 || encoded[0] == nil {
+		return "", nil
+	}
+outer:
+	for _, name := range slices.Sorted(maps.Keys(encoded[0])) {
+		values := make([]string, len(encoded))
+		for i, obj := range encoded {
+			s, ok := obj[name].(string)
+			if !ok || slices.Contains(values[:i], s) || zeros[i][name] != s {
+				continue outer
+			}
+			values[i] = s
+		}
+		return name, values
+	}
+	return "", nil
+}
+
+// exprForGoType returns the CUE type of values of type t
+// as encoded by encoding/json. The types in inProgress are
+// being converted, so they are treated as top to avoid
+// infinite recursion.
+func exprForGoType(t reflect.Type, inProgress map[reflect.Type]bool) ast.Expr {
+	if t.Implements(marshalerType) || inProgress[t] {
+		return ast.NewIdent("_")
+	}
+	inProgress[t] = true
+	defer delete(inProgress, t)
+	switch t.Kind() {
+	case reflect.Bool:
+		return ast.NewIdent("bool")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return ast.NewIdent("int")
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return ast.NewBinExpr(token.AND, ast.NewIdent("int"), &ast.UnaryExpr{
+			Op: token.GEQ,
+			X:  ast.NewLit(token.INT, "0"),
+		})
+	case reflect.Float32, reflect.Float64:
+		return ast.NewIdent("number")
+	case reflect.String:
+		return ast.NewIdent("string")
+	case reflect.Pointer:
+		return nullOr(exprForGoType(t.Elem(), inProgress))
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Encoded as base64.
+			return nullOr(ast.NewIdent("string"))
+		}
+		return nullOr(ast.NewList(&ast.Ellipsis{
+			Type: exprForGoType(t.Elem(), inProgress),
+		}))
+	case reflect.Array:
+		return ast.NewList(&ast.Ellipsis{
+			Type: exprForGoType(t.Elem(), inProgress),
+		})
+	case reflect.Map:
+		return nullOr(ast.NewStruct(&ast.Field{
+			Label: ast.NewList(ast.NewIdent("string")),
+			Value: exprForGoType(t.Elem(), inProgress),
+		}))
+	case reflect.Struct:
+		lit := &ast.StructLit{}
+		addGoFields(lit, t, inProgress)
+		return lit
+	}
+	return ast.NewIdent("_")
+}
+
+// addGoFields adds the fields of the struct type t to lit,
+// following the rules of encoding/json.
+func addGoFields(lit *ast.StructLit, t reflect.Type, inProgress map[reflect.Type]bool) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && !ft.Implements(marshalerType) {
+				// The fields of an embedded struct are promoted.
+				addGoFields(lit, ft, inProgress)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		field := &ast.Field{
+			Label:      ast.NewString(name),
+			Constraint: token.NOT,
+			Value:      exprForGoType(f.Type, inProgress),
+		}
+		if slices.Contains(strings.Split(opts, ","), "omitempty") {
+			field.Constraint = token.OPTION
+		}
+		lit.Elts = append(lit.Elts, field)
+	}
+}
+
+func nullOr(x ast.Expr) ast.Expr {
+	return ast.NewBinExpr(token.OR, ast.NewNull(), x)
+}
+
+// exprForJSON returns a CUE struct requiring the fields in obj,
+// or top if obj is nil. A field that holds the same string, number
+// or bool in zero is constrained to that value; any other has the
+// kind of its value in obj.
+func exprForJSON(obj, zero map[string]any) ast.Expr {
+	if obj == nil {
+		return ast.NewIdent("_")
+	}
+	lit := &ast.StructLit{}
+	for _, name := range slices.Sorted(maps.Keys(obj)) {
+		var value ast.Expr
+		switch x := obj[name].(type) {
+		case nil:
+			value = ast.NewNull()
+		case bool:
+			value = ast.NewIdent("bool")
+			if x == zero[name] {
+				value = ast.NewBool(x)
+			}
+		case float64:
+			value = ast.NewIdent("number")
+			if x == zero[name] {
+				value = ast.NewLit(token.FLOAT, strconv.FormatFloat(x, 'g', -1, 64))
+			}
+		case string:
+			value = ast.NewIdent("string")
+			if x == zero[name] {
+				value = ast.NewString(x)
+			}
+		case []any:
+			value = ast.NewList(&ast.Ellipsis{})
+		default:
+			value = ast.NewStruct(&ast.Ellipsis{})
+		}
+		lit.Elts = append(lit.Elts, &ast.Field{
+			Label:      ast.NewString(name),
+			Constraint: token.NOT,
+			Value:      value,
+		})
+	}
+	return lit
+}
+
+// goTypeName returns the name of t without any
+// package qualifier or pointer indirection.
+func goTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Name() != "" {
+		return t.Name()
+	}
+	return t.String()
+}
@@ -0,0 +1,126 @@
+package cuediscrim
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+type testCircle struct {
+	Radius float64 `json:"radius"`
+	Label  string  `json:"label,omitempty"`
+}
+
+func (c testCircle) MarshalJSON() ([]byte, error) {
+	type plain testCircle
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		plain
+	}{"circle", plain(c)})
+}
+
+type testRect struct {
+	W, H   float64
+	Hidden int `json:"-"`
+}
+
+func (r testRect) MarshalJSON() ([]byte, error) {
+	type plain testRect
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		plain
+	}{"rect", plain(r)})
+}
+
+type testPoint struct {
+	Type testPointTag `json:"type"`
+	X, Y *int
+}
+
+// testPointTag encodes as the tag of a testPoint,
+// whatever its value.
+type testPointTag struct{}
+
+func (testPointTag) MarshalJSON() ([]byte, error) {
+	return []byte(`"point"`), nil
+}
+
+func TestAnalyzeGoVariants(t *testing.T) {
+	a, err := AnalyzeGoVariants(cuecontext.New(), []any{
+		testCircle{},
+		&testRect{W: 1},
+	})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(a.TagField, "type"))
+	qt.Check(t, qt.DeepEquals(a.TagValues, []string{"circle", "rect"}))
+	qt.Check(t, qt.DeepEquals(a.Names, []string{"testCircle", "testRect"}))
+	qt.Check(t, qt.IsTrue(a.Perfect))
+	qt.Check(t, qt.IsTrue(a.Agrees))
+	qt.Check(t, qt.Equals(NodeString(a.Tree), strings.TrimPrefix(`
+switch type {
+case "circle":
+	choose({0})
+case "rect":
+	choose({1})
+default:
+	error
+}
+`, "\n")))
+}
+
+func TestAnalyzeGoVariantsDisagree(t *testing.T) {
+	// The tag of a testPoint is constant but its type doesn't
+	// say what it is, so CUE can't tell the variants apart by it.
+	a, err := AnalyzeGoVariants(cuecontext.New(), []any{
+		testCircle{},
+		testPoint{},
+	})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(a.TagField, "type"))
+	qt.Check(t, qt.DeepEquals(a.TagValues, []string{"circle", "point"}))
+	qt.Check(t, qt.IsFalse(a.Agrees))
+}
+
+func TestAnalyzeGoVariantsExampleTag(t *testing.T) {
+	type a struct {
+		Type string `json:"type"`
+		A    int
+	}
+	type b struct {
+		Type string `json:"type"`
+		B    int
+	}
+	// The tags are filled in by the examples rather than
+	// being determined by the types, so they don't count.
+	r, err := AnalyzeGoVariants(cuecontext.New(), []any{
+		a{Type: "a"},
+		b{Type: "b"},
+	})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(r.TagField, ""))
+	qt.Check(t, qt.IsFalse(r.Agrees))
+	qt.Check(t, qt.IsFalse(r.Perfect))
+}
+
+func TestAnalyzeGoVariantsNoTag(t *testing.T) {
+	type a struct {
+		A string
+	}
+	type b struct {
+		B []int
+	}
+	type c struct {
+		A int `json:"A,omitempty"`
+	}
+	r, err := AnalyzeGoVariants(cuecontext.New(), []any{a{}, b{}, c{}})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(r.TagField, ""))
+	qt.Check(t, qt.IsFalse(r.Agrees))
+	// Without a tag, the CUE representation can only be told
+	// apart by absence of fields, so the Go and CUE
+	// representations cannot be relied on to agree.
+	qt.Check(t, qt.IsFalse(r.Perfect))
+}
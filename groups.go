@@ -0,0 +1,518 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"iter"
+	"maps"
+
+	"cuelang.org/go/cue"
+)
+
+// PreserveGroups causes [DiscriminateValue] to preserve the
+// hierarchy formed by nested matchN calls, such as a matchN of
+// request kinds inside a matchN of message classes, rather than
+// flattening all the arms into a single decision. Each nested
+// group that the tree selects as a whole is represented by a
+// [GroupNode], so the tree mirrors the taxonomy of the schema.
+//
+// The arm indexes in the tree are still those returned by
+// [Disjunctions]. [Discriminate] and [Analyze] are given the arms
+// already flattened, so this option has no effect on them.
+func PreserveGroups(enable bool) Option {
+	return func(opts *options) {
+		opts.preserveGroups = enable
+	}
+}
+
+// GroupNode represents a group of arms that the schema
+// defines together, such as the arms of a nested matchN call,
+// when [PreserveGroups] is in effect. A decision tree reaches a
+// GroupNode once it has decided that the value belongs to the
+// group; Select then decides between the members of the group.
+type GroupNode struct {
+	// Name holds the path of the definition that the group
+	// was referred to by, such as #Request, or the empty
+	// string if it was written inline.
+	Name string
+
+	// Select chooses between the arms in the group.
+	Select DecisionNode
+}
+
+func (n *GroupNode) Possible() IntSet {
+	return n.Select.Possible()
+}
+
+func (n *GroupNode) Check(v cue.Value) IntSet {
+	s, _ := n.check(v, checkOptions{})
+	return s
+}
+
+func (n *GroupNode) check(v cue.Value, opts checkOptions) (IntSet, bool) {
+	return n.Select.check(v, opts)
+}
+
+func (n *GroupNode) write(w *indentWriter) {
+	if n.Name != "" {
+		w.Printf("group %s {", n.Name)
+	} else {
+		w.Printf("group {")
+	}
+	w.Indent()
+	w.node(n.Select)
+	w.Unindent()
+	w.Printf("}")
+}
+
+// armGroup describes a group of arms found by [armGroups].
+// The arms of a group are always contiguous.
+type armGroup struct {
+	name string
+
+	// start and end hold the range of arm
+	// indexes in the group.
+	start, end int
+
+	// sub holds the nested groups, in order.
+	sub []*armGroup
+}
+
+// armGroups returns the arms of v as returned by [Disjunctions],
+// and the hierarchy formed by any matchN calls within it.
+func armGroups(v cue.Value) ([]cue.Value, *armGroup) {
+	var arms []cue.Value
+	root := &armGroup{}
+	stack := []*armGroup{root}
+	walkDisjunctions(v, func(arm cue.Value) bool {
+		arms = append(arms, arm)
+		return true
+	}, func(v cue.Value, enter bool) {
+		if enter {
+			g := &armGroup{
+				start: len(arms),
+			}
+			if ref, p := v.ReferencePath(); ref.Exists() {
+				g.name = p.String()
+			}
+			stack = append(stack, g)
+			return
+		}
+		g := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		g.end = len(arms)
+		if g.end-g.start > 1 {
+			// A group of a single arm tells us nothing.
+			parent := stack[len(stack)-1]
+			parent.sub = append(parent.sub, g)
+		}
+	})
+	root.end = len(arms)
+	if len(root.sub) == 1 && root.sub[0].start == 0 && root.sub[0].end == root.end {
+		// v is itself a matchN call.
+		root = root.sub[0]
+	}
+	return arms, root
+}
+
+// groupTree returns a decision tree for the arms in g,
+// where the arms of nested groups are chosen as a unit
+// when possible.
+func groupTree(arms []cue.Value, g *armGroup, opts []Option) DecisionNode {
+	// Optional applies to the value as a whole, not to each group.
+	opts = append(opts[:len(opts):len(opts)], Optional(false))
+	var members []groupMember
+	subNodes := make([]DecisionNode, len(g.sub))
+	addArms := func(start, end int) {
+		for i := start; i < end; i++ {
+			leaf := &LeafNode{
+				Arms: mapSet[int]{i: true},
+			}
+			if IsDeprecated(arms[i]) {
+				leaf.Deprecated = leaf.Arms
+			}
+			members = append(members, groupMember{
+				start: i,
+				end:   i + 1,
+				node:  leaf,
+			})
+		}
+	}
+	next := g.start
+	for i, sub := range g.sub {
+		addArms(next, sub.start)
+		subNodes[i] = &GroupNode{
+			Name:   sub.name,
+			Select: groupTree(arms, sub, opts),
+		}
+		members = append(members, groupMember{
+			start: sub.start,
+			end:   sub.end,
+			node:  subNodes[i],
+		})
+		next = sub.end
+	}
+	addArms(next, g.end)
+	var o options
+	for _, f := range opts {
+		f(&o)
+	}
+	if n := memberSwitch(arms[g.start:g.end], g.start, members, o.maxValueBranches, o.fieldLimits()); n != nil {
+		return n
+	}
+	// No single field tells the members apart, so fall back
+	// to the usual analysis and keep any parts of it
+	// that choose within a single group.
+	tree, _, _ := Discriminate(arms[g.start:g.end], opts...)
+	tree = shiftArms(tree, g.start)
+	return collapseGroups(tree, g.sub, subNodes)
+}
+
+// groupMember holds a member of a group of arms: either
+// a single arm or a nested group, with the node
+// that is chosen for it.
+type groupMember struct {
+	start, end int
+	node       DecisionNode
+}
+
+// memberSwitch returns a node that chooses between the given
+// members by switching on the kind or value of a single required
+// field common to all the arms, or nil if there is no such field.
+// The arms are those of the members, starting at arm index
+// offset. The value itself is tried first, then the
+// shallowest such field is used. Value switches with more
+// than maxValues branches are not considered when maxValues is
+// greater than zero; see [MaxValueBranches]. Only the fields
+// allowed by limits are considered.
+func memberSwitch(arms []cue.Value, offset int, members []groupMember, maxValues int, limits fieldLimits) DecisionNode {
+	if n := memberSwitchAt(".", arms, offset, members, maxValues); n != nil {
+		return n
+	}
+	for path, values := range allFieldsWithin(arms, intSetN(len(arms)), requiredLabel, limits) {
+		if n := memberSwitchAt(path, values, offset, members, maxValues); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// memberSwitchAt is like memberSwitch but only considers
+// the given path, where values holds the value of each arm
+// at the path.
+func memberSwitchAt(path string, values []cue.Value, offset int, members []groupMember, maxValues int) DecisionNode {
+	sets := make([]valueSet, len(members))
+	common := true
+	for mi, m := range members {
+		for i := m.start; i < m.end && common; i++ {
+			v := values[i-offset]
+			if !v.Exists() {
+				common = false
+				break
+			}
+			sets[mi] = sets[mi].union(valueSetForValue(v))
+		}
+	}
+	if !common {
+		return nil
+	}
+	byKind := make(map[cue.Kind][]int)
+	for mi, vs := range sets {
+		for _, k := range allKinds {
+			if vs.kinds()&k != 0 {
+				byKind[k] = append(byKind[k], mi)
+			}
+		}
+	}
+	if len(byKind) > 0 && covers(maps.Values(byKind), len(members)) {
+		if n, ok := memberKindSwitch(path, byKind, members); ok {
+			return n
+		}
+	}
+	byValue := make(map[Atom][]int)
+	byType := make(map[cue.Kind][]int)
+	for mi, vs := range sets {
+		for c := range vs.consts {
+			byValue[c] = nil
+		}
+		for _, k := range allKinds {
+			if vs.types&k != 0 {
+				byType[k] = append(byType[k], mi)
+			}
+		}
+	}
+	if len(byValue) == 0 || (maxValues > 0 && len(byValue) > maxValues) {
+		return nil
+	}
+	for c := range byValue {
+		for mi, vs := range sets {
+			if vs.holdsAtom(c) {
+				byValue[c] = append(byValue[c], mi)
+			}
+		}
+	}
+	if !distinctMembers(maps.Values(byValue)) || !covers(iterConcat(maps.Values(byValue), maps.Values(byType)), len(members)) {
+		return nil
+	}
+	dflt, ok := memberKindSwitch(path, byType, members)
+	if !ok {
+		return nil
+	}
+	n := &ValueSwitchNode{
+		Path:     path,
+		Branches: make(map[Atom]DecisionNode, len(byValue)),
+		Default:  dflt,
+	}
+	for c, group := range byValue {
+		n.Branches[c] = members[group[0]].node
+	}
+	return n
+}
+
+// memberKindSwitch returns a switch on the kind at path that chooses
+// the members in byKind, and reports whether each kind chooses
+// at most one member. When byKind is empty, it returns an ErrorNode.
+func memberKindSwitch(path string, byKind map[cue.Kind][]int, members []groupMember) (DecisionNode, bool) {
+	if !distinctMembers(maps.Values(byKind)) {
+		return nil, false
+	}
+	if len(byKind) == 0 {
+		return ErrorNode{}, true
+	}
+	n := &KindSwitchNode{
+		Path:     path,
+		Branches: make(map[cue.Kind]DecisionNode, len(byKind)),
+	}
+	for k, group := range byKind {
+		n.Branches[k] = members[group[0]].node
+	}
+	return n, true
+}
+
+// covers reports whether each of the n members
+// is in at least one of the groups.
+func covers(groups iter.Seq[[]int], n int) bool {
+	found := make([]bool, n)
+	count := 0
+	for g := range groups {
+		for _, mi := range g {
+			if !found[mi] {
+				found[mi] = true
+				count++
+			}
+		}
+	}
+	return count == n
+}
+
+// distinctMembers reports whether each of the
+// groups holds exactly one member.
+func distinctMembers(groups iter.Seq[[]int]) bool {
+	for g := range groups {
+		if len(g) != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// collapseGroups replaces each subtree of n that
+// can only choose arms in one of groups by
+// the corresponding member of nodes.
+func collapseGroups(n DecisionNode, groups []*armGroup, nodes []DecisionNode) DecisionNode {
+	if i := containingGroup(n.Possible(), groups); i >= 0 {
+		return nodes[i]
+	}
+	switch n := n.(type) {
+	case *KindSwitchNode:
+		for k, sub := range n.Branches {
+			n.Branches[k] = collapseGroups(sub, groups, nodes)
+		}
+	case *ValueSwitchNode:
+		for val, sub := range n.Branches {
+			n.Branches[val] = collapseGroups(sub, groups, nodes)
+		}
+		if n.Default != nil {
+			n.Default = collapseGroups(n.Default, groups, nodes)
+		}
+	case *RangeSwitchNode:
+		for i, b := range n.Branches {
+			n.Branches[i].Node = collapseGroups(b.Node, groups, nodes)
+		}
+		if n.Default != nil {
+			n.Default = collapseGroups(n.Default, groups, nodes)
+		}
+	case *LenSwitchNode:
+		for i, b := range n.Branches {
+			n.Branches[i].Node = collapseGroups(b.Node, groups, nodes)
+		}
+		if n.Default != nil {
+			n.Default = collapseGroups(n.Default, groups, nodes)
+		}
+	case *RegexSwitchNode:
+		for i, b := range n.Branches {
+			n.Branches[i].Node = collapseGroups(b.Node, groups, nodes)
+		}
+		if n.Default != nil {
+			n.Default = collapseGroups(n.Default, groups, nodes)
+		}
+	case *FieldPresenceNode:
+		for i, b := range n.Branches {
+			n.Branches[i].Node = collapseGroups(b.Node, groups, nodes)
+		}
+		if n.Default != nil {
+			n.Default = collapseGroups(n.Default, groups, nodes)
+		}
+	case *TupleSwitchNode:
+		for i, b := range n.Branches {
+			n.Branches[i].Node = collapseGroups(b.Node, groups, nodes)
+		}
+	case *OptionalNode:
+		n.Present = collapseGroups(n.Present, groups, nodes)
+	}
+	return n
+}
+
+// containingGroup returns the index of the group in groups that
+// holds all of the arms in s, or -1 if there is none.
+func containingGroup(s IntSet, groups []*armGroup) int {
+	if s.Len() == 0 {
+		return -1
+	}
+	for i, g := range groups {
+		all := true
+		for arm := range s.Values() {
+			if arm < g.start || arm >= g.end {
+				all = false
+				break
+			}
+		}
+		if all {
+			return i
+		}
+	}
+	return -1
+}
+
+// shiftArms returns n with offset added to every arm index.
+func shiftArms(n DecisionNode, offset int) DecisionNode {
+	if offset == 0 {
+		return n
+	}
+	shift := func(s IntSet) IntSet {
+		if s == nil {
+			return nil
+		}
+		m := make(mapSet[int])
+		for x := range s.Values() {
+			m[x+offset] = true
+		}
+		return m
+	}
+	shiftList := func(xs []int) []int {
+		if xs == nil {
+			return nil
+		}
+		xs1 := make([]int, len(xs))
+		for i, x := range xs {
+			xs1[i] = x + offset
+		}
+		return xs1
+	}
+	switch n := n.(type) {
+	case nil:
+		return nil
+	case *LeafNode:
+		return &LeafNode{
+			Arms:       shift(n.Arms),
+			Deprecated: shift(n.Deprecated),
+			Precedence: shiftList(n.Precedence),
+		}
+	case *KindSwitchNode:
+		n1 := *n
+		n1.Branches = make(map[cue.Kind]DecisionNode, len(n.Branches))
+		for k, sub := range n.Branches {
+			n1.Branches[k] = shiftArms(sub, offset)
+		}
+		return &n1
+	case *ValueSwitchNode:
+		n1 := *n
+		n1.Branches = make(map[Atom]DecisionNode, len(n.Branches))
+		for val, sub := range n.Branches {
+			n1.Branches[val] = shiftArms(sub, offset)
+		}
+		n1.Default = shiftArms(n.Default, offset)
+		return &n1
+	case *RangeSwitchNode:
+		n1 := *n
+		n1.Branches = make([]RangeBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			n1.Branches[i] = RangeBranch{
+				Interval: b.Interval,
+				Node:     shiftArms(b.Node, offset),
+			}
+		}
+		n1.Default = shiftArms(n.Default, offset)
+		return &n1
+	case *LenSwitchNode:
+		n1 := *n
+		n1.Branches = make([]RangeBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			n1.Branches[i] = RangeBranch{
+				Interval: b.Interval,
+				Node:     shiftArms(b.Node, offset),
+			}
+		}
+		n1.Default = shiftArms(n.Default, offset)
+		return &n1
+	case *RegexSwitchNode:
+		n1 := *n
+		n1.Branches = make([]RegexBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			n1.Branches[i] = RegexBranch{
+				Pattern: b.Pattern,
+				Node:    shiftArms(b.Node, offset),
+			}
+		}
+		n1.Default = shiftArms(n.Default, offset)
+		return &n1
+	case *FieldPresenceNode:
+		n1 := *n
+		n1.Branches = make([]PresenceBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			n1.Branches[i] = PresenceBranch{
+				Path: b.Path,
+				Node: shiftArms(b.Node, offset),
+			}
+		}
+		n1.Default = shiftArms(n.Default, offset)
+		return &n1
+	case *TupleSwitchNode:
+		n1 := *n
+		n1.Branches = make([]TupleBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			n1.Branches[i] = TupleBranch{
+				Values: b.Values,
+				Node:   shiftArms(b.Node, offset),
+			}
+		}
+		return &n1
+	case *FieldAbsenceNode:
+		branches := make(map[string]IntSet, len(n.Branches))
+		for path, s := range n.Branches {
+			branches[path] = shift(s)
+		}
+		return newFieldAbsenceNode(branches)
+	case *OptionalNode:
+		return &OptionalNode{
+			Present: shiftArms(n.Present, offset),
+		}
+	case *GroupNode:
+		return &GroupNode{
+			Name:   n.Name,
+			Select: shiftArms(n.Select, offset),
+		}
+	case ErrorNode, *ErrorNode:
+		return n
+	}
+	panic(fmt.Errorf("unexpected node type %T", n))
+}
@@ -0,0 +1,150 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var preserveGroupsTests = []struct {
+	testName string
+	cue      string
+	want     string
+	data     []dataTest
+}{{
+	testName: "MessageClasses",
+	cue: `
+#Get: {class!: "request", kind!: "get", key!: string}
+#Put: {class!: "request", kind!: "put", key!: string, value!: _}
+#Request: matchN(1, [#Get, #Put])
+#Ok: {class!: "response", kind!: "ok"}
+#Err: {class!: "response", kind!: "err", msg!: string}
+#Response: matchN(1, [#Ok, #Err])
+x: matchN(1, [#Request, #Response, {class!: "ping"}])
+`,
+	want: `
+switch class {
+case "ping":
+	choose({4})
+case "request":
+	group #Request {
+		switch kind {
+		case "get":
+			choose({0})
+		case "put":
+			choose({1})
+		default:
+			error
+		}
+	}
+case "response":
+	group #Response {
+		switch kind {
+		case "err":
+			choose({3})
+		case "ok":
+			choose({2})
+		default:
+			error
+		}
+	}
+default:
+	error
+}
+`,
+	data: []dataTest{{
+		name: "put",
+		cue:  `{class: "request", kind: "put", key: "k", value: 1}`,
+		want: setOf(1),
+	}, {
+		name: "err",
+		cue:  `{class: "response", kind: "err", msg: "m"}`,
+		want: setOf(3),
+	}, {
+		name: "ping",
+		cue:  `{class: "ping"}`,
+		want: setOf(4),
+	}},
+}, {
+	testName: "InlineGroup",
+	cue:      `x: "a" | "b" | matchN(1, [{t!: "p"}, {t!: "q"}])`,
+	want: `
+switch . {
+case "a":
+	choose({0})
+case "b":
+	choose({1})
+default:
+	switch kind(.) {
+	case struct:
+		group {
+			switch t {
+			case "p":
+				choose({2})
+			case "q":
+				choose({3})
+			default:
+				error
+			}
+		}
+	}
+}
+`,
+}, {
+	testName: "NoGroups",
+	cue:      `x: {t!: "a"} | {t!: "b"}`,
+	want: `
+switch t {
+case "a":
+	choose({0})
+case "b":
+	choose({1})
+default:
+	error
+}
+`,
+}}
+
+func TestPreserveGroups(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range preserveGroupsTests {
+		t.Run(test.testName, func(t *testing.T) {
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			x := val.LookupPath(cue.ParsePath("x"))
+			r := DiscriminateValue(x, PreserveGroups(true))
+			qt.Assert(t, qt.Equals(NodeString(r.Tree), strings.TrimPrefix(test.want, "\n")))
+			qt.Check(t, qt.HasLen(r.Arms, len(Disjunctions(x))))
+
+			// The tree survives a round trip through the encoding.
+			data, err := EncodeTree(r.Tree)
+			qt.Assert(t, qt.IsNil(err))
+			tree1, err := DecodeTree(data)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.Equals(NodeString(tree1), NodeString(r.Tree)))
+
+			for _, dtest := range test.data {
+				data := ctx.CompileString(dtest.cue)
+				qt.Check(t, deepEquals(ref(mapSetOf(r.Tree.Check(data).Values())), ref(mapSetOf(dtest.want.Values()))), qt.Commentf("data %s", dtest.name))
+			}
+		})
+	}
+}
+
+func TestPreserveGroupsPerfect(t *testing.T) {
+	ctx := cuecontext.New()
+	val := ctx.CompileString(preserveGroupsTests[0].cue)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	x := val.LookupPath(cue.ParsePath("x"))
+
+	// Flattened, no single field tells all the arms apart.
+	r := DiscriminateValue(x)
+	qt.Check(t, qt.IsFalse(r.Perfect))
+
+	r = DiscriminateValue(x, PreserveGroups(true))
+	qt.Check(t, qt.IsTrue(r.Perfect))
+	qt.Check(t, qt.IsTrue(Prove(r.Tree).Perfect()))
+}
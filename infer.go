@@ -0,0 +1,172 @@
+package cuediscrim
+
+import (
+	"cmp"
+	"iter"
+	"maps"
+	"slices"
+
+	"cuelang.org/go/cue"
+)
+
+// Example holds an example document for use with [InferDiscriminators].
+type Example struct {
+	// Value holds the example document.
+	Value cue.Value
+
+	// Arm holds the index of the arm that the example
+	// is known to be an instance of, or -1 if that's not known.
+	Arm int
+}
+
+// FieldEvidence describes how the field at a particular path
+// behaves as a discriminator across a corpus of examples.
+type FieldEvidence struct {
+	// Path holds the path of the field.
+	Path string
+
+	// Values maps each concrete value found at Path
+	// to the set of arms of the examples it was found in.
+	Values map[Atom]IntSet
+
+	// Missing holds the set of arms with at least one
+	// example that did not have a concrete value at Path.
+	Missing IntSet
+
+	// Discriminates reports whether the field discriminates
+	// between all the arms found in the examples: every
+	// labeled example has a value for the field, and each
+	// value is associated with exactly one arm.
+	Discriminates bool
+
+	// InSchema reports whether the schema-derived decision tree
+	// uses the field to make a decision.
+	InSchema bool
+}
+
+// LabelExamples returns an example for each of docs, labeled with
+// the arm that the document validates against, or -1 if it
+// validates against none of arms or more than one.
+func LabelExamples(arms []cue.Value, docs []cue.Value) []Example {
+	examples := make([]Example, len(docs))
+	for i, doc := range docs {
+		examples[i] = Example{
+			Value: doc,
+			Arm:   -1,
+		}
+		valid := validArms(arms, doc)
+		if len(valid) != 1 {
+			continue
+		}
+		for arm := range valid {
+			examples[i].Arm = arm
+		}
+	}
+	return examples
+}
+
+// InferDiscriminators looks at the concrete atom-valued fields in
+// the given examples and reports on how well each one discriminates
+// between the arms that the examples belong to.
+//
+// Examples without a label (with Arm < 0) are labeled by
+// using tree to classify them; those that the tree cannot assign to
+// exactly one arm are ignored.
+//
+// The results are sorted so that fields that discriminate come
+// first, and then by path. A field with Discriminates set but not
+// InSchema is one that discriminates in practice but isn't used
+// as a discriminator by the schema, which is worth investigating.
+func InferDiscriminators(tree DecisionNode, examples []Example) []*FieldEvidence {
+	schemaPaths := TreePaths(tree)
+	byPath := make(map[string]*FieldEvidence)
+	labeled := make(mapSet[int])
+	var labeledExamples []Example
+	for _, ex := range examples {
+		if ex.Arm < 0 {
+			if tree == nil {
+				continue
+			}
+			arms := tree.Check(ex.Value)
+			if arms.Len() != 1 {
+				continue
+			}
+			for arm := range arms.Values() {
+				ex.Arm = arm
+			}
+		}
+		labeled[ex.Arm] = true
+		labeledExamples = append(labeledExamples, ex)
+		for path, v := range atomFields(ex.Value, ".") {
+			ev := byPath[path]
+			if ev == nil {
+				ev = &FieldEvidence{
+					Path:     path,
+					Values:   make(map[Atom]IntSet),
+					Missing:  make(mapSet[int]),
+					InSchema: schemaPaths.Has(path),
+				}
+				byPath[path] = ev
+			}
+			a := atomForValue(v)
+			s, _ := ev.Values[a].(mapSet[int])
+			if s == nil {
+				s = make(mapSet[int])
+				ev.Values[a] = s
+			}
+			s[ex.Arm] = true
+		}
+	}
+	// Now we know all the paths, find out which examples are missing them.
+	for _, ex := range labeledExamples {
+		for path, ev := range byPath {
+			if !isAtomValue(lookupPath(ex.Value, path)) {
+				ev.Missing.(mapSet[int])[ex.Arm] = true
+			}
+		}
+	}
+	evs := slices.Collect(maps.Values(byPath))
+	for _, ev := range evs {
+		ev.Discriminates = labeled.Len() > 1 && ev.Missing.Len() == 0
+		for _, arms := range ev.Values {
+			if arms.Len() != 1 {
+				ev.Discriminates = false
+				break
+			}
+		}
+	}
+	slices.SortFunc(evs, func(ev0, ev1 *FieldEvidence) int {
+		if ev0.Discriminates != ev1.Discriminates {
+			if ev0.Discriminates {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(ev0.Path, ev1.Path)
+	})
+	return evs
+}
+
+// atomFields returns an iterator over all the fields in v
+// (including v itself) that hold concrete atoms.
+func atomFields(v cue.Value, path string) iter.Seq2[string, cue.Value] {
+	return func(yield func(string, cue.Value) bool) {
+		yieldAtomFields(v, path, yield)
+	}
+}
+
+func yieldAtomFields(v cue.Value, path string, yield func(string, cue.Value) bool) bool {
+	if isAtomValue(v) {
+		return yield(path, v)
+	}
+	for label, fv := range structFields(v, regularLabel|requiredLabel) {
+		if !yieldAtomFields(fv, pathConcat(path, label.name), yield) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAtomValue(v cue.Value) bool {
+	return v.Exists() && atomForValue(v).isValid()
+}
@@ -0,0 +1,63 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestInferDiscriminators(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`{a!: int, kind?: string} | {b!: string, kind?: string}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v))
+
+	examples := []Example{{
+		Value: ctx.CompileString(`{kind: "a", a: 1, n: 1}`),
+		Arm:   0,
+	}, {
+		Value: ctx.CompileString(`{kind: "b", b: "x", n: 1}`),
+		Arm:   1,
+	}, {
+		// Unlabeled, but the tree can classify it.
+		Value: ctx.CompileString(`{kind: "a", a: 2, n: 2}`),
+		Arm:   -1,
+	}, {
+		// Unlabeled and ambiguous: ignored.
+		Value: ctx.CompileString(`{kind: "c", a: 2, b: "x"}`),
+		Arm:   -1,
+	}}
+	var buf strings.Builder
+	for _, ev := range InferDiscriminators(tree, examples) {
+		fmt.Fprintf(&buf, "%s discriminates=%v inSchema=%v missing=%v\n", ev.Path, ev.Discriminates, ev.InSchema, SetString(ev.Missing))
+	}
+	qt.Assert(t, qt.Equals(buf.String(), `
+kind discriminates=true inSchema=false missing={}
+a discriminates=false inSchema=true missing={1}
+b discriminates=false inSchema=true missing={0}
+n discriminates=false inSchema=false missing={}
+`[1:]))
+}
+
+func TestLabelExamples(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`{a!: int, kind?: string} | {b!: string, kind?: string}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	docs := []cue.Value{
+		ctx.CompileString(`{kind: "a", a: 1}`),
+		ctx.CompileString(`{kind: "b", b: "x"}`),
+		// Valid against both arms.
+		ctx.CompileString(`{a: 1, b: "x"}`),
+		// Valid against neither arm.
+		ctx.CompileString(`{kind: "c"}`),
+	}
+	var arms []int
+	for _, ex := range LabelExamples(Disjunctions(v), docs) {
+		arms = append(arms, ex.Arm)
+	}
+	qt.Assert(t, qt.DeepEquals(arms, []int{0, 1, -1, -1}))
+}
@@ -0,0 +1,646 @@
+package cuediscrim
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	cuejson "cuelang.org/go/encoding/json"
+	"cuelang.org/go/encoding/jsonschema"
+	"cuelang.org/go/encoding/yaml"
+)
+
+// jsonSchemaDialect holds the JSON Schema version that
+// [ExportJSONSchema] produces.
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// maxJSONSchemaDepth bounds the nesting of the schemas produced
+// for arms, so that recursive definitions produce a finite
+// schema. Values nested more deeply are unconstrained.
+const maxJSONSchemaDepth = 32
+
+// ExportJSONSchema returns a JSON Schema that accepts the same JSON
+// values as the disjunction of arms when n is used to choose between
+// them. The schema for each arm is held in $defs, and the decisions
+// in n are expressed with if/then/else, so that a validator checks a
+// value against only the arms that n chooses for it:
+//
+//	"if": {"type": "object", "required": ["type"], "properties": {"type": {"const": "a"}}},
+//	"then": {"$ref": "#/$defs/arm0"},
+//	"else": ...
+//
+// A [FieldAbsenceNode] becomes an anyOf with an entry for each arm,
+// requiring the fields whose absence would rule the arm out.
+//
+// The schemas for the arms only capture the constraints that JSON
+// Schema can express directly: field and list types, required and
+// closed fields, bounds, regular expressions and constant values.
+// Other constraints, such as calls to validator functions, are
+// omitted, so the schema may accept values that the arms do not.
+// Also, JSON has no distinct integer type, so a float with an
+// integral value such as 1.0 is treated as an int.
+func ExportJSONSchema(n DecisionNode, arms []cue.Value) ([]byte, error) {
+	return exportJSONSchema(n, arms, nil)
+}
+
+// exportJSONSchema is like [ExportJSONSchema] except that the arm
+// schemas in $defs are named after names, as returned by [ArmNames],
+// where possible.
+func exportJSONSchema(n DecisionNode, arms []cue.Value, names []string) ([]byte, error) {
+	g := &jsonSchemaGen{
+		defs: make([]string, len(arms)),
+	}
+	used := make(map[string]bool)
+	defs := make(map[string]any)
+	for arm, v := range arms {
+		name := fmt.Sprintf("arm%d", arm)
+		if arm < len(names) {
+			if ident := identForPath(names[arm]); ident != "" {
+				name = ident
+				for i := 2; used[name]; i++ {
+					name = fmt.Sprintf("%s%d", ident, i)
+				}
+			}
+		}
+		used[name] = true
+		g.defs[arm] = name
+		defs[name] = g.value(v, 0)
+	}
+	schema := map[string]any{}
+	switch s := g.node(n).(type) {
+	case map[string]any:
+		schema = s
+	case bool:
+		if !s {
+			schema["not"] = map[string]any{}
+		}
+	}
+	schema["$schema"] = jsonSchemaDialect
+	if len(defs) > 0 {
+		schema["$defs"] = defs
+	}
+	data, err := json.MarshalIndent(schema, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+type jsonSchemaGen struct {
+	// defs holds the name in $defs of each arm.
+	defs []string
+
+	// keys is used to compare the branches of value switches.
+	keys nodeKeys
+}
+
+// node returns a schema that accepts the values accepted
+// by any of the arms that n chooses for them.
+func (g *jsonSchemaGen) node(n DecisionNode) any {
+	switch n := n.(type) {
+	case *LeafNode:
+		return g.choose(n.Arms)
+	case *KindSwitchNode:
+		kinds := slices.Sorted(maps.Keys(n.Branches))
+		var branches []jsonSchemaBranch
+		for _, k := range kinds {
+			branches = append(branches, jsonSchemaBranch{
+				cond: jsonSchemaAtPath(n.Path, jsonSchemaKind(k)),
+				node: n.Branches[k],
+			})
+		}
+		return g.ifChain(branches, nil)
+	case *ValueSwitchNode:
+		var branches []jsonSchemaBranch
+		for _, group := range n.foldBranches(&g.keys) {
+			var consts []any
+			for _, a := range group.values {
+				if c, ok := jsonSchemaConst(a); ok {
+					consts = append(consts, c)
+				}
+			}
+			var cond map[string]any
+			switch len(consts) {
+			case 0:
+				// No JSON value can hold any of the values.
+				continue
+			case 1:
+				cond = map[string]any{"const": consts[0]}
+			default:
+				cond = map[string]any{"enum": consts}
+			}
+			branches = append(branches, jsonSchemaBranch{
+				cond: jsonSchemaAtPath(n.Path, cond),
+				node: group.node,
+			})
+		}
+		return g.ifChain(branches, n.Default)
+	case *RangeSwitchNode:
+		var branches []jsonSchemaBranch
+		for _, b := range n.Branches {
+			branches = append(branches, jsonSchemaBranch{
+				cond: jsonSchemaAtPath(n.Path, jsonSchemaInterval(b.Interval)),
+				node: b.Node,
+			})
+		}
+		return g.ifChain(branches, n.Default)
+	case *LenSwitchNode:
+		var branches []jsonSchemaBranch
+		for _, b := range n.Branches {
+			branches = append(branches, jsonSchemaBranch{
+				cond: jsonSchemaAtPath(n.Path, jsonSchemaItems(b.Interval)),
+				node: b.Node,
+			})
+		}
+		return g.ifChain(branches, n.Default)
+	case *RegexSwitchNode:
+		// The if/then/else chain tests the patterns
+		// in order, as the switch does.
+		var branches []jsonSchemaBranch
+		for _, b := range n.Branches {
+			branches = append(branches, jsonSchemaBranch{
+				cond: jsonSchemaAtPath(n.Path, map[string]any{
+					"type":    "string",
+					"pattern": b.Pattern,
+				}),
+				node: b.Node,
+			})
+		}
+		return g.ifChain(branches, n.Default)
+	case *FieldPresenceNode:
+		var branches []jsonSchemaBranch
+		for _, b := range n.Branches {
+			branches = append(branches, jsonSchemaBranch{
+				cond: jsonSchemaAtPath(b.Path, true),
+				node: b.Node,
+			})
+		}
+		return g.ifChain(branches, n.Default)
+	case *TupleSwitchNode:
+		var branches []jsonSchemaBranch
+	tuples:
+		for _, b := range n.Branches {
+			var allOf []any
+			for i, path := range n.Paths {
+				c, ok := jsonSchemaConst(b.Values[i])
+				if !ok {
+					// No JSON value can hold the value.
+					continue tuples
+				}
+				allOf = append(allOf, jsonSchemaAtPath(path, map[string]any{"const": c}))
+			}
+			branches = append(branches, jsonSchemaBranch{
+				cond: map[string]any{"allOf": allOf},
+				node: b.Node,
+			})
+		}
+		return g.ifChain(branches, nil)
+	case *FieldAbsenceNode:
+		// An arm is chosen when every path whose absence
+		// would rule it out is present.
+		paths := slices.Sorted(maps.Keys(n.Branches))
+		var anyOf []any
+		for _, arm := range sortedInts(n.Possible()) {
+			var allOf []any
+			for _, path := range paths {
+				if !n.Branches[path].Has(arm) {
+					allOf = append(allOf, jsonSchemaAtPath(path, true))
+				}
+			}
+			if len(allOf) == 0 {
+				anyOf = append(anyOf, g.ref(arm))
+				continue
+			}
+			anyOf = append(anyOf, map[string]any{
+				"allOf": append(allOf, g.ref(arm)),
+			})
+		}
+		return jsonSchemaAnyOf(anyOf)
+	case *OptionalNode:
+		return g.node(n.Present)
+	case *GroupNode:
+		return g.node(n.Select)
+	}
+	return false
+}
+
+// jsonSchemaInterval returns a schema that accepts
+// the numbers in iv.
+func jsonSchemaInterval(iv Interval) map[string]any {
+	s := map[string]any{
+		"type": "number",
+	}
+	if iv.Min.Value != "" {
+		key := "exclusiveMinimum"
+		if iv.Min.Inclusive {
+			key = "minimum"
+		}
+		s[key] = json.Number(iv.Min.Value)
+	}
+	if iv.Max.Value != "" {
+		key := "exclusiveMaximum"
+		if iv.Max.Inclusive {
+			key = "maximum"
+		}
+		s[key] = json.Number(iv.Max.Value)
+	}
+	return s
+}
+
+// jsonSchemaItems returns a schema for the lists whose
+// length is in the interval iv, as held by a [LenSwitchNode].
+func jsonSchemaItems(iv Interval) map[string]any {
+	s := map[string]any{
+		"type": "array",
+	}
+	if iv.Min.Value != "" {
+		s["minItems"] = json.Number(iv.Min.Value)
+	}
+	if iv.Max.Value != "" {
+		s["maxItems"] = json.Number(iv.Max.Value)
+	}
+	return s
+}
+
+// jsonSchemaBranch holds a branch of a switch: the
+// schema for its condition and the node it leads to.
+type jsonSchemaBranch struct {
+	cond any
+	node DecisionNode
+}
+
+// ifChain returns a chain of if/then/else schemas that tries
+// each branch in turn, falling back to dflt, which may be nil
+// to accept nothing.
+func (g *jsonSchemaGen) ifChain(branches []jsonSchemaBranch, dflt DecisionNode) any {
+	var s any = false
+	if dflt != nil {
+		s = g.node(dflt)
+	}
+	for _, b := range slices.Backward(branches) {
+		s = map[string]any{
+			"if":   b.cond,
+			"then": g.node(b.node),
+			"else": s,
+		}
+	}
+	return s
+}
+
+// choose returns a schema that accepts the values
+// accepted by any of the given arms.
+func (g *jsonSchemaGen) choose(arms IntSet) any {
+	var anyOf []any
+	for _, arm := range sortedInts(arms) {
+		anyOf = append(anyOf, g.ref(arm))
+	}
+	return jsonSchemaAnyOf(anyOf)
+}
+
+func (g *jsonSchemaGen) ref(arm int) any {
+	if arm < 0 || arm >= len(g.defs) {
+		// The tree doesn't match the arms; there's
+		// nothing to refer to.
+		return false
+	}
+	return map[string]any{
+		"$ref": "#/$defs/" + g.defs[arm],
+	}
+}
+
+// jsonSchemaAnyOf returns a schema that accepts
+// the values accepted by any of the schemas in xs.
+func jsonSchemaAnyOf(xs []any) any {
+	switch len(xs) {
+	case 0:
+		return false
+	case 1:
+		return xs[0]
+	}
+	return map[string]any{
+		"anyOf": xs,
+	}
+}
+
+// jsonSchemaAtPath returns a schema that requires the value at
+// the given path to exist and to be accepted by s.
+func jsonSchemaAtPath(path string, s any) any {
+	names := splitPath(path)
+	for _, name := range slices.Backward(names) {
+		if isIndex(name) {
+			i, _ := strconv.Atoi(name[1 : len(name)-1])
+			prefix := make([]any, i+1)
+			for j := range i {
+				prefix[j] = true
+			}
+			prefix[i] = s
+			s = map[string]any{
+				"type":        "array",
+				"minItems":    i + 1,
+				"prefixItems": prefix,
+			}
+			continue
+		}
+		if strings.HasPrefix(name, "#") || strings.HasPrefix(name, "_") {
+			// Definitions and hidden fields are
+			// never present in JSON.
+			return false
+		}
+		name = labelName(name)
+		s = map[string]any{
+			"type":     "object",
+			"required": []string{name},
+			"properties": map[string]any{
+				name: s,
+			},
+		}
+	}
+	return s
+}
+
+// jsonSchemaKind returns a schema that accepts
+// values of the given kind.
+func jsonSchemaKind(k cue.Kind) any {
+	switch k {
+	case cue.FloatKind:
+		// JSON Schema treats any number with an integral
+		// value as an integer.
+		return map[string]any{
+			"type": "number",
+			"not":  map[string]any{"type": "integer"},
+		}
+	case cue.BytesKind:
+		return false
+	}
+	types := jsonSchemaTypes(k)
+	if len(types) == 0 {
+		return false
+	}
+	return map[string]any{"type": types[0]}
+}
+
+// jsonSchemaTypes returns the JSON Schema types
+// for the values of the given kinds.
+func jsonSchemaTypes(k cue.Kind) []string {
+	var types []string
+	if k&cue.NullKind != 0 {
+		types = append(types, "null")
+	}
+	if k&cue.BoolKind != 0 {
+		types = append(types, "boolean")
+	}
+	switch k & cue.NumberKind {
+	case cue.IntKind:
+		types = append(types, "integer")
+	case cue.FloatKind, cue.NumberKind:
+		types = append(types, "number")
+	}
+	if k&(cue.StringKind|cue.BytesKind) != 0 {
+		// Bytes are encoded as base64 strings.
+		types = append(types, "string")
+	}
+	if k&cue.ListKind != 0 {
+		types = append(types, "array")
+	}
+	if k&cue.StructKind != 0 {
+		types = append(types, "object")
+	}
+	return types
+}
+
+// jsonSchemaConst returns the JSON value for a,
+// or false if no JSON value can equal a.
+func jsonSchemaConst(a Atom) (any, bool) {
+	v := cuecontext.New().CompileString(a.String())
+	if v.Kind() == cue.BytesKind {
+		return nil, false
+	}
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return nil, false
+	}
+	return json.RawMessage(data), true
+}
+
+// value returns a schema for the values that unify with v,
+// which is nested depth levels inside an arm.
+func (g *jsonSchemaGen) value(v cue.Value, depth int) any {
+	if v.Err() != nil {
+		return false
+	}
+	if depth > maxJSONSchemaDepth {
+		return true
+	}
+	if arms := Disjunctions(v); len(arms) > 1 {
+		anyOf := make([]any, len(arms))
+		for i, arm := range arms {
+			anyOf[i] = g.value(arm, depth+1)
+		}
+		return jsonSchemaAnyOf(anyOf)
+	}
+	if a := atomForValue(v); a.isValid() {
+		if c, ok := jsonSchemaConst(a); ok {
+			return map[string]any{"const": c}
+		}
+		return false
+	}
+	k := v.IncompleteKind()
+	switch k {
+	case cue.TopKind:
+		return true
+	case cue.StructKind:
+		return g.structValue(v, depth)
+	case cue.ListKind:
+		return g.listValue(v, depth)
+	}
+	types := jsonSchemaTypes(k)
+	s := make(map[string]any)
+	switch len(types) {
+	case 0:
+		return false
+	case 1:
+		s["type"] = types[0]
+	default:
+		s["type"] = types
+	}
+	addJSONSchemaConstraints(s, v)
+	return s
+}
+
+func (g *jsonSchemaGen) structValue(v cue.Value, depth int) any {
+	s := map[string]any{
+		"type": "object",
+	}
+	iter, err := v.Fields(cue.Optional(true))
+	if err != nil {
+		return false
+	}
+	props := make(map[string]any)
+	var required []string
+	for iter.Next() {
+		name := iter.Selector().Unquoted()
+		props[name] = g.value(iter.Value(), depth+1)
+		if iter.FieldType()&cue.OptionalConstraint == 0 {
+			required = append(required, name)
+		}
+	}
+	if len(props) > 0 {
+		s["properties"] = props
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	if !v.Allows(cue.AnyString) {
+		s["additionalProperties"] = false
+	} else if p := v.LookupPath(cue.MakePath(cue.AnyString)); p.Exists() {
+		s["additionalProperties"] = g.value(p, depth+1)
+	}
+	return s
+}
+
+func (g *jsonSchemaGen) listValue(v cue.Value, depth int) any {
+	s := map[string]any{
+		"type": "array",
+	}
+	iter, err := v.List()
+	if err != nil {
+		return false
+	}
+	var prefix []any
+	for iter.Next() {
+		prefix = append(prefix, g.value(iter.Value(), depth+1))
+	}
+	if len(prefix) > 0 {
+		s["prefixItems"] = prefix
+		s["minItems"] = len(prefix)
+	}
+	if elem := v.LookupPath(cue.MakePath(cue.AnyIndex)); elem.Exists() {
+		if elem := g.value(elem, depth+1); elem != true {
+			s["items"] = elem
+		}
+	} else {
+		s["items"] = false
+	}
+	return s
+}
+
+// addJSONSchemaConstraints adds to s the constraints on the
+// scalar value v that JSON Schema can express.
+func addJSONSchemaConstraints(s map[string]any, v cue.Value) {
+	op, args := v.Expr()
+	switch op {
+	case cue.AndOp:
+		for _, arg := range args {
+			addJSONSchemaConstraints(s, arg)
+		}
+		return
+	case cue.NoOp, cue.SelectorOp, cue.CallOp:
+		return
+	}
+	if len(args) != 1 {
+		return
+	}
+	arg := args[0]
+	var key string
+	var c any
+	switch op {
+	case cue.GreaterThanOp, cue.GreaterThanEqualOp, cue.LessThanOp, cue.LessThanEqualOp:
+		if arg.Kind()&cue.NumberKind == 0 {
+			return
+		}
+		data, err := arg.MarshalJSON()
+		if err != nil {
+			return
+		}
+		key = map[cue.Op]string{
+			cue.GreaterThanOp:      "exclusiveMinimum",
+			cue.GreaterThanEqualOp: "minimum",
+			cue.LessThanOp:         "exclusiveMaximum",
+			cue.LessThanEqualOp:    "maximum",
+		}[op]
+		c = json.RawMessage(data)
+	case cue.RegexMatchOp:
+		re, err := arg.String()
+		if err != nil {
+			return
+		}
+		key, c = "pattern", re
+	case cue.NotRegexMatchOp:
+		re, err := arg.String()
+		if err != nil {
+			return
+		}
+		key, c = "not", map[string]any{"pattern": re}
+	case cue.NotEqualOp:
+		data, err := arg.MarshalJSON()
+		if err != nil {
+			return
+		}
+		key, c = "not", map[string]any{"const": json.RawMessage(data)}
+	default:
+		return
+	}
+	if _, ok := s[key]; !ok {
+		s[key] = c
+		return
+	}
+	// The keyword is already in use, so add the
+	// constraint alongside it instead.
+	allOf, _ := s["allOf"].([]any)
+	s["allOf"] = append(allOf, map[string]any{key: c})
+}
+
+// ImportJSONSchema returns the JSON Schema in data converted to CUE
+// as the cue command's JSON Schema decoder would convert it, so that
+// the unions in it can be analyzed. The schema is read as YAML when
+// filename ends in .yaml or .yml and as JSON otherwise.
+//
+// The schemas in $defs and definitions become definitions such as
+// #foo, and each oneOf or anyOf becomes a call to matchN, which
+// [Disjunctions] treats as a disjunction. The root schema is the
+// returned value itself.
+func ImportJSONSchema(ctx *cue.Context, filename string, data []byte) (cue.Value, error) {
+	v, err := decodeDocument(ctx, filename, data)
+	if err != nil {
+		return cue.Value{}, err
+	}
+	f, err := jsonschema.Extract(v, &jsonschema.Config{})
+	if err != nil {
+		return cue.Value{}, fmt.Errorf("cannot convert JSON Schema: %v", err)
+	}
+	sv := ctx.BuildFile(f, cue.Filename(filename))
+	if err := sv.Err(); err != nil {
+		return cue.Value{}, fmt.Errorf("cannot build converted JSON Schema: %v", err)
+	}
+	return sv, nil
+}
+
+// decodeDocument decodes the document in data, which is YAML
+// when filename ends in .yaml or .yml and JSON otherwise.
+func decodeDocument(ctx *cue.Context, filename string, data []byte) (cue.Value, error) {
+	var v cue.Value
+	switch filepath.Ext(filename) {
+	case ".yaml", ".yml":
+		f, err := yaml.Extract(filename, data)
+		if err != nil {
+			return cue.Value{}, err
+		}
+		v = ctx.BuildFile(f)
+	default:
+		expr, err := cuejson.Extract(filename, data)
+		if err != nil {
+			return cue.Value{}, err
+		}
+		v = ctx.BuildExpr(expr)
+	}
+	if err := v.Err(); err != nil {
+		return cue.Value{}, err
+	}
+	return v, nil
+}
@@ -0,0 +1,437 @@
+package cuediscrim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var exportJSONSchemaTests = []struct {
+	testName string
+	cue      string
+	data     []string
+}{{
+	testName: "TaggedStructs",
+	cue: `
+#A: {type!: "a", x!: int & >0, l?: [...string]}
+#B: {type!: "b", y?: =~"^z"}
+x: #A | #B | null | [string, ...int]
+`,
+	data: []string{
+		`{"type": "a", "x": 1}`,
+		`{"type": "a", "x": 0}`,
+		`{"type": "a", "x": 1, "l": ["s"]}`,
+		`{"type": "a", "x": 1, "l": [1]}`,
+		`{"type": "b", "y": "zz"}`,
+		`{"type": "b", "y": "a"}`,
+		`{"type": "b", "x": 1}`,
+		`{"type": "c"}`,
+		`{}`,
+		`null`,
+		`["a", 1]`,
+		`[1]`,
+		`1`,
+	},
+}, {
+	testName: "NestedPath",
+	cue: `
+x: {"x.y"!: {k!: "p"}, v!: int} | {"x.y"!: {k!: "q"}, w!: string} | {"x.y"!: {k!: 1}}
+`,
+	data: []string{
+		`{"x.y": {"k": "p"}, "v": 1}`,
+		`{"x.y": {"k": "q"}, "w": "s"}`,
+		`{"x.y": {"k": "p"}, "v": "s"}`,
+		`{"x.y": {"k": 1}}`,
+		`{"x.y": {"k": "r"}}`,
+		`{"x": {"y": {"k": "p"}}, "v": 1}`,
+	},
+}, {
+	testName: "FieldAbsence",
+	cue: `
+x: {a!: int} | {b!: string} | {a!: string, c!: bool}
+`,
+	data: []string{
+		`{"a": 1}`,
+		`{"b": "s"}`,
+		`{"a": "s", "c": true}`,
+		`{"a": "s"}`,
+		`{"a": 1, "b": "s"}`,
+		`{"c": true}`,
+		`{}`,
+	},
+}, {
+	testName: "ListIndex",
+	cue: `
+x: ["a", int] | ["b", string] | [true]
+`,
+	data: []string{
+		`["a", 1]`,
+		`["b", "s"]`,
+		`["a", "s"]`,
+		`["a", 1, 2]`,
+		`[true]`,
+		`[]`,
+		`["c", 1]`,
+	},
+}}
+
+var errNoArm = errors.New("no arm accepts the data")
+
+func TestExportJSONSchema(t *testing.T) {
+	for _, test := range exportJSONSchemaTests {
+		t.Run(test.testName, func(t *testing.T) {
+			ctx := cuecontext.New()
+			v := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			x := v.LookupPath(cue.ParsePath("x"))
+			arms := Disjunctions(x)
+			tree, _, _ := Discriminate(arms)
+			data, err := ExportJSONSchema(tree, arms)
+			qt.Assert(t, qt.IsNil(err))
+
+			var schema any
+			qt.Assert(t, qt.IsNil(json.Unmarshal(data, &schema)))
+
+			// The schema accepts the data that some arm accepts.
+			for _, data := range test.data {
+				d := ctx.CompileString(data)
+				var want error = errNoArm
+				for _, arm := range arms {
+					if err := arm.Unify(d).Validate(cue.Concrete(true)); err == nil {
+						want = nil
+						break
+					}
+				}
+				var x any
+				qt.Assert(t, qt.IsNil(json.Unmarshal([]byte(data), &x)))
+				got := validateJSONSchema(schema, schema, x)
+				qt.Check(t, qt.Equals(got, want == nil), qt.Commentf("data %s; want %v", data, want))
+			}
+		})
+	}
+}
+
+func TestExportJSONSchemaOutput(t *testing.T) {
+	v := cuecontext.New().CompileString(`{type!: "a", n?: >=1} | {type!: "b"} | string`)
+	arms := Disjunctions(v)
+	tree, _, _ := Discriminate(arms)
+	data, err := ExportJSONSchema(tree, arms)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(string(data), `{
+	"$defs": {
+		"arm0": {
+			"properties": {
+				"n": {
+					"minimum": 1,
+					"type": "number"
+				},
+				"type": {
+					"const": "a"
+				}
+			},
+			"required": [
+				"type"
+			],
+			"type": "object"
+		},
+		"arm1": {
+			"properties": {
+				"type": {
+					"const": "b"
+				}
+			},
+			"required": [
+				"type"
+			],
+			"type": "object"
+		},
+		"arm2": {
+			"type": "string"
+		}
+	},
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"else": {
+		"else": false,
+		"if": {
+			"type": "object"
+		},
+		"then": {
+			"else": {
+				"else": false,
+				"if": {
+					"properties": {
+						"type": {
+							"const": "b"
+						}
+					},
+					"required": [
+						"type"
+					],
+					"type": "object"
+				},
+				"then": {
+					"$ref": "#/$defs/arm1"
+				}
+			},
+			"if": {
+				"properties": {
+					"type": {
+						"const": "a"
+					}
+				},
+				"required": [
+					"type"
+				],
+				"type": "object"
+			},
+			"then": {
+				"$ref": "#/$defs/arm0"
+			}
+		}
+	},
+	"if": {
+		"type": "string"
+	},
+	"then": {
+		"$ref": "#/$defs/arm2"
+	}
+}
+`))
+}
+
+// validateJSONSchema reports whether x, as decoded by [json.Unmarshal],
+// is valid according to the schema s, which is part of the
+// document root. It only understands the keywords that
+// [ExportJSONSchema] produces.
+func validateJSONSchema(root, s, x any) bool {
+	if b, ok := s.(bool); ok {
+		return b
+	}
+	m := s.(map[string]any)
+	for key, arg := range m {
+		if !validateJSONSchemaKeyword(root, m, key, arg, x) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateJSONSchemaKeyword(root any, m map[string]any, key string, arg, x any) bool {
+	obj, isObj := x.(map[string]any)
+	list, isList := x.([]any)
+	num, isNum := x.(float64)
+	str, isStr := x.(string)
+	switch key {
+	case "$schema", "$defs", "then", "else":
+		return true
+	case "$ref":
+		name, _ := strings.CutPrefix(arg.(string), "#/$defs/")
+		return validateJSONSchema(root, root.(map[string]any)["$defs"].(map[string]any)[name], x)
+	case "if":
+		if validateJSONSchema(root, arg, x) {
+			return m["then"] == nil || validateJSONSchema(root, m["then"], x)
+		}
+		return m["else"] == nil || validateJSONSchema(root, m["else"], x)
+	case "anyOf":
+		for _, s := range arg.([]any) {
+			if validateJSONSchema(root, s, x) {
+				return true
+			}
+		}
+		return false
+	case "allOf":
+		for _, s := range arg.([]any) {
+			if !validateJSONSchema(root, s, x) {
+				return false
+			}
+		}
+		return true
+	case "not":
+		return !validateJSONSchema(root, arg, x)
+	case "const":
+		return reflect.DeepEqual(arg, x)
+	case "enum":
+		for _, c := range arg.([]any) {
+			if reflect.DeepEqual(c, x) {
+				return true
+			}
+		}
+		return false
+	case "type":
+		types, ok := arg.([]any)
+		if !ok {
+			types = []any{arg}
+		}
+		for _, t := range types {
+			switch t {
+			case "null":
+				ok = x == nil
+			case "boolean":
+				_, ok = x.(bool)
+			case "integer":
+				ok = isNum && num == math.Trunc(num)
+			case "number":
+				ok = isNum
+			case "string":
+				ok = isStr
+			case "array":
+				ok = isList
+			case "object":
+				ok = isObj
+			}
+			if ok {
+				return true
+			}
+		}
+		return false
+	case "properties":
+		for name, s := range arg.(map[string]any) {
+			if v, ok := obj[name]; ok && !validateJSONSchema(root, s, v) {
+				return false
+			}
+		}
+		return true
+	case "required":
+		for _, name := range arg.([]any) {
+			if _, ok := obj[name.(string)]; isObj && !ok {
+				return false
+			}
+		}
+		return true
+	case "additionalProperties":
+		props, _ := m["properties"].(map[string]any)
+		for name, v := range obj {
+			if _, ok := props[name]; !ok && !validateJSONSchema(root, arg, v) {
+				return false
+			}
+		}
+		return true
+	case "prefixItems":
+		for i, s := range arg.([]any) {
+			if i < len(list) && !validateJSONSchema(root, s, list[i]) {
+				return false
+			}
+		}
+		return true
+	case "items":
+		prefix, _ := m["prefixItems"].([]any)
+		for i := len(prefix); i < len(list); i++ {
+			if !validateJSONSchema(root, arg, list[i]) {
+				return false
+			}
+		}
+		return true
+	case "minItems":
+		return !isList || float64(len(list)) >= arg.(float64)
+	case "minimum":
+		return !isNum || num >= arg.(float64)
+	case "exclusiveMinimum":
+		return !isNum || num > arg.(float64)
+	case "maximum":
+		return !isNum || num <= arg.(float64)
+	case "exclusiveMaximum":
+		return !isNum || num < arg.(float64)
+	case "pattern":
+		return !isStr || regexp.MustCompile(arg.(string)).MatchString(str)
+	}
+	panic(fmt.Sprintf("unknown JSON Schema keyword %q", key))
+}
+
+var importJSONSchemaTests = []struct {
+	testName string
+	filename string
+	data     string
+	path     string
+	want     string
+}{{
+	testName: "OneOf",
+	filename: "shapes.json",
+	data: `{
+	"$defs": {
+		"circle": {"type": "object", "properties": {"type": {"const": "circle"}, "r": {"type": "number"}}, "required": ["type", "r"]},
+		"square": {"type": "object", "properties": {"type": {"const": "square"}, "side": {"type": "number"}}, "required": ["type", "side"]},
+		"shape": {"oneOf": [{"$ref": "#/$defs/circle"}, {"$ref": "#/$defs/square"}]}
+	}
+}`,
+	path: "#shape",
+	want: `
+switch type {
+case "circle":
+	choose(#circle)
+case "square":
+	choose(#square)
+default:
+	error
+}
+`,
+}, {
+	testName: "AnyOfYAML",
+	filename: "thing.yaml",
+	data: `
+$defs:
+  thing:
+    anyOf:
+    - type: string
+    - type: object
+      properties:
+        a: {type: integer}
+      required: [a]
+`,
+	path: "#thing",
+	want: `
+switch kind(.) {
+case string:
+	choose({0})
+case struct:
+	choose({1})
+}
+`,
+}, {
+	testName: "Root",
+	filename: "root.json",
+	data: `{
+	"oneOf": [
+		{"type": "object", "properties": {"kind": {"enum": ["a", "b"]}}, "required": ["kind"]},
+		{"type": "object", "properties": {"kind": {"const": "c"}}, "required": ["kind"]}
+	]
+}`,
+	want: `
+switch kind {
+case in {"a", "b"}:
+	choose({0})
+case "c":
+	choose({1})
+default:
+	error
+}
+`,
+}}
+
+func TestImportJSONSchema(t *testing.T) {
+	for _, test := range importJSONSchemaTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v, err := ImportJSONSchema(cuecontext.New(), test.filename, []byte(test.data))
+			qt.Assert(t, qt.IsNil(err))
+			if test.path != "" {
+				v = v.LookupPath(cue.ParsePath(test.path))
+			}
+			r := DiscriminateValue(v)
+			qt.Check(t, qt.IsTrue(r.Perfect))
+			qt.Check(t, qt.Equals(NodeString(r.Tree, WriteArmNames(r.Names)), strings.TrimPrefix(test.want, "\n")))
+		})
+	}
+}
+
+func TestImportJSONSchemaError(t *testing.T) {
+	_, err := ImportJSONSchema(cuecontext.New(), "bad.json", []byte(`{"type": 1}`))
+	qt.Check(t, qt.ErrorMatches(err, `cannot convert JSON Schema: .*`))
+}
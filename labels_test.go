@@ -0,0 +1,150 @@
+package cuediscrim
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/literal"
+	"github.com/go-quicktest/qt"
+)
+
+// exoticLabels holds field names that aren't identifiers
+// in some or all of the languages that trees are exported to.
+var exoticLabels = []string{
+	"a.b",
+	"a-b",
+	"a b",
+	"a[0]",
+	`a"b`,
+	`a\b`,
+	"a/b",
+	"ünï",
+	"😀",
+	"\a",
+	"x\ny",
+	" ",
+	"\x7f",
+	"\U000E0001",
+	"\\(x)",
+	"123",
+	"if",
+}
+
+// labelExporters holds, for each exporter, a function that returns
+// the field names that the exported form of the tree refers to,
+// in the order they appear in it.
+var labelExporters = []struct {
+	name   string
+	labels func(t *testing.T, tree DecisionNode) []string
+}{{
+	name: "Go",
+	labels: func(t *testing.T, tree DecisionNode) []string {
+		src, err := GenerateGo(tree)
+		qt.Assert(t, qt.IsNil(err))
+		f, err := parser.ParseFile(token.NewFileSet(), "x.go", src, 0)
+		qt.Assert(t, qt.IsNil(err), qt.Commentf("%s", src))
+		var labels []string
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || !isIdent(call.Fun, "discriminateLookup") {
+				return true
+			}
+			for _, arg := range call.Args[1:] {
+				lit, ok := arg.(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					continue
+				}
+				s, err := strconv.Unquote(lit.Value)
+				qt.Assert(t, qt.IsNil(err))
+				labels = append(labels, s)
+			}
+			return true
+		})
+		return labels
+	},
+}, {
+	name: "TypeScript",
+	labels: func(t *testing.T, tree DecisionNode) []string {
+		src, err := GenerateTypeScript(tree, nil)
+		qt.Assert(t, qt.IsNil(err))
+		// A JSON string is also a JavaScript string literal, and
+		// none of the field names need the escapes that only
+		// JavaScript allows, so decoding as JSON rejects
+		// escapes such as Go's \a.
+		var labels []string
+		for _, m := range tsLookupPattern.FindAllStringSubmatch(string(src), -1) {
+			var s string
+			err := json.Unmarshal([]byte(m[1]), &s)
+			qt.Assert(t, qt.IsNil(err), qt.Commentf("literal %s", m[1]))
+			labels = append(labels, s)
+		}
+		return labels
+	},
+}, {
+	name: "JSONSchema",
+	labels: func(t *testing.T, tree DecisionNode) []string {
+		data, err := ExportJSONSchema(tree, nil)
+		qt.Assert(t, qt.IsNil(err))
+		var schema any
+		qt.Assert(t, qt.IsNil(json.Unmarshal(data, &schema)))
+		var labels []string
+		var find func(x any)
+		find = func(x any) {
+			switch x := x.(type) {
+			case map[string]any:
+				if req, ok := x["required"].([]any); ok {
+					for _, r := range req {
+						labels = append(labels, r.(string))
+					}
+				}
+				for _, k := range slices.Sorted(maps.Keys(x)) {
+					find(x[k])
+				}
+			case []any:
+				for _, e := range x {
+					find(e)
+				}
+			}
+		}
+		find(schema)
+		return labels
+	},
+}, {
+	name: "OpenAPI",
+	labels: func(t *testing.T, tree DecisionNode) []string {
+		d, err := FindOpenAPIDiscriminator(tree, []string{"#X", "#Y"})
+		qt.Assert(t, qt.IsNil(err))
+		return []string{d.PropertyName}
+	},
+}}
+
+var tsLookupPattern = regexp.MustCompile(`discriminateLookup\(x, ("(?:[^"\\]|\\.)*")`)
+
+func isIdent(e ast.Expr, name string) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == name
+}
+
+func TestExoticLabels(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, label := range exoticLabels {
+		quoted := literal.Label.Quote(label)
+		v := ctx.CompileString(strings.ReplaceAll(`{L!: "x"} | {L!: "y"}`, "L", quoted))
+		qt.Assert(t, qt.IsNil(v.Err()), qt.Commentf("label %q", label))
+		tree, _, _ := Discriminate(Disjunctions(v))
+		for _, exp := range labelExporters {
+			t.Run(exp.name+"/"+strconv.Quote(label), func(t *testing.T) {
+				qt.Check(t, qt.DeepEquals(slices.Compact(exp.labels(t, tree)), []string{label}))
+			})
+		}
+	}
+}
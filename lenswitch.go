@@ -0,0 +1,354 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"math/big"
+
+	"cuelang.org/go/cue"
+)
+
+// LenSwitchNode switches on the length of the list at a path,
+// choosing the branch whose interval holds the length. It is used
+// when arms are told apart by the number of elements that their
+// lists may have, as with [int] | [int, int] or
+// list.MaxItems(2) | list.MinItems(3), rather than by
+// the elements themselves.
+type LenSwitchNode struct {
+	Path string
+
+	// Branches holds a branch for each of a set of disjoint
+	// intervals of lengths, in ascending order. The bounds of
+	// each interval are inclusive integers. An interval with
+	// no lower bound starts at zero.
+	//
+	// When the lengths allowed by several arms overlap, the
+	// branch for the overlap leads to all of them unless
+	// something else tells them apart (see [Warnings]).
+	Branches []RangeBranch
+
+	// Default is used when the value is not a list
+	// or its length lies outside all the intervals.
+	// When some arms allow no list at all, it
+	// switches on the kind of the value to choose them.
+	Default DecisionNode
+}
+
+func (n *LenSwitchNode) Possible() IntSet {
+	var s IntSet = wordSet(0)
+	for _, b := range n.Branches {
+		s = union(s, b.Node.Possible())
+	}
+	if n.Default != nil {
+		s = union(s, n.Default.Possible())
+	}
+	return s
+}
+
+func (n *LenSwitchNode) Check(v cue.Value) IntSet {
+	s, _ := n.check(v, checkOptions{})
+	return s
+}
+
+func (n *LenSwitchNode) check(v cue.Value, opts checkOptions) (IntSet, bool) {
+	f := lookupPath(v, n.Path)
+	x := listLength(f)
+	if x == nil && opts.allowIncomplete && f.Exists() && f.IncompleteKind()&cue.ListKind != 0 {
+		// Any branch whose interval overlaps the lengths that
+		// f allows is possible, as is the default because f
+		// might not be a list at all.
+		var s IntSet = wordSet(0)
+		for _, b := range n.Branches {
+			for _, iv := range lengthIntervals(f) {
+				if !b.Interval.intersect(iv).isEmpty() {
+					s1, _ := b.Node.check(v, opts)
+					s = union(s, s1)
+					break
+				}
+			}
+		}
+		if n.Default != nil {
+			s1, _ := n.Default.check(v, opts)
+			s = union(s, s1)
+		}
+		return s, false
+	}
+	if x != nil {
+		for _, b := range n.Branches {
+			if b.Interval.Contains(x) {
+				return b.Node.check(v, opts)
+			}
+		}
+	}
+	if n.Default != nil {
+		return n.Default.check(v, opts)
+	}
+	return wordSet(0), true
+}
+
+func (n *LenSwitchNode) write(w *indentWriter) {
+	w.Printf("switch len(%v) {", w.switchPath(n.Path, false))
+	for _, b := range n.Branches {
+		w.Printf("case %v:", b.Interval)
+		w.Indent()
+		w.node(b.Node)
+		w.Unindent()
+	}
+	w.Printf("default:")
+	w.Indent()
+	w.node(n.Default)
+	w.Unindent()
+	w.Printf("}")
+}
+
+// isList reports whether v allows lists only. As well as lists
+// themselves, this includes a list constrained by a validator
+// that it can't yet satisfy, such as list.MinItems(3) & [...int],
+// which evaluates to an incomplete error rather than a list.
+func isList(v cue.Value) bool {
+	switch v.IncompleteKind() {
+	case cue.ListKind:
+		return true
+	case cue.BottomKind:
+		return v.Validate() == nil && len(itemsIntervals(v)) > 0
+	}
+	return false
+}
+
+// listLength returns the length of f if it is a list
+// with a known number of elements, or nil otherwise.
+func listLength(f cue.Value) *big.Rat {
+	if !f.Exists() || f.IncompleteKind() != cue.ListKind {
+		return nil
+	}
+	n, err := f.Len().Int64()
+	if err != nil {
+		return nil
+	}
+	return big.NewRat(n, 1)
+}
+
+// lengthIntervals returns intervals that between them hold all the
+// lengths allowed by the list v: those allowed by the number of
+// elements it declares, as with [int, ...int], and by any
+// list.MinItems and list.MaxItems constraints on it. It returns nil
+// if v allows no lists.
+func lengthIntervals(v cue.Value) []Interval {
+	if v.IncompleteKind()&cue.ListKind == 0 && !isList(v) {
+		return nil
+	}
+	ivs := []Interval{{
+		Min: Bound{Value: "0", Inclusive: true},
+	}}
+	if n := v.Len(); n.Err() == nil {
+		ivs = intersectIntervals(ivs, numberIntervals(n))
+	}
+	for _, iv := range itemsIntervals(v) {
+		ivs = intersectIntervals(ivs, []Interval{iv})
+	}
+	return ivs
+}
+
+// itemsIntervals returns an interval for each list.MinItems
+// or list.MaxItems constraint in the conjuncts of v.
+func itemsIntervals(v cue.Value) []Interval {
+	op, args := v.Expr()
+	switch op {
+	case cue.AndOp:
+		var ivs []Interval
+		for _, arg := range args {
+			ivs = append(ivs, itemsIntervals(arg)...)
+		}
+		return ivs
+	case cue.CallOp:
+		if len(args) != 2 {
+			break
+		}
+		n, err := args[1].Int64()
+		if err != nil {
+			break
+		}
+		b := Bound{
+			Value:     fmt.Sprint(n),
+			Inclusive: true,
+		}
+		switch fmt.Sprint(args[0]) {
+		case "list.MinItems":
+			return []Interval{{Min: b}}
+		case "list.MaxItems":
+			return []Interval{{Max: b}}
+		}
+	}
+	return nil
+}
+
+// intersectIntervals returns the non-empty intersections
+// of each interval in ivs0 with each interval in ivs1.
+func intersectIntervals(ivs0, ivs1 []Interval) []Interval {
+	var ivs []Interval
+	for _, iv0 := range ivs0 {
+		for _, iv1 := range ivs1 {
+			if iv := iv0.intersect(iv1); !iv.isEmpty() {
+				ivs = append(ivs, iv)
+			}
+		}
+	}
+	return ivs
+}
+
+// lengthRanges is like [numberRanges] except that the intervals
+// hold lengths: exclusive bounds are made inclusive, intervals
+// holding no whole number are dropped, and a lower bound of zero
+// is left out when there is an upper bound.
+func lengthRanges(ivs [][]Interval) ([]Interval, [][]int) {
+	ranges, sets := numberRanges(ivs)
+	var ranges1 []Interval
+	var sets1 [][]int
+	for i, iv := range ranges {
+		if r := iv.Min.rat(); r != nil {
+			if r.IsInt() && !iv.Min.Inclusive {
+				r.Add(r, big.NewRat(1, 1))
+			}
+			r = ceilRat(r)
+			iv.Min = Bound{Value: r.RatString(), Inclusive: true}
+		}
+		if r := iv.Max.rat(); r != nil {
+			if r.IsInt() && !iv.Max.Inclusive {
+				r.Sub(r, big.NewRat(1, 1))
+			}
+			r = floorRat(r)
+			iv.Max = Bound{Value: r.RatString(), Inclusive: true}
+		}
+		if iv.isEmpty() || iv.Max.Value != "" && iv.Max.rat().Sign() < 0 {
+			continue
+		}
+		if iv.Min.Value == "0" && iv.Max.Value != "" && iv.Max.Value != "0" {
+			// No length is less than zero, so <=2
+			// says all that >=0 & <=2 does.
+			iv.Min = Bound{}
+		}
+		ranges1 = append(ranges1, iv)
+		sets1 = append(sets1, sets[i])
+	}
+	return ranges1, sets1
+}
+
+// lengthDiscriminator returns a switch on the length of a list
+// that discriminates between the selected arms, or nil if there is
+// none. The list is either the value itself or a field required by
+// all the arms. Arms that allow no list at all are chosen by the
+// default of the switch. A switch that tells all the arms apart
+// is preferred; otherwise the first that narrows them down is used,
+// with the arms whose lengths overlap chosen together.
+func (d *discriminator[Set]) lengthDiscriminator(arms []cue.Value, selected Set) DecisionNode {
+	type lenGroup struct {
+		interval Interval
+		arms     Set
+	}
+	var (
+		bestPath   string
+		bestValues []cue.Value
+		bestGroups []lenGroup
+		bestOthers Set
+	)
+	try := func(path string, values []cue.Value) bool {
+		var ivs [][]Interval
+		var indexes []int
+		others := d.sets.make()
+		for i := range d.sets.values(selected) {
+			v := values[i]
+			switch {
+			case !v.Exists():
+				return false
+			case isList(v):
+				ivs = append(ivs, lengthIntervals(v))
+				indexes = append(indexes, i)
+			case v.IncompleteKind()&cue.ListKind == 0:
+				d.sets.add(&others, i)
+			default:
+				// The value might be a list or something else.
+				return false
+			}
+		}
+		if len(indexes) < 2 {
+			return false
+		}
+		ranges, sets := lengthRanges(ivs)
+		full := true
+		progress := d.sets.len(others) > 0
+		for _, set := range sets {
+			full = full && len(set) == 1
+			progress = progress || len(set) < len(indexes)
+		}
+		if !progress || (bestGroups != nil && !full) {
+			return false
+		}
+		groups := make([]lenGroup, len(ranges))
+		for i, iv := range ranges {
+			groups[i] = lenGroup{
+				interval: iv,
+				arms:     d.sets.make(),
+			}
+			for _, j := range sets[i] {
+				d.sets.add(&groups[i].arms, indexes[j])
+			}
+		}
+		bestPath, bestValues, bestGroups, bestOthers = path, values, groups, others
+		return full
+	}
+	if !try(".", arms) {
+		for path, values := range d.allFields(arms, selected, requiredLabel) {
+			if try(path, values) {
+				break
+			}
+		}
+	}
+	if bestGroups == nil {
+		return nil
+	}
+	d.logf(1, "chose length of %s", bestPath)
+	n := &LenSwitchNode{
+		Path:    bestPath,
+		Default: ErrorNode{},
+	}
+	for _, g := range bestGroups {
+		d.logf(2, "length %v: %v", g.interval, d.setString(g.arms))
+		var branch DecisionNode
+		if d.sets.equal(g.arms, selected) {
+			branch = d.newLeaf(selected)
+		} else {
+			branch = d.discriminate(arms, g.arms)
+		}
+		n.Branches = append(n.Branches, RangeBranch{
+			Interval: g.interval,
+			Node:     branch,
+		})
+	}
+	if d.sets.len(bestOthers) > 0 {
+		// Switch on the kind of the value so that a list
+		// of some other length is not taken for one of
+		// the other arms.
+		byKind := make(map[cue.Kind]Set)
+		for i := range d.sets.values(bestOthers) {
+			for _, k := range allKinds {
+				if bestValues[i].IncompleteKind()&k == 0 {
+					continue
+				}
+				group, ok := byKind[k]
+				if !ok {
+					group = d.sets.make()
+				}
+				d.sets.add(&group, i)
+				byKind[k] = group
+			}
+		}
+		kindSwitch := &KindSwitchNode{
+			Path:     bestPath,
+			Branches: make(map[cue.Kind]DecisionNode, len(byKind)),
+		}
+		for k, group := range byKind {
+			kindSwitch.Branches[k] = d.discriminate(arms, group)
+		}
+		n.Default = kindSwitch
+	}
+	return n
+}
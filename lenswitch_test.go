@@ -0,0 +1,71 @@
+package cuediscrim
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var lengthIntervalsTests = []struct {
+	testName string
+	cue      string
+	want     []string
+}{{
+	testName: "Tuple",
+	cue:      `[int, string]`,
+	want:     []string{"2"},
+}, {
+	testName: "OpenList",
+	cue:      `[...int]`,
+	want:     []string{">=0"},
+}, {
+	testName: "OpenTuple",
+	cue:      `[int, ...int]`,
+	want:     []string{">=1"},
+}, {
+	testName: "MaxItems",
+	cue:      `list.MaxItems(3)`,
+	want:     []string{">=0 & <=3"},
+}, {
+	testName: "MinItemsWithElements",
+	cue:      `list.MinItems(2) & [...string]`,
+	want:     []string{">=2"},
+}, {
+	testName: "MinAndMaxItems",
+	cue:      `list.MinItems(1) & list.MaxItems(4) & [int, ...int]`,
+	want:     []string{">=1 & <=4"},
+}, {
+	testName: "NotAList",
+	cue:      `string`,
+	want:     nil,
+}}
+
+func TestLengthIntervals(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range lengthIntervalsTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := ctx.CompileString("import \"list\"\n" + test.cue)
+			var got []string
+			for _, iv := range lengthIntervals(v) {
+				got = append(got, iv.String())
+			}
+			qt.Check(t, qt.DeepEquals(got, test.want))
+		})
+	}
+}
+
+func TestLengthRanges(t *testing.T) {
+	ctx := cuecontext.New()
+	var ivs [][]Interval
+	for _, s := range []string{`[int]`, `[int, ...int]`, `list.MaxItems(2)`} {
+		ivs = append(ivs, lengthIntervals(ctx.CompileString("import \"list\"\n"+s)))
+	}
+	ranges, sets := lengthRanges(ivs)
+	var got []string
+	for _, iv := range ranges {
+		got = append(got, iv.String())
+	}
+	qt.Check(t, qt.DeepEquals(got, []string{"0", "1", "2", ">=3"}))
+	qt.Check(t, qt.DeepEquals(sets, [][]int{{2}, {0, 1, 2}, {1, 2}, {1}}))
+}
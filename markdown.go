@@ -0,0 +1,230 @@
+package cuediscrim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/token"
+)
+
+// MarkdownOption represents an option to [GenerateMarkdown].
+type MarkdownOption func(*markdownOptions)
+
+type markdownOptions struct {
+	title    string
+	link     func(pos token.Pos) string
+	examples bool
+}
+
+// MarkdownTitle sets the title of the generated section,
+// which may contain Markdown. The default is the path of the
+// union as a code span; there is no title if the union
+// is at the root and no title is set.
+func MarkdownTitle(title string) MarkdownOption {
+	return func(o *markdownOptions) {
+		o.title = title
+	}
+}
+
+// MarkdownLinks sets the function used to make the link to the
+// source of each variant from its position. The default links
+// to the file name with a #L suffix holding the line number,
+// as understood by GitHub and similar code browsers.
+// If f returns the empty string, no link is made.
+func MarkdownLinks(f func(pos token.Pos) string) MarkdownOption {
+	return func(o *markdownOptions) {
+		o.link = f
+	}
+}
+
+// MarkdownExamples sets whether the generated documentation
+// includes an example payload for each variant, as produced by
+// [ArmExample]. The default is true.
+func MarkdownExamples(enable bool) MarkdownOption {
+	return func(o *markdownOptions) {
+		o.examples = enable
+	}
+}
+
+// GenerateMarkdown returns Markdown documentation for the union v,
+// whose analysis is r, suitable for including in API documentation.
+// It has a section headed by the title (see [MarkdownTitle]) that
+// names the fields that the decision tree switches on by value,
+// followed by a table with a row for each variant giving the
+// values of those fields that select it, its name with a link to
+// its source (see [MarkdownLinks]), and the first paragraph of its
+// doc comment. Then comes an example payload for each variant
+// (see [MarkdownExamples]).
+func GenerateMarkdown(v cue.Value, r *Result, opts ...MarkdownOption) ([]byte, error) {
+	o := markdownOptions{
+		link:     markdownSourceLink,
+		examples: true,
+	}
+	if p := v.Path().String(); p != "" {
+		o.title = markdownCode(p)
+	}
+	for _, f := range opts {
+		f(&o)
+	}
+	sources := armSources(v)
+	if sources != nil && len(sources) != len(r.Arms) {
+		// The arms can't be matched up with the source.
+		sources = nil
+	}
+	variant := func(arm int) string {
+		name := ""
+		if arm < len(r.Names) {
+			name = r.Names[arm]
+		}
+		if name == "" {
+			name = fmt.Sprintf("arm %d", arm)
+		}
+		name = "`" + name + "`"
+		if sources != nil {
+			if link := o.link(sources[arm].pos); link != "" {
+				name = fmt.Sprintf("[%s](%s)", name, link)
+			}
+		}
+		return name
+	}
+
+	var buf strings.Builder
+	if o.title != "" {
+		fmt.Fprintf(&buf, "## %s\n\n", o.title)
+	}
+	tags := goTagValues(r.Tree)
+	switch len(tags) {
+	case 0:
+		buf.WriteString("The variants are not told apart by the value of any field.\n\n")
+	case 1:
+		fmt.Fprintf(&buf, "The variants are told apart by the value of %s.\n\n", markdownCode(tags[0].path))
+	default:
+		var paths []string
+		for _, f := range tags {
+			paths = append(paths, markdownCode(f.path))
+		}
+		fmt.Fprintf(&buf, "The variants are told apart by the values of %s.\n\n", strings.Join(paths, ", "))
+	}
+	if !r.Perfect {
+		buf.WriteString("Some values may match more than one variant.\n\n")
+	}
+
+	// Write the table.
+	for _, f := range tags {
+		fmt.Fprintf(&buf, "| %s ", markdownCode(f.path))
+	}
+	buf.WriteString("| Variant | Description |\n")
+	for range tags {
+		buf.WriteString("| --- ")
+	}
+	buf.WriteString("| --- | --- |\n")
+	for arm := range r.Arms {
+		for _, f := range tags {
+			var values []string
+			for _, a := range f.values {
+				if arms := f.arms[a]; arms != nil && arms.Len() == 1 && arms.Has(arm) {
+					values = append(values, markdownCode(a.String()))
+				}
+			}
+			fmt.Fprintf(&buf, "| %s ", strings.Join(values, ", "))
+		}
+		doc := ""
+		if sources != nil {
+			doc = markdownCell(sources[arm].doc)
+		}
+		fmt.Fprintf(&buf, "| %s | %s |\n", variant(arm), doc)
+	}
+
+	if !o.examples {
+		return []byte(buf.String()), nil
+	}
+	buf.WriteString("\n### Examples\n")
+	for arm := range r.Arms {
+		fmt.Fprintf(&buf, "\n%s:\n\n", variant(arm))
+		x, err := ArmExample(r.Tree, r.Arms, arm)
+		if err != nil {
+			fmt.Fprintf(&buf, "No example is available: %s.\n", markdownCell(err.Error()))
+			continue
+		}
+		data, err := json.MarshalIndent(x, "", "  ")
+		if err != nil {
+			fmt.Fprintf(&buf, "No example is available: %s.\n", markdownCell(err.Error()))
+			continue
+		}
+		fmt.Fprintf(&buf, "```json\n%s\n```\n", data)
+	}
+	return []byte(buf.String()), nil
+}
+
+// markdownSourceLink returns the default link
+// to the source at pos; see [MarkdownLinks].
+func markdownSourceLink(pos token.Pos) string {
+	if !pos.IsValid() || pos.Filename() == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s#L%d", pos.Filename(), pos.Line())
+}
+
+// markdownCode returns s as a Markdown code span,
+// using enough backquotes that any in s are
+// treated literally.
+func markdownCode(s string) string {
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
+
+// markdownCell returns the first paragraph of s on a single line,
+// with any vertical bars escaped, so that it can be used in
+// a table cell.
+func markdownCell(s string) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n\n")
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// armSource holds the position and doc comment of an arm.
+type armSource struct {
+	pos token.Pos
+	doc string
+}
+
+// armSources returns the source of each arm of v as returned by
+// [Disjunctions], in the same way as [ArmNames]. An arm that refers
+// to a definition or field is taken to be at that definition or
+// field, and has its doc comment. It returns nil when the arms as
+// written cannot be matched up with the evaluated arms.
+func armSources(v cue.Value) []armSource {
+	var sources []armSource
+	for _, a := range sourceArms(nil, v, nil) {
+		if a.applied.Err() != nil {
+			// Removed by evaluation; see RemovedArms.
+			continue
+		}
+		def := a.arm
+		if root, p := a.arm.ReferencePath(); root.Exists() {
+			if ref := root.LookupPath(p); ref.Exists() {
+				def = ref
+			}
+		}
+		var doc strings.Builder
+		for _, c := range def.Doc() {
+			doc.WriteString(c.Text())
+		}
+		sources = append(sources, armSource{
+			pos: def.Pos(),
+			doc: doc.String(),
+		})
+	}
+	if len(sources) != len(Disjunctions(v)) {
+		return nil
+	}
+	return sources
+}
@@ -0,0 +1,90 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestGenerateMarkdown(t *testing.T) {
+	v := cuecontext.New().CompileString(`
+// A circle.
+#Circle: {type!: "circle", r!: number}
+
+// A square
+// with sides.
+//
+// Squares are | special.
+#Square: {
+	type!: "square"
+	side!: number
+}
+#Shape: #Circle | #Square | {type!: "blob"}
+`, cue.Filename("shapes.cue"))
+	qt.Assert(t, qt.IsNil(v.Err()))
+	u := v.LookupPath(cue.ParsePath("#Shape"))
+	data, err := GenerateMarkdown(u, DiscriminateValue(u))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(string(data), strings.TrimPrefix(`
+## `+"`#Shape`"+`
+
+The variants are told apart by the value of `+"`type`"+`.
+
+| `+"`type`"+` | Variant | Description |
+| --- | --- | --- |
+| `+"`\"circle\"`"+` | [`+"`#Circle`"+`](shapes.cue#L3) | A circle. |
+| `+"`\"square\"`"+` | [`+"`#Square`"+`](shapes.cue#L9) | A square with sides. |
+| `+"`\"blob\"`"+` | [`+"`arm 2`"+`](shapes.cue#L13) |  |
+
+### Examples
+
+[`+"`#Circle`"+`](shapes.cue#L3):
+
+`+"```json"+`
+{
+  "type": "circle",
+  "r": 0
+}
+`+"```"+`
+
+[`+"`#Square`"+`](shapes.cue#L9):
+
+`+"```json"+`
+{
+  "type": "square",
+  "side": 0
+}
+`+"```"+`
+
+[`+"`arm 2`"+`](shapes.cue#L13):
+
+`+"```json"+`
+{
+  "type": "blob"
+}
+`+"```"+`
+`, "\n")))
+}
+
+func TestGenerateMarkdownOptions(t *testing.T) {
+	v := cuecontext.New().CompileString(`{a!: int, b!: "x"} | {a!: string, b!: "y"} | {a!: string, b!: "y", c?: int}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	data, err := GenerateMarkdown(v, DiscriminateValue(v), MarkdownTitle("Things"), MarkdownExamples(false))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(string(data), strings.TrimPrefix(`
+## Things
+
+The variants are told apart by the value of `+"`b`"+`.
+
+Some values may match more than one variant.
+
+| `+"`b`"+` | Variant | Description |
+| --- | --- | --- |
+| `+"`\"x\"`"+` | `+"`arm 0`"+` |  |
+|  | `+"`arm 1`"+` |  |
+|  | `+"`arm 2`"+` |  |
+`, "\n")))
+}
@@ -34,6 +34,64 @@ func DataTypeForValues(arms []cue.Value) ast.Expr {
 	return syntaxForKind(k)
 }
 
+// AtomConstraintForValues returns the disjunction of the given
+// schemas, such as "a" | "b" | =~"^x", when they are all atoms of a
+// single kind, as those merged by [MergeCompatible] are, or nil
+// otherwise. Where [DataTypeForValues] widens such schemas to their
+// kind so that a value of any of them can be stored, this keeps the
+// precise union, so that a validator can still enforce it once a
+// value has been routed to the merged arms by its kind.
+//
+// Calls to functions in other packages, such as strings.HasPrefix,
+// are written as they are in the schemas, without their imports.
+func AtomConstraintForValues(arms []cue.Value) ast.Expr {
+	if len(arms) == 0 {
+		return nil
+	}
+	k := arms[0].IncompleteKind()
+	if !isAtomKind(k) {
+		return nil
+	}
+	exprs := make([]ast.Expr, len(arms))
+	for i, arm := range arms {
+		if arm.IncompleteKind() != k {
+			return nil
+		}
+		x := syntaxExpr(arm.Syntax(cue.Raw()))
+		if x == nil {
+			return nil
+		}
+		exprs[i] = x
+	}
+	return ast.NewBinExpr(token.OR, exprs...)
+}
+
+// syntaxExpr returns the expression in n, as returned by
+// [cue.Value.Syntax], leaving out any imports that it needs,
+// or nil if there is no single expression.
+func syntaxExpr(n ast.Node) ast.Expr {
+	switch n := n.(type) {
+	case ast.Expr:
+		return n
+	case *ast.File:
+		var x ast.Expr
+		for _, decl := range n.Decls {
+			switch decl := decl.(type) {
+			case *ast.ImportDecl:
+			case *ast.EmbedDecl:
+				if x != nil {
+					return nil
+				}
+				x = decl.Expr
+			default:
+				return nil
+			}
+		}
+		return x
+	}
+	return nil
+}
+
 func dataTypeForStruct(arms []cue.Value) ast.Expr {
 	labelTypeOr := func(t1, t2 labelType) labelType {
 		if t1 == t2 {
@@ -238,7 +296,8 @@ func mergeCompatible(arms []cue.Value) ([]cue.Value, func(int) IntSet) {
 // - the kinds of each value should either be atoms and the same or
 // - the kinds of each value should all be structs and every
 // field should be compatible (recursively) across all structs
-// where it's defined.
+// where it's defined, and not disallowed by any of the structs
+// that are closed.
 // TODO we should probably allow identical list types too.
 func compatible(arms []cue.Value) (_ok bool) {
 	//	log.Printf("compatible (")
@@ -258,10 +317,17 @@ func compatible(arms []cue.Value) (_ok bool) {
 	switch k := arms[0].IncompleteKind(); k {
 	case cue.StructKind:
 		// We know that all arms are structs.
-		for _, vals := range allFields(arms, intSetN(len(arms)), requiredLabel|optionalLabel|regularLabel) {
+		for path, vals := range allFields(arms, intSetN(len(arms)), requiredLabel|optionalLabel|regularLabel) {
 			if !compatibleKinds(vals) {
 				return false
 			}
+			for i, v := range vals {
+				if !v.Exists() && closedForbidsField(arms[i], path) {
+					// A closed struct can't have a field
+					// declared by another.
+					return false
+				}
+			}
 		}
 	case cue.ListKind:
 		types, longest := listTypes(arms)
@@ -92,6 +92,22 @@ var compatibleTests = []struct {
 		name: "MixedStructAndAtomType",
 		cue:  "string | {x!: bool}",
 		want: false, // One is an atom kind, the other is a struct.
+	}, {
+		name: "ClosedStructWithoutExtraField",
+		cue:  "close({x!: int}) | {x!: int, y?: string}",
+		want: false, // The closed struct can't have y.
+	}, {
+		name: "ClosedStructsWithSameFields",
+		cue:  "close({x!: int, y?: string}) | {x!: int, y?: string}",
+		want: true,
+	}, {
+		name: "ClosedNestedStruct",
+		cue:  "{a: close({x!: int})} | {a: {x!: int, y!: int}}",
+		want: false, // The closed struct at a can't have y.
+	}, {
+		name: "ClosedStructWithPattern",
+		cue:  "close({x!: int, [string]: int}) | {x!: int, y?: int}",
+		want: true, // The pattern allows y.
 	},
 }
 
@@ -108,3 +124,50 @@ func TestCompatible(t *testing.T) {
 		})
 	}
 }
+
+var atomConstraintForValuesTests = []struct {
+	name string
+	cue  string
+	want string
+}{{
+	name: "Strings",
+	cue:  `"a" | "b" | =~"^x"`,
+	want: `"a" | "b" | =~"^x"`,
+}, {
+	name: "Ints",
+	cue:  `1 | int & >10 & <20`,
+	want: `1 | int & >10 & <20`,
+}, {
+	name: "Import",
+	cue: `
+import "strings"
+
+"a" | strings.HasPrefix("x")
+`,
+	want: `"a" | strings.HasPrefix("x")`,
+}, {
+	name: "MixedKinds",
+	cue:  `"a" | 1`,
+}, {
+	name: "Structs",
+	cue:  `{a!: int} | {b!: int}`,
+}}
+
+func TestAtomConstraintForValues(t *testing.T) {
+	for _, test := range atomConstraintForValuesTests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := cuecontext.New()
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+
+			expr := AtomConstraintForValues(Disjunctions(val))
+			if test.want == "" {
+				qt.Assert(t, qt.IsNil(expr))
+				return
+			}
+			data, err := format.Node(expr)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.Equals(string(data), test.want))
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// NodeMermaid returns a Mermaid flowchart showing the decisions in n,
+// suitable for pasting into GitHub issues and documentation.
+// Switches are shown as decision shapes with an edge for each
+// branch; leaves show the arms they choose, and error nodes,
+// including the default branches of value switches that
+// choose nothing, are shown as "error".
+func NodeMermaid(n DecisionNode) string {
+	m := &mermaidWriter{}
+	m.buf.WriteString("flowchart TD\n")
+	m.node(n)
+	return m.buf.String()
+}
+
+type mermaidWriter struct {
+	buf  strings.Builder
+	next int
+
+	// keys is used to compare the branches of value switches.
+	keys nodeKeys
+}
+
+// node writes n and returns its id.
+func (m *mermaidWriter) node(n DecisionNode) string {
+	id := fmt.Sprintf("n%d", m.next)
+	m.next++
+	switch n := n.(type) {
+	case *LeafNode:
+		label := fmt.Sprintf("choose(%v)", SetString(n.Arms))
+		if n.Deprecated != nil && n.Deprecated.Len() > 0 {
+			label += fmt.Sprintf(" deprecated(%v)", SetString(n.Deprecated))
+		}
+		if n.Precedence != nil {
+			label += fmt.Sprintf(" precedence(%v)", armListString(n.Precedence, nil))
+		}
+		m.printf("%s[%s]", id, mermaidText(label))
+	case *KindSwitchNode:
+		m.printf("%s{%s}", id, mermaidText(fmt.Sprintf("kind(%s)", optionalPath(n.Path, n.Optional))))
+		for _, k := range slices.Sorted(maps.Keys(n.Branches)) {
+			m.edge(id, k.String(), n.Branches[k])
+		}
+	case *ValueSwitchNode:
+		label := optionalPath(n.Path, n.Optional)
+		if n.Implied.isValid() {
+			label += fmt.Sprintf(" (absent = %v)", n.Implied)
+		}
+		m.printf("%s{%s}", id, mermaidText(label))
+		for _, g := range n.foldBranches(&m.keys) {
+			m.edge(id, joinAtoms(g.values), g.node)
+		}
+		m.edge(id, "default", n.Default)
+	case *RangeSwitchNode:
+		m.printf("%s{%s}", id, mermaidText(fmt.Sprintf("range(%s)", optionalPath(n.Path, n.Optional))))
+		for _, b := range n.Branches {
+			m.edge(id, b.Interval.String(), b.Node)
+		}
+		m.edge(id, "default", n.Default)
+	case *LenSwitchNode:
+		m.printf("%s{%s}", id, mermaidText(fmt.Sprintf("len(%s)", n.Path)))
+		for _, b := range n.Branches {
+			m.edge(id, b.Interval.String(), b.Node)
+		}
+		m.edge(id, "default", n.Default)
+	case *RegexSwitchNode:
+		m.printf("%s{%s}", id, mermaidText(fmt.Sprintf("regexp(%s)", optionalPath(n.Path, n.Optional))))
+		for _, b := range n.Branches {
+			m.edge(id, "=~"+strconv.Quote(b.Pattern), b.Node)
+		}
+		m.edge(id, "default", n.Default)
+	case *FieldPresenceNode:
+		m.printf("%s{%s}", id, mermaidText("firstOf"))
+		for _, b := range n.Branches {
+			m.edge(id, fmt.Sprintf("present(%s)", b.Path), b.Node)
+		}
+		m.edge(id, "default", n.Default)
+	case *TupleSwitchNode:
+		m.printf("%s{%s}", id, mermaidText(fmt.Sprintf("(%s)", strings.Join(n.Paths, ", "))))
+		for _, b := range n.Branches {
+			m.edge(id, "("+joinAtoms(b.Values)+")", b.Node)
+		}
+		m.edge(id, "default", ErrorNode{})
+	case *FieldAbsenceNode:
+		m.printf("%s{%s}", id, mermaidText("allOf"))
+		for _, path := range slices.Sorted(maps.Keys(n.Branches)) {
+			m.edge(id, fmt.Sprintf("notPresent(%s)", path), &LeafNode{
+				Arms: n.Branches[path],
+			})
+		}
+	case *OptionalNode:
+		m.printf("%s{%s}", id, mermaidText("present(.)"))
+		m.edge(id, "yes", n.Present)
+		absent := fmt.Sprintf("n%d", m.next)
+		m.next++
+		m.printf("%s([%s])", absent, mermaidText("absent"))
+		m.printf("%s -->|%s| %s", id, mermaidText("no"), absent)
+	case *GroupNode:
+		label := "group"
+		if n.Name != "" {
+			label += " " + n.Name
+		}
+		m.printf("%s[[%s]]", id, mermaidText(label))
+		m.edge(id, "", n.Select)
+	default:
+		// ErrorNode, or a missing default.
+		m.printf("%s([%s])", id, mermaidText("error"))
+	}
+	return id
+}
+
+// edge writes the node sub and an edge to it from the node
+// with the given id, with the given label if it's not empty.
+func (m *mermaidWriter) edge(from, label string, sub DecisionNode) {
+	to := m.node(sub)
+	if label == "" {
+		m.printf("%s --> %s", from, to)
+		return
+	}
+	m.printf("%s -->|%s| %s", from, mermaidText(label), to)
+}
+
+func (m *mermaidWriter) printf(f string, a ...any) {
+	m.buf.WriteString("\t")
+	fmt.Fprintf(&m.buf, f, a...)
+	m.buf.WriteString("\n")
+}
+
+// optionalPath returns path with a "?" suffix when optional is true,
+// as shown by [NodeString].
+func optionalPath(path string, optional bool) string {
+	if optional {
+		return path + "?"
+	}
+	return path
+}
+
+// mermaidText returns s quoted for use as the text of a Mermaid node
+// or edge. Mermaid has no escape for a double quote inside
+// quoted text, so it's written as an entity code.
+func mermaidText(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
@@ -0,0 +1,39 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestNodeMermaid(t *testing.T) {
+	v := cuecontext.New().CompileString(`"a" | "b" | int | {type!: "x"} | {type!: "y"}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v), Optional(true))
+	qt.Check(t, qt.Equals(NodeMermaid(tree), strings.TrimPrefix(`
+flowchart TD
+	n0{"present(.)"}
+	n1{"."}
+	n2["choose({0})"]
+	n1 -->|"#quot;a#quot;"| n2
+	n3["choose({1})"]
+	n1 -->|"#quot;b#quot;"| n3
+	n4{"kind(.)"}
+	n5["choose({2})"]
+	n4 -->|"int"| n5
+	n6{"type"}
+	n7["choose({3})"]
+	n6 -->|"#quot;x#quot;"| n7
+	n8["choose({4})"]
+	n6 -->|"#quot;y#quot;"| n8
+	n9(["error"])
+	n6 -->|"default"| n9
+	n4 -->|"struct"| n6
+	n1 -->|"default"| n4
+	n0 -->|"yes"| n1
+	n10(["absent"])
+	n0 -->|"no"| n10
+`, "\n")))
+}
@@ -0,0 +1,123 @@
+package cuediscrim
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// treeMigrations holds the functions that upgrade
+// an encoded tree from one version to the next:
+// treeMigrations[i] upgrades the root node of a tree
+// from version i+1 to version i+2.
+//
+// When the tree format changes, TreeVersion should be
+// incremented and a migration added here, so that
+// stored trees continue to be readable.
+var treeMigrations = []func(root map[string]any) error{
+	migrateTreeV1,
+	migrateTreeV2,
+}
+
+// migrateTree upgrades the given encoded tree to TreeVersion.
+func migrateTree(data []byte) ([]byte, error) {
+	var t map[string]any
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("invalid tree: %v", err)
+	}
+	fversion, ok := t["version"].(float64)
+	if !ok || fversion != float64(int(fversion)) || fversion < 1 {
+		return nil, fmt.Errorf("invalid tree: missing or invalid version")
+	}
+	version := int(fversion)
+	if version > TreeVersion {
+		return nil, fmt.Errorf("unsupported tree version %d (latest known version is %d)", version, TreeVersion)
+	}
+	if version == TreeVersion {
+		return data, nil
+	}
+	root, ok := t["root"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid tree: missing or invalid root")
+	}
+	for ; version < TreeVersion; version++ {
+		if err := treeMigrations[version-1](root); err != nil {
+			return nil, fmt.Errorf("cannot upgrade tree from version %d: %v", version, err)
+		}
+	}
+	t["version"] = TreeVersion
+	return json.Marshal(t)
+}
+
+// migrateTreeV1 converts value switch branches
+// from an object to an ordered list.
+func migrateTreeV1(n map[string]any) error {
+	return walkEncodedNodes(n, func(n map[string]any) error {
+		if n["type"] != "valueSwitch" {
+			return nil
+		}
+		branches, ok := n["branches"].(map[string]any)
+		if !ok {
+			return fmt.Errorf("value switch branches are not an object")
+		}
+		cases := make([]any, 0, len(branches))
+		for _, val := range slices.Sorted(maps.Keys(branches)) {
+			cases = append(cases, map[string]any{
+				"value": val,
+				"node":  branches[val],
+			})
+		}
+		n["branches"] = cases
+		return nil
+	})
+}
+
+// migrateTreeV2 does nothing: version 3 only adds node types
+// and fields, so a version 2 tree is also a valid version 3 tree.
+func migrateTreeV2(n map[string]any) error {
+	return nil
+}
+
+// walkEncodedNodes calls f for all the nodes in the
+// generically decoded tree rooted at n, parents before children.
+// It understands the structure of all versions of the format.
+func walkEncodedNodes(n map[string]any, f func(map[string]any) error) error {
+	if err := f(n); err != nil {
+		return err
+	}
+	var children []any
+	switch branches := n["branches"].(type) {
+	case map[string]any:
+		if n["type"] != "fieldAbsence" {
+			for _, k := range slices.Sorted(maps.Keys(branches)) {
+				children = append(children, branches[k])
+			}
+		}
+	case []any:
+		for _, c := range branches {
+			if c, ok := c.(map[string]any); ok {
+				children = append(children, c["node"])
+			}
+		}
+	}
+	if dflt, ok := n["default"]; ok {
+		children = append(children, dflt)
+	}
+	if present, ok := n["present"]; ok {
+		children = append(children, present)
+	}
+	if sel, ok := n["select"]; ok {
+		children = append(children, sel)
+	}
+	for _, c := range children {
+		c, ok := c.(map[string]any)
+		if !ok {
+			return fmt.Errorf("unexpected node type %T", c)
+		}
+		if err := walkEncodedNodes(c, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
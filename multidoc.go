@@ -0,0 +1,137 @@
+package cuediscrim
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/encoding/json"
+	"cuelang.org/go/pkg/encoding/yaml"
+)
+
+// DocumentResult holds the classification of a single document
+// by [ClassifyDocuments].
+type DocumentResult struct {
+	// Index holds the index of the document in the stream.
+	Index int
+
+	CheckResult
+}
+
+// Arms returns all the arms that the document might match:
+// the union of r.Selected and r.Possible.
+func (r DocumentResult) Arms() IntSet {
+	return union(r.Selected, r.Possible)
+}
+
+// DocumentStats holds aggregate statistics about the documents
+// classified by [ClassifyDocuments].
+type DocumentStats struct {
+	// Documents holds the total number of documents.
+	Documents int
+
+	// ByArm maps from arm index to the number of documents
+	// classified as that arm and no other.
+	ByArm map[int]int
+
+	// Ambiguous holds the number of documents
+	// that might match more than one arm.
+	Ambiguous int
+
+	// Unmatched holds the number of documents
+	// that match no arm.
+	Unmatched int
+}
+
+// Classification holds the result of [ClassifyDocuments].
+type Classification struct {
+	// Documents holds the classification of each document, in order.
+	Documents []DocumentResult
+
+	// Stats holds statistics across all the documents.
+	Stats DocumentStats
+}
+
+// ClassifyDocuments classifies each of the given documents using
+// the decision tree n, as [CheckDetail] does, and gathers
+// statistics across them. This is useful when a single logical
+// configuration is spread across several documents, such as a
+// YAML stream of Kubernetes manifests. See [YAMLDocuments] and
+// [JSONDocuments] for ways to obtain the documents.
+func ClassifyDocuments(n DecisionNode, docs []cue.Value, opts ...CheckOption) Classification {
+	c := Classification{
+		Documents: make([]DocumentResult, len(docs)),
+		Stats: DocumentStats{
+			Documents: len(docs),
+			ByArm:     make(map[int]int),
+		},
+	}
+	for i, doc := range docs {
+		r := DocumentResult{
+			Index:       i,
+			CheckResult: CheckDetail(n, doc, opts...),
+		}
+		c.Documents[i] = r
+		arms := r.Arms()
+		switch arms.Len() {
+		case 0:
+			c.Stats.Unmatched++
+		case 1:
+			for arm := range arms.Values() {
+				c.Stats.ByArm[arm]++
+			}
+		default:
+			c.Stats.Ambiguous++
+		}
+	}
+	return c
+}
+
+// YAMLDocuments returns the documents in the YAML stream data,
+// which holds documents separated by "---" lines.
+func YAMLDocuments(ctx *cue.Context, data []byte) ([]cue.Value, error) {
+	expr, err := yaml.UnmarshalStream(data)
+	if err != nil {
+		return nil, err
+	}
+	list := ctx.BuildExpr(expr)
+	if err := list.Err(); err != nil {
+		return nil, err
+	}
+	return listValues(list)
+}
+
+// JSONDocuments returns the documents in the JSON stream data,
+// which holds a sequence of JSON values, such as newline-delimited
+// JSON.
+func JSONDocuments(ctx *cue.Context, filename string, data []byte) ([]cue.Value, error) {
+	d := json.NewDecoder(nil, filename, bytes.NewReader(data))
+	var docs []cue.Value
+	for {
+		expr, err := d.Extract()
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		v := ctx.BuildExpr(expr)
+		if err := v.Err(); err != nil {
+			return nil, err
+		}
+		docs = append(docs, v)
+	}
+}
+
+func listValues(list cue.Value) ([]cue.Value, error) {
+	iter, err := list.List()
+	if err != nil {
+		return nil, err
+	}
+	var vs []cue.Value
+	for iter.Next() {
+		vs = append(vs, iter.Value())
+	}
+	return vs, nil
+}
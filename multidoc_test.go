@@ -0,0 +1,85 @@
+package cuediscrim
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestClassifyDocumentsYAML(t *testing.T) {
+	ctx := cuecontext.New()
+	schema := ctx.CompileString(`
+{kind!: "Service", spec!: {...}} |
+{kind!: "Deployment", spec!: {...}} |
+{kind!: "ConfigMap", data?: {...}}
+`)
+	qt.Assert(t, qt.IsNil(schema.Err()))
+	tree, _, _ := Discriminate(Disjunctions(schema))
+	docs, err := YAMLDocuments(ctx, []byte(`
+kind: Deployment
+spec: {}
+---
+kind: Service
+spec: {}
+---
+kind: Deployment
+spec: {}
+---
+kind: Secret
+`))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.HasLen(docs, 4))
+
+	c := ClassifyDocuments(tree, docs)
+	qt.Assert(t, qt.HasLen(c.Documents, 4))
+	qt.Check(t, qt.Equals(c.Documents[2].Index, 2))
+	qt.Check(t, deepEquals(ref(c.Documents[0].Arms()), ref[IntSet](setOf(1))))
+	qt.Check(t, deepEquals(ref(c.Documents[3].Arms()), ref[IntSet](setOf())))
+	qt.Check(t, qt.DeepEquals(c.Stats, DocumentStats{
+		Documents: 4,
+		ByArm:     map[int]int{0: 1, 1: 2},
+		Unmatched: 1,
+	}))
+}
+
+func TestClassifyDocumentsJSON(t *testing.T) {
+	ctx := cuecontext.New()
+	schema := ctx.CompileString(`{type!: "a"} | {type!: "b"}`)
+	qt.Assert(t, qt.IsNil(schema.Err()))
+	tree, _, _ := Discriminate(Disjunctions(schema))
+	docs, err := JSONDocuments(ctx, "stream.json", []byte(`
+{"type": "a"}
+{"type": "b"}
+{"type": "c"}
+`))
+	qt.Assert(t, qt.IsNil(err))
+	c := ClassifyDocuments(tree, docs)
+	qt.Check(t, qt.DeepEquals(c.Stats, DocumentStats{
+		Documents: 3,
+		ByArm:     map[int]int{0: 1, 1: 1},
+		Unmatched: 1,
+	}))
+}
+
+func TestClassifyDocumentsAmbiguous(t *testing.T) {
+	ctx := cuecontext.New()
+	tree := &LeafNode{Arms: setOf(0, 1)}
+	c := ClassifyDocuments(tree, []cue.Value{
+		ctx.CompileString(`{}`),
+		ctx.CompileString(`1`),
+	})
+	qt.Check(t, deepEquals(ref(c.Documents[1].Possible), ref[IntSet](setOf(0, 1))))
+	qt.Check(t, qt.DeepEquals(c.Stats, DocumentStats{
+		Documents: 2,
+		ByArm:     map[int]int{},
+		Ambiguous: 2,
+	}))
+}
+
+func TestYAMLDocumentsError(t *testing.T) {
+	ctx := cuecontext.New()
+	_, err := YAMLDocuments(ctx, []byte("a: [\n"))
+	qt.Assert(t, qt.IsNotNil(err))
+}
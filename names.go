@@ -0,0 +1,202 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/format"
+)
+
+// ArmNames returns a name for each arm of v as returned by
+// [Disjunctions]: the path of the definition or field that the
+// arm refers to, such as #Get, or the empty string when the arm
+// is written inline. It returns nil when the arms as written
+// cannot be matched up with the evaluated arms, for example
+// because evaluation removed duplicates.
+func ArmNames(v cue.Value) []string {
+	var names []string
+	for _, a := range sourceArms(nil, v, nil) {
+		if a.applied.Err() != nil {
+			// Removed by evaluation; see RemovedArms.
+			continue
+		}
+		name := ""
+		if root, p := a.arm.ReferencePath(); root.Exists() {
+			name = p.String()
+		}
+		names = append(names, name)
+	}
+	if len(names) != len(Disjunctions(v)) {
+		return nil
+	}
+	return names
+}
+
+// MergedGroup describes a set of arms that [MergeCompatible]
+// merged together, so that a decision tree chooses them as
+// a single unit.
+type MergedGroup struct {
+	// Name holds an identifier for the group derived from the
+	// names of its members, suitable for naming the code that
+	// handles the group in generated code. It is unique among the
+	// groups returned by [Result.MergedGroups].
+	Name string
+
+	// Arms holds the members of the group in ascending order.
+	Arms []int
+
+	// Type holds a CUE data type that can hold an instance of any
+	// member of the group, as returned by [DataTypeForValues].
+	// It is empty when the arms of the result are not known.
+	Type string
+
+	// Constraint holds the precise union of the members of the
+	// group, as returned by [AtomConstraintForValues], when they
+	// are atoms of a single kind, such as "a" | "b" | =~"^x" where
+	// Type is string. It is empty otherwise.
+	Constraint string
+}
+
+// MergedGroups returns the groups in r.Groups that hold more than
+// one arm. Each group is named after its members, using the names
+// in r.Names where available and the arm index otherwise,
+// so a group merging #Small and #Big is named SmallOrBig, and one
+// merging two unnamed arms 3 and 4 is named Arm3OrArm4.
+func (r *Result) MergedGroups() []MergedGroup {
+	var groups []MergedGroup
+	used := make(map[string]bool)
+	for _, g := range r.Groups {
+		if g.Len() < 2 {
+			continue
+		}
+		arms := slices.Sorted(g.Values())
+		name := groupName(arms, r.Names)
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("%s%d", groupName(arms, r.Names), i)
+		}
+		used[name] = true
+		g := MergedGroup{
+			Name: name,
+			Arms: arms,
+		}
+		if arms[len(arms)-1] < len(r.Arms) {
+			values := make([]cue.Value, len(arms))
+			for i, arm := range arms {
+				values[i] = r.Arms[arm]
+			}
+			g.Type = formatExpr(DataTypeForValues(values))
+			if x := AtomConstraintForValues(values); x != nil {
+				g.Constraint = formatExpr(x)
+			}
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// formatExpr returns x formatted as CUE,
+// or the empty string if that fails.
+func formatExpr(x ast.Expr) string {
+	data, err := format.Node(x)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// groupName returns an identifier for the given arms,
+// made by joining the identifiers for each arm with "Or".
+func groupName(arms []int, names []string) string {
+	var parts []string
+	for _, arm := range arms {
+		part := ""
+		if arm < len(names) {
+			part = identForPath(names[arm])
+		}
+		if part == "" {
+			part = fmt.Sprintf("Arm%d", arm)
+		}
+		if !slices.Contains(parts, part) {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "Or")
+}
+
+// identForPath returns an exported Go-style identifier for the final
+// element of the CUE path p, so #get_item becomes GetItem. It returns
+// the empty string if there is no such element.
+func identForPath(p string) string {
+	sels := cue.ParsePath(p).Selectors()
+	if len(sels) == 0 {
+		return ""
+	}
+	sel := sels[len(sels)-1]
+	var name string
+	switch sel.LabelType() {
+	case cue.StringLabel:
+		name = sel.Unquoted()
+	case cue.DefinitionLabel, cue.HiddenLabel, cue.HiddenDefinitionLabel:
+		name = sel.String()
+	default:
+		return ""
+	}
+	name = camelName(name)
+	if name != "" && !unicode.IsLetter(rune(name[0])) {
+		return ""
+	}
+	return name
+}
+
+// camelName returns name with the characters other than letters
+// and digits removed and the letter following each of them, as well
+// as the first letter, in upper case, so get_item becomes GetItem.
+func camelName(name string) string {
+	var buf strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// WriteMergedGroups writes a comment to w for each of the given
+// groups, describing the arms that it merges, as in:
+//
+//	// SmallOrBig merges arms {0, 1}: #Small, #Big
+//
+// The names of the arms are taken from names,
+// as returned by [ArmNames], when available.
+func WriteMergedGroups(w io.Writer, groups []MergedGroup, names []string) error {
+	for _, g := range groups {
+		members := ""
+		if len(names) > 0 {
+			parts := make([]string, len(g.Arms))
+			for i, arm := range g.Arms {
+				parts[i] = fmt.Sprintf("arm %d", arm)
+				if arm < len(names) && names[arm] != "" {
+					parts[i] = names[arm]
+				}
+			}
+			members = ": " + strings.Join(parts, ", ")
+		}
+		arms := SetString(mapSetOf(slices.Values(g.Arms)))
+		if _, err := fmt.Fprintf(w, "// %s merges arms %s%s\n", g.Name, arms, members); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+const mergedGroupsSchema = `
+#Small: {size!: int, ...}
+#Big: {size!: int, extra?: string, ...}
+#get_item: {size!: int, key!: string, ...}
+x: #Small | #Big | #get_item | string | =~"a"
+`
+
+func TestArmNames(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(mergedGroupsSchema)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	names := ArmNames(v.LookupPath(cue.ParsePath("x")))
+	qt.Check(t, qt.DeepEquals(names, []string{"#Small", "#Big", "#get_item", "", ""}))
+}
+
+func TestMergedGroups(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(mergedGroupsSchema)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	r := DiscriminateValue(v.LookupPath(cue.ParsePath("x")), MergeCompatible(true))
+	groups := r.MergedGroups()
+	qt.Check(t, qt.DeepEquals(groups, []MergedGroup{{
+		Name: "SmallOrBigOrGetItem",
+		Arms: []int{0, 1, 2},
+		Type: "{\n\textra?: string\n\tkey!:   string\n\tsize!:  int\n}",
+	}, {
+		Name:       "Arm3OrArm4",
+		Arms:       []int{3, 4},
+		Type:       "string",
+		Constraint: `string | =~"a"`,
+	}}))
+
+	e, ok := LookupExporter("text")
+	qt.Assert(t, qt.IsTrue(ok))
+	data, err := e.Export(r)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(string(data), strings.TrimPrefix(`
+switch kind(.) {
+case string:
+	choose({3, 4}) precedence([4, 3]) // Arm3OrArm4
+case struct:
+	choose(#Small | #Big | #get_item) // SmallOrBigOrGetItem
+}
+// SmallOrBigOrGetItem merges arms {0, 1, 2}: #Small, #Big, #get_item
+// Arm3OrArm4 merges arms {3, 4}: arm 3, arm 4
+`, "\n")))
+}
+
+func TestMergedGroupsUniqueNames(t *testing.T) {
+	r := &Result{
+		Groups: []IntSet{setOf(0, 1), setOf(2), setOf(3, 4)},
+		Names:  []string{"#A", "#B", "#C", "#A", "#B"},
+	}
+	qt.Check(t, qt.DeepEquals(r.MergedGroups(), []MergedGroup{{
+		Name: "AOrB",
+		Arms: []int{0, 1},
+	}, {
+		Name: "AOrB2",
+		Arms: []int{3, 4},
+	}}))
+}
+
+var armSetStringTests = []struct {
+	testName string
+	arms     IntSet
+	names    []string
+	want     string
+}{{
+	testName: "NoNames",
+	arms:     setOf(0, 1),
+	want:     "{0, 1}",
+}, {
+	testName: "AllNamed",
+	arms:     setOf(1, 0),
+	names:    []string{"#CreateRequest", "#DeleteRequest"},
+	want:     "#CreateRequest | #DeleteRequest",
+}, {
+	testName: "SomeNamed",
+	arms:     setOf(0, 2, 3),
+	names:    []string{"#A", "#B", "", "#D"},
+	want:     "#A | 2 | #D",
+}, {
+	testName: "NoneOfTheArmsNamed",
+	arms:     setOf(2, 4),
+	names:    []string{"#A", "#B", "", "#D"},
+	want:     "{2, 4}",
+}, {
+	testName: "Empty",
+	arms:     setOf(),
+	names:    []string{"#A"},
+	want:     "{}",
+}}
+
+func TestArmSetString(t *testing.T) {
+	for _, test := range armSetStringTests {
+		t.Run(test.testName, func(t *testing.T) {
+			qt.Check(t, qt.Equals(ArmSetString(test.arms, test.names), test.want))
+		})
+	}
+}
+
+func TestNodeStringArmNames(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`
+#CreateRequest: {op!: "create", name!: string}
+#DeleteRequest: {op!: "delete", id!: int}
+x: #CreateRequest | #DeleteRequest | {op!: "list"}
+`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	r := DiscriminateValue(v.LookupPath(cue.ParsePath("x")), CaptureLog(1<<20))
+	qt.Check(t, qt.Equals(NodeString(r.Tree, WriteArmNames(r.Names)), strings.TrimPrefix(`
+switch op {
+case "create":
+	choose(#CreateRequest)
+case "delete":
+	choose(#DeleteRequest)
+case "list":
+	choose({2})
+default:
+	error
+}
+`, "\n")))
+	qt.Check(t, qt.StringContains(r.Log, "discriminate #CreateRequest | #DeleteRequest | 2 {"))
+	qt.Check(t, qt.StringContains(r.Report("x").Tree, "choose(#DeleteRequest)"))
+}
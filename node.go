@@ -2,10 +2,12 @@ package cuediscrim
 
 import (
 	"bytes"
+	"cmp"
 	"fmt"
 	"io"
 	"maps"
 	"slices"
+	"strconv"
 	"strings"
 
 	"cuelang.org/go/cue"
@@ -17,21 +19,191 @@ type DecisionNode interface {
 	Possible() IntSet
 	// Check returns the chosen arms for the given value.
 	Check(v cue.Value) IntSet
+	// check is like Check but also reports whether the
+	// result was determined by positive tests only
+	// (see [CheckDetail]).
+	check(v cue.Value, opts checkOptions) (IntSet, bool)
 	write(w *indentWriter)
 }
 
 // NodeString returns a string representation of a node,
 // showing pseudo-code about the decisions that can be taken.
-func NodeString(n DecisionNode) string {
+// The options are as for [WriteNode].
+func NodeString(n DecisionNode, opts ...WriteOption) string {
+	var buf strings.Builder
+	WriteNode(&buf, n, opts...) // strings.Builder never returns an error.
+	return buf.String()
+}
+
+// WriteOption represents an option to [WriteNode].
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	maxOutput   int
+	pathPrinter func(path string) string
+	groupNames  map[string]string
+	armNames    []string
+}
+
+// MaxOutput limits the output of [WriteNode] to at most n bytes,
+// not counting the final ellipsis. Output is truncated at a line
+// boundary and followed by a line holding "..." when the limit is
+// exceeded. A limit of zero or less means no limit.
+func MaxOutput(n int) WriteOption {
+	return func(o *writeOptions) {
+		o.maxOutput = n
+	}
+}
+
+// PathPrinter causes [WriteNode] to print each path in the tree
+// as f(path) rather than as it is held in the tree. For example,
+// the following prints absolute paths in [cue.Path] syntax
+// for a disjunction found at path p:
+//
+//	cuediscrim.PathPrinter(func(path string) string {
+//		tp, err := cuediscrim.CUEPath(path)
+//		if err != nil {
+//			return path
+//		}
+//		return cue.MakePath(append(p.Selectors(), tp.Selectors()...)...).String()
+//	})
+func PathPrinter(f func(path string) string) WriteOption {
+	return func(o *writeOptions) {
+		o.pathPrinter = f
+	}
+}
+
+// GroupNames causes [WriteNode] to annotate each leaf that chooses
+// exactly the arms of one of the given groups with the group's name,
+// as in:
+//
+//	choose({0, 1}) // SmallOrBig
+//
+// This shows which code path handles each group of arms merged by
+// [MergeCompatible]; see [Result.MergedGroups].
+func GroupNames(groups []MergedGroup) WriteOption {
+	return func(o *writeOptions) {
+		o.groupNames = make(map[string]string, len(groups))
+		for _, g := range groups {
+			o.groupNames[SetString(mapSetOf(slices.Values(g.Arms)))] = g.Name
+		}
+	}
+}
+
+// WriteArmNames causes [WriteNode] to show the arms chosen by each
+// leaf by their names, where known, rather than by their indexes,
+// as in:
+//
+//	choose(#CreateRequest | #DeleteRequest)
+//
+// The names are indexed by arm, as returned by [ArmNames];
+// see [ArmSetString].
+func WriteArmNames(names []string) WriteOption {
+	return func(o *writeOptions) {
+		o.armNames = names
+	}
+}
+
+// WriteNode is like [NodeString] but streams the
+// representation of n to w rather than returning it.
+// It returns the first error encountered when writing to w.
+//
+// Trees for large enumerations can produce a great deal of output,
+// so [MaxOutput] can be used to bound the amount written.
+func WriteNode(w io.Writer, n DecisionNode, opts ...WriteOption) error {
+	var o writeOptions
+	for _, f := range opts {
+		f(&o)
+	}
+	lw := &limitWriter{
+		w:   w,
+		max: o.maxOutput,
+	}
 	if n == nil {
-		return "<nil>"
+		io.WriteString(lw, "<nil>")
+	} else {
+		n.write(&indentWriter{
+			w:           lw,
+			pathPrinter: o.pathPrinter,
+			groupNames:  o.groupNames,
+			armNames:    o.armNames,
+		})
 	}
-	var buf strings.Builder
-	w := &indentWriter{
-		w: &buf,
+	return lw.Close()
+}
+
+// limitWriter writes whole lines to w until the total
+// written would exceed max, after which it discards
+// everything and writes an ellipsis on Close.
+type limitWriter struct {
+	w         io.Writer
+	max       int
+	written   int
+	line      []byte
+	truncated bool
+	err       error
+}
+
+func (w *limitWriter) Write(buf []byte) (int, error) {
+	total := len(buf)
+	for len(buf) > 0 && w.err == nil && !w.truncated {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			w.line = append(w.line, buf...)
+			break
+		}
+		w.line = append(w.line, buf[:i+1]...)
+		buf = buf[i+1:]
+		w.flush()
 	}
-	n.write(w)
-	return buf.String()
+	// Errors are reported by Close so that the
+	// node writing code does not need to check them.
+	return total, nil
+}
+
+func (w *limitWriter) flush() {
+	if len(w.line) == 0 {
+		return
+	}
+	if w.max > 0 && w.written+len(w.line) > w.max {
+		w.truncated = true
+		w.line = nil
+		return
+	}
+	n, err := w.w.Write(w.line)
+	w.written += n
+	w.err = err
+	w.line = w.line[:0]
+}
+
+// Close writes any incomplete final line and
+// the ellipsis if the output was truncated.
+func (w *limitWriter) Close() error {
+	if w.err == nil && !w.truncated {
+		w.flush()
+	}
+	if w.err == nil && w.truncated {
+		_, w.err = io.WriteString(w.w, "...\n")
+	}
+	return w.err
+}
+
+// switchPath returns the path as shown by NodeString,
+// with a "?" suffix when the field is optional.
+func (w *indentWriter) switchPath(path string, optional bool) string {
+	path = w.path(path)
+	if optional {
+		return path + "?"
+	}
+	return path
+}
+
+// path returns path as printed by w's path printer.
+func (w *indentWriter) path(path string) string {
+	if w.pathPrinter == nil {
+		return path
+	}
+	return w.pathPrinter(path)
 }
 
 // LeafNode represents a terminal node, which can contain one or more arms (if indistinguishable).
@@ -41,14 +213,47 @@ type LeafNode struct {
 	// If fully discriminated, it’s usually 1 index.
 	// If multiple arms remain indistinguishable, they’re all listed here.
 	Arms IntSet
+
+	// Deprecated holds the subset of Arms that are marked
+	// as deprecated (see [IsDeprecated]), or nil if there are none.
+	Deprecated IntSet
+
+	// Precedence holds the members of Arms ordered by specificity,
+	// for a decoder that must pick one of several arms that cannot
+	// be told apart and takes the first that matches. An arm comes
+	// before any arm that strictly subsumes it, such as
+	// {a!: int, b?: string} before {a!: int}; other arms keep their
+	// declaration order. It is nil when that is ascending order;
+	// [LeafNode.Ordered] returns the order in either case.
+	Precedence []int
 }
 
 func (l *LeafNode) write(w *indentWriter) {
-	w.Printf("choose(%v)", SetString(l.Arms))
+	arms := ArmSetString(l.Arms, w.armNames)
+	comment := ""
+	if name, ok := w.groupNames[SetString(l.Arms)]; ok {
+		comment = " // " + name
+	}
+	extra := ""
+	if l.Deprecated != nil && l.Deprecated.Len() > 0 {
+		extra += fmt.Sprintf(" deprecated(%v)", ArmSetString(l.Deprecated, w.armNames))
+	}
+	if l.Precedence != nil {
+		extra += fmt.Sprintf(" precedence(%v)", armListString(l.Precedence, w.armNames))
+	}
+	w.Printf("choose(%v)%s%s", arms, extra, comment)
 }
 
 func (l *LeafNode) Check(v cue.Value) IntSet {
-	return l.Arms
+	s, _ := l.check(v, checkOptions{})
+	return s
+}
+
+func (l *LeafNode) check(v cue.Value, opts checkOptions) (IntSet, bool) {
+	if opts.leaves != nil {
+		*opts.leaves = append(*opts.leaves, l)
+	}
+	return l.Arms, true
 }
 
 func (l *LeafNode) Possible() IntSet {
@@ -59,6 +264,17 @@ func (l *LeafNode) Possible() IntSet {
 type KindSwitchNode struct {
 	Path     string
 	Branches map[cue.Kind]DecisionNode
+
+	// Optional reports whether the field at Path is
+	// optional, so a value might not have it at all.
+	// See [Warnings].
+	Optional bool
+
+	// Order holds the order in which the branches are
+	// shown and tested by generated code, as set by [ArmWeights].
+	// Kinds not in Order follow those that are, in
+	// ascending order. It makes no difference to Check.
+	Order []cue.Kind
 }
 
 func (n *KindSwitchNode) Possible() IntSet {
@@ -66,20 +282,38 @@ func (n *KindSwitchNode) Possible() IntSet {
 }
 
 func (n *KindSwitchNode) Check(v cue.Value) IntSet {
+	s, _ := n.check(v, checkOptions{})
+	return s
+}
+
+func (n *KindSwitchNode) check(v cue.Value, opts checkOptions) (IntSet, bool) {
 	f := lookupPath(v, n.Path)
+	if opts.isUnknown(f) {
+		// Any branch with a kind that f could
+		// take on when made concrete is possible.
+		kinds := unknownKind(f)
+		var s IntSet = wordSet(0)
+		for k, sub := range n.Branches {
+			if k&kinds != 0 {
+				s1, _ := sub.check(v, opts)
+				s = union(s, s1)
+			}
+		}
+		return s, false
+	}
 	if sub, ok := n.Branches[f.Kind()]; ok {
-		return sub.Check(v)
+		return sub.check(v, opts)
 	}
-	return wordSet(0)
+	return wordSet(0), true
 }
 
 func (k *KindSwitchNode) write(w *indentWriter) {
-	w.Printf("switch kind(%v) {", k.Path)
-	for _, kind := range slices.Sorted(maps.Keys(k.Branches)) {
+	w.Printf("switch kind(%v) {", w.switchPath(k.Path, k.Optional))
+	for _, kind := range ordered(k.Branches, k.Order, cmp.Compare[cue.Kind]) {
 		node := k.Branches[kind]
 		w.Printf("case %v:", kind)
 		w.Indent()
-		node.write(w)
+		w.node(node)
 		w.Unindent()
 
 	}
@@ -92,6 +326,20 @@ type FieldAbsenceNode struct {
 	// Branches maps paths to the set of arms selected
 	// if the field at that path is known not to exist.
 	Branches map[string]IntSet
+
+	// checker holds the compiled form of Branches. It is made by
+	// newFieldAbsenceNode, so Branches should not be changed
+	// after that. It is not encoded.
+	checker *absenceChecker
+}
+
+// newFieldAbsenceNode returns a FieldAbsenceNode with the given
+// branches, compiling them once so that Check does not need to.
+func newFieldAbsenceNode(branches map[string]IntSet) *FieldAbsenceNode {
+	return &FieldAbsenceNode{
+		Branches: branches,
+		checker:  newAbsenceChecker(branches),
+	}
 }
 
 func (n *FieldAbsenceNode) Possible() IntSet {
@@ -103,24 +351,114 @@ func (n *FieldAbsenceNode) Possible() IntSet {
 }
 
 func (n *FieldAbsenceNode) Check(v cue.Value) IntSet {
-	first := true
+	s, _ := n.check(v, checkOptions{})
+	return s
+}
+
+func (n *FieldAbsenceNode) check(v cue.Value, opts checkOptions) (IntSet, bool) {
+	c := n.checker
+	if c == nil {
+		// The node was not made by newFieldAbsenceNode.
+		c = newAbsenceChecker(n.Branches)
+	}
+	// The result is arrived at by elimination, so
+	// it is never definite.
+	if s, ok := c.check(v); ok {
+		return s, false
+	}
+	// No non-existence test failed. Could be anything.
+	return n.Possible(), false
+}
+
+// absenceChecker holds a compiled form of a FieldAbsenceNode
+// that finds out which top level fields are present with a single
+// pass over the value, and intersects arm sets using
+// bit sets where possible.
+type absenceChecker struct {
+	paths []absencePath
+	// useWords holds whether all the arm sets fit into wordSets.
+	useWords bool
+}
+
+type absencePath struct {
+	path string
+	// top holds the first selector in the path.
+	top string
+	// nested holds whether the path has more than one selector.
+	nested bool
+	group  IntSet
+	words  wordSet
+}
+
+func newAbsenceChecker(branches map[string]IntSet) *absenceChecker {
+	c := &absenceChecker{
+		useWords: true,
+	}
+	for _, path := range slices.Sorted(maps.Keys(branches)) {
+		group := branches[path]
+		p := absencePath{
+			path:  path,
+			group: group,
+		}
+		if names := splitPath(path); len(names) > 0 && !isIndex(names[0]) {
+			p.top = names[0]
+			p.nested = len(names) > 1
+		}
+		for i := range group.Values() {
+			if i < 0 || i >= 64 {
+				c.useWords = false
+				break
+			}
+			p.words.add(i)
+		}
+		c.paths = append(c.paths, p)
+	}
+	return c
+}
+
+// check returns the set of arms selected by the paths
+// that are absent in v. It reports false if
+// none of the paths are absent.
+func (c *absenceChecker) check(v cue.Value) (IntSet, bool) {
+	present := make(map[string]bool)
+	if iter, err := v.Fields(cue.All()); err == nil {
+		for iter.Next() {
+			present[selectorName(iter.Selector())] = true
+		}
+	}
+	found := false
+	var words wordSet
 	var s IntSet = wordSet(0)
-	for path, group := range n.Branches {
-		if lookupPath(v, path).Exists() {
+	for _, p := range c.paths {
+		var exists bool
+		switch {
+		case p.top == "":
+			exists = lookupPath(v, p.path).Exists()
+		case present[p.top]:
+			exists = !p.nested || lookupPath(v, p.path).Exists()
+		}
+		if exists {
 			continue
 		}
-		if first {
-			s = group
-			first = false
-		} else {
-			s = intersect(s, group)
+		switch {
+		case !found && c.useWords:
+			words = p.words
+		case !found:
+			s = p.group
+		case c.useWords:
+			words &= p.words
+		default:
+			s = intersect(s, p.group)
 		}
+		found = true
 	}
-	if first {
-		// No non-existence test failed. Could be anything.
-		return n.Possible()
+	if !found {
+		return nil, false
 	}
-	return s
+	if c.useWords {
+		return words, true
+	}
+	return s, true
 }
 
 func (n *FieldAbsenceNode) write(w *indentWriter) {
@@ -128,7 +466,7 @@ func (n *FieldAbsenceNode) write(w *indentWriter) {
 	w.Indent()
 	for _, path := range slices.Sorted(maps.Keys(n.Branches)) {
 		group := n.Branches[path]
-		w.Printf("notPresent(%v) -> %s", path, SetString(group))
+		w.Printf("notPresent(%v) -> %s", w.path(path), ArmSetString(group, w.armNames))
 	}
 	w.Unindent()
 	w.Printf("}")
@@ -139,61 +477,280 @@ type ValueSwitchNode struct {
 	Path     string
 	Branches map[Atom]DecisionNode // possible concrete values -> sub-node
 	Default  DecisionNode
+
+	// Optional reports whether the field at Path is
+	// optional, so a value might not have it at all.
+	// See [Warnings].
+	Optional bool
+
+	// Implied holds the value that the field is taken to hold
+	// when it is absent and [InjectDefaults] is in effect.
+	// It is derived from the default values of the field in
+	// the arms, and is the zero Atom when there is none.
+	Implied Atom
+
+	// Enum holds how code generated from the node should find
+	// the branch for a value, as chosen by the [Enums] option.
+	// When it is [EnumAuto], the code generator chooses by
+	// calling [EnumStrategy.Choose] with the default threshold.
+	// It makes no difference to Check, which always looks
+	// values up in Branches.
+	Enum EnumStrategy
+
+	// Order holds the order in which the branches are
+	// shown and tested by generated code, as set by [ArmWeights].
+	// Values not in Order follow those that are, in
+	// their usual order. It makes no difference to Check.
+	Order []Atom
 }
 
 func (n *ValueSwitchNode) Possible() IntSet {
-	return fold(iterMap(maps.Values(n.Branches), DecisionNode.Possible), union[int])
+	s := fold(iterMap(maps.Values(n.Branches), DecisionNode.Possible), union[int])
+	if n.Default == nil {
+		return s
+	}
+	if s == nil {
+		return n.Default.Possible()
+	}
+	return union(s, n.Default.Possible())
 }
 
 func (n *ValueSwitchNode) Check(v cue.Value) IntSet {
+	s, _ := n.check(v, checkOptions{})
+	return s
+}
+
+func (n *ValueSwitchNode) check(v cue.Value, opts checkOptions) (IntSet, bool) {
 	f := lookupPath(v, n.Path)
+	if !f.Exists() && opts.injectDefaults && n.Implied.isValid() {
+		if sub, ok := n.Branches[n.Implied]; ok {
+			s, _ := sub.check(v, opts)
+			return s, false
+		}
+	}
+	if opts.isUnknown(f) {
+		// Any branch with a value that unifies with f is
+		// possible, as is the default because f might
+		// be made concrete with some other value.
+		var s IntSet = wordSet(0)
+		for a, sub := range n.Branches {
+			if f.Unify(f.Context().CompileString(a.String())).Validate() == nil {
+				s1, _ := sub.check(v, opts)
+				s = union(s, s1)
+			}
+		}
+		if n.Default != nil {
+			s1, _ := n.Default.check(v, opts)
+			s = union(s, s1)
+		}
+		return s, false
+	}
 	if f.Exists() && isAtomKind(f.Kind()) {
 		if sub, ok := n.Branches[atomForValue(f)]; ok {
-			return sub.Check(v)
+			return sub.check(v, opts)
 		}
 	}
 	if n.Default != nil {
-		return n.Default.Check(v)
+		return n.Default.check(v, opts)
 	}
-	return wordSet(0)
+	return wordSet(0), true
 }
 
 func (n *ValueSwitchNode) write(w *indentWriter) {
-	w.Printf("switch %s {", n.Path)
-	for _, val := range slices.SortedFunc(maps.Keys(n.Branches), Atom.compare) {
-		node := n.Branches[val]
-		w.Printf("case %v:", val)
+	if n.Implied.isValid() {
+		w.Printf("switch %s (absent = %v) {", w.switchPath(n.Path, n.Optional), n.Implied)
+	} else {
+		w.Printf("switch %s {", w.switchPath(n.Path, n.Optional))
+	}
+	for _, g := range n.foldBranches(w.nodeKeys()) {
+		if len(g.values) == 1 {
+			w.Printf("case %v:", g.values[0])
+		} else {
+			w.Printf("case in {%s}:", joinAtoms(g.values))
+		}
 		w.Indent()
-		node.write(w)
+		w.node(g.node)
 		w.Unindent()
 	}
 	w.Printf("default:")
 	w.Indent()
-	n.Default.write(w)
+	w.node(n.Default)
+	w.Unindent()
+	w.Printf("}")
+}
+
+// branchGroup holds a set of values in a ValueSwitchNode
+// that all lead to equivalent nodes.
+type branchGroup struct {
+	values []Atom
+	node   DecisionNode
+}
+
+// foldBranches returns the branches of n grouped so that
+// values whose nodes have the same representation are
+// in the same group. This keeps the output small for enumerations
+// where many values select the same arms. Groups are ordered by
+// their first value, and values within a group are sorted, where
+// the order is that given by n.Order if set. The nodes are compared
+// using keys, which may be nil.
+func (n *ValueSwitchNode) foldBranches(keys *nodeKeys) []branchGroup {
+	if keys == nil {
+		keys = new(nodeKeys)
+	}
+	var groups []branchGroup
+	index := make(map[int]int)
+	for _, val := range ordered(n.Branches, n.Order, Atom.compare) {
+		node := n.Branches[val]
+		key := keys.key(node)
+		if i, ok := index[key]; ok {
+			groups[i].values = append(groups[i].values, val)
+			continue
+		}
+		index[key] = len(groups)
+		groups = append(groups, branchGroup{
+			values: []Atom{val},
+			node:   node,
+		})
+	}
+	return groups
+}
+
+func joinAtoms(atoms []Atom) string {
+	var buf strings.Builder
+	for i, a := range atoms {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(a.String())
+	}
+	return buf.String()
+}
+
+// OptionalNode handles a value that may be absent, such as
+// the value of an optional field (see [Optional]). When the value
+// is absent, no arms are chosen, but unlike an [ErrorNode] that is
+// not a failure; otherwise the decision is made by Present.
+type OptionalNode struct {
+	Present DecisionNode
+}
+
+func (n *OptionalNode) Possible() IntSet {
+	return n.Present.Possible()
+}
+
+func (n *OptionalNode) Check(v cue.Value) IntSet {
+	s, _ := n.check(v, checkOptions{})
+	return s
+}
+
+func (n *OptionalNode) check(v cue.Value, opts checkOptions) (IntSet, bool) {
+	if !v.Exists() {
+		return wordSet(0), true
+	}
+	return n.Present.check(v, opts)
+}
+
+func (n *OptionalNode) write(w *indentWriter) {
+	w.Printf("if present(%s) {", w.path("."))
+	w.Indent()
+	w.node(n.Present)
+	w.Unindent()
+	w.Printf("} else {")
+	w.Indent()
+	w.Printf("absent")
 	w.Unindent()
 	w.Printf("}")
 }
 
+// TreePaths returns the set of all the paths that are
+// used to make decisions in the given tree.
+func TreePaths(n DecisionNode) Set[string] {
+	paths := make(mapSet[string])
+	addTreePaths(n, paths)
+	return paths
+}
+
+func addTreePaths(n DecisionNode, paths mapSet[string]) {
+	switch n := n.(type) {
+	case *KindSwitchNode:
+		paths[n.Path] = true
+		for _, sub := range n.Branches {
+			addTreePaths(sub, paths)
+		}
+	case *ValueSwitchNode:
+		paths[n.Path] = true
+		for _, sub := range n.Branches {
+			addTreePaths(sub, paths)
+		}
+		addTreePaths(n.Default, paths)
+	case *RangeSwitchNode:
+		paths[n.Path] = true
+		for _, b := range n.Branches {
+			addTreePaths(b.Node, paths)
+		}
+		addTreePaths(n.Default, paths)
+	case *RegexSwitchNode:
+		paths[n.Path] = true
+		for _, b := range n.Branches {
+			addTreePaths(b.Node, paths)
+		}
+		addTreePaths(n.Default, paths)
+	case *LenSwitchNode:
+		paths[n.Path] = true
+		for _, b := range n.Branches {
+			addTreePaths(b.Node, paths)
+		}
+		addTreePaths(n.Default, paths)
+	case *FieldAbsenceNode:
+		for path := range n.Branches {
+			paths[path] = true
+		}
+	case *FieldPresenceNode:
+		for _, b := range n.Branches {
+			paths[b.Path] = true
+			addTreePaths(b.Node, paths)
+		}
+		addTreePaths(n.Default, paths)
+	case *TupleSwitchNode:
+		for _, path := range n.Paths {
+			paths[path] = true
+		}
+		for _, b := range n.Branches {
+			addTreePaths(b.Node, paths)
+		}
+	case *OptionalNode:
+		addTreePaths(n.Present, paths)
+	case *GroupNode:
+		addTreePaths(n.Select, paths)
+	}
+}
+
 // isPerfect reports whether n is a "perfect" discriminator,
 // in that any given value must result in a single arm chosen
 // or an error.
-// If noAtoms is true, it's still considered "perfect" if all the chosen
-// arms are of the same atom type (it uses arms to determine that)
-func isPerfect(n DecisionNode, noAtoms bool, arms []cue.Value) bool {
+// If opts.mergeCompatible is true, it's still considered "perfect" if all the chosen
+// arms are of the same atom type (it uses arms to determine that).
+// If opts.ignoreDeprecated is true, deprecated arms in a leaf are
+// not counted.
+func isPerfect(n DecisionNode, opts options, arms []cue.Value) bool {
 	switch n := n.(type) {
 	case nil:
 		return true
 	case *LeafNode:
-		if n.Arms.Len() <= 1 {
+		chosen := n.Arms
+		if opts.ignoreDeprecated && n.Deprecated != nil && n.Deprecated.Len() > 0 {
+			chosen = without(chosen, n.Deprecated)
+		}
+		if chosen.Len() <= 1 {
 			return true
 		}
-		if !noAtoms {
+		if !opts.mergeCompatible {
 			return false
 		}
 		var k cue.Kind
-		for i := range n.Arms.Values() {
+		for i := range chosen.Values() {
 			v := arms[i]
-			vk := v.Kind()
+			vk := v.IncompleteKind()
 			if !isAtomKind(vk) {
 				return false
 			}
@@ -205,8 +762,12 @@ func isPerfect(n DecisionNode, noAtoms bool, arms []cue.Value) bool {
 		// If all the arms have the same atom kind: we're still OK.
 		return true
 	case *KindSwitchNode:
+		if n.Optional {
+			// A value without the field can't be discriminated.
+			return false
+		}
 		for _, n := range n.Branches {
-			if !isPerfect(n, noAtoms, arms) {
+			if !isPerfect(n, opts, arms) {
 				return false
 			}
 		}
@@ -214,12 +775,60 @@ func isPerfect(n DecisionNode, noAtoms bool, arms []cue.Value) bool {
 	case *FieldAbsenceNode:
 		return false
 	case *ValueSwitchNode:
+		if n.Optional {
+			return false
+		}
 		for _, n := range n.Branches {
-			if !isPerfect(n, noAtoms, arms) {
+			if !isPerfect(n, opts, arms) {
+				return false
+			}
+		}
+		return isPerfect(n.Default, opts, arms)
+	case *RangeSwitchNode:
+		if n.Optional {
+			return false
+		}
+		for _, b := range n.Branches {
+			if !isPerfect(b.Node, opts, arms) {
+				return false
+			}
+		}
+		return isPerfect(n.Default, opts, arms)
+	case *RegexSwitchNode:
+		if n.Optional {
+			return false
+		}
+		for _, b := range n.Branches {
+			if !isPerfect(b.Node, opts, arms) {
+				return false
+			}
+		}
+		return isPerfect(n.Default, opts, arms)
+	case *LenSwitchNode:
+		for _, b := range n.Branches {
+			if !isPerfect(b.Node, opts, arms) {
 				return false
 			}
 		}
-		return isPerfect(n.Default, noAtoms, arms)
+		return isPerfect(n.Default, opts, arms)
+	case *FieldPresenceNode:
+		for _, b := range n.Branches {
+			if !isPerfect(b.Node, opts, arms) {
+				return false
+			}
+		}
+		return isPerfect(n.Default, opts, arms)
+	case *TupleSwitchNode:
+		for _, b := range n.Branches {
+			if !isPerfect(b.Node, opts, arms) {
+				return false
+			}
+		}
+		return true
+	case *OptionalNode:
+		return isPerfect(n.Present, opts, arms)
+	case *GroupNode:
+		return isPerfect(n.Select, opts, arms)
 	case *ErrorNode, ErrorNode:
 		return true
 	}
@@ -229,13 +838,17 @@ func isPerfect(n DecisionNode, noAtoms bool, arms []cue.Value) bool {
 type ErrorNode struct{}
 
 func (ErrorNode) Possible() IntSet {
-	return nil
+	return wordSet(0)
 }
 
 func (ErrorNode) Check(v cue.Value) IntSet {
 	return wordSet(0)
 }
 
+func (ErrorNode) check(v cue.Value, opts checkOptions) (IntSet, bool) {
+	return wordSet(0), true
+}
+
 func (ErrorNode) write(w *indentWriter) {
 	w.Printf("error")
 }
@@ -244,6 +857,85 @@ type indentWriter struct {
 	w       io.Writer
 	indent  int
 	midline bool
+
+	// pathPrinter is used to print paths when non-nil.
+	// See [PathPrinter].
+	pathPrinter func(path string) string
+
+	// groupNames maps the string form of a set of
+	// arms to the name of its group. See [GroupNames].
+	groupNames map[string]string
+
+	// armNames holds the names used to show arms.
+	// See [WriteArmNames].
+	armNames []string
+
+	// keys holds the keys of the nodes written so far,
+	// created when needed. When shallow is true, the writer
+	// is computing the key of a node, and its children are
+	// written as their keys.
+	keys    *nodeKeys
+	shallow bool
+}
+
+// node writes n, a child of the node being written.
+func (w *indentWriter) node(n DecisionNode) {
+	if w != nil && w.shallow {
+		w.Printf("node%d", w.keys.key(n))
+		return
+	}
+	n.write(w)
+}
+
+// nodeKeys returns the keys of the nodes written by w.
+func (w *indentWriter) nodeKeys() *nodeKeys {
+	if w == nil {
+		return nil
+	}
+	if w.keys == nil {
+		w.keys = new(nodeKeys)
+	}
+	return w.keys
+}
+
+// nodeKeys gives each decision node a key such that two nodes have
+// the same key exactly when they have the same representation, as
+// written by [NodeString]. The key of a node is found from its own
+// representation with its children written as their keys, and is
+// remembered, so finding the keys of all the nodes in a tree takes
+// time proportional to its size. The nodes must not change while
+// their keys are in use.
+type nodeKeys struct {
+	byNode map[DecisionNode]int
+	byRepr map[string]int
+}
+
+// key returns the key of n.
+func (k *nodeKeys) key(n DecisionNode) int {
+	if key, ok := k.byNode[n]; ok {
+		return key
+	}
+	var buf strings.Builder
+	if n == nil {
+		buf.WriteString("<nil>")
+	} else {
+		n.write(&indentWriter{
+			w:       &buf,
+			keys:    k,
+			shallow: true,
+		})
+	}
+	key, ok := k.byRepr[buf.String()]
+	if !ok {
+		if k.byRepr == nil {
+			k.byNode = make(map[DecisionNode]int)
+			k.byRepr = make(map[string]int)
+		}
+		key = len(k.byRepr)
+		k.byRepr[buf.String()] = key
+	}
+	k.byNode[n] = key
+	return key
 }
 
 // Write implements [io.Writer]. All lines written
@@ -308,14 +1000,37 @@ func (w *indentWriter) Printf(f string, a ...any) {
 }
 
 func lookupPath(v cue.Value, path string) cue.Value {
-	if path == "." || path == "" {
-		return v
+	for _, name := range splitPath(path) {
+		v = lookupSelector(v, name)
+	}
+	return v
+}
+
+// lookupSelector returns the field in v with the given selector name,
+// as returned by selectorName.
+func lookupSelector(v cue.Value, name string) cue.Value {
+	if isIndex(name) {
+		i, err := strconv.Atoi(strings.Trim(name, "[]"))
+		if err != nil {
+			return cue.Value{}
+		}
+		return v.LookupPath(cue.MakePath(cue.Index(i)))
 	}
-	// TODO this doesn't work when a field name contains a dot.
-	parts := strings.Split(path, ".")
-	sels := make([]cue.Selector, len(parts))
-	for i, part := range parts {
-		sels[i] = cue.Str(part)
+	if !strings.HasPrefix(name, "_") {
+		return v.LookupPath(cue.ParsePath(name))
+	}
+	// Hidden labels are scoped to a package, so they can't be
+	// parsed as a path. Find the field by name instead so that
+	// the lookup works regardless of which package v was
+	// defined in.
+	iter, err := v.Fields(cue.All())
+	if err != nil {
+		return cue.Value{}
+	}
+	for iter.Next() {
+		if selectorName(iter.Selector()) == name {
+			return iter.Value()
+		}
 	}
-	return v.LookupPath(cue.MakePath(sels...))
+	return cue.Value{}
 }
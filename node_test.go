@@ -0,0 +1,97 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestNodeKeys(t *testing.T) {
+	leaf := func(arms ...int) DecisionNode {
+		return &LeafNode{Arms: setOf(arms...)}
+	}
+	sw := func(a, b DecisionNode) DecisionNode {
+		return &ValueSwitchNode{
+			Path: "x",
+			Branches: map[Atom]DecisionNode{
+				{`"a"`}: a,
+				{`"b"`}: b,
+			},
+			Default: ErrorNode{},
+		}
+	}
+	var keys nodeKeys
+	nodes := []DecisionNode{
+		leaf(0),
+		leaf(0),
+		leaf(1),
+		sw(leaf(0), leaf(1)),
+		sw(leaf(0), leaf(1)),
+		sw(leaf(1), leaf(0)),
+		ErrorNode{},
+	}
+	for _, n0 := range nodes {
+		for _, n1 := range nodes {
+			qt.Check(t, qt.Equals(keys.key(n0) == keys.key(n1), NodeString(n0) == NodeString(n1)))
+		}
+	}
+}
+
+func TestNodeStringDeepFold(t *testing.T) {
+	// Each level has two branches leading to the same subtree,
+	// so comparing the branches by their representations
+	// would take time exponential in the depth.
+	const depth = 40
+	var n DecisionNode = &LeafNode{Arms: setOf(0)}
+	for range depth {
+		n = &ValueSwitchNode{
+			Path: "x",
+			Branches: map[Atom]DecisionNode{
+				{`"a"`}: n,
+				{`"b"`}: n,
+			},
+			Default: ErrorNode{},
+		}
+	}
+	s := NodeString(n)
+	qt.Check(t, qt.Equals(strings.Count(s, `case in {"a", "b"}:`), depth))
+}
+
+func TestFieldAbsenceNodeChanged(t *testing.T) {
+	// Changing Branches after the node has been
+	// checked is reflected in later checks.
+	v := cuecontext.New().CompileString(`{a: 1}`)
+	n := &FieldAbsenceNode{
+		Branches: map[string]IntSet{
+			"b": setOf(0, 1),
+		},
+	}
+	qt.Check(t, qt.Equals(SetString(n.Check(v)), "{0, 1}"))
+	n.Branches["c"] = setOf(1, 2)
+	qt.Check(t, qt.Equals(SetString(n.Check(v)), "{1}"))
+}
+
+func TestFieldAbsenceNodeCompiledOnce(t *testing.T) {
+	// A node made by newFieldAbsenceNode, or decoded,
+	// compiles its branches once, and the compiled form
+	// makes no difference to the encoding.
+	v := cuecontext.New().CompileString(`{a: 1}`)
+	n := newFieldAbsenceNode(map[string]IntSet{
+		"b": setOf(0, 1),
+		"c": setOf(1, 2),
+	})
+	qt.Assert(t, qt.IsNotNil(n.checker))
+	qt.Check(t, qt.Equals(SetString(n.Check(v)), "{1}"))
+
+	data, err := EncodeTree(n)
+	qt.Assert(t, qt.IsNil(err))
+	n1, err := DecodeTree(data)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.IsNotNil(n1.(*FieldAbsenceNode).checker))
+	qt.Check(t, qt.Equals(SetString(n1.Check(v)), "{1}"))
+	data1, err := EncodeTree(n1)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(string(data1), string(data)))
+}
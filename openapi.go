@@ -0,0 +1,294 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/encoding/openapi"
+)
+
+// OpenAPIDiscriminator holds an OpenAPI 3 discriminator object,
+// which tells OpenAPI tools which schema in a oneOf or anyOf
+// applies to a value by looking at a single property.
+type OpenAPIDiscriminator struct {
+	// PropertyName holds the name of the property that
+	// tells the schemas apart.
+	PropertyName string `json:"propertyName"`
+
+	// Mapping maps each value of the property
+	// to a reference to the schema that it selects.
+	Mapping map[string]string `json:"mapping"`
+}
+
+// openAPISchemaPrefix holds the prefix of the references
+// to the schemas in an [OpenAPIDiscriminator] mapping.
+const openAPISchemaPrefix = "#/components/schemas/"
+
+// FindOpenAPIDiscriminator returns the OpenAPI discriminator object
+// equivalent to n, where names holds the name of each arm
+// as returned by [ArmNames].
+//
+// This is only possible when n perfectly discriminates struct arms
+// by switching on the string value of a single required top level
+// field, and every arm is named. Each arm is referred to by the
+// schema that the cue command's OpenAPI encoder produces for it,
+// so the arm #Dog is referred to as "#/components/schemas/Dog".
+// The error describes why there is no discriminator otherwise.
+func FindOpenAPIDiscriminator(n DecisionNode, names []string) (*OpenAPIDiscriminator, error) {
+	if k, ok := n.(*KindSwitchNode); ok && k.Path == "." && len(k.Branches) == 1 {
+		n = k.Branches[cue.StructKind]
+	}
+	if sw := optionalSwitch(n); sw != nil {
+		return nil, fmt.Errorf("field %s is optional", switchPath(sw))
+	}
+	sw, ok := n.(*ValueSwitchNode)
+	if !ok {
+		return nil, fmt.Errorf("arms are not told apart by the value of a single field")
+	}
+	switch sw.Default.(type) {
+	case nil, ErrorNode:
+	default:
+		return nil, fmt.Errorf("field %s does not tell all the arms apart", sw.Path)
+	}
+	if sw.Optional {
+		return nil, fmt.Errorf("field %s is optional", sw.Path)
+	}
+	names1 := splitPath(sw.Path)
+	if len(names1) != 1 || isIndex(names1[0]) || strings.HasPrefix(names1[0], "#") || strings.HasPrefix(names1[0], "_") {
+		return nil, fmt.Errorf("%s is not a top level regular field", sw.Path)
+	}
+	prop := labelName(names1[0])
+	d := &OpenAPIDiscriminator{
+		PropertyName: prop,
+		Mapping:      make(map[string]string),
+	}
+	ctx := cuecontext.New()
+	for _, val := range slices.SortedFunc(maps.Keys(sw.Branches), Atom.compare) {
+		branch := sw.Branches[val]
+		s, err := ctx.CompileString(val.String()).String()
+		if err != nil {
+			return nil, fmt.Errorf("field %s switches on non-string value %v", sw.Path, val)
+		}
+		leaf, ok := branch.(*LeafNode)
+		if !ok || leaf.Arms.Len() != 1 {
+			return nil, fmt.Errorf("value %v of field %s does not select a single arm", val, sw.Path)
+		}
+		arm := sortedInts(leaf.Arms)[0]
+		name := ""
+		if arm < len(names) {
+			name = openAPISchemaName(names[arm])
+		}
+		if name == "" {
+			return nil, fmt.Errorf("arm %d has no name", arm)
+		}
+		d.Mapping[s] = openAPISchemaPrefix + name
+	}
+	return d, nil
+}
+
+// openAPISchemaName returns the name that the cue command's OpenAPI
+// encoder gives to the schema for the CUE path p, so #A.#B is named
+// A.B, or the empty string if there is no such name.
+func openAPISchemaName(p string) string {
+	sels := cue.ParsePath(p).Selectors()
+	if len(sels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(sels))
+	for i, sel := range sels {
+		switch sel.LabelType() {
+		case cue.DefinitionLabel:
+			parts[i] = strings.TrimPrefix(sel.String(), "#")
+		case cue.StringLabel:
+			parts[i] = sel.Unquoted()
+		default:
+			return ""
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// ImportOpenAPI returns the component schemas of the OpenAPI 3
+// document in data converted to CUE as the cue command's OpenAPI
+// decoder would convert them, so that the unions in them can be
+// analyzed. The document is read as YAML when filename ends in
+// .yaml or .yml and as JSON otherwise.
+//
+// The schema at #/components/schemas/Pet becomes the definition
+// #Pet, and each oneOf or anyOf becomes a call to matchN, which
+// [Disjunctions] treats as a disjunction. A schema whose name is
+// not a valid identifier is held in #SchemaMap instead.
+func ImportOpenAPI(ctx *cue.Context, filename string, data []byte) (cue.Value, error) {
+	doc, err := decodeDocument(ctx, filename, data)
+	if err != nil {
+		return cue.Value{}, err
+	}
+	return importOpenAPI(ctx, filename, doc)
+}
+
+func importOpenAPI(ctx *cue.Context, filename string, doc cue.Value) (cue.Value, error) {
+	f, err := openapi.Extract(doc, &openapi.Config{})
+	if err != nil {
+		return cue.Value{}, fmt.Errorf("cannot convert OpenAPI document: %v", err)
+	}
+	v := ctx.BuildFile(f, cue.Filename(filename))
+	if err := v.Err(); err != nil {
+		return cue.Value{}, fmt.Errorf("cannot build converted OpenAPI document: %v", err)
+	}
+	return v, nil
+}
+
+// OpenAPIAudit holds the result of auditing the discriminator
+// of a union schema in an OpenAPI document with [AuditOpenAPI].
+type OpenAPIAudit struct {
+	// Schema holds the name of the schema
+	// within #/components/schemas.
+	Schema string `json:"schema"`
+
+	// Perfect reports whether the decision tree
+	// tells the arms of the union apart perfectly.
+	Perfect bool `json:"perfect"`
+
+	// Declared holds the discriminator object
+	// declared by the schema, if any.
+	Declared *OpenAPIDiscriminator `json:"declared,omitempty"`
+
+	// Inferred holds the discriminator object found by
+	// [FindOpenAPIDiscriminator] for the union, if any.
+	Inferred *OpenAPIDiscriminator `json:"inferred,omitempty"`
+
+	// NotInferred describes why there is no inferred
+	// discriminator object, if so.
+	NotInferred string `json:"notInferred,omitempty"`
+
+	// Problems describes the reasons that the declared
+	// discriminator is not usable, if any.
+	Problems []string `json:"problems,omitempty"`
+
+	// Result holds the result of analyzing the union.
+	Result *Result `json:"-"`
+}
+
+// Usable reports whether the union has a declared
+// discriminator that tells its arms apart.
+func (a *OpenAPIAudit) Usable() bool {
+	return len(a.Problems) == 0
+}
+
+// AuditOpenAPI analyzes each component schema of the OpenAPI 3
+// document in data that is a union, such as one defined by oneOf or
+// anyOf, read as for [ImportOpenAPI]. It checks that the union
+// declares a discriminator object whose property tells its arms apart
+// perfectly and whose mapping agrees with the decision tree, and finds
+// the discriminator object that the decision tree implies, if any, as
+// a suggestion. The audits are returned in order of schema name.
+func AuditOpenAPI(ctx *cue.Context, filename string, data []byte, opts ...Option) ([]OpenAPIAudit, error) {
+	doc, err := decodeDocument(ctx, filename, data)
+	if err != nil {
+		return nil, err
+	}
+	v, err := importOpenAPI(ctx, filename, doc)
+	if err != nil {
+		return nil, err
+	}
+	schemas := doc.LookupPath(cue.MakePath(cue.Str("components"), cue.Str("schemas")))
+	iter, err := schemas.Fields()
+	if err != nil {
+		// There are no component schemas.
+		return nil, nil
+	}
+	var audits []OpenAPIAudit
+	for iter.Next() {
+		name := iter.Selector().Unquoted()
+		sv := openAPIComponent(v, name)
+		if len(Disjunctions(sv)) < 2 {
+			continue
+		}
+		a := OpenAPIAudit{
+			Schema: name,
+			Result: DiscriminateValue(sv, opts...),
+		}
+		a.Perfect = a.Result.Perfect
+		a.Inferred, err = FindOpenAPIDiscriminator(a.Result.Tree, a.Result.Names)
+		if err != nil {
+			a.NotInferred = err.Error()
+		}
+		if dv := iter.Value().LookupPath(cue.MakePath(cue.Str("discriminator"))); dv.Exists() {
+			var d OpenAPIDiscriminator
+			if err := dv.Decode(&d); err != nil {
+				return nil, fmt.Errorf("%s: invalid discriminator: %v", name, err)
+			}
+			if d.Mapping == nil {
+				d.Mapping = make(map[string]string)
+			}
+			a.Declared = &d
+		}
+		a.Problems = openAPIProblems(a.Result, a.Declared, a.Inferred)
+		audits = append(audits, a)
+	}
+	slices.SortFunc(audits, func(a1, a2 OpenAPIAudit) int {
+		return strings.Compare(a1.Schema, a2.Schema)
+	})
+	return audits, nil
+}
+
+// openAPIComponent returns the definition that [ImportOpenAPI]
+// makes in v for the component schema with the given name.
+func openAPIComponent(v cue.Value, name string) cue.Value {
+	if p := cue.ParsePath("#" + name); p.Err() == nil {
+		if sv := v.LookupPath(p); sv.Exists() {
+			return sv
+		}
+	}
+	return v.LookupPath(cue.MakePath(cue.Def("#SchemaMap"), cue.Str(name)))
+}
+
+// openAPIProblems returns the reasons that the declared discriminator
+// object d is not usable for the union analyzed by r, given the
+// discriminator object inferred from r, which may be nil.
+func openAPIProblems(r *Result, d, inferred *OpenAPIDiscriminator) []string {
+	if d == nil {
+		return []string{"no discriminator is declared"}
+	}
+	path := cue.MakePath(cue.Str(d.PropertyName)).String()
+	if err := RequireDiscriminator(r, path); err != nil {
+		return []string{err.Error()}
+	}
+	if inferred == nil || inferred.PropertyName != d.PropertyName {
+		// The tree switches on the property but there's
+		// no inferred mapping to compare against.
+		return nil
+	}
+	var problems []string
+	for _, val := range slices.Sorted(maps.Keys(d.Mapping)) {
+		ref := openAPISchemaRef(d.Mapping[val])
+		switch got, ok := inferred.Mapping[val]; {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("mapping for %q selects no schema", val))
+		case got != ref:
+			problems = append(problems, fmt.Sprintf("mapping for %q refers to %s but the value selects %s", val, ref, got))
+		}
+	}
+	for _, val := range slices.Sorted(maps.Keys(inferred.Mapping)) {
+		// Without a mapping, a value refers
+		// to the schema with the same name.
+		if _, ok := d.Mapping[val]; !ok && inferred.Mapping[val] != openAPISchemaPrefix+val {
+			problems = append(problems, fmt.Sprintf("%q has no mapping and is not the name of the schema it selects, %s", val, inferred.Mapping[val]))
+		}
+	}
+	return problems
+}
+
+// openAPISchemaRef returns the reference to the schema
+// held in a discriminator mapping, which may also be
+// given as a bare schema name.
+func openAPISchemaRef(s string) string {
+	if strings.Contains(s, "/") {
+		return s
+	}
+	return openAPISchemaPrefix + s
+}
@@ -0,0 +1,228 @@
+package cuediscrim
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var findOpenAPIDiscriminatorTests = []struct {
+	testName string
+	cue      string
+	want     *OpenAPIDiscriminator
+	wantErr  string
+}{{
+	testName: "TaggedDefinitions",
+	cue: `
+#Dog: {petType!: "dog", bark?: bool}
+#Cat: {petType!: "cat" | "kitten", meow?: bool}
+x: #Dog | #Cat
+`,
+	want: &OpenAPIDiscriminator{
+		PropertyName: "petType",
+		Mapping: map[string]string{
+			"cat":    "#/components/schemas/Cat",
+			"dog":    "#/components/schemas/Dog",
+			"kitten": "#/components/schemas/Cat",
+		},
+	},
+}, {
+	testName: "NestedDefinitions",
+	cue: `
+#S: #A: {"pet-type"!: "a"}
+#S: #B: {"pet-type"!: "b"}
+x: #S.#A | #S.#B
+`,
+	want: &OpenAPIDiscriminator{
+		PropertyName: "pet-type",
+		Mapping: map[string]string{
+			"a": "#/components/schemas/S.A",
+			"b": "#/components/schemas/S.B",
+		},
+	},
+}, {
+	testName: "InlineArm",
+	cue: `
+#A: {type!: "a"}
+x: #A | {type!: "b"}
+`,
+	wantErr: `arm 1 has no name`,
+}, {
+	testName: "NonStringValues",
+	cue: `
+#A: {type!: 1}
+#B: {type!: 2}
+x: #A | #B
+`,
+	wantErr: `field type switches on non-string value 1`,
+}, {
+	testName: "OptionalField",
+	cue: `
+#A: {type?: "a"}
+#B: {type!: "b"}
+x: #A | #B
+`,
+	wantErr: `field type is optional`,
+}, {
+	testName: "NestedField",
+	cue: `
+#A: {meta!: kind!: "a"}
+#B: {meta!: kind!: "b"}
+x: #A | #B
+`,
+	wantErr: `meta.kind is not a top level regular field`,
+}, {
+	testName: "Kinds",
+	cue: `
+#A: {type!: "a"}
+x: #A | string
+`,
+	wantErr: `arms are not told apart by the value of a single field`,
+}, {
+	testName: "Indistinguishable",
+	cue: `
+#A: {type!: "a", x!: int, ...}
+#B: {type!: "a", y!: int, ...}
+#C: {type!: "c"}
+x: #A | #B | #C
+`,
+	wantErr: `value "a" of field type does not select a single arm`,
+}}
+
+func TestFindOpenAPIDiscriminator(t *testing.T) {
+	for _, test := range findOpenAPIDiscriminatorTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := cuecontext.New().CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			r := DiscriminateValue(v.LookupPath(cue.ParsePath("x")))
+			d, err := FindOpenAPIDiscriminator(r.Tree, r.Names)
+			if test.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, test.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(d, test.want))
+		})
+	}
+}
+
+const auditOpenAPITestDoc = `
+openapi: 3.0.3
+info: {title: Pets, version: "1"}
+paths: {}
+components:
+  schemas:
+    Pet:
+      oneOf:
+      - $ref: '#/components/schemas/Cat'
+      - $ref: '#/components/schemas/Dog'
+      discriminator:
+        propertyName: petType
+        mapping:
+          cat: '#/components/schemas/Cat'
+          dog: Dog
+    Cat:
+      type: object
+      required: [petType]
+      properties:
+        petType: {type: string, enum: [cat]}
+    Dog:
+      type: object
+      required: [petType]
+      properties:
+        petType: {type: string, enum: [dog]}
+        bark: {type: boolean}
+    WrongProperty:
+      oneOf:
+      - $ref: '#/components/schemas/Cat'
+      - $ref: '#/components/schemas/Dog'
+      discriminator:
+        propertyName: bark
+    Vehicle:
+      anyOf:
+      - $ref: '#/components/schemas/Car'
+      - $ref: '#/components/schemas/Bike'
+    MisMapped:
+      oneOf:
+      - $ref: '#/components/schemas/Car'
+      - $ref: '#/components/schemas/Bike'
+      discriminator:
+        propertyName: kind
+        mapping:
+          car: '#/components/schemas/Bike'
+          boat: '#/components/schemas/Boat'
+    Car:
+      type: object
+      required: [kind]
+      properties: {kind: {type: string, enum: [car]}}
+    Bike:
+      type: object
+      required: [kind]
+      properties: {kind: {type: string, enum: [bike]}}
+    Shape:
+      oneOf:
+      - {type: object, required: [r], properties: {r: {type: number}}, additionalProperties: false}
+      - {type: object, required: [side], properties: {side: {type: number}}, additionalProperties: false}
+`
+
+func TestAuditOpenAPI(t *testing.T) {
+	audits, err := AuditOpenAPI(cuecontext.New(), "pets.yaml", []byte(auditOpenAPITestDoc))
+	qt.Assert(t, qt.IsNil(err))
+	type audit struct {
+		Schema      string
+		Inferred    string
+		NotInferred string
+		Problems    []string
+	}
+	var got []audit
+	for _, a := range audits {
+		qt.Check(t, qt.IsTrue(a.Perfect), qt.Commentf("%s", a.Schema))
+		qt.Check(t, qt.Equals(a.Usable(), len(a.Problems) == 0))
+		a1 := audit{
+			Schema:      a.Schema,
+			NotInferred: a.NotInferred,
+			Problems:    a.Problems,
+		}
+		if a.Inferred != nil {
+			a1.Inferred = a.Inferred.PropertyName
+		}
+		got = append(got, a1)
+	}
+	qt.Check(t, qt.DeepEquals(got, []audit{{
+		Schema:   "MisMapped",
+		Inferred: "kind",
+		Problems: []string{
+			`mapping for "boat" selects no schema`,
+			`mapping for "car" refers to #/components/schemas/Bike but the value selects #/components/schemas/Car`,
+			`"bike" has no mapping and is not the name of the schema it selects, #/components/schemas/Bike`,
+		},
+	}, {
+		Schema:   "Pet",
+		Inferred: "petType",
+	}, {
+		Schema:      "Shape",
+		NotInferred: "arms are not told apart by the value of a single field",
+		Problems:    []string{"no discriminator is declared"},
+	}, {
+		Schema:   "Vehicle",
+		Inferred: "kind",
+		Problems: []string{"no discriminator is declared"},
+	}, {
+		Schema:   "WrongProperty",
+		Inferred: "petType",
+		Problems: []string{"arms are told apart by petType instead of bark"},
+	}}))
+}
+
+func TestImportOpenAPI(t *testing.T) {
+	v, err := ImportOpenAPI(cuecontext.New(), "pets.yaml", []byte(auditOpenAPITestDoc))
+	qt.Assert(t, qt.IsNil(err))
+	r := DiscriminateValue(v.LookupPath(cue.ParsePath("#Pet")))
+	qt.Check(t, qt.IsTrue(r.Perfect))
+	qt.Check(t, qt.DeepEquals(r.Names, []string{"#Cat", "#Dog"}))
+
+	_, err = ImportOpenAPI(cuecontext.New(), "bad.json", []byte(`{"components": {}}`))
+	qt.Check(t, qt.ErrorMatches(err, `cannot convert OpenAPI document: openapi field is required but not found`))
+}
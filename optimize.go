@@ -0,0 +1,440 @@
+package cuediscrim
+
+import (
+	"maps"
+
+	"cuelang.org/go/cue"
+)
+
+// Optimize returns a tree that classifies concrete values in the same
+// way as n but that is no more expensive to evaluate, as measured by
+// [TreeStats.ExpectedLookups]. The tree produced by [Discriminate]
+// follows the order in which its search found discriminators, which
+// can be needlessly deep. Optimize:
+//
+//   - removes switches whose outcome has already been decided by a
+//     switch on the same path above them, and branches that such a
+//     switch has ruled out;
+//   - replaces a switch whose branches all lead to the same node
+//     with that node;
+//   - hoists a kind switch used as the default of a value, range or
+//     pattern switch on the same path above that switch, when that
+//     makes fewer lookups.
+//
+// The result of checking a value that is not concrete (see
+// [AllowIncomplete]) may be more precise than with n.
+// The nodes of n are not changed.
+func Optimize(n DecisionNode) DecisionNode {
+	return optimize(n, nil)
+}
+
+// pathFact holds what is known about the value at a path
+// by virtue of the branch that led to a node.
+type pathFact struct {
+	// kind holds the possible kinds of the value.
+	kind cue.Kind
+	// value holds the value itself, if known.
+	value Atom
+}
+
+// withFact returns a copy of facts with the fact for path set to f.
+func withFact(facts map[string]pathFact, path string, f pathFact) map[string]pathFact {
+	facts = maps.Clone(facts)
+	if facts == nil {
+		facts = make(map[string]pathFact)
+	}
+	facts[path] = f
+	return facts
+}
+
+func optimize(n DecisionNode, facts map[string]pathFact) DecisionNode {
+	switch n := n.(type) {
+	case *KindSwitchNode:
+		fact, known := facts[n.Path]
+		n1 := *n
+		n1.Branches = make(map[cue.Kind]DecisionNode, len(n.Branches))
+		for k, sub := range n.Branches {
+			if known && k&fact.kind == 0 {
+				continue
+			}
+			n1.Branches[k] = optimize(sub, withFact(facts, n.Path, pathFact{kind: k}))
+		}
+		if known {
+			switch len(n1.Branches) {
+			case 0:
+				return ErrorNode{}
+			case 1:
+				if sub, ok := n1.Branches[fact.kind]; ok {
+					return sub
+				}
+			}
+		}
+		return &n1
+	case *ValueSwitchNode:
+		fact, known := facts[n.Path]
+		if known && fact.value.isValid() {
+			if sub, ok := n.Branches[fact.value]; ok {
+				return optimize(sub, facts)
+			}
+			return optimize(orError(n.Default), facts)
+		}
+		n1 := *n
+		n1.Branches = make(map[Atom]DecisionNode, len(n.Branches))
+		for a, sub := range n.Branches {
+			if known && a.kind()&fact.kind == 0 {
+				continue
+			}
+			if a == n.Implied {
+				// The field might be absent, so nothing is
+				// known about it in this branch.
+				n1.Branches[a] = optimize(sub, facts)
+				continue
+			}
+			n1.Branches[a] = optimize(sub, withFact(facts, n.Path, pathFact{kind: a.kind(), value: a}))
+		}
+		if !mapHasKey(n1.Branches, n1.Implied) {
+			n1.Implied = Atom{}
+		}
+		if n.Default != nil {
+			n1.Default = optimize(n.Default, facts)
+		}
+		if len(n1.Branches) == 0 {
+			return orError(n1.Default)
+		}
+		if sameOutcomes(mapValues(n1.Branches), n1.Default) {
+			return n1.Default
+		}
+		return hoistKindSwitch(&n1)
+	case *RangeSwitchNode:
+		if fact, ok := facts[n.Path]; ok && fact.kind&cue.NumberKind == 0 {
+			return optimize(orError(n.Default), facts)
+		}
+		n1 := *n
+		n1.Branches = make([]RangeBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			n1.Branches[i] = RangeBranch{
+				Interval: b.Interval,
+				Node:     optimize(b.Node, facts),
+			}
+		}
+		if n.Default != nil {
+			n1.Default = optimize(n.Default, facts)
+		}
+		if sameOutcomes(rangeNodes(n1.Branches), n1.Default) {
+			return n1.Default
+		}
+		return hoistKindSwitch(&n1)
+	case *LenSwitchNode:
+		if fact, ok := facts[n.Path]; ok && fact.kind&cue.ListKind == 0 {
+			return optimize(orError(n.Default), facts)
+		}
+		n1 := *n
+		n1.Branches = make([]RangeBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			n1.Branches[i] = RangeBranch{
+				Interval: b.Interval,
+				Node:     optimize(b.Node, facts),
+			}
+		}
+		if n.Default != nil {
+			n1.Default = optimize(n.Default, facts)
+		}
+		if sameOutcomes(rangeNodes(n1.Branches), n1.Default) {
+			return n1.Default
+		}
+		return hoistKindSwitch(&n1)
+	case *RegexSwitchNode:
+		if fact, ok := facts[n.Path]; ok && fact.kind&cue.StringKind == 0 {
+			return optimize(orError(n.Default), facts)
+		}
+		n1 := *n
+		n1.Branches = make([]RegexBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			n1.Branches[i] = RegexBranch{
+				Pattern: b.Pattern,
+				Node:    optimize(b.Node, facts),
+			}
+		}
+		if n.Default != nil {
+			n1.Default = optimize(n.Default, facts)
+		}
+		if sameOutcomes(regexNodes(n1.Branches), n1.Default) {
+			return n1.Default
+		}
+		return hoistKindSwitch(&n1)
+	case *TupleSwitchNode:
+		n1 := *n
+		n1.Branches = nil
+	branches:
+		for _, b := range n.Branches {
+			bfacts := facts
+			for i, path := range n.Paths {
+				a := b.Values[i]
+				if fact, ok := facts[path]; ok {
+					if a.kind()&fact.kind == 0 || fact.value.isValid() && fact.value != a {
+						continue branches
+					}
+				}
+				bfacts = withFact(bfacts, path, pathFact{kind: a.kind(), value: a})
+			}
+			n1.Branches = append(n1.Branches, TupleBranch{
+				Values: b.Values,
+				Node:   optimize(b.Node, bfacts),
+			})
+		}
+		if len(n1.Branches) == 0 {
+			return ErrorNode{}
+		}
+		return &n1
+	case *FieldPresenceNode:
+		n1 := *n
+		n1.Branches = nil
+		for _, b := range n.Branches {
+			b.Node = optimize(b.Node, facts)
+			if _, ok := facts[b.Path]; ok {
+				// The field is known to be present, so
+				// no later branch can be taken.
+				if len(n1.Branches) == 0 {
+					return b.Node
+				}
+				n1.Default = b.Node
+				return &n1
+			}
+			n1.Branches = append(n1.Branches, b)
+		}
+		if n.Default != nil {
+			n1.Default = optimize(n.Default, facts)
+		}
+		nodes := make([]DecisionNode, len(n1.Branches))
+		for i, b := range n1.Branches {
+			nodes[i] = b.Node
+		}
+		if sameOutcomes(nodes, n1.Default) {
+			return n1.Default
+		}
+		return &n1
+	case *OptionalNode:
+		return &OptionalNode{
+			Present: optimize(n.Present, facts),
+		}
+	case *GroupNode:
+		return &GroupNode{
+			Name:   n.Name,
+			Select: optimize(n.Select, facts),
+		}
+	}
+	return n
+}
+
+// hoistKindSwitch returns a kind switch equivalent to n when its
+// default is a kind switch on the same path and the result would make
+// fewer lookups; otherwise it returns n. Each branch of the result
+// holds the part of n that deals with values of its kind, with the
+// matching branch of the original kind switch as default.
+func hoistKindSwitch(n DecisionNode) DecisionNode {
+	var (
+		path     string
+		optional bool
+		dflt     DecisionNode
+		// kinds holds the kinds of value that n itself handles.
+		kinds cue.Kind
+		// restrict returns a copy of n that handles only values
+		// of kind k, with the given default, or nil if it would
+		// have no branches.
+		restrict func(k cue.Kind, dflt DecisionNode) DecisionNode
+	)
+	switch n := n.(type) {
+	case *ValueSwitchNode:
+		if n.Implied.isValid() {
+			// The default doesn't handle absent values.
+			return n
+		}
+		path, optional, dflt = n.Path, n.Optional, n.Default
+		for a := range n.Branches {
+			kinds |= a.kind()
+		}
+		restrict = func(k cue.Kind, dflt DecisionNode) DecisionNode {
+			n1 := *n
+			n1.Branches = make(map[Atom]DecisionNode)
+			for a, sub := range n.Branches {
+				if a.kind()&k != 0 {
+					n1.Branches[a] = sub
+				}
+			}
+			if len(n1.Branches) == 0 {
+				return nil
+			}
+			n1.Default = dflt
+			return &n1
+		}
+	case *RangeSwitchNode:
+		path, optional, dflt = n.Path, n.Optional, n.Default
+		kinds = cue.NumberKind
+		restrict = func(_ cue.Kind, dflt DecisionNode) DecisionNode {
+			n1 := *n
+			n1.Default = dflt
+			return &n1
+		}
+	case *LenSwitchNode:
+		path, dflt = n.Path, n.Default
+		kinds = cue.ListKind
+		restrict = func(_ cue.Kind, dflt DecisionNode) DecisionNode {
+			n1 := *n
+			n1.Default = dflt
+			return &n1
+		}
+	case *RegexSwitchNode:
+		path, optional, dflt = n.Path, n.Optional, n.Default
+		kinds = cue.StringKind
+		restrict = func(_ cue.Kind, dflt DecisionNode) DecisionNode {
+			n1 := *n
+			n1.Default = dflt
+			return &n1
+		}
+	default:
+		return n
+	}
+	ks, ok := dflt.(*KindSwitchNode)
+	if !ok || ks.Path != path {
+		return n
+	}
+	hoisted := &KindSwitchNode{
+		Path:     path,
+		Branches: make(map[cue.Kind]DecisionNode),
+		Optional: optional || ks.Optional,
+	}
+	all := kinds
+	for k := range ks.Branches {
+		all |= k
+	}
+	// Each bit of a kind stands for a single kind of value.
+	for k := cue.Kind(1); k <= all; k <<= 1 {
+		if k&all == 0 {
+			continue
+		}
+		sub := ks.Branches[k]
+		if k&kinds != 0 {
+			if r := restrict(k, orError(sub)); r != nil {
+				sub = r
+			}
+		}
+		if sub != nil {
+			hoisted.Branches[k] = sub
+		}
+	}
+	if expectedLookups(hoisted) < expectedLookups(n) {
+		return hoisted
+	}
+	return n
+}
+
+// orError returns n, or [ErrorNode] if n is nil.
+func orError(n DecisionNode) DecisionNode {
+	if n == nil {
+		return ErrorNode{}
+	}
+	return n
+}
+
+// sameOutcomes reports whether all of nodes are equivalent
+// to dflt, which is not nil.
+func sameOutcomes(nodes []DecisionNode, dflt DecisionNode) bool {
+	if dflt == nil {
+		return false
+	}
+	var keys nodeKeys
+	key := keys.key(dflt)
+	for _, sub := range nodes {
+		if keys.key(sub) != key {
+			return false
+		}
+	}
+	return true
+}
+
+func mapValues[K comparable, V any](m map[K]V) []V {
+	vs := make([]V, 0, len(m))
+	for _, v := range m {
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+func rangeNodes(branches []RangeBranch) []DecisionNode {
+	nodes := make([]DecisionNode, len(branches))
+	for i, b := range branches {
+		nodes[i] = b.Node
+	}
+	return nodes
+}
+
+func regexNodes(branches []RegexBranch) []DecisionNode {
+	nodes := make([]DecisionNode, len(branches))
+	for i, b := range branches {
+		nodes[i] = b.Node
+	}
+	return nodes
+}
+
+// expectedLookups returns the average number of field lookups
+// made by n to reach each of the arms it chooses, counting each
+// arm once for each leaf it appears in.
+func expectedLookups(n DecisionNode) float64 {
+	var total, count int
+	var walk func(n DecisionNode, depth int)
+	leaf := func(arms IntSet, depth int) {
+		total += depth * arms.Len()
+		count += arms.Len()
+	}
+	walk = func(n DecisionNode, depth int) {
+		switch n := n.(type) {
+		case *LeafNode:
+			leaf(n.Arms, depth)
+		case *FieldAbsenceNode:
+			leaf(n.Possible(), depth+len(n.Branches))
+		case *KindSwitchNode:
+			for _, sub := range n.Branches {
+				walk(sub, depth+1)
+			}
+		case *ValueSwitchNode:
+			for _, sub := range n.Branches {
+				walk(sub, depth+1)
+			}
+			walk(n.Default, depth+1)
+		case *RangeSwitchNode:
+			for _, b := range n.Branches {
+				walk(b.Node, depth+1)
+			}
+			walk(n.Default, depth+1)
+		case *LenSwitchNode:
+			for _, b := range n.Branches {
+				walk(b.Node, depth+1)
+			}
+			walk(n.Default, depth+1)
+		case *RegexSwitchNode:
+			for _, b := range n.Branches {
+				walk(b.Node, depth+1)
+			}
+			walk(n.Default, depth+1)
+		case *TupleSwitchNode:
+			for _, b := range n.Branches {
+				walk(b.Node, depth+len(n.Paths))
+			}
+		case *FieldPresenceNode:
+			// Each field is looked up in turn.
+			for i, b := range n.Branches {
+				walk(b.Node, depth+i+1)
+			}
+			walk(n.Default, depth+len(n.Branches))
+		case *OptionalNode:
+			walk(n.Present, depth)
+		case *GroupNode:
+			walk(n.Select, depth)
+		}
+	}
+	walk(n, 0)
+	if count == 0 {
+		return 0
+	}
+	return float64(total) / float64(count)
+}
@@ -0,0 +1,209 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var optimizeTests = []struct {
+	testName string
+	cue      string
+	// tree, if set, holds the tree to optimize in encoded form;
+	// otherwise the tree for cue is used.
+	tree        string
+	want        string
+	wantLookups float64
+}{{
+	testName: "HoistKinds",
+	cue:      `int | bool | (null | bytes) | "foo" | "bar"`,
+	want: `
+switch kind(.) {
+case null:
+	choose({2})
+case bool:
+	choose({1})
+case int:
+	choose({0})
+case string:
+	switch . {
+	case "bar":
+		choose({5})
+	case "foo":
+		choose({4})
+	default:
+		error
+	}
+case bytes:
+	choose({3})
+}
+`,
+	wantLookups: 8.0 / 6,
+}, {
+	testName: "HoistNested",
+	cue:      `int | bool | "foo" | "bar" | {type!: "a"} | {type!: "b"} | {type!: "c"}`,
+	want: `
+switch kind(.) {
+case bool:
+	choose({1})
+case int:
+	choose({0})
+case string:
+	switch . {
+	case "bar":
+		choose({3})
+	case "foo":
+		choose({2})
+	default:
+		error
+	}
+case struct:
+	switch type {
+	case "a":
+		choose({4})
+	case "b":
+		choose({5})
+	case "c":
+		choose({6})
+	default:
+		error
+	}
+}
+`,
+	wantLookups: 12.0 / 7,
+}, {
+	testName: "RangeNotHoisted",
+	cue:      `int & <0 | int & >=0 & <10 | 10 | string`,
+	want: `
+switch range(.) {
+case <0:
+	choose({0})
+case >=0 & <10:
+	choose({1})
+case 10:
+	choose({2})
+default:
+	switch kind(.) {
+	case string:
+		choose({3})
+	}
+}
+`,
+	wantLookups: 5.0 / 4,
+}, {
+	testName: "RedundantSwitches",
+	tree: `{"version": 2, "root": {
+		"type": "kindSwitch", "path": "a",
+		"branches": {
+			"string": {"type": "valueSwitch", "path": "a", "branches": [
+				{"value": "\"x\"", "node": {"type": "kindSwitch", "path": "a", "branches": {
+					"string": {"type": "leaf", "arms": [0]},
+					"int": {"type": "leaf", "arms": [1]}
+				}}},
+				{"value": "1", "node": {"type": "leaf", "arms": [1]}}
+			], "default": {"type": "leaf", "arms": [2]}},
+			"int": {"type": "regexSwitch", "path": "a", "branches": [
+				{"pattern": "^a", "node": {"type": "leaf", "arms": [0]}}
+			], "default": {"type": "leaf", "arms": [1]}}
+		}
+	}}`,
+	want: `
+switch kind(a) {
+case int:
+	choose({1})
+case string:
+	switch a {
+	case "x":
+		choose({0})
+	default:
+		choose({2})
+	}
+}
+`,
+	wantLookups: 5.0 / 3,
+}, {
+	testName: "SameOutcomes",
+	tree: `{"version": 2, "root": {
+		"type": "valueSwitch", "path": "a", "branches": [
+			{"value": "\"x\"", "node": {"type": "leaf", "arms": [0]}},
+			{"value": "\"y\"", "node": {"type": "leaf", "arms": [0]}}
+		], "default": {"type": "leaf", "arms": [0]}
+	}}`,
+	want: `
+choose({0})
+`,
+	wantLookups: 0,
+}}
+
+func TestOptimize(t *testing.T) {
+	for _, test := range optimizeTests {
+		t.Run(test.testName, func(t *testing.T) {
+			var tree DecisionNode
+			if test.tree != "" {
+				var err error
+				tree, err = DecodeTree([]byte(test.tree))
+				qt.Assert(t, qt.IsNil(err))
+			} else {
+				v := cuecontext.New().CompileString(test.cue)
+				qt.Assert(t, qt.IsNil(v.Err()))
+				tree, _, _ = Discriminate(Disjunctions(v))
+			}
+			before := NodeString(tree)
+			got := Optimize(tree)
+			qt.Check(t, qt.Equals(NodeString(got), strings.TrimPrefix(test.want, "\n")))
+			qt.Check(t, qt.Equals(Stats(got).ExpectedLookups, test.wantLookups))
+			// The original tree is unchanged.
+			qt.Check(t, qt.Equals(NodeString(tree), before))
+		})
+	}
+}
+
+func TestOptimizePreservesChecks(t *testing.T) {
+	for _, test := range buildDecisionTreeTests {
+		t.Run(test.testName, func(t *testing.T) {
+			ctx := cuecontext.New()
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			arms := Disjunctions(val)
+			tree, _, _ := Discriminate(arms)
+			opt := Optimize(tree)
+			t.Logf("optimized: %s", NodeString(opt))
+			qt.Check(t, qt.IsTrue(Stats(opt).ExpectedLookups <= Stats(tree).ExpectedLookups))
+
+			var values []cue.Value
+			for _, dtest := range test.data {
+				values = append(values, ctx.CompileString(dtest.cue))
+			}
+			for i := range arms {
+				if v, err := ArmExample(tree, arms, i); err == nil {
+					values = append(values, v)
+				}
+			}
+			for _, v := range values {
+				qt.Check(t, deepEquals(ref(opt.Check(v)), ref(tree.Check(v))), qt.Commentf("value %v", v))
+			}
+		})
+	}
+}
+
+func TestSameOutcomesDeep(t *testing.T) {
+	// As for TestNodeStringDeepFold, comparing the nodes
+	// by their representations would take time exponential
+	// in the depth.
+	var n DecisionNode = &LeafNode{Arms: setOf(0)}
+	for range 40 {
+		n = &ValueSwitchNode{
+			Path: "x",
+			Branches: map[Atom]DecisionNode{
+				{`"a"`}: n,
+				{`"b"`}: n,
+			},
+			Default: ErrorNode{},
+		}
+	}
+	qt.Check(t, qt.IsTrue(sameOutcomes([]DecisionNode{n, n}, n)))
+	qt.Check(t, qt.IsFalse(sameOutcomes([]DecisionNode{n, &LeafNode{Arms: setOf(0)}}, n)))
+}
@@ -0,0 +1,92 @@
+package cuediscrim
+
+// #Policy describes a decision tree as a list of rules,
+// as produced by Policy.CUE. A value is classified by
+// evaluating every rule: the chosen arms are all the arms
+// of the rules whose conditions all hold. No arms are
+// chosen if no rule holds.
+#Policy: {
+	// version holds the version of the policy format.
+	version!: 1
+
+	// rules holds the rules of the policy.
+	rules!: [...#Rule]
+}
+
+#Rule: {
+	// arms holds the indexes of the arms
+	// chosen when the rule holds.
+	arms!: [...int & >=0]
+
+	// conditions holds the conditions that must
+	// all hold for the rule to hold.
+	conditions!: [...#Condition]
+}
+
+// #Condition holds a test on the value at a path.
+#Condition: {
+	// path holds the path of the value to test from the
+	// root of the value being classified: a string selects
+	// a field and an integer selects a list element.
+	path!: [...(string | int & >=0)]
+
+	// test holds the kind of test.
+	test!: "kind" | "equals" | "notIn" | "inRange" | "notInRanges" | "lenInRange" | "lenNotInRanges" | "matches" | "notMatches" | "present" | "absent"
+
+	if test == "kind" {
+		// kind holds the CUE kind that the value must have.
+		// A missing value has no kind.
+		kind!: "null" | "bool" | "int" | "float" | "string" | "bytes" | "list" | "struct"
+	}
+	if test == "equals" {
+		// value holds the CUE representation of a
+		// constant that the value must equal.
+		value!: string
+	}
+	if test == "notIn" {
+		// values holds the CUE representations of
+		// constants that the value must not equal. The
+		// condition holds when the value is missing.
+		values!: [...string]
+	}
+	if test == "inRange" {
+		// range holds the CUE representation of an
+		// interval, such as ">=1 & <4", that the value
+		// must be a number in.
+		range!: string
+	}
+	if test == "notInRanges" {
+		// ranges holds the CUE representations of
+		// intervals that the value must not be a number
+		// in. The condition holds when the value is
+		// missing or is not a number.
+		ranges!: [...string]
+	}
+	if test == "lenInRange" {
+		// range holds the CUE representation of an
+		// interval, such as ">=1 & <=3", that the value
+		// must be a list whose length is in.
+		range!: string
+	}
+	if test == "lenNotInRanges" {
+		// ranges holds the CUE representations of
+		// intervals that the value must not be a list
+		// whose length is in. The condition holds when
+		// the value is missing or is not a list.
+		ranges!: [...string]
+	}
+	if test == "matches" {
+		// pattern holds a regular expression, as used by
+		// the =~ operator, that the value must be a string
+		// matched by.
+		pattern!: string
+	}
+	if test == "notMatches" {
+		// patterns holds regular expressions that the value
+		// must not be a string matched by. The condition
+		// holds when the value is missing or is not a string.
+		patterns!: [...string]
+	}
+	// For the present test, the value must exist,
+	// and for the absent test, it must not.
+}
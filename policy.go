@@ -0,0 +1,423 @@
+package cuediscrim
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+)
+
+// PolicySchema holds the CUE source of the schema for policies,
+// which defines #Policy. The result of [Policy.CUE] always
+// conforms to #Policy.
+//
+//go:embed policy.cue
+var PolicySchema string
+
+// PolicyVersion holds the version of the policy format
+// produced by [NewPolicy].
+const PolicyVersion = 1
+
+// Policy holds the decision logic of a tree as data rather than
+// code: a list of rules, each giving the arms chosen when all of its
+// conditions hold. Unlike a tree, a policy needs no knowledge of
+// the node types to interpret, so runtimes in other languages can
+// classify values by loading a policy file; [Policy.Check] is the
+// Go implementation. See [PolicySchema] for the details of the format.
+type Policy struct {
+	Version int          `json:"version"`
+	Rules   []PolicyRule `json:"rules"`
+}
+
+// PolicyRule holds a rule in a [Policy].
+type PolicyRule struct {
+	Arms       []int             `json:"arms"`
+	Conditions []PolicyCondition `json:"conditions"`
+}
+
+// PolicyCondition holds a condition in a [PolicyRule]:
+// a test on the value at Path.
+type PolicyCondition struct {
+	// Path holds the path of the value to test.
+	Path []PolicySelector `json:"path"`
+
+	// Test holds one of "kind", "equals", "notIn", "inRange",
+	// "notInRanges", "lenInRange", "lenNotInRanges", "matches",
+	// "notMatches", "present" or "absent".
+	Test string `json:"test"`
+
+	// Kind holds the kind for a "kind" test.
+	Kind string `json:"kind,omitempty"`
+
+	// Value holds the CUE representation of the
+	// constant for an "equals" test.
+	Value string `json:"value,omitempty"`
+
+	// Values holds the CUE representations of the
+	// constants for a "notIn" test.
+	Values []string `json:"values,omitempty"`
+
+	// Range holds the CUE representation of the
+	// interval for an "inRange" or "lenInRange" test,
+	// such as >=1 & <4.
+	Range string `json:"range,omitempty"`
+
+	// Ranges holds the CUE representations of the
+	// intervals for a "notInRanges" or "lenNotInRanges" test.
+	Ranges []string `json:"ranges,omitempty"`
+
+	// Pattern holds the regular expression
+	// for a "matches" test.
+	Pattern string `json:"pattern,omitempty"`
+
+	// Patterns holds the regular expressions
+	// for a "notMatches" test.
+	Patterns []string `json:"patterns,omitempty"`
+}
+
+// PolicySelector holds an element of the path in a
+// [PolicyCondition]: either a field name or a list index. It is
+// encoded as a JSON string or a non-negative JSON integer
+// respectively.
+type PolicySelector struct {
+	// Field holds the name of the field selected
+	// when IsIndex is false.
+	Field string
+
+	// Index holds the index of the list element
+	// selected when IsIndex is true.
+	Index int
+
+	IsIndex bool
+}
+
+func (s PolicySelector) MarshalJSON() ([]byte, error) {
+	if s.IsIndex {
+		return []byte(strconv.Itoa(s.Index)), nil
+	}
+	return json.Marshal(s.Field)
+}
+
+func (s *PolicySelector) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*s = PolicySelector{}
+		return json.Unmarshal(data, &s.Field)
+	}
+	i, err := strconv.Atoi(string(data))
+	if err != nil || i < 0 {
+		return fmt.Errorf("invalid policy path element %s", data)
+	}
+	*s = PolicySelector{
+		Index:   i,
+		IsIndex: true,
+	}
+	return nil
+}
+
+// NewPolicy returns a policy that classifies values in the same
+// way as n.Check. There is one rule for each leaf of n, and one for
+// each arm of a [FieldAbsenceNode].
+func NewPolicy(n DecisionNode) *Policy {
+	p := &Policy{
+		Version: PolicyVersion,
+		Rules:   []PolicyRule{},
+	}
+	p.addRules(n, []PolicyCondition{})
+	return p
+}
+
+func (p *Policy) addRules(n DecisionNode, conds []PolicyCondition) {
+	// with returns conds with c added, without
+	// sharing storage with other rules.
+	with := func(c PolicyCondition) []PolicyCondition {
+		return append(conds[:len(conds):len(conds)], c)
+	}
+	switch n := n.(type) {
+	case *LeafNode:
+		if n.Arms.Len() == 0 {
+			return
+		}
+		p.Rules = append(p.Rules, PolicyRule{
+			Arms:       sortedInts(n.Arms),
+			Conditions: slices.Clip(conds),
+		})
+	case *KindSwitchNode:
+		path := policyPath(n.Path)
+		for _, k := range slices.Sorted(maps.Keys(n.Branches)) {
+			p.addRules(n.Branches[k], with(PolicyCondition{
+				Path: path,
+				Test: "kind",
+				Kind: k.String(),
+			}))
+		}
+	case *ValueSwitchNode:
+		path := policyPath(n.Path)
+		vals := slices.SortedFunc(maps.Keys(n.Branches), Atom.compare)
+		for _, val := range vals {
+			p.addRules(n.Branches[val], with(PolicyCondition{
+				Path:  path,
+				Test:  "equals",
+				Value: val.String(),
+			}))
+		}
+		if n.Default != nil {
+			p.addRules(n.Default, with(PolicyCondition{
+				Path:   path,
+				Test:   "notIn",
+				Values: slices.Collect(iterMap(slices.Values(vals), Atom.String)),
+			}))
+		}
+	case *RangeSwitchNode:
+		path := policyPath(n.Path)
+		var ranges []string
+		for _, b := range n.Branches {
+			ranges = append(ranges, b.Interval.String())
+			p.addRules(b.Node, with(PolicyCondition{
+				Path:  path,
+				Test:  "inRange",
+				Range: b.Interval.String(),
+			}))
+		}
+		if n.Default != nil {
+			p.addRules(n.Default, with(PolicyCondition{
+				Path:   path,
+				Test:   "notInRanges",
+				Ranges: ranges,
+			}))
+		}
+	case *LenSwitchNode:
+		path := policyPath(n.Path)
+		var ranges []string
+		for _, b := range n.Branches {
+			ranges = append(ranges, b.Interval.String())
+			p.addRules(b.Node, with(PolicyCondition{
+				Path:  path,
+				Test:  "lenInRange",
+				Range: b.Interval.String(),
+			}))
+		}
+		if n.Default != nil {
+			p.addRules(n.Default, with(PolicyCondition{
+				Path:   path,
+				Test:   "lenNotInRanges",
+				Ranges: ranges,
+			}))
+		}
+	case *RegexSwitchNode:
+		// The rules are not ordered, so each branch must also
+		// rule out the patterns tested before it.
+		path := policyPath(n.Path)
+		var patterns []string
+		for _, b := range n.Branches {
+			branchConds := with(PolicyCondition{
+				Path:    path,
+				Test:    "matches",
+				Pattern: b.Pattern,
+			})
+			if len(patterns) > 0 {
+				branchConds = append(branchConds, PolicyCondition{
+					Path:     path,
+					Test:     "notMatches",
+					Patterns: slices.Clone(patterns),
+				})
+			}
+			p.addRules(b.Node, branchConds)
+			patterns = append(patterns, b.Pattern)
+		}
+		if n.Default != nil {
+			p.addRules(n.Default, with(PolicyCondition{
+				Path:     path,
+				Test:     "notMatches",
+				Patterns: patterns,
+			}))
+		}
+	case *FieldAbsenceNode:
+		// An arm is chosen unless some absent field rules it
+		// out, so it's chosen when all the fields that would
+		// rule it out are present.
+		paths := slices.Sorted(maps.Keys(n.Branches))
+		for _, arm := range slices.Sorted(n.Possible().Values()) {
+			conds := conds
+			for _, path := range paths {
+				if !n.Branches[path].Has(arm) {
+					conds = append(conds[:len(conds):len(conds)], PolicyCondition{
+						Path: policyPath(path),
+						Test: "present",
+					})
+				}
+			}
+			p.Rules = append(p.Rules, PolicyRule{
+				Arms:       []int{arm},
+				Conditions: slices.Clip(conds),
+			})
+		}
+	case *FieldPresenceNode:
+		// As for RegexSwitchNode, each branch must also
+		// rule out the fields tested before it.
+		var absent []PolicyCondition
+		for _, b := range n.Branches {
+			branchConds := append(with(PolicyCondition{
+				Path: policyPath(b.Path),
+				Test: "present",
+			}), absent...)
+			p.addRules(b.Node, branchConds)
+			absent = append(absent, PolicyCondition{
+				Path: policyPath(b.Path),
+				Test: "absent",
+			})
+		}
+		if n.Default != nil {
+			p.addRules(n.Default, append(conds[:len(conds):len(conds)], absent...))
+		}
+	case *TupleSwitchNode:
+		// A value matching no branch matches no arm,
+		// so there's no rule for the default.
+		for _, b := range n.Branches {
+			branchConds := conds[:len(conds):len(conds)]
+			for i, path := range n.Paths {
+				branchConds = append(branchConds, PolicyCondition{
+					Path:  policyPath(path),
+					Test:  "equals",
+					Value: b.Values[i].String(),
+				})
+			}
+			p.addRules(b.Node, branchConds)
+		}
+	case *OptionalNode:
+		p.addRules(n.Present, with(PolicyCondition{
+			Path: []PolicySelector{},
+			Test: "present",
+		}))
+	case *GroupNode:
+		p.addRules(n.Select, conds)
+	}
+	// An ErrorNode chooses nothing, so it has no rule.
+}
+
+// policyPath returns the tree path as a list of selectors.
+func policyPath(path string) []PolicySelector {
+	sels := []PolicySelector{}
+	for _, name := range splitPath(path) {
+		if isIndex(name) {
+			i, _ := strconv.Atoi(name[1 : len(name)-1])
+			sels = append(sels, PolicySelector{
+				Index:   i,
+				IsIndex: true,
+			})
+			continue
+		}
+		sels = append(sels, PolicySelector{
+			Field: labelName(name),
+		})
+	}
+	return sels
+}
+
+// inRanges reports whether v is a number in
+// any of the given intervals, in CUE syntax.
+func inRanges(v cue.Value, ranges ...string) bool {
+	x := numberValue(v)
+	if x == nil {
+		return false
+	}
+	for _, r := range ranges {
+		if iv, err := parseInterval(r); err == nil && iv.Contains(x) {
+			return true
+		}
+	}
+	return false
+}
+
+// lenInRanges reports whether v is a list whose length
+// is in any of the given intervals, in CUE syntax.
+func lenInRanges(v cue.Value, ranges ...string) bool {
+	x := listLength(v)
+	if x == nil {
+		return false
+	}
+	for _, r := range ranges {
+		if iv, err := parseInterval(r); err == nil && iv.Contains(x) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether v is a string
+// matched by any of the given patterns.
+func matches(v cue.Value, patterns ...string) bool {
+	if !v.Exists() || v.Kind() != cue.StringKind {
+		return false
+	}
+	s, _ := v.String()
+	for _, p := range patterns {
+		if re := compileRegexp(p); re != nil && re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// CUE returns the policy formatted as a CUE data file. The result
+// is validated against the #Policy definition in [PolicySchema].
+func (p *Policy) CUE() ([]byte, error) {
+	return encodeCUE(p, PolicySchema, "policy", "Policy")
+}
+
+// Check returns the arms that the policy chooses for v.
+func (p *Policy) Check(v cue.Value) IntSet {
+	s := make(mapSet[int])
+	for _, r := range p.Rules {
+		if !slices.ContainsFunc(r.Conditions, func(c PolicyCondition) bool {
+			return !c.holds(v)
+		}) {
+			s.addSeq(slices.Values(r.Arms))
+		}
+	}
+	return s
+}
+
+// holds reports whether the condition holds for v.
+func (c PolicyCondition) holds(v cue.Value) bool {
+	for _, sel := range c.Path {
+		if sel.IsIndex {
+			v = v.LookupPath(cue.MakePath(cue.Index(sel.Index)))
+			continue
+		}
+		name := sel.Field
+		if !strings.HasPrefix(name, "#") && !strings.HasPrefix(name, "_") {
+			name = selectorName(cue.Str(name))
+		}
+		v = lookupSelector(v, name)
+	}
+	switch c.Test {
+	case "present":
+		return v.Exists()
+	case "absent":
+		return !v.Exists()
+	case "kind":
+		return v.Exists() && v.Kind().String() == c.Kind
+	case "equals":
+		return v.Exists() && isAtomKind(v.Kind()) && atomForValue(v).String() == c.Value
+	case "notIn":
+		return !v.Exists() || !isAtomKind(v.Kind()) || !slices.Contains(c.Values, atomForValue(v).String())
+	case "inRange":
+		return inRanges(v, c.Range)
+	case "notInRanges":
+		return !inRanges(v, c.Ranges...)
+	case "lenInRange":
+		return lenInRanges(v, c.Range)
+	case "lenNotInRanges":
+		return !lenInRanges(v, c.Ranges...)
+	case "matches":
+		return matches(v, c.Pattern)
+	case "notMatches":
+		return !matches(v, c.Patterns...)
+	}
+	return false
+}
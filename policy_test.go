@@ -0,0 +1,128 @@
+package cuediscrim
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestPolicyMatchesTree(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range buildDecisionTreeTests {
+		t.Run(test.testName, func(t *testing.T) {
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			tree, _, _ := Discriminate(Disjunctions(val))
+			p := NewPolicy(tree)
+			_, err := p.CUE()
+			qt.Assert(t, qt.IsNil(err))
+			for _, dtest := range test.data {
+				data := ctx.CompileString(dtest.cue)
+				qt.Check(t, deepEquals(ref(mapSetOf(p.Check(data).Values())), ref(mapSetOf(tree.Check(data).Values()))), qt.Commentf("data %s", dtest.name))
+			}
+		})
+	}
+}
+
+func TestPolicyCUE(t *testing.T) {
+	v := cuecontext.New().CompileString(`{type!: "a", "x.y"!: int} | {type!: "b"} | [...string]`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v))
+	data, err := NewPolicy(&OptionalNode{Present: tree}).CUE()
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(string(data), strings.TrimPrefix(`
+version: 1
+rules: [{
+	arms: [2]
+	conditions: [{
+		path: []
+		test: "present"
+	}, {
+		path: []
+		test: "kind"
+		kind: "list"
+	}]
+}, {
+	arms: [0]
+	conditions: [{
+		path: []
+		test: "present"
+	}, {
+		path: []
+		test: "kind"
+		kind: "struct"
+	}, {
+		path: ["type"]
+		test:  "equals"
+		value: "\"a\""
+	}]
+}, {
+	arms: [1]
+	conditions: [{
+		path: []
+		test: "present"
+	}, {
+		path: []
+		test: "kind"
+		kind: "struct"
+	}, {
+		path: ["type"]
+		test:  "equals"
+		value: "\"b\""
+	}]
+}]
+`, "\n")))
+}
+
+func TestPolicyFieldAbsence(t *testing.T) {
+	ctx := cuecontext.New()
+	tree := &FieldAbsenceNode{
+		Branches: map[string]IntSet{
+			"a":      setOf(1, 2),
+			"b.c":    setOf(0, 2),
+			"l[0].d": setOf(0, 1),
+		},
+	}
+	p := NewPolicy(tree)
+	for _, data := range []string{
+		`{}`,
+		`{a: 1}`,
+		`{a: 1, b: c: 1}`,
+		`{b: c: 1, l: [{d: 1}]}`,
+		`{a: 1, b: c: 1, l: [{d: 1}]}`,
+	} {
+		v := ctx.CompileString(data)
+		qt.Check(t, deepEquals(ref(mapSetOf(p.Check(v).Values())), ref(mapSetOf(tree.Check(v).Values()))), qt.Commentf("data %s", data))
+	}
+}
+
+func TestPolicyJSONRoundTrip(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`[...{k!: "a"}] | [...{k!: "b", "x.y"?: int}]`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v))
+	p := NewPolicy(tree)
+	data, err := json.Marshal(p)
+	qt.Assert(t, qt.IsNil(err))
+	var p1 *Policy
+	qt.Assert(t, qt.IsNil(json.Unmarshal(data, &p1)))
+	qt.Assert(t, qt.DeepEquals(p1, p))
+	for _, data := range []string{
+		`[{k: "a"}]`,
+		`[{k: "b"}, {k: "a"}]`,
+		`[{k: "c"}]`,
+	} {
+		v := ctx.CompileString(data)
+		qt.Check(t, deepEquals(ref(mapSetOf(p1.Check(v).Values())), ref(mapSetOf(tree.Check(v).Values()))), qt.Commentf("data %s", data))
+	}
+}
+
+func TestPolicySelectorUnmarshalError(t *testing.T) {
+	for _, data := range []string{`-1`, `1.5`, `true`, `{}`} {
+		var sel PolicySelector
+		qt.Check(t, qt.ErrorMatches(json.Unmarshal([]byte(data), &sel), `invalid policy path element .*`))
+	}
+}
@@ -0,0 +1,356 @@
+package cuediscrim
+
+import (
+	"maps"
+	"strings"
+
+	"cuelang.org/go/cue"
+)
+
+// FieldPresenceNode chooses between arms by the presence of fields,
+// taking the first branch whose field exists in the value. It is
+// made when a field is required by some arms and the others can't
+// have it, either because they forbid it, as with b?: _|_, because
+// they are closed and don't allow it, or because they don't declare
+// it under the [ClosedWorld] option. Such
+// a field can only be present in values of the former, so that an
+// arm can be selected by a field it has rather than ruled out by one
+// it lacks, as a [FieldAbsenceNode] does.
+//
+// With [OptionalFields], it's also made to test for an optional
+// field before switching on its value, with a default that
+// decides between the arms without it. As a last resort, it's also
+// made for fields that are optional in the arms that declare them
+// and that the others can't have, as with mutually exclusive groups
+// of optional fields embedded in a struct alongside common fields.
+type FieldPresenceNode struct {
+	// Branches holds the branches in the order
+	// that their fields are tested.
+	Branches []PresenceBranch
+
+	// Default is used when none of the fields are present.
+	Default DecisionNode
+}
+
+// PresenceBranch holds one branch of a [FieldPresenceNode].
+type PresenceBranch struct {
+	// Path holds the path of the field.
+	Path string
+	Node DecisionNode
+}
+
+func (n *FieldPresenceNode) Possible() IntSet {
+	var s IntSet = wordSet(0)
+	for _, b := range n.Branches {
+		s = union(s, b.Node.Possible())
+	}
+	if n.Default != nil {
+		s = union(s, n.Default.Possible())
+	}
+	return s
+}
+
+func (n *FieldPresenceNode) Check(v cue.Value) IntSet {
+	s, _ := n.check(v, checkOptions{})
+	return s
+}
+
+func (n *FieldPresenceNode) check(v cue.Value, opts checkOptions) (IntSet, bool) {
+	for _, b := range n.Branches {
+		if lookupPath(v, b.Path).Exists() {
+			return b.Node.check(v, opts)
+		}
+	}
+	if n.Default != nil {
+		return n.Default.check(v, opts)
+	}
+	return wordSet(0), true
+}
+
+func (n *FieldPresenceNode) write(w *indentWriter) {
+	w.Printf("firstOf {")
+	w.Indent()
+	for _, b := range n.Branches {
+		w.Printf("present(%v) ->", w.path(b.Path))
+		w.Indent()
+		w.node(b.Node)
+		w.Unindent()
+	}
+	w.Printf("default ->")
+	w.Indent()
+	w.node(n.Default)
+	w.Unindent()
+	w.Unindent()
+	w.Printf("}")
+}
+
+// presenceDiscriminator returns a node that discriminates between
+// the selected arms by the presence of fields, as described for
+// [FieldPresenceNode], or nil if no field can be used.
+func (d *discriminator[Set]) presenceDiscriminator(arms []cue.Value, selected Set) DecisionNode {
+	var branches []PresenceBranch
+	remaining := selected
+	for path, values := range d.allFields(arms, selected, requiredLabel) {
+		if hasIndex(path) {
+			// A list element may be present in a value of an arm
+			// with an element type, even when not declared.
+			continue
+		}
+		group := d.existenceDiscriminator(values, selected)
+		// The arms that require the field are those
+		// that don't appear in group.
+		required := d.sets.make()
+		for i := range d.sets.values(selected) {
+			if !d.sets.has(group, i) {
+				d.sets.add(&required, i)
+			}
+		}
+		if d.sets.len(group) == 0 || d.sets.len(d.sets.intersect(required, remaining)) == 0 {
+			continue
+		}
+		allowed := false
+		for i := range d.sets.values(group) {
+			if d.mayHaveField(arms, i, path) {
+				allowed = true
+				break
+			}
+		}
+		if allowed {
+			d.logf(2, "field %s is allowed by other arms", path)
+			continue
+		}
+		d.logf(2, "presence of %s selects %s", path, d.setString(required))
+		branches = append(branches, PresenceBranch{
+			Path: path,
+			Node: d.discriminate(arms, required),
+		})
+		remaining = d.sets.intersect(remaining, group)
+		if d.sets.len(remaining) == 0 {
+			break
+		}
+	}
+	if len(branches) == 0 {
+		return nil
+	}
+	paths := make([]string, len(branches))
+	for i, b := range branches {
+		paths[i] = b.Path
+	}
+	d.logf(1, "chose presence of %s", strings.Join(paths, ", "))
+	n := &FieldPresenceNode{
+		Branches: branches,
+	}
+	if d.sets.len(remaining) == 0 {
+		// Every value of the arms has one of the fields.
+		n.Default = ErrorNode{}
+	} else {
+		n.Default = d.discriminate(arms, remaining)
+	}
+	return n
+}
+
+// declaredPresenceDiscriminator is like
+// [discriminator.presenceDiscriminator] but also uses fields that
+// are optional in the arms that declare them, as in a struct that
+// embeds mutually exclusive groups of optional fields alongside
+// fields common to them all:
+//
+//	#A: {a?: int, a2?: int}
+//	#B: {b?: string}
+//	#D: {
+//		common!: string
+//		{#A} | {#B}
+//	}
+//
+// Only fields that some of the selected arms declare and the others
+// can't have are used, so fields common to all the arms are passed
+// over. A value with such a field selects the arms that declare it,
+// but a value with none of them might be an instance of any arm that
+// doesn't require one, so the default is imperfect unless every arm
+// requires one of the fields. It returns nil if there is no such field.
+func (d *discriminator[Set]) declaredPresenceDiscriminator(arms []cue.Value, selected Set) DecisionNode {
+	required := make(map[string]Set)
+	for path, values := range d.allFields(arms, selected, requiredLabel) {
+		required[path] = d.existenceDiscriminator(values, selected)
+	}
+	var branches []PresenceBranch
+	remaining := selected
+	for path, values := range d.allFields(arms, selected, requiredLabel|optionalLabel) {
+		if hasIndex(path) {
+			continue
+		}
+		declared := d.sets.make()
+		for i := range d.sets.values(selected) {
+			if values[i].Exists() {
+				d.sets.add(&declared, i)
+			}
+		}
+		if d.sets.len(declared) == d.sets.len(selected) {
+			// The field is common to all the arms.
+			continue
+		}
+		allowed := false
+		for i := range d.sets.values(selected) {
+			if !d.sets.has(declared, i) && d.mayHaveField(arms, i, path) {
+				allowed = true
+				break
+			}
+		}
+		if allowed {
+			d.logf(2, "field %s is allowed by other arms", path)
+			continue
+		}
+		d.logf(2, "presence of %s selects %s", path, d.setString(declared))
+		branches = append(branches, PresenceBranch{
+			Path: path,
+			Node: d.discriminate(arms, declared),
+		})
+		if group, ok := required[path]; ok {
+			// Arms that require the field can't be
+			// instances of a value without it.
+			remaining = d.sets.intersect(remaining, group)
+		}
+	}
+	if len(branches) == 0 {
+		return nil
+	}
+	paths := make([]string, len(branches))
+	for i, b := range branches {
+		paths[i] = b.Path
+	}
+	d.logf(1, "chose presence of declared fields %s", strings.Join(paths, ", "))
+	n := &FieldPresenceNode{
+		Branches: branches,
+	}
+	switch d.sets.len(remaining) {
+	case 0:
+		n.Default = ErrorNode{}
+	case d.sets.len(selected):
+		// Nothing has been ruled out, so there's
+		// no point in trying again.
+		n.Default = d.newLeaf(selected)
+	default:
+		n.Default = d.discriminate(arms, remaining)
+	}
+	return n
+}
+
+// optionalFieldDiscriminator returns a node that discriminates
+// between the selected arms by switching on the value of a field
+// that is optional in some of them, when it's present, falling back
+// to a decision made without the field otherwise, as described for
+// [OptionalFields]. It returns nil if there is no such field.
+func (d *discriminator[Set]) optionalFieldDiscriminator(arms []cue.Value, selected Set) DecisionNode {
+	n := d.fieldDiscriminator(arms, selected, requiredLabel|optionalLabel)
+	if n == nil {
+		return nil
+	}
+	path := switchPath(n)
+	if path == "" {
+		return nil
+	}
+	// A value without the field can't be an
+	// instance of any arm that requires it.
+	without := d.sets.clone(selected)
+	for p, values := range d.allFields(arms, selected, requiredLabel) {
+		if p != path {
+			continue
+		}
+		for i := range d.sets.values(selected) {
+			if values[i].Exists() {
+				d.sets.delete(&without, i)
+			}
+		}
+		break
+	}
+	d.logf(1, "chose optional field %s with a fallback for values without it", path)
+	absent := d.absent
+	d.absent = maps.Clone(absent)
+	if d.absent == nil {
+		d.absent = make(map[string]bool)
+	}
+	d.absent[path] = true
+	defer func() {
+		d.absent = absent
+	}()
+	return &FieldPresenceNode{
+		Branches: []PresenceBranch{{
+			Path: path,
+			Node: n,
+		}},
+		Default: d.discriminate(arms, without),
+	}
+}
+
+// switchPath returns the path switched on by n, as
+// returned by [discriminator.buildDecisionFromDescriminators].
+func switchPath(n DecisionNode) string {
+	switch n := n.(type) {
+	case *KindSwitchNode:
+		return n.Path
+	case *ValueSwitchNode:
+		return n.Path
+	case *RangeSwitchNode:
+		return n.Path
+	case *RegexSwitchNode:
+		return n.Path
+	}
+	return ""
+}
+
+// optionalSwitch returns the switch taken when the field is present
+// in n, a [FieldPresenceNode] as made by
+// [discriminator.optionalFieldDiscriminator] to switch on an optional
+// field, or nil if n is not such a node.
+func optionalSwitch(n DecisionNode) DecisionNode {
+	p, ok := n.(*FieldPresenceNode)
+	if !ok || len(p.Branches) != 1 {
+		return nil
+	}
+	if b := p.Branches[0]; switchPath(b.Node) == b.Path {
+		return b.Node
+	}
+	return nil
+}
+
+// allowsField reports whether a value of v, treated as closed,
+// might have a field at path: that is, whether each element of
+// the path is declared in the struct above it, or might be allowed
+// by a pattern constraint there.
+func allowsField(v cue.Value, path string) bool {
+	for _, name := range splitPath(path) {
+		if v.IncompleteKind()&cue.StructKind == 0 {
+			return false
+		}
+		if v.LookupPath(cue.MakePath(cue.AnyString)).Exists() {
+			return true
+		}
+		f, ok := field(v, name)
+		if !ok {
+			return false
+		}
+		v = f
+	}
+	return true
+}
+
+// field returns the field in the struct v with the given
+// selector name, whatever its label type.
+func field(v cue.Value, name string) (cue.Value, bool) {
+	for label, f := range structFields(v, requiredLabel|optionalLabel|regularLabel) {
+		if label.name == name {
+			return f, true
+		}
+	}
+	return cue.Value{}, false
+}
+
+// hasIndex reports whether path holds a list index.
+func hasIndex(path string) bool {
+	for _, name := range splitPath(path) {
+		if isIndex(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package cuediscrim
+
+// The presets below bundle options that suit common uses of the
+// decision tree. Each is an ordinary [Option], so it can be combined
+// with others: options given after a preset override its settings,
+// as in
+//
+//	Analyze(arms, ForOpenAPI(), MaxDepth(2))
+//
+// The presets don't change the defaults used without them, and the
+// options that they set may be refined in later versions to suit
+// their purpose better.
+
+// ForOpenAPI returns an option that suits looking for an OpenAPI
+// discriminator with [FindOpenAPIDiscriminator]. Such a discriminator
+// can only look at a single required top level field, so only
+// fields at the top level are used ([MaxDepth] 1), and optional
+// fields are never preferred to required ones. Arms are evaluated
+// first ([EvalSimplify]) so that a tag field computed from a
+// reference can still be used, and ties between equally good tag
+// fields are broken by declaration order ([TieBreakDeclaration]) so
+// that the property chosen is the one that the schema author wrote
+// first. Arms that accept any struct cannot be described by
+// a discriminator, so they are left out with a warning ([TopArmsError]).
+func ForOpenAPI() Option {
+	return func(opts *options) {
+		opts.eval = EvalSimplify
+		opts.maxDepth = 1
+		opts.optionalFields = false
+		opts.mergeCompatible = false
+		opts.tieBreak = TieBreakDeclaration
+		opts.topArms = TopArmsError
+	}
+}
+
+// ForRuntimeRouting returns an option that suits classifying values
+// at runtime, for example to route each request to the handler for
+// its arm. Tag fields that are optional in some arms are tested as
+// soon as no required field will do ([OptionalFields]), and arms
+// that accept any value or any struct act as the fallback route
+// ([TopArmsCatchAll]). Ties are broken in favor of the shallowest field
+// ([TieBreakShallowest]) to keep lookups cheap, and each value switch
+// records the strategy chosen for its size with
+// [DefaultEnumThreshold] ([Enums]) so that all code generators treat
+// large enumerations alike.
+func ForRuntimeRouting() Option {
+	return func(opts *options) {
+		opts.eval = EvalSimplify
+		opts.optionalFields = true
+		opts.topArms = TopArmsCatchAll
+		opts.mergeCompatible = false
+		opts.tieBreak = TieBreakShallowest
+		opts.enumStrategy = EnumAuto
+		opts.enumThreshold = DefaultEnumThreshold
+		opts.chooseEnums = true
+	}
+}
+
+// ForLinting returns an option that suits checking schemas for
+// disjunctions that cannot be told apart. Arms are analyzed as written
+// ([EvalNone]) and never merged, arms marked as deprecated don't
+// count against a tree ([IgnoreDeprecated]), and arms that accept any
+// value or any struct are reported as problems ([TopArmsError]). Ties
+// are broken by declaration order ([TieBreakDeclaration]) so that the
+// trees reported follow the source.
+func ForLinting() Option {
+	return func(opts *options) {
+		opts.eval = EvalNone
+		opts.mergeCompatible = false
+		opts.ignoreDeprecated = true
+		opts.topArms = TopArmsError
+		opts.tieBreak = TieBreakDeclaration
+	}
+}
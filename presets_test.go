@@ -0,0 +1,131 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var presetTests = []struct {
+	testName     string
+	cue          string
+	opts         []Option
+	want         string
+	wantPerfect  bool
+	wantWarnings []string
+}{{
+	testName: "OpenAPITopLevelOnly",
+	cue: `#Cat | #Dog
+#Cat: {
+	meta!: kind!: "cat"
+	pet!: "cat"
+}
+#Dog: {
+	meta!: kind!: "dog"
+	pet!: "dog"
+}`,
+	opts:        []Option{ForOpenAPI()},
+	wantPerfect: true,
+	want: `
+switch pet {
+case "cat":
+	choose(#Cat)
+case "dog":
+	choose(#Dog)
+default:
+	error
+}
+`,
+}, {
+	testName: "OpenAPIOverridden",
+	cue: `#Cat | #Dog
+#Cat: {
+	meta!: kind!: "cat"
+	pet!: "cat"
+}
+#Dog: {
+	meta!: kind!: "dog"
+	pet!: "dog"
+}`,
+	opts:        []Option{ForOpenAPI(), MaxDepth(0)},
+	wantPerfect: true,
+	want: `
+switch meta.kind {
+case "cat":
+	choose(#Cat)
+case "dog":
+	choose(#Dog)
+default:
+	error
+}
+`,
+}, {
+	testName:    "RuntimeRoutingCatchAll",
+	cue:         `{type!: "a"} | {type!: "b"} | _`,
+	opts:        []Option{ForRuntimeRouting()},
+	wantPerfect: true,
+	want: `
+switch type {
+case "a":
+	choose({0})
+case "b":
+	choose({1})
+default:
+	choose({2})
+}
+`,
+}, {
+	testName:     "LintingTopArm",
+	cue:          `{type!: "a"} | {type!: "b"} | _`,
+	opts:         []Option{ForLinting()},
+	wantWarnings: []string{".: arm 2 accepts any value, so it is left out of the decision tree"},
+	want: `
+switch type {
+case "a":
+	choose({0})
+case "b":
+	choose({1})
+default:
+	error
+}
+`,
+}, {
+	testName: "LintingDeprecated",
+	cue: `#A | #OldA
+#A: {
+	type!: "a"
+	x!: int
+}
+#OldA: {
+	@deprecated(use #A)
+	type!: "a"
+	x!: >0
+}`,
+	opts:        []Option{ForLinting()},
+	wantPerfect: true,
+	want: `
+choose(#A | #OldA) deprecated(#OldA)
+`,
+}}
+
+func TestPresets(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range presetTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := ctx.CompileString("x: " + test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			v = v.LookupPath(cue.ParsePath("x"))
+			r := DiscriminateValue(v, test.opts...)
+			qt.Assert(t, qt.Equals(NodeString(r.Tree, WriteArmNames(r.Names)), strings.TrimPrefix(test.want, "\n")))
+			qt.Check(t, qt.Equals(r.Perfect, test.wantPerfect))
+			var warnings []string
+			for _, w := range r.Warnings {
+				warnings = append(warnings, w.String())
+			}
+			qt.Check(t, qt.DeepEquals(warnings, test.wantWarnings))
+		})
+	}
+}
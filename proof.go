@@ -0,0 +1,403 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SeparationReason describes how a decision tree
+// tells two arms apart.
+type SeparationReason int
+
+const (
+	// SeparatedByKind means that the arms allow
+	// different kinds of value at the path.
+	SeparatedByKind SeparationReason = iota + 1
+
+	// SeparatedByValue means that the arms allow
+	// disjoint sets of constant values at the path.
+	SeparatedByValue
+
+	// SeparatedByRange means that the arms allow
+	// disjoint intervals of numbers at the path.
+	SeparatedByRange
+
+	// SeparatedByPattern means that the arms allow strings
+	// matching different regular expressions at the path.
+	SeparatedByPattern
+
+	// SeparatedByPresence means that one arm requires the
+	// field at the path and the other does not allow it, either
+	// forbidding it, being closed without allowing it or, with
+	// [ClosedWorld], not declaring it.
+	SeparatedByPresence
+
+	// SeparatedByValues means that the arms allow disjoint
+	// combinations of constant values at several paths.
+	// The Path of the [Separation] holds the paths joined
+	// by ", ".
+	SeparatedByValues
+
+	// SeparatedByLength means that the arms allow lists
+	// with disjoint intervals of lengths at the path.
+	SeparatedByLength
+)
+
+func (r SeparationReason) String() string {
+	switch r {
+	case SeparatedByKind:
+		return "different kinds"
+	case SeparatedByValue:
+		return "disjoint values"
+	case SeparatedByRange:
+		return "disjoint ranges"
+	case SeparatedByPattern:
+		return "different patterns"
+	case SeparatedByPresence:
+		return "field presence"
+	case SeparatedByValues:
+		return "disjoint combinations"
+	case SeparatedByLength:
+		return "disjoint lengths"
+	}
+	return fmt.Sprintf("SeparationReason(%d)", int(r))
+}
+
+// Separation explains why no value can be classified as
+// both of a pair of arms.
+type Separation struct {
+	// Arms holds the pair of arms, lowest first.
+	Arms [2]int
+
+	// Path holds the path of the field that separates them.
+	Path string
+
+	// Reason holds the way in which they are separated.
+	Reason SeparationReason
+
+	// Cases holds, for each arm in Arms, the kinds, values,
+	// intervals or patterns that the arm allows at Path. The value "other" stands
+	// for any value not otherwise mentioned by the switch.
+	// For SeparatedByPresence, it holds whether the field
+	// is present, as "true" or "false".
+	Cases [2][]string
+}
+
+func (s Separation) String() string {
+	what := s.Path
+	switch s.Reason {
+	case SeparatedByKind:
+		what = fmt.Sprintf("kind(%s)", s.Path)
+	case SeparatedByRange:
+		what = fmt.Sprintf("range(%s)", s.Path)
+	case SeparatedByPattern:
+		what = fmt.Sprintf("regexp(%s)", s.Path)
+	case SeparatedByPresence:
+		what = fmt.Sprintf("present(%s)", s.Path)
+	case SeparatedByValues:
+		what = fmt.Sprintf("(%s)", s.Path)
+	case SeparatedByLength:
+		what = fmt.Sprintf("len(%s)", s.Path)
+	}
+	return fmt.Sprintf("arms %d and %d: %s is %s for arm %d but %s for arm %d (%v)",
+		s.Arms[0], s.Arms[1],
+		what,
+		strings.Join(s.Cases[0], " or "), s.Arms[0],
+		strings.Join(s.Cases[1], " or "), s.Arms[1],
+		s.Reason,
+	)
+}
+
+// Proof holds the result of [Prove].
+type Proof struct {
+	// Separations holds how each pair of arms is separated,
+	// ordered by arm. A pair may have more than one entry
+	// when the arms can be told apart in several ways
+	// depending on the value, for example when both
+	// allow int and float values but the tree separates
+	// them by different constants in each case.
+	Separations []Separation
+
+	// Unseparated holds the pairs of arms that the
+	// tree does not always tell apart.
+	Unseparated [][2]int
+}
+
+// Perfect reports whether every pair of arms is separated.
+func (p Proof) Perfect() bool {
+	return len(p.Unseparated) == 0
+}
+
+func (p Proof) String() string {
+	var buf strings.Builder
+	for _, s := range p.Separations {
+		fmt.Fprintf(&buf, "%v\n", s)
+	}
+	for _, pair := range p.Unseparated {
+		fmt.Fprintf(&buf, "arms %d and %d: not separated\n", pair[0], pair[1])
+	}
+	return buf.String()
+}
+
+// Prove explains why the decision tree n is perfect: for every
+// pair of arms that n can choose, it finds the switch in n that tells
+// them apart, and why. This answers the question "how do we know
+// that this disjunction is unambiguous?" pairwise, which the tree
+// alone does not.
+//
+// Pairs that the tree does not separate are reported in
+// [Proof.Unseparated]. That includes arms merged by
+// [MergeCompatible] and deprecated arms sharing a leaf, which
+// [Discriminate] may still consider perfect. Absence of a field
+// never separates arms because extra fields are usually allowed,
+// so a tree with a [FieldAbsenceNode] is not proved perfect;
+// neither is a switch on an optional field. Presence of a field
+// in a [FieldPresenceNode] does separate them, as the other arms
+// can't have the field.
+//
+// The number of pairs grows with the square of the number of arms.
+func Prove(n DecisionNode) Proof {
+	var p Proof
+	arms := slices.Sorted(n.Possible().Values())
+	for i, a0 := range arms {
+		for _, a1 := range arms[i+1:] {
+			seps, ok := separate(n, a0, a1)
+			if !ok {
+				p.Unseparated = append(p.Unseparated, [2]int{a0, a1})
+				continue
+			}
+			p.Separations = append(p.Separations, seps...)
+		}
+	}
+	return p
+}
+
+// separate returns how n separates arms a0 and a1,
+// both of which n can choose, and reports whether it does.
+func separate(n DecisionNode, a0, a1 int) ([]Separation, bool) {
+	switch n := n.(type) {
+	case *OptionalNode:
+		return separate(n.Present, a0, a1)
+	case *GroupNode:
+		return separate(n.Select, a0, a1)
+	case *KindSwitchNode:
+		if n.Optional {
+			return nil, false
+		}
+		var cases [2][]string
+		var both []DecisionNode
+		for _, k := range slices.Sorted(maps.Keys(n.Branches)) {
+			sub := n.Branches[k]
+			has0, has1 := sub.Possible().Has(a0), sub.Possible().Has(a1)
+			if has0 {
+				cases[0] = append(cases[0], k.String())
+			}
+			if has1 {
+				cases[1] = append(cases[1], k.String())
+			}
+			if has0 && has1 {
+				both = append(both, sub)
+			}
+		}
+		return separateWithin(both, Separation{
+			Arms:   [2]int{a0, a1},
+			Path:   n.Path,
+			Reason: SeparatedByKind,
+			Cases:  cases,
+		})
+	case *ValueSwitchNode:
+		if n.Optional {
+			return nil, false
+		}
+		var cases [2][]string
+		var both []DecisionNode
+		add := func(name string, sub DecisionNode) {
+			if sub == nil {
+				return
+			}
+			has0, has1 := sub.Possible().Has(a0), sub.Possible().Has(a1)
+			if has0 {
+				cases[0] = append(cases[0], name)
+			}
+			if has1 {
+				cases[1] = append(cases[1], name)
+			}
+			if has0 && has1 {
+				both = append(both, sub)
+			}
+		}
+		for _, val := range slices.SortedFunc(maps.Keys(n.Branches), Atom.compare) {
+			add(val.String(), n.Branches[val])
+		}
+		add("other", n.Default)
+		return separateWithin(both, Separation{
+			Arms:   [2]int{a0, a1},
+			Path:   n.Path,
+			Reason: SeparatedByValue,
+			Cases:  cases,
+		})
+	case *RangeSwitchNode:
+		if n.Optional {
+			return nil, false
+		}
+		var cases [2][]string
+		var both []DecisionNode
+		add := func(name string, sub DecisionNode) {
+			if sub == nil {
+				return
+			}
+			has0, has1 := sub.Possible().Has(a0), sub.Possible().Has(a1)
+			if has0 {
+				cases[0] = append(cases[0], name)
+			}
+			if has1 {
+				cases[1] = append(cases[1], name)
+			}
+			if has0 && has1 {
+				both = append(both, sub)
+			}
+		}
+		for _, b := range n.Branches {
+			add(b.Interval.String(), b.Node)
+		}
+		add("other", n.Default)
+		return separateWithin(both, Separation{
+			Arms:   [2]int{a0, a1},
+			Path:   n.Path,
+			Reason: SeparatedByRange,
+			Cases:  cases,
+		})
+	case *LenSwitchNode:
+		var cases [2][]string
+		var both []DecisionNode
+		add := func(name string, sub DecisionNode) {
+			if sub == nil {
+				return
+			}
+			has0, has1 := sub.Possible().Has(a0), sub.Possible().Has(a1)
+			if has0 {
+				cases[0] = append(cases[0], name)
+			}
+			if has1 {
+				cases[1] = append(cases[1], name)
+			}
+			if has0 && has1 {
+				both = append(both, sub)
+			}
+		}
+		for _, b := range n.Branches {
+			add(b.Interval.String(), b.Node)
+		}
+		add("other", n.Default)
+		return separateWithin(both, Separation{
+			Arms:   [2]int{a0, a1},
+			Path:   n.Path,
+			Reason: SeparatedByLength,
+			Cases:  cases,
+		})
+	case *RegexSwitchNode:
+		if n.Optional {
+			return nil, false
+		}
+		var cases [2][]string
+		var both []DecisionNode
+		add := func(name string, sub DecisionNode) {
+			if sub == nil {
+				return
+			}
+			has0, has1 := sub.Possible().Has(a0), sub.Possible().Has(a1)
+			if has0 {
+				cases[0] = append(cases[0], name)
+			}
+			if has1 {
+				cases[1] = append(cases[1], name)
+			}
+			if has0 && has1 {
+				both = append(both, sub)
+			}
+		}
+		// Each string is handled by the first branch whose pattern
+		// matches it, so the branches separate like cases of a switch.
+		for _, b := range n.Branches {
+			add("=~"+strconv.Quote(b.Pattern), b.Node)
+		}
+		add("other", n.Default)
+		return separateWithin(both, Separation{
+			Arms:   [2]int{a0, a1},
+			Path:   n.Path,
+			Reason: SeparatedByPattern,
+			Cases:  cases,
+		})
+	case *FieldPresenceNode:
+		// The fields of the branches before the first that can
+		// choose either arm are not allowed by either, so that
+		// branch is the one taken by values of both.
+		for _, b := range n.Branches {
+			has0, has1 := b.Node.Possible().Has(a0), b.Node.Possible().Has(a1)
+			if has0 && has1 {
+				return separate(b.Node, a0, a1)
+			}
+			if has0 || has1 {
+				return []Separation{{
+					Arms:   [2]int{a0, a1},
+					Path:   b.Path,
+					Reason: SeparatedByPresence,
+					Cases:  [2][]string{{strconv.FormatBool(has0)}, {strconv.FormatBool(has1)}},
+				}}, true
+			}
+		}
+		return separate(n.Default, a0, a1)
+	case *TupleSwitchNode:
+		var cases [2][]string
+		var both []DecisionNode
+		for _, b := range n.Branches {
+			has0, has1 := b.Node.Possible().Has(a0), b.Node.Possible().Has(a1)
+			name := "(" + joinAtoms(b.Values) + ")"
+			if has0 {
+				cases[0] = append(cases[0], name)
+			}
+			if has1 {
+				cases[1] = append(cases[1], name)
+			}
+			if has0 && has1 {
+				both = append(both, b.Node)
+			}
+		}
+		return separateWithin(both, Separation{
+			Arms:   [2]int{a0, a1},
+			Path:   strings.Join(n.Paths, ", "),
+			Reason: SeparatedByValues,
+			Cases:  cases,
+		})
+	}
+	// A leaf choosing both arms, or a FieldAbsenceNode,
+	// which can only rule arms out.
+	return nil, false
+}
+
+// separateWithin returns sep if no branch of a switch can
+// choose both its arms; otherwise each of the branches in both
+// must separate them.
+func separateWithin(both []DecisionNode, sep Separation) ([]Separation, bool) {
+	if len(both) == 0 {
+		return []Separation{sep}, true
+	}
+	var seps []Separation
+	for _, sub := range both {
+		subSeps, ok := separate(sub, sep.Arms[0], sep.Arms[1])
+		if !ok {
+			return nil, false
+		}
+		for _, s := range subSeps {
+			if !slices.ContainsFunc(seps, func(s1 Separation) bool {
+				return s1.String() == s.String()
+			}) {
+				seps = append(seps, s)
+			}
+		}
+	}
+	return seps, true
+}
@@ -0,0 +1,87 @@
+package cuediscrim
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var proveTests = []struct {
+	testName string
+	cue      string
+	opts     []Option
+	want     string
+}{{
+	testName: "KindsAndValues",
+	cue:      `string | int | {t!: "a"} | {t!: "b"}`,
+	want: `
+arms 0 and 1: kind(.) is string for arm 0 but int for arm 1 (different kinds)
+arms 0 and 2: kind(.) is string for arm 0 but struct for arm 2 (different kinds)
+arms 0 and 3: kind(.) is string for arm 0 but struct for arm 3 (different kinds)
+arms 1 and 2: kind(.) is int for arm 1 but struct for arm 2 (different kinds)
+arms 1 and 3: kind(.) is int for arm 1 but struct for arm 3 (different kinds)
+arms 2 and 3: t is "a" for arm 2 but "b" for arm 3 (disjoint values)
+`,
+}, {
+	testName: "SeveralKinds",
+	cue:      `number | "x"`,
+	want: `
+arms 0 and 1: kind(.) is int or float for arm 0 but string for arm 1 (different kinds)
+`,
+}, {
+	testName: "FoldedValues",
+	cue:      `{t!: "a" | "b"} | {t!: "c"}`,
+	want: `
+arms 0 and 1: t is "a" or "b" for arm 0 but "c" for arm 1 (disjoint values)
+`,
+}, {
+	testName: "Absence",
+	cue:      `{a!: int} | {b!: string}`,
+	want: `
+arms 0 and 1: not separated
+`,
+}, {
+	testName: "Presence",
+	cue:      `{a!: int, t!: "x"} | {a!: int, t!: "y"} | {b!: string}`,
+	opts:     []Option{ClosedWorld(true)},
+	want: `
+arms 0 and 1: t is "x" for arm 0 but "y" for arm 1 (disjoint values)
+arms 0 and 2: present(a) is true for arm 0 but false for arm 2 (field presence)
+arms 1 and 2: present(a) is true for arm 1 but false for arm 2 (field presence)
+`,
+}, {
+	testName: "Tuple",
+	cue:      `{a!: "foo", b!: true} | {a!: "foo", b!: false} | {a!: "bar", b!: true}`,
+	want: `
+arms 0 and 1: (a, b) is ("foo", true) for arm 0 but ("foo", false) for arm 1 (disjoint combinations)
+arms 0 and 2: (a, b) is ("foo", true) for arm 0 but ("bar", true) for arm 2 (disjoint combinations)
+arms 1 and 2: (a, b) is ("foo", false) for arm 1 but ("bar", true) for arm 2 (disjoint combinations)
+`,
+}}
+
+func TestProve(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range proveTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			tree, _, isPerfect := Discriminate(Disjunctions(v), test.opts...)
+			t.Logf("tree: %s", NodeString(tree))
+			p := Prove(tree)
+			qt.Check(t, qt.Equals(p.Perfect(), isPerfect))
+			qt.Check(t, qt.Equals(p.String(), test.want[1:]))
+		})
+	}
+}
+
+func TestProveMerged(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`string | =~"a" | {x!: int}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, isPerfect := Discriminate(Disjunctions(v), MergeCompatible(true))
+	qt.Assert(t, qt.IsTrue(isPerfect))
+	p := Prove(tree)
+	qt.Check(t, qt.IsFalse(p.Perfect()))
+	qt.Check(t, qt.DeepEquals(p.Unseparated, [][2]int{{0, 1}}))
+}
@@ -0,0 +1,213 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+)
+
+// ProtoOneof holds a suggested protobuf oneof layout for
+// a disjunction, as returned by [SuggestProtoOneof].
+type ProtoOneof struct {
+	// Message holds the name of the message holding the oneof.
+	Message string
+
+	// Name holds the name of the oneof: the name of the
+	// field that tells the arms apart, if there is one.
+	Name string
+
+	// TagPath holds the path of the field whose values
+	// tell the arms apart, or the empty string if there
+	// is no such field.
+	TagPath string
+
+	// Fields holds a field for each arm, in arm order.
+	Fields []ProtoOneofField
+}
+
+// ProtoOneofField holds the suggested oneof field for an arm.
+type ProtoOneofField struct {
+	// Arm holds the index of the arm.
+	Arm int
+
+	// Name holds the name of the field.
+	Name string
+
+	// Type holds the type of the field: a scalar type for an arm
+	// of a scalar kind such as string, and otherwise the name
+	// of a message to be declared for the arm.
+	Type string
+
+	// Number holds the field number.
+	Number int
+
+	// Tags holds the values of the field at TagPath
+	// that select the arm, in CUE syntax.
+	Tags []string
+}
+
+// SuggestProtoOneof suggests how the disjunction analyzed in r
+// could be represented as a protobuf oneof within a message with the
+// given name, for those migrating unions defined in CUE to protobuf.
+//
+// The field that tells the most arms apart by its value becomes
+// the name of the oneof, and the field for each arm is named
+// after the value of that field that selects it, such as circle for
+// type: "circle". Arms that are not selected by a single string value
+// are named after the arm itself, using r.Names, and otherwise
+// by their index. Fields are numbered from 1 in arm order.
+func SuggestProtoOneof(r *Result, message string) *ProtoOneof {
+	o := &ProtoOneof{
+		Message: message,
+		Name:    "value",
+	}
+	// Choose the field whose values select the most arms.
+	var tags goTagValueSet
+	best := 0
+	for _, f := range goTagValues(r.Tree) {
+		n := 0
+		for _, arms := range f.arms {
+			if arms != nil && arms.Len() == 1 {
+				n++
+			}
+		}
+		if n > best {
+			tags, best = f, n
+		}
+	}
+	armTags := make(map[int][]Atom)
+	if best > 0 {
+		o.TagPath = tags.path
+		names := splitPath(tags.path)
+		if name := snakeName(labelName(names[len(names)-1])); name != "" {
+			o.Name = name
+		}
+		for _, a := range tags.values {
+			if arms := tags.arms[a]; arms != nil && arms.Len() == 1 {
+				arm := sortedInts(arms)[0]
+				armTags[arm] = append(armTags[arm], a)
+			}
+		}
+	}
+	ctx := cuecontext.New()
+	used := make(map[string]bool)
+	unique := func(name string) string {
+		name1 := name
+		for i := 2; used[name1]; i++ {
+			name1 = fmt.Sprintf("%s_%d", name, i)
+		}
+		used[name1] = true
+		return name1
+	}
+	for arm, v := range r.Arms {
+		f := ProtoOneofField{
+			Arm:    arm,
+			Number: arm + 1,
+		}
+		label := ""
+		if arm < len(r.Names) {
+			label = identForPath(r.Names[arm])
+		}
+		for _, a := range armTags[arm] {
+			f.Tags = append(f.Tags, a.String())
+			if f.Name != "" {
+				continue
+			}
+			if s, err := ctx.CompileString(a.String()).String(); err == nil {
+				f.Name = snakeName(s)
+			}
+		}
+		if f.Name == "" {
+			f.Name = snakeName(label)
+		}
+		if f.Name == "" {
+			f.Name = fmt.Sprintf("arm%d", arm)
+		}
+		f.Name = unique(f.Name)
+		f.Type = protoScalarType(v.IncompleteKind())
+		if f.Type == "" {
+			f.Type = label
+		}
+		if f.Type == "" {
+			f.Type = message + camelName(f.Name)
+		}
+		o.Fields = append(o.Fields, f)
+	}
+	return o
+}
+
+// Proto returns the oneof as protobuf source, as in:
+//
+//	message Shape {
+//	  oneof type {
+//	    // type: "circle"
+//	    Circle circle = 1;
+//	  }
+//	}
+//
+// The messages for the arms are not declared.
+func (o *ProtoOneof) Proto() []byte {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "message %s {\n", o.Message)
+	fmt.Fprintf(&buf, "  oneof %s {\n", o.Name)
+	for _, f := range o.Fields {
+		if len(f.Tags) > 0 {
+			fmt.Fprintf(&buf, "    // %s: %s\n", o.TagPath, strings.Join(f.Tags, " | "))
+		}
+		fmt.Fprintf(&buf, "    %s %s = %d;\n", f.Type, f.Name, f.Number)
+	}
+	fmt.Fprintf(&buf, "  }\n}\n")
+	return []byte(buf.String())
+}
+
+// protoScalarType returns the protobuf scalar type for values
+// of kind k, or the empty string if there is none.
+func protoScalarType(k cue.Kind) string {
+	switch k {
+	case cue.StringKind:
+		return "string"
+	case cue.BytesKind:
+		return "bytes"
+	case cue.BoolKind:
+		return "bool"
+	case cue.IntKind:
+		return "int64"
+	case cue.FloatKind, cue.NumberKind:
+		return "double"
+	}
+	return ""
+}
+
+// snakeName returns name in snake case, as used for protobuf
+// field names, so OtherShape and other-shape both become
+// other_shape. It returns the empty string if the result
+// would not start with a letter.
+func snakeName(name string) string {
+	var buf strings.Builder
+	sep := false
+	prev := rune(0)
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			sep = buf.Len() > 0
+			prev = 0
+			continue
+		}
+		if unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) {
+			sep = true
+		}
+		if sep {
+			buf.WriteByte('_')
+			sep = false
+		}
+		buf.WriteRune(unicode.ToLower(r))
+		prev = r
+	}
+	name = buf.String()
+	if name == "" || !unicode.IsLetter(rune(name[0])) {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,99 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var suggestProtoOneofTests = []struct {
+	testName string
+	cue      string
+	want     string
+}{{
+	testName: "TaggedDefinitions",
+	cue: `
+#Circle: {type!: "circle", r!: number}
+#Square: {type!: "square" | "box", side!: number}
+x: #Circle | #Square | {type!: "other-shape"} | {type!: "123"} | string
+`,
+	want: `
+message Shape {
+  oneof type {
+    // type: "circle"
+    Circle circle = 1;
+    // type: "box" | "square"
+    Square box = 2;
+    // type: "other-shape"
+    ShapeOtherShape other_shape = 3;
+    // type: "123"
+    ShapeArm3 arm3 = 4;
+    string arm4 = 5;
+  }
+}
+`,
+}, {
+	testName: "NoTagField",
+	cue: `
+#TextMessage: {text!: string}
+x: #TextMessage | int | [...string]
+`,
+	want: `
+message Shape {
+  oneof value {
+    TextMessage text_message = 1;
+    int64 arm1 = 2;
+    ShapeArm2 arm2 = 3;
+  }
+}
+`,
+}, {
+	testName: "QuotedTagField",
+	cue: `
+x: {"Kind-Name"!: "a"} | {"Kind-Name"!: "a"+"a"}
+`,
+	want: `
+message Shape {
+  oneof kind_name {
+    // "Kind-Name": "a"
+    ShapeA a = 1;
+    // "Kind-Name": "aa"
+    ShapeAa aa = 2;
+  }
+}
+`,
+}}
+
+func TestSuggestProtoOneof(t *testing.T) {
+	for _, test := range suggestProtoOneofTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := cuecontext.New().CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			r := DiscriminateValue(v.LookupPath(cue.ParsePath("x")))
+			o := SuggestProtoOneof(r, "Shape")
+			qt.Check(t, qt.Equals(string(o.Proto()), strings.TrimPrefix(test.want, "\n")))
+		})
+	}
+}
+
+var snakeNameTests = []struct {
+	name string
+	want string
+}{
+	{"OtherShape", "other_shape"},
+	{"other-shape", "other_shape"},
+	{"HTTPServer", "httpserver"},
+	{"v2Thing", "v2_thing"},
+	{"--a--b--", "a_b"},
+	{"123", ""},
+	{"", ""},
+}
+
+func TestSnakeName(t *testing.T) {
+	for _, test := range snakeNameTests {
+		qt.Check(t, qt.Equals(snakeName(test.name), test.want), qt.Commentf("name %q", test.name))
+	}
+}
@@ -0,0 +1,426 @@
+package cuediscrim
+
+import (
+	"math/big"
+	"slices"
+
+	"cuelang.org/go/cue"
+)
+
+// RangeSwitchNode switches on the numeric value at a path,
+// choosing the branch whose interval holds the value. It is used
+// when arms are told apart by their bounds, as with >5 | <=0,
+// rather than by their kinds or concrete values.
+type RangeSwitchNode struct {
+	Path string
+
+	// Branches holds a branch for each of a set of
+	// disjoint intervals, in ascending order.
+	Branches []RangeBranch
+
+	// Default is used when the value is not a number
+	// or lies outside all the intervals.
+	Default DecisionNode
+
+	// Optional reports whether the field at Path is
+	// optional, so a value might not have it at all.
+	// See [Warnings].
+	Optional bool
+}
+
+// RangeBranch holds one branch of a [RangeSwitchNode].
+type RangeBranch struct {
+	Interval Interval
+	Node     DecisionNode
+}
+
+// Interval represents the set of numbers between two bounds.
+type Interval struct {
+	Min, Max Bound
+}
+
+// Bound represents one end of an [Interval].
+type Bound struct {
+	// Value holds the bound in CUE syntax, such as 5 or 1.5,
+	// or the empty string when the interval is unbounded
+	// at that end.
+	Value string
+
+	// Inclusive reports whether Value itself is in the interval.
+	Inclusive bool
+}
+
+// String returns the interval in CUE syntax, such as >=1 & <4,
+// or 3 when the interval holds a single number.
+func (iv Interval) String() string {
+	lo, hi := iv.Min, iv.Max
+	switch {
+	case lo.Value == "" && hi.Value == "":
+		return "number"
+	case lo.Value == "":
+		return hi.upper()
+	case hi.Value == "":
+		return lo.lower()
+	case lo.Value == hi.Value && lo.Inclusive && hi.Inclusive:
+		return lo.Value
+	}
+	return lo.lower() + " & " + hi.upper()
+}
+
+func (b Bound) lower() string {
+	if b.Inclusive {
+		return ">=" + b.Value
+	}
+	return ">" + b.Value
+}
+
+func (b Bound) upper() string {
+	if b.Inclusive {
+		return "<=" + b.Value
+	}
+	return "<" + b.Value
+}
+
+// Contains reports whether x is in the interval.
+func (iv Interval) Contains(x *big.Rat) bool {
+	if r := iv.Min.rat(); r != nil {
+		if c := x.Cmp(r); c < 0 || (c == 0 && !iv.Min.Inclusive) {
+			return false
+		}
+	}
+	if r := iv.Max.rat(); r != nil {
+		if c := x.Cmp(r); c > 0 || (c == 0 && !iv.Max.Inclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+// isEmpty reports whether the interval holds no numbers.
+func (iv Interval) isEmpty() bool {
+	lo, hi := iv.Min.rat(), iv.Max.rat()
+	if lo == nil || hi == nil {
+		return false
+	}
+	c := lo.Cmp(hi)
+	return c > 0 || (c == 0 && !(iv.Min.Inclusive && iv.Max.Inclusive))
+}
+
+// intersect returns the numbers in both iv and iv1.
+// The result might be empty.
+func (iv Interval) intersect(iv1 Interval) Interval {
+	return Interval{
+		Min: tighterBound(iv.Min, iv1.Min, 1),
+		Max: tighterBound(iv.Max, iv1.Max, -1),
+	}
+}
+
+// coveredBy reports whether every number in iv is in one of
+// ivs, which are disjoint and in ascending order.
+func (iv Interval) coveredBy(ivs []Interval) bool {
+	for _, iv1 := range ivs {
+		if iv.isEmpty() {
+			return true
+		}
+		if iv.intersect(iv1).isEmpty() {
+			continue
+		}
+		if tighterBound(iv.Min, iv1.Min, 1) != iv.Min {
+			// iv1 leaves out the lowest numbers of iv.
+			return false
+		}
+		if tighterBound(iv.Max, iv1.Max, -1) == iv.Max {
+			return true
+		}
+		// The numbers above iv1 remain.
+		iv.Min = Bound{
+			Value:     iv1.Max.Value,
+			Inclusive: !iv1.Max.Inclusive,
+		}
+	}
+	return iv.isEmpty()
+}
+
+// tighterBound returns whichever of the bounds b0 and b1
+// excludes more numbers, where dir is 1 for lower bounds
+// and -1 for upper bounds.
+func tighterBound(b0, b1 Bound, dir int) Bound {
+	r0, r1 := b0.rat(), b1.rat()
+	switch {
+	case r0 == nil:
+		return b1
+	case r1 == nil:
+		return b0
+	}
+	switch c := r0.Cmp(r1) * dir; {
+	case c > 0:
+		return b0
+	case c < 0:
+		return b1
+	}
+	b0.Inclusive = b0.Inclusive && b1.Inclusive
+	return b0
+}
+
+// rat returns the value of b, or nil if it is unbounded.
+func (b Bound) rat() *big.Rat {
+	if b.Value == "" {
+		return nil
+	}
+	r, ok := new(big.Rat).SetString(b.Value)
+	if !ok {
+		// Not a number, which can only happen for
+		// a hand-made interval; treat it as unbounded.
+		return nil
+	}
+	return r
+}
+
+func (n *RangeSwitchNode) Possible() IntSet {
+	var s IntSet = wordSet(0)
+	for _, b := range n.Branches {
+		s = union(s, b.Node.Possible())
+	}
+	if n.Default != nil {
+		s = union(s, n.Default.Possible())
+	}
+	return s
+}
+
+func (n *RangeSwitchNode) Check(v cue.Value) IntSet {
+	s, _ := n.check(v, checkOptions{})
+	return s
+}
+
+func (n *RangeSwitchNode) check(v cue.Value, opts checkOptions) (IntSet, bool) {
+	f := lookupPath(v, n.Path)
+	if opts.isUnknown(f) {
+		// Any branch whose interval overlaps the numbers
+		// that f allows is possible, as is the default
+		// because f might not be a number at all.
+		var s IntSet = wordSet(0)
+		for _, b := range n.Branches {
+			for _, iv := range numberIntervals(f) {
+				if !b.Interval.intersect(iv).isEmpty() {
+					s1, _ := b.Node.check(v, opts)
+					s = union(s, s1)
+					break
+				}
+			}
+		}
+		if n.Default != nil {
+			s1, _ := n.Default.check(v, opts)
+			s = union(s, s1)
+		}
+		return s, false
+	}
+	if x := numberValue(f); x != nil {
+		for _, b := range n.Branches {
+			if b.Interval.Contains(x) {
+				return b.Node.check(v, opts)
+			}
+		}
+	}
+	if n.Default != nil {
+		return n.Default.check(v, opts)
+	}
+	return wordSet(0), true
+}
+
+func (n *RangeSwitchNode) write(w *indentWriter) {
+	w.Printf("switch range(%v) {", w.switchPath(n.Path, n.Optional))
+	for _, b := range n.Branches {
+		w.Printf("case %v:", b.Interval)
+		w.Indent()
+		w.node(b.Node)
+		w.Unindent()
+	}
+	w.Printf("default:")
+	w.Indent()
+	w.node(n.Default)
+	w.Unindent()
+	w.Printf("}")
+}
+
+// numberValue returns the value of f if it is
+// a concrete number, or nil otherwise.
+func numberValue(f cue.Value) *big.Rat {
+	if !f.Exists() || f.Kind()&cue.NumberKind == 0 {
+		return nil
+	}
+	return Bound{Value: atomForValue(f).String()}.rat()
+}
+
+// numberIntervals returns intervals that between them hold all the
+// numbers allowed by v, or nil if v allows no numbers. The result
+// errs on the side of allowing too much: a constraint that isn't
+// a bound, such as !=3, is taken to allow any number.
+func numberIntervals(v cue.Value) []Interval {
+	if v.IncompleteKind()&cue.NumberKind == 0 {
+		return nil
+	}
+	if a := atomForValue(v); a.isValid() {
+		b := Bound{
+			Value:     a.String(),
+			Inclusive: true,
+		}
+		return []Interval{{Min: b, Max: b}}
+	}
+	op, args := v.Expr()
+	if op == cue.NoOp {
+		// The bounds might be embedded in a struct, as
+		// with {>5, #doc: "x"}. Evaluating the value
+		// exposes them.
+		op, args = v.Eval().Expr()
+	}
+	switch op {
+	case cue.OrOp:
+		var ivs []Interval
+		for _, arg := range args {
+			ivs = append(ivs, numberIntervals(arg)...)
+		}
+		return ivs
+	case cue.AndOp:
+		ivs := []Interval{{}}
+		for _, arg := range args {
+			var ivs1 []Interval
+			for _, iv := range ivs {
+				for _, iv1 := range numberIntervals(arg) {
+					if iv2 := iv.intersect(iv1); !iv2.isEmpty() {
+						ivs1 = append(ivs1, iv2)
+					}
+				}
+			}
+			ivs = ivs1
+		}
+		return ivs
+	case cue.GreaterThanOp, cue.GreaterThanEqualOp, cue.LessThanOp, cue.LessThanEqualOp:
+		if len(args) != 1 {
+			break
+		}
+		a := atomForValue(args[0])
+		if !a.isValid() || args[0].Kind()&cue.NumberKind == 0 {
+			break
+		}
+		b := Bound{
+			Value:     a.String(),
+			Inclusive: op == cue.GreaterThanEqualOp || op == cue.LessThanEqualOp,
+		}
+		if op == cue.GreaterThanOp || op == cue.GreaterThanEqualOp {
+			return []Interval{{Min: b}}
+		}
+		return []Interval{{Max: b}}
+	}
+	return []Interval{{}}
+}
+
+// numberRanges returns the disjoint intervals, in ascending order,
+// that tell apart the numbers allowed by each of the given sets of
+// intervals, with the indexes of the sets that allow the numbers
+// in each. Numbers allowed by none of the sets are in no interval.
+func numberRanges(ivs [][]Interval) ([]Interval, [][]int) {
+	// Split the number line at every bound, so that each
+	// part is either wholly in an interval or wholly outside it.
+	var points []*big.Rat
+	for _, ivs := range ivs {
+		for _, iv := range ivs {
+			for _, r := range []*big.Rat{iv.Min.rat(), iv.Max.rat()} {
+				if r != nil {
+					points = append(points, r)
+				}
+			}
+		}
+	}
+	slices.SortFunc(points, (*big.Rat).Cmp)
+	points = slices.CompactFunc(points, func(x, y *big.Rat) bool {
+		return x.Cmp(y) == 0
+	})
+	type part struct {
+		iv Interval
+		// x holds a number within the part.
+		x *big.Rat
+	}
+	var parts []part
+	one := big.NewRat(1, 1)
+	for i, p := range points {
+		open := part{
+			iv: Interval{Max: Bound{Value: p.RatString()}},
+		}
+		if i == 0 {
+			open.x = new(big.Rat).Sub(p, one)
+		} else {
+			open.iv.Min = Bound{Value: points[i-1].RatString()}
+			open.x = new(big.Rat).Add(points[i-1], p)
+			open.x.Quo(open.x, big.NewRat(2, 1))
+		}
+		point := Bound{
+			Value:     p.RatString(),
+			Inclusive: true,
+		}
+		parts = append(parts, open, part{
+			iv: Interval{Min: point, Max: point},
+			x:  p,
+		})
+	}
+	last := part{
+		x: new(big.Rat),
+	}
+	if len(points) > 0 {
+		p := points[len(points)-1]
+		last.iv.Min = Bound{Value: p.RatString()}
+		last.x.Add(p, one)
+	}
+	parts = append(parts, last)
+
+	var result []Interval
+	var sets [][]int
+	for _, p := range parts {
+		var set []int
+		for i, ivs := range ivs {
+			if slices.ContainsFunc(ivs, func(iv Interval) bool {
+				return iv.Contains(p.x)
+			}) {
+				set = append(set, i)
+			}
+		}
+		if len(set) == 0 {
+			continue
+		}
+		if n := len(result); n > 0 && slices.Equal(sets[n-1], set) && adjacent(result[n-1].Max, p.iv.Min) {
+			result[n-1].Max = p.iv.Max
+			continue
+		}
+		result = append(result, p.iv)
+		sets = append(sets, set)
+	}
+	for i := range result {
+		result[i].Min.Value = formatBound(result[i].Min.Value)
+		result[i].Max.Value = formatBound(result[i].Max.Value)
+	}
+	return result, sets
+}
+
+// adjacent reports whether an interval with the upper bound
+// hi is immediately followed by one with the lower bound lo.
+func adjacent(hi, lo Bound) bool {
+	return hi.Value != "" && hi.Value == lo.Value && hi.Inclusive != lo.Inclusive
+}
+
+// formatBound returns the bound value s, as produced by
+// [big.Rat.RatString], in CUE syntax.
+func formatBound(s string) string {
+	if s == "" {
+		return ""
+	}
+	r, _ := new(big.Rat).SetString(s)
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	if n, exact := r.FloatPrec(); exact {
+		return r.FloatString(n)
+	}
+	// The bounds come from decimal numbers in CUE, so this
+	// shouldn't happen. Use the nearest float64 as a fallback.
+	f, _ := r.Float64()
+	return new(big.Float).SetFloat64(f).Text('g', -1)
+}
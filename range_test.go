@@ -0,0 +1,75 @@
+package cuediscrim
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var numberIntervalsTests = []struct {
+	testName string
+	cue      string
+	want     []string
+}{{
+	testName: "Number",
+	cue:      `number`,
+	want:     []string{"number"},
+}, {
+	testName: "Concrete",
+	cue:      `-3`,
+	want:     []string{"-3"},
+}, {
+	testName: "Bounds",
+	cue:      `int & >=1 & <4.5`,
+	want:     []string{">=1 & <4.5"},
+}, {
+	testName: "Disjunction",
+	cue:      `<0 | >10 | 5`,
+	want:     []string{"<0", ">10", "5"},
+}, {
+	testName: "Overlapping",
+	cue:      `>=1 & <=3 | >2`,
+	want:     []string{">=1 & <=3", ">2"},
+}, {
+	testName: "NotANumber",
+	cue:      `string`,
+	want:     nil,
+}, {
+	testName: "Unknown",
+	cue:      `!=3`,
+	want:     []string{"number"},
+}}
+
+func TestNumberIntervals(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range numberIntervalsTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			var got []string
+			for _, iv := range numberIntervals(v) {
+				got = append(got, iv.String())
+				iv1, err := parseInterval(iv.String())
+				qt.Assert(t, qt.IsNil(err))
+				qt.Check(t, qt.Equals(iv1, iv))
+			}
+			qt.Check(t, qt.DeepEquals(got, test.want))
+		})
+	}
+}
+
+func TestNumberRanges(t *testing.T) {
+	ctx := cuecontext.New()
+	var ivs [][]Interval
+	for _, s := range []string{`>=0 & <10`, `>=5 & <20`, `30`} {
+		ivs = append(ivs, numberIntervals(ctx.CompileString(s)))
+	}
+	ranges, sets := numberRanges(ivs)
+	var got []string
+	for _, iv := range ranges {
+		got = append(got, iv.String())
+	}
+	qt.Check(t, qt.DeepEquals(got, []string{">=0 & <5", ">=5 & <10", ">=10 & <20", "30"}))
+	qt.Check(t, qt.DeepEquals(sets, [][]int{{0}, {0, 1}, {1}, {2}}))
+}
@@ -0,0 +1,207 @@
+package cuediscrim
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cuelang.org/go/cue"
+)
+
+// RegexSwitchNode switches on the string value at a path, choosing
+// the first branch whose pattern matches the value. It is used when
+// arms are told apart by the patterns they allow, as with
+// =~"^a" | =~"^b", rather than by their kinds or concrete values.
+type RegexSwitchNode struct {
+	Path string
+
+	// Branches holds the branches in the order
+	// that their patterns are tested.
+	Branches []RegexBranch
+
+	// Default is used when the value is not a string
+	// or matches none of the patterns.
+	Default DecisionNode
+
+	// Optional reports whether the field at Path is
+	// optional, so a value might not have it at all.
+	// See [Warnings].
+	Optional bool
+}
+
+// RegexBranch holds one branch of a [RegexSwitchNode].
+type RegexBranch struct {
+	// Pattern holds the regular expression,
+	// in the syntax of the =~ operator.
+	Pattern string
+	Node    DecisionNode
+}
+
+func (n *RegexSwitchNode) Possible() IntSet {
+	var s IntSet = wordSet(0)
+	for _, b := range n.Branches {
+		s = union(s, b.Node.Possible())
+	}
+	if n.Default != nil {
+		s = union(s, n.Default.Possible())
+	}
+	return s
+}
+
+func (n *RegexSwitchNode) Check(v cue.Value) IntSet {
+	s, _ := n.check(v, checkOptions{})
+	return s
+}
+
+func (n *RegexSwitchNode) check(v cue.Value, opts checkOptions) (IntSet, bool) {
+	f := lookupPath(v, n.Path)
+	if opts.isUnknown(f) {
+		// We can't tell which patterns an unknown
+		// string will match, so any branch is possible.
+		var s IntSet = wordSet(0)
+		for _, b := range n.Branches {
+			s1, _ := b.Node.check(v, opts)
+			s = union(s, s1)
+		}
+		if n.Default != nil {
+			s1, _ := n.Default.check(v, opts)
+			s = union(s, s1)
+		}
+		return s, false
+	}
+	if f.Exists() && f.Kind() == cue.StringKind {
+		str, _ := f.String()
+		for _, b := range n.Branches {
+			if re := compileRegexp(b.Pattern); re != nil && re.MatchString(str) {
+				return b.Node.check(v, opts)
+			}
+		}
+	}
+	if n.Default != nil {
+		return n.Default.check(v, opts)
+	}
+	return wordSet(0), true
+}
+
+func (n *RegexSwitchNode) write(w *indentWriter) {
+	w.Printf("switch regexp(%v) {", w.switchPath(n.Path, n.Optional))
+	for _, b := range n.Branches {
+		w.Printf("case =~%s:", strconv.Quote(b.Pattern))
+		w.Indent()
+		w.node(b.Node)
+		w.Unindent()
+	}
+	w.Printf("default:")
+	w.Indent()
+	w.node(n.Default)
+	w.Unindent()
+	w.Printf("}")
+}
+
+// regexps caches the compiled form of the patterns used by
+// [RegexSwitchNode], keyed by pattern, so that checking many
+// values doesn't compile the same patterns repeatedly.
+var regexps sync.Map
+
+// compileRegexp returns the compiled form of pattern,
+// or nil if it is not a valid regular expression.
+func compileRegexp(pattern string) *regexp.Regexp {
+	if re, ok := regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil
+	}
+	re1, _ := regexps.LoadOrStore(pattern, re)
+	return re1.(*regexp.Regexp)
+}
+
+// anyString holds a pattern that matches any string.
+const anyString = "^"
+
+// stringPatterns returns patterns that between them match all the
+// strings allowed by v, or nil if v allows no strings. The result
+// errs on the side of matching too much: strings that can't be
+// described by a pattern with a fixed prefix, as returned by
+// anchoredPrefix, are described by [anyString]. A concrete string
+// is described by a pattern that matches only it.
+func stringPatterns(v cue.Value) []string {
+	if v.IncompleteKind()&cue.StringKind == 0 {
+		return nil
+	}
+	if v.Kind() == cue.StringKind {
+		s, _ := v.String()
+		return []string{"^" + regexp.QuoteMeta(s) + "$"}
+	}
+	op, args := v.Expr()
+	if op == cue.NoOp {
+		// As for numberIntervals, the pattern might be
+		// embedded in a struct.
+		op, args = v.Eval().Expr()
+	}
+	switch op {
+	case cue.OrOp:
+		var patterns []string
+		for _, arg := range args {
+			patterns = append(patterns, stringPatterns(arg)...)
+		}
+		return patterns
+	case cue.AndOp:
+		// The strings allowed by v are a subset of those
+		// allowed by each conjunct, so any conjunct that
+		// can be described will do.
+		for _, arg := range args {
+			if p := stringPatterns(arg); p != nil && !slices.Contains(p, anyString) {
+				return p
+			}
+		}
+	case cue.RegexMatchOp:
+		if len(args) != 1 || args[0].Kind() != cue.StringKind {
+			break
+		}
+		pattern, _ := args[0].String()
+		if _, ok := anchoredPrefix(pattern); ok {
+			return []string{pattern}
+		}
+	}
+	return []string{anyString}
+}
+
+// anchoredPrefix returns the literal text that any string
+// matched by pattern must start with. It reports false if
+// pattern is not anchored at the start of the text.
+func anchoredPrefix(pattern string) (string, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	re = re.Simplify()
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	if len(subs) == 0 || subs[0].Op != syntax.OpBeginText {
+		return "", false
+	}
+	var prefix strings.Builder
+	for _, sub := range subs[1:] {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			break
+		}
+		prefix.WriteString(string(sub.Rune))
+	}
+	return prefix.String(), true
+}
+
+// patternsOverlap reports whether a string might be matched
+// by both of the given patterns, which must have anchored
+// prefixes. It errs on the side of reporting true.
+func patternsOverlap(p0, p1 string) bool {
+	prefix0, _ := anchoredPrefix(p0)
+	prefix1, _ := anchoredPrefix(p1)
+	return strings.HasPrefix(prefix0, prefix1) || strings.HasPrefix(prefix1, prefix0)
+}
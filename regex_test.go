@@ -0,0 +1,87 @@
+package cuediscrim
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var stringPatternsTests = []struct {
+	testName string
+	cue      string
+	want     []string
+}{{
+	testName: "Pattern",
+	cue:      `=~"^usr_[0-9]+$"`,
+	want:     []string{"^usr_[0-9]+$"},
+}, {
+	testName: "Concrete",
+	cue:      `"a.b"`,
+	want:     []string{`^a\.b$`},
+}, {
+	testName: "Conjunction",
+	cue:      `string & =~"^a" & !="ab"`,
+	want:     []string{"^a"},
+}, {
+	testName: "Disjunction",
+	cue:      `=~"^a" | "b"`,
+	want:     []string{"^a", "^b$"},
+}, {
+	testName: "Unanchored",
+	cue:      `=~"a"`,
+	want:     []string{"^"},
+}, {
+	testName: "AnyString",
+	cue:      `string`,
+	want:     []string{"^"},
+}, {
+	testName: "NotAString",
+	cue:      `int`,
+	want:     nil,
+}}
+
+func TestStringPatterns(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range stringPatternsTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			qt.Check(t, qt.DeepEquals(stringPatterns(v), test.want))
+		})
+	}
+}
+
+var anchoredPrefixTests = []struct {
+	pattern    string
+	wantPrefix string
+	wantOK     bool
+}{{
+	pattern:    "^usr_[0-9]+",
+	wantPrefix: "usr_",
+	wantOK:     true,
+}, {
+	pattern:    `^a\.b$`,
+	wantPrefix: "a.b",
+	wantOK:     true,
+}, {
+	pattern:    "^(?i)abc",
+	wantPrefix: "",
+	wantOK:     true,
+}, {
+	pattern: "abc",
+}, {
+	pattern: "(?m)^abc",
+}, {
+	pattern: "(",
+}}
+
+func TestAnchoredPrefix(t *testing.T) {
+	for _, test := range anchoredPrefixTests {
+		t.Run(test.pattern, func(t *testing.T) {
+			prefix, ok := anchoredPrefix(test.pattern)
+			qt.Check(t, qt.Equals(prefix, test.wantPrefix))
+			qt.Check(t, qt.Equals(ok, test.wantOK))
+		})
+	}
+}
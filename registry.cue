@@ -0,0 +1,43 @@
+package cuediscrim
+
+// #TagRegistry describes the tag values of a set of unions,
+// as produced by TagRegistry.CUE.
+#TagRegistry: {
+	// fields holds an entry for each field that the decision
+	// tree of some union switches on by value, ordered by path.
+	fields!: [...#Field]
+
+	// collisions holds the tag values that are used by more
+	// than one union without selecting the same definition.
+	collisions?: [...#Collision]
+}
+
+#Field: {
+	// path holds the path of the field within the unions.
+	path!: string
+
+	// values holds an entry for each value of the field.
+	values!: [...{
+		// value holds the CUE representation of the value
+		// (for example "\"circle\"" or "1").
+		value!: string
+
+		// owners holds the unions that use the value.
+		owners!: [...#Owner]
+	}]
+}
+
+#Owner: {
+	// union holds the name of the union.
+	union!: string
+
+	// definition holds the name of the arm of the union that
+	// the value selects, when it selects a single named arm.
+	definition?: string
+}
+
+#Collision: {
+	path!:  string
+	value!: string
+	owners!: [...#Owner]
+}
@@ -0,0 +1,159 @@
+package cuediscrim
+
+import (
+	_ "embed"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// TagRegistrySchema holds the CUE source of the schema for tag
+// registries, which defines #TagRegistry. The result of
+// [TagRegistry.CUE] always conforms to #TagRegistry.
+//
+//go:embed registry.cue
+var TagRegistrySchema string
+
+// TagRegistry records the tag values of a set of unions, such as all
+// the unions in a package: for each field that a union's decision
+// tree switches on by value, the values of the field and the
+// definition that each selects. Its JSON form is intended to be
+// consumed by other generators, so that they agree on which
+// definition a tag value stands for.
+type TagRegistry struct {
+	// Fields holds an entry for each field, ordered by path.
+	Fields []TagRegistryField `json:"fields"`
+
+	// Collisions holds the tag values that are used by
+	// more than one union without selecting the same definition.
+	Collisions []TagCollision `json:"collisions,omitempty"`
+}
+
+// TagRegistryField holds the values of a field in a [TagRegistry].
+type TagRegistryField struct {
+	// Path holds the path of the field within the unions.
+	Path string `json:"path"`
+
+	// Values holds an entry for each value, in order.
+	Values []TagRegistryValue `json:"values"`
+}
+
+// TagRegistryValue holds the unions that use a value of a field.
+type TagRegistryValue struct {
+	// Value holds the CUE representation of the value.
+	Value string `json:"value"`
+
+	// Owners holds the unions that use the value,
+	// in the order they were given to [NewTagRegistry].
+	Owners []TagOwner `json:"owners"`
+}
+
+// TagOwner holds a union that uses a tag value.
+type TagOwner struct {
+	// Union holds the name of the union.
+	Union string `json:"union"`
+
+	// Definition holds the name of the arm of the union
+	// that the value selects, or the empty string if it
+	// doesn't select a single named arm.
+	Definition string `json:"definition,omitempty"`
+}
+
+// TagCollision holds a tag value that is used by more than one
+// union without selecting the same definition in all of them,
+// which confuses tooling that maps tag values to definitions
+// regardless of the union.
+type TagCollision struct {
+	Path   string     `json:"path"`
+	Value  string     `json:"value"`
+	Owners []TagOwner `json:"owners"`
+}
+
+func (c TagCollision) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%s: %s is used by ", c.Path, c.Value)
+	for i, o := range c.Owners {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(o.Union)
+		if o.Definition != "" {
+			fmt.Fprintf(&buf, " (selecting %s)", o.Definition)
+		}
+	}
+	return buf.String()
+}
+
+// NewTagRegistry returns the registry of the tag values of the given
+// unions. Values that are used by several unions to select the same
+// definition, as when one union includes the arms of another, are
+// not collisions; any other value used by more than one union is.
+func NewTagRegistry(unions []GoTagUnion) *TagRegistry {
+	byPath := make(map[string]map[Atom][]TagOwner)
+	for _, u := range unions {
+		for _, f := range goTagValues(u.Tree) {
+			values := byPath[f.path]
+			if values == nil {
+				values = make(map[Atom][]TagOwner)
+				byPath[f.path] = values
+			}
+			for _, a := range f.values {
+				o := TagOwner{
+					Union: u.Name,
+				}
+				if arms := f.arms[a]; arms != nil && arms.Len() == 1 {
+					if arm := sortedInts(arms)[0]; arm < len(u.Names) {
+						o.Definition = u.Names[arm]
+					}
+				}
+				values[a] = append(values[a], o)
+			}
+		}
+	}
+	r := &TagRegistry{
+		Fields: []TagRegistryField{},
+	}
+	for _, path := range slices.Sorted(maps.Keys(byPath)) {
+		values := byPath[path]
+		f := TagRegistryField{
+			Path: path,
+		}
+		for _, a := range slices.SortedFunc(maps.Keys(values), Atom.compare) {
+			owners := values[a]
+			f.Values = append(f.Values, TagRegistryValue{
+				Value:  a.String(),
+				Owners: owners,
+			})
+			if tagsCollide(owners) {
+				r.Collisions = append(r.Collisions, TagCollision{
+					Path:   path,
+					Value:  a.String(),
+					Owners: owners,
+				})
+			}
+		}
+		r.Fields = append(r.Fields, f)
+	}
+	return r
+}
+
+// tagsCollide reports whether the owners of a tag value
+// don't all use it to select the same definition.
+func tagsCollide(owners []TagOwner) bool {
+	if len(owners) < 2 {
+		return false
+	}
+	for _, o := range owners {
+		if o.Definition == "" || o.Definition != owners[0].Definition {
+			return true
+		}
+	}
+	return false
+}
+
+// CUE returns the registry formatted as a CUE data file. The result
+// is validated against the #TagRegistry definition in [TagRegistrySchema].
+func (r *TagRegistry) CUE() ([]byte, error) {
+	return encodeCUE(r, TagRegistrySchema, "registry", "TagRegistry")
+}
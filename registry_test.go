@@ -0,0 +1,102 @@
+package cuediscrim
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestNewTagRegistry(t *testing.T) {
+	v := cuecontext.New().CompileString(`
+#Circle: {type!: "circle", r!: number}
+#Square: {type!: "square", side!: number}
+#Shape: #Circle | #Square
+#Shapes: #Circle | #Square | {type!: "triangle"}
+#Round: {type!: "circle", diameter!: number}
+#Other: #Round | {type!: "blob"}
+`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	var unions []GoTagUnion
+	for _, name := range []string{"#Shape", "#Shapes", "#Other"} {
+		r := DiscriminateValue(v.LookupPath(cue.ParsePath(name)))
+		unions = append(unions, GoTagUnion{
+			Name:  name,
+			Tree:  r.Tree,
+			Names: r.Names,
+		})
+	}
+	r := NewTagRegistry(unions)
+	var collisions []string
+	for _, c := range r.Collisions {
+		collisions = append(collisions, c.String())
+	}
+	qt.Check(t, qt.DeepEquals(collisions, []string{
+		`type: "circle" is used by #Shape (selecting #Circle), #Shapes (selecting #Circle), #Other (selecting #Round)`,
+	}))
+	data, err := r.CUE()
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(string(data), strings.TrimPrefix(`
+fields: [{
+	path: "type"
+	values: [{
+		value: "\"blob\""
+		owners: [{
+			union: "#Other"
+		}]
+	}, {
+		value: "\"circle\""
+		owners: [{
+			union:      "#Shape"
+			definition: "#Circle"
+		}, {
+			union:      "#Shapes"
+			definition: "#Circle"
+		}, {
+			union:      "#Other"
+			definition: "#Round"
+		}]
+	}, {
+		value: "\"square\""
+		owners: [{
+			union:      "#Shape"
+			definition: "#Square"
+		}, {
+			union:      "#Shapes"
+			definition: "#Square"
+		}]
+	}, {
+		value: "\"triangle\""
+		owners: [{
+			union: "#Shapes"
+		}]
+	}]
+}]
+collisions: [{
+	path:  "type"
+	value: "\"circle\""
+	owners: [{
+		union:      "#Shape"
+		definition: "#Circle"
+	}, {
+		union:      "#Shapes"
+		definition: "#Circle"
+	}, {
+		union:      "#Other"
+		definition: "#Round"
+	}]
+}]
+`, "\n")))
+}
+
+func TestTagRegistryJSON(t *testing.T) {
+	r := NewTagRegistry(nil)
+	data, err := json.Marshal(r)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(string(data), `{"fields":[]}`))
+	_, err = r.CUE()
+	qt.Assert(t, qt.IsNil(err))
+}
@@ -0,0 +1,188 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/errors"
+)
+
+// RemovedArm describes an arm of a disjunction, as written in the
+// source, that is not present in the evaluated value and so takes no
+// part in the analysis.
+type RemovedArm struct {
+	// Arm holds the arm as written, before any
+	// other conjuncts were applied.
+	Arm cue.Value
+
+	// Reason describes why the arm was removed.
+	Reason string
+}
+
+// RemovedArms returns the arms of the disjunction v that
+// CUE evaluation removes, such as arms that are errors,
+// arms that conflict with a conjunct applied to the whole
+// disjunction (as in #Union & {kind!: "a"}), and arms that are
+// duplicates of earlier arms. The arms are returned in
+// source order.
+func RemovedArms(v cue.Value) []RemovedArm {
+	var removed []RemovedArm
+	var kept []sourceArm
+	for _, a := range sourceArms(nil, v, nil) {
+		if err := a.applied.Err(); err != nil {
+			removed = append(removed, RemovedArm{
+				Arm:    a.arm,
+				Reason: errorReason(err),
+			})
+			continue
+		}
+		if i := indexEquivalent(kept, a.applied); i >= 0 {
+			removed = append(removed, RemovedArm{
+				Arm:    a.arm,
+				Reason: fmt.Sprintf("duplicate of arm at %v", kept[i].arm.Pos()),
+			})
+			continue
+		}
+		kept = append(kept, a)
+	}
+	return removed
+}
+
+// errorReason returns a description of err suitable
+// for use as a RemovedArm reason. Summary lines such as
+// "2 errors in empty disjunction:" are omitted in favor of
+// the errors they summarize.
+func errorReason(err error) string {
+	var msgs []string
+	for _, e := range errors.Errors(err) {
+		if msg := e.Error(); !strings.HasSuffix(msg, ":") {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(msgs) == 0 {
+		return err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// sourceArm holds an arm of a disjunction as written, and
+// the same arm with any other conjuncts applied.
+type sourceArm struct {
+	arm     cue.Value
+	applied cue.Value
+}
+
+// sourceArms appends to dst the arms of v as written in
+// the source, using the unevaluated expression. Each arm is
+// unified with the values in conjuncts to find its applied value.
+// It mirrors the splitting done by [Disjunctions].
+func sourceArms(dst []sourceArm, v cue.Value, conjuncts []cue.Value) []sourceArm {
+	op, args := v.Expr()
+	switch op {
+	case cue.OrOp:
+		for _, arg := range args {
+			dst = sourceArms(dst, arg, conjuncts)
+		}
+		return dst
+	case cue.AndOp:
+		all := appendConjuncts(nil, v)
+		for i, c := range all {
+			if len(Disjunctions(c)) < 2 {
+				continue
+			}
+			others := append(conjuncts[:len(conjuncts):len(conjuncts)], all[:i]...)
+			others = append(others, all[i+1:]...)
+			return sourceArms(dst, c, others)
+		}
+	case cue.CallOp:
+		if arms := Disjunctions(v); len(arms) > 1 {
+			for _, arm := range arms {
+				dst = sourceArms(dst, arm, conjuncts)
+			}
+			return dst
+		}
+	case cue.SelectorOp, cue.IndexOp:
+		// A reference to a disjunction defined elsewhere.
+		// Use the referenced value when possible so that the
+		// arms retain their source positions.
+		if root, p := v.ReferencePath(); root.Exists() {
+			if ref := root.LookupPath(p); ref.Exists() {
+				if op, _ := ref.Expr(); op == cue.OrOp {
+					return sourceArms(dst, ref, conjuncts)
+				}
+			}
+		}
+		if op, _ := v.Eval().Expr(); op == cue.OrOp {
+			return sourceArms(dst, v.Eval(), conjuncts)
+		}
+	}
+	applied := v
+	for _, c := range conjuncts {
+		applied = applied.Unify(c)
+	}
+	return append(dst, sourceArm{
+		arm:     v,
+		applied: applied,
+	})
+}
+
+// indexEquivalent returns the index of the first arm in arms
+// whose applied value is equivalent to v, or -1 if there is none.
+func indexEquivalent(arms []sourceArm, v cue.Value) int {
+	for i, a := range arms {
+		if equivalent(a.applied, v, maxEquivalentDepth) {
+			return i
+		}
+	}
+	return -1
+}
+
+// maxEquivalentDepth bounds the depth to which [equivalent] looks
+// inside values, so that it terminates for recursive definitions.
+const maxEquivalentDepth = 8
+
+// equivalent reports whether a and b subsume one another.
+// Subsumption doesn't take account of the element types of lists,
+// so [...#A] and [...#B] subsume one another, for example; so the
+// elements and fields of a and b are compared too, down to
+// the given depth.
+func equivalent(a, b cue.Value, depth int) bool {
+	if a.Subsume(b, cue.Schema()) != nil || b.Subsume(a, cue.Schema()) != nil {
+		return false
+	}
+	if depth <= 0 {
+		return true
+	}
+	if a.IncompleteKind() == cue.ListKind && b.IncompleteKind() == cue.ListKind {
+		for i := range max(listLen(a), listLen(b)) {
+			if !equivalentElems(listElement(a, i), listElement(b, i), depth-1) {
+				return false
+			}
+		}
+		anyA := a.LookupPath(cue.MakePath(cue.AnyIndex))
+		anyB := b.LookupPath(cue.MakePath(cue.AnyIndex))
+		if !equivalentElems(anyA, anyB, depth-1) {
+			return false
+		}
+	}
+	bFields := make(map[string]cue.Value)
+	for label, f := range structFields(b, requiredLabel|optionalLabel|regularLabel) {
+		bFields[label.name] = f
+	}
+	for label, f := range structFields(a, requiredLabel|optionalLabel|regularLabel) {
+		if g, ok := bFields[label.name]; ok && !equivalent(f, g, depth-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// equivalentElems is like [equivalent] for list elements,
+// which might not exist.
+func equivalentElems(a, b cue.Value, depth int) bool {
+	if a.Exists() != b.Exists() {
+		return false
+	}
+	return !a.Exists() || equivalent(a, b, depth)
+}
@@ -0,0 +1,113 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var removedArmsTests = []struct {
+	testName string
+	cue      string
+	want     []string
+}{{
+	testName: "None",
+	cue:      `x: {type!: "a"} | {type!: "b", y?: int}`,
+}, {
+	testName: "NoneWithOverlap",
+	cue:      `x: int | >5`,
+}, {
+	testName: "NoneWithLists",
+	cue:      `x: [...{kind!: "a"}] | [...{kind!: "b"}]`,
+}, {
+	testName: "NoneWithListDefinitions",
+	cue: `
+#A: {kind!: "a"}
+#B: {kind!: "b"}
+x: [...#A] | [...#B]
+`,
+}, {
+	testName: "NoneWithNestedLists",
+	cue: `
+#A: {kind!: "a"}
+#B: {kind!: "b"}
+x: {l!: [#A, ...#A]} | {l!: [#A, ...#B]}
+`,
+}, {
+	testName: "DuplicateList",
+	cue: `
+#A: {kind!: "a"}
+x: [...#A] | [...#A]
+`,
+	want: []string{
+		`3:14: duplicate of arm at 3:4`,
+	},
+}, {
+	testName: "ConflictWithConjunct",
+	cue: `
+U: {type!: "a"} | {type!: "b"} | {type!: "c"}
+x: U & {type!: "b" | "c"}
+`,
+	want: []string{
+		`2:4: U.type: conflicting values "b" and "a"; U.type: conflicting values "c" and "a"`,
+	},
+}, {
+	testName: "DuplicateAtom",
+	cue:      `x: "a" | "a" | string`,
+	want: []string{
+		`1:10: duplicate of arm at 1:4`,
+	},
+}, {
+	testName: "DuplicateStruct",
+	cue:      `x: {a!: int} | {a!: int}`,
+	want: []string{
+		`1:16: duplicate of arm at 1:4`,
+	},
+}, {
+	testName: "ErrorArm",
+	cue:      `x: {a!: int} | 1 & 2`,
+	want: []string{
+		`1:16: x: conflicting values 2 and 1`,
+	},
+}, {
+	testName: "MatchN",
+	cue:      `x: matchN(1, [{type!: "a", x!: int}, {type!: "b"}]) & {type!: "b"}`,
+	want: []string{
+		`1:15: 0.type: conflicting values "b" and "a"`,
+	},
+}}
+
+func TestRemovedArms(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range removedArmsTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			var got []string
+			for _, r := range RemovedArms(v.LookupPath(cue.ParsePath("x"))) {
+				got = append(got, fmt.Sprintf("%v: %s", r.Arm.Pos(), r.Reason))
+			}
+			qt.Assert(t, qt.DeepEquals(got, test.want))
+		})
+	}
+}
+
+func TestDiscriminateValueRemoved(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`"a" | "b" | "a"`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	r := DiscriminateValue(v)
+	qt.Assert(t, qt.HasLen(r.Arms, 2))
+	qt.Assert(t, qt.HasLen(r.Removed, 1))
+	rep := r.Report("")
+	qt.Assert(t, qt.DeepEquals(rep.Removed, []ReportRemoved{{
+		Pos:    "1:13",
+		Source: `"a"`,
+		Reason: "duplicate of arm at 1:1",
+	}}))
+	_, err := rep.CUE()
+	qt.Assert(t, qt.IsNil(err))
+}
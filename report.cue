@@ -0,0 +1,104 @@
+package cuediscrim
+
+// #Report describes the result of analyzing a disjunction,
+// as produced by Report.CUE.
+#Report: {
+	// importPath holds the import path of the package
+	// holding the analyzed value, if known.
+	importPath?: string
+
+	// path holds the CUE path of the analyzed value, if known.
+	path?: string
+
+	// pos holds the source position of the analyzed value, if known.
+	pos?: string
+
+	// perfect reports whether the decision tree
+	// is a perfect discriminator.
+	perfect!: bool
+
+	// arms holds information on each arm of the disjunction.
+	arms!: [...#Arm]
+
+	// groups holds the sets of arms that were merged
+	// together as compatible.
+	groups?: [...[...int]]
+
+	// merged holds a name for each group in groups,
+	// derived from the names of its members.
+	merged?: [...#Group]
+
+	// tree holds a textual representation of the decision tree.
+	tree!: string
+
+	// importers holds the locations that refer to the
+	// analyzed value from other packages, when known.
+	importers?: [...string]
+
+	// warnings holds any warnings about the tree.
+	warnings?: [...#Warning]
+
+	// removed holds the arms written in the source
+	// that were removed by evaluation.
+	removed?: [...#Removed]
+}
+
+// #Reports describes a set of reports, as produced by ReportsCUE.
+#Reports: {
+	reports!: [...#Report]
+}
+
+#Arm: {
+	// index holds the index of the arm within the disjunction.
+	index!: int & >=0
+
+	// name holds the path of the definition or field
+	// that the arm refers to, if any.
+	name?: string
+
+	// pos holds the source position of the arm, if known.
+	pos?: string
+
+	// source holds the CUE representation of the arm.
+	source!: string
+
+	// deprecated reports whether the arm is marked @deprecated.
+	deprecated?: bool
+}
+
+#Group: {
+	// name holds an identifier for the group,
+	// unique within the report.
+	name!: string
+
+	// arms holds the indexes of the arms in the group.
+	arms!: [...int & >=0]
+
+	// type holds a CUE data type that can hold
+	// an instance of any arm in the group.
+	type?: string
+
+	// constraint holds the precise union of the arms in the
+	// group when they are atoms of a single kind, such as
+	// "a" | "b" | =~"^x", which type widens to their kind.
+	constraint?: string
+}
+
+#Warning: {
+	// path holds the path of the value concerned.
+	path!: string
+
+	// message describes the problem.
+	message!: string
+}
+
+#Removed: {
+	// pos holds the source position of the arm, if known.
+	pos?: string
+
+	// source holds the CUE representation of the arm as written.
+	source!: string
+
+	// reason describes why the arm was removed.
+	reason!: string
+}
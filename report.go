@@ -0,0 +1,181 @@
+package cuediscrim
+
+import (
+	_ "embed"
+	"fmt"
+	"slices"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/format"
+)
+
+// ReportSchema holds the CUE source of the schema for reports,
+// which defines #Report. The result of [Report.CUE] always
+// conforms to #Report.
+//
+//go:embed report.cue
+var ReportSchema string
+
+// Report holds a serializable summary of a [Result]. Each arm is
+// described by a [ReportArm], the diagnostics about the analysis
+// are held in Warnings and Removed, and the type of each merged group
+// is held in Merged as CUE source. The tree is held as text; a tree
+// that can be decoded again is given by [EncodeTree].
+type Report struct {
+	// ImportPath holds the import path of the package holding
+	// the analyzed value, when known.
+	ImportPath string `json:"importPath,omitempty"`
+
+	Path string `json:"path,omitempty"`
+
+	// Pos holds the source position of the analyzed value,
+	// when known.
+	Pos string `json:"pos,omitempty"`
+
+	Perfect bool        `json:"perfect"`
+	Arms    []ReportArm `json:"arms"`
+	Groups  [][]int     `json:"groups,omitempty"`
+
+	// Merged holds the named groups of arms merged
+	// together, as returned by [Result.MergedGroups].
+	Merged []ReportGroup `json:"merged,omitempty"`
+
+	Tree string `json:"tree"`
+
+	// Importers holds the locations that refer to the
+	// analyzed value from other packages, when known.
+	Importers []string `json:"importers,omitempty"`
+
+	// Warnings holds any warnings about the tree.
+	Warnings []ReportWarning `json:"warnings,omitempty"`
+
+	// Removed holds the arms that were removed by evaluation.
+	Removed []ReportRemoved `json:"removed,omitempty"`
+}
+
+// ReportArm holds information about a single arm in a [Report].
+type ReportArm struct {
+	Index      int    `json:"index"`
+	Name       string `json:"name,omitempty"`
+	Pos        string `json:"pos,omitempty"`
+	Source     string `json:"source"`
+	Deprecated bool   `json:"deprecated,omitempty"`
+}
+
+// ReportGroup holds a [MergedGroup] in a [Report].
+type ReportGroup struct {
+	Name       string `json:"name"`
+	Arms       []int  `json:"arms"`
+	Type       string `json:"type,omitempty"`
+	Constraint string `json:"constraint,omitempty"`
+}
+
+// ReportWarning holds a [Warning] in a [Report].
+type ReportWarning struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ReportRemoved holds information about an arm in a [Report]
+// that was removed by evaluation.
+type ReportRemoved struct {
+	Pos    string `json:"pos,omitempty"`
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+}
+
+// Report returns a report on the result. The path
+// is recorded in the report and may be empty.
+func (r *Result) Report(path string) *Report {
+	rep := &Report{
+		Path:    path,
+		Perfect: r.Perfect,
+		Arms:    make([]ReportArm, len(r.Arms)),
+		Tree:    NodeString(r.Tree, WriteArmNames(r.Names)),
+	}
+	for i, arm := range r.Arms {
+		ra := ReportArm{
+			Index:      i,
+			Source:     fmt.Sprint(arm),
+			Deprecated: IsDeprecated(arm),
+		}
+		if i < len(r.Names) {
+			ra.Name = r.Names[i]
+		}
+		if pos := arm.Pos(); pos.IsValid() {
+			ra.Pos = pos.String()
+		}
+		rep.Arms[i] = ra
+	}
+	for _, g := range r.Groups {
+		if g.Len() < 2 {
+			continue
+		}
+		rep.Groups = append(rep.Groups, slices.Sorted(g.Values()))
+	}
+	for _, g := range r.MergedGroups() {
+		rep.Merged = append(rep.Merged, ReportGroup(g))
+	}
+	for _, w := range r.Warnings {
+		rep.Warnings = append(rep.Warnings, ReportWarning(w))
+	}
+	for _, arm := range r.Removed {
+		rr := ReportRemoved{
+			Source: fmt.Sprint(arm.Arm),
+			Reason: arm.Reason,
+		}
+		if pos := arm.Arm.Pos(); pos.IsValid() {
+			rr.Pos = pos.String()
+		}
+		rep.Removed = append(rep.Removed, rr)
+	}
+	return rep
+}
+
+// CUE returns the report formatted as a CUE data file. The result
+// is validated against the #Report definition in [ReportSchema].
+func (r *Report) CUE() ([]byte, error) {
+	return encodeReportCUE(r, "Report")
+}
+
+// ReportsCUE returns the given reports formatted as a CUE data file
+// with a single field, reports, holding all the reports. The result
+// is validated against the #Reports definition in [ReportSchema].
+func ReportsCUE(reports []*Report) ([]byte, error) {
+	return encodeReportCUE(struct {
+		Reports []*Report `json:"reports"`
+	}{reports}, "Reports")
+}
+
+func encodeReportCUE(x any, def string) ([]byte, error) {
+	return encodeCUE(x, ReportSchema, "report", def)
+}
+
+// encodeCUE returns x formatted as a CUE data file after
+// validating it against the definition def in the schema
+// with the given source. The name describes what x is
+// for the purposes of error messages.
+func encodeCUE(x any, schemaSrc, name, def string) ([]byte, error) {
+	ctx := cuecontext.New()
+	v := ctx.Encode(x)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	schema := ctx.CompileString(schemaSrc, cue.Filename(name+".cue"))
+	if err := schema.Err(); err != nil {
+		return nil, fmt.Errorf("invalid %s schema: %v", name, err)
+	}
+	v = schema.LookupPath(cue.MakePath(cue.Def(def))).Unify(v)
+	if err := v.Validate(cue.Concrete(true)); err != nil {
+		return nil, fmt.Errorf("%s does not conform to schema: %v", name, err)
+	}
+	syn := v.Syntax(cue.Final(), cue.Concrete(true))
+	if lit, ok := syn.(*ast.StructLit); ok {
+		syn = &ast.File{
+			Decls: lit.Elts,
+		}
+	}
+	return format.Node(syn)
+}
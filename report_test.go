@@ -0,0 +1,61 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestReportCUE(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`"a" | "b" | =~"^x"`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	arms := Disjunctions(v)
+	r := Analyze(arms, MergeCompatible(true))
+	rep := r.Report("x")
+	rep.ImportPath = "example.com/x"
+	rep.Pos = "x.cue:1:4"
+	data, err := rep.CUE()
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(string(data), strings.TrimPrefix(`
+importPath: "example.com/x"
+path:       "x"
+pos:        "x.cue:1:4"
+perfect:    true
+arms: [{
+	index:  0
+	pos:    "1:1"
+	source: "\"a\""
+}, {
+	index:  1
+	pos:    "1:7"
+	source: "\"b\""
+}, {
+	index:  2
+	pos:    "1:13"
+	source: "=~\"^x\""
+}]
+groups: [[0, 1, 2]]
+merged: [{
+	name: "Arm0OrArm1OrArm2"
+	arms: [0, 1, 2]
+	type:       "string"
+	constraint: "\"a\" | \"b\" | =~\"^x\""
+}]
+tree: """
+	choose({0, 1, 2})
+
+	"""
+`, "\n")))
+
+	// Check that the result really does conform to the schema.
+	schema := ctx.CompileString(ReportSchema)
+	qt.Assert(t, qt.IsNil(schema.Err()))
+	rv := ctx.CompileBytes(data)
+	qt.Assert(t, qt.IsNil(rv.Err()))
+	rv = schema.LookupPath(cue.MakePath(cue.Def("Report"))).Unify(rv)
+	qt.Assert(t, qt.IsNil(rv.Validate(cue.Concrete(true))))
+}
@@ -0,0 +1,71 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"cuelang.org/go/cue"
+)
+
+// DiscriminatorViolation describes a union that is not
+// discriminated as required by [RequireDiscriminator].
+type DiscriminatorViolation struct {
+	// Path holds the path of the required discriminator field.
+	Path string
+
+	// Used holds the other paths that the tree makes
+	// decisions on, in sorted order.
+	Used []string
+
+	// Imperfect reports whether the tree is not
+	// a perfect discriminator.
+	Imperfect bool
+}
+
+func (v *DiscriminatorViolation) Error() string {
+	switch {
+	case len(v.Used) == 0:
+		return fmt.Sprintf("%s does not tell all the arms apart", v.Path)
+	case v.Imperfect:
+		return fmt.Sprintf("arms are not all told apart, using %s instead of %s", strings.Join(v.Used, ", "), v.Path)
+	}
+	return fmt.Sprintf("arms are told apart by %s instead of %s", strings.Join(v.Used, ", "), v.Path)
+}
+
+// RequireDiscriminator returns an error if the tree in r is not a
+// perfect discriminator that makes decisions on the field at path
+// alone, where path is as shown in decision trees (for example
+// kind or "api-version"). Organizations that standardize on a
+// single tag field can use it to check that every union follows
+// that convention. As for [FindOpenAPIDiscriminator], a switch on
+// the kind of the whole value that only allows structs is not
+// counted as a decision.
+//
+// The error, if any, is a [*DiscriminatorViolation] that
+// holds the paths that the tree uses instead.
+func RequireDiscriminator(r *Result, path string) error {
+	n := r.Tree
+	if k, ok := n.(*OptionalNode); ok {
+		n = k.Present
+	}
+	if k, ok := n.(*KindSwitchNode); ok && k.Path == "." && len(k.Branches) == 1 && k.Branches[cue.StructKind] != nil {
+		n = k.Branches[cue.StructKind]
+	}
+	paths := TreePaths(n)
+	var used []string
+	for p := range paths.Values() {
+		if p != path {
+			used = append(used, p)
+		}
+	}
+	slices.Sort(used)
+	if r.Perfect && len(used) == 0 && paths.Has(path) {
+		return nil
+	}
+	return &DiscriminatorViolation{
+		Path:      path,
+		Used:      used,
+		Imperfect: !r.Perfect,
+	}
+}
@@ -0,0 +1,60 @@
+package cuediscrim
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var requireDiscriminatorTests = []struct {
+	testName  string
+	cue       string
+	path      string
+	wantError string
+}{{
+	testName: "Tagged",
+	cue:      `{kind!: "a", x!: int} | {kind!: "b", y!: string}`,
+	path:     "kind",
+}, {
+	testName: "QuotedPath",
+	cue:      `{"api-kind"!: "a"} | {"api-kind"!: "b"}`,
+	path:     `"api-kind"`,
+}, {
+	testName:  "OtherField",
+	cue:       `{type!: "a"} | {type!: "b"}`,
+	path:      "kind",
+	wantError: `arms are told apart by type instead of kind`,
+}, {
+	testName:  "ImperfectOtherField",
+	cue:       `{type!: "a", x?: int} | {type!: "a", y?: int} | {type!: "b"}`,
+	path:      "kind",
+	wantError: `arms are not all told apart, using type instead of kind`,
+}, {
+	testName:  "NotStructs",
+	cue:       `{kind!: "a"} | {kind!: "b"} | string`,
+	path:      "kind",
+	wantError: `arms are told apart by \. instead of kind`,
+}, {
+	testName:  "Indistinguishable",
+	cue:       `{kind?: "a"} | {kind?: "b"}`,
+	path:      "kind",
+	wantError: `kind does not tell all the arms apart`,
+}}
+
+func TestRequireDiscriminator(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range requireDiscriminatorTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			err := RequireDiscriminator(DiscriminateValue(v), test.path)
+			if test.wantError == "" {
+				qt.Assert(t, qt.IsNil(err))
+				return
+			}
+			qt.Assert(t, qt.ErrorMatches(err, test.wantError))
+			qt.Check(t, qt.ErrorAs(err, new(*DiscriminatorViolation)))
+		})
+	}
+}
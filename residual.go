@@ -0,0 +1,303 @@
+package cuediscrim
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/literal"
+)
+
+// Residual holds the constraints of an arm that remain to be
+// checked once a decision tree has chosen it. Generated validators
+// can check a value against the residual rather than the whole arm,
+// so that they don't check the discriminator fields twice and their
+// error messages don't mention them.
+type Residual struct {
+	// Node holds the node in the tree that chose the arm:
+	// a *LeafNode or, when the arm was chosen by elimination,
+	// a *FieldAbsenceNode.
+	Node DecisionNode
+
+	// Arm holds the index of the arm.
+	Arm int
+
+	// Checked holds the paths whose constraints in the arm are
+	// known to be satisfied by any value that reaches Node,
+	// in the order they are tested by the tree.
+	Checked []string
+
+	// Expr holds the arm with the constraints at the paths in
+	// Checked replaced by _. The fields themselves remain, so
+	// that they are still allowed when the arm is closed.
+	Expr ast.Expr
+
+	// Value holds Expr built in the same context as the arm.
+	Value cue.Value
+}
+
+// Residuals returns the residual constraints for each arm chosen
+// by each leaf of n, in the order that the leaves are
+// written by [NodeString].
+//
+// The constraint on a field is known to be satisfied when the
+// tree has switched on its value, as the arms chosen for a value
+// must allow it, or when the tree has switched on its kind and the
+// arm allows any value of that kind, as with x: string. Negative
+// tests, such as those made by a [FieldAbsenceNode], tell
+// nothing about the fields that are present.
+func Residuals(n DecisionNode, arms []cue.Value) []Residual {
+	r := &residualFinder{
+		arms: arms,
+	}
+	r.node(n, nil)
+	return r.residuals
+}
+
+type residualFinder struct {
+	arms      []cue.Value
+	residuals []Residual
+
+	// keys is used to compare the branches of value switches.
+	keys nodeKeys
+}
+
+// residualCheck holds a positive test made by a decision tree.
+type residualCheck struct {
+	path string
+	// kind holds the kind tested for, or zero for a value test.
+	kind cue.Kind
+}
+
+// node adds the residuals for the leaves of n,
+// reached after making the given checks.
+func (r *residualFinder) node(n DecisionNode, checks []residualCheck) {
+	switch n := n.(type) {
+	case *LeafNode:
+		r.add(n, n.Arms, checks)
+	case *FieldAbsenceNode:
+		r.add(n, n.Possible(), checks)
+	case *KindSwitchNode:
+		for _, k := range slices.Sorted(maps.Keys(n.Branches)) {
+			r.node(n.Branches[k], append(checks[:len(checks):len(checks)], residualCheck{
+				path: n.Path,
+				kind: k,
+			}))
+		}
+	case *ValueSwitchNode:
+		for _, g := range n.foldBranches(&r.keys) {
+			r.node(g.node, append(checks[:len(checks):len(checks)], residualCheck{
+				path: n.Path,
+			}))
+		}
+		if n.Default != nil {
+			r.node(n.Default, checks)
+		}
+	case *RangeSwitchNode:
+		// An arm chosen for an interval need not allow every
+		// number in it, as with int & >5, so the test tells
+		// nothing certain about the arm's constraint.
+		for _, b := range n.Branches {
+			r.node(b.Node, checks)
+		}
+		if n.Default != nil {
+			r.node(n.Default, checks)
+		}
+	case *RegexSwitchNode:
+		// Likewise, an arm chosen for a pattern need not
+		// allow every string that the pattern matches.
+		for _, b := range n.Branches {
+			r.node(b.Node, checks)
+		}
+		if n.Default != nil {
+			r.node(n.Default, checks)
+		}
+	case *LenSwitchNode:
+		// Likewise, an arm chosen for an interval of lengths
+		// need not allow lists of every length in it.
+		for _, b := range n.Branches {
+			r.node(b.Node, checks)
+		}
+		if n.Default != nil {
+			r.node(n.Default, checks)
+		}
+	case *FieldPresenceNode:
+		// The presence of a field tells nothing
+		// about its value.
+		for _, b := range n.Branches {
+			r.node(b.Node, checks)
+		}
+		if n.Default != nil {
+			r.node(n.Default, checks)
+		}
+	case *TupleSwitchNode:
+		for _, b := range n.Branches {
+			checks1 := checks[:len(checks):len(checks)]
+			for _, path := range n.Paths {
+				checks1 = append(checks1, residualCheck{
+					path: path,
+				})
+			}
+			r.node(b.Node, checks1)
+		}
+	case *OptionalNode:
+		r.node(n.Present, checks)
+	case *GroupNode:
+		r.node(n.Select, checks)
+	}
+}
+
+func (r *residualFinder) add(n DecisionNode, arms IntSet, checks []residualCheck) {
+	for _, arm := range sortedInts(arms) {
+		if arm < 0 || arm >= len(r.arms) {
+			continue
+		}
+		v := r.arms[arm]
+		var expr ast.Node = v.Syntax()
+		var checked []string
+		for _, c := range checks {
+			if slices.Contains(checked, c.path) {
+				continue
+			}
+			if c.kind != 0 && !allowsKind(lookupPath(v, c.path), c.kind) {
+				continue
+			}
+			if expr1, ok := replaceAtPath(expr, splitPath(c.path)); ok {
+				expr = expr1
+				checked = append(checked, c.path)
+			}
+		}
+		res := Residual{
+			Node:    n,
+			Arm:     arm,
+			Checked: checked,
+		}
+		if e, ok := expr.(ast.Expr); ok {
+			res.Expr = e
+			res.Value = v.Context().BuildExpr(e)
+		}
+		r.residuals = append(r.residuals, res)
+	}
+}
+
+// allowsKind reports whether v allows every value of kind k,
+// which must be an atom kind.
+func allowsKind(v cue.Value, k cue.Kind) bool {
+	if !isAtomKind(k) || v.IncompleteKind() != k || v.IsConcrete() {
+		return false
+	}
+	return v.Subsume(v.Context().CompileString(k.String())) == nil
+}
+
+// replaceAtPath returns a copy of n with the value of the field at
+// the given path replaced by _. It reports false if the field
+// isn't written in n.
+func replaceAtPath(n ast.Node, names []string) (ast.Node, bool) {
+	if len(names) == 0 {
+		return ast.NewIdent("_"), true
+	}
+	switch n := n.(type) {
+	case *ast.ParenExpr:
+		x, ok := replaceAtPath(n.X, names)
+		if !ok {
+			return n, false
+		}
+		n1 := *n
+		n1.X = x.(ast.Expr)
+		return &n1, true
+	case *ast.BinaryExpr:
+		x, okx := replaceAtPath(n.X, names)
+		y, oky := replaceAtPath(n.Y, names)
+		if !okx && !oky {
+			return n, false
+		}
+		n1 := *n
+		n1.X, n1.Y = x.(ast.Expr), y.(ast.Expr)
+		return &n1, true
+	case *ast.CallExpr:
+		// For example, close({...}).
+		n1 := *n
+		n1.Args = slices.Clone(n.Args)
+		found := false
+		for i, arg := range n.Args {
+			if x, ok := replaceAtPath(arg, names); ok {
+				n1.Args[i] = x.(ast.Expr)
+				found = true
+			}
+		}
+		return &n1, found
+	case *ast.ListLit:
+		if !isIndex(names[0]) {
+			return n, false
+		}
+		i, err := strconv.Atoi(strings.Trim(names[0], "[]"))
+		if err != nil || i >= len(n.Elts) {
+			return n, false
+		}
+		if _, ok := n.Elts[i].(*ast.Ellipsis); ok {
+			return n, false
+		}
+		x, ok := replaceAtPath(n.Elts[i], names[1:])
+		if !ok {
+			return n, false
+		}
+		n1 := *n
+		n1.Elts = slices.Clone(n.Elts)
+		n1.Elts[i] = x.(ast.Expr)
+		return &n1, true
+	case *ast.StructLit:
+		n1 := *n
+		n1.Elts = slices.Clone(n.Elts)
+		found := false
+		for i, d := range n.Elts {
+			switch d := d.(type) {
+			case *ast.Field:
+				name, _, err := ast.LabelName(d.Label)
+				if err != nil {
+					continue
+				}
+				var x ast.Node
+				var ok bool
+				switch {
+				case name == labelName(names[0]):
+					x, ok = replaceAtPath(d.Value, names[1:])
+				case strings.HasPrefix(name, "_#"):
+					// A helper definition, as produced by
+					// [cue.Value.Syntax] for a closed arm.
+					x, ok = replaceAtPath(d.Value, names)
+				}
+				if ok {
+					d1 := *d
+					d1.Value = x.(ast.Expr)
+					n1.Elts[i] = &d1
+					found = true
+				}
+			case *ast.EmbedDecl:
+				if x, ok := replaceAtPath(d.Expr, names); ok {
+					d1 := *d
+					d1.Expr = x.(ast.Expr)
+					n1.Elts[i] = &d1
+					found = true
+				}
+			}
+		}
+		return &n1, found
+	}
+	return n, false
+}
+
+// labelName returns the field name for the selector
+// name as returned by splitPath. A quoted name, such as
+// "a.b" or "x-y", is unquoted according to the CUE rules
+// for string literals.
+func labelName(name string) string {
+	if strings.HasPrefix(name, `"`) {
+		if s, err := literal.Unquote(name); err == nil {
+			return s
+		}
+	}
+	return name
+}
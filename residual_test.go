@@ -0,0 +1,119 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/format"
+	"github.com/go-quicktest/qt"
+)
+
+var residualsTests = []struct {
+	testName string
+	cue      string
+	want     string
+}{{
+	testName: "TaggedDefinitions",
+	cue: `
+#A: {type!: "a", x!: int}
+#B: {type!: "b" | "bb", x!: string | null}
+x: #A | #B | {type!: "c", "x.y"!: string} | string
+`,
+	want: `
+arm 3 checked [.]:
+_
+arm 0 checked [type]:
+{
+	_#def
+	_#def: {
+		type!: _
+		x!:    int
+	}
+}
+arm 1 checked [type]:
+{
+	_#def
+	_#def: {
+		type!: _
+		x!:    string | null
+	}
+}
+arm 2 checked [type]:
+{
+	type!:  _
+	"x.y"!: string
+}
+`,
+}, {
+	testName: "ListElements",
+	cue: `
+x: [bool, int] | [string & =~"^a"]
+`,
+	want: `
+arm 0 checked [[0]]:
+[_, int]
+arm 1 checked []:
+[=~"^a"]
+`,
+}, {
+	testName: "NestedField",
+	cue: `
+x: {"x.y"!: {z!: "p"}, a!: int} | {"x.y"!: {z!: "q"}}
+`,
+	want: `
+arm 0 checked ["x.y".z]:
+{
+	"x.y"!: {
+		z!: _
+	}
+	a!: int
+}
+arm 1 checked ["x.y".z]:
+{
+	"x.y"!: {
+		z!: _
+	}
+}
+`,
+}}
+
+func TestResiduals(t *testing.T) {
+	for _, test := range residualsTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := cuecontext.New().CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			r := DiscriminateValue(v.LookupPath(cue.ParsePath("x")))
+			var buf strings.Builder
+			for _, res := range Residuals(r.Tree, r.Arms) {
+				qt.Assert(t, qt.IsNil(res.Value.Err()))
+				src, err := format.Node(res.Expr)
+				qt.Assert(t, qt.IsNil(err))
+				fmt.Fprintf(&buf, "arm %d checked %v:\n%s\n", res.Arm, res.Checked, src)
+			}
+			qt.Check(t, qt.Equals(buf.String(), strings.TrimPrefix(test.want, "\n")))
+		})
+	}
+}
+
+func TestResidualsValue(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`
+#A: {type!: "a", x!: int}
+#B: {type!: "b"}
+`)
+	arms := []cue.Value{v.LookupPath(cue.ParsePath("#A")), v.LookupPath(cue.ParsePath("#B"))}
+	tree, _, _ := Discriminate(arms)
+	residuals := Residuals(tree, arms)
+	qt.Assert(t, qt.HasLen(residuals, 2))
+
+	// The residual doesn't mention the tag, but
+	// still allows it as the arm is closed.
+	res := residuals[0].Value
+	qt.Check(t, qt.IsNil(res.Unify(ctx.CompileString(`{type: "a", x: 1}`)).Validate(cue.Concrete(true))))
+	qt.Check(t, qt.IsNil(res.Unify(ctx.CompileString(`{type: "other", x: 1}`)).Validate(cue.Concrete(true))))
+	qt.Check(t, qt.ErrorMatches(res.Unify(ctx.CompileString(`{type: "a", x: "s"}`)).Validate(cue.Concrete(true)), `.*conflicting values "s" and int.*`))
+	qt.Check(t, qt.ErrorMatches(res.Unify(ctx.CompileString(`{type: "a", x: 1, y: 2}`)).Validate(cue.Concrete(true)), `.*field not allowed.*`))
+}
@@ -0,0 +1,192 @@
+package cuediscrim
+
+import (
+	"bytes"
+	"io"
+
+	"cuelang.org/go/cue"
+)
+
+// Result holds everything known about the discrimination
+// of a set of disjunction arms, as returned by [Analyze], so
+// that the tree, the groups and the types of the merged groups
+// (see [Result.MergedGroups]) don't need to be found separately.
+// It holds CUE values, so it can't be serialized itself;
+// [Result.Report] returns its serializable form.
+type Result struct {
+	// Arms holds the arms that were analyzed.
+	Arms []cue.Value
+
+	// Tree holds the decision tree for the arms.
+	Tree DecisionNode
+
+	// Groups holds the sets of arms that were merged together
+	// when [MergeCompatible] is enabled.
+	// See also [Result.MergedGroups].
+	Groups []IntSet
+
+	// Names holds a name for each arm, as returned by [ArmNames],
+	// or nil if the names are not known. It is only populated
+	// by [DiscriminateValue].
+	Names []string
+
+	// Perfect reports whether Tree is a perfect discriminator.
+	// See [Discriminate] for details.
+	Perfect bool
+
+	// Warnings holds any warnings about the tree,
+	// as returned by [Warnings].
+	Warnings []Warning
+
+	// Removed holds the arms written in the source that
+	// were removed by evaluation and so are not in Arms.
+	// It is only populated by [DiscriminateValue].
+	Removed []RemovedArm
+
+	// Log holds the debug log of the analysis when [CaptureLog]
+	// is specified. If the log was larger than the limit, only its
+	// most recent lines are kept, following a "..." line.
+	Log string
+}
+
+// CaptureLog causes the debug log of the analysis, as written by
+// [LogTo] at the level set by [LogLevel], to be kept in the Log field
+// of the [Result], so that it can be stored alongside the tree to
+// explain it. At most maxBytes bytes of the log are kept; when it's
+// longer than that, the earliest lines are discarded. It has no
+// effect on [Discriminate], which does not return a Result.
+//
+// If [LogTo] is also specified, the log is written there as well.
+func CaptureLog(maxBytes int) Option {
+	return func(opts *options) {
+		opts.captureLog = maxBytes
+	}
+}
+
+// withLogCapture returns opts with any [CaptureLog] option
+// replaced by logging to the returned buffer, or opts itself
+// and nil if the log is not captured.
+func withLogCapture(opts []Option) ([]Option, *logBuffer) {
+	var o options
+	for _, f := range opts {
+		f(&o)
+	}
+	if o.captureLog <= 0 {
+		return opts, nil
+	}
+	buf := &logBuffer{
+		max: o.captureLog,
+	}
+	var w io.Writer = buf
+	if o.logger != nil {
+		w = io.MultiWriter(o.logger.w, buf)
+	}
+	return append(opts[:len(opts):len(opts)], LogTo(w), CaptureLog(0)), buf
+}
+
+// logBuffer is an io.Writer that keeps the most recent
+// lines written to it, up to max bytes.
+type logBuffer struct {
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	// Only trim when the buffer is well over the limit
+	// so that the cost of copying is amortized.
+	if len(b.buf) > 2*b.max {
+		b.trim()
+	}
+	return len(p), nil
+}
+
+// trim discards the earliest lines so that
+// the buffer holds at most max bytes.
+func (b *logBuffer) trim() {
+	if len(b.buf) <= b.max {
+		return
+	}
+	rest := b.buf[len(b.buf)-b.max:]
+	if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+		// Start at a line boundary.
+		rest = rest[i+1:]
+	}
+	b.buf = append(b.buf[:0], rest...)
+	b.truncated = true
+}
+
+func (b *logBuffer) String() string {
+	if b == nil {
+		return ""
+	}
+	b.trim()
+	if b.truncated {
+		return "...\n" + string(b.buf)
+	}
+	return string(b.buf)
+}
+
+// Analyze is like [Discriminate] except that it returns
+// all the results of the analysis as a single value.
+func Analyze(arms []cue.Value, opts ...Option) *Result {
+	opts, logBuf := withLogCapture(opts)
+	var o options
+	for _, f := range opts {
+		f(&o)
+	}
+	tree, groups, perfect := Discriminate(arms, opts...)
+	return &Result{
+		Arms:     arms,
+		Tree:     tree,
+		Groups:   groups,
+		Perfect:  perfect,
+		Warnings: resultWarnings(tree, arms, o),
+		Log:      logBuf.String(),
+	}
+}
+
+// DiscriminateValue is like [Analyze] except that it takes
+// the disjunction itself rather than its arms, splitting it
+// with [Disjunctions].
+//
+// Note that an optional field's value cannot be distinguished
+// from a regular value, so callers that found v as the value
+// of an optional field should pass [Optional](true)
+// so that the tree handles absence first.
+//
+// The Removed and Names fields of the result are populated
+// by [RemovedArms] and [ArmNames] respectively, and the names
+// are used to show the arms in the log; see [LogArmNames].
+//
+// With [PreserveGroups], the tree reflects the hierarchy of
+// any nested matchN calls in v.
+func DiscriminateValue(v cue.Value, opts ...Option) *Result {
+	names := ArmNames(v)
+	// Put the names first so that an explicit
+	// LogArmNames option takes precedence.
+	opts = append([]Option{LogArmNames(names)}, opts...)
+	opts, logBuf := withLogCapture(opts)
+	var o options
+	for _, f := range opts {
+		f(&o)
+	}
+	arms, groups := armGroups(v)
+	r := Analyze(arms, opts...)
+	if o.preserveGroups && len(groups.sub) > 0 {
+		tree := groupTree(arms, groups, opts)
+		if o.optional {
+			tree = &OptionalNode{
+				Present: tree,
+			}
+		}
+		r.Tree = tree
+		r.Perfect = isPerfect(tree, o, arms)
+		r.Warnings = resultWarnings(tree, arms, o)
+	}
+	r.Removed = RemovedArms(v)
+	r.Names = names
+	r.Log = logBuf.String()
+	return r
+}
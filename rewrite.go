@@ -0,0 +1,186 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/format"
+)
+
+// RewriteCUE returns CUE source for an expression equivalent to the
+// disjunction analyzed in r, but with the choice between arms made
+// explicit, so that schema authors can replace a disjunction that
+// is slow to evaluate with the result.
+//
+// Each [ValueSwitchNode] on a field becomes a struct that requires
+// the field to hold one of the values switched on, with an if
+// comprehension for each value that embeds the arms chosen for it:
+//
+//	{
+//		type!: "a" | "b"
+//		if type == "a" {#A}
+//		if type == "b" {#B}
+//	}
+//
+// A [KindSwitchNode] at the root becomes a disjunction of its
+// branches, which CUE can tell apart cheaply as their kinds differ.
+// CUE has no way for a comprehension to test the kind of a field
+// without also constraining it, so other kind switches, field presence
+// and absence checks and value switches with a default branch become a
+// disjunction of the arms that remain possible at that point.
+//
+// Arms are written as references to the names in r.Names where
+// available, so the result is intended to be placed alongside the
+// definitions that the arms refer to; other arms are written out
+// in full.
+func RewriteCUE(r *Result) ([]byte, error) {
+	w := &cueRewriter{
+		r: r,
+	}
+	expr := w.expr(r.Tree)
+	src, err := format.Source([]byte(expr))
+	if err != nil {
+		return nil, fmt.Errorf("cannot format rewritten CUE: %v", err)
+	}
+	return src, nil
+}
+
+type cueRewriter struct {
+	r *Result
+	// aliases holds the number of aliases made so far,
+	// to keep their names unique.
+	aliases int
+}
+
+// expr returns a CUE expression for the arms chosen by n
+// for the value at the root.
+func (w *cueRewriter) expr(n DecisionNode) string {
+	switch n := n.(type) {
+	case *OptionalNode:
+		return w.expr(n.Present)
+	case *GroupNode:
+		return w.expr(n.Select)
+	case *KindSwitchNode:
+		if n.Path != "." {
+			break
+		}
+		var exprs []string
+		for _, k := range slices.Sorted(maps.Keys(n.Branches)) {
+			if x := w.expr(n.Branches[k]); !slices.Contains(exprs, x) {
+				exprs = append(exprs, x)
+			}
+		}
+		return strings.Join(exprs, " | ")
+	case *ValueSwitchNode:
+		if !rewritableSwitch(n) {
+			break
+		}
+		var buf strings.Builder
+		buf.WriteString("{\n")
+		w.valueSwitch(&buf, n)
+		buf.WriteString("}")
+		return buf.String()
+	}
+	return w.arms(n.Possible())
+}
+
+// valueSwitch writes the declarations and comprehensions
+// for n, which must satisfy rewritableSwitch, to buf.
+func (w *cueRewriter) valueSwitch(buf *strings.Builder, n *ValueSwitchNode) {
+	names := splitPath(n.Path)
+	ref := names[0]
+	label := names[0]
+	if strings.HasPrefix(label, `"`) {
+		// The label isn't an identifier, so it
+		// needs an alias to be referred to.
+		ref = fmt.Sprintf("X%d", w.aliases)
+		w.aliases++
+		label = ref + "=" + label
+	}
+	for _, name := range names[1:] {
+		if strings.HasPrefix(name, `"`) {
+			ref += "[" + name + "]"
+		} else {
+			ref += "." + name
+		}
+	}
+	vals := slices.SortedFunc(maps.Keys(n.Branches), Atom.compare)
+	fmt.Fprintf(buf, "%s!: ", label)
+	for _, name := range names[1:] {
+		fmt.Fprintf(buf, "%s!: ", name)
+	}
+	buf.WriteString(strings.Join(slices.Collect(iterMap(slices.Values(vals), Atom.String)), " | "))
+	buf.WriteString("\n")
+	for _, g := range n.foldBranches(nil) {
+		conds := make([]string, len(g.values))
+		for i, v := range g.values {
+			conds[i] = fmt.Sprintf("%s == %v", ref, v)
+		}
+		fmt.Fprintf(buf, "if %s {\n", strings.Join(conds, " || "))
+		w.body(buf, g.node)
+		buf.WriteString("}\n")
+	}
+}
+
+// body writes the contents of a comprehension
+// that chooses the arms chosen by n.
+func (w *cueRewriter) body(buf *strings.Builder, n DecisionNode) {
+	switch n := n.(type) {
+	case *GroupNode:
+		w.body(buf, n.Select)
+		return
+	case *ValueSwitchNode:
+		if rewritableSwitch(n) {
+			w.valueSwitch(buf, n)
+			return
+		}
+	}
+	buf.WriteString(w.arms(n.Possible()))
+	buf.WriteString("\n")
+}
+
+// arms returns a disjunction of the given arms.
+func (w *cueRewriter) arms(arms IntSet) string {
+	if arms.Len() == 0 {
+		return "_|_"
+	}
+	var exprs []string
+	for _, arm := range sortedInts(arms) {
+		exprs = append(exprs, w.arm(arm))
+	}
+	return strings.Join(exprs, " | ")
+}
+
+// arm returns an expression for the given arm.
+func (w *cueRewriter) arm(arm int) string {
+	if arm < len(w.r.Names) && w.r.Names[arm] != "" {
+		return w.r.Names[arm]
+	}
+	src, err := format.Node(w.r.Arms[arm].Syntax(cue.Raw()))
+	if err != nil {
+		// Shouldn't happen, but produce valid
+		// syntax anyway.
+		return "_"
+	}
+	if strings.ContainsAny(string(src), " \n") {
+		return "(" + string(src) + ")"
+	}
+	return string(src)
+}
+
+// rewritableSwitch reports whether n can be rewritten as
+// if comprehensions: it must switch on a field with no
+// list index in its path, and have no default branch.
+func rewritableSwitch(n *ValueSwitchNode) bool {
+	if n.Path == "." || n.Optional || slices.ContainsFunc(splitPath(n.Path), isIndex) {
+		return false
+	}
+	switch n.Default.(type) {
+	case nil, ErrorNode:
+		return true
+	}
+	return false
+}
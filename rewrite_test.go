@@ -0,0 +1,100 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var rewriteCUETests = []struct {
+	testName string
+	cue      string
+	want     string
+	data     []string
+}{{
+	testName: "TaggedStructs",
+	cue: `
+#A: {type!: "a", x!: int}
+#B: {type!: "b"}
+x: #A | #B | [...string] | string
+`,
+	want: `
+string | [...string] | {
+	type!: "a" | "b"
+	if type == "a" {
+		#A
+	}
+	if type == "b" {
+		#B
+	}
+}
+`,
+	data: []string{`"s"`, `["s"]`, `{type: "a", x: 1}`, `{type: "b"}`, `1`, `{type: "a"}`, `{type: "c"}`, `{x: 1}`, `{type: "b", x: 1}`},
+}, {
+	testName: "NestedPath",
+	cue: `
+x: {"x.y"!: {k!: "p"}, v!: int} | {"x.y"!: {k!: "q"}, w!: string}
+`,
+	want: `
+{
+	X0="x.y"!: k!: "p" | "q"
+	if X0.k == "p" {
+		({
+			"x.y"!: {
+				k!: "p"
+			}
+			v!: int
+		})
+	}
+	if X0.k == "q" {
+		({
+			"x.y"!: {
+				k!: "q"
+			}
+			w!: string
+		})
+	}
+}
+`,
+	data: []string{`{"x.y": k: "p", v: 1}`, `{"x.y": k: "q", w: "s"}`, `{"x.y": k: "p", v: "s"}`, `{"x.y": k: "r"}`},
+}, {
+	testName: "FieldAbsence",
+	cue: `
+x: {a!: int} | {b!: int}
+`,
+	want: `
+({
+	a!: int
+}) | ({
+	b!: int
+})
+`,
+	data: []string{`{a: 1}`, `{b: 1}`, `{a: "s"}`, `{a: 1, b: 1}`},
+}}
+
+func TestRewriteCUE(t *testing.T) {
+	for _, test := range rewriteCUETests {
+		t.Run(test.testName, func(t *testing.T) {
+			ctx := cuecontext.New()
+			v := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			r := DiscriminateValue(v.LookupPath(cue.ParsePath("x")))
+			src, err := RewriteCUE(r)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.Equals(string(src), strings.TrimPrefix(test.want, "\n")))
+
+			// The rewrite accepts the same data as the original.
+			v = ctx.CompileString(test.cue + "\nrewritten: " + string(src))
+			qt.Assert(t, qt.IsNil(v.Err()))
+			for _, data := range test.data {
+				d := ctx.CompileString(data)
+				want := v.LookupPath(cue.ParsePath("x")).Unify(d).Validate(cue.Concrete(true))
+				got := v.LookupPath(cue.ParsePath("rewritten")).Unify(d).Validate(cue.Concrete(true))
+				qt.Check(t, qt.Equals(got == nil, want == nil), qt.Commentf("data %s; got %v; want %v", data, got, want))
+			}
+		})
+	}
+}
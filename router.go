@@ -0,0 +1,365 @@
+package cuediscrim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// GoRoute describes an HTTP endpoint whose request body is
+// an instance of a union, for [GenerateGoRouter].
+type GoRoute struct {
+	// Name holds the name of the endpoint, such as CreateShape,
+	// which must be an exported Go identifier. The declarations
+	// for the endpoint are named after it.
+	Name string
+
+	// Method holds the HTTP method of the endpoint, such as POST.
+	Method string
+
+	// Path holds the pattern for the path of the endpoint, such as
+	// /shapes/{id}, in the syntax understood by both
+	// net/http.ServeMux and chi.
+	Path string
+
+	// Union holds the union that the body is an instance of,
+	// with the Go type of each of its arms, as checked by
+	// [NewGoTypeManifest].
+	Union GoTypeUnion
+}
+
+// GoChiRouter causes the code generated by [GenerateGoRouter] to
+// register its handlers with a chi.Router from
+// github.com/go-chi/chi/v5 rather than with a net/http.ServeMux.
+func GoChiRouter(enable bool) GoOption {
+	return func(o *goOptions) {
+		o.chi = enable
+	}
+}
+
+// GenerateGoRouter returns Go source code for an HTTP routing layer
+// for the given endpoints. For each endpoint, named CreateShape, say,
+// it declares a struct type CreateShapeHandlers with a handler
+// function for each distinct Go type of the arms of its union,
+// as in:
+//
+//	type CreateShapeHandlers struct {
+//		Circle func(w http.ResponseWriter, req *http.Request, body *shapes.Circle)
+//		Square func(w http.ResponseWriter, req *http.Request, body *shapes.Square)
+//	}
+//
+// and a function CreateShape that returns an http.Handler that
+// classifies the JSON request body with the code that [GenerateGo]
+// generates for the decision tree of the union, decodes it into the
+// Go type of the arm it selects and passes it to the handler for that
+// type. Arms that were merged with [MergeCompatible] can be given
+// the same Go type, so that they share a handler. A body that is not
+// JSON, or that can't be decoded into the type, is rejected with
+// status 400 Bad Request; one that is an instance of no arm is
+// rejected with status 422 Unprocessable Entity; and one whose
+// handler is nil is rejected with status 501 Not Implemented.
+//
+// A function RegisterRoutes registers the handler for each endpoint
+// with a net/http.ServeMux, using a pattern that includes the method,
+// or with a chi.Router when [GoChiRouter] is specified, taking the
+// handlers for all the endpoints in a Routes struct with a field
+// named after each one.
+//
+// The union of each endpoint is checked as by [NewGoTypeManifest]
+// and its errors are returned, prefixed by the name of the endpoint.
+// Of the options, only [GoPackage], [GoGeneratedBy] and
+// [GoChiRouter] have any effect.
+func GenerateGoRouter(routes []GoRoute, opts ...GoOption) ([]byte, error) {
+	o := goOptions{
+		pkg:     "discrim",
+		command: "cuediscrim",
+	}
+	for _, f := range opts {
+		f(&o)
+	}
+	if !isGoIdent(o.pkg) {
+		return nil, fmt.Errorf("invalid Go identifier in package %q", o.pkg)
+	}
+	var errs []error
+	names := make(map[string]bool)
+	manifests := make([]GoTypeManifestUnion, len(routes))
+	for i, rt := range routes {
+		if !token.IsExported(rt.Name) || !isGoIdent(rt.Name) {
+			errs = append(errs, fmt.Errorf("invalid endpoint name %q", rt.Name))
+			continue
+		}
+		if names[rt.Name] {
+			errs = append(errs, fmt.Errorf("duplicate endpoint name %q", rt.Name))
+			continue
+		}
+		names[rt.Name] = true
+		if rt.Method == "" || strings.ContainsAny(rt.Method, " \t") || !strings.HasPrefix(rt.Path, "/") {
+			errs = append(errs, fmt.Errorf("%s: invalid method %q or path %q", rt.Name, rt.Method, rt.Path))
+			continue
+		}
+		mu, uerrs := goTypeManifestUnion(rt.Union)
+		for _, err := range uerrs {
+			errs = append(errs, fmt.Errorf("%s: %s: %v", rt.Name, rt.Union.Name, err))
+		}
+		manifests[i] = mu
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	imports := newGoImports("bytes", "encoding/json", "io", "math", "net/http", "strconv", "strings")
+	if o.chi {
+		imports.add("github.com/go-chi/chi/v5")
+	}
+	var body bytes.Buffer
+	for i, rt := range routes {
+		decls, regexps := goDiscriminatorDecls(rt.Union.Result.Tree, goOptions{
+			funcName: "discriminate" + rt.Name,
+		})
+		if regexps {
+			imports.add("regexp")
+		}
+		writeGoRoute(&body, rt, manifests[i], imports)
+		body.Write(decls)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by %s. DO NOT EDIT.\n\n", o.command)
+	fmt.Fprintf(&buf, "package %s\n\n", o.pkg)
+	imports.write(&buf)
+	fmt.Fprintf(&buf, "// Routes holds the handlers for all the endpoints.\n")
+	fmt.Fprintf(&buf, "type Routes struct {\n")
+	for _, rt := range routes {
+		fmt.Fprintf(&buf, "%s %sHandlers\n", rt.Name, rt.Name)
+	}
+	fmt.Fprintf(&buf, "}\n\n")
+	fmt.Fprintf(&buf, "// RegisterRoutes registers the handler for each endpoint with r.\n")
+	if o.chi {
+		fmt.Fprintf(&buf, "func RegisterRoutes(r chi.Router, routes Routes) {\n")
+		for _, rt := range routes {
+			fmt.Fprintf(&buf, "r.Method(%q, %q, %s(routes.%s))\n", rt.Method, rt.Path, rt.Name, rt.Name)
+		}
+	} else {
+		fmt.Fprintf(&buf, "func RegisterRoutes(r *http.ServeMux, routes Routes) {\n")
+		for _, rt := range routes {
+			fmt.Fprintf(&buf, "r.Handle(%q, %s(routes.%s))\n", rt.Method+" "+rt.Path, rt.Name, rt.Name)
+		}
+	}
+	fmt.Fprintf(&buf, "}\n\n")
+	buf.Write(body.Bytes())
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("cannot format generated code: %v", err)
+	}
+	return src, nil
+}
+
+// goRouteHandler holds a handler function of an endpoint
+// generated by [GenerateGoRouter].
+type goRouteHandler struct {
+	// field holds the name of the field holding the function.
+	field string
+	// goType holds the Go type of the body it takes.
+	goType string
+	// arms holds the arms whose Go type that is,
+	// and names holds their names.
+	arms  []int
+	names []string
+}
+
+// writeGoRoute writes the declarations of the handlers type and the
+// handler function for rt, whose union has the Go types in mu,
+// to buf, adding the packages it uses to imports.
+func writeGoRoute(buf *bytes.Buffer, rt GoRoute, mu GoTypeManifestUnion, imports *goImports) {
+	var handlers []*goRouteHandler
+	byType := make(map[GoTypeRef]*goRouteHandler)
+	fields := make(map[string]bool)
+	for _, arm := range mu.Arms {
+		h := byType[arm.Type]
+		if h == nil {
+			field := arm.Type.Name
+			if !token.IsExported(field) {
+				// A predeclared type such as string.
+				field = camelName(field)
+			}
+			field1 := field
+			for i := 2; fields[field1]; i++ {
+				field1 = fmt.Sprintf("%s%d", field, i)
+			}
+			fields[field1] = true
+			h = &goRouteHandler{
+				field:  field1,
+				goType: imports.qualify(arm.Type),
+			}
+			byType[arm.Type] = h
+			handlers = append(handlers, h)
+		}
+		h.arms = append(h.arms, arm.Arm)
+		if arm.Name != "" {
+			h.names = append(h.names, arm.Name)
+		}
+	}
+	endpoint := rt.Method + " " + rt.Path
+	fmt.Fprintf(buf, "// %sHandlers holds a handler for each Go type of the\n", rt.Name)
+	fmt.Fprintf(buf, "// body of %s requests, which is an instance of %s.\n", endpoint, rt.Union.Name)
+	fmt.Fprintf(buf, "type %sHandlers struct {\n", rt.Name)
+	for _, h := range handlers {
+		if len(h.names) > 0 {
+			fmt.Fprintf(buf, "// %s handles bodies that are instances of %s.\n", h.field, strings.Join(h.names, ", "))
+		}
+		fmt.Fprintf(buf, "%s func(w http.ResponseWriter, req *http.Request, body *%s)\n", h.field, h.goType)
+	}
+	fmt.Fprintf(buf, "}\n\n")
+	fmt.Fprintf(buf, "// %s returns a handler for %s requests that\n", rt.Name, endpoint)
+	fmt.Fprintf(buf, "// decides which arm of %s the body is an instance of\n", rt.Union.Name)
+	fmt.Fprintf(buf, "// and passes it, decoded into the Go type of that arm,\n")
+	fmt.Fprintf(buf, "// to the corresponding handler in h.\n")
+	fmt.Fprintf(buf, "func %s(h %sHandlers) http.Handler {\n", rt.Name, rt.Name)
+	fmt.Fprintf(buf, "return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {\n")
+	fmt.Fprintf(buf, "data, err := io.ReadAll(req.Body)\n")
+	fmt.Fprintf(buf, "if err != nil {\n")
+	fmt.Fprintf(buf, "http.Error(w, \"cannot read request body: \"+err.Error(), http.StatusBadRequest)\n")
+	fmt.Fprintf(buf, "return\n}\n")
+	fmt.Fprintf(buf, "arms, err := discriminate%sJSON(data)\n", rt.Name)
+	fmt.Fprintf(buf, "if err != nil {\n")
+	fmt.Fprintf(buf, "http.Error(w, \"invalid request body: \"+err.Error(), http.StatusBadRequest)\n")
+	fmt.Fprintf(buf, "return\n}\n")
+	fmt.Fprintf(buf, "if len(arms) == 0 {\n")
+	fmt.Fprintf(buf, "http.Error(w, %s, http.StatusUnprocessableEntity)\n", strconv.Quote("request body is not an instance of "+rt.Union.Name))
+	fmt.Fprintf(buf, "return\n}\n")
+	fmt.Fprintf(buf, "switch arms[0] {\n")
+	for _, h := range handlers {
+		cases := make([]string, len(h.arms))
+		for i, arm := range h.arms {
+			cases[i] = strconv.Itoa(arm)
+		}
+		fmt.Fprintf(buf, "case %s:\n", strings.Join(cases, ", "))
+		fmt.Fprintf(buf, "if h.%s == nil {\n", h.field)
+		fmt.Fprintf(buf, "http.Error(w, %s, http.StatusNotImplemented)\n", strconv.Quote("no handler for request body of type "+h.goType))
+		fmt.Fprintf(buf, "return\n}\n")
+		fmt.Fprintf(buf, "var body %s\n", h.goType)
+		fmt.Fprintf(buf, "if err := json.Unmarshal(data, &body); err != nil {\n")
+		fmt.Fprintf(buf, "http.Error(w, %s+err.Error(), http.StatusBadRequest)\n", strconv.Quote("cannot decode request body as "+h.goType+": "))
+		fmt.Fprintf(buf, "return\n}\n")
+		fmt.Fprintf(buf, "h.%s(w, req, &body)\n", h.field)
+	}
+	fmt.Fprintf(buf, "default:\n")
+	fmt.Fprintf(buf, "http.Error(w, %s, http.StatusUnprocessableEntity)\n", strconv.Quote("request body is not an instance of "+rt.Union.Name))
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "})\n}\n\n")
+}
+
+// goImports holds the imports of generated Go code,
+// with the name that each is referred to by.
+type goImports struct {
+	paths []string
+	names map[string]string
+	used  map[string]bool
+}
+
+// newGoImports returns a set of imports holding the
+// given standard library packages.
+func newGoImports(paths ...string) *goImports {
+	imports := &goImports{
+		names: make(map[string]string),
+		used:  make(map[string]bool),
+	}
+	for _, p := range paths {
+		imports.add(p)
+	}
+	return imports
+}
+
+// add adds the package with the given import path if it isn't
+// already present, and returns the name it is referred to by.
+// The name is the last element of the path, ignoring any major
+// version suffix such as /v5, made unique with a numeric suffix
+// if needed.
+func (imports *goImports) add(path string) string {
+	if name, ok := imports.names[path]; ok {
+		return name
+	}
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && isMajorVersion(name) {
+		name = elems[len(elems)-2]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, name)
+	if !isGoIdent(name) || token.Lookup(name).IsKeyword() {
+		name = "pkg"
+	}
+	name1 := name
+	for i := 2; imports.used[name1]; i++ {
+		name1 = fmt.Sprintf("%s%d", name, i)
+	}
+	imports.used[name1] = true
+	imports.names[path] = name1
+	imports.paths = append(imports.paths, path)
+	return name1
+}
+
+// qualify returns the name of t in generated code
+// that imports its package.
+func (imports *goImports) qualify(t GoTypeRef) string {
+	if t.ImportPath == "" {
+		return t.Name
+	}
+	return imports.add(t.ImportPath) + "." + t.Name
+}
+
+// write writes the import declaration to buf, naming each package
+// explicitly where its name isn't the last element of its path.
+// Packages of the standard library come first, as goimports
+// would have them.
+func (imports *goImports) write(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "import (\n")
+	paths := slices.SortedFunc(slices.Values(imports.paths), func(p1, p2 string) int {
+		if std1, std2 := isStdPath(p1), isStdPath(p2); std1 != std2 {
+			if std1 {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(p1, p2)
+	})
+	for i, path := range paths {
+		if i > 0 && isStdPath(path) != isStdPath(paths[i-1]) {
+			fmt.Fprintf(buf, "\n")
+		}
+		name := imports.names[path]
+		if strings.HasSuffix(path, "/"+name) || path == name {
+			fmt.Fprintf(buf, "\t%q\n", path)
+		} else {
+			fmt.Fprintf(buf, "\t%s %q\n", name, path)
+		}
+	}
+	fmt.Fprintf(buf, ")\n\n")
+}
+
+// isStdPath reports whether path is the import path of
+// a package in the standard library, whose first element,
+// unlike that of a module path, has no dot.
+func isStdPath(path string) bool {
+	first, _, _ := strings.Cut(path, "/")
+	return !strings.Contains(first, ".")
+}
+
+// isMajorVersion reports whether elem is a major version
+// suffix of an import path, such as v2.
+func isMajorVersion(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' {
+		return false
+	}
+	_, err := strconv.Atoi(elem[1:])
+	return err == nil
+}
@@ -0,0 +1,164 @@
+package cuediscrim
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+// routerTestSchema holds the schema for the routes
+// used by the tests of GenerateGoRouter.
+const routerTestSchema = `
+#Circle: {type!: "circle", r!: number}
+#Square: {type!: "square", side!: number}
+#Shape: #Circle | #Square | {type!: "blob"}
+`
+
+func routerTestRoutes(t *testing.T) []GoRoute {
+	v := cuecontext.New().CompileString(routerTestSchema)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	return []GoRoute{{
+		Name:   "CreateShape",
+		Method: "POST",
+		Path:   "/shapes",
+		Union: GoTypeUnion{
+			Name:   "#Shape",
+			Result: DiscriminateValue(v.LookupPath(cue.ParsePath("#Shape"))),
+			Types: map[string]string{
+				"#Circle": "example.test/gen/shapes.Circle",
+				"#Square": "example.test/gen/shapes.Square",
+				"blob":    "string",
+			},
+		},
+	}}
+}
+
+func TestGenerateGoRouter(t *testing.T) {
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not available")
+	}
+	if testing.Short() {
+		t.Skip("skipping build of generated code in short mode")
+	}
+	src, err := GenerateGoRouter(routerTestRoutes(t), GoPackage("main"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.StringContains(string(src), `
+type CreateShapeHandlers struct {
+	// Circle handles bodies that are instances of #Circle.
+	Circle func(w http.ResponseWriter, req *http.Request, body *shapes.Circle)
+	// Square handles bodies that are instances of #Square.
+	Square func(w http.ResponseWriter, req *http.Request, body *shapes.Square)
+	String func(w http.ResponseWriter, req *http.Request, body *string)
+}
+`))
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":    "module example.test/gen\n\ngo 1.22\n",
+		"router.go": string(src),
+		"shapes/shapes.go": `package shapes
+
+type Circle struct {
+	R float64 ` + "`json:\"r\"`" + `
+}
+
+type Square struct {
+	Side float64 ` + "`json:\"side\"`" + `
+}
+`,
+		"main.go": `package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"example.test/gen/shapes"
+)
+
+func main() {
+	mux := http.NewServeMux()
+	RegisterRoutes(mux, Routes{
+		CreateShape: CreateShapeHandlers{
+			Circle: func(w http.ResponseWriter, req *http.Request, body *shapes.Circle) {
+				fmt.Fprintf(w, "circle %v", body.R)
+			},
+			Square: func(w http.ResponseWriter, req *http.Request, body *shapes.Square) {
+				fmt.Fprintf(w, "square %v", body.Side)
+			},
+		},
+	})
+	for _, body := range []string{
+		` + "`" + `{"type": "circle", "r": 2}` + "`" + `,
+		` + "`" + `{"type": "square", "side": 3}` + "`" + `,
+		` + "`" + `{"type": "blob"}` + "`" + `,
+		` + "`" + `{"type": "triangle"}` + "`" + `,
+		` + "`" + `{"type": "circle", "r": "x"}` + "`" + `,
+		` + "`" + `{` + "`" + `,
+	} {
+		req := httptest.NewRequest("POST", "/shapes", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		fmt.Println(rec.Code, strings.TrimSpace(rec.Body.String()))
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/shapes", nil))
+	fmt.Println(rec.Code)
+}
+`,
+	}
+	for name, data := range files {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o777)
+		qt.Assert(t, qt.IsNil(err))
+		err = os.WriteFile(filepath.Join(dir, name), []byte(data), 0o666)
+		qt.Assert(t, qt.IsNil(err))
+	}
+	cmd := exec.Command(goCmd, "run", ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	qt.Assert(t, qt.IsNil(err), qt.Commentf("output: %s", out))
+	qt.Check(t, qt.Equals(string(out), strings.TrimPrefix(`
+200 circle 2
+200 square 3
+501 no handler for request body of type string
+422 request body is not an instance of #Shape
+400 cannot decode request body as shapes.Circle: json: cannot unmarshal string into Go struct field Circle.r of type float64
+400 invalid request body: unexpected EOF
+405
+`, "\n")))
+}
+
+func TestGenerateGoRouterChi(t *testing.T) {
+	src, err := GenerateGoRouter(routerTestRoutes(t), GoChiRouter(true))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.StringContains(string(src), `
+	chi "github.com/go-chi/chi/v5"
+`))
+	qt.Check(t, qt.StringContains(string(src), `
+func RegisterRoutes(r chi.Router, routes Routes) {
+	r.Method("POST", "/shapes", CreateShape(routes.CreateShape))
+}
+`))
+}
+
+func TestGenerateGoRouterErrors(t *testing.T) {
+	routes := routerTestRoutes(t)
+	routes = append(routes, GoRoute{
+		Name:   "createShape",
+		Method: "POST",
+		Path:   "/shapes2",
+		Union:  routes[0].Union,
+	})
+	delete(routes[0].Union.Types, "#Square")
+	_, err := GenerateGoRouter(routes)
+	qt.Check(t, qt.ErrorMatches(err, `CreateShape: #Shape: #Square has no Go type
+invalid endpoint name "createShape"`))
+}
@@ -0,0 +1,52 @@
+package cuediscrim
+
+import (
+	"fmt"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+)
+
+// SelfContained returns v rebuilt from a self-contained export of
+// its syntax, like that produced by cue def --inline-imports: the
+// definitions that v uses from imported packages are inlined, so
+// the result no longer depends on how the imports are resolved. This
+// makes analysis results comparable between environments that
+// resolve imports differently.
+//
+// Values in the result have no source positions in the original
+// files, and attributes on fields in imported packages, such as
+// @deprecated, are not retained.
+func SelfContained(v cue.Value) (cue.Value, error) {
+	n := v.Syntax(
+		cue.Definitions(true),
+		cue.Hidden(true),
+		cue.Optional(true),
+		cue.Attributes(true),
+		cue.Docs(true),
+		cue.InlineImports(true),
+	)
+	var sv cue.Value
+	switch n := n.(type) {
+	case *ast.File:
+		sv = v.Context().BuildFile(n)
+	case ast.Expr:
+		sv = v.Context().BuildExpr(n)
+	default:
+		return cue.Value{}, fmt.Errorf("unexpected syntax %T for value", n)
+	}
+	if err := sv.Err(); err != nil {
+		return cue.Value{}, fmt.Errorf("cannot build self-contained value: %v", err)
+	}
+	return sv, nil
+}
+
+// DiscriminateSelfContained is like [DiscriminateValue] except
+// that it analyzes the disjunction v as exported by [SelfContained].
+func DiscriminateSelfContained(v cue.Value, opts ...Option) (*Result, error) {
+	sv, err := SelfContained(v)
+	if err != nil {
+		return nil, err
+	}
+	return DiscriminateValue(sv, opts...), nil
+}
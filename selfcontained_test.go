@@ -0,0 +1,56 @@
+package cuediscrim
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/load"
+	"github.com/go-quicktest/qt"
+)
+
+func TestDiscriminateSelfContained(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"cue.mod/module.cue": `
+module: "example.com/sc"
+language: version: "v0.12.0"
+`,
+		"lib/lib.cue": `
+package lib
+
+#A: {kind!: "a", x?: int}
+#B: {kind!: "b"}
+#U: #A | #B
+`,
+		"main.cue": `
+package main
+
+import "example.com/sc/lib"
+
+u: lib.#U | {kind!: "c"}
+`,
+	} {
+		path := filepath.Join(dir, name)
+		qt.Assert(t, qt.IsNil(os.MkdirAll(filepath.Dir(path), 0o777)))
+		qt.Assert(t, qt.IsNil(os.WriteFile(path, []byte(content), 0o666)))
+	}
+	insts := load.Instances([]string{"."}, &load.Config{Dir: dir})
+	ctx := cuecontext.New()
+	v := ctx.BuildInstance(insts[0])
+	qt.Assert(t, qt.IsNil(v.Err()))
+
+	sv, err := SelfContained(v)
+	qt.Assert(t, qt.IsNil(err))
+	op, _ := sv.LookupPath(cue.ParsePath("u")).Expr()
+	qt.Check(t, qt.Equals(op, cue.OrOp))
+
+	r, err := DiscriminateSelfContained(v.LookupPath(cue.ParsePath("u")))
+	qt.Assert(t, qt.IsNil(err))
+	want := DiscriminateValue(v.LookupPath(cue.ParsePath("u")))
+	qt.Check(t, qt.IsTrue(r.Perfect))
+	qt.Check(t, qt.HasLen(r.Arms, 3))
+	qt.Check(t, qt.Equals(NodeString(r.Tree), NodeString(want.Tree)))
+}
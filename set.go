@@ -53,7 +53,7 @@ func union[T comparable](s1, s2 Set[T]) Set[T] {
 
 func intersect[T comparable](s0, s1 Set[T]) Set[T] {
 	if s0.Len() == 0 {
-		return s1
+		return s0
 	}
 	if s1.Len() == 0 {
 		return s1
@@ -67,6 +67,17 @@ func intersect[T comparable](s0, s1 Set[T]) Set[T] {
 	return s2
 }
 
+// without returns the members of s0 that aren't in s1.
+func without[T comparable](s0, s1 Set[T]) Set[T] {
+	s2 := make(mapSet[T])
+	for x := range s0.Values() {
+		if !s1.Has(x) {
+			s2[x] = true
+		}
+	}
+	return s2
+}
+
 type singleInt int
 
 func (i singleInt) Values() iter.Seq[int] {
@@ -98,6 +109,43 @@ func SetString[T cmp.Ordered](s Set[T]) string {
 	return buf.String()
 }
 
+// ArmSetString is like [SetString] except that when any arm in s
+// has a name in names, such as that returned by [ArmNames], the
+// arms are shown as a disjunction of their names, as in
+// #CreateRequest | #DeleteRequest. Arms without a name are shown
+// by their index.
+func ArmSetString(s IntSet, names []string) string {
+	arms := slices.Sorted(s.Values())
+	if !slices.ContainsFunc(arms, func(i int) bool {
+		return i < len(names) && names[i] != ""
+	}) {
+		return SetString(s)
+	}
+	strs := make([]string, len(arms))
+	for i, arm := range arms {
+		if arm < len(names) && names[arm] != "" {
+			strs[i] = names[arm]
+		} else {
+			strs[i] = fmt.Sprint(arm)
+		}
+	}
+	return strings.Join(strs, " | ")
+}
+
+// armListString is like [ArmSetString] except that it shows
+// the arms in the given order, as a list.
+func armListString(arms []int, names []string) string {
+	strs := make([]string, len(arms))
+	for i, arm := range arms {
+		if arm < len(names) && names[arm] != "" {
+			strs[i] = names[arm]
+		} else {
+			strs[i] = fmt.Sprint(arm)
+		}
+	}
+	return "[" + strings.Join(strs, ", ") + "]"
+}
+
 func revSet[T comparable](s Set[T], rev func(T) Set[T]) Set[T] {
 	if rev == nil {
 		return s
@@ -138,6 +186,6 @@ func (s *revSetImpl[T]) Len() int {
 
 func (s *revSetImpl[T]) init() {
 	if s.new == nil {
-		s.new = mapSetOf(s.orig.Values())
+		s.new = mapSetOf(s.Values())
 	}
 }
@@ -0,0 +1,230 @@
+package cuediscrim
+
+import (
+	"cmp"
+	"maps"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+)
+
+// guardShortLists returns a copy of n in which each switch on a
+// path through an element of a list, such as [0].kind, is preceded
+// by a switch on the length of the list when some of the arms it
+// might choose allow the list to be too short to have that element.
+// Without it, such a list, including the empty list, would choose no
+// arm; with it, the list chooses the arms that allow it (see
+// [Warnings]).
+//
+// The arms are indexed as in arms. minLen holds the length that
+// each list is known to have at least.
+func guardShortLists(n DecisionNode, arms []cue.Value, deprecated IntSet, minLen map[string]int) DecisionNode {
+	var paths []string
+	switch n := n.(type) {
+	case *KindSwitchNode, *ValueSwitchNode, *RangeSwitchNode, *RegexSwitchNode:
+		paths = []string{switchPath(n)}
+	case *LenSwitchNode:
+		paths = []string{n.Path}
+	case *TupleSwitchNode:
+		paths = n.Paths
+	}
+	need := make(map[string]int)
+	for _, path := range paths {
+		for list, l := range listLens(path) {
+			if l > minLen[list] && l > need[list] {
+				need[list] = l
+			}
+		}
+	}
+	n = guardChildren(n, arms, deprecated, withMinLen(minLen, need))
+	// Guard the innermost list first so that the
+	// outermost list is tested first.
+	lists := slices.SortedFunc(maps.Keys(need), func(a, b string) int {
+		return cmp.Or(len(splitPath(b))-len(splitPath(a)), strings.Compare(a, b))
+	})
+	for _, list := range lists {
+		short := Interval{
+			Max: Bound{Value: strconv.Itoa(need[list] - 1), Inclusive: true},
+		}
+		if need[list] == 1 {
+			// As for lengthRanges, the lower bound
+			// is left out unless the interval holds
+			// only zero.
+			short.Min = Bound{Value: "0", Inclusive: true}
+		}
+		allowed := make(mapSet[int])
+		for arm := range n.Possible().Values() {
+			if arm >= 0 && arm < len(arms) && allowsLength(declaredField(arms[arm], list), short) {
+				allowed[arm] = true
+			}
+		}
+		if len(allowed) == 0 {
+			continue
+		}
+		leaf := &LeafNode{
+			Arms: allowed,
+		}
+		if deprecated.Len() > 0 {
+			if dep := intersect[int](allowed, deprecated); dep.Len() > 0 {
+				leaf.Deprecated = dep
+			}
+		}
+		n = &LenSwitchNode{
+			Path: list,
+			Branches: []RangeBranch{{
+				Interval: short,
+				Node:     leaf,
+			}},
+			Default: n,
+		}
+	}
+	return n
+}
+
+// guardChildren returns a copy of n with [guardShortLists]
+// applied to each of its children.
+func guardChildren(n DecisionNode, arms []cue.Value, deprecated IntSet, minLen map[string]int) DecisionNode {
+	guard := func(n DecisionNode) DecisionNode {
+		return guardShortLists(n, arms, deprecated, minLen)
+	}
+	switch n := n.(type) {
+	case *KindSwitchNode:
+		n1 := *n
+		n1.Branches = make(map[cue.Kind]DecisionNode, len(n.Branches))
+		for k, sub := range n.Branches {
+			n1.Branches[k] = guard(sub)
+		}
+		return &n1
+	case *ValueSwitchNode:
+		n1 := *n
+		n1.Branches = make(map[Atom]DecisionNode, len(n.Branches))
+		for a, sub := range n.Branches {
+			n1.Branches[a] = guard(sub)
+		}
+		n1.Default = guard(n.Default)
+		return &n1
+	case *RangeSwitchNode:
+		n1 := *n
+		n1.Branches = make([]RangeBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			b.Node = guard(b.Node)
+			n1.Branches[i] = b
+		}
+		n1.Default = guard(n.Default)
+		return &n1
+	case *LenSwitchNode:
+		n1 := *n
+		n1.Branches = make([]RangeBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			// The list at the path has at least the
+			// minimum length of the interval when
+			// the branch is taken.
+			b.Node = guardShortLists(b.Node, arms, deprecated, withMinLen(minLen, map[string]int{
+				n.Path: minLength(b.Interval),
+			}))
+			n1.Branches[i] = b
+		}
+		n1.Default = guard(n.Default)
+		return &n1
+	case *RegexSwitchNode:
+		n1 := *n
+		n1.Branches = make([]RegexBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			b.Node = guard(b.Node)
+			n1.Branches[i] = b
+		}
+		n1.Default = guard(n.Default)
+		return &n1
+	case *TupleSwitchNode:
+		n1 := *n
+		n1.Branches = make([]TupleBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			b.Node = guard(b.Node)
+			n1.Branches[i] = b
+		}
+		return &n1
+	case *FieldPresenceNode:
+		n1 := *n
+		n1.Branches = make([]PresenceBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			// The lists on the way to a present
+			// field have the elements on the way.
+			b.Node = guardShortLists(b.Node, arms, deprecated, withMinLen(minLen, listLens(b.Path)))
+			n1.Branches[i] = b
+		}
+		n1.Default = guard(n.Default)
+		return &n1
+	case *OptionalNode:
+		return &OptionalNode{
+			Present: guard(n.Present),
+		}
+	case *GroupNode:
+		return &GroupNode{
+			Name:   n.Name,
+			Select: guard(n.Select),
+		}
+	}
+	return n
+}
+
+// withMinLen returns minLen with the lengths in need added,
+// without changing minLen itself.
+func withMinLen(minLen, need map[string]int) map[string]int {
+	if len(need) == 0 {
+		return minLen
+	}
+	m := maps.Clone(minLen)
+	if m == nil {
+		m = make(map[string]int)
+	}
+	for list, n := range need {
+		m[list] = max(m[list], n)
+	}
+	return m
+}
+
+// listLens returns the lists that path selects elements of, each
+// with the length that the list must have for the element to exist:
+// for a[0].b[1].c, a with length 1 and a[0].b with length 2.
+func listLens(path string) map[string]int {
+	lens := make(map[string]int)
+	prefix := "."
+	for _, name := range splitPath(path) {
+		if isIndex(name) {
+			if i, err := strconv.Atoi(strings.Trim(name, "[]")); err == nil {
+				lens[prefix] = i + 1
+			}
+		}
+		prefix = pathConcat(prefix, name)
+	}
+	return lens
+}
+
+// allowsLength reports whether v allows a list
+// with a length in iv.
+func allowsLength(v cue.Value, iv Interval) bool {
+	if !v.Exists() {
+		return false
+	}
+	for _, iv1 := range lengthIntervals(v) {
+		if !iv.intersect(iv1).isEmpty() {
+			return true
+		}
+	}
+	return false
+}
+
+// minLength returns the smallest length in iv.
+func minLength(iv Interval) int {
+	r := iv.Min.rat()
+	if r == nil || r.Sign() < 0 {
+		return 0
+	}
+	if r.IsInt() && !iv.Min.Inclusive {
+		r.Add(r, big.NewRat(1, 1))
+	}
+	return int(ceilRat(r).Num().Int64())
+}
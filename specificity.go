@@ -0,0 +1,188 @@
+package cuediscrim
+
+import (
+	"slices"
+
+	"cuelang.org/go/cue"
+)
+
+// Ordered returns the arms of l in the order in which a decoder that
+// must pick a single arm should try them: its Precedence if that is
+// set, or ascending order otherwise.
+func (l *LeafNode) Ordered() []int {
+	if l.Precedence != nil {
+		return slices.Clone(l.Precedence)
+	}
+	return sortedInts(l.Arms)
+}
+
+// orderBySpecificity sets the Precedence field of each leaf in n
+// that holds several arms, as described for [LeafNode.Precedence].
+// The arms are indexed as in arms.
+func orderBySpecificity(n DecisionNode, arms []cue.Value) {
+	switch n := n.(type) {
+	case *LeafNode:
+		n.Precedence = specificityOrder(n.Arms, arms)
+	case *KindSwitchNode:
+		for _, sub := range n.Branches {
+			orderBySpecificity(sub, arms)
+		}
+	case *ValueSwitchNode:
+		for _, sub := range n.Branches {
+			orderBySpecificity(sub, arms)
+		}
+		if n.Default != nil {
+			orderBySpecificity(n.Default, arms)
+		}
+	case *RangeSwitchNode:
+		for _, b := range n.Branches {
+			orderBySpecificity(b.Node, arms)
+		}
+		if n.Default != nil {
+			orderBySpecificity(n.Default, arms)
+		}
+	case *LenSwitchNode:
+		for _, b := range n.Branches {
+			orderBySpecificity(b.Node, arms)
+		}
+		if n.Default != nil {
+			orderBySpecificity(n.Default, arms)
+		}
+	case *RegexSwitchNode:
+		for _, b := range n.Branches {
+			orderBySpecificity(b.Node, arms)
+		}
+		if n.Default != nil {
+			orderBySpecificity(n.Default, arms)
+		}
+	case *TupleSwitchNode:
+		for _, b := range n.Branches {
+			orderBySpecificity(b.Node, arms)
+		}
+	case *FieldPresenceNode:
+		for _, b := range n.Branches {
+			orderBySpecificity(b.Node, arms)
+		}
+		if n.Default != nil {
+			orderBySpecificity(n.Default, arms)
+		}
+	case *OptionalNode:
+		orderBySpecificity(n.Present, arms)
+	case *GroupNode:
+		orderBySpecificity(n.Select, arms)
+	}
+}
+
+// specificityOrder returns the members of s ordered so that each arm
+// comes before the arms that strictly subsume it, with arms otherwise
+// kept in ascending order. It returns nil if that is the same as
+// ascending order.
+func specificityOrder(s IntSet, arms []cue.Value) []int {
+	if s == nil || s.Len() < 2 {
+		return nil
+	}
+	remaining := sortedInts(s)
+	for _, i := range remaining {
+		if i < 0 || i >= len(arms) {
+			return nil
+		}
+	}
+	// above[i][j] reports whether remaining[i] is strictly
+	// more specific than remaining[j].
+	above := make([][]bool, len(remaining))
+	for i := range remaining {
+		above[i] = make([]bool, len(remaining))
+	}
+	for i, x := range remaining {
+		for j, y := range remaining[:i] {
+			above[i][j] = moreSpecific(arms[x], arms[y])
+			above[j][i] = moreSpecific(arms[y], arms[x])
+		}
+	}
+	indexes := make([]int, len(remaining))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	order := make([]int, 0, len(remaining))
+	for len(indexes) > 0 {
+		// Start with the first remaining arm and move to the
+		// first arm more specific than it until there is none,
+		// so that an arm moves only as far as it must. The
+		// number of steps is bounded in case the evaluator
+		// doesn't give a partial order.
+		k := 0
+		for range indexes {
+			k1 := slices.IndexFunc(indexes, func(j int) bool {
+				return above[j][indexes[k]]
+			})
+			if k1 < 0 {
+				break
+			}
+			k = k1
+		}
+		order = append(order, remaining[indexes[k]])
+		indexes = slices.Delete(indexes, k, k+1)
+	}
+	if slices.Equal(order, remaining) {
+		return nil
+	}
+	return order
+}
+
+// moreSpecific reports whether x is strictly more specific than y:
+// every value allowed by x is allowed by y but not the other way
+// around. When each subsumes the other, as can happen when
+// evaluation leaves out some of their constraints, the one that
+// declares more fields is taken to be the more specific.
+func moreSpecific(x, y cue.Value) bool {
+	if !subsumes(y, x) {
+		return false
+	}
+	if !subsumes(x, y) {
+		return true
+	}
+	return fieldCount(x) > fieldCount(y)
+}
+
+// subsumes reports whether y subsumes x. The evaluator can claim
+// that the arms of a disjunction subsume values that they don't,
+// because evaluation loses pattern constraints such as
+// [string]: string and the alternatives of a field with a default,
+// so y must also require no field that x doesn't require, and
+// every field in both must have no more values in x than in y.
+func subsumes(y, x cue.Value) bool {
+	if y.Subsume(x, cue.Schema()) != nil {
+		return false
+	}
+	required := make(map[string]bool)
+	for path := range allFields([]cue.Value{x}, intSetN(1), requiredLabel) {
+		required[path] = true
+	}
+	for path := range allFields([]cue.Value{y}, intSetN(1), requiredLabel) {
+		if !required[path] {
+			return false
+		}
+	}
+	for _, values := range allFields([]cue.Value{x, y}, intSetN(2), requiredLabel|optionalLabel|regularLabel) {
+		vx, vy := values[0], values[1]
+		if !vx.Exists() || !vy.Exists() {
+			continue
+		}
+		if !valueSetForValue(vx).without(valueSetForValue(vy)).isEmpty() {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldCount returns the number of fields declared in v,
+// at any depth.
+func fieldCount(v cue.Value) int {
+	n := 0
+	for path := range allFields([]cue.Value{v}, intSetN(1), requiredLabel|optionalLabel|regularLabel) {
+		if path != "." {
+			n++
+		}
+	}
+	return n
+}
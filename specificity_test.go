@@ -0,0 +1,77 @@
+package cuediscrim
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var specificityOrderTests = []struct {
+	testName string
+	cue      string
+	want     []int
+}{{
+	testName: "Range",
+	cue:      `>0 | >10`,
+	want:     []int{1, 0},
+}, {
+	testName: "Pattern",
+	cue:      `string | =~"^a"`,
+	want:     []int{1, 0},
+}, {
+	testName: "AlreadyOrdered",
+	cue:      `=~"^a" | string`,
+	want:     nil,
+}, {
+	testName: "Chain",
+	cue:      `number | int | 1`,
+	want:     []int{2, 1, 0},
+}, {
+	testName: "ExtraField",
+	cue:      `{a!: int} | {a!: int, b?: string}`,
+	want:     []int{1, 0},
+}, {
+	testName: "ExtraRequiredField",
+	cue:      `{a!: int} | {a!: int, b!: string}`,
+	want:     []int{1, 0},
+}, {
+	testName: "PatternConstraint",
+	cue:      `{a!: int} | {[string]: string}`,
+	want:     nil,
+}, {
+	testName: "OverlappingValues",
+	cue:      `{k!: "a" | "b", x?: int} | {k!: "b" | "c", y?: int}`,
+	want:     nil,
+}, {
+	testName: "Unrelated",
+	cue:      `{a!: int} | {b!: int} | {a!: 1}`,
+	want:     []int{2, 0, 1},
+}}
+
+func TestSpecificityOrder(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range specificityOrderTests {
+		t.Run(test.testName, func(t *testing.T) {
+			val := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			arms := Disjunctions(val)
+			got := specificityOrder(intSetN(len(arms)), arms)
+			qt.Check(t, qt.DeepEquals(got, test.want))
+		})
+	}
+}
+
+func TestLeafOrdered(t *testing.T) {
+	val := cuecontext.New().CompileString(`>0 | >10 | >20`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	tree, _, _ := Discriminate(Disjunctions(val))
+	qt.Check(t, qt.Equals(NodeString(tree), "choose({0, 1, 2}) precedence([2, 1, 0])\n"))
+	leaf, ok := tree.(*LeafNode)
+	qt.Assert(t, qt.IsTrue(ok))
+	qt.Check(t, qt.DeepEquals(leaf.Ordered(), []int{2, 1, 0}))
+
+	// A leaf in ascending order has no precedence.
+	leaf = &LeafNode{Arms: setOf(3, 1)}
+	qt.Check(t, qt.DeepEquals(leaf.Ordered(), []int{1, 3}))
+}
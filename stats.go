@@ -0,0 +1,156 @@
+package cuediscrim
+
+import (
+	"fmt"
+)
+
+// TreeStats holds summary information about the shape of a decision tree.
+// It's intended to help users and code generators decide how
+// a tree should be rendered.
+type TreeStats struct {
+	// Nodes holds the total number of nodes in the tree.
+	Nodes int
+	// Depth holds the maximum depth of the tree. A lone leaf has depth 1.
+	Depth int
+	// ValueSwitches holds the number of ValueSwitchNodes in the tree.
+	ValueSwitches int
+	// MaxValueBranches holds the largest number of
+	// branches found in any single ValueSwitchNode.
+	MaxValueBranches int
+	// ExpectedLookups holds the average number of field lookups
+	// made to classify a value, assuming that each arm in each leaf
+	// is equally likely. A TupleSwitchNode counts one lookup for
+	// each of its fields, and a FieldPresenceNode one for each
+	// field tested before reaching a branch.
+	ExpectedLookups float64
+}
+
+// Stats returns statistics about the given tree.
+func Stats(n DecisionNode) TreeStats {
+	var st TreeStats
+	st.add(n, 1)
+	st.ExpectedLookups = expectedLookups(n)
+	return st
+}
+
+func (st *TreeStats) add(n DecisionNode, depth int) {
+	if n == nil {
+		return
+	}
+	st.Nodes++
+	st.Depth = max(st.Depth, depth)
+	switch n := n.(type) {
+	case *KindSwitchNode:
+		for _, sub := range n.Branches {
+			st.add(sub, depth+1)
+		}
+	case *ValueSwitchNode:
+		st.ValueSwitches++
+		st.MaxValueBranches = max(st.MaxValueBranches, len(n.Branches))
+		for _, sub := range n.Branches {
+			st.add(sub, depth+1)
+		}
+		st.add(n.Default, depth+1)
+	case *RangeSwitchNode:
+		for _, b := range n.Branches {
+			st.add(b.Node, depth+1)
+		}
+		st.add(n.Default, depth+1)
+	case *LenSwitchNode:
+		for _, b := range n.Branches {
+			st.add(b.Node, depth+1)
+		}
+		st.add(n.Default, depth+1)
+	case *RegexSwitchNode:
+		for _, b := range n.Branches {
+			st.add(b.Node, depth+1)
+		}
+		st.add(n.Default, depth+1)
+	case *FieldPresenceNode:
+		for _, b := range n.Branches {
+			st.add(b.Node, depth+1)
+		}
+		st.add(n.Default, depth+1)
+	case *TupleSwitchNode:
+		for _, b := range n.Branches {
+			st.add(b.Node, depth+1)
+		}
+	case *OptionalNode:
+		st.add(n.Present, depth+1)
+	case *GroupNode:
+		st.add(n.Select, depth+1)
+	}
+}
+
+// EnumStrategy determines how a code generator renders
+// a ValueSwitchNode with many branches.
+type EnumStrategy int
+
+const (
+	// EnumAuto chooses a strategy based on the number of branches.
+	// See [EnumStrategy.Choose].
+	EnumAuto EnumStrategy = iota
+	// EnumSwitch renders a value switch as a switch statement
+	// with one case per value.
+	EnumSwitch
+	// EnumMap renders a value switch as a lookup
+	// in a hash map from value to branch.
+	EnumMap
+	// EnumBinarySearch renders a value switch as a binary
+	// search over the sorted values.
+	EnumBinarySearch
+)
+
+// DefaultEnumThreshold holds the number of branches
+// above which [EnumStrategy.Choose] will stop using
+// switch statements.
+const DefaultEnumThreshold = 64
+
+func (s EnumStrategy) String() string {
+	switch s {
+	case EnumAuto:
+		return "auto"
+	case EnumSwitch:
+		return "switch"
+	case EnumMap:
+		return "map"
+	case EnumBinarySearch:
+		return "binary-search"
+	}
+	return fmt.Sprintf("EnumStrategy(%d)", int(s))
+}
+
+// ParseEnumStrategy returns the strategy with the given name,
+// as returned by [EnumStrategy.String].
+func ParseEnumStrategy(name string) (EnumStrategy, error) {
+	for s := EnumAuto; s <= EnumBinarySearch; s++ {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown enum strategy %q", name)
+}
+
+// Choose returns the strategy that should be used
+// for a value switch with the given number of branches
+// when s is [EnumAuto]. Otherwise it returns s unchanged.
+// If threshold is zero, [DefaultEnumThreshold] is used.
+//
+// Above the threshold, [EnumMap] is chosen rather than
+// [EnumBinarySearch] because a map lookup takes constant time
+// whatever the number or kind of values. A binary search is only
+// worth having when the cost of initializing the map matters more
+// than the cost of each lookup, which the number of branches
+// doesn't tell us, so it must be asked for explicitly.
+func (s EnumStrategy) Choose(branches, threshold int) EnumStrategy {
+	if s != EnumAuto {
+		return s
+	}
+	if threshold <= 0 {
+		threshold = DefaultEnumThreshold
+	}
+	if branches <= threshold {
+		return EnumSwitch
+	}
+	return EnumMap
+}
@@ -0,0 +1,34 @@
+package cuediscrim
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestStats(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`int | bool | "foo" | "bar" | {type!: "a"} | {type!: "b"} | {type!: "c"}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v))
+	st := Stats(tree)
+	qt.Check(t, qt.Equals(st.ValueSwitches, 2))
+	qt.Check(t, qt.Equals(st.MaxValueBranches, 3))
+	qt.Check(t, qt.Equals(st.Depth, 4))
+	qt.Check(t, qt.Equals(st.ExpectedLookups, 15.0/7))
+}
+
+func TestEnumStrategy(t *testing.T) {
+	qt.Check(t, qt.Equals(EnumAuto.Choose(10, 0), EnumSwitch))
+	qt.Check(t, qt.Equals(EnumAuto.Choose(1000, 0), EnumMap))
+	qt.Check(t, qt.Equals(EnumAuto.Choose(10, 5), EnumMap))
+	qt.Check(t, qt.Equals(EnumBinarySearch.Choose(10, 0), EnumBinarySearch))
+	for s := EnumAuto; s <= EnumBinarySearch; s++ {
+		s1, err := ParseEnumStrategy(s.String())
+		qt.Assert(t, qt.IsNil(err))
+		qt.Check(t, qt.Equals(s1, s))
+	}
+	_, err := ParseEnumStrategy("other")
+	qt.Check(t, qt.ErrorMatches(err, `unknown enum strategy "other"`))
+}
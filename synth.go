@@ -0,0 +1,154 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/literal"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/cue/token"
+)
+
+// TagPatch holds a suggested constraint to add to an arm
+// of a disjunction so that it can be discriminated.
+type TagPatch struct {
+	// Arm holds the index of the arm to be patched.
+	Arm int
+
+	// Path holds the path of the tag field.
+	Path string
+
+	// Values holds the values the tag field
+	// should allow for the arm, in sorted order.
+	Values []Atom
+
+	// Patch holds CUE syntax for a struct that can
+	// be unified with the arm to add the tag constraint,
+	// for example {kind!: "a"}.
+	Patch ast.Expr
+}
+
+// SynthesizeTags suggests tag constraints for the given arms that would
+// make them perfectly discriminable. It works by looking for a field
+// in the labeled examples (those with Arm >= 0) whose values partition
+// the examples according to their arms. Unlabeled examples are ignored.
+//
+// Every arm must have at least one example. The patch for each arm
+// is checked to be consistent with the arm. Because the values
+// suggested for each arm are disjoint, a required field holding
+// them discriminates perfectly between the patched arms.
+//
+// This API is experimental and may change.
+func SynthesizeTags(arms []cue.Value, examples []Example) ([]*TagPatch, error) {
+	var labeled []Example
+	seen := make(mapSet[int])
+	for _, ex := range examples {
+		if ex.Arm < 0 {
+			continue
+		}
+		if ex.Arm >= len(arms) {
+			return nil, fmt.Errorf("example has out of range arm %d", ex.Arm)
+		}
+		labeled = append(labeled, ex)
+		seen[ex.Arm] = true
+	}
+	for i := range arms {
+		if !seen[i] {
+			return nil, fmt.Errorf("no examples for arm %d", i)
+		}
+	}
+	if len(arms) < 2 {
+		return nil, fmt.Errorf("need at least two arms")
+	}
+	evs := InferDiscriminators(nil, labeled)
+	// Prefer shallower paths.
+	slices.SortStableFunc(evs, func(ev0, ev1 *FieldEvidence) int {
+		return pathDepth(ev0.Path) - pathDepth(ev1.Path)
+	})
+	for _, ev := range evs {
+		if !ev.Discriminates || ev.Path == "." {
+			continue
+		}
+		patches, ok := tagPatches(arms, ev)
+		if ok {
+			return patches, nil
+		}
+	}
+	return nil, fmt.Errorf("no field in the examples can discriminate between all arms")
+}
+
+// tagPatches returns the patches that would constrain
+// each arm according to the values in ev, and reports
+// whether all the patches are consistent with their arms.
+func tagPatches(arms []cue.Value, ev *FieldEvidence) ([]*TagPatch, bool) {
+	byArm := make([][]Atom, len(arms))
+	for a, armSet := range ev.Values {
+		for arm := range armSet.Values() {
+			byArm[arm] = append(byArm[arm], a)
+		}
+	}
+	patches := make([]*TagPatch, len(arms))
+	for i, arm := range arms {
+		values := slices.SortedFunc(slices.Values(byArm[i]), Atom.compare)
+		patch, err := tagSyntax(ev.Path, values)
+		if err != nil {
+			return nil, false
+		}
+		if err := arm.Unify(arm.Context().BuildExpr(patch)).Validate(); err != nil {
+			return nil, false
+		}
+		patches[i] = &TagPatch{
+			Arm:    i,
+			Path:   ev.Path,
+			Values: values,
+			Patch:  patch,
+		}
+	}
+	return patches, true
+}
+
+// tagSyntax returns a struct literal that requires
+// the field at path to be one of the given values.
+func tagSyntax(path string, values []Atom) (ast.Expr, error) {
+	var exprs []ast.Expr
+	for _, a := range values {
+		x, err := parser.ParseExpr("", a.String())
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, x)
+	}
+	expr := ast.NewBinExpr(token.OR, exprs...)
+	names := splitPath(path)
+	for i := len(names) - 1; i >= 0; i-- {
+		label, err := labelForName(names[i])
+		if err != nil {
+			return nil, err
+		}
+		expr = ast.NewStruct(&ast.Field{
+			Label:      label,
+			Constraint: token.NOT,
+			Value:      expr,
+		})
+	}
+	return expr, nil
+}
+
+// labelForName returns the label syntax for
+// a selector name as returned by selectorName.
+func labelForName(name string) (ast.Label, error) {
+	if isIndex(name) {
+		return nil, fmt.Errorf("cannot make label for list index %s", name)
+	}
+	if !strings.HasPrefix(name, `"`) {
+		return ast.NewIdent(name), nil
+	}
+	s, err := literal.Unquote(name)
+	if err != nil {
+		return nil, err
+	}
+	return ast.NewString(s), nil
+}
@@ -0,0 +1,89 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/format"
+	"github.com/go-quicktest/qt"
+)
+
+func TestSynthesizeTags(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`
+{
+	meta!: {kind!: string}
+	a?: int
+} | {
+	meta!: {kind!: string}
+	b?: string
+}
+`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	arms := Disjunctions(v)
+	_, _, perfect := Discriminate(arms)
+	qt.Assert(t, qt.IsFalse(perfect))
+
+	examples := []Example{{
+		Value: ctx.CompileString(`{meta: kind: "a", a: 1}`),
+		Arm:   0,
+	}, {
+		Value: ctx.CompileString(`{meta: kind: "old-a", a: 2}`),
+		Arm:   0,
+	}, {
+		Value: ctx.CompileString(`{meta: kind: "b", b: "x"}`),
+		Arm:   1,
+	}}
+	patches, err := SynthesizeTags(arms, examples)
+	qt.Assert(t, qt.IsNil(err))
+	var buf strings.Builder
+	for _, p := range patches {
+		data, err := format.Node(p.Patch)
+		qt.Assert(t, qt.IsNil(err))
+		fmt.Fprintf(&buf, "%d: %s\n", p.Arm, data)
+	}
+	qt.Assert(t, qt.Equals(buf.String(), `
+0: {
+	meta!: {
+		kind!: "a" | "old-a"
+	}
+}
+1: {
+	meta!: {
+		kind!: "b"
+	}
+}
+`[1:]))
+}
+
+func TestSynthesizeTagsNoCandidate(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`{a!: int} | {b!: int}`)
+	examples := []Example{{
+		Value: ctx.CompileString(`{a: 1, x: 1}`),
+		Arm:   0,
+	}, {
+		Value: ctx.CompileString(`{b: 1, x: 1}`),
+		Arm:   1,
+	}}
+	_, err := SynthesizeTags(Disjunctions(v), examples)
+	qt.Assert(t, qt.ErrorMatches(err, `no field in the examples can discriminate between all arms`))
+}
+
+func TestSynthesizeTagsInconsistent(t *testing.T) {
+	ctx := cuecontext.New()
+	// The only discriminating field in the examples is
+	// inconsistent with the schema.
+	v := ctx.CompileString(`{kind!: int, a?: int} | {kind!: int, b?: int}`)
+	examples := []Example{{
+		Value: ctx.CompileString(`{kind: "a"}`),
+		Arm:   0,
+	}, {
+		Value: ctx.CompileString(`{kind: "b"}`),
+		Arm:   1,
+	}}
+	_, err := SynthesizeTags(Disjunctions(v), examples)
+	qt.Assert(t, qt.ErrorMatches(err, `no field in the examples can discriminate between all arms`))
+}
@@ -0,0 +1,299 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"cuelang.org/go/cue"
+)
+
+// TopArmPolicy says how arms that accept any value, such as _, or
+// any struct, such as {...}, are treated. Such an arm is an instance
+// of every other arm of its kind, so when it's analyzed like any
+// other arm it's chosen along with all of them and can leave
+// the tree with little to go on. See [TopArms].
+type TopArmPolicy int
+
+const (
+	// TopArmsAnalyze analyzes top arms like any other arm.
+	// This is the default.
+	TopArmsAnalyze TopArmPolicy = iota
+
+	// TopArmsCatchAll discriminates between the other arms
+	// and chooses the top arms wherever that decision tree
+	// chooses no arm: in place of an error default or a kind
+	// that no other arm has. A {...} arm is only chosen for
+	// structs. The tree still assumes that a value is an
+	// instance of some arm, so a value that another arm is
+	// chosen for is not checked against it.
+	TopArmsCatchAll
+
+	// TopArmsError discriminates between the other arms
+	// and never chooses the top arms. The tree is not counted
+	// as perfect and there is a warning for each top arm,
+	// so that a union with such an arm is reported as a problem.
+	TopArmsError
+
+	// TopArmsExclude is like TopArmsError except that the
+	// top arms make no difference to whether the tree is
+	// perfect. There is still a warning for each of them.
+	TopArmsExclude
+)
+
+// TopArms sets the policy for arms that accept any value or any struct.
+// See [TopArmPolicy] for details.
+func TopArms(policy TopArmPolicy) Option {
+	return func(opts *options) {
+		opts.topArms = policy
+	}
+}
+
+// String returns the name of the policy as used by
+// the -top-arms flag of the discrim command.
+func (p TopArmPolicy) String() string {
+	switch p {
+	case TopArmsAnalyze:
+		return "analyze"
+	case TopArmsCatchAll:
+		return "catch-all"
+	case TopArmsError:
+		return "error"
+	case TopArmsExclude:
+		return "exclude"
+	}
+	return fmt.Sprintf("TopArmPolicy(%d)", int(p))
+}
+
+// ParseTopArmPolicy returns the policy with the given name,
+// as returned by [TopArmPolicy.String].
+func ParseTopArmPolicy(s string) (TopArmPolicy, error) {
+	for p := TopArmsAnalyze; p <= TopArmsExclude; p++ {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown top arm policy %q", s)
+}
+
+// isTopArm reports whether v accepts any value or any struct.
+func isTopArm(v cue.Value) bool {
+	switch v.IncompleteKind() {
+	case cue.TopKind:
+		return true
+	case cue.StructKind:
+		return acceptsAnyStruct(v)
+	}
+	return false
+}
+
+// acceptsAnyStruct reports whether the struct v has no fields
+// and no pattern constraints that restrict it, as with {...}.
+// As elsewhere, only pattern constraints on all field names
+// are taken into account.
+func acceptsAnyStruct(v cue.Value) bool {
+	if !v.Allows(cue.AnyString) {
+		// A closed struct.
+		return false
+	}
+	if p := v.LookupPath(cue.MakePath(cue.AnyString)); p.Exists() && p.IncompleteKind() != cue.TopKind {
+		return false
+	}
+	iter, err := v.Fields(cue.Optional(true))
+	if err != nil {
+		return false
+	}
+	return !iter.Next()
+}
+
+// topArms returns the indexes of the arms that are treated
+// specially by the [TopArms] policy in opts.
+func topArms(arms []cue.Value, opts options) []int {
+	if opts.topArms == TopArmsAnalyze || len(arms) < 2 {
+		return nil
+	}
+	var top []int
+	for i, arm := range arms {
+		if isTopArm(arm) {
+			top = append(top, i)
+		}
+	}
+	return top
+}
+
+// topArmWarnings returns a warning for each arm left out
+// of the tree by the [TopArms] policy in opts.
+func topArmWarnings(arms []cue.Value, opts options) []Warning {
+	if opts.topArms != TopArmsError && opts.topArms != TopArmsExclude {
+		return nil
+	}
+	var warnings []Warning
+	for _, i := range topArms(arms, opts) {
+		what := "any value"
+		if arms[i].IncompleteKind() == cue.StructKind {
+			what = "any struct"
+		}
+		warnings = append(warnings, Warning{
+			Path:    ".",
+			Message: fmt.Sprintf("arm %d accepts %s, so it is left out of the decision tree", i, what),
+		})
+	}
+	return warnings
+}
+
+// resultWarnings returns the warnings for the tree
+// of a [Result] analyzing arms with the given options.
+func resultWarnings(tree DecisionNode, arms []cue.Value, opts options) []Warning {
+	return append(Warnings(tree), topArmWarnings(arms, opts)...)
+}
+
+// withCatchAll returns n with anyArms, the arms that accept any
+// value, and structArms, the arms that accept any struct, chosen
+// wherever n chooses no arm, as for [TopArmsCatchAll].
+// The other arms, which n decides between, have the given kinds.
+func withCatchAll(n DecisionNode, anyArms, structArms IntSet, otherKinds cue.Kind) DecisionNode {
+	if structArms.Len() > 0 {
+		// The struct arms only apply to the struct
+		// branch of a switch on the kind of the whole value.
+		root, ok := n.(*KindSwitchNode)
+		if ok && root.Path == "." && !root.Optional {
+			root = &KindSwitchNode{
+				Path:     ".",
+				Branches: maps.Clone(root.Branches),
+				Order:    root.Order,
+			}
+		} else {
+			root = &KindSwitchNode{
+				Path:     ".",
+				Branches: make(map[cue.Kind]DecisionNode),
+			}
+			for _, k := range allKinds {
+				if otherKinds&k != 0 {
+					root.Branches[k] = n
+				}
+			}
+		}
+		root.Branches[cue.StructKind] = fillCatchAll(root.Branches[cue.StructKind], union(anyArms, structArms))
+		n = root
+	}
+	if anyArms.Len() > 0 {
+		n = fillCatchAll(n, anyArms)
+	}
+	return n
+}
+
+// fillCatchAll returns a copy of n that chooses arms
+// wherever n chooses no arm.
+func fillCatchAll(n DecisionNode, arms IntSet) DecisionNode {
+	switch n := n.(type) {
+	case nil, ErrorNode:
+		return &LeafNode{
+			Arms: arms,
+		}
+	case *LeafNode:
+		if n.Arms.Len() == 0 {
+			return &LeafNode{
+				Arms: arms,
+			}
+		}
+	case *KindSwitchNode:
+		n1 := *n
+		n1.Branches = make(map[cue.Kind]DecisionNode, len(allKinds))
+		for _, k := range allKinds {
+			n1.Branches[k] = fillCatchAll(n.Branches[k], arms)
+		}
+		return &n1
+	case *ValueSwitchNode:
+		n1 := *n
+		n1.Branches = make(map[Atom]DecisionNode, len(n.Branches))
+		for a, sub := range n.Branches {
+			n1.Branches[a] = fillCatchAll(sub, arms)
+		}
+		n1.Default = fillCatchAll(n.Default, arms)
+		return &n1
+	case *RangeSwitchNode:
+		n1 := *n
+		n1.Branches = make([]RangeBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			b.Node = fillCatchAll(b.Node, arms)
+			n1.Branches[i] = b
+		}
+		n1.Default = fillCatchAll(n.Default, arms)
+		return &n1
+	case *LenSwitchNode:
+		n1 := *n
+		n1.Branches = make([]RangeBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			b.Node = fillCatchAll(b.Node, arms)
+			n1.Branches[i] = b
+		}
+		n1.Default = fillCatchAll(n.Default, arms)
+		return &n1
+	case *RegexSwitchNode:
+		n1 := *n
+		n1.Branches = make([]RegexBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			b.Node = fillCatchAll(b.Node, arms)
+			n1.Branches[i] = b
+		}
+		n1.Default = fillCatchAll(n.Default, arms)
+		return &n1
+	case *TupleSwitchNode:
+		n1 := *n
+		n1.Branches = make([]TupleBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			b.Node = fillCatchAll(b.Node, arms)
+			n1.Branches[i] = b
+		}
+		return &n1
+	case *FieldPresenceNode:
+		n1 := *n
+		n1.Branches = make([]PresenceBranch, len(n.Branches))
+		for i, b := range n.Branches {
+			b.Node = fillCatchAll(b.Node, arms)
+			n1.Branches[i] = b
+		}
+		n1.Default = fillCatchAll(n.Default, arms)
+		return &n1
+	case *OptionalNode:
+		return &OptionalNode{
+			Present: fillCatchAll(n.Present, arms),
+		}
+	case *GroupNode:
+		return &GroupNode{
+			Name:   n.Name,
+			Select: fillCatchAll(n.Select, arms),
+		}
+	}
+	return n
+}
+
+// applyTopArms returns the tree n, which decides between the arms
+// other than those in top, adjusted according to the [TopArms]
+// policy in opts. If rev is non-nil, it maps each arm
+// to the original arms that it was merged from.
+func applyTopArms(n DecisionNode, arms []cue.Value, top []int, rev func(int) IntSet, opts options) DecisionNode {
+	if len(top) == 0 || opts.topArms != TopArmsCatchAll {
+		return n
+	}
+	anyArms, structArms := make(mapSet[int]), make(mapSet[int])
+	var otherKinds cue.Kind
+	for i, arm := range arms {
+		var s mapSet[int]
+		switch {
+		case !slices.Contains(top, i):
+			otherKinds |= arm.IncompleteKind()
+			continue
+		case arm.IncompleteKind() == cue.StructKind:
+			s = structArms
+		default:
+			s = anyArms
+		}
+		if rev == nil {
+			s[i] = true
+		} else {
+			s.addSeq(rev(i).Values())
+		}
+	}
+	return withCatchAll(n, anyArms, structArms, otherKinds)
+}
@@ -0,0 +1,205 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var topArmsTests = []struct {
+	testName     string
+	cue          string
+	policy       TopArmPolicy
+	want         string
+	wantPerfect  bool
+	wantWarnings []string
+	// check maps data to the arms the tree
+	// should choose for it.
+	check map[string][]int
+}{{
+	testName:    "CatchAllAny",
+	cue:         `{type!: "a"} | {type!: "b"} | _`,
+	policy:      TopArmsCatchAll,
+	wantPerfect: true,
+	want: `
+switch type {
+case "a":
+	choose({0})
+case "b":
+	choose({1})
+default:
+	choose({2})
+}
+`,
+	check: map[string][]int{
+		`{type: "a"}`: {0},
+		`{type: "c"}`: {2},
+		`{}`:          {2},
+		`1`:           {2},
+	},
+}, {
+	testName:    "CatchAllAnyKinds",
+	cue:         `{type!: "a"} | string | _`,
+	policy:      TopArmsCatchAll,
+	wantPerfect: true,
+	want: `
+switch kind(.) {
+case null:
+	choose({2})
+case bool:
+	choose({2})
+case int:
+	choose({2})
+case float:
+	choose({2})
+case string:
+	choose({1})
+case bytes:
+	choose({2})
+case list:
+	choose({2})
+case struct:
+	choose({0})
+}
+`,
+	check: map[string][]int{
+		`"x"`:   {1},
+		`[1]`:   {2},
+		`false`: {2},
+	},
+}, {
+	testName:    "CatchAllStruct",
+	cue:         `{type!: "a"} | {type!: "b"} | {...}`,
+	policy:      TopArmsCatchAll,
+	wantPerfect: true,
+	want: `
+switch kind(.) {
+case struct:
+	switch type {
+	case "a":
+		choose({0})
+	case "b":
+		choose({1})
+	default:
+		choose({2})
+	}
+}
+`,
+	check: map[string][]int{
+		`{type: "b"}`: {1},
+		`{other: 1}`:  {2},
+		`1`:           {},
+	},
+}, {
+	testName:    "CatchAllStructWithKinds",
+	cue:         `int | {type!: "a"} | {type!: "b"} | {...}`,
+	policy:      TopArmsCatchAll,
+	wantPerfect: true,
+	want: `
+switch kind(.) {
+case int:
+	choose({0})
+case struct:
+	switch type {
+	case "a":
+		choose({1})
+	case "b":
+		choose({2})
+	default:
+		choose({3})
+	}
+}
+`,
+}, {
+	testName:     "Error",
+	cue:          `{type!: "a"} | {type!: "b"} | _`,
+	policy:       TopArmsError,
+	wantWarnings: []string{".: arm 2 accepts any value, so it is left out of the decision tree"},
+	want: `
+switch type {
+case "a":
+	choose({0})
+case "b":
+	choose({1})
+default:
+	error
+}
+`,
+}, {
+	testName:     "Exclude",
+	cue:          `{type!: "a"} | string | _`,
+	policy:       TopArmsExclude,
+	wantPerfect:  true,
+	wantWarnings: []string{".: arm 2 accepts any value, so it is left out of the decision tree"},
+	want: `
+switch kind(.) {
+case string:
+	choose({1})
+case struct:
+	choose({0})
+}
+`,
+}, {
+	testName:     "ExcludeStruct",
+	cue:          `int | string | #Any, #Any: {...}`,
+	policy:       TopArmsExclude,
+	wantPerfect:  true,
+	wantWarnings: []string{".: arm 2 accepts any struct, so it is left out of the decision tree"},
+	want: `
+switch kind(.) {
+case int:
+	choose({0})
+case string:
+	choose({1})
+}
+`,
+}, {
+	testName:    "NotTop",
+	cue:         `int | {[string]: int} | close({})`,
+	policy:      TopArmsExclude,
+	wantPerfect: false,
+	want: `
+switch kind(.) {
+case int:
+	choose({0})
+case struct:
+	choose({1, 2})
+}
+`,
+}}
+
+func TestTopArms(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range topArmsTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := ctx.CompileString("x: " + test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			v = v.LookupPath(cue.ParsePath("x"))
+			r := Analyze(Disjunctions(v), TopArms(test.policy))
+			qt.Assert(t, qt.Equals(NodeString(r.Tree), strings.TrimPrefix(test.want, "\n")))
+			qt.Check(t, qt.Equals(r.Perfect, test.wantPerfect))
+			var warnings []string
+			for _, w := range r.Warnings {
+				warnings = append(warnings, w.String())
+			}
+			qt.Check(t, qt.DeepEquals(warnings, test.wantWarnings))
+			for data, want := range test.check {
+				x := ctx.CompileString(data)
+				qt.Check(t, qt.DeepEquals(sortedInts(r.Tree.Check(x)), want), qt.Commentf("data %s", data))
+			}
+		})
+	}
+}
+
+func TestParseTopArmPolicy(t *testing.T) {
+	for p := TopArmsAnalyze; p <= TopArmsExclude; p++ {
+		p1, err := ParseTopArmPolicy(p.String())
+		qt.Assert(t, qt.IsNil(err))
+		qt.Check(t, qt.Equals(p1, p))
+	}
+	_, err := ParseTopArmPolicy("other")
+	qt.Check(t, qt.ErrorMatches(err, `unknown top arm policy "other"`))
+}
@@ -0,0 +1,161 @@
+package cuediscrim
+
+// #Tree describes the serialized form of a decision tree,
+// as produced by EncodeTree and accepted by DecodeTree.
+#Tree: {
+	// version holds the version of the format.
+	// DecodeTree upgrades older versions to the current
+	// version before validating against this schema.
+	version!: #TreeVersion
+
+	// root holds the root node of the tree.
+	root!: #Node
+}
+
+// #TreeVersion holds the current version of the format.
+#TreeVersion: 3
+
+#Node: #LeafNode | #KindSwitchNode | #ValueSwitchNode | #RangeSwitchNode | #LenSwitchNode | #RegexSwitchNode | #TupleSwitchNode | #FieldPresenceNode | #FieldAbsenceNode | #OptionalNode | #GroupNode | #ErrorNode
+
+// #Arms holds a set of arm indexes.
+#Arms: [...int & >=0]
+
+#LeafNode: {
+	type!:       "leaf"
+	arms!:       #Arms
+	deprecated?: #Arms
+	// precedence holds the arms ordered by specificity,
+	// most constrained first, when that is not ascending order.
+	precedence?: #Arms
+}
+
+#KindSwitchNode: {
+	type!: "kindSwitch"
+	path!: string
+	// optional reports whether the field at path is optional.
+	optional?: bool
+	// order holds the kinds whose branches are tested first,
+	// in order. The others follow in the order of #Kind.
+	order?: [...#Kind]
+	// branches is keyed by kind name (for example "string" or "struct").
+	branches!: [#Kind]: #Node
+}
+
+#Kind: "null" | "bool" | "int" | "float" | "string" | "bytes" | "list" | "struct"
+
+#ValueSwitchNode: {
+	type!: "valueSwitch"
+	path!: string
+	// optional reports whether the field at path is optional.
+	optional?: bool
+	// implied holds the CUE representation of the value
+	// that the field is taken to hold when it is absent.
+	implied?: string
+	// enum holds how generated code should find
+	// the branch for a value. It is "auto" when absent.
+	enum?: "auto" | "switch" | "map" | "binary-search"
+	// branches holds the cases in the order they are
+	// tested. Each value
+	// holds the CUE representation of the value
+	// (for example "\"foo\"" or "true").
+	branches!: [...{
+		value!: string
+		node!:  #Node
+	}]
+	default?: #Node
+}
+
+#RangeSwitchNode: {
+	type!: "rangeSwitch"
+	path!: string
+	// optional reports whether the field at path is optional.
+	optional?: bool
+	// branches holds the cases in ascending order. Each range
+	// holds the CUE representation of a numeric interval
+	// (for example ">=1 & <4" or ">5").
+	branches!: [...{
+		range!: string
+		node!:  #Node
+	}]
+	default?: #Node
+}
+
+#LenSwitchNode: {
+	type!: "lenSwitch"
+	path!: string
+	// branches holds the cases in ascending order. Each range
+	// holds the CUE representation of an interval of list
+	// lengths with inclusive bounds (for example ">=1 & <=3"
+	// or "2"). An interval with no lower bound starts at zero.
+	branches!: [...{
+		range!: string
+		node!:  #Node
+	}]
+	// default is used when the value is not a list or
+	// its length is in none of the intervals.
+	default?: #Node
+}
+
+#RegexSwitchNode: {
+	type!: "regexSwitch"
+	path!: string
+	// optional reports whether the field at path is optional.
+	optional?: bool
+	// branches holds the cases in the order they are tested:
+	// a string is handled by the first branch whose pattern,
+	// a regular expression as used by the =~ operator, matches it.
+	branches!: [...{
+		pattern!: string
+		node!:    #Node
+	}]
+	default?: #Node
+}
+
+#TupleSwitchNode: {
+	type!:  "tupleSwitch"
+	paths!: [...string]
+	// branches holds the combinations of values, one for each
+	// path. A value matching none of them selects no arm.
+	branches!: [...{
+		values!: [...string]
+		node!:   #Node
+	}]
+}
+
+#FieldPresenceNode: {
+	type!: "fieldPresence"
+	// branches holds the cases in the order they are tested:
+	// a value is handled by the first branch whose field,
+	// at the given path, is present.
+	branches!: [...{
+		path!: string
+		node!: #Node
+	}]
+	default?: #Node
+}
+
+#FieldAbsenceNode: {
+	type!: "fieldAbsence"
+	// branches maps from path to the arms selected
+	// when the field at that path is not present.
+	branches!: [string]: #Arms
+}
+
+#OptionalNode: {
+	type!: "optional"
+	// present holds the node used when the value is present.
+	present!: #Node
+}
+
+#GroupNode: {
+	type!: "group"
+	// name holds the path of the definition
+	// that defines the group, if any.
+	name?: string
+	// select chooses between the arms in the group.
+	select!: #Node
+}
+
+#ErrorNode: {
+	type!: "error"
+}
@@ -0,0 +1,554 @@
+package cuediscrim
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+)
+
+// TSOption represents an option to [GenerateTypeScript].
+type TSOption func(*tsOptions)
+
+type tsOptions struct {
+	funcName  string
+	typesFrom string
+	command   string
+}
+
+// TSFunc sets the name of the generated function that returns the
+// indexes of the arms chosen for a value. The default is "discriminate".
+func TSFunc(name string) TSOption {
+	return func(o *tsOptions) {
+		o.funcName = name
+	}
+}
+
+// TSTypesFrom causes the generated code to import the types named
+// by the type guards from the given module. By default, the types
+// are assumed to be declared alongside the generated code.
+func TSTypesFrom(module string) TSOption {
+	return func(o *tsOptions) {
+		o.typesFrom = module
+	}
+}
+
+// TSGeneratedBy sets the name of the command mentioned in the
+// "Code generated" comment at the start of the generated code.
+// The default is "cuediscrim".
+func TSGeneratedBy(command string) TSOption {
+	return func(o *tsOptions) {
+		o.command = command
+	}
+}
+
+// GenerateTypeScript returns TypeScript source code that classifies
+// values in the same way as n.Check. It exports a function (named
+// discriminate by default; see [TSFunc]) that takes a value as
+// decoded by JSON.parse and returns the indexes of the arms chosen
+// for it, with a switch statement for each [KindSwitchNode] and
+// [ValueSwitchNode] in n, comparisons for each [RangeSwitchNode] and
+// [LenSwitchNode], and regular expression tests for each
+// [RegexSwitchNode]. The regular
+// expressions are compiled once, when the module is loaded; they
+// should use syntax common to Go and JavaScript. As for [GenerateGo],
+// a ValueSwitchNode may instead look the value up in a Map or in a
+// sorted table; see [Enums]. As with GenerateGo, the arms of a leaf
+// are returned in the order given by [LeafNode.Ordered].
+//
+// For each arm with a name in names, as returned by [ArmNames],
+// it also exports a type guard named after the arm, so an arm
+// named #Foo has the guard:
+//
+//	export function isFoo(x: unknown): x is Foo
+//
+// The guard holds when the arm is among those chosen, so when n
+// is not a perfect discriminator, several guards may hold for
+// the same value. Arms without names have no guard.
+//
+// JavaScript has a single number type, so a number is considered
+// to be an int when it is integral. As with [GenerateGo], definitions
+// and hidden fields are always absent.
+func GenerateTypeScript(n DecisionNode, names []string, opts ...TSOption) ([]byte, error) {
+	o := tsOptions{
+		funcName: "discriminate",
+		command:  "cuediscrim",
+	}
+	for _, f := range opts {
+		f(&o)
+	}
+	if !isGoIdent(o.funcName) {
+		return nil, fmt.Errorf("invalid TypeScript identifier %q", o.funcName)
+	}
+	var sb strings.Builder
+	g := &tsGenerator{
+		w:      &indentWriter{w: &sb},
+		helper: o.funcName,
+	}
+	guards := tsGuards(names)
+	g.w.Printf("// Code generated by %s. DO NOT EDIT.\n\n", o.command)
+	if o.typesFrom != "" && len(guards) > 0 {
+		types := make([]string, len(guards))
+		for i, guard := range guards {
+			types[i] = guard.name
+		}
+		g.w.Printf("import type { %s } from %s;\n\n", strings.Join(types, ", "), tsString(o.typesFrom))
+	}
+	g.w.Printf("// %s returns the indexes of the arms that x is classified as,\n", o.funcName)
+	g.w.Printf("// where x is as decoded by JSON.parse. It returns an empty\n")
+	g.w.Printf("// array if x matches no arm.\n")
+	g.w.Printf("export function %s(x: unknown): number[] {\n", o.funcName)
+	g.w.Indent()
+	g.node(n)
+	g.w.Unindent()
+	g.w.Printf("}\n")
+	for _, guard := range guards {
+		g.w.Printf("\n// is%s reports whether x is classified as %s.\n", guard.name, names[guard.arm])
+		g.w.Printf("export function is%s(x: unknown): x is %s {\n", guard.name, guard.name)
+		g.w.Printf("\treturn %s(x).includes(%d);\n}\n", o.funcName, guard.arm)
+	}
+	if len(g.regexps) > 0 {
+		g.w.Printf("\n// %sRegexps holds the regular expressions used by %s.\n", g.helper, o.funcName)
+		g.w.Printf("const %sRegexps = [\n", g.helper)
+		for _, p := range g.regexps {
+			g.w.Printf("\tnew RegExp(%s),\n", tsString(p))
+		}
+		g.w.Printf("];\n")
+	}
+	searches := false
+	for i, table := range g.tables {
+		g.w.Printf("\n// %sTable%d maps the values %s to\n", g.helper, i, tablePathDesc(table.path))
+		g.w.Printf("// the cases of a switch in %s.\n", o.funcName)
+		if table.strategy == EnumMap {
+			g.w.Printf("const %sTable%d = new Map<string, number>([\n", g.helper, i)
+			for _, e := range table.entries {
+				g.w.Printf("\t[%s, %d],\n", tsString(e.key), e.index)
+			}
+			g.w.Printf("]);\n")
+			continue
+		}
+		// JavaScript compares strings by UTF-16 code
+		// units, so sort the entries in the same order.
+		searches = true
+		g.w.Printf("const %sTable%d: [string, number][] = [\n", g.helper, i)
+		for _, e := range table.sorted(compareUTF16) {
+			g.w.Printf("\t[%s, %d],\n", tsString(e.key), e.index)
+		}
+		g.w.Printf("];\n")
+	}
+	g.w.Printf("\n")
+	g.w.Printf(tsHelpers, g.helper)
+	if searches {
+		g.w.Printf(tsSearchHelpers, g.helper)
+	}
+	return []byte(sb.String()), nil
+}
+
+// tsGuard holds the arm and type name for a type guard.
+type tsGuard struct {
+	arm  int
+	name string
+}
+
+// tsGuards returns a type guard for each arm with a name,
+// making the names unique in the same way as [Result.MergedGroups].
+func tsGuards(names []string) []tsGuard {
+	var guards []tsGuard
+	used := make(map[string]bool)
+	for arm, path := range names {
+		ident := identForPath(path)
+		if ident == "" {
+			continue
+		}
+		name := ident
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("%s%d", ident, i)
+		}
+		used[name] = true
+		guards = append(guards, tsGuard{
+			arm:  arm,
+			name: name,
+		})
+	}
+	return guards
+}
+
+type tsGenerator struct {
+	w      *indentWriter
+	helper string
+
+	// regexps and tables hold the patterns and lookup
+	// tables used by the generated code, as for [goGenerator].
+	regexps []string
+	tables  []valueTable
+}
+
+// regexp returns an expression for the compiled form of pattern.
+func (g *tsGenerator) regexp(pattern string) string {
+	i := slices.Index(g.regexps, pattern)
+	if i < 0 {
+		i = len(g.regexps)
+		g.regexps = append(g.regexps, pattern)
+	}
+	return fmt.Sprintf("%sRegexps[%d]", g.helper, i)
+}
+
+// table returns the name of a new table holding
+// the given case keys for a switch on path.
+func (g *tsGenerator) table(path string, strategy EnumStrategy, keys [][]string) string {
+	g.tables = append(g.tables, newValueTable(path, strategy, keys))
+	return fmt.Sprintf("%sTable%d", g.helper, len(g.tables)-1)
+}
+
+// node writes the statements that return the arms chosen by n.
+func (g *tsGenerator) node(n DecisionNode) {
+	switch n := n.(type) {
+	case *LeafNode:
+		g.w.Printf("return %s;\n", tsInts(n.Ordered()))
+	case *KindSwitchNode:
+		g.w.Printf("switch (%sKind(%sLookup(x%s))) {\n", g.helper, g.helper, tsPathArgs(n.Path))
+		for _, k := range ordered(n.Branches, n.Order, cmp.Compare[cue.Kind]) {
+			g.w.Printf("case %q:\n", k.String())
+			g.w.Indent()
+			g.node(n.Branches[k])
+			g.w.Unindent()
+		}
+		g.w.Printf("}\nreturn [];\n")
+	case *ValueSwitchNode:
+		keys, nodes := valueTableCases(n, tsAtomKey, g.w.nodeKeys())
+		value := fmt.Sprintf("%sValue(%sLookup(x%s))", g.helper, g.helper, tsPathArgs(n.Path))
+		s := n.Enum.Choose(len(n.Branches), 0)
+		switch s {
+		case EnumMap:
+			g.w.Printf("switch (%s.get(%s) ?? 0) {\n", g.table(n.Path, s, keys), value)
+		case EnumBinarySearch:
+			g.w.Printf("switch (%sSearch(%s, %s)) {\n", g.helper, g.table(n.Path, s, keys), value)
+		default:
+			g.w.Printf("switch (%s) {\n", value)
+		}
+		for i, node := range nodes {
+			if s == EnumMap || s == EnumBinarySearch {
+				g.w.Printf("case %d:\n", i+1)
+			} else {
+				for _, key := range keys[i] {
+					g.w.Printf("case %s:\n", tsString(key))
+				}
+			}
+			g.w.Indent()
+			g.node(node)
+			g.w.Unindent()
+		}
+		g.w.Printf("default:\n")
+		g.w.Indent()
+		if n.Default != nil {
+			g.node(n.Default)
+		} else {
+			g.w.Printf("return [];\n")
+		}
+		g.w.Unindent()
+		g.w.Printf("}\n")
+	case *RangeSwitchNode:
+		// Use a block so that the variable is local to it.
+		g.w.Printf("{\n")
+		g.w.Indent()
+		g.w.Printf("const n = %sLookup(x%s);\n", g.helper, tsPathArgs(n.Path))
+		g.w.Printf("if (typeof n === \"number\") {\n")
+		g.w.Indent()
+		for _, b := range n.Branches {
+			g.w.Printf("if (%s) {\n", intervalCond(b.Interval, "n"))
+			g.w.Indent()
+			g.node(b.Node)
+			g.w.Unindent()
+			g.w.Printf("}\n")
+		}
+		g.w.Unindent()
+		g.w.Printf("}\n")
+		g.w.Unindent()
+		g.w.Printf("}\n")
+		if n.Default != nil {
+			g.node(n.Default)
+		} else {
+			g.w.Printf("return [];\n")
+		}
+	case *LenSwitchNode:
+		// Use a block so that the variable is local to it.
+		g.w.Printf("{\n")
+		g.w.Indent()
+		g.w.Printf("const l = %sLookup(x%s);\n", g.helper, tsPathArgs(n.Path))
+		g.w.Printf("if (Array.isArray(l)) {\n")
+		g.w.Indent()
+		for _, b := range n.Branches {
+			g.w.Printf("if (%s) {\n", intervalCond(b.Interval, "l.length"))
+			g.w.Indent()
+			g.node(b.Node)
+			g.w.Unindent()
+			g.w.Printf("}\n")
+		}
+		g.w.Unindent()
+		g.w.Printf("}\n")
+		g.w.Unindent()
+		g.w.Printf("}\n")
+		if n.Default != nil {
+			g.node(n.Default)
+		} else {
+			g.w.Printf("return [];\n")
+		}
+	case *RegexSwitchNode:
+		// Use a block so that the variable is local to it.
+		g.w.Printf("{\n")
+		g.w.Indent()
+		g.w.Printf("const s = %sLookup(x%s);\n", g.helper, tsPathArgs(n.Path))
+		g.w.Printf("if (typeof s === \"string\") {\n")
+		g.w.Indent()
+		for _, b := range n.Branches {
+			g.w.Printf("if (%s.test(s)) {\n", g.regexp(b.Pattern))
+			g.w.Indent()
+			g.node(b.Node)
+			g.w.Unindent()
+			g.w.Printf("}\n")
+		}
+		g.w.Unindent()
+		g.w.Printf("}\n")
+		g.w.Unindent()
+		g.w.Printf("}\n")
+		if n.Default != nil {
+			g.node(n.Default)
+		} else {
+			g.w.Printf("return [];\n")
+		}
+	case *FieldPresenceNode:
+		for _, b := range n.Branches {
+			g.w.Printf("if (%sLookup(x%s) !== %sAbsent) {\n", g.helper, tsPathArgs(b.Path), g.helper)
+			g.w.Indent()
+			g.node(b.Node)
+			g.w.Unindent()
+			g.w.Printf("}\n")
+		}
+		if n.Default != nil {
+			g.node(n.Default)
+		} else {
+			g.w.Printf("return [];\n")
+		}
+	case *TupleSwitchNode:
+		// Use a block so that the variables are local to it.
+		g.w.Printf("{\n")
+		g.w.Indent()
+		for i, path := range n.Paths {
+			g.w.Printf("const v%d = %sValue(%sLookup(x%s));\n", i, g.helper, g.helper, tsPathArgs(path))
+		}
+		for _, b := range n.Branches {
+			keys, ok := tupleKeys(b.Values, tsAtomKey)
+			if !ok {
+				continue
+			}
+			conds := make([]string, len(keys))
+			for i, key := range keys {
+				conds[i] = fmt.Sprintf("v%d === %s", i, tsString(key))
+			}
+			g.w.Printf("if (%s) {\n", strings.Join(conds, " && "))
+			g.w.Indent()
+			g.node(b.Node)
+			g.w.Unindent()
+			g.w.Printf("}\n")
+		}
+		g.w.Unindent()
+		g.w.Printf("}\n")
+		g.w.Printf("return [];\n")
+	case *FieldAbsenceNode:
+		// Use a block so that the variable is local to it.
+		g.w.Printf("{\n")
+		g.w.Indent()
+		g.w.Printf("let arms: number[] | undefined;\n")
+		for _, path := range slices.Sorted(maps.Keys(n.Branches)) {
+			g.w.Printf("if (%sLookup(x%s) === %sAbsent) {\n", g.helper, tsPathArgs(path), g.helper)
+			g.w.Printf("\tarms = %sIntersect(arms, %s);\n}\n", g.helper, tsInts(sortedInts(n.Branches[path])))
+		}
+		g.w.Printf("return arms ?? %s;\n", tsInts(sortedInts(n.Possible())))
+		g.w.Unindent()
+		g.w.Printf("}\n")
+	case *OptionalNode:
+		g.node(n.Present)
+	case *GroupNode:
+		if n.Name != "" {
+			g.w.Printf("// group %s\n", n.Name)
+		}
+		g.node(n.Select)
+	default:
+		g.w.Printf("return [];\n")
+	}
+}
+
+func tsInts(xs []int) string {
+	parts := make([]string, len(xs))
+	for i, x := range xs {
+		parts[i] = strconv.Itoa(x)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// tsString returns s as a JavaScript string literal.
+func tsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// tsPathArgs is like [goPathArgs] but quotes the field names as
+// JavaScript string literals, which don't allow all the escapes
+// that Go does, such as \a or \U0001F600.
+func tsPathArgs(path string) string {
+	var buf strings.Builder
+	for _, sel := range policyPath(path) {
+		if sel.IsIndex {
+			fmt.Fprintf(&buf, ", %d", sel.Index)
+		} else {
+			fmt.Fprintf(&buf, ", %s", tsString(sel.Field))
+		}
+	}
+	return buf.String()
+}
+
+// tsAtomKey returns the key that the value helper in the generated
+// code produces for a JSON value equal to a. It reports false
+// if no JSON value can equal a.
+func tsAtomKey(a Atom) (string, bool) {
+	v := cuecontext.New().CompileString(a.String())
+	switch v.Kind() {
+	case cue.NullKind:
+		return "null", true
+	case cue.BoolKind:
+		b, _ := v.Bool()
+		return "bool:" + strconv.FormatBool(b), true
+	case cue.StringKind:
+		s, _ := v.String()
+		return "string:" + s, true
+	case cue.IntKind, cue.FloatKind:
+		f, _ := v.Float64()
+		return "number:" + jsNumberString(f), true
+	}
+	return "", false
+}
+
+// jsNumberString returns f formatted as by JavaScript's String function.
+func jsNumberString(f float64) string {
+	if abs := math.Abs(f); abs == 0 || (abs >= 1e-6 && abs < 1e21) {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	// JavaScript omits leading zeros from the exponent.
+	mant, exp, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, 64), "e")
+	sign := exp[:1]
+	exp = strings.TrimLeft(exp[1:], "0")
+	return mant + "e" + sign + exp
+}
+
+// tsHelpers holds the helper functions in the generated code.
+// It is formatted with the prefix for helper names.
+const tsHelpers = `const %[1]sAbsent = Symbol("absent");
+
+// %[1]sLookup returns the value at the given path in x, where each
+// element of the path is a field name or a list index, or
+// %[1]sAbsent if it does not exist.
+function %[1]sLookup(x: unknown, ...path: (string | number)[]): unknown {
+	for (const p of path) {
+		if (typeof p === "number") {
+			if (!Array.isArray(x) || p >= x.length) {
+				return %[1]sAbsent;
+			}
+			x = x[p];
+		} else {
+			if (typeof x !== "object" || x === null || Array.isArray(x) || !Object.prototype.hasOwnProperty.call(x, p)) {
+				return %[1]sAbsent;
+			}
+			x = (x as Record<string, unknown>)[p];
+		}
+	}
+	return x;
+}
+
+// %[1]sKind returns the CUE kind of x, or the empty
+// string if it does not exist.
+function %[1]sKind(x: unknown): string {
+	if (x === %[1]sAbsent) {
+		return "";
+	}
+	if (x === null) {
+		return "null";
+	}
+	if (Array.isArray(x)) {
+		return "list";
+	}
+	switch (typeof x) {
+	case "boolean":
+		return "bool";
+	case "string":
+		return "string";
+	case "number":
+		return Number.isInteger(x) ? "int" : "float";
+	case "object":
+		return "struct";
+	}
+	return "";
+}
+
+// %[1]sValue returns a key identifying the value of x when it is a
+// constant, or the empty string otherwise.
+function %[1]sValue(x: unknown): string {
+	switch (%[1]sKind(x)) {
+	case "null":
+		return "null";
+	case "bool":
+		return "bool:" + String(x);
+	case "string":
+		return "string:" + x;
+	case "int":
+	case "float":
+		return "number:" + String(x);
+	}
+	return "";
+}
+
+// %[1]sIntersect returns the arms in both arms and group,
+// or group if arms is undefined.
+function %[1]sIntersect(arms: number[] | undefined, group: number[]): number[] {
+	if (arms === undefined) {
+		return group;
+	}
+	return arms.filter((arm) => group.includes(arm));
+}
+`
+
+// tsSearchHelpers holds the helpers in the generated code for
+// value switches rendered with [EnumBinarySearch].
+const tsSearchHelpers = `
+// %[1]sSearch returns the case for key in table, which
+// is sorted by key, or zero if there is none.
+function %[1]sSearch(table: [string, number][], key: string): number {
+	let i = 0;
+	let j = table.length;
+	while (i < j) {
+		const h = (i + j) >>> 1;
+		if (table[h][0] < key) {
+			i = h + 1;
+		} else {
+			j = h;
+		}
+	}
+	if (i < table.length && table[i][0] === key) {
+		return table[i][1];
+	}
+	return 0;
+}
+`
+
+// compareUTF16 compares a and b in the order of their
+// UTF-16 code units, as JavaScript does.
+func compareUTF16(a, b string) int {
+	return slices.Compare(utf16.Encode([]rune(a)), utf16.Encode([]rune(b)))
+}
@@ -0,0 +1,221 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestGenerateTypeScript(t *testing.T) {
+	v := cuecontext.New().CompileString(`
+#A: {type!: "a", x!: int}
+#B: {type!: "b"}
+x: #A | #B | [...string]
+`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	r := DiscriminateValue(v.LookupPath(cue.ParsePath("x")))
+	src, err := GenerateTypeScript(r.Tree, r.Names, TSFunc("classify"), TSTypesFrom("./types"))
+	qt.Assert(t, qt.IsNil(err))
+	s := string(src)
+	qt.Check(t, qt.StringContains(s, `// Code generated by cuediscrim. DO NOT EDIT.
+
+import type { A, B } from "./types";
+`))
+	qt.Check(t, qt.StringContains(s, `
+export function classify(x: unknown): number[] {
+	switch (classifyKind(classifyLookup(x))) {
+	case "list":
+		return [2];
+	case "struct":
+		switch (classifyValue(classifyLookup(x, "type"))) {
+		case "string:a":
+			return [0];
+		case "string:b":
+			return [1];
+		default:
+			return [];
+		}
+	}
+	return [];
+}
+
+// isA reports whether x is classified as #A.
+export function isA(x: unknown): x is A {
+	return classify(x).includes(0);
+}
+
+// isB reports whether x is classified as #B.
+export function isB(x: unknown): x is B {
+	return classify(x).includes(1);
+}
+`))
+	// The unnamed list arm has no guard.
+	qt.Check(t, qt.Equals(strings.Count(s, "): x is "), 2))
+}
+
+func TestGenerateTypeScriptMap(t *testing.T) {
+	v := cuecontext.New().CompileString(`{type!: "a" | "b", x!: int} | {type!: "c"}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v), Enums(EnumAuto, 2))
+	src, err := GenerateTypeScript(tree, nil, TSFunc("classify"))
+	qt.Assert(t, qt.IsNil(err))
+	s := string(src)
+	qt.Check(t, qt.StringContains(s, `
+export function classify(x: unknown): number[] {
+	switch (classifyTable0.get(classifyValue(classifyLookup(x, "type"))) ?? 0) {
+	case 1:
+		return [0];
+	case 2:
+		return [1];
+	default:
+		return [];
+	}
+}
+`))
+	qt.Check(t, qt.StringContains(s, `
+// classifyTable0 maps the values at type to
+// the cases of a switch in classify.
+const classifyTable0 = new Map<string, number>([
+	["string:a", 1],
+	["string:b", 1],
+	["string:c", 2],
+]);
+`))
+}
+
+func TestGenerateTypeScriptBinarySearch(t *testing.T) {
+	// U+1F600 sorts before U+FF61 in UTF-16 but not in UTF-8.
+	v := cuecontext.New().CompileString(`{type!: "\U0001F600" | "a", x!: int} | {type!: "\uFF61"}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v), Enums(EnumBinarySearch, 0))
+	src, err := GenerateTypeScript(tree, nil, TSFunc("classify"))
+	qt.Assert(t, qt.IsNil(err))
+	s := string(src)
+	qt.Check(t, qt.StringContains(s, `
+	switch (classifySearch(classifyTable0, classifyValue(classifyLookup(x, "type")))) {
+	case 1:
+		return [0];
+	case 2:
+		return [1];
+	default:
+		return [];
+	}
+`))
+	qt.Check(t, qt.StringContains(s, `
+const classifyTable0: [string, number][] = [
+	["string:a", 1],
+	["string:😀", 1],
+	["string:｡", 2],
+];
+`))
+	qt.Check(t, qt.StringContains(s, "function classifySearch(table: [string, number][], key: string): number {"))
+}
+
+func TestGenerateTypeScriptFieldPresence(t *testing.T) {
+	v := cuecontext.New().CompileString(`{a!: int} | {b!: string} | {c?: int}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v), ClosedWorld(true))
+	src, err := GenerateTypeScript(tree, nil, TSFunc("classify"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.StringContains(string(src), `
+export function classify(x: unknown): number[] {
+	if (classifyLookup(x, "a") !== classifyAbsent) {
+		return [0];
+	}
+	if (classifyLookup(x, "b") !== classifyAbsent) {
+		return [1];
+	}
+	return [2];
+}
+`))
+}
+
+func TestGenerateTypeScriptPrecedence(t *testing.T) {
+	v := cuecontext.New().CompileString(`>0 | >10`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v))
+	src, err := GenerateTypeScript(tree, nil, TSFunc("classify"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.StringContains(string(src), `
+export function classify(x: unknown): number[] {
+	return [1, 0];
+}
+`))
+}
+
+func TestGenerateTypeScriptTupleSwitch(t *testing.T) {
+	v := cuecontext.New().CompileString(`{a!: "foo", b!: true} | {a!: "foo", b!: false} | {a!: "bar", b!: true}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v))
+	src, err := GenerateTypeScript(tree, nil, TSFunc("classify"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.StringContains(string(src), `
+export function classify(x: unknown): number[] {
+	{
+		const v0 = classifyValue(classifyLookup(x, "a"));
+		const v1 = classifyValue(classifyLookup(x, "b"));
+		if (v0 === "string:bar" && v1 === "bool:true") {
+			return [2];
+		}
+		if (v0 === "string:foo" && v1 === "bool:false") {
+			return [1];
+		}
+		if (v0 === "string:foo" && v1 === "bool:true") {
+			return [0];
+		}
+	}
+	return [];
+}
+`))
+}
+
+func TestGenerateTypeScriptFieldAbsence(t *testing.T) {
+	v := cuecontext.New().CompileString(`{a!: int} | {b!: int} | {c!: int}`)
+	qt.Assert(t, qt.IsNil(v.Err()))
+	tree, _, _ := Discriminate(Disjunctions(v))
+	src, err := GenerateTypeScript(tree, []string{"#A", "#B", "#A"})
+	qt.Assert(t, qt.IsNil(err))
+	s := string(src)
+	qt.Check(t, qt.StringContains(s, `
+export function discriminate(x: unknown): number[] {
+	{
+		let arms: number[] | undefined;
+		if (discriminateLookup(x, "a") === discriminateAbsent) {
+			arms = discriminateIntersect(arms, [1, 2]);
+		}
+		if (discriminateLookup(x, "b") === discriminateAbsent) {
+			arms = discriminateIntersect(arms, [0, 2]);
+		}
+		if (discriminateLookup(x, "c") === discriminateAbsent) {
+			arms = discriminateIntersect(arms, [0, 1]);
+		}
+		return arms ?? [0, 1, 2];
+	}
+}
+`))
+	// Guard names are made unique.
+	qt.Check(t, qt.StringContains(s, "export function isA2(x: unknown): x is A2 {"))
+}
+
+var jsNumberStringTests = []struct {
+	f    float64
+	want string
+}{
+	{0, "0"},
+	{1, "1"},
+	{-1.5, "-1.5"},
+	{1e20, "100000000000000000000"},
+	{1e21, "1e+21"},
+	{1.5e-6, "0.0000015"},
+	{1e-7, "1e-7"},
+	{-2.5e-10, "-2.5e-10"},
+}
+
+func TestJSNumberString(t *testing.T) {
+	for _, test := range jsNumberStringTests {
+		qt.Check(t, qt.Equals(jsNumberString(test.f), test.want), qt.Commentf("%v", test.f))
+	}
+}
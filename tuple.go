@@ -0,0 +1,253 @@
+package cuediscrim
+
+import (
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+
+	"cuelang.org/go/cue"
+)
+
+const (
+	// maxTupleCandidates bounds the number of fields considered
+	// by [discriminator.tupleDiscriminator].
+	maxTupleCandidates = 8
+
+	// maxTupleFields bounds the number of fields in a tuple.
+	maxTupleFields = 3
+
+	// maxTupleBranches bounds the number of branches in a
+	// [TupleSwitchNode] unless [MaxValueBranches] is smaller.
+	maxTupleBranches = 64
+)
+
+// TupleSwitchNode chooses between arms by the combination of the
+// values of several fields, when no single field is enough to do so.
+// A value matches a branch when the field at each of Paths holds
+// the corresponding member of the branch's Values. A value that
+// matches no branch matches no arm.
+type TupleSwitchNode struct {
+	// Paths holds the paths of the fields, in tuple order.
+	Paths []string
+
+	// Branches holds the branches, ordered by their values.
+	Branches []TupleBranch
+}
+
+// TupleBranch holds one branch of a [TupleSwitchNode].
+type TupleBranch struct {
+	// Values holds one value for each of the node's Paths.
+	Values []Atom
+	Node   DecisionNode
+}
+
+func (n *TupleSwitchNode) Possible() IntSet {
+	var s IntSet = wordSet(0)
+	for _, b := range n.Branches {
+		s = union(s, b.Node.Possible())
+	}
+	return s
+}
+
+func (n *TupleSwitchNode) Check(v cue.Value) IntSet {
+	s, _ := n.check(v, checkOptions{})
+	return s
+}
+
+func (n *TupleSwitchNode) check(v cue.Value, opts checkOptions) (IntSet, bool) {
+	fields := make([]cue.Value, len(n.Paths))
+	unknown := false
+	for i, path := range n.Paths {
+		fields[i] = lookupPath(v, path)
+		unknown = unknown || opts.isUnknown(fields[i])
+	}
+	if unknown {
+		// Any branch with values that unify with the fields
+		// is possible.
+		var s IntSet = wordSet(0)
+		for _, b := range n.Branches {
+			if tupleUnifies(fields, b.Values) {
+				s1, _ := b.Node.check(v, opts)
+				s = union(s, s1)
+			}
+		}
+		return s, false
+	}
+	atoms := make([]Atom, len(fields))
+	for i, f := range fields {
+		if !f.Exists() || !isAtomKind(f.Kind()) {
+			return wordSet(0), true
+		}
+		atoms[i] = atomForValue(f)
+	}
+	for _, b := range n.Branches {
+		if slices.Equal(b.Values, atoms) {
+			return b.Node.check(v, opts)
+		}
+	}
+	return wordSet(0), true
+}
+
+// tupleUnifies reports whether each of the fields might hold
+// the corresponding value.
+func tupleUnifies(fields []cue.Value, values []Atom) bool {
+	for i, f := range fields {
+		if f.Exists() && f.Unify(f.Context().CompileString(values[i].String())).Validate() != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *TupleSwitchNode) write(w *indentWriter) {
+	paths := make([]string, len(n.Paths))
+	for i, p := range n.Paths {
+		paths[i] = w.path(p)
+	}
+	w.Printf("switch (%s) {", strings.Join(paths, ", "))
+	for _, b := range n.Branches {
+		w.Printf("case (%s):", joinAtoms(b.Values))
+		w.Indent()
+		w.node(b.Node)
+		w.Unindent()
+	}
+	w.Printf("default:")
+	w.Indent()
+	w.Printf("error")
+	w.Unindent()
+	w.Printf("}")
+}
+
+// tupleCandidate holds a field that might be part of a tuple,
+// with the constants allowed at it by each arm.
+type tupleCandidate struct {
+	path   string
+	consts [][]Atom
+}
+
+// tupleDiscriminator returns a [TupleSwitchNode] that discriminates
+// completely between the selected arms using the values of two or
+// three required fields together, or nil if there is no such node
+// within the limits above. Only fields that hold constants in every
+// selected arm are considered.
+func (d *discriminator[Set]) tupleDiscriminator(arms []cue.Value, selected Set) DecisionNode {
+	var candidates []tupleCandidate
+	for path, values := range d.allFields(arms, selected, requiredLabel) {
+		if len(candidates) >= maxTupleCandidates {
+			break
+		}
+		c := tupleCandidate{
+			path:   path,
+			consts: make([][]Atom, len(arms)),
+		}
+		ok := true
+		for i := range d.sets.values(selected) {
+			vs := d.valueSet(valueSetKey{path, requiredLabel}, values, i)
+			if vs.types != 0 || len(vs.consts) == 0 {
+				ok = false
+				break
+			}
+			c.consts[i] = slices.SortedFunc(maps.Keys(vs.consts), Atom.compare)
+		}
+		if ok {
+			candidates = append(candidates, c)
+		}
+	}
+	limit := maxTupleBranches
+	if d.maxValueBranches > 0 {
+		limit = min(limit, d.maxValueBranches)
+	}
+	for size := 2; size <= maxTupleFields; size++ {
+		for combo := range combinations(len(candidates), size) {
+			chosen := make([]tupleCandidate, len(combo))
+			for i, j := range combo {
+				chosen[i] = candidates[j]
+			}
+			if n := d.tupleSwitch(chosen, selected, limit); n != nil {
+				d.logf(1, "chose tuple of %s", strings.Join(n.Paths, ", "))
+				return n
+			}
+		}
+	}
+	return nil
+}
+
+// tupleSwitch returns a node that switches on the given candidate
+// fields, or nil if some combination of values is allowed by more than
+// one arm or the node would need more than limit branches.
+func (d *discriminator[Set]) tupleSwitch(candidates []tupleCandidate, selected Set, limit int) *TupleSwitchNode {
+	n := &TupleSwitchNode{
+		Paths: make([]string, len(candidates)),
+	}
+	for i, c := range candidates {
+		n.Paths[i] = c.path
+	}
+	seen := make(map[string]bool)
+	for i := range d.sets.values(selected) {
+		for values := range tupleProduct(candidates, i) {
+			key := joinAtoms(values)
+			if seen[key] {
+				d.logf(2, "tuple (%s) is allowed by more than one arm", key)
+				return nil
+			}
+			seen[key] = true
+			if len(n.Branches) >= limit {
+				d.logf(2, "tuple switch on %s would need more than %d branches", strings.Join(n.Paths, ", "), limit)
+				return nil
+			}
+			n.Branches = append(n.Branches, TupleBranch{
+				Values: values,
+				Node:   d.newLeaf(d.sets.of(i)),
+			})
+		}
+	}
+	slices.SortFunc(n.Branches, func(b0, b1 TupleBranch) int {
+		return slices.CompareFunc(b0.Values, b1.Values, Atom.compare)
+	})
+	return n
+}
+
+// tupleProduct yields every combination of the constants allowed
+// by arm i at the candidate fields.
+func tupleProduct(candidates []tupleCandidate, i int) iter.Seq[[]Atom] {
+	return func(yield func([]Atom) bool) {
+		values := make([]Atom, len(candidates))
+		var product func(j int) bool
+		product = func(j int) bool {
+			if j == len(candidates) {
+				return yield(slices.Clone(values))
+			}
+			for _, a := range candidates[j].consts[i] {
+				values[j] = a
+				if !product(j + 1) {
+					return false
+				}
+			}
+			return true
+		}
+		product(0)
+	}
+}
+
+// combinations yields each ascending sequence of k distinct
+// integers in [0, n).
+func combinations(n, k int) iter.Seq[[]int] {
+	return func(yield func([]int) bool) {
+		combo := make([]int, k)
+		var choose func(i, start int) bool
+		choose = func(i, start int) bool {
+			if i == k {
+				return yield(slices.Clone(combo))
+			}
+			for j := start; j < n; j++ {
+				combo[i] = j
+				if !choose(i+1, j+1) {
+					return false
+				}
+			}
+			return true
+		}
+		choose(0, 0)
+	}
+}
@@ -27,6 +27,13 @@ func valueSetForValue(v cue.Value) valueSet {
 		}
 	}
 	op, args := v.Expr()
+	if op != cue.OrOp {
+		// The disjunction might be embedded in a struct alongside
+		// other declarations, as in {"a" | "b", #doc: "x"}, or hidden
+		// behind a reference to such a struct. Evaluating
+		// the value exposes it.
+		op, args = v.Eval().Expr()
+	}
 	if op != cue.OrOp {
 		return valueSet{
 			types: v.IncompleteKind(),
@@ -220,7 +227,7 @@ func (s Atom) kind() cue.Kind {
 		return cue.StringKind
 	case '\'':
 		return cue.BytesKind
-	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '.':
+	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '.':
 		return cue.NumberKind
 	case 'n':
 		return cue.NullKind
@@ -234,6 +241,11 @@ func atomForValue(v cue.Value) Atom {
 	if !isAtomKind(v.IncompleteKind()) || v.Validate(cue.Concrete(true)) != nil {
 		return Atom{}
 	}
+	if _, ok := v.Default(); ok {
+		// A value with a default, such as *"a" | "b", validates
+		// as concrete but can still take on other values.
+		return Atom{}
+	}
 	// TODO it's probably not guaranteed that the value is actually canonical.
 	// For example, a string might be represented differently depending
 	// on its representation in the original source. We should make
@@ -123,6 +123,13 @@ var valueSetForValueTests = []struct {
 			consts: atoms(`"one"`, `"two"`),
 		},
 	},
+	{
+		name: "disjunction embedded in struct",
+		cue:  `{"a" | "b", #doc: "x", extra?: int}`,
+		want: valueSet{
+			consts: atoms(`"a"`, `"b"`),
+		},
+	},
 	{
 		name: "bottom",
 		cue:  `_|_`,
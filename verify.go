@@ -0,0 +1,651 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"iter"
+	"maps"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+)
+
+// maxVerifyCombinations bounds the number of combinations of
+// values that [Verify] tries against a [TupleSwitchNode].
+const maxVerifyCombinations = 1024
+
+// VerifyReport holds the problems found by [Verify].
+type VerifyReport struct {
+	// Unknown holds the arms chosen by leaves of the tree
+	// that are not indexes of arms, in ascending order.
+	Unknown []int
+
+	// Unreachable holds the arms that no value is classified
+	// as by the tree, in ascending order.
+	Unreachable []int
+
+	// Misrouted holds the routes by which values of an arm
+	// reach a leaf that doesn't choose the arm or are
+	// rejected by the tree.
+	Misrouted []VerifyRoute
+
+	// Inconsistent holds the routes to leaves that choose
+	// an arm whose values can't take the route.
+	Inconsistent []VerifyRoute
+}
+
+// String returns a line for each problem in the report.
+func (r VerifyReport) String() string {
+	var buf strings.Builder
+	for _, arm := range r.Unknown {
+		fmt.Fprintf(&buf, "unknown arm %d\n", arm)
+	}
+	for _, arm := range r.Unreachable {
+		fmt.Fprintf(&buf, "unreachable arm %d\n", arm)
+	}
+	for _, route := range r.Misrouted {
+		fmt.Fprintf(&buf, "misrouted %v\n", route)
+	}
+	for _, route := range r.Inconsistent {
+		fmt.Fprintf(&buf, "inconsistent %v\n", route)
+	}
+	return buf.String()
+}
+
+// VerifyRoute holds a route through a decision tree.
+type VerifyRoute struct {
+	// Arm holds the index of the arm.
+	Arm int
+
+	// Route holds the conditions met on the way to the end
+	// of the route, outermost first, such as kind(.) == struct,
+	// type == "a" or absent(b).
+	Route []string
+
+	// Chosen holds the arms chosen at the end of the route,
+	// in ascending order. It is empty when values that take
+	// the route are rejected.
+	Chosen []int
+}
+
+func (r VerifyRoute) String() string {
+	route := "at the root"
+	if len(r.Route) > 0 {
+		route = "when " + strings.Join(r.Route, ", ")
+	}
+	if len(r.Chosen) == 0 {
+		return fmt.Sprintf("arm %d %s: rejected", r.Arm, route)
+	}
+	return fmt.Sprintf("arm %d %s: chooses %v", r.Arm, route, r.Chosen)
+}
+
+// Verify checks that the decision tree n, which might have been
+// stored or edited by hand, is still sound for the given arms, which
+// might have changed since the tree was made: that every leaf
+// chooses arms whose values can reach it, that every arm is chosen by
+// some leaf that its values can reach, and that no value of an arm
+// is rejected or reaches a leaf that doesn't choose the arm. It
+// reports whether no problems were found. A leaf that chooses
+// several arms, as in a tree that isn't perfect, is sound for
+// each of them.
+//
+// The arms are taken to allow any value for a field that they don't
+// declare unless they are closed, so a tree made with [ClosedWorld]
+// might not pass. Like [Prove], Verify errs on the side of reporting
+// problems where the constraints of an arm are too complex to
+// analyze, such as bounds that aren't simple numbers.
+func Verify(n DecisionNode, arms []cue.Value) (ok bool, report VerifyReport) {
+	for _, arm := range sortedInts(n.Possible()) {
+		if arm < 0 || arm >= len(arms) {
+			report.Unknown = append(report.Unknown, arm)
+		}
+	}
+	for i, v := range arms {
+		vr := &verifier{
+			arm:    i,
+			report: &report,
+		}
+		vr.node(n, v, nil, true)
+		if !vr.reached {
+			report.Unreachable = append(report.Unreachable, i)
+		}
+	}
+	ok = len(report.Unknown) == 0 &&
+		len(report.Unreachable) == 0 &&
+		len(report.Misrouted) == 0 &&
+		len(report.Inconsistent) == 0
+	return ok, report
+}
+
+// verifier walks a decision tree on behalf of [Verify],
+// following the branches that the values of one arm can take.
+type verifier struct {
+	arm     int
+	report  *VerifyReport
+	reached bool
+
+	// minLen holds the length that the list at each path
+	// is known to have at least on the current route.
+	minLen map[string]int
+}
+
+// withMinLen calls f with the lengths in need added to vr.minLen.
+func (vr *verifier) withMinLen(need map[string]int, f func()) {
+	old := vr.minLen
+	vr.minLen = withMinLen(old, need)
+	defer func() {
+		vr.minLen = old
+	}()
+	f()
+}
+
+// requiresField is like the requiresField function but
+// takes account of the list lengths known on the current route.
+func (vr *verifier) requiresField(v cue.Value, path string) bool {
+	return requiresField(v, path, vr.minLen)
+}
+
+// node walks n for the values of v, an arm refined by the
+// conditions in route, which have been met by the values that
+// reach n. The whole tree is walked, whether or not any value of the
+// arm can take the route, as reported by possible, so that the
+// leaves that choose the arm but can't be reached by it are found.
+func (vr *verifier) node(n DecisionNode, v cue.Value, route []string, possible bool) {
+	switch n := n.(type) {
+	case nil, ErrorNode, *ErrorNode:
+		if possible {
+			vr.misrouted(route, nil)
+		}
+	case *LeafNode:
+		switch {
+		case possible && n.Arms.Has(vr.arm):
+			vr.reached = true
+		case possible:
+			vr.misrouted(route, n.Arms)
+		case n.Arms.Has(vr.arm):
+			vr.report.Inconsistent = append(vr.report.Inconsistent, VerifyRoute{
+				Arm:    vr.arm,
+				Route:  route,
+				Chosen: sortedInts(n.Arms),
+			})
+		}
+	case *KindSwitchNode:
+		kinds := fieldKinds(v, n.Path)
+		var covered cue.Kind
+		for _, k := range slices.Sorted(maps.Keys(n.Branches)) {
+			covered |= k
+			cond := fmt.Sprintf("kind(%s) == %v", n.Path, k)
+			vr.node(n.Branches[k], v, with(route, cond), possible && k&kinds != 0)
+		}
+		if !possible {
+			break
+		}
+		if k := kinds &^ covered; k != 0 {
+			vr.misrouted(with(route, fmt.Sprintf("kind(%s) == %v", n.Path, k)), nil)
+		}
+		if !vr.requiresField(v, n.Path) {
+			vr.misrouted(with(route, fmt.Sprintf("absent(%s)", n.Path)), nil)
+		}
+	case *ValueSwitchNode:
+		for _, a := range slices.SortedFunc(maps.Keys(n.Branches), Atom.compare) {
+			v1, ok := fillAtom(v, n.Path, a)
+			cond := fmt.Sprintf("%s == %v", n.Path, a)
+			vr.node(n.Branches[a], v1, with(route, cond), possible && ok)
+		}
+		dflt := !vr.requiresField(v, n.Path) || !valuesCovered(declaredField(v, n.Path), n.Branches)
+		vr.node(n.Default, v, with(route, n.Path+" == default"), possible && dflt)
+	case *RangeSwitchNode:
+		f := declaredField(v, n.Path)
+		anyValue := !f.Exists() && mightHaveField(v, n.Path)
+		ivs := numberIntervals(f)
+		var branches []Interval
+		for _, b := range n.Branches {
+			branches = append(branches, b.Interval)
+			cond := fmt.Sprintf("%s in %v", n.Path, b.Interval)
+			vr.node(b.Node, v, with(route, cond), possible && (anyValue || overlaps(ivs, b.Interval)))
+		}
+		dflt := anyValue || !vr.requiresField(v, n.Path) ||
+			f.IncompleteKind()&^cue.NumberKind != 0 || !coveredBy(ivs, branches)
+		vr.node(n.Default, v, with(route, n.Path+" == default"), possible && dflt)
+	case *LenSwitchNode:
+		f := declaredField(v, n.Path)
+		anyValue := !f.Exists() && mightHaveField(v, n.Path)
+		ivs := lengthIntervals(f)
+		var branches []Interval
+		for _, b := range n.Branches {
+			branches = append(branches, b.Interval)
+			cond := fmt.Sprintf("len(%s) in %v", n.Path, b.Interval)
+			vr.withMinLen(map[string]int{n.Path: minLength(b.Interval)}, func() {
+				vr.node(b.Node, v, with(route, cond), possible && (anyValue || overlaps(ivs, b.Interval)))
+			})
+		}
+		dflt := anyValue || !vr.requiresField(v, n.Path) ||
+			f.IncompleteKind()&^cue.ListKind != 0 || !coveredBy(ivs, branches)
+		need := map[string]int{n.Path: defaultMinLength(branches)}
+		if f.IncompleteKind() != cue.ListKind {
+			// The value might not be a list at all.
+			need = nil
+		}
+		vr.withMinLen(need, func() {
+			vr.node(n.Default, v, with(route, fmt.Sprintf("len(%s) == default", n.Path)), possible && dflt)
+		})
+	case *RegexSwitchNode:
+		vr.regexSwitch(n, v, route, possible)
+	case *TupleSwitchNode:
+		vr.tupleSwitch(n, v, route, possible)
+	case *FieldPresenceNode:
+		// The branches are tried in order, so once a field
+		// is required, no later branch can be taken.
+		required := false
+		var paths []string
+		for _, b := range n.Branches {
+			paths = append(paths, b.Path)
+			cond := fmt.Sprintf("present(%s)", b.Path)
+			vr.withMinLen(listLens(b.Path), func() {
+				vr.node(b.Node, presentField(v, b.Path), with(route, cond), possible && !required && mightHaveField(v, b.Path))
+			})
+			required = required || vr.requiresField(v, b.Path)
+		}
+		cond := fmt.Sprintf("absent(%s)", strings.Join(paths, ", "))
+		vr.node(n.Default, v, with(route, cond), possible && !required)
+	case *FieldAbsenceNode:
+		if possible {
+			vr.fieldAbsence(n, v, route)
+		}
+	case *OptionalNode:
+		vr.node(n.Present, v, with(route, "present(.)"), possible)
+	case *GroupNode:
+		vr.node(n.Select, v, route, possible)
+	default:
+		if possible && n.Possible().Has(vr.arm) {
+			vr.reached = true
+		}
+	}
+}
+
+// regexSwitch walks n as for [verifier.node].
+func (vr *verifier) regexSwitch(n *RegexSwitchNode, v cue.Value, route []string, possible bool) {
+	f := declaredField(v, n.Path)
+	anyValue := !f.Exists() && mightHaveField(v, n.Path)
+	concrete := f.Exists() && f.Kind() == cue.StringKind
+	str, _ := f.String()
+	patterns := stringPatterns(f)
+	// matched holds whether a concrete string has
+	// been matched by an earlier branch.
+	matched := false
+	for _, b := range n.Branches {
+		ok := anyValue
+		switch {
+		case concrete:
+			re := compileRegexp(b.Pattern)
+			ok = !matched && re != nil && re.MatchString(str)
+			matched = matched || ok
+		case !anyValue:
+			ok = slices.ContainsFunc(patterns, func(p string) bool {
+				return patternsMightOverlap(p, b.Pattern)
+			})
+		}
+		cond := fmt.Sprintf("%s =~ %q", n.Path, b.Pattern)
+		vr.node(b.Node, v, with(route, cond), possible && ok)
+	}
+	dflt := anyValue || !vr.requiresField(v, n.Path) || f.IncompleteKind()&^cue.StringKind != 0
+	switch {
+	case concrete:
+		dflt = dflt || !matched
+	case !dflt:
+		// A string matched by one of the patterns of the field
+		// is matched by the branch with the same pattern, if
+		// not by an earlier one.
+		dflt = slices.ContainsFunc(patterns, func(p string) bool {
+			return !slices.ContainsFunc(n.Branches, func(b RegexBranch) bool {
+				return b.Pattern == p
+			})
+		})
+	}
+	vr.node(n.Default, v, with(route, fmt.Sprintf("regexp(%s) == default", n.Path)), possible && dflt)
+}
+
+// tupleSwitch walks n as for [verifier.node].
+func (vr *verifier) tupleSwitch(n *TupleSwitchNode, v cue.Value, route []string, possible bool) {
+	paths := strings.Join(n.Paths, ", ")
+	branches := make(map[string]bool)
+	for _, b := range n.Branches {
+		values := joinAtoms(b.Values)
+		branches[values] = true
+		v1, ok := fillAtoms(v, n.Paths, b.Values)
+		cond := fmt.Sprintf("(%s) == (%s)", paths, values)
+		vr.node(b.Node, v1, with(route, cond), possible && ok)
+	}
+	if !possible {
+		return
+	}
+	// Values that lack one of the fields, or that hold a
+	// combination of values without a branch, are rejected.
+	consts := make([][]Atom, len(n.Paths))
+	enumerable := true
+	for i, path := range n.Paths {
+		if !vr.requiresField(v, path) {
+			vr.misrouted(with(route, fmt.Sprintf("absent(%s)", path)), nil)
+		}
+		f := declaredField(v, path)
+		s := valueSetForValue(f)
+		if s.types == cue.BoolKind {
+			s.consts = mapSet[Atom]{{"true"}: true, {"false"}: true}
+			s.types = 0
+		}
+		if !f.Exists() || s.types != 0 {
+			enumerable = false
+			continue
+		}
+		consts[i] = slices.SortedFunc(maps.Keys(s.consts), Atom.compare)
+	}
+	if !enumerable {
+		vr.misrouted(with(route, fmt.Sprintf("(%s) == default", paths)), nil)
+		return
+	}
+	count := 0
+	for values := range atomProduct(consts) {
+		if count++; count > maxVerifyCombinations {
+			return
+		}
+		if branches[joinAtoms(values)] {
+			continue
+		}
+		if _, ok := fillAtoms(v, n.Paths, values); ok {
+			vr.misrouted(with(route, fmt.Sprintf("(%s) == (%s)", paths, joinAtoms(values))), nil)
+		}
+	}
+}
+
+// fieldAbsence checks n, which values of the arm can reach by
+// route. A value that lacks a field is classified as the arms in
+// the branch for its path, so the arm must be in the branch
+// for each field that its values might lack.
+func (vr *verifier) fieldAbsence(n *FieldAbsenceNode, v cue.Value, route []string) {
+	lacks := false
+	for _, path := range slices.Sorted(maps.Keys(n.Branches)) {
+		if vr.requiresField(v, path) {
+			continue
+		}
+		lacks = true
+		if s := n.Branches[path]; s.Has(vr.arm) {
+			vr.reached = true
+		} else {
+			vr.misrouted(with(route, fmt.Sprintf("absent(%s)", path)), s)
+		}
+	}
+	if lacks {
+		return
+	}
+	// A value with all the fields could be any of the arms.
+	if s := n.Possible(); s.Has(vr.arm) {
+		vr.reached = true
+	} else {
+		paths := slices.Sorted(maps.Keys(n.Branches))
+		vr.misrouted(with(route, fmt.Sprintf("present(%s)", strings.Join(paths, ", "))), s)
+	}
+}
+
+// misrouted records that values of the arm reach the end of
+// route and are classified as the arms in chosen,
+// which might be nil.
+func (vr *verifier) misrouted(route []string, chosen IntSet) {
+	r := VerifyRoute{
+		Arm:   vr.arm,
+		Route: route,
+	}
+	if chosen != nil {
+		r.Chosen = sortedInts(chosen)
+	}
+	vr.report.Misrouted = append(vr.report.Misrouted, r)
+}
+
+// with returns route with cond appended, without
+// changing the route shared by sibling branches.
+func with(route []string, cond string) []string {
+	return append(route[:len(route):len(route)], cond)
+}
+
+// fieldKinds returns the kinds of value that a value of v
+// might hold at path.
+func fieldKinds(v cue.Value, path string) cue.Kind {
+	if !mightHaveField(v, path) {
+		return 0
+	}
+	f := declaredField(v, path)
+	if !f.Exists() {
+		return cue.TopKind
+	}
+	if k := f.IncompleteKind(); k&cue.NumberKind != 0 && atomForValue(f).isValid() {
+		// A number such as 1 might be written as 1.0.
+		return k | cue.NumberKind
+	}
+	return unknownKind(f)
+}
+
+// mightHaveField reports whether a value of v might have a field
+// at path: that is, whether v neither forbids the field (see
+// [forbidsField]) nor is closed without allowing it (see
+// [closedForbidsField]).
+func mightHaveField(v cue.Value, path string) bool {
+	return !forbidsField(v, path) && !closedForbidsField(v, path)
+}
+
+// requiresField reports whether every value of v has a field at
+// path: that is, whether each element of the path is a required or
+// regular field of the struct above it, or an element of a list
+// that the list requires, as it does when the list can't be too
+// short to have it. minLen holds the length that the list at each
+// path is otherwise known to have at least.
+func requiresField(v cue.Value, path string, minLen map[string]int) bool {
+	prefix := "."
+	for _, name := range splitPath(path) {
+		list := prefix
+		prefix = pathConcat(prefix, name)
+		if isIndex(name) {
+			i, err := strconv.Atoi(strings.Trim(name, "[]"))
+			if err != nil {
+				return false
+			}
+			short := Interval{
+				Max: Bound{Value: strconv.Itoa(i), Inclusive: true},
+			}
+			if minLen[list] <= i && allowsLength(v, short) {
+				return false
+			}
+			if v = declaredField(v, name); !v.Exists() {
+				return false
+			}
+			continue
+		}
+		found := false
+		for label, f := range structFields(v, requiredLabel|regularLabel) {
+			if label.name == name {
+				v, found = f, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// declaredField is like [lookupPath] but also finds the optional
+// and required fields declared by v, and the element type of a list
+// beyond its fixed prefix, as [listElement] does.
+func declaredField(v cue.Value, path string) cue.Value {
+	for _, name := range splitPath(path) {
+		if isIndex(name) {
+			i, err := strconv.Atoi(strings.Trim(name, "[]"))
+			if err != nil {
+				return cue.Value{}
+			}
+			v = listElement(v, i)
+			continue
+		}
+		f, ok := field(v, name)
+		if !ok {
+			return cue.Value{}
+		}
+		v = f
+	}
+	return v
+}
+
+// fillAtom returns v with the field at path set to a. It reports
+// false if no value of v can hold a there.
+func fillAtom(v cue.Value, path string, a Atom) (cue.Value, bool) {
+	x := v.Context().CompileString(a.String())
+	p, err := CUEPath(path)
+	if err != nil {
+		// A hidden field can't be filled, so
+		// check its value without refining v.
+		f := declaredField(v, path)
+		return v, !f.Exists() || f.Unify(x).Validate() == nil
+	}
+	if f := declaredField(v, path); f.Exists() && f.Unify(x).Validate() != nil {
+		return v, false
+	}
+	v1 := v.FillPath(p, x)
+	if v1.Validate() != nil {
+		return v, false
+	}
+	return v1, true
+}
+
+// presentField returns v refined so that the field at path,
+// if it might be present, is present, as it is for the values
+// that take a branch of a [FieldPresenceNode].
+func presentField(v cue.Value, path string) cue.Value {
+	p, err := CUEPath(path)
+	if err != nil || !mightHaveField(v, path) {
+		return v
+	}
+	f := declaredField(v, path)
+	if !f.Exists() {
+		f = v.Context().CompileString("_")
+	}
+	if v1 := v.FillPath(p, f); v1.Validate() == nil {
+		return v1
+	}
+	return v
+}
+
+// defaultMinLength returns the smallest length that is in none
+// of the given intervals, so that the list in a value that takes
+// the default of a [LenSwitchNode] with those branches, if it is
+// a list, has at least that many elements.
+func defaultMinLength(branches []Interval) int {
+	n := 0
+	for {
+		i := slices.IndexFunc(branches, func(iv Interval) bool {
+			return iv.Contains(big.NewRat(int64(n), 1))
+		})
+		if i < 0 {
+			return n
+		}
+		hi := branches[i].Max.rat()
+		if hi == nil {
+			// No list takes the default.
+			return n
+		}
+		// Skip to the first length above the interval.
+		if !hi.IsInt() || branches[i].Max.Inclusive {
+			hi = floorRat(hi).Add(floorRat(hi), big.NewRat(1, 1))
+		}
+		n = max(n+1, int(hi.Num().Int64()))
+	}
+}
+
+// fillAtoms is like [fillAtom] for the fields at several paths.
+func fillAtoms(v cue.Value, paths []string, values []Atom) (cue.Value, bool) {
+	for i, path := range paths {
+		var ok bool
+		if v, ok = fillAtom(v, path, values[i]); !ok {
+			return v, false
+		}
+	}
+	return v, true
+}
+
+// valuesCovered reports whether every value that f allows
+// has a branch of its own.
+func valuesCovered(f cue.Value, branches map[Atom]DecisionNode) bool {
+	if !f.Exists() {
+		return false
+	}
+	s := valueSetForValue(f)
+	types := s.types
+	if mapHasKey(branches, Atom{"true"}) && mapHasKey(branches, Atom{"false"}) {
+		types &^= cue.BoolKind
+	}
+	if mapHasKey(branches, Atom{"null"}) {
+		types &^= cue.NullKind
+	}
+	if types != 0 {
+		return false
+	}
+	for a := range s.consts {
+		if !mapHasKey(branches, a) {
+			return false
+		}
+	}
+	return true
+}
+
+// overlaps reports whether any of ivs has numbers in common with iv.
+func overlaps(ivs []Interval, iv Interval) bool {
+	return slices.ContainsFunc(ivs, func(iv1 Interval) bool {
+		return !iv1.intersect(iv).isEmpty()
+	})
+}
+
+// coveredBy reports whether every number in ivs is in
+// one of branches, which are disjoint and in ascending order.
+func coveredBy(ivs, branches []Interval) bool {
+	for _, iv := range ivs {
+		if !iv.coveredBy(branches) {
+			return false
+		}
+	}
+	return true
+}
+
+// patternsMightOverlap is like [patternsOverlap] but allows
+// patterns without anchored prefixes, which might overlap
+// with anything.
+func patternsMightOverlap(p0, p1 string) bool {
+	if _, ok := anchoredPrefix(p0); !ok {
+		return true
+	}
+	if _, ok := anchoredPrefix(p1); !ok {
+		return true
+	}
+	return patternsOverlap(p0, p1)
+}
+
+// atomProduct yields each combination of one
+// atom from each of the sets, in order.
+func atomProduct(sets [][]Atom) iter.Seq[[]Atom] {
+	return func(yield func([]Atom) bool) {
+		values := make([]Atom, len(sets))
+		var gen func(i int) bool
+		gen = func(i int) bool {
+			if i == len(sets) {
+				return yield(slices.Clone(values))
+			}
+			for _, a := range sets[i] {
+				values[i] = a
+				if !gen(i + 1) {
+					return false
+				}
+			}
+			return true
+		}
+		gen(0)
+	}
+}
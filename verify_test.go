@@ -0,0 +1,183 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var verifyTests = []struct {
+	testName string
+	// tree holds the arms that the tree is made from.
+	tree string
+	// arms holds the arms that the tree is verified against.
+	arms string
+	want string
+}{{
+	testName: "Unchanged",
+	tree:     `{type!: "a", x!: int} | {type!: "b", y!: string}`,
+	arms:     `{type!: "a", x!: int} | {type!: "b", y!: string}`,
+}, {
+	testName: "UnrelatedChange",
+	tree:     `{type!: "a", x!: int} | {type!: "b", y!: string}`,
+	arms:     `{type!: "a", x!: string, z?: bool} | {type!: "b"}`,
+}, {
+	testName: "ChangedValue",
+	tree:     `{type!: "a", x!: int} | {type!: "b", y!: string}`,
+	arms:     `{type!: "a", x!: int} | {type!: "c", y!: string}`,
+	want: `
+unreachable arm 1
+misrouted arm 1 when type == default: rejected
+inconsistent arm 1 when type == "b": chooses [1]
+`,
+}, {
+	testName: "WidenedValue",
+	tree:     `{type!: "a", x!: int} | {type!: "b", y!: string}`,
+	arms:     `{type!: "a", x!: int} | {type!: "a" | "b", y!: string}`,
+	want: `
+misrouted arm 1 when type == "a": chooses [0]
+`,
+}, {
+	testName: "AddedArm",
+	tree:     `{type!: "a"} | {type!: "b"}`,
+	arms:     `{type!: "a"} | {type!: "b"} | {type!: "c"}`,
+	want: `
+unreachable arm 2
+misrouted arm 2 when type == default: rejected
+`,
+}, {
+	testName: "RemovedArm",
+	tree:     `{type!: "a"} | {type!: "b"} | {type!: "c"}`,
+	arms:     `{type!: "a"} | {type!: "c"}`,
+	want: `
+unknown arm 2
+unreachable arm 1
+misrouted arm 1 when type == "c": chooses [2]
+inconsistent arm 1 when type == "b": chooses [1]
+`,
+}, {
+	testName: "OptionalTag",
+	tree:     `{type!: "a"} | {type!: "b"}`,
+	arms:     `{type!: "a"} | {type?: "b"}`,
+	want: `
+misrouted arm 1 when type == default: rejected
+`,
+}, {
+	testName: "ChangedKind",
+	tree:     `string | int`,
+	arms:     `string | float`,
+	want: `
+unreachable arm 1
+misrouted arm 1 when kind(.) == float: rejected
+inconsistent arm 1 when kind(.) == int: chooses [1]
+`,
+}, {
+	testName: "Presence",
+	tree:     `close({a!: int}) | close({b!: int})`,
+	arms:     `close({a!: int}) | close({b!: int, a?: string})`,
+	want: `
+misrouted arm 1 when present(a): chooses [0]
+`,
+}, {
+	testName: "Range",
+	tree:     `{n!: >0} | {n!: <=0}`,
+	arms:     `{n!: >0} | {n!: <=1}`,
+	want: `
+misrouted arm 1 when n in >0: chooses [0]
+`,
+}, {
+	testName: "Tuple",
+	tree:     `{a!: "foo", b!: true} | {a!: "foo", b!: false} | {a!: "bar", b!: true}`,
+	arms:     `{a!: "foo", b!: true} | {a!: "foo", b!: false} | {a!: "bar", b!: bool}`,
+	want: `
+misrouted arm 2 when (a, b) == ("bar", false): rejected
+`,
+}}
+
+func TestVerify(t *testing.T) {
+	for _, test := range verifyTests {
+		t.Run(test.testName, func(t *testing.T) {
+			ctx := cuecontext.New()
+			val := ctx.CompileString(test.tree)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			tree, _, _ := Discriminate(Disjunctions(val))
+
+			val = ctx.CompileString(test.arms)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			ok, report := Verify(tree, Disjunctions(val))
+			qt.Check(t, qt.Equals(report.String(), strings.TrimPrefix(test.want, "\n")), qt.Commentf("tree:\n%s", NodeString(tree)))
+			qt.Check(t, qt.Equals(ok, test.want == ""))
+		})
+	}
+}
+
+func TestVerifyGeneratedTrees(t *testing.T) {
+	// A tree made from the arms is sound for them.
+	for _, test := range buildDecisionTreeTests {
+		if !test.wantPerfect {
+			continue
+		}
+		t.Run(test.testName, func(t *testing.T) {
+			val := cuecontext.New().CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(val.Err()))
+			arms := Disjunctions(val)
+			tree, _, _ := Discriminate(arms)
+			ok, report := Verify(tree, arms)
+			qt.Check(t, qt.IsTrue(ok), qt.Commentf("%s", report))
+		})
+	}
+}
+
+func TestVerifyEditedTree(t *testing.T) {
+	val := cuecontext.New().CompileString(`{type!: "a"} | {type!: "b"}`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	// The leaves for "a" and "b" have been swapped.
+	tree := &ValueSwitchNode{
+		Path: "type",
+		Branches: map[Atom]DecisionNode{
+			{"\"a\""}: &LeafNode{Arms: setOf(1)},
+			{"\"b\""}: &LeafNode{Arms: setOf(0)},
+		},
+		Default: ErrorNode{},
+	}
+	ok, report := Verify(tree, Disjunctions(val))
+	qt.Check(t, qt.IsFalse(ok))
+	qt.Check(t, qt.Equals(report.String(), `
+unreachable arm 0
+unreachable arm 1
+misrouted arm 0 when type == "a": chooses [1]
+misrouted arm 1 when type == "b": chooses [0]
+inconsistent arm 0 when type == "b": chooses [0]
+inconsistent arm 1 when type == "a": chooses [1]
+`[1:]))
+}
+
+func TestVerifyEmptyList(t *testing.T) {
+	val := cuecontext.New().CompileString(`[...{k!: "a"}] | [...{k!: "b"}]`)
+	qt.Assert(t, qt.IsNil(val.Err()))
+	arms := Disjunctions(val)
+	// The empty list is an instance of both arms,
+	// but has no element to switch on.
+	tree := &ValueSwitchNode{
+		Path: "[0].k",
+		Branches: map[Atom]DecisionNode{
+			{`"a"`}: &LeafNode{Arms: setOf(0)},
+			{`"b"`}: &LeafNode{Arms: setOf(1)},
+		},
+		Default: ErrorNode{},
+	}
+	ok, report := Verify(tree, arms)
+	qt.Check(t, qt.IsFalse(ok))
+	qt.Check(t, qt.Equals(report.String(), `
+misrouted arm 0 when [0].k == default: rejected
+misrouted arm 1 when [0].k == default: rejected
+`[1:]))
+
+	// The tree made from the arms switches
+	// on the length of the list first.
+	tree1, _, _ := Discriminate(arms)
+	ok, report = Verify(tree1, arms)
+	qt.Check(t, qt.IsTrue(ok), qt.Commentf("%s", report))
+}
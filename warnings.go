@@ -0,0 +1,266 @@
+package cuediscrim
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"cuelang.org/go/cue"
+)
+
+// Warning describes a way in which a decision tree may not
+// behave as the author of a schema expects, even when
+// it is perfect.
+type Warning struct {
+	// Path holds the path of the value concerned.
+	Path string
+
+	// Message describes the problem.
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Path, w.Message)
+}
+
+// Warnings returns any warnings about the decision tree n,
+// ordered by path.
+//
+// A tree that makes decisions based on the first element of a
+// list, for example switching on [0].kind to discriminate between
+// [...#EventA] and [...#EventB], first switches on the length of the
+// list, because an empty list has no first element. When several
+// arms allow the list to be empty, as here, the empty list chooses
+// all of them, and there is a warning for the length 0 as for any
+// other overlap of lengths (see below).
+//
+// A tree that switches on an optional field (see
+// [ValueSwitchNode.Optional] and [OptionalFields])
+// cannot discriminate a value without that field when several arms
+// allow it to be absent. Making the field required avoids the problem.
+//
+// A tree that switches on a field whose constants are shared by
+// several arms, as with {type!: "a" | "legacy"} | {type!: "b" |
+// "legacy"}, chooses all of those arms for each shared constant.
+// There is a warning listing the shared constants for each set
+// of arms that share them.
+//
+// Likewise, a switch on a field that some arms allow to be any
+// string, as with {type!: "a"} | {type!: string}, chooses those
+// arms along with the others for each constant. There is a warning
+// that the tag accepts arbitrary strings in those arms, so that
+// it's clear that the union is imperfect because of them.
+// Conversely, when every value that the arms allow has its own
+// case, as with true and false, the default of the switch is an
+// error.
+//
+// Similarly, a switch on the length of a list (see [LenSwitchNode])
+// whose arms allow overlapping lengths, as with [int] | [int, ...int],
+// chooses all of those arms for a list with a length in the overlap.
+// There is a warning giving the lengths for each such overlap.
+func Warnings(n DecisionNode) []Warning {
+	var warnings []Warning
+	for path := range optionalSwitchPaths(n) {
+		warnings = append(warnings, Warning{
+			Path:    path,
+			Message: "discriminating field is optional, so a value without it is not discriminated; consider making it required",
+		})
+	}
+	warnings = append(warnings, sharedValueWarnings(n)...)
+	slices.SortStableFunc(warnings, func(w0, w1 Warning) int {
+		return strings.Compare(w0.Path, w1.Path)
+	})
+	return warnings
+}
+
+// sharedValueWarnings returns a warning for each set of arms
+// chosen together by values of a [ValueSwitchNode] in n,
+// listing the values, and for each interval of lengths of
+// a [LenSwitchNode] in n that chooses several arms.
+func sharedValueWarnings(n DecisionNode) []Warning {
+	var warnings []Warning
+	var walk func(n DecisionNode)
+	walk = func(n DecisionNode) {
+		switch n := n.(type) {
+		case *KindSwitchNode:
+			for _, k := range slices.Sorted(maps.Keys(n.Branches)) {
+				walk(n.Branches[k])
+			}
+		case *ValueSwitchNode:
+			var arms []IntSet
+			byArms := make(map[string][]string)
+			for _, val := range slices.SortedFunc(maps.Keys(n.Branches), Atom.compare) {
+				sub := n.Branches[val]
+				leaf, ok := sub.(*LeafNode)
+				if !ok || leaf.Arms.Len() < 2 {
+					walk(sub)
+					continue
+				}
+				key := SetString(leaf.Arms)
+				if _, ok := byArms[key]; !ok {
+					arms = append(arms, leaf.Arms)
+				}
+				byArms[key] = append(byArms[key], val.String())
+			}
+			for _, s := range arms {
+				vals := byArms[SetString(s)]
+				what := "value " + vals[0] + " is"
+				if len(vals) > 1 {
+					what = "values " + strings.Join(vals, ", ") + " are"
+				}
+				warnings = append(warnings, Warning{
+					Path:    n.Path,
+					Message: fmt.Sprintf("%s allowed by arms %s, so they are not discriminated", what, SetString(s)),
+				})
+			}
+			warnings = append(warnings, openTagWarnings(n)...)
+			walk(n.Default)
+		case *RangeSwitchNode:
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+			walk(n.Default)
+		case *LenSwitchNode:
+			for _, b := range n.Branches {
+				leaf, ok := b.Node.(*LeafNode)
+				if !ok || leaf.Arms.Len() < 2 {
+					walk(b.Node)
+					continue
+				}
+				what := "lengths " + b.Interval.String() + " are"
+				if iv := b.Interval; iv.Min.Value != "" && iv.Min == iv.Max {
+					what = "length " + iv.Min.Value + " is"
+				}
+				warnings = append(warnings, Warning{
+					Path:    n.Path,
+					Message: fmt.Sprintf("%s allowed by arms %s, so they are not discriminated", what, SetString(leaf.Arms)),
+				})
+			}
+			walk(n.Default)
+		case *RegexSwitchNode:
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+			walk(n.Default)
+		case *FieldPresenceNode:
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+			walk(n.Default)
+		case *TupleSwitchNode:
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+		case *OptionalNode:
+			walk(n.Present)
+		case *GroupNode:
+			walk(n.Select)
+		}
+	}
+	walk(n)
+	return warnings
+}
+
+// openTagWarnings returns a warning for each kind of value that
+// the default of the switch n chooses arms for by kind alone, as
+// when an arm allows any string at the path, if those arms are
+// not told apart from the others for the values of n.
+func openTagWarnings(n *ValueSwitchNode) []Warning {
+	k, ok := n.Default.(*KindSwitchNode)
+	if !ok || k.Path != n.Path {
+		return nil
+	}
+	var warnings []Warning
+	for _, kind := range slices.Sorted(maps.Keys(k.Branches)) {
+		if kind == cue.BoolKind || kind == cue.NullKind {
+			// There are too few values of these
+			// kinds to call them arbitrary.
+			continue
+		}
+		open := k.Branches[kind].Possible()
+		shared := false
+		for val, sub := range n.Branches {
+			if val.kind() != kind {
+				continue
+			}
+			arms := sub.Possible()
+			if arms.Len() > 1 && intersect(arms, open).Len() > 0 {
+				shared = true
+				break
+			}
+		}
+		if shared {
+			warnings = append(warnings, Warning{
+				Path:    n.Path,
+				Message: fmt.Sprintf("tag accepts arbitrary %ss in arms %s, so its values do not tell those arms apart from the others", kind, SetString(open)),
+			})
+		}
+	}
+	return warnings
+}
+
+// optionalSwitchPaths returns the paths of all the switches in n on
+// optional fields whose absence leaves several arms to choose from.
+func optionalSwitchPaths(n DecisionNode) mapSet[string] {
+	paths := make(mapSet[string])
+	var walk func(n DecisionNode)
+	walk = func(n DecisionNode) {
+		switch n := n.(type) {
+		case *KindSwitchNode:
+			if n.Optional {
+				paths[n.Path] = true
+			}
+			for _, sub := range n.Branches {
+				walk(sub)
+			}
+		case *ValueSwitchNode:
+			if n.Optional {
+				paths[n.Path] = true
+			}
+			for _, sub := range n.Branches {
+				walk(sub)
+			}
+			walk(n.Default)
+		case *RangeSwitchNode:
+			if n.Optional {
+				paths[n.Path] = true
+			}
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+			walk(n.Default)
+		case *LenSwitchNode:
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+			walk(n.Default)
+		case *RegexSwitchNode:
+			if n.Optional {
+				paths[n.Path] = true
+			}
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+			walk(n.Default)
+		case *FieldPresenceNode:
+			if sw := optionalSwitch(n); sw != nil && n.Default.Possible().Len() > 1 {
+				paths[switchPath(sw)] = true
+			}
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+			walk(n.Default)
+		case *TupleSwitchNode:
+			for _, b := range n.Branches {
+				walk(b.Node)
+			}
+		case *OptionalNode:
+			walk(n.Present)
+		case *GroupNode:
+			walk(n.Select)
+		}
+	}
+	walk(n)
+	return paths
+}
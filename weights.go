@@ -0,0 +1,139 @@
+package cuediscrim
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+
+	"cuelang.org/go/cue"
+)
+
+// ArmWeights gives the relative frequency of values of each arm,
+// keyed by arm index, so that a tree built for traffic in which
+// most values belong to a few arms tests the branches leading to
+// those arms first. The weight of a branch is the total weight of
+// the arms it can choose; arms not in weights have weight zero.
+//
+// The branches of a [KindSwitchNode] or [ValueSwitchNode] are
+// ordered by setting their Order field, and those of a
+// [RangeSwitchNode], [LenSwitchNode] or [TupleSwitchNode] are
+// reordered in place.
+// Branches of equal weight keep their usual order. The branches of
+// a [RegexSwitchNode] or [FieldPresenceNode] are not reordered,
+// because a value is handled by the first branch that matches it.
+// The order is reflected by [NodeString] and by generated code,
+// but makes no difference to which arms a value selects.
+func ArmWeights(weights map[int]float64) Option {
+	return func(opts *options) {
+		opts.armWeights = weights
+	}
+}
+
+// orderByWeight orders the branches of n and the nodes below it by
+// descending weight, as described for [ArmWeights].
+func orderByWeight(n DecisionNode, weights map[int]float64) {
+	weight := func(n DecisionNode) float64 {
+		var w float64
+		for i := range n.Possible().Values() {
+			w += weights[i]
+		}
+		return w
+	}
+	switch n := n.(type) {
+	case *KindSwitchNode:
+		n.Order = byWeight(slices.Sorted(maps.Keys(n.Branches)), func(k cue.Kind) float64 {
+			return weight(n.Branches[k])
+		})
+		for _, sub := range n.Branches {
+			orderByWeight(sub, weights)
+		}
+	case *ValueSwitchNode:
+		n.Order = byWeight(slices.SortedFunc(maps.Keys(n.Branches), Atom.compare), func(a Atom) float64 {
+			return weight(n.Branches[a])
+		})
+		for _, sub := range n.Branches {
+			orderByWeight(sub, weights)
+		}
+		if n.Default != nil {
+			orderByWeight(n.Default, weights)
+		}
+	case *RangeSwitchNode:
+		slices.SortStableFunc(n.Branches, func(b0, b1 RangeBranch) int {
+			return cmp.Compare(weight(b1.Node), weight(b0.Node))
+		})
+		for _, b := range n.Branches {
+			orderByWeight(b.Node, weights)
+		}
+		if n.Default != nil {
+			orderByWeight(n.Default, weights)
+		}
+	case *LenSwitchNode:
+		slices.SortStableFunc(n.Branches, func(b0, b1 RangeBranch) int {
+			return cmp.Compare(weight(b1.Node), weight(b0.Node))
+		})
+		for _, b := range n.Branches {
+			orderByWeight(b.Node, weights)
+		}
+		if n.Default != nil {
+			orderByWeight(n.Default, weights)
+		}
+	case *TupleSwitchNode:
+		slices.SortStableFunc(n.Branches, func(b0, b1 TupleBranch) int {
+			return cmp.Compare(weight(b1.Node), weight(b0.Node))
+		})
+		for _, b := range n.Branches {
+			orderByWeight(b.Node, weights)
+		}
+	case *RegexSwitchNode:
+		for _, b := range n.Branches {
+			orderByWeight(b.Node, weights)
+		}
+		if n.Default != nil {
+			orderByWeight(n.Default, weights)
+		}
+	case *FieldPresenceNode:
+		for _, b := range n.Branches {
+			orderByWeight(b.Node, weights)
+		}
+		if n.Default != nil {
+			orderByWeight(n.Default, weights)
+		}
+	case *OptionalNode:
+		orderByWeight(n.Present, weights)
+	case *GroupNode:
+		orderByWeight(n.Select, weights)
+	}
+}
+
+// byWeight returns keys, which are in their usual order, sorted
+// stably by descending weight, or nil if that makes no difference.
+func byWeight[K comparable](keys []K, weight func(K) float64) []K {
+	sorted := slices.Clone(keys)
+	slices.SortStableFunc(sorted, func(k0, k1 K) int {
+		return cmp.Compare(weight(k1), weight(k0))
+	})
+	if slices.Equal(sorted, keys) {
+		return nil
+	}
+	return sorted
+}
+
+// ordered returns the keys of m in the order given by order,
+// followed by any keys not in order, in the usual order as
+// determined by compare. Keys in order that are not in m are
+// ignored, so a node whose branches have been pruned (see
+// [Optimize]) keeps the order of those that remain.
+func ordered[K comparable, V any](m map[K]V, order []K, compare func(K, K) int) []K {
+	keys := make([]K, 0, len(m))
+	for _, k := range order {
+		if mapHasKey(m, k) && !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range slices.SortedFunc(maps.Keys(m), compare) {
+		if !slices.Contains(order, k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,102 @@
+package cuediscrim
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-quicktest/qt"
+)
+
+var armWeightsTests = []struct {
+	testName string
+	cue      string
+	weights  map[int]float64
+	want     string
+}{{
+	testName: "Kinds",
+	cue:      `int | string | {a!: int}`,
+	weights:  map[int]float64{2: 95, 1: 5},
+	want: `
+switch kind(.) {
+case struct:
+	choose({2})
+case string:
+	choose({1})
+case int:
+	choose({0})
+}
+`,
+}, {
+	testName: "Values",
+	cue:      `{type!: "a"} | {type!: "b"} | {type!: "c"}`,
+	weights:  map[int]float64{2: 10},
+	want: `
+switch type {
+case "c":
+	choose({2})
+case "a":
+	choose({0})
+case "b":
+	choose({1})
+default:
+	error
+}
+`,
+}, {
+	testName: "Nested",
+	cue:      `int | {type!: "a"} | {type!: "b"}`,
+	weights:  map[int]float64{0: 1, 2: 5},
+	want: `
+switch kind(.) {
+case struct:
+	switch type {
+	case "b":
+		choose({2})
+	case "a":
+		choose({1})
+	default:
+		error
+	}
+case int:
+	choose({0})
+}
+`,
+}, {
+	testName: "NoWeights",
+	cue:      `int | string`,
+	weights:  map[int]float64{},
+	want: `
+switch kind(.) {
+case int:
+	choose({0})
+case string:
+	choose({1})
+}
+`,
+}}
+
+func TestArmWeights(t *testing.T) {
+	ctx := cuecontext.New()
+	for _, test := range armWeightsTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v := ctx.CompileString(test.cue)
+			qt.Assert(t, qt.IsNil(v.Err()))
+			arms := Disjunctions(v)
+			tree, _, _ := Discriminate(arms, ArmWeights(test.weights))
+			qt.Assert(t, qt.Equals(NodeString(tree), strings.TrimPrefix(test.want, "\n")))
+
+			unweighted, _, _ := Discriminate(arms)
+			for _, arm := range arms {
+				qt.Check(t, deepEquals(ref(tree.Check(arm)), ref(unweighted.Check(arm))))
+			}
+
+			// The order survives encoding.
+			data, err := EncodeTree(tree)
+			qt.Assert(t, qt.IsNil(err))
+			tree1, err := DecodeTree(data)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Check(t, qt.Equals(NodeString(tree1), NodeString(tree)))
+		})
+	}
+}